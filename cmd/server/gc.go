@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// runGC removes all records last asked-by a requester and returns the exit code
+func runGC(k8sClient *k8s.Client, args []string) int {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	requester := flags.String("requester", "", "IP address of the requester whose records are removed")
+	key := flags.String("key", "", "TSIG key name of the requester whose records are removed")
+	dryRun := flags.Bool("dry-run", false, "only list the records that would be removed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	sel := k8s.RequesterSelector{Addr: *requester, KeyName: *key}
+	if sel.IsEmpty() {
+		logrus.Errorf("gc requires --requester and/or --key")
+		return 2
+	}
+
+	names, err := k8sClient.CollectByRequester(context.Background(), sel, *dryRun)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	if err != nil {
+		logrus.Errorf("Garbage collection failed: %v", err)
+		return 1
+	}
+	return 0
+}
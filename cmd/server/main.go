@@ -1,22 +1,61 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/tJouve/ddnsbridge4extdns/internal/handler"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/admin"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/audit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ban"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/diag"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnstap"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/geoip"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/health"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/kafka"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/pcap"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/proxyproto"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ratelimit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/redis"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/replica"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/retire"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/s3"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/sig0"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/snapshot"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/tcplimit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/tsig"
 )
 
+// proxyHeaderTimeout bounds the wait for the PROXY protocol header of a connection
+const proxyHeaderTimeout = 5 * time.Second
+
 func main() {
+	// Global flags come before the subcommand
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML file of configuration variables, overridden by the environment")
+	configFlags := config.RegisterFlags(flags)
+	flags.Parse(os.Args[1:])
+	args := flags.Args()
+
 	// Load configuration first
-	cfg, err := config.LoadConfig()
+	cfg, err := loadConfig(*configFile, configFlags)
 	if err != nil {
 		logrus.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -37,12 +76,57 @@ func main() {
 	logrus.Infof("Log level set to: %s", level.String())
 
 	logrus.Infof("Configuration loaded: listening on %s:%d", cfg.ListenAddr, cfg.Port)
-	logrus.Debugf("Allowed zones: %v", cfg.AllowedZones)
-	logrus.Debugf("TSIG key: %s, algorithm: %s", cfg.TSIGKey, cfg.TSIGAlgorithm)
-	logrus.Debugf("Kubernetes namespace: %s", cfg.Namespace)
+	// Echo the effective configuration, secrets replaced by their fingerprint
+	if effective, err := json.Marshal(cfg.Redacted()); err != nil {
+		logrus.Errorf("Failed to encode configuration: %v", err)
+	} else {
+		logrus.WithField("config", string(effective)).Info("Effective configuration")
+	}
+
+	// Derive the namespace of records from their hostname
+	var namespaceTemplate *k8s.NamespaceTemplate
+	if cfg.NamespaceTemplate != "" {
+		namespaceTemplate, err = k8s.ParseNamespaceTemplate(cfg.NamespaceTemplate)
+		if err != nil {
+			logrus.Fatalf("Invalid NAMESPACE_TEMPLATE: %v", err)
+		}
+		logrus.Infof("Records are written to the namespace rendered by %q", cfg.NamespaceTemplate)
+	}
+	for zone, namespace := range cfg.ZoneNamespaces {
+		logrus.Infof("Records of zone %s are written to namespace %s", zone, namespace)
+	}
 
 	// Initialize Kubernetes client
-	k8sClient, err := k8s.NewClient(cfg.Namespace, cfg.CustomLabels)
+	k8sClient, err := k8s.NewClient(k8s.Options{
+		Namespace:      cfg.KubernetesNamespace(),
+		CustomLabels:   cfg.CustomLabels,
+		DynamicRecords: cfg.DynamicRecords,
+		AutoApprove:    cfg.DynamicRecordsAutoApprove,
+
+		RecordEvents:    cfg.RecordEvents,
+		EventRetention:  cfg.RecordEventsRetention,
+		EventsPerRecord: cfg.RecordEventsPerRecord,
+
+		FirstOwnerWins: cfg.ConflictPolicy == config.ConflictFirstOwnerWins,
+		MergeTargets:   cfg.ConflictPolicy == config.ConflictMerge,
+		KeyPriorities:  cfg.KeyPriorities,
+
+		NamespaceTemplate: namespaceTemplate,
+		ZoneNamespaces:    cfg.ZoneNamespaces,
+		GroupByRequester:  cfg.GroupByRequester,
+		SubdomainNames:    cfg.ResourceNaming == config.ResourceNamingSubdomain,
+		HashedNames:       cfg.ResourceNaming == config.ResourceNamingHashSuffix,
+		ZonePrefixedNames: cfg.ResourceNaming == config.ResourceNamingZonePrefixed,
+
+		WriteInterval: cfg.WriteInterval,
+		TTLExpiry:     cfg.TTLExpiry,
+
+		RetryAttempts:   cfg.BackendRetryAttempts,
+		RetryBackoff:    cfg.BackendRetryBackoff,
+		RetryMaxBackoff: cfg.BackendRetryMaxBackoff,
+
+		EndpointCache: cfg.EndpointCache,
+	})
 	if err != nil {
 		logrus.Fatalf("Failed to initialize Kubernetes client: %v", err)
 	}
@@ -50,49 +134,388 @@ func main() {
 	if len(cfg.CustomLabels) > 0 {
 		logrus.Debugf("Custom labels configured: %v", cfg.CustomLabels)
 	}
+	if len(cfg.KeyPriorities) > 0 {
+		logrus.Infof("Key priorities configured: %v", cfg.KeyPriorities)
+	}
+
+	// Run a one-shot subcommand instead of the server
+	if len(args) > 0 && args[0] == "gc" {
+		os.Exit(runGC(k8sClient, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "transfer" {
+		os.Exit(runTransfer(k8sClient, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "snapshot" {
+		os.Exit(runSnapshot(cfg, k8sClient, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "push" {
+		os.Exit(runPush(cfg, k8sClient, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "zone" {
+		os.Exit(runZone(cfg, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "replay" {
+		os.Exit(runReplay(k8sClient, args[1:]))
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(k8sClient, cfg.AllowedZones, args[1:]))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Read the DNSEndpoints from a watch, from the API server until it is synced
+	if cfg.EndpointCache {
+		go func() {
+			if err := k8sClient.RunEndpointCache(ctx); err != nil {
+				logrus.Fatalf("DNSEndpoint cache failed: %v", err)
+			}
+		}()
+	}
+
+	// Project DynamicRecords into DNSEndpoints
+	if cfg.DynamicRecords {
+		logrus.Infof("DynamicRecord mode enabled (auto-approve: %v)", cfg.DynamicRecordsAutoApprove)
+		go func() {
+			if err := k8sClient.RunRecordController(ctx); err != nil {
+				logrus.Fatalf("DynamicRecord controller failed: %v", err)
+			}
+		}()
+	}
 
 	// Create DNS handler
-	dnsHandler := handler.NewHandler(cfg, k8sClient)
+	// Temporarily ban sources refused too often
+	var banner *ban.Banner
+	var decorateReader dns.DecorateReader
+	if cfg.BanThreshold > 0 {
+		logrus.Infof("Bans enabled (%d refusals within %s ban for %s)", cfg.BanThreshold, cfg.BanWindow, cfg.BanDuration)
+		store := ban.NewMemoryStore()
+		if cfg.RedisAddr != "" {
+			options := redis.Options{Addr: cfg.RedisAddr, Password: cfg.RedisPassword, DB: cfg.RedisDB}
+			if cfg.RedisTLS {
+				options.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+			client := redis.New(options)
+			defer client.Close()
+			logrus.Infof("Sharing bans between replicas through Redis %s (prefix: %q)", cfg.RedisAddr, cfg.RedisKeyPrefix)
+			store = ban.NewRedisStore(client, cfg.RedisKeyPrefix)
+		}
+		banner = ban.NewWithStore(cfg.BanThreshold, cfg.BanWindow, cfg.BanDuration, store)
+		decorateReader = banner.DecorateReader
+	}
+
+	dnsHandler := handler.NewHandler(cfg, k8sClient, banner)
+	var captureFile admin.CaptureFile
+
+	// Accept the zones listed in AllowedZone resources as well
+	if cfg.ZoneResources {
+		zoneList := k8sClient.NewZoneList()
+		go func() {
+			if err := zoneList.Run(ctx); err != nil {
+				logrus.Fatalf("AllowedZone watch failed: %v", err)
+			}
+		}()
+		dnsHandler.AcceptZones(zoneList)
+	}
+
+	// Record the raw messages of some clients or zones for debugging
+	if cfg.CaptureFile != "" {
+		clients, err := pcap.ParseClients(cfg.CaptureClients)
+		if err != nil {
+			logrus.Fatalf("Invalid CAPTURE_CLIENTS: %v", err)
+		}
+		capture, err := pcap.Start(pcap.Options{
+			Path:     cfg.CaptureFile,
+			Clients:  clients,
+			Zones:    cfg.CaptureZones,
+			MaxSize:  int64(cfg.CaptureMaxSize),
+			Duration: cfg.CaptureDuration,
+		})
+		if err != nil {
+			logrus.Fatalf("Failed to start wire capture: %v", err)
+		}
+		defer capture.Stop("shutdown")
+		logrus.Warnf("Wire capture to %s enabled for %s (clients: %v, zones: %v, max size: %d bytes)",
+			cfg.CaptureFile, cfg.CaptureDuration, cfg.CaptureClients, cfg.CaptureZones, cfg.CaptureMaxSize)
+		dnsHandler.SetCapture(capture)
+		decorateReader = chainReaders(decorateReader, capture.DecorateReader)
+		captureFile = capture
+	}
+
+	// Process the messages queued on a TCP connection concurrently
+	tcpQueries := 0
+	var decorateWriter dns.DecorateWriter
+	if cfg.TCPPipelineDepth > 0 {
+		logrus.Infof("TCP pipelining enabled (%d messages per connection)", cfg.TCPPipelineDepth)
+		decorateReader = chainReaders(decorateReader, dnsHandler.DecorateReader)
+		decorateWriter = dnsHandler.DecorateWriter
+		// Closing a connection after a number of queries would drop the answers in flight
+		tcpQueries = -1
+	}
+
+	// Keep the raw SIG(0) signed messages, their signature covers the message as sent
+	if len(cfg.SIG0Keys) > 0 {
+		for _, key := range cfg.SIG0Keys {
+			logrus.Infof("SIG(0) key %s (tag %d) accepted for zones %v", key.Name, key.Tag, key.Zones)
+		}
+		messages := sig0.NewMessages()
+		decorateReader = chainReaders(decorateReader, messages.DecorateReader)
+		dnsHandler.SetSIG0Messages(messages)
+	}
+
+	// Bound the TCP sessions of each source and key
+	sessions := tcplimit.New(tcplimit.Limits{
+		ConnsPerSource: cfg.TCPMaxConnsPerSource,
+		ConnsPerKey:    cfg.TCPMaxConnsPerKey,
+		InflightPerKey: cfg.TCPMaxInflightPerKey,
+	})
+	if sessions != nil {
+		logrus.Infof("TCP session limits enabled (connections per source: %d, per key: %d, in flight per key: %d)",
+			cfg.TCPMaxConnsPerSource, cfg.TCPMaxConnsPerKey, cfg.TCPMaxInflightPerKey)
+		dnsHandler.SetSessionLimiter(sessions)
+	}
+	// Bound the rate of the messages of each source
+	if rates := ratelimit.New(cfg.RateLimit, cfg.RateLimitBurst); rates != nil {
+		logrus.Infof("Rate limit enabled (%g messages per second per source, bursts of %d)", cfg.RateLimit, cfg.RateLimitBurst)
+		dnsHandler.SetRateLimiter(rates)
+	}
+	if cfg.ProxyProtocol {
+		logrus.Infof("PROXY protocol enabled on TCP listeners (trusted networks: %v)", cfg.ProxyTrustedNetworks)
+	}
+
+	// Count the updates of each tenant with the configured label dimensions
+	if len(cfg.MetricsDimensions) > 0 {
+		tenants, err := metrics.NewTenants(cfg.MetricsDimensions, cfg.MetricsSeriesBudget, prometheus.DefaultRegisterer)
+		if err != nil {
+			logrus.Fatalf("Failed to create tenant metrics: %v", err)
+		}
+		logrus.Infof("Tenant metrics enabled (dimensions: %s, series budget: %d)", strings.Join(cfg.MetricsDimensions, ","), cfg.MetricsSeriesBudget)
+		dnsHandler.SetTenantMetrics(tenants)
+	}
+
+	// Stream the accepted-update state to the standby replicas, and follow the others
+	if cfg.ReplicationListen != "" || len(cfg.ReplicationPeers) > 0 {
+		replicator := replica.New(cfg.ReplicationToken)
+		dnsHandler.SetReplicator(replicator)
+		k8sClient.ReplicateWrites(replicator)
+		if cfg.ReplicationListen != "" {
+			listener, err := net.Listen("tcp", cfg.ReplicationListen)
+			if err != nil {
+				logrus.Fatalf("Failed to listen for replication on %s: %v", cfg.ReplicationListen, err)
+			}
+			defer listener.Close()
+			go func() {
+				if err := replicator.Serve(listener); err != nil {
+					logrus.Errorf("Replication listener failed: %v", err)
+				}
+			}()
+			logrus.Infof("Streaming the replication state on %s", cfg.ReplicationListen)
+		}
+		for _, peer := range cfg.ReplicationPeers {
+			go replicator.Follow(ctx, peer)
+		}
+	}
+
+	// Decommission zones through the admin API, exporting them to the snapshot bucket
+	var exporter retire.Exporter
+	if cfg.SnapshotBucket != "" {
+		store, err := s3.New(s3.Options{
+			Endpoint:  cfg.SnapshotEndpoint,
+			Region:    cfg.SnapshotRegion,
+			Bucket:    cfg.SnapshotBucket,
+			AccessKey: cfg.SnapshotAccessKey,
+			SecretKey: cfg.SnapshotSecretKey,
+		})
+		if err != nil {
+			logrus.Errorf("Zone exports disabled, invalid snapshot bucket: %v", err)
+		} else {
+			exporter = snapshot.New(k8sClient, store, cfg.AllowedZones, cfg.ZoneOf, cfg.SnapshotPrefix, cfg.SnapshotRetention)
+		}
+	}
+	retirements := retire.New(k8sClient, exporter)
+	dnsHandler.SetRetirements(retirements)
+
+	// Log received messages and sent responses to dnstap
+	if cfg.DnstapOutput != "" {
+		identity := cfg.DnstapIdentity
+		if identity == "" {
+			identity, _ = os.Hostname()
+		}
+		output, err := dnstap.Open(cfg.DnstapOutput, identity, "ddnsbridge4extdns")
+		if err != nil {
+			logrus.Fatalf("Failed to open dnstap output: %v", err)
+		}
+		defer output.Close()
+		logrus.Infof("Dnstap enabled (output: %s, identity: %q)", cfg.DnstapOutput, identity)
+		dnsHandler.SetDnstap(output)
+	}
+
+	// Publish accepted updates to Kafka
+	if len(cfg.KafkaBrokers) > 0 {
+		kafkaConfig := kafka.Config{
+			Brokers:      cfg.KafkaBrokers,
+			Topic:        cfg.KafkaTopic,
+			Format:       cfg.KafkaFormat,
+			PartitionKey: cfg.KafkaPartitionKey,
+			ClientID:     "ddnsbridge4extdns",
+		}
+		if cfg.KafkaTLS {
+			kafkaConfig.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		producer, err := kafka.NewProducer(kafkaConfig)
+		if err != nil {
+			logrus.Fatalf("Failed to start Kafka producer: %v", err)
+		}
+		defer producer.Close()
+		logrus.Infof("Publishing update events to Kafka topic %s (format: %s, partition key: %s)", cfg.KafkaTopic, cfg.KafkaFormat, cfg.KafkaPartitionKey)
+		dnsHandler.SetEventProducer(producer)
+	}
+
+	// Keep an audit trail of the accepted updates
+	if cfg.AuditLog != "" {
+		auditLog, err := audit.Open(cfg.AuditLog, int64(cfg.AuditLogMaxSize), cfg.AuditLogMaxFiles)
+		if err != nil {
+			logrus.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		logrus.Infof("Writing the audit trail to %s (max size: %d bytes, files: %d)", cfg.AuditLog, cfg.AuditLogMaxSize, cfg.AuditLogMaxFiles)
+		dnsHandler.SetAuditLog(auditLog)
+	}
+
+	// Queue the messages failing on an unavailable API server
+	if cfg.WriteJournal != "" {
+		journal, err := k8s.OpenJournal(cfg.WriteJournal, cfg.WriteJournalMaxMessages)
+		if err != nil {
+			logrus.Fatalf("Failed to open write journal: %v", err)
+		}
+		defer journal.Close()
+		logrus.Infof("Write journal enabled (%s, max messages: %d, replay interval: %s)", cfg.WriteJournal, cfg.WriteJournalMaxMessages, cfg.WriteJournalReplayInterval)
+		dnsHandler.SetJournal(journal)
+		go k8sClient.RunJournal(ctx, journal, cfg.WriteJournalReplayInterval)
+	}
+
+	// Label records with the country and ASN of their requester
+	if cfg.GeoIPCountryDB != "" || cfg.GeoIPASNDB != "" {
+		resolver, err := geoip.Open(cfg.GeoIPCountryDB, cfg.GeoIPASNDB)
+		if err != nil {
+			logrus.Fatalf("Failed to open GeoIP databases: %v", err)
+		}
+		defer resolver.Close()
+		logrus.Infof("GeoIP enrichment enabled (country: %q, ASN: %q)", cfg.GeoIPCountryDB, cfg.GeoIPASNDB)
+		dnsHandler.SetGeoIP(resolver)
+	}
 
 	// Create DNS server for UDP and TCP
-	// Set TsigSecret on the server - this is required for TSIG to work properly
+	// Set TsigProvider on the server - this is required for TSIG to work properly
 	// The server will handle TSIG verification automatically before calling the handler
 	serverAddr := fmt.Sprintf("%s:%d", cfg.ListenAddr, cfg.Port)
 
-	// TSIG secret map - include both with and without trailing dot
-	tsigSecret := map[string]string{
-		cfg.TSIGKey:       cfg.TSIGSecret,
-		cfg.TSIGKey + ".": cfg.TSIGSecret,
+	// TSIG secrets of TSIG_KEY, TSIG_KEYS and TSIG_KEYS_FILE - include both with and without trailing dot;
+	// the keyring is shared by every server so that applied keys take effect at once
+	keyring := tsig.NewKeyring(cfg.TSIGSecrets())
+	keyring.SetOverlap(cfg.TSIGRotationOverlap)
+	keyring.SetMinAlgorithm(cfg.MinAlgorithmName())
+	dnsHandler.SetKeyring(keyring)
+	for _, key := range cfg.Keys() {
+		logrus.Debugf("TSIG secret %s configured for key %s (%s)", config.Fingerprint(key.Secret), key.Name, config.TSIGAlgorithmName(key.Algorithm))
+	}
+
+	// Zones, keys and policies of configuration documents pushed to the admin API
+	// replace the running ones without a restart
+	live := config.NewLive(cfg, configFlags)
+	live.OnApply(dnsHandler.ApplyConfig)
+	live.OnApply(func(applied *config.Config) {
+		keyring.SetOverlap(applied.TSIGRotationOverlap)
+		keyring.SetMinAlgorithm(applied.MinAlgorithmName())
+		keyring.SetSecrets(applied.TSIGSecrets())
+		k8sClient.SetCustomLabels(applied.CustomLabels)
+		if level, err := logrus.ParseLevel(strings.ToLower(applied.LogLevel)); err == nil {
+			logrus.SetLevel(level)
+		}
+		logrus.Infof("Applied configuration (zones: %v, keys: %d)", applied.AllowedZones, len(applied.Keys()))
+	})
+
+	// SIGHUP, and changes of the configuration file when watched, load the
+	// configuration again from the flags, the environment and the file
+	reload := func(trigger string) {
+		changes, err := live.Reload(*configFile)
+		if err != nil {
+			logrus.Errorf("Failed to reload configuration on %s: %v", trigger, err)
+			return
+		}
+		logrus.Infof("Reloaded configuration on %s (%d changes)", trigger, len(changes))
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reload("SIGHUP")
+		}
+	}()
+	if *configFile != "" && cfg.ConfigWatchInterval > 0 {
+		go config.WatchFile(ctx, *configFile, cfg.ConfigWatchInterval, func() {
+			reload("change of " + *configFile)
+		})
 	}
-	logrus.Debugf("TSIG secrets configured for keys: %s, %s.", cfg.TSIGKey, cfg.TSIGKey)
 
-	// Custom MsgAcceptFunc: accept queries, notifies and UPDATE opcodes; ignore responses; reject others
-	msgAccept := func(dh dns.Header) dns.MsgAcceptAction {
-		// QR flag (response) is the most significant bit (1<<15 == 0x8000)
-		if dh.Bits&0x8000 != 0 { // is a response
-			return dns.MsgIgnore
+	// TSIG secrets of a Secret, read before serving and applied again on every rotation
+	if cfg.TSIGSecretRef != "" {
+		namespace, name := cfg.SecretRef()
+		data, err := k8sClient.TSIGSecret(ctx, namespace, name)
+		if err != nil {
+			logrus.Fatalf("Failed to read TSIG_SECRET_REF: %v", err)
 		}
-		opcode := int((dh.Bits >> 11) & 0xF)
-		if opcode == dns.OpcodeQuery || opcode == dns.OpcodeNotify || opcode == dns.OpcodeUpdate {
-			return dns.MsgAccept
+		if err := live.SetSecretKeys(data); err != nil {
+			logrus.Fatalf("Invalid TSIG secrets in Secret %s: %v", cfg.TSIGSecretRef, err)
 		}
-		return dns.MsgRejectNotImplemented
+		go func() {
+			err := k8sClient.WatchTSIGSecret(ctx, namespace, name, func(data map[string]string) {
+				if err := live.SetSecretKeys(data); err != nil {
+					logrus.Errorf("Ignoring TSIG secrets of Secret %s: %v", cfg.TSIGSecretRef, err)
+				}
+			})
+			if err != nil {
+				logrus.Fatalf("TSIG secret watch failed: %v", err)
+			}
+		}()
 	}
 
+	// The pod is ready once every DNS listener is bound
+	checker := health.NewChecker()
+	listenerCount := 2 + 2*len(cfg.Listeners)
+	if cfg.TLSPort > 0 {
+		listenerCount++
+	}
+	listening := checker.Countdown("listeners", listenerCount)
+
+	// Custom MsgAcceptFunc: accept queries, notifies and UPDATE opcodes; ignore responses;
+	// answer others according to UNSUPPORTED_RESPONSE
+	msgAccept := dnsHandler.MsgAcceptFunc
+
 	udpServer := &dns.Server{
-		Addr:          serverAddr,
-		Net:           "udp",
-		Handler:       dnsHandler,
-		TsigSecret:    tsigSecret,
-		MsgAcceptFunc: msgAccept,
+		Addr:           serverAddr,
+		Net:            "udp",
+		Handler:        dnsHandler,
+		TsigProvider:   keyring,
+		MsgAcceptFunc:  msgAccept,
+		DecorateReader: decorateReader,
+		DecorateWriter: decorateWriter,
+		MaxTCPQueries:  tcpQueries,
+
+		NotifyStartedFunc: listening,
 	}
 
 	tcpServer := &dns.Server{
-		Addr:          serverAddr,
-		Net:           "tcp",
-		Handler:       dnsHandler,
-		TsigSecret:    tsigSecret,
-		MsgAcceptFunc: msgAccept,
+		Addr:           serverAddr,
+		Net:            "tcp",
+		Handler:        dnsHandler,
+		TsigProvider:   keyring,
+		MsgAcceptFunc:  msgAccept,
+		DecorateReader: decorateReader,
+		DecorateWriter: decorateWriter,
+		MaxTCPQueries:  tcpQueries,
+
+		NotifyStartedFunc: listening,
 	}
 
 	// Start UDP server
@@ -106,11 +529,181 @@ func main() {
 	// Start TCP server
 	go func() {
 		logrus.Infof("Starting TCP server on %s", serverAddr)
-		if err := tcpServer.ListenAndServe(); err != nil {
+		if err := listenAndServe(tcpServer, sessions, cfg); err != nil {
 			logrus.Fatalf("Failed to start TCP server: %v", err)
 		}
 	}()
 
+	// Start DNS-over-TLS server
+	var tlsServer *dns.Server
+	if cfg.TLSPort > 0 {
+		tlsConfig, err := loadTLSConfig(cfg)
+		if err != nil {
+			logrus.Fatalf("Failed to configure TLS: %v", err)
+		}
+		tlsAddr := fmt.Sprintf("%s:%d", cfg.ListenAddr, cfg.TLSPort)
+		tlsServer = &dns.Server{
+			Addr:           tlsAddr,
+			Net:            "tcp-tls",
+			TLSConfig:      tlsConfig,
+			Handler:        dnsHandler,
+			TsigProvider:   keyring,
+			MsgAcceptFunc:  msgAccept,
+			DecorateReader: decorateReader,
+			DecorateWriter: decorateWriter,
+			MaxTCPQueries:  tcpQueries,
+
+			NotifyStartedFunc: listening,
+		}
+		go func() {
+			logrus.Infof("Starting DNS-over-TLS server on %s (certificate ACLs: %d)", tlsAddr, len(cfg.CertACLs))
+			if err := listenAndServe(tlsServer, sessions, cfg); err != nil {
+				logrus.Fatalf("Failed to start DNS-over-TLS server: %v", err)
+			}
+		}()
+	}
+
+	// Start dedicated listeners restricted to a subset of zones and keys
+	listenerServers := make([]*dns.Server, 0, 2*len(cfg.Listeners))
+	for _, listener := range cfg.Listeners {
+		listenerHandler := dnsHandler.ForListener(listener)
+		for _, network := range []string{"udp", "tcp"} {
+			server := &dns.Server{
+				Addr:           listener.Addr,
+				Net:            network,
+				Handler:        listenerHandler,
+				TsigProvider:   keyring,
+				MsgAcceptFunc:  msgAccept,
+				DecorateReader: decorateReader,
+				DecorateWriter: decorateWriter,
+				MaxTCPQueries:  tcpQueries,
+
+				NotifyStartedFunc: listening,
+			}
+			listenerServers = append(listenerServers, server)
+			go func() {
+				logrus.Infof("Starting %s listener on %s (zones: %v, keys: %v)", strings.ToUpper(network), listener.Addr, listener.Zones, listener.Keys)
+				if err := listenAndServe(server, sessions, cfg); err != nil {
+					logrus.Fatalf("Failed to start %s listener on %s: %v", network, listener.Addr, err)
+				}
+			}()
+		}
+	}
+
+	// Prune expired RecordEvents
+	if cfg.RecordEvents {
+		logrus.Infof("RecordEvent history enabled (retention: %s, per record: %d)", cfg.RecordEventsRetention, cfg.RecordEventsPerRecord)
+		go k8sClient.RunEventPruner(ctx)
+	}
+
+	// Remove the records of the TTL_EXPIRY zones not refreshed within their lifetime
+	if len(cfg.TTLExpiry) > 0 {
+		logrus.Infof("TTL expiry enabled for zones %v (interval: %s)", cfg.TTLExpiry, cfg.TTLExpiryInterval)
+		go k8sClient.RunExpiry(ctx, cfg.TTLExpiryInterval)
+	}
+
+	// Merge fragmented DNSEndpoints into the canonical layout
+	if cfg.CompactionInterval > 0 && !cfg.DynamicRecords {
+		logrus.Infof("DNSEndpoint compaction enabled (interval: %s)", cfg.CompactionInterval)
+		go k8sClient.RunCompactor(ctx, cfg.CompactionInterval, cfg.AllowedZones)
+	}
+
+	// Remove ACME challenges whose cleanup never came
+	if cfg.ACMEChallenges && cfg.ACMEChallengeMaxAge > 0 {
+		logrus.Infof("ACME challenges enabled (max age: %s)", cfg.ACMEChallengeMaxAge)
+		go k8sClient.RunChallengeJanitor(ctx, cfg.ACMEChallengeMaxAge)
+	}
+
+	// Readiness checks
+	if cfg.RBACCheckInterval > 0 {
+		checker.RunPeriodic(ctx, "rbac", cfg.RBACCheckInterval, k8sClient.CheckAccess)
+	}
+	if cfg.BackendCheckInterval > 0 {
+		checker.RunPeriodic(ctx, "kubernetes", cfg.BackendCheckInterval, k8sClient.CheckBackend)
+	}
+
+	// Dump the state of the bridge to the log on SIGQUIT
+	dumper := diag.NewDumper()
+	dumper.Register("handler", dnsHandler.Diagnostics)
+	dumper.Register("readiness", func() interface{} {
+		ready, failures := checker.Ready()
+		return map[string]interface{}{"ready": ready, "failures": failures}
+	})
+	if banner != nil {
+		dumper.Register("bans", func() interface{} { return banner.List() })
+	}
+	if sessions != nil {
+		dumper.Register("tcpSessions", func() interface{} { return sessions.Stats() })
+	}
+	dumper.Register("retirements", func() interface{} { return retirements.List() })
+	dumper.HandleSignals(ctx, syscall.SIGQUIT)
+
+	// Start admin API
+	var adminServer *admin.Server
+	if cfg.AdminAddr != "" || cfg.AdminSocket != "" {
+		adminServer = admin.NewServer(cfg.AdminAddr)
+		// Probes stay reachable by the kubelet without a token
+		adminServer.HandlePublic("GET /healthz", checker.HealthzHandler())
+		adminServer.HandlePublic("GET /readyz", checker.ReadyzHandler())
+		if cfg.AdminAuth {
+			logrus.Infof("Admin API requires bearer tokens for audience %s", cfg.AdminTokenAudience)
+			adminServer.RequireToken(k8sClient, cfg.AdminTokenAudience)
+		} else if cfg.AdminAddr != "" {
+			logrus.Warnf("Serving the admin API without authentication on %s: anyone reaching it can collect, transfer, import and retire records and clear bans; set ADMIN_AUTH=true", cfg.AdminAddr)
+		}
+		adminServer.Handle("GET /metrics", metrics.Handler())
+		adminServer.Handle("POST /gc", admin.GCHandler(k8sClient))
+		adminServer.Handle("POST /transfer", admin.TransferHandler(k8sClient))
+		adminServer.Handle("GET /records", admin.ExportHandler(k8sClient))
+		adminServer.HandleFunc("POST /records", func(w http.ResponseWriter, r *http.Request) {
+			admin.ImportHandler(k8sClient, live.Current().AllowedZones).ServeHTTP(w, r)
+		})
+		// Pushing a configuration replaces the keys and zones: never without a token
+		adminServer.HandleRestricted("POST /config", admin.ConfigHandler(live))
+		if cfg.AdminAddr != "" && !cfg.AdminAuth {
+			logrus.Warnf("POST /config is not served on %s without ADMIN_AUTH", cfg.AdminAddr)
+		}
+		adminServer.Handle("POST /dryrun", admin.DryRunHandler(dnsHandler))
+		adminServer.Handle("POST /dump", admin.DumpHandler(dumper))
+		adminServer.Handle("POST /zones/retire", admin.RetireHandler(retirements))
+		adminServer.Handle("GET /zones/retire", admin.RetirementsHandler(retirements))
+		adminServer.Handle("DELETE /zones/retire", admin.CancelRetirementHandler(retirements))
+		if captureFile != nil {
+			adminServer.Handle("GET /capture", admin.CaptureHandler(captureFile))
+		}
+		if banner != nil {
+			adminServer.Handle("GET /bans", admin.BansHandler(banner))
+			adminServer.Handle("DELETE /bans", admin.ClearBansHandler(banner))
+		}
+		if cfg.AdminAddr != "" {
+			go func() {
+				if err := adminServer.ListenAndServe(); err != nil {
+					logrus.Fatalf("Failed to start admin API: %v", err)
+				}
+			}()
+		}
+		// Sidecars and node-local tooling query the socket, guarded by its permissions
+		if cfg.AdminSocket != "" {
+			go func() {
+				if err := adminServer.ServeUnix(cfg.AdminSocket, cfg.AdminSocketMode); err != nil {
+					logrus.Fatalf("Failed to start admin API on unix socket: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Serve the pprof profiles apart from the admin API
+	var debugServer *http.Server
+	if cfg.DebugAddr != "" {
+		debugServer = admin.NewDebugServer(cfg.DebugAddr)
+		go func() {
+			logrus.Warnf("Serving pprof profiles without authentication on %s", cfg.DebugAddr)
+			if err := debugServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.Fatalf("Failed to start debug server: %v", err)
+			}
+		}()
+	}
+
 	logrus.Println("DNS UPDATE server started successfully")
 
 	// Wait for interrupt signal
@@ -119,7 +712,66 @@ func main() {
 	<-sig
 
 	logrus.Println("Shutting down servers...")
+	cancel()
 	udpServer.Shutdown()
 	tcpServer.Shutdown()
+	if tlsServer != nil {
+		tlsServer.Shutdown()
+	}
+	for _, server := range listenerServers {
+		server.Shutdown()
+	}
+	dnsHandler.Drain()
+	if adminServer != nil {
+		adminServer.Shutdown(context.Background())
+	}
+	if debugServer != nil {
+		debugServer.Shutdown(context.Background())
+	}
 	logrus.Println("Servers stopped")
 }
+
+// loadConfig loads the configuration from the flags and the environment, over the
+// configuration file when set
+func loadConfig(path string, flags config.Flags) (*config.Config, error) {
+	if path == "" {
+		return config.LoadConfig(flags)
+	}
+	return config.LoadConfigFile(path, flags)
+}
+
+// listenAndServe starts a DNS server, reading the PROXY protocol header of its TCP
+// connections when enabled and tracking them with the session limiter when set
+func listenAndServe(server *dns.Server, sessions *tcplimit.Limiter, cfg *config.Config) error {
+	if server.Net == "udp" || (sessions == nil && !cfg.ProxyProtocol) {
+		return server.ListenAndServe()
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	if cfg.ProxyProtocol {
+		listener = proxyproto.Listener(listener, cfg.ProxyTrustedNetworks, proxyHeaderTimeout)
+	}
+	if sessions != nil {
+		listener = sessions.Listener(listener)
+	}
+	if server.Net == "tcp-tls" {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+	server.Listener = listener
+	return server.ActivateAndServe()
+}
+
+// chainReaders applies reader decorators in order, the first one reading from
+// the connection; nil decorators are skipped
+func chainReaders(decorators ...dns.DecorateReader) dns.DecorateReader {
+	return func(reader dns.Reader) dns.Reader {
+		for _, decorate := range decorators {
+			if decorate != nil {
+				reader = decorate(reader)
+			}
+		}
+		return reader
+	}
+}
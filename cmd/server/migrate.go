@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// runMigrate rewrites the managed DNSEndpoints to the configured naming layout and returns the exit code
+func runMigrate(k8sClient *k8s.Client, zones []string, args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list the resources that would be migrated")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	names, err := k8sClient.MigrateLayout(context.Background(), zones, *dryRun)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	if err != nil {
+		logrus.Errorf("Migration failed: %v", err)
+		return 1
+	}
+	if *dryRun {
+		logrus.Infof("Migration dry run: %d DNSEndpoint(s) would be migrated", len(names))
+	} else {
+		logrus.Infof("Migrated %d DNSEndpoint(s)", len(names))
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/admin"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/push"
+)
+
+// pushTimeout bounds the exchange of an UPDATE with the external server
+const pushTimeout = 10 * time.Second
+
+// runPush sends the records of the DNSEndpoints in the allowed zones to PUSH_SERVER
+// until stopped, and returns the exit code
+func runPush(cfg *config.Config, k8sClient *k8s.Client, args []string) int {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.PushServer == "" {
+		logrus.Errorf("push requires PUSH_SERVER")
+		return 2
+	}
+
+	// Validate checked that the key is configured
+	key, _ := cfg.Key(cfg.PushKey)
+	options := push.Options{
+		Server:    cfg.PushServer,
+		KeyName:   key.Name,
+		Algorithm: config.TSIGAlgorithmName(key.Algorithm),
+		Secret:    key.Secret,
+		ZoneOf:    cfg.ZoneOf,
+	}
+	pusher := push.New(options, push.NewClient(options, cfg.PushNet, pushTimeout))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Expose the push metrics
+	if cfg.AdminAddr != "" {
+		adminServer := admin.NewServer(cfg.AdminAddr)
+		adminServer.Handle("GET /metrics", metrics.Handler())
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil {
+				logrus.Fatalf("Failed to start admin API: %v", err)
+			}
+		}()
+		defer adminServer.Shutdown(context.Background())
+	}
+
+	logrus.Infof("Pushing the DNSEndpoints of zones %v to %s over %s (key: %s)", cfg.AllowedZones, cfg.PushServer, cfg.PushNet, key.Name)
+	err := k8sClient.RunEndpointWatch(ctx, cfg.PushSelector, func(records []k8s.Record) {
+		if err := pusher.Sync(records); err != nil {
+			logrus.Errorf("Push failed, retrying at the next change or resync: %v", err)
+		}
+	})
+	if err != nil {
+		logrus.Errorf("DNSEndpoint watch failed: %v", err)
+		return 1
+	}
+	return 0
+}
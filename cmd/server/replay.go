@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// runReplay applies again the changes recorded by RecordEvents and returns the exit code
+func runReplay(k8sClient *k8s.Client, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	since := flags.String("since", "", "replay the events from this time (RFC 3339), or this long ago (e.g. 24h)")
+	until := flags.String("until", "", "replay the events up to this time (RFC 3339), or this long ago")
+	zone := flags.String("zone", "", "only replay the events of the names of this zone")
+	dryRun := flags.Bool("dry-run", false, "only list the changes that would be replayed")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	now := time.Now()
+	filter := k8s.ReplayFilter{Zone: *zone}
+	var err error
+	if filter.Since, err = parseReplayTime(*since, now); err != nil {
+		logrus.Errorf("Invalid --since: %v", err)
+		return 2
+	}
+	if filter.Until, err = parseReplayTime(*until, now); err != nil {
+		logrus.Errorf("Invalid --until: %v", err)
+		return 2
+	}
+
+	lines, err := k8sClient.ReplayEvents(context.Background(), filter, *dryRun)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	if err != nil {
+		logrus.Errorf("Replay failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// parseReplayTime parses an RFC 3339 time, or a duration before now; empty is no bound
+func parseReplayTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
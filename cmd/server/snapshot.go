@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/s3"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/snapshot"
+)
+
+// runSnapshot exports each allowed zone to the snapshot bucket, once for a CronJob or
+// on a schedule, and returns the exit code
+func runSnapshot(cfg *config.Config, k8sClient *k8s.Client, args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	schedule := flags.Duration("schedule", 0, "take a snapshot at this interval until stopped, instead of once")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if cfg.SnapshotBucket == "" {
+		logrus.Errorf("snapshot requires SNAPSHOT_BUCKET")
+		return 2
+	}
+	if *schedule < 0 {
+		logrus.Errorf("--schedule must not be negative")
+		return 2
+	}
+
+	store, err := s3.New(s3.Options{
+		Endpoint:  cfg.SnapshotEndpoint,
+		Region:    cfg.SnapshotRegion,
+		Bucket:    cfg.SnapshotBucket,
+		AccessKey: cfg.SnapshotAccessKey,
+		SecretKey: cfg.SnapshotSecretKey,
+	})
+	if err != nil {
+		logrus.Errorf("Invalid snapshot bucket: %v", err)
+		return 2
+	}
+	snapshotter := snapshot.New(k8sClient, store, cfg.AllowedZones, cfg.ZoneOf, cfg.SnapshotPrefix, cfg.SnapshotRetention)
+
+	if *schedule > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		logrus.Infof("Taking zone snapshots every %s to bucket %s", *schedule, cfg.SnapshotBucket)
+		snapshotter.Run(ctx, *schedule)
+		return 0
+	}
+
+	if _, err := snapshotter.Snapshot(context.Background(), time.Now()); err != nil {
+		logrus.Errorf("Snapshot failed: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// runTransfer hands the records of a TSIG key over to another key and returns the exit code
+func runTransfer(k8sClient *k8s.Client, args []string) int {
+	flags := flag.NewFlagSet("transfer", flag.ContinueOnError)
+	fromKey := flags.String("from-key", "", "TSIG key name of the current owner")
+	toKey := flags.String("to-key", "", "TSIG key name of the new owner")
+	requester := flags.String("requester", "", "only transfer the records last refreshed from this IP address")
+	toRequester := flags.String("to-requester", "", "IP address of the new owner, the current one is kept when empty")
+	dryRun := flags.Bool("dry-run", false, "only list the records that would be transferred")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *fromKey == "" || *toKey == "" {
+		logrus.Errorf("transfer requires --from-key and --to-key")
+		return 2
+	}
+
+	from := k8s.RequesterSelector{Addr: *requester, KeyName: *fromKey}
+	to := k8s.RequesterSelector{Addr: *toRequester, KeyName: *toKey}
+	names, err := k8sClient.TransferOwnership(context.Background(), from, to, *dryRun)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	if err != nil {
+		logrus.Errorf("Ownership transfer failed: %v", err)
+		return 1
+	}
+	return 0
+}
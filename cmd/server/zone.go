@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+)
+
+// runZone retires a zone, or cancels its retirement, through the admin API of the
+// running server, e.g. from kubectl exec, and returns the exit code
+func runZone(cfg *config.Config, args []string) int {
+	if len(args) == 0 || (args[0] != "retire" && args[0] != "cancel") {
+		logrus.Errorf("usage: zone retire|cancel [flags] <zone>")
+		return 2
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("zone "+action, flag.ContinueOnError)
+	grace := flags.Duration("grace", 0, "time the records stay published after updates are refused")
+	export := flags.Bool("export", false, "export the records to the snapshot bucket first")
+	tombstone := flags.Bool("tombstone", false, "keep the emptied resources of the records instead of deleting them")
+	dryRun := flags.Bool("dry-run", false, "only list the records that would be removed")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		logrus.Errorf("zone %s requires a zone", action)
+		return 2
+	}
+
+	client, base, err := adminClient(cfg)
+	if err != nil {
+		logrus.Errorf("zone %s: %v", action, err)
+		return 2
+	}
+	query := url.Values{"zone": {flags.Arg(0)}}
+	method := http.MethodDelete
+	if action == "retire" {
+		method = http.MethodPost
+		query.Set("grace", grace.String())
+		query.Set("export", strconv.FormatBool(*export))
+		query.Set("tombstone", strconv.FormatBool(*tombstone))
+		query.Set("dryRun", strconv.FormatBool(*dryRun))
+	}
+
+	req, err := http.NewRequest(method, base+"/zones/retire?"+query.Encode(), nil)
+	if err != nil {
+		logrus.Errorf("zone %s: %v", action, err)
+		return 1
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Errorf("zone %s: admin API unreachable: %v", action, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		logrus.Errorf("zone %s: %v", action, err)
+		return 1
+	}
+	if resp.StatusCode >= 300 {
+		logrus.Errorf("zone %s failed: %s", action, resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// adminClient returns a client of the admin API of the server running with cfg and
+// its base URL, preferring the unix socket which requires no bearer token
+func adminClient(cfg *config.Config) (*http.Client, string, error) {
+	client := &http.Client{Timeout: time.Minute}
+	if cfg.AdminSocket != "" {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", cfg.AdminSocket)
+			},
+		}
+		return client, "http://localhost", nil
+	}
+	if cfg.AdminAddr == "" {
+		return nil, "", fmt.Errorf("requires ADMIN_SOCKET or ADMIN_ADDR")
+	}
+	if cfg.AdminAuth {
+		return nil, "", fmt.Errorf("requires ADMIN_SOCKET when ADMIN_AUTH is enabled")
+	}
+	addr := cfg.AdminAddr
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return client, "http://" + addr, nil
+}
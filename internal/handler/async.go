@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// asyncMessage is a validated message waiting for its write
+type asyncMessage struct {
+	requester k8s.Requester
+	updates   []*update.DNSUpdate
+}
+
+// asyncWriter writes the validated messages to Kubernetes after they are answered,
+// with a worker per queue. The messages of a requester always go to the same
+// queue, so they are written in the order they were received.
+type asyncWriter struct {
+	queues []chan asyncMessage
+	// block waits for room in a full queue instead of refusing the message
+	block bool
+	write func(k8s.Requester, []*update.DNSUpdate) error
+
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// newAsyncWriter starts workers workers writing messages with write, each queueing
+// up to size messages, or returns nil when workers is 0
+func newAsyncWriter(workers, size int, block bool, write func(k8s.Requester, []*update.DNSUpdate) error) *asyncWriter {
+	if workers <= 0 {
+		return nil
+	}
+	a := &asyncWriter{queues: make([]chan asyncMessage, workers), block: block, write: write}
+	for i := range a.queues {
+		a.queues[i] = make(chan asyncMessage, size)
+		a.workers.Add(1)
+		go a.run(a.queues[i])
+	}
+	return a
+}
+
+// run writes the messages of a queue until it is closed
+func (a *asyncWriter) run(queue chan asyncMessage) {
+	defer a.workers.Done()
+	for m := range queue {
+		metrics.AsyncQueueDepth.Dec()
+		if err := a.write(m.requester, m.updates); err != nil {
+			logrus.Errorf("Failed to apply acknowledged update from %s to Kubernetes: %v", m.requester.Addr, err)
+			metrics.AsyncMessages.WithLabelValues("failed").Inc()
+			continue
+		}
+		metrics.AsyncMessages.WithLabelValues("written").Inc()
+	}
+}
+
+// enqueue queues the updates of a requester. A full queue waits for room, or
+// refuses the message when the writer does not block.
+func (a *asyncWriter) enqueue(requester k8s.Requester, updates []*update.DNSUpdate) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return fmt.Errorf("%w: shutting down", dnserr.ErrBackendUnavailable)
+	}
+
+	queue := a.queues[a.shard(requester)]
+	message := asyncMessage{requester: requester, updates: updates}
+	// Counted before it is queued, a worker may take the message at once
+	metrics.AsyncQueueDepth.Inc()
+	if a.block {
+		queue <- message
+	} else {
+		select {
+		case queue <- message:
+		default:
+			metrics.AsyncQueueDepth.Dec()
+			metrics.AsyncMessages.WithLabelValues("refused").Inc()
+			return fmt.Errorf("%w: write queue full", dnserr.ErrBackendUnavailable)
+		}
+	}
+	metrics.AsyncMessages.WithLabelValues("queued").Inc()
+	return nil
+}
+
+// shard returns the queue of a requester, by address and key
+func (a *asyncWriter) shard(requester k8s.Requester) int {
+	h := fnv.New32a()
+	h.Write([]byte(requester.IP()))
+	h.Write([]byte(requester.KeyName))
+	return int(h.Sum32() % uint32(len(a.queues)))
+}
+
+// close stops accepting messages and waits for the queued ones to be written
+func (a *asyncWriter) close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	for _, queue := range a.queues {
+		close(queue)
+	}
+	a.mu.Unlock()
+	a.workers.Wait()
+}
+
+// Drain writes the messages waiting in the write queues, refusing the messages
+// received meanwhile. It returns at once without ASYNC_WORKERS.
+func (h *Handler) Drain() {
+	if h.async != nil {
+		h.async.close()
+	}
+}
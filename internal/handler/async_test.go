@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// blockedWrites holds the writes of an async writer until released
+type blockedWrites struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu      sync.Mutex
+	written int
+}
+
+func newBlockedWrites() *blockedWrites {
+	return &blockedWrites{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (b *blockedWrites) write(k8s.Requester, []*update.DNSUpdate) error {
+	b.started <- struct{}{}
+	<-b.release
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.written++
+	return nil
+}
+
+func (b *blockedWrites) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.written
+}
+
+func TestAsyncWriterQueueFull(t *testing.T) {
+	writes := newBlockedWrites()
+	a := newAsyncWriter(1, 1, false, writes.write)
+	router := k8s.Requester{Addr: udpClient}
+	updates := []*update.DNSUpdate{addressUpdate("host.example.com.", "192.0.2.10")}
+	depth := testutil.ToFloat64(metrics.AsyncQueueDepth)
+
+	// The worker holds the first message, the queue the second one
+	if err := a.enqueue(router, updates); err != nil {
+		t.Fatalf("enqueue() failed: %v", err)
+	}
+	<-writes.started
+	if err := a.enqueue(router, updates); err != nil {
+		t.Fatalf("enqueue() failed: %v", err)
+	}
+	err := a.enqueue(router, updates)
+	if !errors.Is(err, dnserr.ErrBackendUnavailable) || dnserr.Rcode(err) != dns.RcodeServerFailure {
+		t.Errorf("Expected a full queue to refuse the message with SERVFAIL, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.AsyncQueueDepth) - depth; got != 1 {
+		t.Errorf("Expected a queue depth of 1 without the refused message, got %v", got)
+	}
+
+	close(writes.release)
+	a.close()
+	if writes.count() != 2 {
+		t.Errorf("Expected the 2 accepted messages to be written, got %d", writes.count())
+	}
+	if got := testutil.ToFloat64(metrics.AsyncQueueDepth) - depth; got != 0 {
+		t.Errorf("Expected an empty queue once written, got a depth of %v", got)
+	}
+}
+
+func TestAsyncWriterQueueDepth(t *testing.T) {
+	// The gauge never counts a message taken by a worker before it was counted
+	a := newAsyncWriter(4, 1, true, func(k8s.Requester, []*update.DNSUpdate) error {
+		if depth := testutil.ToFloat64(metrics.AsyncQueueDepth); depth < 0 {
+			t.Errorf("Expected a positive queue depth, got %v", depth)
+		}
+		return nil
+	})
+	depth := testutil.ToFloat64(metrics.AsyncQueueDepth)
+	updates := []*update.DNSUpdate{addressUpdate("host.example.com.", "192.0.2.10")}
+	for i := 0; i < 200; i++ {
+		router := k8s.Requester{Addr: &net.UDPAddr{IP: net.IPv4(192, 168, 1, byte(i)), Port: 5353}}
+		if err := a.enqueue(router, updates); err != nil {
+			t.Fatalf("enqueue() failed: %v", err)
+		}
+	}
+	a.close()
+	if got := testutil.ToFloat64(metrics.AsyncQueueDepth) - depth; got != 0 {
+		t.Errorf("Expected an empty queue once written, got a depth of %v", got)
+	}
+}
+
+func TestAsyncWriterQueueFullBlock(t *testing.T) {
+	writes := newBlockedWrites()
+	a := newAsyncWriter(1, 1, true, writes.write)
+	router := k8s.Requester{Addr: udpClient}
+	updates := []*update.DNSUpdate{addressUpdate("host.example.com.", "192.0.2.10")}
+
+	if err := a.enqueue(router, updates); err != nil {
+		t.Fatalf("enqueue() failed: %v", err)
+	}
+	<-writes.started
+	if err := a.enqueue(router, updates); err != nil {
+		t.Fatalf("enqueue() failed: %v", err)
+	}
+	queued := make(chan error, 1)
+	go func() {
+		queued <- a.enqueue(router, updates)
+	}()
+	select {
+	case err := <-queued:
+		t.Fatalf("Expected the message to wait for room in the full queue, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(writes.release)
+	if err := <-queued; err != nil {
+		t.Errorf("Expected the message to be queued once there is room, got %v", err)
+	}
+	a.close()
+	if writes.count() != 3 {
+		t.Errorf("Expected the 3 messages to be written, got %d", writes.count())
+	}
+}
+
+func TestServeDNSAsyncQueueFull(t *testing.T) {
+	h, api := newTestHandler(t, map[string]string{"ASYNC_WORKERS": "1", "ASYNC_QUEUE_SIZE": "1"})
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	api.PrependReactor("create", "dnsendpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+		started <- struct{}{}
+		<-release
+		return false, nil, nil
+	})
+
+	serve := func(name string) int {
+		w := &testWriter{remote: udpClient}
+		h.serveDNS(w, updateMsg(name, true))
+		if len(w.responses) != 1 {
+			t.Fatalf("Expected one response, got %d", len(w.responses))
+		}
+		return w.responses[0].Rcode
+	}
+
+	// Validated messages are answered before their write
+	if rcode := serve("a.example.com."); rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected NOERROR before the write, got %s", dns.RcodeToString[rcode])
+	}
+	<-started
+	if rcode := serve("b.example.com."); rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected the queued message to be answered NOERROR, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode := serve("c.example.com."); rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL with the write queue full, got %s", dns.RcodeToString[rcode])
+	}
+
+	// Drain writes the queued messages before returning
+	close(release)
+	h.Drain()
+	if got := writes(api); got != 2 {
+		t.Errorf("Expected the 2 acknowledged messages to be written by Drain, got %d", got)
+	}
+	if rcode := serve("d.example.com."); rcode != dns.RcodeServerFailure {
+		t.Errorf("Expected SERVFAIL once drained, got %s", dns.RcodeToString[rcode])
+	}
+	if got := writes(api); got != 2 {
+		t.Errorf("Expected no write after Drain, got %d", got-2)
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/audit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// SetAuditLog records every accepted update in an audit trail
+func (h *Handler) SetAuditLog(log *audit.Log) {
+	h.audit = log
+}
+
+// auditRecords returns the published records of the name and type of each update
+// for the audit trail, or nil without one
+func (h *Handler) auditRecords(updates []*update.DNSUpdate) [][]string {
+	if h.audit == nil {
+		return nil
+	}
+	rrsets := make(map[string]map[uint16][]string)
+	records := make([][]string, len(updates))
+	for i, upd := range updates {
+		key := upd.Zone + " " + upd.Name
+		rrset, ok := rrsets[key]
+		if !ok {
+			var err error
+			if rrset, err = h.k8sClient.LookupRRsets(upd.Name, upd.Zone); err != nil {
+				logrus.Warnf("Failed to look up the records of %s for the audit trail: %v", upd.Name, err)
+			}
+			rrsets[key] = rrset
+		}
+		records[i] = append([]string{}, rrset[upd.RecordType]...)
+	}
+	return records
+}
+
+// recordAudit records the updates a transaction changed in the audit trail, with
+// the records of their name and type before and after it
+func (h *Handler) recordAudit(requester k8s.Requester, updates []*update.DNSUpdate, tx *k8s.Transaction, before [][]string) {
+	if h.audit == nil {
+		return
+	}
+	after := h.auditRecords(updates)
+	now := time.Now().UTC()
+	for i, upd := range updates {
+		if !tx.Changed(i) {
+			continue
+		}
+		action := audit.ActionUpdated
+		switch {
+		case tx.Deferred(i):
+			action = audit.ActionDeferred
+		case len(before[i]) == 0:
+			action = audit.ActionCreated
+		case len(after[i]) == 0:
+			action = audit.ActionDeleted
+		}
+		h.audit.Record(audit.Entry{
+			Time:       now,
+			Client:     requester.Addr.String(),
+			Key:        requester.KeyName,
+			Zone:       upd.Zone,
+			Name:       upd.Name,
+			RecordType: dns.TypeToString[upd.RecordType],
+			Update:     upd.Type.String(),
+			Old:        before[i],
+			New:        after[i],
+			Action:     action,
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// writeSlots bounds the messages writing to Kubernetes at once. Large messages give
+// their slot back between batches, so the messages of other clients interleave
+// instead of waiting behind a whole resync. A nil writeSlots is unbounded.
+type writeSlots chan struct{}
+
+// newWriteSlots creates n write slots, or nil when n is 0
+func newWriteSlots(n int) writeSlots {
+	if n <= 0 {
+		return nil
+	}
+	return make(writeSlots, n)
+}
+
+func (s writeSlots) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s writeSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// applyUpdates writes the updates of a message to Kubernetes, or queues them to be
+// written after the message is answered with ASYNC_WORKERS. ACME challenges are
+// awaited by their client and always written before.
+func (h *Handler) applyUpdates(requester k8s.Requester, updates []*update.DNSUpdate) error {
+	for _, upd := range updates {
+		logrus.Debugf("Processing update from %s: %s", requester.Addr, upd.String())
+	}
+	// Records written again within DEDUP_WINDOW are already in Kubernetes
+	if h.dedup.seen(requester, updates) {
+		logrus.Debugf("UPDATE from %s writes the same records again within %s, skipping it", requester.Addr, h.config.DedupWindow)
+		return nil
+	}
+	if h.async != nil && !hasChallenge(updates) {
+		return h.async.enqueue(requester, updates)
+	}
+	return h.writeUpdates(requester, updates)
+}
+
+// hasChallenge checks if a message updates an ACME challenge
+func hasChallenge(updates []*update.DNSUpdate) bool {
+	for _, upd := range updates {
+		if upd.RecordType == dns.TypeTXT {
+			return true
+		}
+	}
+	return false
+}
+
+// writeUpdates writes the updates of a message to Kubernetes as a transaction, made
+// again from the staging when it fails on a transient error
+func (h *Handler) writeUpdates(requester k8s.Requester, updates []*update.DNSUpdate) error {
+	h.dedup.forget(updates)
+	// Messages queue behind the journaled ones, to be written in order
+	if h.journal != nil && h.journal.Len() > 0 && h.journal.Accepts(updates) {
+		return h.queueUpdates(requester, updates, nil)
+	}
+
+	before := h.auditRecords(updates)
+	var tx *k8s.Transaction
+	err := h.k8sClient.Retry(func() (err error) {
+		tx, err = h.writeTransaction(requester, updates)
+		return err
+	})
+	if err != nil {
+		if h.journal != nil && errors.Is(err, dnserr.ErrBackendUnavailable) && h.journal.Accepts(updates) {
+			return h.queueUpdates(requester, updates, err)
+		}
+		return err
+	}
+
+	for i, upd := range updates {
+		if tx.Changed(i) {
+			logrus.Infof("Successfully applied update: %s", upd.String())
+			zone := h.config.ZoneOf(upd.Zone)
+			h.serials.bump(zone)
+			h.cache.invalidate(zone)
+			h.publishEvent(requester, upd)
+			h.tenants.Update(upd.Zone, requester.KeyName, dns.TypeToString[upd.RecordType], requester.IP())
+		}
+	}
+	h.recordAudit(requester, updates, tx, before)
+	h.dedup.written(requester, updates)
+	return nil
+}
+
+// SetJournal queues the messages failing on an unavailable API server in a
+// journal, acknowledging them
+func (h *Handler) SetJournal(journal *k8s.Journal) {
+	h.journal = journal
+}
+
+// queueUpdates appends the updates of a message to the journal, or returns the
+// error of their write when the journal cannot take them
+func (h *Handler) queueUpdates(requester k8s.Requester, updates []*update.DNSUpdate, writeErr error) error {
+	if err := h.journal.Queue(requester, updates); err != nil {
+		logrus.Errorf("Failed to queue update from %s in the write journal: %v", requester.Addr, err)
+		if writeErr != nil {
+			return writeErr
+		}
+		return fmt.Errorf("%w: %w", dnserr.ErrBackendUnavailable, err)
+	}
+	for _, upd := range updates {
+		logrus.Infof("Queued update in the write journal: %s", upd.String())
+	}
+	return nil
+}
+
+// writeTransaction stages the updates of a message, then writes its resources in
+// batches of UPDATE_BATCH_SIZE, holding a write slot per batch. A failed write
+// rolls back the resources of the previous ones.
+func (h *Handler) writeTransaction(requester k8s.Requester, updates []*update.DNSUpdate) (*k8s.Transaction, error) {
+	// Lock the resources before taking a slot: the messages holding the slots
+	// would otherwise wait for a message waiting for a slot
+	unlock, err := h.k8sClient.LockUpdates(requester, updates)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	h.writeSlots.acquire()
+	tx, err := h.k8sClient.BeginUpdates(requester, updates)
+	h.writeSlots.release()
+	if err != nil {
+		return nil, err
+	}
+
+	size := h.config.UpdateBatchSize
+	if size <= 0 || size > tx.Len() {
+		size = tx.Len()
+	}
+	for start, done := 0, false; !done; start += size {
+		if tx.Len() > size {
+			logrus.Debugf("Writing resources %d-%d of %d from %s", start+1, min(start+size, tx.Len()), tx.Len(), requester.Addr)
+		}
+		metrics.UpdateBatches.Inc()
+		if done, err = h.commitBatch(tx, size); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// commitBatch writes a batch of the resources of a transaction while holding a
+// write slot
+func (h *Handler) commitBatch(tx *k8s.Transaction, size int) (bool, error) {
+	h.writeSlots.acquire()
+	defer h.writeSlots.release()
+	return tx.Commit(size)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// queryKey identifies a cached query
+type queryKey struct {
+	qname string
+	qtype uint16
+}
+
+// queryResult holds the sections of a query answer
+type queryResult struct {
+	answer []dns.RR
+	ns     []dns.RR
+}
+
+// cacheEntry is a cached query result of a zone
+type cacheEntry struct {
+	key     queryKey
+	zone    string
+	result  queryResult
+	expires time.Time
+}
+
+// queryCache is a small LRU cache of query results, invalidated per zone on update
+type queryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[queryKey]*list.Element
+}
+
+// newQueryCache creates a cache of size entries kept for ttl, or nil when size is 0
+func newQueryCache(size int, ttl time.Duration) *queryCache {
+	if size <= 0 {
+		return nil
+	}
+	return &queryCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[queryKey]*list.Element),
+	}
+}
+
+// get returns the cached result of a query
+func (c *queryCache) get(key queryKey) (queryResult, bool) {
+	if c == nil {
+		return queryResult{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return queryResult{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return queryResult{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.result, true
+}
+
+// put caches the result of a query of a zone, evicting the least recently used entry when full
+func (c *queryCache) put(key queryKey, zone string, result queryResult) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	if c.order.Len() >= c.size {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:     key,
+		zone:    strings.ToLower(zone),
+		result:  result,
+		expires: time.Now().Add(c.ttl),
+	})
+}
+
+// invalidate drops the cached results of a zone
+func (c *queryCache) invalidate(zone string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	zone = strings.ToLower(zone)
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cacheEntry).zone == zone {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// remove drops a cache entry
+func (c *queryCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// clear drops every cached result
+func (c *queryCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[queryKey]*list.Element)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryCache(t *testing.T) {
+	soa := func(zone string) queryResult {
+		return queryResult{answer: []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET}}}}
+	}
+	apex := queryKey{qname: "example.com.", qtype: dns.TypeSOA}
+	other := queryKey{qname: "example.net.", qtype: dns.TypeSOA}
+	third := queryKey{qname: "example.org.", qtype: dns.TypeSOA}
+
+	c := newQueryCache(2, time.Minute)
+	c.put(apex, "Example.com.", soa("example.com."))
+	c.put(other, "example.net.", soa("example.net."))
+	if _, ok := c.get(apex); !ok {
+		t.Fatal("Expected a cached result")
+	}
+
+	// The least recently used entry is evicted when full
+	c.put(third, "example.org.", soa("example.org."))
+	if _, ok := c.get(other); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if _, ok := c.get(apex); !ok {
+		t.Error("Expected the recently used entry to be kept")
+	}
+
+	// Updates of a zone drop its results only
+	c.invalidate("EXAMPLE.COM.")
+	if _, ok := c.get(apex); ok {
+		t.Error("Expected the results of the updated zone to be dropped")
+	}
+	if _, ok := c.get(third); !ok {
+		t.Error("Expected the results of other zones to be kept")
+	}
+
+	c.clear()
+	if _, ok := c.get(third); ok {
+		t.Error("Expected no result after clear")
+	}
+}
+
+func TestQueryCacheExpiry(t *testing.T) {
+	c := newQueryCache(2, -time.Second)
+	key := queryKey{qname: "example.com.", qtype: dns.TypeSOA}
+	c.put(key, "example.com.", queryResult{})
+	if _, ok := c.get(key); ok {
+		t.Error("Expected an expired result not to be returned")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("Expected the expired entry to be dropped, got %d entries", len(c.entries))
+	}
+}
+
+func TestQueryCacheDisabled(t *testing.T) {
+	c := newQueryCache(0, time.Minute)
+	if c != nil {
+		t.Fatal("Expected no cache without size")
+	}
+	key := queryKey{qname: "example.com.", qtype: dns.TypeSOA}
+	c.put(key, "example.com.", queryResult{})
+	c.invalidate("example.com.")
+	if _, ok := c.get(key); ok {
+		t.Error("Expected a disabled cache to return nothing")
+	}
+}
+
+func TestServeQueryCache(t *testing.T) {
+	h, api := newTestHandler(t, map[string]string{"SERVE_SOA": "true"})
+	query := func() *dns.Msg {
+		t.Helper()
+		msg := new(dns.Msg)
+		msg.SetQuestion("host.example.com.", dns.TypeANY)
+		w := &testWriter{remote: udpClient}
+		h.serveDNS(w, msg)
+		if len(w.responses) != 1 || w.responses[0].Rcode != dns.RcodeSuccess {
+			t.Fatalf("Expected a NOERROR answer, got %v", w.responses)
+		}
+		return w.responses[0]
+	}
+	reads := func() int {
+		n := 0
+		for _, action := range api.Actions() {
+			if action.GetVerb() == "get" || action.GetVerb() == "list" {
+				n++
+			}
+		}
+		return n
+	}
+
+	if resp := query(); len(resp.Answer) != 0 || len(resp.Ns) != 1 {
+		t.Fatalf("Expected NODATA with the SOA of the zone, got %v", resp)
+	}
+	before := reads()
+	if before == 0 {
+		t.Fatal("Expected the first query to read the records")
+	}
+	query()
+	if reads() != before {
+		t.Errorf("Expected the repeated query to be answered from the cache, got %d more reads", reads()-before)
+	}
+
+	// An update of the zone invalidates its cached answers
+	w := &testWriter{remote: udpClient}
+	h.serveDNS(w, updateMsg("host.example.com.", true))
+	if len(w.responses) != 1 || w.responses[0].Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected the update to be applied, got %v", w.responses)
+	}
+	resp := query()
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "192.0.2.10" {
+		t.Errorf("Expected the updated record, got %v", resp.Answer)
+	}
+}
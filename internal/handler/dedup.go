@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// dedupRecord identifies a record written by a requester
+type dedupRecord struct {
+	requester string
+	rrtype    uint16
+	target    string
+	ttl       uint32
+}
+
+// dedupCache remembers the records written in the last window, so that a
+// message writing them again, as DHCP servers do on every lease renewal, is
+// answered without a Kubernetes call. The records of a name are forgotten
+// whenever another update of the name is written, or the watch of the
+// DNSEndpoints sees them changed by another writer.
+type dedupCache struct {
+	window time.Duration
+	size   int
+	now    func() time.Time
+	// tracks checks if the changes of the records of an update are seen, and
+	// if skipping its write leaves them as written
+	tracks func(*update.DNSUpdate) bool
+
+	mu sync.Mutex
+	// names holds the time each record was written, by lowercase name
+	names   map[string]map[dedupRecord]time.Time
+	records int
+}
+
+// newDedupCache creates a cache of size records written in the last window, of
+// the updates tracks accepts, or nil when window is 0
+func newDedupCache(window time.Duration, size int, tracks func(*update.DNSUpdate) bool) *dedupCache {
+	if window <= 0 || size <= 0 {
+		return nil
+	}
+	return &dedupCache{window: window, size: size, now: time.Now, tracks: tracks, names: make(map[string]map[dedupRecord]time.Time)}
+}
+
+// dedupKey returns the name and record of an update of a requester
+func dedupKey(requester k8s.Requester, upd *update.DNSUpdate) (string, dedupRecord) {
+	target := upd.Target
+	switch {
+	case upd.IP != nil:
+		target = upd.IP.String()
+	case upd.Text != nil:
+		target = strconv.Quote(strings.Join(upd.Text, "\x00"))
+	case upd.RecordType == dns.TypeSRV || upd.RecordType == dns.TypeMX:
+		target = strconv.Itoa(int(upd.Priority)) + " " + strconv.Itoa(int(upd.Weight)) + " " + strconv.Itoa(int(upd.Port)) + " " + target
+	}
+	if upd.Unreachable {
+		target += " unreachable"
+	}
+	record := dedupRecord{requester: requester.IP() + " " + requester.KeyName, rrtype: upd.RecordType, target: target, ttl: upd.TTL}
+	return strings.ToLower(upd.Name), record
+}
+
+// seen checks if every update of a message adds a record the requester wrote
+// in the last window. Messages deleting records, or updating records whose
+// changes are not tracked, are always written.
+func (c *dedupCache) seen(requester k8s.Requester, updates []*update.DNSUpdate) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, upd := range updates {
+		if upd.Type == update.UpdateTypeDelete || !c.tracks(upd) {
+			return false
+		}
+		name, record := dedupKey(requester, upd)
+		written, ok := c.names[name][record]
+		if !ok || now.Sub(written) >= c.window {
+			return false
+		}
+	}
+	metrics.DeduplicatedMessages.Inc()
+	return true
+}
+
+// forget forgets the records of the names of a message about to be written,
+// which may change them
+func (c *dedupCache) forget(updates []*update.DNSUpdate) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, upd := range updates {
+		name := strings.ToLower(upd.Name)
+		c.records -= len(c.names[name])
+		delete(c.names, name)
+	}
+}
+
+// forgetNames forgets the records of names, lowercased and ending with a dot,
+// changed by another writer
+func (c *dedupCache) forgetNames(names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		c.records -= len(c.names[name])
+		delete(c.names, name)
+	}
+}
+
+// written remembers the records added by a message written to Kubernetes
+func (c *dedupCache) written(requester k8s.Requester, updates []*update.DNSUpdate) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.records+len(updates) > c.size {
+		c.prune(now)
+	}
+	for _, upd := range updates {
+		if upd.Type == update.UpdateTypeDelete || !c.tracks(upd) {
+			continue
+		}
+		name, record := dedupKey(requester, upd)
+		records, ok := c.names[name]
+		if !ok {
+			records = make(map[dedupRecord]time.Time)
+			c.names[name] = records
+		}
+		if _, ok := records[record]; !ok {
+			if c.records >= c.size {
+				continue
+			}
+			c.records++
+		}
+		records[record] = now
+	}
+}
+
+// prune forgets the records written before the window. The lock must be held.
+func (c *dedupCache) prune(now time.Time) {
+	for name, records := range c.names {
+		for record, written := range records {
+			if now.Sub(written) >= c.window {
+				delete(records, record)
+				c.records--
+			}
+		}
+		if len(records) == 0 {
+			delete(c.names, name)
+		}
+	}
+}
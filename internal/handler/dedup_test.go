@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// addressUpdate replaces the A record of a name
+func addressUpdate(name, ip string) *update.DNSUpdate {
+	return &update.DNSUpdate{Type: update.UpdateTypeUpdate, RecordType: dns.TypeA, Name: name, Zone: "example.com.", IP: net.ParseIP(ip), TTL: 300}
+}
+
+// trackAll tracks the records of every update
+func trackAll(*update.DNSUpdate) bool {
+	return true
+}
+
+func TestDedupCache(t *testing.T) {
+	router := k8s.Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+	other := k8s.Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}, KeyName: "router."}
+	renewal := []*update.DNSUpdate{addressUpdate("Host.example.com.", "192.0.2.10")}
+
+	tests := []struct {
+		name string
+		// before runs after the first write of renewal, at the time of the renewal
+		before    func(c *dedupCache, now *time.Time)
+		requester k8s.Requester
+		updates   []*update.DNSUpdate
+		expected  bool
+	}{
+		{name: "renewal", requester: router, updates: renewal, expected: true},
+		{name: "another requester", requester: other, updates: renewal},
+		{name: "another target", requester: router, updates: []*update.DNSUpdate{addressUpdate("host.example.com.", "192.0.2.11")}},
+		{name: "another TTL", requester: router, updates: []*update.DNSUpdate{{Type: update.UpdateTypeUpdate, RecordType: dns.TypeA, Name: "host.example.com.", Zone: "example.com.", IP: net.ParseIP("192.0.2.10"), TTL: 60}}},
+		{name: "delete", requester: router, updates: []*update.DNSUpdate{{Type: update.UpdateTypeDelete, RecordType: dns.TypeA, Name: "host.example.com.", Zone: "example.com."}}},
+		{
+			name: "after the window",
+			before: func(c *dedupCache, now *time.Time) {
+				*now = now.Add(time.Minute)
+			},
+			requester: router, updates: renewal,
+		},
+		{
+			name: "name written again",
+			before: func(c *dedupCache, now *time.Time) {
+				changed := []*update.DNSUpdate{addressUpdate("host.example.com.", "192.0.2.11")}
+				c.forget(changed)
+				c.written(other, changed)
+			},
+			requester: router, updates: renewal,
+		},
+		{
+			name: "name changed by another writer",
+			before: func(c *dedupCache, now *time.Time) {
+				c.forgetNames([]string{"host.example.com."})
+			},
+			requester: router, updates: renewal,
+		},
+		{
+			name: "another name changed by another writer",
+			before: func(c *dedupCache, now *time.Time) {
+				c.forgetNames([]string{"other.example.com."})
+			},
+			requester: router, updates: renewal, expected: true,
+		},
+		{
+			name: "untracked records",
+			before: func(c *dedupCache, now *time.Time) {
+				c.tracks = func(*update.DNSUpdate) bool { return false }
+			},
+			requester: router, updates: renewal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newDedupCache(time.Minute, 10, trackAll)
+			now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			c.now = func() time.Time { return now }
+			if c.seen(router, renewal) {
+				t.Fatal("Expected the first write not to be skipped")
+			}
+			c.forget(renewal)
+			c.written(router, renewal)
+
+			now = now.Add(30 * time.Second)
+			if tt.before != nil {
+				tt.before(c, &now)
+			}
+			if got := c.seen(tt.requester, tt.updates); got != tt.expected {
+				t.Errorf("Expected seen() = %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDedupCacheSize(t *testing.T) {
+	router := k8s.Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}}
+	c := newDedupCache(time.Minute, 2, trackAll)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	first := []*update.DNSUpdate{addressUpdate("a.example.com.", "192.0.2.1")}
+	second := []*update.DNSUpdate{addressUpdate("b.example.com.", "192.0.2.2")}
+	third := []*update.DNSUpdate{addressUpdate("c.example.com.", "192.0.2.3")}
+	c.written(router, first)
+	c.written(router, second)
+	// A full cache does not remember more records
+	c.written(router, third)
+	if c.records != 2 || c.seen(router, third) {
+		t.Fatalf("Expected the third record not to be remembered, got %d records", c.records)
+	}
+
+	// Records older than the window are pruned to make room
+	now = now.Add(time.Minute)
+	c.written(router, third)
+	if c.records != 1 || !c.seen(router, third) || c.seen(router, first) {
+		t.Errorf("Expected only the third record to be remembered, got %d records", c.records)
+	}
+
+	c.forgetNames([]string{"c.example.com."})
+	if c.records != 0 {
+		t.Errorf("Expected no record left, got %d", c.records)
+	}
+}
+
+func TestDedupCacheDisabled(t *testing.T) {
+	c := newDedupCache(0, 10, trackAll)
+	if c != nil {
+		t.Fatal("Expected no cache without window")
+	}
+	router := k8s.Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}}
+	renewal := []*update.DNSUpdate{addressUpdate("host.example.com.", "192.0.2.10")}
+	c.written(router, renewal)
+	if c.seen(router, renewal) {
+		t.Error("Expected a disabled cache to never skip a write")
+	}
+}
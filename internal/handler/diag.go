@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"github.com/tJouve/ddnsbridge4extdns/pkg/diag"
+)
+
+// recentErrorsSize is the number of errors answered to clients kept for diagnostics
+const recentErrorsSize = 50
+
+// State is the state of the handler reported in diagnostic dumps
+type State struct {
+	WriteSlots   SlotState    `json:"writeSlots"`
+	Pipeline     SlotState    `json:"pipeline"`
+	QueryCache   SlotState    `json:"queryCache"`
+	RecentErrors []diag.Error `json:"recentErrors"`
+}
+
+// SlotState reports how much of a bounded resource is in use; a zero Capacity is unbounded or disabled
+type SlotState struct {
+	InUse    int `json:"inUse"`
+	Capacity int `json:"capacity"`
+}
+
+// Diagnostics reports the queue depths, cache size and recent errors of the handler
+func (h *Handler) Diagnostics() interface{} {
+	return State{
+		WriteSlots:   SlotState{InUse: len(h.writeSlots), Capacity: cap(h.writeSlots)},
+		Pipeline:     h.pipeline.state(),
+		QueryCache:   h.cache.state(),
+		RecentErrors: h.errors.Recent(),
+	}
+}
+
+// state reports the messages being processed on pipelined connections
+func (p *pipeline) state() SlotState {
+	if p == nil {
+		return SlotState{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := SlotState{}
+	for _, pc := range p.conns {
+		state.InUse += pc.pending
+		state.Capacity += p.depth
+	}
+	return state
+}
+
+// state reports the number of cached query results
+func (c *queryCache) state() SlotState {
+	if c == nil {
+		return SlotState{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SlotState{InUse: c.order.Len(), Capacity: c.size}
+}
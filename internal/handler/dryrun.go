@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/admin"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// DryRunUpdate evaluates an UPDATE message as if it was sent from source and signed
+// with keyName, and returns the changes of resources it would make without applying
+// them. The signature is not verified: the key does not need to be configured yet.
+// Listener restrictions, client certificates and the reachability probe are not
+// evaluated, and nothing is counted or banned.
+func (h *Handler) DryRunUpdate(ctx context.Context, r *dns.Msg, source net.IP, keyName string) admin.DryRunResult {
+	h = h.current()
+	result := admin.DryRunResult{Changes: []k8s.Change{}}
+	refuse := func(err error) admin.DryRunResult {
+		result.Rcode, result.Kind, result.Error = dns.RcodeToString[dnserr.Rcode(err)], dnserr.Kind(err), err.Error()
+		return result
+	}
+	refuseRcode := func(rcode int, reason string) admin.DryRunResult {
+		result.Rcode, result.Kind, result.Error = dns.RcodeToString[rcode], "prerequisite", reason
+		return result
+	}
+
+	if r.Opcode != dns.OpcodeUpdate || len(r.Question) == 0 {
+		return refuse(fmt.Errorf("%w: not an UPDATE with a zone section", dnserr.ErrMalformed))
+	}
+	quirks := h.config.ClientQuirks.For(keyName, source)
+	if !acceptsZoneClass(r.Question[0].Qclass, quirks) {
+		return refuse(fmt.Errorf("%w: zone class %s", dnserr.ErrUnsupportedClass, dns.ClassToString[r.Question[0].Qclass]))
+	}
+	if h.config.IsTrapZone(r.Question[0].Name) {
+		// Updates of decoy zones are acknowledged and ignored
+		result.Rcode = dns.RcodeToString[dns.RcodeSuccess]
+		return result
+	}
+	h.quirkZone(r, quirks)
+	zone := r.Question[0].Name
+
+	unsigned := keyName == ""
+	if unsigned && !h.config.ZoneAllowsUnsigned(zone, source) {
+		return refuse(dnserr.ErrNotSigned)
+	}
+	if !h.zones.Allows(zone, keyName) {
+		return refuse(fmt.Errorf("%w: %s", dnserr.ErrZoneNotAllowed, zone))
+	}
+
+	updates, rejections, err := h.parserFor(quirks).ParseReport(r)
+	for _, rejection := range rejections {
+		result.Skipped = append(result.Skipped, rejection.String())
+	}
+	if err != nil {
+		return refuse(err)
+	}
+	updates = update.Coalesce(updates)
+	for _, upd := range updates {
+		if upd.RecordType == dns.TypePTR {
+			if upd.Zone = h.config.ZoneOf(upd.Name); upd.Zone == "" {
+				return refuse(fmt.Errorf("%w: %s", dnserr.ErrNotZone, upd.Name))
+			}
+		}
+		result.Updates = append(result.Updates, upd.String())
+	}
+
+	names := []string{zone}
+	for _, upd := range updates {
+		names = append(names, upd.Name)
+	}
+	for _, name := range names {
+		if retired := h.retired.Retiring(name); retired != "" {
+			return refuse(fmt.Errorf("%w: %s", dnserr.ErrZoneRetired, retired))
+		}
+	}
+	if unsigned {
+		for _, upd := range updates {
+			if !h.config.ZoneAllowsUnsigned(upd.Name, source) {
+				return refuse(fmt.Errorf("%w: %s", dnserr.ErrNotSigned, upd.Name))
+			}
+		}
+	} else if name, ok := h.authorizeZoneKey(keyName, zone, updates); !ok {
+		return refuse(fmt.Errorf("%w: key %s for %s", dnserr.ErrNotAuthorized, keyName, name))
+	}
+
+	if rcode := h.checkPrerequisites(r, zone); rcode != dns.RcodeSuccess {
+		return refuseRcode(rcode, "prerequisites not satisfied")
+	}
+	if len(updates) == 0 {
+		result.Rcode = dns.RcodeToString[dns.RcodeSuccess]
+		return result
+	}
+	if h.config.DHCIDEnforce {
+		if rcode := h.checkDHCIDOwnership(r, updates, zone); rcode != dns.RcodeSuccess {
+			return refuseRcode(rcode, "name owned by a client with another DHCID")
+		}
+	}
+
+	addr := &net.UDPAddr{IP: source}
+	requester := k8s.Requester{Addr: addr, KeyName: keyName}
+	if h.geoip != nil {
+		info := h.geoip.LookupAddr(addr)
+		requester.Country, requester.ASN = info.Country, info.ASN
+	}
+	changes, err := h.k8sClient.DryRunUpdates(ctx, requester, updates)
+	result.Changes = changes
+	if err != nil {
+		return refuse(err)
+	}
+	result.Rcode = dns.RcodeToString[dns.RcodeSuccess]
+	return result
+}
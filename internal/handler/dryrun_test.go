@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+func TestDryRunUpdate(t *testing.T) {
+	source := net.ParseIP("192.168.1.1")
+	tests := []struct {
+		name    string
+		msg     func() *dns.Msg
+		keyName string
+		rcode   int
+		kind    string
+		// action is the action of the only change, empty when nothing changes
+		action string
+	}{
+		{
+			name: "not an update",
+			msg: func() *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetQuestion("example.com.", dns.TypeSOA)
+				return msg
+			},
+			keyName: "router.",
+			rcode:   dns.RcodeFormatError, kind: "malformed",
+		},
+		{
+			name:  "not signed",
+			msg:   func() *dns.Msg { return updateMsg("new.example.com.", false) },
+			rcode: dns.RcodeRefused, kind: "not_signed",
+		},
+		{
+			name: "zone not allowed",
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.net.", false)
+				msg.Question[0].Name = "example.net."
+				return msg
+			},
+			keyName: "router.",
+			rcode:   dns.RcodeRefused, kind: "zone_not_allowed",
+		},
+		{
+			name: "prerequisite not satisfied",
+			msg: func() *dns.Msg {
+				msg := updateMsg("new.example.com.", false)
+				msg.RRsetUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "new.example.com.", Rrtype: dns.TypeA}}})
+				return msg
+			},
+			keyName: "router.",
+			rcode:   dns.RcodeNXRrset, kind: "prerequisite",
+		},
+		{
+			name:    "new name",
+			msg:     func() *dns.Msg { return updateMsg("new.example.com.", false) },
+			keyName: "router.",
+			rcode:   dns.RcodeSuccess, action: k8s.ChangeCreate,
+		},
+		{
+			name: "published name",
+			msg: func() *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetUpdate("example.com.")
+				rr, _ := dns.NewRR("host.example.com. 300 IN A 192.0.2.20")
+				msg.Insert([]dns.RR{rr})
+				return msg
+			},
+			keyName: "router.",
+			rcode:   dns.RcodeSuccess, action: k8s.ChangeUpdate,
+		},
+		{
+			name:    "same records",
+			msg:     func() *dns.Msg { return updateMsg("host.example.com.", false) },
+			keyName: "router.",
+			rcode:   dns.RcodeSuccess,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := newTestHandler(t, nil)
+			w := &testWriter{remote: udpClient}
+			h.serveDNS(w, updateMsg("host.example.com.", true))
+			if len(w.responses) != 1 || w.responses[0].Rcode != dns.RcodeSuccess {
+				t.Fatalf("Expected host.example.com. to be published, got %v", w.responses)
+			}
+			published := writes(api)
+
+			result := h.DryRunUpdate(context.Background(), tt.msg(), source, tt.keyName)
+			if result.Rcode != dns.RcodeToString[tt.rcode] || result.Kind != tt.kind {
+				t.Errorf("Expected %s (%s), got %s (%s): %s", dns.RcodeToString[tt.rcode], tt.kind, result.Rcode, result.Kind, result.Error)
+			}
+			switch {
+			case tt.action == "" && len(result.Changes) != 0:
+				t.Errorf("Expected no change, got %v", result.Changes)
+			case tt.action != "" && (len(result.Changes) != 1 || result.Changes[0].Action != tt.action):
+				t.Errorf("Expected a change of action %s, got %v", tt.action, result.Changes)
+			}
+			if got := writes(api); got != published {
+				t.Errorf("Expected the dry run to write nothing, got %d writes", got-published)
+			}
+		})
+	}
+}
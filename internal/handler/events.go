@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/kafka"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// SetEventProducer publishes every accepted update to Kafka
+func (h *Handler) SetEventProducer(producer *kafka.Producer) {
+	h.events = producer
+}
+
+// publishEvent publishes an accepted update
+func (h *Handler) publishEvent(requester k8s.Requester, upd *update.DNSUpdate) {
+	if h.events == nil {
+		return
+	}
+	targets := []string{}
+	switch {
+	case upd.IP != nil:
+		targets = append(targets, upd.IP.String())
+	case upd.Text != nil:
+		targets = append(targets, upd.Text...)
+	case upd.Target != "":
+		targets = append(targets, upd.Target)
+	}
+	h.events.Publish(kafka.Event{
+		Time:       time.Now().UTC(),
+		Action:     upd.Type.String(),
+		Name:       upd.Name,
+		Zone:       upd.Zone,
+		RecordType: dns.TypeToString[upd.RecordType],
+		TTL:        int64(upd.TTL),
+		Targets:    targets,
+		Requester:  requester.IP(),
+		Key:        requester.KeyName,
+	})
+}
@@ -1,11 +1,37 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/audit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ban"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/diag"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnstap"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/geoip"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/kafka"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/pcap"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/probe"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ratelimit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/replica"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/retire"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/sig0"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/tcplimit"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/tsig"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/zoneauth"
 )
 
 // Handler handles DNS UPDATE requests
@@ -13,19 +39,184 @@ type Handler struct {
 	config    *config.Config
 	k8sClient *k8s.Client
 	parser    *update.Parser
+	certACL   acl.ACL
+	banner    *ban.Banner
+	listener  *config.Listener
+	serials   *zoneSerials
+	cache     *queryCache
+	prober    probe.Prober
+	geoip     *geoip.Resolver
+	tap       *dnstap.Output
+	events    *kafka.Producer
+	audit     *audit.Log
+	journal   *k8s.Journal
+	capture   *pcap.Capture
+	zones     zoneauth.Authorizer
+	live      *liveConfig
+	sessions  *tcplimit.Limiter
+	rates     *ratelimit.Limiter
+	retired   *retire.Manager
+	tenants   *metrics.Tenants
+	keyring   *tsig.Keyring
+
+	sig0Messages *sig0.Messages
+
+	retransmits *retransmitCache
+
+	writeSlots writeSlots
+	pipeline   *pipeline
+	async      *asyncWriter
+	dedup      *dedupCache
+	errors     *diag.ErrorLog
 }
 
-// NewHandler creates a new DNS UPDATE handler
-func NewHandler(cfg *config.Config, k8sClient *k8s.Client) *Handler {
-	return &Handler{
+// NewHandler creates a new DNS UPDATE handler, reporting refused sources to
+// banner when it is not nil
+func NewHandler(cfg *config.Config, k8sClient *k8s.Client, banner *ban.Banner) *Handler {
+	parser := update.NewParser()
+	parser.ACMEChallenges = cfg.ACMEChallenges
+	parser.DHCP = cfg.WindowsDHCP
+	parser.DHCID = cfg.DHCIDEnforce
+	parser.Types = cfg.AcceptedRecordTypes()
+
+	h := &Handler{
 		config:    cfg,
 		k8sClient: k8sClient,
-		parser:    update.NewParser(),
+		parser:    parser,
+		certACL:   acl.New(cfg.CertACLs),
+		banner:    banner,
+		zones:     cfg.ZoneAuthorizer(),
+		serials:   newZoneSerials(),
+		cache:     newQueryCache(cfg.QueryCacheSize, cfg.QueryCacheTTL),
+
+		retransmits: newRetransmitCache(),
+
+		writeSlots: newWriteSlots(cfg.UpdateConcurrency),
+		pipeline:   newPipeline(cfg.TCPPipelineDepth),
+		errors:     diag.NewErrorLog(recentErrorsSize),
+	}
+	// Records are only deduplicated while the watch of the DNSEndpoints reports
+	// their changes, and when skipped renewals do not let them expire
+	h.dedup = newDedupCache(cfg.DedupWindow, cfg.DedupCacheSize, func(upd *update.DNSUpdate) bool {
+		return k8sClient.Watches(upd.Name, upd.Zone) && !k8sClient.ExpiresRecords(upd.Name)
+	})
+	if h.dedup != nil {
+		k8sClient.OnEndpointChange(h.dedup.forgetNames)
+	}
+	h.live = &liveConfig{config: cfg, zones: h.zones, certACL: h.certACL}
+	h.async = newAsyncWriter(cfg.AsyncWorkers, max(cfg.AsyncQueueSize/max(cfg.AsyncWorkers, 1), 1), cfg.AsyncQueueFull == config.AsyncQueueFullBlock, h.writeUpdates)
+	if cfg.ProbeNetwork != "" {
+		prober, err := probe.New(cfg.ProbeNetwork, cfg.ProbePort, cfg.ProbeTimeout)
+		if err != nil {
+			logrus.Errorf("Reachability probe disabled: %v", err)
+		} else {
+			h.prober = prober
+		}
 	}
+	return h
+}
+
+// SetGeoIP enriches the requester of applied updates with its country and autonomous system
+func (h *Handler) SetGeoIP(resolver *geoip.Resolver) {
+	h.geoip = resolver
+}
+
+// SetSessionLimiter bounds the TCP connections and transactions in flight of each key
+func (h *Handler) SetSessionLimiter(limiter *tcplimit.Limiter) {
+	h.sessions = limiter
+}
+
+// SetRateLimiter bounds the rate of the messages of each source address
+func (h *Handler) SetRateLimiter(limiter *ratelimit.Limiter) {
+	h.rates = limiter
+}
+
+// SetRetirements refuses the updates of the zones being retired
+func (h *Handler) SetRetirements(retirements *retire.Manager) {
+	h.retired = retirements
+}
+
+// SetKeyring signs the responses to requests verified with a previous secret of
+// the keyring with that secret
+func (h *Handler) SetKeyring(keyring *tsig.Keyring) {
+	h.keyring = keyring
+}
+
+// SetTenantMetrics counts the updates and errors of each tenant
+func (h *Handler) SetTenantMetrics(tenants *metrics.Tenants) {
+	h.tenants = tenants
+}
+
+// SetReplicator streams the zone serials to the standby replicas, and merges
+// the serials of the replicas this one follows
+func (h *Handler) SetReplicator(replicator *replica.Replicator) {
+	h.serials.replicator = replicator
+	replicator.Register(replica.KindSerial, h.serials)
+}
+
+// SetCapture records the responses to the messages matching a wire capture
+func (h *Handler) SetCapture(capture *pcap.Capture) {
+	h.capture = capture
+}
+
+// ForListener returns a handler restricted to the zones and keys of a listener
+func (h *Handler) ForListener(listener config.Listener) *Handler {
+	restricted := *h
+	restricted.listener = &listener
+	return &restricted
 }
 
 // ServeDNS implements the dns.Handler interface
 func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	if h.pipeline != nil && isTCP(w) {
+		h.pipeline.dispatch(w, r, h.serveDNS)
+		return
+	}
+	h.serveDNS(w, r)
+}
+
+// serveDNS answers a message
+func (h *Handler) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	h = h.current()
+	if h.tap != nil {
+		w = h.tapMessage(w, r)
+	}
+	if h.capture != nil {
+		w = h.capture.Wrap(w, r)
+	}
+	if r.Opcode == dns.OpcodeUpdate {
+		var observe func()
+		w, observe = timeUpdate(w, r)
+		defer observe()
+	}
+
+	// Sources over RATE_LIMIT are refused over TCP, and dropped over UDP where an
+	// answer would only feed a flood
+	if !h.rates.Allow(remoteIP(w.RemoteAddr()).String()) {
+		logrus.Debugf("Rate limited message from %s", w.RemoteAddr())
+		if !isTCP(w) {
+			metrics.RateLimitedRequests.WithLabelValues("dropped").Inc()
+			return
+		}
+		metrics.RateLimitedRequests.WithLabelValues("refused").Inc()
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		h.writeError(w, r, msg, dnserr.ErrRateLimited, verifiedTSIG(w, r))
+		return
+	}
+
+	// Only the sources of ALLOWED_SOURCES and, for the zones listed, ZONE_SOURCES
+	// get further, whatever their credentials
+	ip := remoteIP(w.RemoteAddr())
+	if !h.config.SourceAllowed(ip) || (r.Opcode == dns.OpcodeUpdate && !h.zoneSourcesAllow(r, ip)) {
+		logrus.Warnf("Refused message (opcode: %d) from %s: source not allowed", r.Opcode, w.RemoteAddr())
+		h.banner.Fail(w.RemoteAddr(), "source")
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		h.writeError(w, r, msg, dnserr.ErrSourceNotAllowed, verifiedTSIG(w, r))
+		return
+	}
+
 	tsigPresent := r.IsTsig() != nil
 	logrus.Debugf("Received message from %s: opcode=%d, hasQuestion=%d, hasTSIG=%v",
 		w.RemoteAddr(), r.Opcode, len(r.Question), tsigPresent)
@@ -40,112 +231,811 @@ func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	msg.SetReply(r)
 	msg.Authoritative = true
 
+	// With UNSIGNED_REQUESTS=refuse, only signed or certificate-authenticated clients get an answer
+	if h.refusesUnsigned(w, r) {
+		logrus.Warnf("Refused unsigned request (opcode: %d) from %s", r.Opcode, w.RemoteAddr())
+		if r.Opcode == dns.OpcodeUpdate {
+			metrics.UnsignedUpdates.WithLabelValues("refused").Inc()
+		}
+		h.banner.Fail(w.RemoteAddr(), "notsigned")
+		h.writeError(w, r, msg, dnserr.ErrNotSigned, nil)
+		return
+	}
+
+	// Answer SOA queries of the served zones
+	if r.Opcode == dns.OpcodeQuery && h.answersQuery(w, r) && h.serveQuery(w, r, msg) {
+		return
+	}
+
 	// Only process UPDATE opcodes
 	if r.Opcode != dns.OpcodeUpdate {
 		logrus.Warnf("Rejected non-UPDATE request (opcode: %d) from %s", r.Opcode, w.RemoteAddr())
-		msg.SetRcode(r, dns.RcodeNotImplemented)
-		w.WriteMsg(msg)
+		h.writeUnsupported(w, r, msg)
 		return
 	}
 
-	// Enforce TSIG presence - the DNS server handles automatic verification when TsigSecret is set
-	// If the request reaches here with TSIG, it has already been verified by the server
-	// We just need to ensure TSIG is present (reject requests without TSIG)
+	// Updates of decoy zones are accepted and ignored, whatever their credentials
+	if len(r.Question) > 0 && h.config.IsTrapZone(r.Question[0].Name) {
+		h.serveTrap(w, r, msg)
+		return
+	}
+
+	// A verified TLS client certificate listed in CERT_ACLS is a credential on its own
+	certIdentities := h.knownCertIdentities(w)
+
+	// Enforce TSIG presence - the DNS server handles automatic verification when TsigProvider is set
+	// We just need to ensure TSIG is present (reject requests without TSIG), unless the
+	// zone accepts unsigned updates from the client network
 	tsigRecord := r.IsTsig()
+	// A SIG(0) signature of a key of SIG0_KEYS authenticates the request like a TSIG
+	sig0Key := ""
 	if tsigRecord == nil {
+		var err error
+		if sig0Key, err = h.verifySIG0(w, r); err != nil {
+			logrus.Warnf("Rejected UPDATE from %s: %v", w.RemoteAddr(), err)
+			h.banner.Fail(w.RemoteAddr(), "sig0")
+			h.writeError(w, r, msg, err, nil)
+			return
+		}
+	}
+	unsigned := tsigRecord == nil && sig0Key == "" && len(certIdentities) == 0
+	if unsigned && (len(r.Question) == 0 || !h.config.ZoneAllowsUnsigned(r.Question[0].Name, remoteIP(w.RemoteAddr()))) {
 		logrus.Warnf("Rejected UPDATE request without TSIG from %s", w.RemoteAddr())
-		msg.SetRcode(r, dns.RcodeRefused)
-		w.WriteMsg(msg)
+		metrics.UnsignedUpdates.WithLabelValues("refused").Inc()
+		h.banner.Fail(w.RemoteAddr(), "notsigned")
+		h.writeError(w, r, msg, dnserr.ErrNotSigned, nil)
 		return
 	}
 
-	// TSIG is present and was already verified by the DNS server
-	requestMAC := tsigRecord.MAC
-	logrus.Debugf("Request authenticated with TSIG from key: %s", tsigRecord.Hdr.Name)
+	// signer is the verified TSIG of the request, responses are signed with its key
+	var signer *dns.TSIG
+	keyName := ""
+	if tsigRecord != nil {
+		// The DNS server verified the TSIG before calling the handler, check the outcome,
+		// the error response carries the TSIG error of RFC 8945
+		if err := h.checkTSIG(w, tsigRecord); err != nil {
+			h.writeError(w, r, msg, err, tsigRecord)
+			return
+		}
+
+		// TSIG is present and was verified by the DNS server
+		signer = tsigRecord
+		keyName = tsigRecord.Hdr.Name
+		logrus.Debugf("Request authenticated with TSIG from key: %s", tsigRecord.Hdr.Name)
+	} else if sig0Key != "" {
+		// Responses stay unsigned, the server holds no private key
+		keyName = sig0Key
+	}
+
+	// Tolerate the deviations of the known clients, by key or network
+	quirks := h.config.ClientQuirks.For(keyName, remoteIP(w.RemoteAddr()))
+	if quirks.Retransmits && !isTCP(w) {
+		var first *dns.Msg
+		var retransmitted bool
+		if w, first, retransmitted = h.retransmits.track(w, r); retransmitted {
+			logrus.Infof("Retransmission of UPDATE %d from %s, not applied again", r.Id, w.RemoteAddr())
+			if first != nil {
+				h.writeResponse(w, first, signer)
+			}
+			return
+		}
+	}
+
+	// Bound the TCP connections and the transactions in flight of each key
+	if keyName != "" && h.sessions != nil && isTCP(w) {
+		key := strings.ToLower(strings.TrimSuffix(keyName, "."))
+		if !h.sessions.BindKey(w.LocalAddr(), w.RemoteAddr(), key) {
+			h.writeError(w, r, msg, fmt.Errorf("%w: connections of key %s", dnserr.ErrSessionLimit, keyName), signer)
+			w.Close()
+			return
+		}
+		if !h.sessions.Begin(key) {
+			logrus.Warnf("Refused UPDATE from %s: key %s has too many transactions in flight", w.RemoteAddr(), keyName)
+			h.writeError(w, r, msg, fmt.Errorf("%w: transactions of key %s", dnserr.ErrSessionLimit, keyName), signer)
+			return
+		}
+		defer h.sessions.End(key)
+	}
 
 	// Validate zone
 	if len(r.Question) == 0 {
 		logrus.Warnf("UPDATE message has no zone section from %s", w.RemoteAddr())
-		msg.SetRcode(r, dns.RcodeFormatError)
-		h.writeResponse(w, msg, requestMAC)
+		h.writeError(w, r, msg, dnserr.ErrMalformed, signer)
+		return
+	}
+
+	// Only the IN class is supported for the zone section
+	if !acceptsZoneClass(r.Question[0].Qclass, quirks) {
+		logrus.Warnf("Rejected UPDATE with unsupported zone class %s from %s",
+			dns.ClassToString[r.Question[0].Qclass], w.RemoteAddr())
+		if h.config.UnsupportedResponse == config.UnsupportedResponseDrop {
+			return
+		}
+		msg.SetRcode(r, h.unsupportedRcode())
+		h.writeResponse(w, msg, signer)
 		return
 	}
 
+	h.quirkZone(r, quirks)
 	zone := r.Question[0].Name
-	if !h.config.IsZoneAllowed(zone) {
+	if !h.zones.Allows(zone, keyName) {
 		logrus.Warnf("Zone %s not allowed from %s", zone, w.RemoteAddr())
-		msg.SetRcode(r, dns.RcodeRefused)
-		h.writeResponse(w, msg, requestMAC)
+		h.banner.Fail(w.RemoteAddr(), "zone")
+		h.writeError(w, r, msg, fmt.Errorf("%w: %s", dnserr.ErrZoneNotAllowed, zone), signer)
+		return
+	}
+	if h.listener != nil && !h.listener.AllowsZone(zone) {
+		logrus.Warnf("Zone %s not served by listener %s, from %s", zone, h.listener.Addr, w.RemoteAddr())
+		h.banner.Fail(w.RemoteAddr(), "zone")
+		h.writeError(w, r, msg, fmt.Errorf("%w on listener %s: %s", dnserr.ErrZoneNotAllowed, h.listener.Addr, zone), signer)
 		return
 	}
 
 	// Parse updates
-	updates, err := h.parser.Parse(r)
+	updates, rejections, err := h.parserFor(quirks).ParseReport(r)
+	h.reportRejections(w, zone, len(r.Ns), rejections)
 	if err != nil {
 		logrus.Errorf("Failed to parse UPDATE from %s: %v", w.RemoteAddr(), err)
-		msg.SetRcode(r, dns.RcodeFormatError)
-		h.writeResponse(w, msg, requestMAC)
+		h.writeError(w, r, msg, err, signer)
 		return
 	}
 
-	// Apply updates to Kubernetes
+	// Group the updates of the message, and route PTR records to their reverse zone
+	updates = update.Coalesce(updates)
 	for _, upd := range updates {
-		logrus.Debugf("Processing update from %s: %s", w.RemoteAddr(), upd.String())
-		updated, err := h.k8sClient.ApplyUpdate(w.RemoteAddr(), upd)
-		if err != nil {
-			logrus.Errorf("Failed to apply update to Kubernetes: %v", err)
-			msg.SetRcode(r, dns.RcodeServerFailure)
-			h.writeResponse(w, msg, requestMAC)
+		if upd.RecordType != dns.TypePTR {
+			continue
+		}
+		upd.Zone = h.config.ZoneOf(upd.Name)
+		if upd.Zone == "" {
+			logrus.Warnf("Rejected PTR update of %s outside of the allowed zones from %s", upd.Name, w.RemoteAddr())
+			h.writeError(w, r, msg, fmt.Errorf("%w: %s", dnserr.ErrNotZone, upd.Name), signer)
 			return
 		}
-		if updated {
-			logrus.Infof("Successfully applied update: %s", upd.String())
+		if h.listener != nil && !h.listener.AllowsZone(upd.Zone) {
+			logrus.Warnf("Rejected PTR update of %s not served by listener %s from %s", upd.Name, h.listener.Addr, w.RemoteAddr())
+			h.writeError(w, r, msg, fmt.Errorf("%w on listener %s: %s", dnserr.ErrZoneNotAllowed, h.listener.Addr, upd.Zone), signer)
+			return
 		}
 	}
 
+	// Zones being decommissioned accept no more updates
+	names := []string{zone}
+	for _, upd := range updates {
+		names = append(names, upd.Name)
+	}
+	for _, name := range names {
+		if retired := h.retired.Retiring(name); retired != "" {
+			logrus.Warnf("Rejected update of %s in retired zone %s from %s", name, retired, w.RemoteAddr())
+			h.writeError(w, r, msg, fmt.Errorf("%w: %s", dnserr.ErrZoneRetired, retired), signer)
+			return
+		}
+	}
+
+	// Unsigned updates may only touch the zones accepting them from the client network
+	if unsigned {
+		for _, upd := range updates {
+			if !h.config.ZoneAllowsUnsigned(upd.Name, remoteIP(w.RemoteAddr())) {
+				logrus.Warnf("Rejected unsigned update of %s from %s", upd.Name, w.RemoteAddr())
+				metrics.UnsignedUpdates.WithLabelValues("refused").Inc()
+				h.banner.Fail(w.RemoteAddr(), "notsigned")
+				h.writeError(w, r, msg, fmt.Errorf("%w: %s", dnserr.ErrNotSigned, upd.Name), signer)
+				return
+			}
+		}
+		logrus.Debugf("Unsigned request accepted for zone %s from %s", zone, w.RemoteAddr())
+		metrics.UnsignedUpdates.WithLabelValues("accepted").Inc()
+	}
+
+	// Enforce the names a client certificate may update
+	if len(certIdentities) > 0 {
+		identity, ok := h.authorizeCertificate(certIdentities, updates)
+		if !ok {
+			logrus.Warnf("Rejected UPDATE from %s: certificate %v is not allowed to update these names", w.RemoteAddr(), certIdentities)
+			h.banner.Fail(w.RemoteAddr(), "certificate")
+			h.writeError(w, r, msg, fmt.Errorf("%w: certificate %v", dnserr.ErrNotAuthorized, certIdentities), signer)
+			return
+		}
+		logrus.Debugf("Request authorized by TLS client certificate: %s", identity)
+		if keyName == "" {
+			keyName = identity
+		}
+	}
+
+	// Dedicated listeners only accept their own keys
+	if h.listener != nil && !h.listener.AllowsKey(keyName) {
+		logrus.Warnf("Key %s not accepted by listener %s, from %s", keyName, h.listener.Addr, w.RemoteAddr())
+		h.banner.Fail(w.RemoteAddr(), "key")
+		h.writeError(w, r, msg, fmt.Errorf("%w: key %s on listener %s", dnserr.ErrNotAuthorized, keyName, h.listener.Addr), signer)
+		return
+	}
+
+	// Zones listed in ZONE_KEYS only accept their own keys, and keys listed in
+	// KEY_SCOPES only update their own zones
+	if !unsigned {
+		if name, ok := h.authorizeZoneKey(keyName, zone, updates); !ok {
+			logrus.Warnf("Key %s not allowed to update %s, from %s", keyName, name, w.RemoteAddr())
+			h.banner.Fail(w.RemoteAddr(), "key")
+			h.writeError(w, r, msg, fmt.Errorf("%w: key %s for %s", dnserr.ErrNotAuthorized, keyName, name), signer)
+			return
+		}
+	}
+
+	// Check the prerequisites of the message
+	if rcode := h.checkPrerequisites(r, zone); rcode != dns.RcodeSuccess {
+		logrus.Infof("UPDATE prerequisites not satisfied from %s: %s", w.RemoteAddr(), dns.RcodeToString[rcode])
+		msg.SetRcode(r, rcode)
+		h.writeResponse(w, msg, signer)
+		return
+	}
+
+	// A message without updates is a ping: clients verify their key and prerequisites
+	// before registering
+	if len(updates) == 0 {
+		logrus.Infof("Prerequisite-only UPDATE for zone %s from %s (key: %s) succeeded", zone, w.RemoteAddr(), keyName)
+		msg.SetRcode(r, dns.RcodeSuccess)
+		h.writeResponse(w, msg, signer)
+		return
+	}
+
+	// Refuse names claimed by another client (RFC 4701)
+	if h.config.DHCIDEnforce {
+		if rcode := h.checkDHCIDOwnership(r, updates, zone); rcode != dns.RcodeSuccess {
+			logrus.Warnf("Rejected UPDATE from %s: name owned by a client with another DHCID", w.RemoteAddr())
+			metrics.DHCIDConflicts.Inc()
+			msg.SetRcode(r, rcode)
+			h.writeResponse(w, msg, signer)
+			return
+		}
+	}
+
+	// Refuse or flag records pointing at targets that do not answer
+	if h.prober != nil {
+		if err := h.probeTargets(updates); err != nil {
+			logrus.Warnf("Rejected UPDATE from %s: %v", w.RemoteAddr(), err)
+			h.writeError(w, r, msg, err, signer)
+			return
+		}
+	}
+
+	// Apply updates to Kubernetes
+	requester := k8s.Requester{Addr: w.RemoteAddr(), KeyName: keyName}
+	if h.geoip != nil {
+		info := h.geoip.LookupAddr(w.RemoteAddr())
+		requester.Country, requester.ASN = info.Country, info.ASN
+		logrus.Debugf("Requester %s located in %q, AS%d %s", w.RemoteAddr(), info.Country, info.ASN, info.Organization)
+	}
+	if err := h.applyUpdates(requester, updates); err != nil {
+		logrus.Errorf("Failed to apply update to Kubernetes: %v", err)
+		h.writeError(w, r, msg, err, signer)
+		return
+	}
+
 	// Success response
 	msg.SetRcode(r, dns.RcodeSuccess)
-	h.writeResponse(w, msg, requestMAC)
-}
-
-// writeResponse writes a DNS response with TSIG signing if the request had TSIG
-func (h *Handler) writeResponse(w dns.ResponseWriter, msg *dns.Msg, requestMAC string) {
-	// If the request had TSIG, we need to sign the response
-	if requestMAC != "" {
-		// Add TSIG to the response
-		// The key name should end with a dot (FQDN)
-		keyName := h.config.TSIGKey
-		if keyName[len(keyName)-1] != '.' {
-			keyName = keyName + "."
-		}
-		algorithm := dns.HmacSHA256
-		switch h.config.TSIGAlgorithm {
-		case "hmac-sha1":
-			algorithm = dns.HmacSHA1
-		case "hmac-sha256":
-			algorithm = dns.HmacSHA256
-		case "hmac-sha512":
-			algorithm = dns.HmacSHA512
-		case "hmac-md5":
-			algorithm = dns.HmacMD5
-		}
-
-		// Set TSIG parameters on the message
-		msg.SetTsig(keyName, algorithm, 300, 0)
-
-		// Sign the message using the request MAC for chaining
-		// dns.TsigGenerate returns the packed signed message
-		buf, _, err := dns.TsigGenerate(msg, h.config.TSIGSecret, requestMAC, false)
+	h.writeResponse(w, msg, signer)
+}
+
+// serveTrap logs an update of a decoy zone and acknowledges it without applying it
+func (h *Handler) serveTrap(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg) {
+	zone := r.Question[0].Name
+
+	// Tell apart scanners from clients holding a valid key
+	var signer *dns.TSIG
+	keyName := ""
+	authenticated := false
+	if tsig := r.IsTsig(); tsig != nil {
+		keyName = tsig.Hdr.Name
+		if w.TsigStatus() == nil {
+			authenticated = true
+			signer = tsig
+		}
+	}
+
+	names := make([]string, 0, len(r.Ns))
+	for _, rr := range r.Ns {
+		names = append(names, rr.Header().Name)
+	}
+
+	metrics.TrapUpdates.WithLabelValues(zone, strconv.FormatBool(authenticated)).Inc()
+	logrus.WithFields(logrus.Fields{
+		"zone":          zone,
+		"source":        w.RemoteAddr().String(),
+		"key":           keyName,
+		"authenticated": authenticated,
+		"names":         names,
+	}).Error("TRAP: UPDATE received for decoy zone, credentials may be compromised or the endpoint scanned")
+
+	msg.SetRcode(r, dns.RcodeSuccess)
+	h.writeResponse(w, msg, signer)
+}
+
+// reportRejections logs the records of an update section that were skipped, and
+// counts them by type and reason
+func (h *Handler) reportRejections(w dns.ResponseWriter, zone string, total int, rejections []update.Rejection) {
+	if len(rejections) == 0 {
+		return
+	}
+	skipped := make([]string, 0, len(rejections))
+	for _, rejection := range rejections {
+		metrics.RejectedRecords.WithLabelValues(rejection.Type, rejection.Reason).Inc()
+		skipped = append(skipped, rejection.String())
+	}
+	logrus.WithFields(logrus.Fields{
+		"zone":     zone,
+		"source":   w.RemoteAddr().String(),
+		"rejected": skipped,
+	}).Warnf("Skipped %d of %d record(s) of UPDATE from %s", len(rejections), total, w.RemoteAddr())
+}
+
+// knownCertIdentities returns the names of a verified TLS client certificate
+// that have an entry in the certificate ACL
+func (h *Handler) knownCertIdentities(w dns.ResponseWriter) []string {
+	stater, ok := w.(dns.ConnectionStater)
+	if !ok {
+		return nil
+	}
+	state := stater.ConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	identities := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != "" && h.certACL.Knows(name) {
+			identities = append(identities, name)
+		}
+	}
+	return identities
+}
+
+// authorizeCertificate returns the certificate identity allowed to apply all updates
+func (h *Handler) authorizeCertificate(identities []string, updates []*update.DNSUpdate) (string, bool) {
+	for _, identity := range identities {
+		allowed := true
+		for _, upd := range updates {
+			if !h.certACL.Allows(identity, upd.Name) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// authorizeZoneKey checks if a key may update the zone and every name of the updates,
+// per ZONE_KEYS and KEY_SCOPES, returning the first name it may not update
+func (h *Handler) authorizeZoneKey(keyName, zone string, updates []*update.DNSUpdate) (string, bool) {
+	names := []string{zone}
+	for _, upd := range updates {
+		names = append(names, upd.Name)
+	}
+	for _, name := range names {
+		if !h.config.ZoneAllowsKey(name, keyName) || !h.config.KeyAllowsName(keyName, name) {
+			return name, false
+		}
+	}
+	return "", true
+}
+
+// zoneSourcesAllow checks ZONE_SOURCES for the zone and every name of an update,
+// before it is parsed
+func (h *Handler) zoneSourcesAllow(r *dns.Msg, ip net.IP) bool {
+	if len(h.config.ZoneSources) == 0 {
+		return true
+	}
+	if len(r.Question) > 0 && !h.config.ZoneAllowsSource(r.Question[0].Name, ip) {
+		return false
+	}
+	for _, rr := range r.Ns {
+		if !h.config.ZoneAllowsSource(rr.Header().Name, ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteIP returns the IP of a client address, nil when it has none
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// checkPrerequisites evaluates the RFC 2136 prerequisites against the published records.
+// Records published by other sources than the bridge are not seen: a name is only in
+// use when the bridge publishes records for it.
+func (h *Handler) checkPrerequisites(r *dns.Msg, zone string) int {
+	// Value-dependent prerequisites must match the whole RRset, collect them by name and type
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	published := make(map[rrsetKey][]string)
+	expected := make(map[rrsetKey][]string)
+
+	for _, rr := range r.Answer {
+		header := rr.Header()
+		if header.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if (header.Class == dns.ClassANY || header.Class == dns.ClassNONE) && header.Rdlength != 0 {
+			return dns.RcodeFormatError
+		}
+		// RFC 2136 section 3.2.5: prerequisites name records of the zone section
+		if !dns.IsSubDomain(zone, header.Name) {
+			return dns.RcodeNotZone
+		}
+		rrsets, err := h.k8sClient.LookupRRsets(header.Name, zone)
 		if err != nil {
-			logrus.Errorf("Failed to generate TSIG for response: %v", err)
-			w.WriteMsg(msg)
-			return
+			logrus.Errorf("Failed to check prerequisites of %s: %v", header.Name, err)
+			return dns.RcodeServerFailure
+		}
+		exists := len(rrsets) > 0
+		typeExists := len(rrsets[header.Rrtype]) > 0
+
+		switch header.Class {
+		case dns.ClassANY:
+			if header.Rrtype == dns.TypeANY && !exists {
+				return dns.RcodeNameError
+			}
+			if header.Rrtype != dns.TypeANY && !typeExists {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if header.Rrtype == dns.TypeANY && exists {
+				return dns.RcodeYXDomain
+			}
+			if header.Rrtype != dns.TypeANY && typeExists {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			if !typeExists {
+				return dns.RcodeNXRrset
+			}
+			key := rrsetKey{name: strings.ToLower(header.Name), rrtype: header.Rrtype}
+			published[key] = rrsets[header.Rrtype]
+			expected[key] = append(expected[key], rrValues(rr)...)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for key, values := range expected {
+		if !sameStrings(published[key], values) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// checkDHCIDOwnership checks that the updates of names with a stored DHCID carry the
+// same DHCID, either as a prerequisite or in the update section
+func (h *Handler) checkDHCIDOwnership(r *dns.Msg, updates []*update.DNSUpdate, zone string) int {
+	// DHCIDs presented by the message, by name
+	presented := make(map[string]string)
+	for _, rr := range r.Answer {
+		if dhcid, ok := rr.(*dns.DHCID); ok && dhcid.Hdr.Class == dns.ClassINET {
+			presented[strings.ToLower(dhcid.Hdr.Name)] = dhcid.Digest
+		}
+	}
+	for _, upd := range updates {
+		if upd.RecordType == dns.TypeDHCID && upd.Type != update.UpdateTypeDelete {
+			if _, ok := presented[strings.ToLower(upd.Name)]; !ok {
+				presented[strings.ToLower(upd.Name)] = upd.Target
+			}
+		}
+	}
+
+	checked := make(map[string]bool)
+	for _, upd := range updates {
+		name := strings.ToLower(upd.Name)
+		if upd.RecordType == dns.TypePTR || upd.RecordType == dns.TypeTXT || checked[name] {
+			continue
 		}
+		checked[name] = true
 
-		// Write the signed response directly
-		w.Write(buf)
+		rrsets, err := h.k8sClient.LookupRRsets(upd.Name, zone)
+		if err != nil {
+			logrus.Errorf("Failed to check DHCID of %s: %v", upd.Name, err)
+			return dns.RcodeServerFailure
+		}
+		stored := rrsets[dns.TypeDHCID]
+		if len(stored) == 0 {
+			continue
+		}
+		if presented[name] != stored[0] {
+			logrus.Debugf("DHCID of %s does not match the owner", upd.Name)
+			return dns.RcodeYXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// probeTargets probes the target address of the added records. Unreachable targets fail
+// the update, or are flagged on their record with PROBE_ACTION=flag.
+func (h *Handler) probeTargets(updates []*update.DNSUpdate) error {
+	failures := make(map[string]error)
+	for _, upd := range updates {
+		if upd.Type == update.UpdateTypeDelete || upd.IP == nil {
+			continue
+		}
+		target := upd.IP.String()
+		err, probed := failures[target]
+		if !probed {
+			err = h.prober.Probe(context.Background(), upd.IP)
+			failures[target] = err
+		}
+		if err == nil {
+			continue
+		}
+
+		metrics.ProbeFailures.WithLabelValues(h.config.ProbeAction).Inc()
+		if h.config.ProbeAction != config.ProbeActionFlag {
+			return fmt.Errorf("%w: %s -> %s: %v", dnserr.ErrTargetUnreachable, upd.Name, target, err)
+		}
+		logrus.Warnf("Publishing %s flagged as unreachable: %v", upd.Name, err)
+		upd.Unreachable = true
+	}
+	return nil
+}
+
+// rrValues returns the values of a record as stored in a DNSEndpoint
+func rrValues(rr dns.RR) []string {
+	switch v := rr.(type) {
+	case *dns.A:
+		return []string{v.A.String()}
+	case *dns.AAAA:
+		return []string{v.AAAA.String()}
+	case *dns.TXT:
+		return v.Txt
+	case *dns.PTR:
+		return []string{v.Ptr}
+	case *dns.DHCID:
+		return []string{v.Digest}
+	case *dns.SRV:
+		return []string{fmt.Sprintf("%d %d %d %s", v.Priority, v.Weight, v.Port, v.Target)}
+	case *dns.MX:
+		return []string{fmt.Sprintf("%d %s", v.Preference, v.Mx)}
+	case *dns.NS:
+		return []string{v.Ns}
+	}
+	return []string{rr.String()}
+}
+
+// sameStrings checks if two slices hold the same strings regardless of order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, v := range a {
+		counts[v]++
+	}
+	for _, v := range b {
+		counts[v]--
+		if counts[v] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTSIG checks the TSIG verification status of a request, accepting signatures made
+// outside of their fudge when the clock skew is within the configured tolerance
+func (h *Handler) checkTSIG(w dns.ResponseWriter, record *dns.TSIG) error {
+	now := time.Now()
+	result, err := tsig.Verify(w.TsigStatus(), record, now, h.config.TSIGSkewTolerance)
+	metrics.ObserveMessage(metrics.TSIGClockSkew, result.Skew.Seconds(), w.RemoteAddr().String(), record.OrigId)
+
+	if err == nil {
+		if result.Tolerated {
+			metrics.TSIGSkewTolerated.Inc()
+			logrus.Warnf("Accepted UPDATE from %s (key %s) with clock skew %s beyond its fudge %ds, within TSIG_SKEW_TOLERANCE %s",
+				w.RemoteAddr(), record.Hdr.Name, result.Skew.Round(time.Second), record.Fudge, h.config.TSIGSkewTolerance)
+		}
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, dnserr.ErrTSIGBadTime):
+		metrics.TSIGFailures.WithLabelValues("badtime").Inc()
+		h.banner.Fail(w.RemoteAddr(), "badtime")
+		logrus.Warnf("Rejected UPDATE from %s (key %s): TSIG BADTIME, clock skew %s exceeds fudge %ds and TSIG_SKEW_TOLERANCE %s "+
+			"(client time %s, server time %s); check the client clock (NTP) or raise TSIG_SKEW_TOLERANCE",
+			w.RemoteAddr(), record.Hdr.Name, result.Skew.Round(time.Second), record.Fudge, h.config.TSIGSkewTolerance,
+			time.Unix(int64(record.TimeSigned), 0).UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	case errors.Is(err, dnserr.ErrTSIGKeyUnknown):
+		metrics.TSIGFailures.WithLabelValues("badkey").Inc()
+		h.banner.Fail(w.RemoteAddr(), "badkey")
+		logrus.Warnf("Rejected UPDATE from %s: TSIG BADKEY: %v", w.RemoteAddr(), err)
+	default:
+		metrics.TSIGFailures.WithLabelValues("badsig").Inc()
+		h.banner.Fail(w.RemoteAddr(), "badsig")
+		logrus.Warnf("Rejected UPDATE from %s: TSIG BADSIG: %v", w.RemoteAddr(), err)
+	}
+	return err
+}
+
+// MsgAcceptFunc accepts queries, notifies and UPDATE opcodes, ignores responses and
+// handles any other opcode according to the configured unsupported response
+func (h *Handler) MsgAcceptFunc(dh dns.Header) dns.MsgAcceptAction {
+	// QR flag (response) is the most significant bit (1<<15 == 0x8000)
+	if dh.Bits&0x8000 != 0 { // is a response
+		return dns.MsgIgnore
+	}
+	opcode := int((dh.Bits >> 11) & 0xF)
+	// Oversized updates are refused from their header, before their records are unpacked
+	if limit := h.current().config.MaxUpdateRecords; opcode == dns.OpcodeUpdate && limit > 0 && int(dh.Ancount)+int(dh.Nscount) > limit {
+		logrus.Warnf("Refused UPDATE of %d prerequisites and %d updates, over MAX_UPDATE_RECORDS of %d", dh.Ancount, dh.Nscount, limit)
+		metrics.OversizedUpdates.Inc()
+		return dns.MsgReject
+	}
+	if opcode == dns.OpcodeQuery || opcode == dns.OpcodeNotify || opcode == dns.OpcodeUpdate {
+		return dns.MsgAccept
+	}
+
+	switch h.current().config.UnsupportedResponse {
+	case config.UnsupportedResponseDrop:
+		return dns.MsgIgnore
+	case config.UnsupportedResponseRefused:
+		// The accept func can only answer FORMERR or NOTIMP, let ServeDNS answer REFUSED
+		return dns.MsgAccept
+	default:
+		return dns.MsgRejectNotImplemented
+	}
+}
+
+// unsupportedRcode returns the rcode configured for unsupported opcodes and classes
+func (h *Handler) unsupportedRcode() int {
+	if h.config.UnsupportedResponse == config.UnsupportedResponseRefused {
+		return dns.RcodeRefused
+	}
+	return dns.RcodeNotImplemented
+}
+
+// writeUnsupported answers an unsupported request with the configured rcode, or not at all
+func (h *Handler) writeUnsupported(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg) {
+	if h.config.UnsupportedResponse == config.UnsupportedResponseDrop {
+		logrus.Debugf("Dropping unsupported request from %s without answer", w.RemoteAddr())
+		return
+	}
+	msg.SetRcode(r, h.unsupportedRcode())
+	h.writeResponse(w, msg, verifiedTSIG(w, r))
+}
+
+// verifiedTSIG returns the TSIG of a request when the server verified it, or nil
+func verifiedTSIG(w dns.ResponseWriter, r *dns.Msg) *dns.TSIG {
+	if tsig := r.IsTsig(); tsig != nil && w.TsigStatus() == nil {
+		return tsig
+	}
+	return nil
+}
+
+// refusesUnsigned checks if a request is refused by UNSIGNED_REQUESTS=refuse: it has no
+// TSIG, no known client certificate and is not an update of a decoy zone
+func (h *Handler) refusesUnsigned(w dns.ResponseWriter, r *dns.Msg) bool {
+	if h.config.UnsignedRequests != config.UnsignedRequestsRefuse || r.IsTsig() != nil {
+		return false
+	}
+	// SIG(0) signatures are verified with the update
+	if sig0.Signature(r) != nil && len(h.config.SIG0Keys) > 0 {
+		return false
+	}
+	if r.Opcode == dns.OpcodeUpdate && len(r.Question) > 0 && h.config.IsTrapZone(r.Question[0].Name) {
+		return false
+	}
+	return len(h.knownCertIdentities(w)) == 0
+}
+
+// writeError answers a failed update with the rcode of its error, and the extended DNS
+// error (RFC 8914) when the client supports EDNS. A TSIG failure is reported in the
+// TSIG error field of the response.
+func (h *Handler) writeError(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg, err error, request *dns.TSIG) {
+	metrics.UpdateErrors.WithLabelValues(dnserr.Kind(err)).Inc()
+	if h.tenants != nil {
+		zone, keyName := "", ""
+		if len(r.Question) > 0 {
+			zone = r.Question[0].Name
+		}
+		if tsig := r.IsTsig(); tsig != nil {
+			keyName = tsig.Hdr.Name
+		}
+		h.tenants.Error(zone, keyName, remoteIP(w.RemoteAddr()).String(), dnserr.Kind(err))
+	}
+	h.errors.Record(dnserr.Kind(err), fmt.Sprintf("%s: %v", w.RemoteAddr(), err))
+	msg.SetRcode(r, dnserr.Rcode(err))
+	if opt := r.IsEdns0(); opt != nil {
+		if ede, ok := dnserr.ExtendedError(err); ok {
+			msg.SetEdns0(opt.UDPSize(), false)
+			reply := msg.IsEdns0()
+			reply.Option = append(reply.Option, ede)
+		}
+	}
+	if request == nil {
+		w.WriteMsg(msg)
+		return
+	}
+	h.writeSigned(w, msg, request, tsigError(err))
+}
+
+// writeResponse writes a DNS response, signed with the key of the request TSIG when
+// the request was signed
+func (h *Handler) writeResponse(w dns.ResponseWriter, msg *dns.Msg, request *dns.TSIG) {
+	if request == nil {
+		w.WriteMsg(msg)
 		return
 	}
+	h.writeSigned(w, msg, request, dns.RcodeSuccess)
+}
 
-	w.WriteMsg(msg)
+// previousSecret returns the previous secret that verified a request during a
+// rotation overlap, which the client still signs with
+func (h *Handler) previousSecret(request *dns.TSIG) (string, bool) {
+	if h.keyring == nil {
+		return "", false
+	}
+	return h.keyring.VerifiedSecret(request.MAC)
+}
+
+// writeSigned signs a response with the key and algorithm of the request TSIG, chaining
+// the request MAC. Following RFC 8945, BADKEY and BADSIG responses carry an unsigned
+// TSIG, and BADTIME responses are signed and carry the server time.
+func (h *Handler) writeSigned(w dns.ResponseWriter, msg *dns.Msg, request *dns.TSIG, tsigErr int) {
+	secret := ""
+	if previous, ok := h.previousSecret(request); ok {
+		secret = previous
+	} else if key, ok := h.config.Key(request.Hdr.Name); ok {
+		secret = key.Secret
+	} else if tsigErr != dns.RcodeBadKey {
+		logrus.Errorf("No secret for TSIG key %s, answering unsigned", request.Hdr.Name)
+		w.WriteMsg(msg)
+		return
+	}
+
+	msg.SetTsig(request.Hdr.Name, request.Algorithm, uint16(h.config.TSIGFudge), 0)
+	t := msg.IsTsig()
+	t.Error = uint16(tsigErr)
+	requestMAC := request.MAC
+	switch tsigErr {
+	case dns.RcodeBadKey, dns.RcodeBadSig:
+		requestMAC = ""
+	case dns.RcodeBadTime:
+		t.TimeSigned = request.TimeSigned
+		t.OtherLen = 6
+		t.OtherData = fmt.Sprintf("%012x", time.Now().Unix())
+	}
+
+	// dns.TsigGenerate returns the packed signed message, the server would sign a
+	// message written with WriteMsg with its own key table
+	buf, _, err := dns.TsigGenerate(msg, secret, requestMAC, false)
+	if err != nil {
+		// TsigGenerate removed the TSIG from the message
+		logrus.Errorf("Failed to generate TSIG for response: %v", err)
+		w.WriteMsg(msg)
+		return
+	}
+	w.Write(buf)
+}
+
+// tsigError returns the TSIG error code of an error, or zero
+func tsigError(err error) int {
+	switch {
+	case errors.Is(err, dnserr.ErrTSIGKeyUnknown):
+		return dns.RcodeBadKey
+	case errors.Is(err, dnserr.ErrTSIGBadSignature):
+		return dns.RcodeBadSig
+	case errors.Is(err, dnserr.ErrTSIGBadTime):
+		return dns.RcodeBadTime
+	}
+	return dns.RcodeSuccess
 }
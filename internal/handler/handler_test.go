@@ -0,0 +1,552 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ratelimit"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var endpointGVR = schema.GroupVersionResource{Group: "externaldns.k8s.io", Version: "v1alpha1", Resource: "dnsendpoints"}
+
+// testWriter records the responses written to a client
+type testWriter struct {
+	remote     net.Addr
+	tsigStatus error
+	responses  []*dns.Msg
+}
+
+func (w *testWriter) LocalAddr() net.Addr {
+	if _, ok := w.remote.(*net.TCPAddr); ok {
+		return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+	}
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
+}
+
+func (w *testWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *testWriter) WriteMsg(msg *dns.Msg) error {
+	w.responses = append(w.responses, msg)
+	return nil
+}
+
+// Write records a response packed by the handler, such as a signed one
+func (w *testWriter) Write(buf []byte) (int, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(buf); err != nil {
+		return 0, err
+	}
+	w.responses = append(w.responses, msg)
+	return len(buf), nil
+}
+
+func (w *testWriter) Close() error        { return nil }
+func (w *testWriter) TsigStatus() error   { return w.tsigStatus }
+func (w *testWriter) TsigTimersOnly(bool) {}
+func (w *testWriter) Hijack()             {}
+
+// udpClient and tcpClient are the addresses of a router
+var (
+	udpClient = &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}
+	tcpClient = &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}
+)
+
+// newTestHandler creates a handler of the zone example.com, accepting the key
+// router., configured with env over the defaults, writing to a fake API server
+func newTestHandler(t *testing.T, env map[string]string) (*Handler, *fake.FakeDynamicClient) {
+	t.Helper()
+	values := map[string]string{
+		"TSIG_KEY":      "router.",
+		"TSIG_SECRET":   "dGVzdC1zZWNyZXQ=",
+		"ALLOWED_ZONES": "example.com",
+	}
+	for key, value := range env {
+		values[key] = value
+	}
+	cfg, err := config.LoadDocument(values)
+	if err != nil {
+		t.Fatalf("LoadDocument() failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+	api := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		endpointGVR: "DNSEndpointList",
+	})
+	client := k8s.NewClientForDynamic(api, k8s.Options{Namespace: "default"})
+	return NewHandler(cfg, client, nil), api
+}
+
+// updateMsg creates an UPDATE of the zone example.com adding the A record of a
+// name, signed with the key router. when signed is set
+func updateMsg(name string, signed bool) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	rr, _ := dns.NewRR(name + " 300 IN A 192.0.2.10")
+	msg.Insert([]dns.RR{rr})
+	if signed {
+		msg.SetTsig("router.", dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	return msg
+}
+
+// writes returns the writes of DNSEndpoints the API server received
+func writes(api *fake.FakeDynamicClient) int {
+	n := 0
+	for _, action := range api.Actions() {
+		if action.GetVerb() == "create" || action.GetVerb() == "update" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMsgAcceptFuncOversizedUpdate(t *testing.T) {
+	h := NewHandler(&config.Config{MaxUpdateRecords: 100}, nil, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	served := make(chan *dns.Msg, 1)
+	server := &dns.Server{
+		Listener:      listener,
+		MsgAcceptFunc: h.MsgAcceptFunc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			served <- r
+			msg := new(dns.Msg)
+			msg.SetReply(r)
+			w.WriteMsg(msg)
+		}),
+	}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	// A DHCP resync holding one update per lease
+	resync := func(records int) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetUpdate("example.com.")
+		for i := 0; i < records; i++ {
+			rr, err := dns.NewRR(fmt.Sprintf("host%d.example.com. 300 IN A 192.0.%d.%d", i, i/256, i%256))
+			if err != nil {
+				t.Fatalf("NewRR() failed: %v", err)
+			}
+			msg.Insert([]dns.RR{rr})
+		}
+		return msg
+	}
+
+	client := &dns.Client{Net: "tcp"}
+	resp, _, err := client.Exchange(resync(1000), listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Exchange() failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeFormatError {
+		t.Errorf("Expected FORMERR for an oversized UPDATE, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	select {
+	case <-served:
+		t.Error("Expected the oversized UPDATE to be refused before reaching the handler")
+	default:
+	}
+
+	resp, _, err = client.Exchange(resync(100), listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Exchange() failed: %v", err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		t.Errorf("Expected an UPDATE within the limit to be served, got %s", dns.RcodeToString[resp.Rcode])
+	}
+	if r := <-served; len(r.Ns) != 100 {
+		t.Errorf("Expected the 100 updates to be unpacked, got %d", len(r.Ns))
+	}
+}
+
+func TestServeDNSRejections(t *testing.T) {
+	// Each message fails at one stage of serveDNS, and at the following ones
+	// when it can, to check they run in order: rate limit, source ACL, TSIG, zone
+	// checks, prerequisites, then the write
+	tests := []struct {
+		name       string
+		env        map[string]string
+		setup      func(h *Handler, api *fake.FakeDynamicClient)
+		remote     net.Addr
+		tsigStatus error
+		msg        func() *dns.Msg
+		// rcode is the rcode of the response, -1 when the message is dropped
+		rcode int
+		// kind is the kind of error recorded, empty when the response is not an error
+		kind   string
+		writes int
+	}{
+		{
+			name: "rate limited over TCP",
+			env:  map[string]string{"ALLOWED_SOURCES": "10.0.0.0/8"},
+			setup: func(h *Handler, api *fake.FakeDynamicClient) {
+				limiter := ratelimit.New(0.001, 1)
+				limiter.Allow("192.168.1.1")
+				h.SetRateLimiter(limiter)
+			},
+			remote: tcpClient,
+			msg:    func() *dns.Msg { return updateMsg("host.example.com.", false) },
+			rcode:  dns.RcodeRefused, kind: "rate_limited",
+		},
+		{
+			name: "rate limited over UDP",
+			setup: func(h *Handler, api *fake.FakeDynamicClient) {
+				limiter := ratelimit.New(0.001, 1)
+				limiter.Allow("192.168.1.1")
+				h.SetRateLimiter(limiter)
+			},
+			remote: udpClient,
+			msg:    func() *dns.Msg { return updateMsg("host.example.com.", true) },
+			rcode:  -1,
+		},
+		{
+			name:   "source not allowed",
+			env:    map[string]string{"ALLOWED_SOURCES": "10.0.0.0/8"},
+			remote: udpClient,
+			msg:    func() *dns.Msg { return updateMsg("host.example.com.", false) },
+			rcode:  dns.RcodeRefused, kind: "source_not_allowed",
+		},
+		{
+			name:   "not signed",
+			remote: udpClient,
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.com.", false)
+				msg.Question[0].Name = "example.net."
+				return msg
+			},
+			rcode: dns.RcodeRefused, kind: "not_signed",
+		},
+		{
+			name:       "bad signature",
+			remote:     udpClient,
+			tsigStatus: dns.ErrSig,
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.com.", true)
+				msg.Question[0].Name = "example.net."
+				return msg
+			},
+			rcode: dns.RcodeNotAuth, kind: "tsig_badsig",
+		},
+		{
+			name:       "unknown key",
+			remote:     udpClient,
+			tsigStatus: dns.ErrSecret,
+			msg:        func() *dns.Msg { return updateMsg("host.example.com.", true) },
+			rcode:      dns.RcodeNotAuth, kind: "tsig_badkey",
+		},
+		{
+			name:   "no zone section",
+			remote: udpClient,
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.com.", true)
+				msg.Question = nil
+				return msg
+			},
+			rcode: dns.RcodeFormatError, kind: "malformed",
+		},
+		{
+			name:   "zone not allowed",
+			remote: udpClient,
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.net.", true)
+				msg.Question[0].Name = "example.net."
+				return msg
+			},
+			rcode: dns.RcodeRefused, kind: "zone_not_allowed",
+		},
+		{
+			name:   "unsupported records",
+			remote: udpClient,
+			msg: func() *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetUpdate("example.com.")
+				rr, _ := dns.NewRR("host.example.com. 300 IN HINFO \"cpu\" \"os\"")
+				msg.Insert([]dns.RR{rr})
+				msg.SetTsig("router.", dns.HmacSHA256, 300, time.Now().Unix())
+				return msg
+			},
+			rcode: dns.RcodeFormatError, kind: "unsupported_type",
+		},
+		{
+			name:   "prerequisite not satisfied",
+			remote: udpClient,
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.com.", true)
+				msg.RRsetUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeA}}})
+				return msg
+			},
+			rcode: dns.RcodeNXRrset,
+		},
+		{
+			name: "backend unavailable",
+			setup: func(h *Handler, api *fake.FakeDynamicClient) {
+				api.PrependReactor("create", "dnsendpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewServiceUnavailable("etcd unavailable")
+				})
+			},
+			remote: udpClient,
+			msg:    func() *dns.Msg { return updateMsg("host.example.com.", true) },
+			rcode:  dns.RcodeServerFailure, kind: "backend_unavailable", writes: 1,
+		},
+		{
+			name:   "applied",
+			remote: udpClient,
+			msg:    func() *dns.Msg { return updateMsg("host.example.com.", true) },
+			rcode:  dns.RcodeSuccess, writes: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := newTestHandler(t, tt.env)
+			if tt.setup != nil {
+				tt.setup(h, api)
+			}
+			w := &testWriter{remote: tt.remote, tsigStatus: tt.tsigStatus}
+			r := tt.msg()
+			// Messages are received packed
+			buf, err := r.Pack()
+			if err != nil {
+				t.Fatalf("Pack() failed: %v", err)
+			}
+			received := new(dns.Msg)
+			if err := received.Unpack(buf); err != nil {
+				t.Fatalf("Unpack() failed: %v", err)
+			}
+			h.serveDNS(w, received)
+
+			if tt.rcode < 0 {
+				if len(w.responses) != 0 {
+					t.Fatalf("Expected no response, got %d", len(w.responses))
+				}
+			} else if len(w.responses) != 1 {
+				t.Fatalf("Expected one response, got %d", len(w.responses))
+			} else if got := w.responses[0].Rcode; got != tt.rcode {
+				t.Errorf("Expected %s, got %s", dns.RcodeToString[tt.rcode], dns.RcodeToString[got])
+			}
+
+			recorded := h.errors.Recent()
+			switch {
+			case tt.kind == "" && len(recorded) != 0:
+				t.Errorf("Expected no error recorded, got %v", recorded)
+			case tt.kind != "" && (len(recorded) != 1 || recorded[0].Kind != tt.kind):
+				t.Errorf("Expected a %s error recorded, got %v", tt.kind, recorded)
+			}
+			if got := writes(api); got != tt.writes {
+				t.Errorf("Expected %d writes to the API server, got %d", tt.writes, got)
+			}
+		})
+	}
+}
+
+func TestServeDNSSignedResponse(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	w := &testWriter{remote: udpClient}
+	h.serveDNS(w, updateMsg("host.example.com.", true))
+	if len(w.responses) != 1 {
+		t.Fatalf("Expected one response, got %d", len(w.responses))
+	}
+	tsig := w.responses[0].IsTsig()
+	if tsig == nil || tsig.Hdr.Name != "router." || tsig.Error != dns.RcodeSuccess {
+		t.Errorf("Expected the response to be signed with the key of the request, got %v", tsig)
+	}
+}
+
+func TestServeDNSClockSkew(t *testing.T) {
+	// The request is signed 10 minutes ago with a fudge of 5 minutes, the server
+	// reports its signature valid but out of time
+	tests := []struct {
+		name      string
+		env       map[string]string
+		rcode     int
+		tsigError uint16
+		failures  float64
+		tolerated float64
+		writes    int
+	}{
+		{"beyond the fudge", map[string]string{"TSIG_FUDGE": "120"}, dns.RcodeNotAuth, dns.RcodeBadTime, 1, 0, 0},
+		{"within TSIG_SKEW_TOLERANCE", map[string]string{"TSIG_FUDGE": "120", "TSIG_SKEW_TOLERANCE": "15m"}, dns.RcodeSuccess, dns.RcodeSuccess, 0, 1, 1},
+		{"beyond TSIG_SKEW_TOLERANCE", map[string]string{"TSIG_FUDGE": "120", "TSIG_SKEW_TOLERANCE": "5m"}, dns.RcodeNotAuth, dns.RcodeBadTime, 1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := newTestHandler(t, tt.env)
+			failures := testutil.ToFloat64(metrics.TSIGFailures.WithLabelValues("badtime"))
+			tolerated := testutil.ToFloat64(metrics.TSIGSkewTolerated)
+
+			msg := updateMsg("host.example.com.", false)
+			signed := time.Now().Add(-10 * time.Minute).Unix()
+			msg.SetTsig("router.", dns.HmacSHA256, 300, signed)
+			w := &testWriter{remote: udpClient, tsigStatus: dns.ErrTime}
+			h.serveDNS(w, msg)
+
+			if len(w.responses) != 1 || w.responses[0].Rcode != tt.rcode {
+				t.Fatalf("Expected a %s response, got %v", dns.RcodeToString[tt.rcode], w.responses)
+			}
+			tsig := w.responses[0].IsTsig()
+			if tsig == nil || tsig.Error != tt.tsigError || tsig.Fudge != 120 {
+				t.Fatalf("Expected the response signed with TSIG error %s and fudge 120, got %v", dns.RcodeToString[int(tt.tsigError)], tsig)
+			}
+			if tt.tsigError == dns.RcodeBadTime {
+				// BADTIME responses carry the time of the request and the server time
+				if tsig.TimeSigned != uint64(signed) || tsig.OtherLen != 6 {
+					t.Errorf("Expected the request time and the server time in the BADTIME response, got %v", tsig)
+				}
+			}
+			if got := testutil.ToFloat64(metrics.TSIGFailures.WithLabelValues("badtime")) - failures; got != tt.failures {
+				t.Errorf("Expected %v BADTIME failures counted, got %v", tt.failures, got)
+			}
+			if got := testutil.ToFloat64(metrics.TSIGSkewTolerated) - tolerated; got != tt.tolerated {
+				t.Errorf("Expected %v tolerated requests counted, got %v", tt.tolerated, got)
+			}
+			if got := writes(api); got != tt.writes {
+				t.Errorf("Expected %d writes to the API server, got %d", tt.writes, got)
+			}
+		})
+	}
+}
+
+func TestServeDNSUnsignedUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		rcode   int
+		outcome string
+		writes  int
+	}{
+		{"signature required by default", nil, dns.RcodeRefused, "refused", 0},
+		{"outside of UNSIGNED_ZONES", map[string]string{"UNSIGNED_ZONES": "example.com=10.0.0.0/8"}, dns.RcodeRefused, "refused", 0},
+		{"from a network of UNSIGNED_ZONES", map[string]string{"UNSIGNED_ZONES": "example.com=192.168.1.0/24"}, dns.RcodeSuccess, "accepted", 1},
+		{"signature not required", map[string]string{"REQUIRE_TSIG": "false"}, dns.RcodeSuccess, "accepted", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := newTestHandler(t, tt.env)
+			counter := metrics.UnsignedUpdates.WithLabelValues(tt.outcome)
+			before := testutil.ToFloat64(counter)
+
+			w := &testWriter{remote: udpClient}
+			h.serveDNS(w, updateMsg("host.example.com.", false))
+			if len(w.responses) != 1 || w.responses[0].Rcode != tt.rcode {
+				t.Fatalf("Expected a %s response, got %v", dns.RcodeToString[tt.rcode], w.responses)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("Expected the unsigned update to be counted %s, got %v", tt.outcome, got)
+			}
+			if got := writes(api); got != tt.writes {
+				t.Errorf("Expected %d writes to the API server, got %d", tt.writes, got)
+			}
+		})
+	}
+}
+
+func TestServeDNSPrerequisites(t *testing.T) {
+	// host.example.com. and gw.example.net. are published with an A record
+	published := &dns.A{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.0.2.10")}
+	prereq := func(name string, rrtype uint16) dns.RR {
+		return &dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: rrtype}}
+	}
+	tests := []struct {
+		name  string
+		set   func(msg *dns.Msg)
+		rcode int
+	}{
+		{name: "name in use", set: func(msg *dns.Msg) { msg.NameUsed([]dns.RR{prereq("host.example.com.", dns.TypeANY)}) }, rcode: dns.RcodeSuccess},
+		{name: "name not in use", set: func(msg *dns.Msg) { msg.NameUsed([]dns.RR{prereq("new.example.com.", dns.TypeANY)}) }, rcode: dns.RcodeNameError},
+		{name: "name free", set: func(msg *dns.Msg) { msg.NameNotUsed([]dns.RR{prereq("new.example.com.", dns.TypeANY)}) }, rcode: dns.RcodeSuccess},
+		{name: "name not free", set: func(msg *dns.Msg) { msg.NameNotUsed([]dns.RR{prereq("host.example.com.", dns.TypeANY)}) }, rcode: dns.RcodeYXDomain},
+		{name: "RRset exists", set: func(msg *dns.Msg) { msg.RRsetUsed([]dns.RR{prereq("host.example.com.", dns.TypeA)}) }, rcode: dns.RcodeSuccess},
+		{name: "RRset does not exist", set: func(msg *dns.Msg) { msg.RRsetUsed([]dns.RR{prereq("host.example.com.", dns.TypeAAAA)}) }, rcode: dns.RcodeNXRrset},
+		{name: "RRset absent", set: func(msg *dns.Msg) { msg.RRsetNotUsed([]dns.RR{prereq("host.example.com.", dns.TypeAAAA)}) }, rcode: dns.RcodeSuccess},
+		{name: "RRset not absent", set: func(msg *dns.Msg) { msg.RRsetNotUsed([]dns.RR{prereq("host.example.com.", dns.TypeA)}) }, rcode: dns.RcodeYXRrset},
+		{name: "RRset with its values", set: func(msg *dns.Msg) { msg.Used([]dns.RR{published}) }, rcode: dns.RcodeSuccess},
+		{
+			name: "RRset with other values",
+			set: func(msg *dns.Msg) {
+				other := *published
+				other.A = net.ParseIP("192.0.2.99")
+				msg.Used([]dns.RR{&other})
+			},
+			rcode: dns.RcodeNXRrset,
+		},
+		{
+			name: "prerequisite with a TTL",
+			set: func(msg *dns.Msg) {
+				msg.RRsetUsed([]dns.RR{prereq("host.example.com.", dns.TypeA)})
+				msg.Answer[0].Header().Ttl = 300
+			},
+			rcode: dns.RcodeFormatError,
+		},
+		{
+			name: "class ANY prerequisite with data",
+			set: func(msg *dns.Msg) {
+				rr := *published
+				rr.Hdr.Class, rr.Hdr.Rdlength = dns.ClassANY, 4
+				msg.Answer = []dns.RR{&rr}
+			},
+			rcode: dns.RcodeFormatError,
+		},
+		{
+			name: "prerequisite of class CHAOS",
+			set: func(msg *dns.Msg) {
+				msg.Answer = []dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeA, Class: dns.ClassCHAOS}}}
+			},
+			rcode: dns.RcodeFormatError,
+		},
+		{
+			// RFC 2136 section 3.2.5, even for a name of another allowed zone
+			name:  "name outside the zone section",
+			set:   func(msg *dns.Msg) { msg.NameUsed([]dns.RR{prereq("gw.example.net.", dns.TypeANY)}) },
+			rcode: dns.RcodeNotZone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := newTestHandler(t, map[string]string{"ALLOWED_ZONES": "example.com,example.net"})
+			other := updateMsg("gw.example.net.", true)
+			other.Question[0].Name = "example.net."
+			for _, msg := range []*dns.Msg{updateMsg("host.example.com.", true), other} {
+				w := &testWriter{remote: udpClient}
+				h.serveDNS(w, msg)
+				if len(w.responses) != 1 || w.responses[0].Rcode != dns.RcodeSuccess {
+					t.Fatalf("Expected %s to be published, got %v", msg.Ns[0].Header().Name, w.responses)
+				}
+			}
+			before := writes(api)
+
+			msg := updateMsg("new.example.com.", false)
+			tt.set(msg)
+			msg.SetTsig("router.", dns.HmacSHA256, 300, time.Now().Unix())
+			w := &testWriter{remote: udpClient}
+			h.serveDNS(w, msg)
+			if len(w.responses) != 1 {
+				t.Fatalf("Expected one response, got %d", len(w.responses))
+			}
+			if got := w.responses[0].Rcode; got != tt.rcode {
+				t.Errorf("Expected %s, got %s", dns.RcodeToString[tt.rcode], dns.RcodeToString[got])
+			}
+			// A failed prerequisite leaves the zone untouched
+			if got := writes(api) - before; (tt.rcode == dns.RcodeSuccess) != (got > 0) {
+				t.Errorf("Expected the update to be applied only when its prerequisites are satisfied, got %d writes", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/zoneauth"
+)
+
+// liveConfig is the configuration shared by a handler and its listener handlers,
+// replaced by ApplyConfig
+type liveConfig struct {
+	mu     sync.RWMutex
+	config *config.Config
+	zones  zoneauth.Authorizer
+	// certACL holds the names of the client certificates, from CERT_ACLS
+	certACL acl.ACL
+	// resources accepts zones besides the configured ones, e.g. AllowedZone resources
+	resources zoneauth.Authorizer
+}
+
+// authorizer returns the authorizer of the zones accepted with a configuration
+func (l *liveConfig) authorizer(cfg *config.Config) zoneauth.Authorizer {
+	if l.resources == nil {
+		return cfg.ZoneAuthorizer()
+	}
+	return zoneauth.Any(cfg.ZoneAuthorizer(), l.resources)
+}
+
+// AcceptZones accepts the zones of an authorizer in the zone section besides the
+// configured zones
+func (h *Handler) AcceptZones(resources zoneauth.Authorizer) {
+	h.live.mu.Lock()
+	defer h.live.mu.Unlock()
+	h.live.resources = resources
+	h.live.zones = h.live.authorizer(h.live.config)
+	h.zones = h.live.zones
+}
+
+// ApplyConfig replaces the configuration of the handler and its listener handlers.
+// Messages being answered keep the configuration they started with.
+func (h *Handler) ApplyConfig(cfg *config.Config) {
+	h.live.mu.Lock()
+	h.live.config = cfg
+	h.live.zones = h.live.authorizer(cfg)
+	h.live.certACL = acl.New(cfg.CertACLs)
+	h.live.mu.Unlock()
+	// Cached answers may come from zones or SOA parameters that changed
+	h.cache.clear()
+}
+
+// current returns the handler with the configuration applied last, so that a
+// message is answered with a single configuration
+func (h *Handler) current() *Handler {
+	h.live.mu.RLock()
+	cfg, zones, certACL := h.live.config, h.live.zones, h.live.certACL
+	h.live.mu.RUnlock()
+	if cfg == h.config {
+		return h
+	}
+	pinned := *h
+	pinned.config, pinned.zones, pinned.certACL = cfg, zones, certACL
+	return &pinned
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// pipeline processes the messages queued on a TCP connection concurrently, up to
+// TCP_PIPELINE_DEPTH per connection. Responses are written as soon as they are
+// ready and matched by the client on their message ID, as RFC 7766 permits.
+type pipeline struct {
+	depth int
+
+	mu    sync.Mutex
+	conns map[string]*pipelinedConn
+	// writers serializes the writes of each open connection, guarded by mu
+	writers map[string]*connWriter
+}
+
+// pipelinedConn tracks the messages of a connection being processed
+type pipelinedConn struct {
+	slots    chan struct{}
+	inflight sync.WaitGroup
+	// pending counts the messages dispatched and not answered, guarded by pipeline.mu
+	pending int
+	writer  *connWriter
+}
+
+// connWriter writes the responses of a connection one at a time: those of the
+// pipelined messages, and those the server writes itself, such as the rejects of
+// MsgAcceptFunc
+type connWriter struct {
+	dns.Writer
+	mu sync.Mutex
+}
+
+func (w *connWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Writer.Write(b)
+}
+
+// newPipeline creates a pipeline processing depth messages per connection, or nil when depth is 0
+func newPipeline(depth int) *pipeline {
+	if depth <= 0 {
+		return nil
+	}
+	return &pipeline{depth: depth, conns: make(map[string]*pipelinedConn), writers: make(map[string]*connWriter)}
+}
+
+// connKey identifies a TCP connection by its addresses
+func connKey(local, remote net.Addr) string {
+	return local.String() + "|" + remote.String()
+}
+
+// isTCP checks if a message was received over TCP or TLS
+func isTCP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.TCPAddr)
+	return ok
+}
+
+// writer returns the writer serializing the writes of a connection, wrapping w
+// when the connection has none yet. Guarded by mu.
+func (p *pipeline) writer(key string, w dns.Writer) *connWriter {
+	cw, ok := p.writers[key]
+	if !ok {
+		cw = &connWriter{Writer: w}
+		p.writers[key] = cw
+	}
+	return cw
+}
+
+// acquire returns the state of a connection, counting one more pending message
+func (p *pipeline) acquire(key string, w dns.ResponseWriter) *pipelinedConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.conns[key]
+	if !ok {
+		pc = &pipelinedConn{slots: make(chan struct{}, p.depth), writer: p.writer(key, w)}
+		p.conns[key] = pc
+	}
+	pc.pending++
+	pc.inflight.Add(1)
+	return pc
+}
+
+// release counts an answered message, forgetting the connection once it is idle
+func (p *pipeline) release(key string, pc *pipelinedConn) {
+	p.mu.Lock()
+	pc.pending--
+	if pc.pending == 0 {
+		delete(p.conns, key)
+	}
+	p.mu.Unlock()
+	pc.inflight.Done()
+}
+
+// drain waits for the messages of a connection being processed, then forgets the
+// connection, about to be closed
+func (p *pipeline) drain(key string) {
+	p.mu.Lock()
+	pc := p.conns[key]
+	p.mu.Unlock()
+	if pc != nil {
+		pc.inflight.Wait()
+	}
+	p.mu.Lock()
+	delete(p.writers, key)
+	p.mu.Unlock()
+}
+
+// dispatch processes a message in the background once the connection has a free
+// slot. The connection is not read further while every slot is taken.
+func (p *pipeline) dispatch(w dns.ResponseWriter, r *dns.Msg, serve dns.HandlerFunc) {
+	key := connKey(w.LocalAddr(), w.RemoteAddr())
+	pc := p.acquire(key, w)
+	pc.slots <- struct{}{}
+
+	// The server verifies the TSIG of the next message on the same writer, keep the outcome of this one
+	pw := &pipelinedWriter{ResponseWriter: w, conn: pc, tsigStatus: w.TsigStatus()}
+	go func() {
+		defer p.release(key, pc)
+		defer func() { <-pc.slots }()
+		serve(pw, r)
+	}()
+}
+
+// pipelinedWriter answers one of the messages processed concurrently on a connection
+type pipelinedWriter struct {
+	dns.ResponseWriter
+	conn       *pipelinedConn
+	tsigStatus error
+}
+
+// TsigStatus returns the outcome of the TSIG verification of the message
+func (w *pipelinedWriter) TsigStatus() error {
+	return w.tsigStatus
+}
+
+// Write writes a packed response, one response at a time
+func (w *pipelinedWriter) Write(b []byte) (int, error) {
+	return w.conn.writer.Write(b)
+}
+
+// WriteMsg packs and writes a response. Responses are signed by the handler, the
+// TSIG state of the shared writer belongs to the message being read.
+func (w *pipelinedWriter) WriteMsg(m *dns.Msg) error {
+	data, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ConnectionState returns the TLS state of the connection, if any
+func (w *pipelinedWriter) ConnectionState() *tls.ConnectionState {
+	if stater, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return nil
+}
+
+// pipelinedReader answers the messages of a connection still being processed
+// before the server closes it
+type pipelinedReader struct {
+	dns.Reader
+	pipeline *pipeline
+}
+
+func (r *pipelinedReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	m, err := r.Reader.ReadTCP(conn, timeout)
+	if err != nil {
+		// The client is done or idle: the server closes the connection once the read fails
+		r.pipeline.drain(connKey(conn.LocalAddr(), conn.RemoteAddr()))
+	}
+	return m, err
+}
+
+// DecorateReader wraps a dns.Reader so that the messages processed concurrently
+// on a TCP connection are answered before the connection is closed. It returns
+// the reader unchanged when TCP pipelining is disabled.
+func (h *Handler) DecorateReader(reader dns.Reader) dns.Reader {
+	if h.pipeline == nil {
+		return reader
+	}
+	return &pipelinedReader{Reader: reader, pipeline: h.pipeline}
+}
+
+// DecorateWriter wraps the writer of a TCP connection so that the responses the
+// server writes itself, such as the rejects of MsgAcceptFunc, do not interleave
+// with the responses of the messages processed concurrently. It returns the
+// writer unchanged for UDP, or when TCP pipelining is disabled.
+func (h *Handler) DecorateWriter(writer dns.Writer) dns.Writer {
+	w, ok := writer.(dns.ResponseWriter)
+	if h.pipeline == nil || !ok || !isTCP(w) {
+		return writer
+	}
+	h.pipeline.mu.Lock()
+	defer h.pipeline.mu.Unlock()
+	return h.pipeline.writer(connKey(w.LocalAddr(), w.RemoteAddr()), writer)
+}
@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startPipelinedServer serves the messages of TCP connections with serve,
+// pipelined depth messages per connection
+func startPipelinedServer(t *testing.T, depth int, serve dns.HandlerFunc, accept dns.MsgAcceptFunc) (*Handler, string) {
+	t.Helper()
+	h := &Handler{pipeline: newPipeline(depth)}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	if accept == nil {
+		accept = dns.DefaultMsgAcceptFunc
+	}
+	server := &dns.Server{
+		Listener: listener,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			h.pipeline.dispatch(w, r, serve)
+		}),
+		MsgAcceptFunc:  accept,
+		DecorateReader: h.DecorateReader,
+		DecorateWriter: h.DecorateWriter,
+		MaxTCPQueries:  -1,
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return h, listener.Addr().String()
+}
+
+// query creates a query of the given ID
+func query(id uint16) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion("host.example.com.", dns.TypeA)
+	msg.Id = id
+	return msg
+}
+
+func TestPipelineOutOfOrder(t *testing.T) {
+	// The first message is answered once the second one is
+	release := make(chan struct{})
+	_, addr := startPipelinedServer(t, 2, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Id == 1 {
+			<-release
+		}
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+		if r.Id == 2 {
+			close(release)
+		}
+	}, nil)
+
+	conn, err := dns.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for _, id := range []uint16{1, 2} {
+		if err := conn.WriteMsg(query(id)); err != nil {
+			t.Fatalf("WriteMsg() failed: %v", err)
+		}
+	}
+	for _, id := range []uint16{2, 1} {
+		resp, err := conn.ReadMsg()
+		if err != nil {
+			t.Fatalf("ReadMsg() failed: %v", err)
+		}
+		if resp.Id != id {
+			t.Errorf("Expected the response to message %d, got %d", id, resp.Id)
+		}
+	}
+}
+
+func TestPipelineDepth(t *testing.T) {
+	// Without pipelining, the second message waits for the first one
+	release := make(chan struct{})
+	_, addr := startPipelinedServer(t, 1, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Id == 1 {
+			<-release
+		}
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+	}, nil)
+
+	conn, err := dns.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	for _, id := range []uint16{1, 2} {
+		if err := conn.WriteMsg(query(id)); err != nil {
+			t.Fatalf("WriteMsg() failed: %v", err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if resp, err := conn.ReadMsg(); err == nil {
+		t.Fatalf("Expected no response while the only slot is taken, got message %d", resp.Id)
+	}
+	close(release)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, id := range []uint16{1, 2} {
+		if resp, err := conn.ReadMsg(); err != nil || resp.Id != id {
+			t.Fatalf("Expected the response to message %d, got %v, %v", id, resp, err)
+		}
+	}
+}
+
+func TestPipelineDrain(t *testing.T) {
+	// The client closes its side while its message is processed
+	processing := make(chan struct{})
+	h, addr := startPipelinedServer(t, 2, func(w dns.ResponseWriter, r *dns.Msg) {
+		close(processing)
+		time.Sleep(50 * time.Millisecond)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+	}, nil)
+
+	conn, err := dns.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.WriteMsg(query(1)); err != nil {
+		t.Fatalf("WriteMsg() failed: %v", err)
+	}
+	<-processing
+	conn.Conn.(*net.TCPConn).CloseWrite()
+
+	resp, err := conn.ReadMsg()
+	if err != nil {
+		t.Fatalf("Expected the response in flight before the connection is closed, got %v", err)
+	}
+	if resp.Id != 1 {
+		t.Errorf("Expected the response to message 1, got %d", resp.Id)
+	}
+
+	// The connection is forgotten once closed
+	if _, err := conn.ReadMsg(); err == nil {
+		t.Error("Expected the server to close the connection")
+	}
+	h.pipeline.mu.Lock()
+	defer h.pipeline.mu.Unlock()
+	if len(h.pipeline.conns) != 0 || len(h.pipeline.writers) != 0 {
+		t.Errorf("Expected the closed connection to be forgotten, got %d connections and %d writers", len(h.pipeline.conns), len(h.pipeline.writers))
+	}
+}
+
+func TestPipelineRejectsServed(t *testing.T) {
+	// Responses are rejected by the server loop while others are processed
+	_, addr := startPipelinedServer(t, 4, func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(10 * time.Millisecond)
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		w.WriteMsg(msg)
+	}, func(dh dns.Header) dns.MsgAcceptAction {
+		if dh.Id%2 == 0 {
+			return dns.MsgReject
+		}
+		return dns.MsgAccept
+	})
+
+	conn, err := dns.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for id := uint16(1); id <= 8; id++ {
+		if err := conn.WriteMsg(query(id)); err != nil {
+			t.Fatalf("WriteMsg() failed: %v", err)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		resp, err := conn.ReadMsg()
+		if err != nil {
+			t.Fatalf("ReadMsg() failed: %v", err)
+		}
+		if rejected := resp.Id%2 == 0; rejected != (resp.Rcode == dns.RcodeFormatError) {
+			t.Errorf("Expected message %d to be answered %v, got %s", resp.Id, rejected, dns.RcodeToString[resp.Rcode])
+		}
+	}
+}
+
+// overlapWriter detects writes overlapping on a connection
+type overlapWriter struct {
+	testWriter
+	writing  atomic.Int32
+	overlaps atomic.Int32
+	writes   atomic.Int32
+}
+
+func (w *overlapWriter) Write(b []byte) (int, error) {
+	if w.writing.Add(1) > 1 {
+		w.overlaps.Add(1)
+	}
+	time.Sleep(time.Millisecond)
+	w.writing.Add(-1)
+	w.writes.Add(1)
+	return len(b), nil
+}
+
+func TestPipelineWritesSerialized(t *testing.T) {
+	h := &Handler{pipeline: newPipeline(4)}
+	w := &overlapWriter{testWriter: testWriter{remote: tcpClient}}
+	// The writer of the server loop, as set by DecorateWriter
+	serverWriter := h.DecorateWriter(w)
+	if serverWriter == dns.Writer(w) {
+		t.Fatal("Expected the writer of a TCP connection to be decorated")
+	}
+	if udp := (&overlapWriter{testWriter: testWriter{remote: udpClient}}); h.DecorateWriter(udp) != dns.Writer(udp) {
+		t.Error("Expected the writer of UDP messages to be left alone")
+	}
+
+	var wg sync.WaitGroup
+	response := make([]byte, 12)
+	for i := 0; i < 8; i++ {
+		h.pipeline.dispatch(w, query(uint16(i)), func(w dns.ResponseWriter, r *dns.Msg) {
+			w.Write(response)
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serverWriter.Write(response)
+		}()
+	}
+	wg.Wait()
+	h.pipeline.drain(connKey(w.LocalAddr(), w.RemoteAddr()))
+
+	if got := w.writes.Load(); got != 16 {
+		t.Errorf("Expected 16 writes, got %d", got)
+	}
+	if got := w.overlaps.Load(); got != 0 {
+		t.Errorf("Expected the writes of the connection to be serialized, got %d overlapping writes", got)
+	}
+}
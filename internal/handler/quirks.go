@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/quirks"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// retransmitWindow is the time a response is kept to answer the retransmissions
+// of its request
+const retransmitWindow = 30 * time.Second
+
+// retransmitPruneEvery is the number of tracked requests between two prunings
+const retransmitPruneEvery = 256
+
+// acceptsZoneClass checks if the class of a zone section is accepted from a client
+func acceptsZoneClass(class uint16, q quirks.Quirks) bool {
+	return class == dns.ClassINET || (q.AnyZoneClass && class == dns.ClassANY)
+}
+
+// quirkZone rewrites the zone section of a client naming a host below an allowed
+// zone to that zone
+func (h *Handler) quirkZone(r *dns.Msg, q quirks.Quirks) {
+	if !q.ZoneFromName || len(r.Question) == 0 {
+		return
+	}
+	name := r.Question[0].Name
+	if zone := h.config.ZoneOf(name); zone != "" && !strings.EqualFold(dns.Fqdn(name), zone) {
+		logrus.Debugf("Zone section %s rewritten to its enclosing zone %s", name, zone)
+		r.Question[0].Name = zone
+	}
+}
+
+// parserFor returns the parser of the updates of a client
+func (h *Handler) parserFor(q quirks.Quirks) *update.Parser {
+	if !q.DHCPRecords || h.parser.DHCP {
+		return h.parser
+	}
+	parser := *h.parser
+	parser.DHCP = true
+	return &parser
+}
+
+// answersQuery checks if a query is answered: SERVE_SOA is enabled, or the client
+// finds its zone with SOA queries
+func (h *Handler) answersQuery(w dns.ResponseWriter, r *dns.Msg) bool {
+	if h.config.ServeSOA {
+		return true
+	}
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return false
+	}
+	keyName := ""
+	if tsig := verifiedTSIG(w, r); tsig != nil {
+		keyName = tsig.Hdr.Name
+	}
+	return h.config.ClientQuirks.For(keyName, remoteIP(w.RemoteAddr())).SOAQueries
+}
+
+// retransmitKey identifies the transmissions of a request
+type retransmitKey struct {
+	source string
+	id     uint16
+	zone   string
+}
+
+// retransmitCache keeps the responses to the UDP updates of the clients that
+// retransmit them, to answer their retransmissions without applying them again
+type retransmitCache struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[retransmitKey]*retransmitEntry
+	tracked int
+}
+
+// retransmitEntry is a request being applied, or answered with response
+type retransmitEntry struct {
+	expires  time.Time
+	response *dns.Msg
+}
+
+// newRetransmitCache creates an empty cache
+func newRetransmitCache() *retransmitCache {
+	return &retransmitCache{now: time.Now, entries: make(map[retransmitKey]*retransmitEntry)}
+}
+
+// track returns the response to the first transmission of a retransmitted request,
+// nil while it is being applied, and true. A new request is tracked: its response,
+// written to the returned writer, answers its retransmissions.
+func (c *retransmitCache) track(w dns.ResponseWriter, r *dns.Msg) (dns.ResponseWriter, *dns.Msg, bool) {
+	key := retransmitKey{source: w.RemoteAddr().String(), id: r.Id}
+	if len(r.Question) > 0 {
+		key.zone = strings.ToLower(r.Question[0].Name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		if entry.response == nil {
+			metrics.Retransmits.WithLabelValues("in_flight").Inc()
+			return w, nil, true
+		}
+		metrics.Retransmits.WithLabelValues("answered").Inc()
+		return w, entry.response.Copy(), true
+	}
+
+	c.tracked++
+	if c.tracked%retransmitPruneEvery == 0 {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	entry := &retransmitEntry{expires: now.Add(retransmitWindow)}
+	c.entries[key] = entry
+	return &retransmitWriter{ResponseWriter: w, cache: c, entry: entry}, nil, false
+}
+
+// retransmitWriter keeps the response to a tracked request
+type retransmitWriter struct {
+	dns.ResponseWriter
+	cache *retransmitCache
+	entry *retransmitEntry
+}
+
+// WriteMsg writes the response and keeps it
+func (w *retransmitWriter) WriteMsg(msg *dns.Msg) error {
+	w.keep(msg.Copy())
+	return w.ResponseWriter.WriteMsg(msg)
+}
+
+// Write writes a packed response, as signed responses are, and keeps it
+func (w *retransmitWriter) Write(buf []byte) (int, error) {
+	response := new(dns.Msg)
+	if err := response.Unpack(buf); err == nil {
+		w.keep(response)
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// keep keeps a response without its TSIG, signed again for each retransmission
+func (w *retransmitWriter) keep(response *dns.Msg) {
+	extra := response.Extra[:0]
+	for _, rr := range response.Extra {
+		if _, ok := rr.(*dns.TSIG); !ok {
+			extra = append(extra, rr)
+		}
+	}
+	response.Extra = extra
+
+	w.cache.mu.Lock()
+	w.entry.response = response
+	w.cache.mu.Unlock()
+}
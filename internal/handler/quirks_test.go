@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestServeDNSQuirks(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		msg     func() *dns.Msg
+		rcode   int
+		// rcodeWithout is the rcode of the message from a client without the profile
+		rcodeWithout int
+	}{
+		{
+			name:    "zone section naming the host",
+			profile: "ddclient",
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.com.", true)
+				msg.Question[0].Name = "host.example.com."
+				return msg
+			},
+			rcode: dns.RcodeSuccess, rcodeWithout: dns.RcodeRefused,
+		},
+		{
+			name:    "zone section of class ANY",
+			profile: "dnsmasq",
+			msg: func() *dns.Msg {
+				msg := updateMsg("host.example.com.", true)
+				msg.Question[0].Qclass = dns.ClassANY
+				return msg
+			},
+			rcode: dns.RcodeSuccess, rcodeWithout: dns.RcodeNotImplemented,
+		},
+		{
+			name:    "SOA query",
+			profile: "opnsense",
+			msg: func() *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetQuestion("host.example.com.", dns.TypeSOA)
+				msg.SetTsig("router.", dns.HmacSHA256, 300, time.Now().Unix())
+				return msg
+			},
+			rcode: dns.RcodeSuccess, rcodeWithout: dns.RcodeNotImplemented,
+		},
+		{
+			name:    "DHCP records",
+			profile: "windows-dhcp",
+			msg: func() *dns.Msg {
+				msg := new(dns.Msg)
+				msg.SetUpdate("example.com.")
+				rr, _ := dns.NewRR("10.2.0.192.in-addr.arpa. 300 IN PTR host.example.com.")
+				msg.Insert([]dns.RR{rr})
+				msg.SetTsig("router.", dns.HmacSHA256, 300, time.Now().Unix())
+				return msg
+			},
+			rcode: dns.RcodeSuccess, rcodeWithout: dns.RcodeFormatError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"ALLOWED_ZONES": "example.com,2.0.192.in-addr.arpa"}
+			without, _ := newTestHandler(t, env)
+			env["CLIENT_QUIRKS"] = "router.=" + tt.profile
+			with, _ := newTestHandler(t, env)
+
+			for _, c := range []struct {
+				h       *Handler
+				profile string
+				rcode   int
+			}{{with, tt.profile, tt.rcode}, {without, "none", tt.rcodeWithout}} {
+				w := &testWriter{remote: udpClient}
+				c.h.serveDNS(w, tt.msg())
+				if len(w.responses) != 1 {
+					t.Fatalf("Expected one response, got %d", len(w.responses))
+				}
+				if got := w.responses[0].Rcode; got != c.rcode {
+					t.Errorf("Expected %s with profile %s, got %s", dns.RcodeToString[c.rcode], c.profile, dns.RcodeToString[got])
+				}
+			}
+		})
+	}
+}
+
+func TestServeDNSRetransmits(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		quirks string
+		// rcode is the rcode of the retransmission, once writes fail
+		rcode int
+	}{
+		{name: "answered with the first response", quirks: "router.=opnsense", rcode: dns.RcodeSuccess},
+		{name: "applied again without the profile", rcode: dns.RcodeServerFailure},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api := newTestHandler(t, map[string]string{"CLIENT_QUIRKS": tt.quirks})
+			msg := updateMsg("host.example.com.", true)
+
+			w := &testWriter{remote: udpClient}
+			h.serveDNS(w, msg.Copy())
+			if len(w.responses) != 1 || w.responses[0].Rcode != dns.RcodeSuccess {
+				t.Fatalf("Expected the first transmission to be applied, got %v", w.responses)
+			}
+
+			// A retransmission applied again would fail
+			api.PrependReactor("*", "dnsendpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewServiceUnavailable("etcd unavailable")
+			})
+			w = &testWriter{remote: udpClient}
+			h.serveDNS(w, msg.Copy())
+			if len(w.responses) != 1 {
+				t.Fatalf("Expected one response to the retransmission, got %d", len(w.responses))
+			}
+			if got := w.responses[0].Rcode; got != tt.rcode {
+				t.Errorf("Expected %s, got %s", dns.RcodeToString[tt.rcode], dns.RcodeToString[got])
+			}
+			if w.responses[0].IsTsig() == nil {
+				t.Error("Expected the response to the retransmission to be signed")
+			}
+		})
+	}
+}
+
+func TestRetransmitCache(t *testing.T) {
+	c := newRetransmitCache()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	msg := updateMsg("host.example.com.", false)
+
+	w, first, retransmitted := c.track(&testWriter{remote: udpClient}, msg)
+	if retransmitted || first != nil {
+		t.Fatal("Expected the first transmission to be tracked")
+	}
+
+	// A retransmission received while the first one is applied is not answered
+	if _, first, retransmitted := c.track(&testWriter{remote: udpClient}, msg); !retransmitted || first != nil {
+		t.Errorf("Expected an in-flight retransmission without response, got %v, %v", first, retransmitted)
+	}
+
+	response := new(dns.Msg)
+	response.SetReply(msg)
+	response.SetTsig("router.", dns.HmacSHA256, 300, now.Unix())
+	w.WriteMsg(response)
+	_, first, retransmitted = c.track(&testWriter{remote: udpClient}, msg)
+	if !retransmitted || first == nil || first.Id != msg.Id {
+		t.Fatalf("Expected the response to the first transmission, got %v", first)
+	}
+	if first.IsTsig() != nil {
+		t.Error("Expected the kept response without its TSIG, signed again for each retransmission")
+	}
+
+	// Another zone or another source is another request
+	other := msg.Copy()
+	other.Question[0].Name = "example.net."
+	if _, _, retransmitted := c.track(&testWriter{remote: udpClient}, other); retransmitted {
+		t.Error("Expected an update of another zone to be a new request")
+	}
+	if _, _, retransmitted := c.track(&testWriter{remote: &net.UDPAddr{IP: udpClient.IP, Port: 5354}}, msg); retransmitted {
+		t.Error("Expected a request of another source to be a new request")
+	}
+
+	// The ID is reused after the window
+	now = now.Add(retransmitWindow)
+	if _, _, retransmitted := c.track(&testWriter{remote: udpClient}, msg); retransmitted {
+		t.Error("Expected a request after the window to be a new request")
+	}
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/sig0"
+)
+
+// SetSIG0Messages verifies the SIG(0) signed updates against the raw messages the
+// servers read
+func (h *Handler) SetSIG0Messages(messages *sig0.Messages) {
+	h.sig0Messages = messages
+}
+
+// verifySIG0 verifies the SIG(0) of a request with the keys of SIG0_KEYS, and
+// returns the name of its key; an empty name when SIG(0) is not enabled or the
+// request carries no SIG(0)
+func (h *Handler) verifySIG0(w dns.ResponseWriter, r *dns.Msg) (string, error) {
+	sig := sig0.Signature(r)
+	if sig == nil || h.sig0Messages == nil || len(h.config.SIG0Keys) == 0 {
+		return "", nil
+	}
+	raw, ok := h.sig0Messages.Take(w.RemoteAddr(), r)
+	if !ok {
+		return "", fmt.Errorf("%w: message of %s not kept", dnserr.ErrSIG0BadSignature, sig.SignerName)
+	}
+	signer, err := h.config.SIG0Keys.Verify(raw, sig)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", dnserr.ErrSIG0BadSignature, err)
+	}
+	logrus.Debugf("Request authenticated with SIG(0) from key: %s", signer)
+	return signer, nil
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/replica"
+)
+
+// zoneSerials tracks the SOA serial of the served zones, bumped on every change
+type zoneSerials struct {
+	mu      sync.Mutex
+	serials map[string]uint32
+	// replicator streams the bumped serials to the standby replicas
+	replicator *replica.Replicator
+}
+
+// newZoneSerials creates serials starting at the current time
+func newZoneSerials() *zoneSerials {
+	return &zoneSerials{serials: make(map[string]uint32)}
+}
+
+// get returns the serial of a zone
+func (s *zoneSerials) get(zone string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current(zone)
+}
+
+// bump increments the serial of a zone, keeping it at least the current time
+func (s *zoneSerials) bump(zone string) {
+	zone = strings.ToLower(zone)
+	s.mu.Lock()
+	serial := s.current(zone) + 1
+	if now := uint32(time.Now().Unix()); now > serial {
+		serial = now
+	}
+	s.serials[zone] = serial
+	s.mu.Unlock()
+	s.replicator.Publish(replica.Entry{Kind: replica.KindSerial, Key: zone, Serial: serial})
+}
+
+// Snapshot returns the serials of the zones, implementing replica.State
+func (s *zoneSerials) Snapshot() []replica.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]replica.Entry, 0, len(s.serials))
+	for zone, serial := range s.serials {
+		entries = append(entries, replica.Entry{Kind: replica.KindSerial, Key: zone, Serial: serial})
+	}
+	return entries
+}
+
+// Apply keeps the serial of a zone replicated from another replica when it is
+// ahead, so that a failover does not move serials backwards
+func (s *zoneSerials) Apply(entry replica.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if zone := strings.ToLower(entry.Key); entry.Serial > s.serials[zone] {
+		s.serials[zone] = entry.Serial
+	}
+}
+
+// current returns the serial of a zone, initializing it when unknown
+func (s *zoneSerials) current(zone string) uint32 {
+	zone = strings.ToLower(zone)
+	serial, ok := s.serials[zone]
+	if !ok {
+		serial = uint32(time.Now().Unix())
+		s.serials[zone] = serial
+	}
+	return serial
+}
+
+// serveQuery answers the queries of the served zones, from the cache when possible.
+// It returns false when the query is not answered by the bridge.
+func (h *Handler) serveQuery(w dns.ResponseWriter, r *dns.Msg, msg *dns.Msg) bool {
+	if len(r.Question) != 1 || r.Question[0].Qclass != dns.ClassINET {
+		return false
+	}
+	qname := strings.ToLower(dns.Fqdn(r.Question[0].Name))
+	zone := h.config.ZoneOf(qname)
+	if zone == "" || (h.listener != nil && !h.listener.AllowsZone(zone)) {
+		return false
+	}
+
+	key := queryKey{qname: qname, qtype: r.Question[0].Qtype}
+	result, cached := h.cache.get(key)
+	if cached {
+		metrics.QueryCache.WithLabelValues("hit").Inc()
+	} else {
+		var ok bool
+		var err error
+		if result, ok, err = h.resolve(qname, key.qtype, zone); err != nil {
+			logrus.Errorf("Failed to answer %s query for %s: %v", dns.TypeToString[key.qtype], qname, err)
+			msg.SetRcode(r, dns.RcodeServerFailure)
+			h.writeResponse(w, msg, verifiedTSIG(w, r))
+			return true
+		} else if !ok {
+			return false
+		}
+		if h.cache != nil {
+			metrics.QueryCache.WithLabelValues("miss").Inc()
+		}
+		h.cache.put(key, zone, result)
+	}
+
+	for _, rr := range result.answer {
+		msg.Answer = append(msg.Answer, dns.Copy(rr))
+	}
+	for _, rr := range result.ns {
+		msg.Ns = append(msg.Ns, dns.Copy(rr))
+	}
+	logrus.Debugf("Answered %s query for %s from %s (zone %s, cached: %v)", dns.TypeToString[key.qtype], qname, w.RemoteAddr(), zone, cached)
+	msg.SetRcode(r, dns.RcodeSuccess)
+	h.writeResponse(w, msg, verifiedTSIG(w, r))
+	return true
+}
+
+// resolve builds the answer of a query of a served zone. It returns false for the
+// queries the bridge does not answer.
+func (h *Handler) resolve(qname string, qtype uint16, zone string) (queryResult, bool, error) {
+	switch qtype {
+	case dns.TypeSOA:
+		// SOA at the zone apex, and in the authority section below it so clients can find the zone
+		soa := h.soaRecord(zone)
+		if qname == zone {
+			return queryResult{answer: []dns.RR{soa}}, true, nil
+		}
+		return queryResult{ns: []dns.RR{soa}}, true, nil
+	case dns.TypeANY:
+		result, err := h.resolveAny(qname, zone)
+		return result, err == nil, err
+	default:
+		return queryResult{}, false, nil
+	}
+}
+
+// resolveAny answers an ANY query with every record managed for the name, or with
+// a synthesized HINFO record as RFC 8482 allows when ANY_RESPONSE is hinfo
+func (h *Handler) resolveAny(qname, zone string) (queryResult, error) {
+	soa := h.soaRecord(zone)
+	if h.config.AnyResponse == config.AnyResponseHINFO {
+		hinfo := &dns.HINFO{
+			Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: soa.Minttl},
+			Cpu: "RFC8482",
+		}
+		return queryResult{answer: []dns.RR{hinfo}}, nil
+	}
+
+	records, err := h.k8sClient.LookupRecords(qname, zone)
+	if err != nil {
+		return queryResult{}, err
+	}
+	result := queryResult{}
+	if qname == zone {
+		result.answer = append(result.answer, soa)
+	}
+	for _, record := range records {
+		ttl := uint32(record.TTL)
+		if record.TTL <= 0 {
+			ttl = soa.Minttl
+		}
+		for _, target := range record.Targets {
+			rr, err := recordRR(qname, ttl, record.Type, target)
+			if err != nil {
+				logrus.Debugf("Skipping %s record of %s in ANY answer: %v", record.Type, qname, err)
+				continue
+			}
+			result.answer = append(result.answer, rr)
+		}
+	}
+	// No record for the name: NODATA with the SOA of the zone
+	if len(result.answer) == 0 {
+		result.ns = []dns.RR{soa}
+	}
+	return result, nil
+}
+
+// recordRR builds the resource record of a target in its presentation format
+func recordRR(name string, ttl uint32, recordType, target string) (dns.RR, error) {
+	header := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: ttl}
+	if recordType == "TXT" {
+		header.Rrtype = dns.TypeTXT
+		return &dns.TXT{Hdr: header, Txt: []string{target}}, nil
+	}
+	return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl, recordType, target))
+}
+
+// soaRecord builds the SOA record of a zone from its configured parameters
+func (h *Handler) soaRecord(zone string) *dns.SOA {
+	params := h.config.SOAFor(zone)
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    params.Minimum,
+		},
+		Ns:      params.MName,
+		Mbox:    params.RName,
+		Serial:  h.serials.get(zone),
+		Refresh: params.Refresh,
+		Retry:   params.Retry,
+		Expire:  params.Expire,
+		Minttl:  params.Minimum,
+	}
+}
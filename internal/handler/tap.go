@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnstap"
+)
+
+// SetDnstap logs every received message and sent response to a dnstap output
+func (h *Handler) SetDnstap(output *dnstap.Output) {
+	h.tap = output
+}
+
+// tapWriter logs the response to a message to dnstap
+type tapWriter struct {
+	dns.ResponseWriter
+	tap     *dnstap.Output
+	message dnstap.Message
+}
+
+// tapMessage logs a received message to dnstap and returns the writer logging its response
+func (h *Handler) tapMessage(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	query, err := r.Pack()
+	if err != nil {
+		logrus.Debugf("Failed to pack message from %s for dnstap: %v", w.RemoteAddr(), err)
+		return w
+	}
+
+	message := dnstap.Message{
+		Type:      dnstap.AuthQuery,
+		Client:    w.RemoteAddr(),
+		Server:    w.LocalAddr(),
+		QueryTime: time.Now(),
+		Query:     query,
+	}
+	if r.Opcode == dns.OpcodeUpdate {
+		message.Type = dnstap.UpdateQuery
+	}
+	if stater, ok := w.(dns.ConnectionStater); ok && stater.ConnectionState() != nil {
+		message.TLS = true
+	}
+	if len(r.Question) > 0 {
+		zone := make([]byte, 255)
+		if n, err := dns.PackDomainName(dns.Fqdn(r.Question[0].Name), zone, 0, nil, false); err == nil {
+			message.Zone = zone[:n]
+		}
+	}
+	h.tap.Log(message)
+
+	// The response carries the query and its time, as dnstap expects
+	message.Type++
+	return &tapWriter{ResponseWriter: w, tap: h.tap, message: message}
+}
+
+// Write writes a packed response and logs it
+func (w *tapWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if err == nil {
+		w.log(b)
+	}
+	return n, err
+}
+
+// WriteMsg writes a response and logs it
+func (w *tapWriter) WriteMsg(m *dns.Msg) error {
+	if err := w.ResponseWriter.WriteMsg(m); err != nil {
+		return err
+	}
+	if b, err := m.Pack(); err == nil {
+		w.log(b)
+	}
+	return nil
+}
+
+// ConnectionState returns the TLS state of the connection, if any
+func (w *tapWriter) ConnectionState() *tls.ConnectionState {
+	if stater, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return nil
+}
+
+func (w *tapWriter) log(response []byte) {
+	message := w.message
+	message.ResponseTime = time.Now()
+	message.Response = response
+	w.tap.Log(message)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// timedWriter times the answer to an UPDATE message
+type timedWriter struct {
+	dns.ResponseWriter
+	start time.Time
+	// rcode is the rcode of the response, -1 until it is written
+	rcode int
+}
+
+// timeUpdate returns a writer recording the answer to an UPDATE message, and
+// the function observing its time once the message is served
+func timeUpdate(w dns.ResponseWriter, r *dns.Msg) (dns.ResponseWriter, func()) {
+	timed := &timedWriter{ResponseWriter: w, start: time.Now(), rcode: -1}
+	return timed, func() {
+		// Dropped messages are not answered
+		if timed.rcode < 0 {
+			return
+		}
+		observer := metrics.UpdateDuration.WithLabelValues(dns.RcodeToString[timed.rcode])
+		metrics.ObserveMessage(observer, time.Since(timed.start).Seconds(), w.RemoteAddr().String(), r.Id)
+	}
+}
+
+// Write writes a packed response, as signed responses are, and records its rcode
+func (w *timedWriter) Write(b []byte) (int, error) {
+	if len(b) >= 4 {
+		w.rcode = int(b[3] & 0x0F)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// WriteMsg writes a response and records its rcode
+func (w *timedWriter) WriteMsg(m *dns.Msg) error {
+	w.rcode = m.Rcode
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// ConnectionState returns the TLS state of the connection, if any
+func (w *timedWriter) ConnectionState() *tls.ConnectionState {
+	if stater, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return nil
+}
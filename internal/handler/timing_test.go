@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestServeDNSUpdateDuration(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"ALLOWED_SOURCES": "10.0.0.0/8"})
+	msg := updateMsg("host.example.com.", true)
+	msg.Id = 4243
+	w := &testWriter{remote: udpClient}
+	h.serveDNS(w, msg)
+	if len(w.responses) != 1 || w.responses[0].Rcode != dns.RcodeRefused {
+		t.Fatalf("Expected REFUSED, got %v", w.responses)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather() failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "ddnsbridge4extdns_update_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetLabel()[0].GetValue() != "REFUSED" {
+				continue
+			}
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				labels := map[string]string{}
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				if labels["message_id"] == strconv.Itoa(int(msg.Id)) && labels["source"] == udpClient.String() {
+					return
+				}
+			}
+		}
+	}
+	t.Error("Expected the answer time observed with the message as exemplar")
+}
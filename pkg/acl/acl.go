@@ -0,0 +1,63 @@
+package acl
+
+import (
+	"strings"
+)
+
+// ACL maps identities (TSIG key names, certificate names) to the DNS names they may update
+type ACL map[string][]string
+
+// New builds an ACL from identity to name patterns. Patterns are domain names
+// matching themselves and every name below them, or "*.domain" matching only
+// the names strictly below the domain.
+func New(entries map[string][]string) ACL {
+	a := make(ACL, len(entries))
+	for identity, patterns := range entries {
+		normalized := make([]string, 0, len(patterns))
+		for _, pattern := range patterns {
+			if pattern = normalizeName(pattern); pattern != "." {
+				normalized = append(normalized, pattern)
+			}
+		}
+		a[normalizeName(identity)] = normalized
+	}
+	return a
+}
+
+// Knows checks if an identity has an entry in the ACL
+func (a ACL) Knows(identity string) bool {
+	_, ok := a[normalizeName(identity)]
+	return ok
+}
+
+// Allows checks if an identity may update the given name
+func (a ACL) Allows(identity, name string) bool {
+	patterns, ok := a[normalizeName(identity)]
+	if !ok {
+		return false
+	}
+	name = normalizeName(name)
+	for _, pattern := range patterns {
+		if matches(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks if a normalized name matches a normalized pattern
+func matches(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:])
+	}
+	return name == pattern || strings.HasSuffix(name, "."+pattern)
+}
+
+// normalizeName lowercases a name and ensures it ends with a dot
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	return name
+}
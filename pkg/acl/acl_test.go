@@ -0,0 +1,43 @@
+package acl
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	a := New(map[string][]string{
+		"router1.lan":  {"dyn.example.com"},
+		"ap.lan.":      {"*.iot.example.com", "ap.example.org."},
+		"empty.lan":    {},
+		"Mixed.Case":   {"Lab.Example.Org"},
+		"trailing.lan": {"host.example.net."},
+	})
+
+	tests := []struct {
+		identity string
+		name     string
+		allowed  bool
+	}{
+		{"router1.lan", "dyn.example.com.", true},
+		{"router1.lan", "host.dyn.example.com.", true},
+		{"router1.lan", "otherdyn.example.com.", false},
+		{"router1.lan", "example.com.", false},
+		{"ap.lan", "sensor.iot.example.com", true},
+		{"ap.lan", "iot.example.com.", false},
+		{"ap.lan", "ap.example.org.", true},
+		{"empty.lan", "dyn.example.com.", false},
+		{"mixed.case", "HOST.lab.example.org.", true},
+		{"trailing.lan.", "host.example.net", true},
+		{"unknown.lan", "dyn.example.com.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.identity+"/"+tt.name, func(t *testing.T) {
+			if got := a.Allows(tt.identity, tt.name); got != tt.allowed {
+				t.Errorf("Allows(%s, %s) = %v, want %v", tt.identity, tt.name, got, tt.allowed)
+			}
+		})
+	}
+
+	if !a.Knows("ROUTER1.lan.") || a.Knows("unknown.lan") {
+		t.Error("Knows() does not match the configured identities")
+	}
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// TokenReviewer authenticates bearer tokens and authorizes requests against cluster RBAC
+type TokenReviewer interface {
+	ReviewToken(ctx context.Context, token string, audiences []string) (*k8s.Identity, error)
+	AuthorizeRequest(ctx context.Context, identity *k8s.Identity, path, verb string) (allowed bool, reason string, err error)
+}
+
+// RequireToken only lets through requests carrying a bearer token issued for
+// audience whose user is allowed by RBAC to use the request method on its path
+func RequireToken(reviewer TokenReviewer, audience string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ddnsbridge4extdns"`)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing bearer token"))
+			return
+		}
+
+		identity, err := reviewer.ReviewToken(r.Context(), token, []string{audience})
+		if err != nil {
+			if errors.Is(err, k8s.ErrUnauthenticated) {
+				logrus.Warnf("Admin API rejected token from %s: %v", r.RemoteAddr, err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ddnsbridge4extdns", error="invalid_token"`)
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid bearer token"))
+				return
+			}
+			logrus.Errorf("Admin API failed to review token: %v", err)
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to authenticate request"))
+			return
+		}
+
+		verb := strings.ToLower(r.Method)
+		allowed, reason, err := reviewer.AuthorizeRequest(r.Context(), identity, r.URL.Path, verb)
+		if err != nil {
+			logrus.Errorf("Admin API failed to authorize %s: %v", identity.Username, err)
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to authorize request"))
+			return
+		}
+		if !allowed {
+			logrus.Warnf("Admin API denied %s %s to %s: %s", verb, r.URL.Path, identity.Username, reason)
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s is not allowed to %s %s", identity.Username, verb, r.URL.Path))
+			return
+		}
+
+		logrus.Debugf("Admin API authorized %s %s for %s", verb, r.URL.Path, identity.Username)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
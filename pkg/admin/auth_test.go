@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+type fakeReviewer struct {
+	audiences []string
+}
+
+func (f *fakeReviewer) ReviewToken(_ context.Context, token string, audiences []string) (*k8s.Identity, error) {
+	f.audiences = audiences
+	switch token {
+	case "reader":
+		return &k8s.Identity{Username: "reader"}, nil
+	case "operator":
+		return &k8s.Identity{Username: "operator"}, nil
+	}
+	return nil, k8s.ErrUnauthenticated
+}
+
+func (f *fakeReviewer) AuthorizeRequest(_ context.Context, identity *k8s.Identity, path, verb string) (bool, string, error) {
+	if identity.Username == "operator" {
+		return true, "", nil
+	}
+	return verb == "get", "", nil
+}
+
+func TestRequireToken(t *testing.T) {
+	reviewer := &fakeReviewer{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := RequireToken(reviewer, "ddnsbridge4extdns", next)
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		status int
+	}{
+		{"missing token", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong scheme", http.MethodGet, "Basic reader", http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "Bearer nope", http.StatusUnauthorized},
+		{"reader can read", http.MethodGet, "Bearer reader", http.StatusNoContent},
+		{"reader cannot mutate", http.MethodDelete, "Bearer reader", http.StatusForbidden},
+		{"operator can mutate", http.MethodDelete, "Bearer operator", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/bans", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+
+	if len(reviewer.audiences) != 1 || reviewer.audiences[0] != "ddnsbridge4extdns" {
+		t.Errorf("Expected required audience to be sent, got %v", reviewer.audiences)
+	}
+}
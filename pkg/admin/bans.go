@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ban"
+)
+
+// BanStore holds the temporary bans of abusive sources
+type BanStore interface {
+	List() []ban.Ban
+	Clear(ip string) (int, error)
+}
+
+// ClearBansResponse is the result of clearing bans
+type ClearBansResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// BansHandler lists the active bans
+func BansHandler(store BanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.List())
+	}
+}
+
+// ClearBansHandler lifts the ban of the address given in the "ip" query
+// parameter, or all bans when it is omitted
+func ClearBansHandler(store BanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.URL.Query().Get("ip")
+		cleared, err := store.Clear(ip)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		logrus.Infof("Admin API cleared %d ban(s) (ip: %q) requested by %s", cleared, ip, r.RemoteAddr)
+		writeJSON(w, http.StatusOK, ClearBansResponse{Cleared: cleared})
+	}
+}
@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/ban"
+)
+
+func TestBansHandlers(t *testing.T) {
+	banner := ban.New(1, time.Minute, time.Hour)
+	banner.Fail(&net.UDPAddr{IP: net.ParseIP("203.0.113.1")}, "badsig")
+	banner.Fail(&net.UDPAddr{IP: net.ParseIP("203.0.113.2")}, "zone")
+
+	rec := httptest.NewRecorder()
+	BansHandler(banner).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bans", nil))
+	var bans []ban.Ban
+	if err := json.NewDecoder(rec.Body).Decode(&bans); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(bans) != 2 || bans[0].IP != "203.0.113.1" {
+		t.Errorf("unexpected bans: %v", bans)
+	}
+
+	tests := []struct {
+		url     string
+		cleared int
+	}{
+		{"/bans?ip=203.0.113.1", 1},
+		{"/bans?ip=203.0.113.1", 0},
+		{"/bans", 1},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		ClearBansHandler(banner).ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, tt.url, nil))
+		var resp ClearBansResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid response: %v", err)
+		}
+		if resp.Cleared != tt.cleared {
+			t.Errorf("DELETE %s cleared %d, want %d", tt.url, resp.Cleared, tt.cleared)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package admin
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CaptureFile is the pcap file written by a wire capture
+type CaptureFile interface {
+	Path() string
+}
+
+// CaptureHandler downloads the wire capture, as captured so far when it is still running
+func CaptureHandler(capture CaptureFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logrus.Infof("Admin API capture download requested by %s", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(capture.Path())+`"`)
+		http.ServeFile(w, r, capture.Path())
+	}
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type captureFile string
+
+func (c captureFile) Path() string { return string(c) }
+
+func TestCaptureHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(path, []byte("pcap"), 0o600); err != nil {
+		t.Fatalf("failed to write capture: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	CaptureHandler(captureFile(path)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/capture", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "pcap" {
+		t.Errorf("GET /capture = %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/vnd.tcpdump.pcap" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+)
+
+// maxConfigSize bounds the size of a configuration document
+const maxConfigSize = 1 << 20
+
+// ConfigApplier replaces the running configuration with a configuration document
+type ConfigApplier interface {
+	Apply(values map[string]string, dryRun bool) ([]config.Change, error)
+}
+
+// ConfigResponse is the result of a configuration push
+type ConfigResponse struct {
+	DryRun  bool            `json:"dryRun"`
+	Applied bool            `json:"applied"`
+	Changes []config.Change `json:"changes"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// ConfigHandler applies a configuration document: a JSON object of environment
+// variables, e.g. the data of the ConfigMap of the deployment. The document is
+// validated and diffed against the running configuration, then applied as a whole.
+// A document changing settings that require a restart is refused with 409 and its
+// diff. Setting "dryRun=true" only returns the diff.
+func ConfigHandler(applier ConfigApplier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := false
+		if v := r.URL.Query().Get("dryRun"); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dryRun parameter: %w", err))
+				return
+			}
+			dryRun = parsed
+		}
+
+		var values map[string]string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigSize)).Decode(&values); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid configuration document: %w", err))
+			return
+		}
+
+		changes, err := applier.Apply(values, dryRun)
+		if errors.Is(err, config.ErrRestartRequired) {
+			logrus.Warnf("Admin API configuration push from %s refused: %v", r.RemoteAddr, err)
+			writeJSON(w, http.StatusConflict, ConfigResponse{DryRun: dryRun, Changes: changes, Error: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		applied := !dryRun && len(changes) > 0
+		for _, change := range changes {
+			logrus.Infof("Admin API configuration push from %s: %s changed from %v to %v (dry run: %v)",
+				r.RemoteAddr, change.Field, change.Old, change.New, dryRun)
+		}
+		writeJSON(w, http.StatusOK, ConfigResponse{DryRun: dryRun, Applied: applied, Changes: changes})
+	}
+}
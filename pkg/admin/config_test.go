@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/config"
+)
+
+type fakeApplier struct {
+	values map[string]string
+	dryRun bool
+	err    error
+}
+
+func (f *fakeApplier) Apply(values map[string]string, dryRun bool) ([]config.Change, error) {
+	f.values, f.dryRun = values, dryRun
+	return []config.Change{{Field: "AllowedZones", Old: []string{"example.com"}, New: []string{"example.org"}, Live: true}}, f.err
+}
+
+func TestConfigHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		body        string
+		err         error
+		wantStatus  int
+		wantApplied bool
+	}{
+		{"invalid dry run", "/config?dryRun=maybe", `{}`, nil, http.StatusBadRequest, false},
+		{"invalid document", "/config", `{"ALLOWED_ZONES": ["example.org"]}`, nil, http.StatusBadRequest, false},
+		{"applied", "/config", `{"ALLOWED_ZONES": "example.org"}`, nil, http.StatusOK, true},
+		{"dry run", "/config?dryRun=true", `{"ALLOWED_ZONES": "example.org"}`, nil, http.StatusOK, false},
+		{"restart required", "/config", `{"PORT": "53"}`, fmt.Errorf("%w: Port", config.ErrRestartRequired), http.StatusConflict, false},
+		{"invalid configuration", "/config", `{"UNSIGNED_REQUESTS": "sometimes"}`, fmt.Errorf("invalid configuration"), http.StatusUnprocessableEntity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applier := &fakeApplier{err: tt.err}
+			rec := httptest.NewRecorder()
+			ConfigHandler(applier).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK && tt.wantStatus != http.StatusConflict {
+				return
+			}
+			var resp ConfigResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if resp.Applied != tt.wantApplied || len(resp.Changes) != 1 || resp.Changes[0].Field != "AllowedZones" {
+				t.Errorf("response = %+v, want applied %v with the AllowedZones change", resp, tt.wantApplied)
+			}
+		})
+	}
+}
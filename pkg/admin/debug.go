@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// NewDebugServer creates a server of the pprof profiles on addr, kept apart from
+// the admin API since the profiles are neither authenticated nor cheap
+func NewDebugServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
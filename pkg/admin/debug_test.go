@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugServer(t *testing.T) {
+	server := NewDebugServer("127.0.0.1:0")
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/debug/pprof/", http.StatusOK, "goroutine"},
+		{"/debug/pprof/heap?debug=1", http.StatusOK, "heap profile"},
+		{"/debug/pprof/cmdline", http.StatusOK, ""},
+		{"/bans", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("Expected %q in the body of %s", tt.body, tt.path)
+			}
+		})
+	}
+}
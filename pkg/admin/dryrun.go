@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// dnsMessageType is the media type of wire-format DNS messages (RFC 8484)
+const dnsMessageType = "application/dns-message"
+
+// UpdateDryRunner evaluates a hypothetical UPDATE message sent from source and
+// signed with keyName, an empty keyName for an unsigned message
+type UpdateDryRunner interface {
+	DryRunUpdate(ctx context.Context, msg *dns.Msg, source net.IP, keyName string) DryRunResult
+}
+
+// DryRunRequest is a hypothetical UPDATE of a zone. Updates use the syntax of
+// nsupdate: "add <name> <ttl> [class] <type> <data>", or "delete <name> [<type> [<data>]]".
+type DryRunRequest struct {
+	Zone    string   `json:"zone"`
+	Updates []string `json:"updates"`
+}
+
+// DryRunResult is the outcome of a hypothetical UPDATE
+type DryRunResult struct {
+	// Rcode is the response code the client would be answered with
+	Rcode string `json:"rcode"`
+	// Error explains a refusal, and Kind is its class
+	Error string `json:"error,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	// Skipped are the records of the update section that are ignored
+	Skipped []string `json:"skipped,omitempty"`
+	// Updates are the updates of the message, as applied
+	Updates []string `json:"updates,omitempty"`
+	// Changes are the changes of resources the message would make, up to a refusal
+	Changes []k8s.Change `json:"changes"`
+}
+
+// DryRunHandler evaluates a hypothetical UPDATE with the policies of the running
+// configuration and returns the changes of resources it would make, without applying
+// them. The body is a DryRunRequest, or a wire-format message with the
+// application/dns-message content type. The "source" query parameter is the address
+// of the client, and "key" the TSIG key it would sign with, which does not need to be
+// configured yet; a wire-format message defaults to the key of its TSIG record.
+func DryRunHandler(runner UpdateDryRunner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		source := net.ParseIP(query.Get("source"))
+		if source == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid or missing source parameter: %q", query.Get("source")))
+			return
+		}
+		keyName := query.Get("key")
+
+		body := http.MaxBytesReader(w, r.Body, dns.MaxMsgSize)
+		var msg *dns.Msg
+		if strings.HasPrefix(r.Header.Get("Content-Type"), dnsMessageType) {
+			data, err := io.ReadAll(body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("failed to read message: %w", err))
+				return
+			}
+			msg = new(dns.Msg)
+			if err := msg.Unpack(data); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid DNS message: %w", err))
+				return
+			}
+			if tsig := msg.IsTsig(); tsig != nil && keyName == "" {
+				keyName = tsig.Hdr.Name
+			}
+		} else {
+			var req DryRunRequest
+			decoder := json.NewDecoder(body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dry run request: %w", err))
+				return
+			}
+			var err error
+			if msg, err = updateMessage(req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if keyName != "" {
+			keyName = dns.Fqdn(keyName)
+		}
+
+		logrus.Infof("Admin API dry run of an UPDATE of %d record(s) from %s (key: %q) requested by %s", len(msg.Ns), source, keyName, r.RemoteAddr)
+		writeJSON(w, http.StatusOK, runner.DryRunUpdate(r.Context(), msg, source, keyName))
+	}
+}
+
+// updateMessage builds the UPDATE message of a DryRunRequest
+func updateMessage(req DryRunRequest) (*dns.Msg, error) {
+	if req.Zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(req.Zone))
+	for _, line := range req.Updates {
+		if err := addUpdate(msg, line); err != nil {
+			return nil, fmt.Errorf("invalid update %q: %w", line, err)
+		}
+	}
+	return msg, nil
+}
+
+// addUpdate adds an update in nsupdate syntax to the update section of msg
+func addUpdate(msg *dns.Msg, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return fmt.Errorf("expected add or delete and a name")
+	}
+	name := dns.Fqdn(fields[1])
+	switch strings.ToLower(fields[0]) {
+	case "add":
+		rr, err := dns.NewRR(name + " " + strings.Join(fields[2:], " "))
+		if err != nil {
+			return err
+		}
+		if rr == nil {
+			return fmt.Errorf("missing record")
+		}
+		msg.Insert([]dns.RR{rr})
+	case "delete":
+		switch len(fields) {
+		case 2:
+			msg.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: name}}})
+		case 3:
+			rrtype, ok := dns.StringToType[strings.ToUpper(fields[2])]
+			if !ok {
+				return fmt.Errorf("unknown type %s", fields[2])
+			}
+			msg.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: rrtype}}})
+		default:
+			rr, err := dns.NewRR(name + " 0 IN " + strings.Join(fields[2:], " "))
+			if err != nil {
+				return err
+			}
+			msg.Remove([]dns.RR{rr})
+		}
+	default:
+		return fmt.Errorf("unknown command %s, expected add or delete", fields[0])
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+type fakeDryRunner struct {
+	msg     *dns.Msg
+	source  net.IP
+	keyName string
+}
+
+func (f *fakeDryRunner) DryRunUpdate(_ context.Context, msg *dns.Msg, source net.IP, keyName string) DryRunResult {
+	f.msg, f.source, f.keyName = msg, source, keyName
+	return DryRunResult{Rcode: "NOERROR", Changes: []k8s.Change{{Action: k8s.ChangeCreate, Kind: "DNSEndpoint", Name: "host"}}}
+}
+
+func TestDryRunHandler(t *testing.T) {
+	signed := new(dns.Msg)
+	signed.SetUpdate("example.com.")
+	signed.Insert([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("192.0.2.1")}})
+	signed.SetTsig("router.", dns.HmacSHA256, 300, 0)
+	wire, err := signed.Pack()
+	if err != nil {
+		t.Fatalf("Pack() failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		body        []byte
+		wantStatus  int
+		wantKey     string
+		wantNs      []uint16
+	}{
+		{"missing source", "/dryrun", "application/json", []byte(`{"zone":"example.com"}`), http.StatusBadRequest, "", nil},
+		{"invalid update", "/dryrun?source=192.0.2.10", "application/json", []byte(`{"zone":"example.com","updates":["replace host.example.com"]}`), http.StatusBadRequest, "", nil},
+		{"missing zone", "/dryrun?source=192.0.2.10", "application/json", []byte(`{"updates":[]}`), http.StatusBadRequest, "", nil},
+		{"json", "/dryrun?source=192.0.2.10&key=router", "application/json",
+			[]byte(`{"zone":"example.com","updates":["add host.example.com 300 A 192.0.2.1","delete old.example.com AAAA","delete gone.example.com","delete host.example.com A 192.0.2.2"]}`),
+			http.StatusOK, "router.", []uint16{dns.ClassINET, dns.ClassANY, dns.ClassANY, dns.ClassNONE}},
+		{"unsigned", "/dryrun?source=192.0.2.10", "application/json", []byte(`{"zone":"example.com","updates":["add host.example.com 300 A 192.0.2.1"]}`),
+			http.StatusOK, "", []uint16{dns.ClassINET}},
+		{"wire format", "/dryrun?source=192.0.2.10", "application/dns-message", wire, http.StatusOK, "router.", []uint16{dns.ClassINET}},
+		{"invalid wire format", "/dryrun?source=192.0.2.10", "application/dns-message", []byte{1, 2, 3}, http.StatusBadRequest, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeDryRunner{}
+			req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			DryRunHandler(runner).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if runner.keyName != tt.wantKey || !runner.source.Equal(net.ParseIP("192.0.2.10")) {
+				t.Errorf("runner called with key %q from %s, want %q", runner.keyName, runner.source, tt.wantKey)
+			}
+			if runner.msg.Question[0].Name != "example.com." || len(runner.msg.Ns) != len(tt.wantNs) {
+				t.Fatalf("unexpected message %v", runner.msg)
+			}
+			for i, class := range tt.wantNs {
+				if runner.msg.Ns[i].Header().Class != class {
+					t.Errorf("update %d has class %d, want %d", i, runner.msg.Ns[i].Header().Class, class)
+				}
+			}
+			var resp DryRunResult
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if resp.Rcode != "NOERROR" || len(resp.Changes) != 1 {
+				t.Errorf("unexpected response %+v", resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/diag"
+)
+
+// Dumper captures diagnostic dumps
+type Dumper interface {
+	Log(reason string) diag.Dump
+}
+
+// DumpHandler writes a diagnostic dump to the log and returns it
+func DumpHandler(dumper Dumper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logrus.Infof("Admin API diagnostic dump requested by %s", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, dumper.Log("admin API"))
+	}
+}
@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/diag"
+)
+
+func TestDumpHandler(t *testing.T) {
+	dumper := diag.NewDumper()
+	dumper.Register("bans", func() interface{} { return []string{"203.0.113.1"} })
+
+	rec := httptest.NewRecorder()
+	DumpHandler(dumper).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/dump", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	var dump diag.Dump
+	if err := json.NewDecoder(rec.Body).Decode(&dump); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if dump.Stacks == "" || dump.Goroutines == 0 {
+		t.Error("expected goroutine stacks in the dump")
+	}
+	if bans, ok := dump.Sections["bans"].([]interface{}); !ok || len(bans) != 1 {
+		t.Errorf("unexpected bans section: %v", dump.Sections["bans"])
+	}
+}
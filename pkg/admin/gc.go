@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// RequesterCollector deletes records by the requester that last refreshed them
+type RequesterCollector interface {
+	CollectByRequester(ctx context.Context, sel k8s.RequesterSelector, dryRun bool) ([]string, error)
+}
+
+// GCResponse is the result of a garbage collection
+type GCResponse struct {
+	DryRun  bool     `json:"dryRun"`
+	Deleted []string `json:"deleted"`
+}
+
+// GCHandler removes all records last asked-by the requester given in the
+// "requester" (IP address) and/or "key" (TSIG key name) query parameters.
+// Setting "dryRun=true" only lists the records that would be removed.
+func GCHandler(collector RequesterCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		sel := k8s.RequesterSelector{
+			Addr:    query.Get("requester"),
+			KeyName: query.Get("key"),
+		}
+		if sel.IsEmpty() {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("requester or key parameter is required"))
+			return
+		}
+
+		dryRun := false
+		if v := query.Get("dryRun"); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dryRun parameter: %w", err))
+				return
+			}
+			dryRun = parsed
+		}
+
+		logrus.Infof("Admin API garbage collection requested by %s for requester %s (dry run: %v)", r.RemoteAddr, sel, dryRun)
+		deleted, err := collector.CollectByRequester(r.Context(), sel, dryRun)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, GCResponse{DryRun: dryRun, Deleted: deleted})
+	}
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+type fakeCollector struct {
+	sel    k8s.RequesterSelector
+	dryRun bool
+}
+
+func (f *fakeCollector) CollectByRequester(_ context.Context, sel k8s.RequesterSelector, dryRun bool) ([]string, error) {
+	f.sel, f.dryRun = sel, dryRun
+	return []string{"router"}, nil
+}
+
+func TestGCHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantSel    k8s.RequesterSelector
+		wantDryRun bool
+	}{
+		{"missing selector", "/gc", http.StatusBadRequest, k8s.RequesterSelector{}, false},
+		{"invalid dry run", "/gc?requester=192.168.1.1&dryRun=maybe", http.StatusBadRequest, k8s.RequesterSelector{}, false},
+		{"by requester", "/gc?requester=192.168.1.1", http.StatusOK, k8s.RequesterSelector{Addr: "192.168.1.1"}, false},
+		{"by key dry run", "/gc?key=router&dryRun=true", http.StatusOK, k8s.RequesterSelector{KeyName: "router"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &fakeCollector{}
+			rec := httptest.NewRecorder()
+			GCHandler(collector).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.url, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if collector.sel != tt.wantSel || collector.dryRun != tt.wantDryRun {
+				t.Errorf("collector called with %v/%v, want %v/%v", collector.sel, collector.dryRun, tt.wantSel, tt.wantDryRun)
+			}
+			var resp GCResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(resp.Deleted) != 1 || resp.Deleted[0] != "router" {
+				t.Errorf("unexpected deleted list: %v", resp.Deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// maxImportSize caps the size of an imported document
+const maxImportSize = 16 << 20
+
+// RecordExporter lists the managed records
+type RecordExporter interface {
+	ExportRecords(ctx context.Context) ([]k8s.Record, error)
+}
+
+// RecordImporter applies a set of records
+type RecordImporter interface {
+	ImportRecords(ctx context.Context, records []k8s.Record, zones []string, dryRun bool) (k8s.ImportResult, error)
+}
+
+// RecordsDocument is the JSON document exported and imported by the records endpoints
+type RecordsDocument struct {
+	Records []k8s.Record `json:"records"`
+}
+
+// ImportResponse is the result of a bulk import
+type ImportResponse struct {
+	DryRun bool `json:"dryRun"`
+	k8s.ImportResult
+}
+
+// ExportHandler returns all managed records as a RecordsDocument
+func ExportHandler(exporter RecordExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		records, err := exporter.ExportRecords(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, RecordsDocument{Records: records})
+	}
+}
+
+// ImportHandler applies the records of a RecordsDocument within the allowed zones.
+// The whole document is validated before any record is written.
+// Setting "dryRun=true" only validates the document.
+func ImportHandler(importer RecordImporter, zones []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := false
+		if v := r.URL.Query().Get("dryRun"); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dryRun parameter: %w", err))
+				return
+			}
+			dryRun = parsed
+		}
+
+		var doc RecordsDocument
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&doc); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid records document: %w", err))
+			return
+		}
+
+		logrus.Infof("Admin API import of %d record(s) requested by %s (dry run: %v)", len(doc.Records), r.RemoteAddr, dryRun)
+		result, err := importer.ImportRecords(r.Context(), doc.Records, zones, dryRun)
+		if err != nil {
+			// Nothing was written when the document is invalid
+			status := http.StatusInternalServerError
+			if errors.Is(err, k8s.ErrInvalidRecord) {
+				status = http.StatusUnprocessableEntity
+			}
+			writeError(w, status, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ImportResponse{DryRun: dryRun, ImportResult: result})
+	}
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+type fakeRecords struct {
+	records []k8s.Record
+	dryRun  bool
+}
+
+func (f *fakeRecords) ExportRecords(_ context.Context) ([]k8s.Record, error) {
+	return f.records, nil
+}
+
+func (f *fakeRecords) ImportRecords(_ context.Context, records []k8s.Record, _ []string, dryRun bool) (k8s.ImportResult, error) {
+	for _, record := range records {
+		if record.Type != "A" {
+			return k8s.ImportResult{}, fmt.Errorf("%w: %s", k8s.ErrInvalidRecord, record.Type)
+		}
+	}
+	f.records, f.dryRun = records, dryRun
+	return k8s.ImportResult{Applied: len(records)}, nil
+}
+
+func TestExportHandler(t *testing.T) {
+	store := &fakeRecords{records: []k8s.Record{{Name: "web.example.com", Type: "A", TTL: 300, Targets: []string{"192.0.2.1"}}}}
+	rec := httptest.NewRecorder()
+	ExportHandler(store).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/records", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var doc RecordsDocument
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(doc.Records) != 1 || doc.Records[0].Name != "web.example.com" {
+		t.Errorf("unexpected records: %+v", doc.Records)
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		body       string
+		wantStatus int
+		wantDryRun bool
+	}{
+		{"import", "/records", `{"records":[{"name":"web.example.com","type":"A","ttl":300,"targets":["192.0.2.1"]}]}`, http.StatusOK, false},
+		{"dry run", "/records?dryRun=true", `{"records":[{"name":"web.example.com","type":"A","ttl":300,"targets":["192.0.2.1"]}]}`, http.StatusOK, true},
+		{"invalid dry run", "/records?dryRun=maybe", `{"records":[]}`, http.StatusBadRequest, false},
+		{"malformed JSON", "/records", `{"records":`, http.StatusBadRequest, false},
+		{"unknown field", "/records", `{"records":[],"zones":[]}`, http.StatusBadRequest, false},
+		{"invalid record", "/records", `{"records":[{"name":"web.example.com","type":"MX","targets":["mail"]}]}`, http.StatusUnprocessableEntity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeRecords{}
+			rec := httptest.NewRecorder()
+			ImportHandler(store, []string{"example.com"}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp ImportResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if resp.DryRun != tt.wantDryRun || store.dryRun != tt.wantDryRun || resp.Applied != 1 {
+				t.Errorf("unexpected response %+v (importer dry run: %v)", resp, store.dryRun)
+			}
+		})
+	}
+}
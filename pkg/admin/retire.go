@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/retire"
+)
+
+// ZoneRetirer decommissions zones
+type ZoneRetirer interface {
+	Plan(ctx context.Context, zone string, options retire.Options) (retire.Retirement, error)
+	Retire(ctx context.Context, zone string, options retire.Options) (retire.Retirement, error)
+	Cancel(zone string) error
+	List() []retire.Retirement
+}
+
+// RetireHandler retires the zone given in the "zone" query parameter: its updates
+// are refused at once, and its managed records removed after the "grace" duration
+// (0 by default). "export=true" keeps a copy of the records in the snapshot bucket
+// first, "tombstone=true" keeps the emptied resources instead of deleting them.
+// Setting "dryRun=true" only lists the records that would be removed.
+func RetireHandler(retirer ZoneRetirer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		zone := query.Get("zone")
+		if zone == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("zone parameter is required"))
+			return
+		}
+
+		var options retire.Options
+		if v := query.Get("grace"); v != "" {
+			grace, err := time.ParseDuration(v)
+			if err != nil || grace < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid grace parameter: %q", v))
+				return
+			}
+			options.Grace = grace
+		}
+		dryRun := false
+		flags := []struct {
+			name  string
+			value *bool
+		}{{"export", &options.Export}, {"tombstone", &options.Tombstone}, {"dryRun", &dryRun}}
+		for _, flag := range flags {
+			if v := query.Get(flag.name); v != "" {
+				parsed, err := strconv.ParseBool(v)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s parameter: %w", flag.name, err))
+					return
+				}
+				*flag.value = parsed
+			}
+		}
+
+		if dryRun {
+			plan, err := retirer.Plan(r.Context(), zone, options)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, plan)
+			return
+		}
+
+		logrus.Infof("Admin API retirement of zone %s requested by %s (grace: %s, export: %v, tombstone: %v)",
+			zone, r.RemoteAddr, options.Grace, options.Export, options.Tombstone)
+		retirement, err := retirer.Retire(r.Context(), zone, options)
+		if errors.Is(err, retire.ErrRetiring) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, retirement)
+	}
+}
+
+// RetirementsHandler lists the retirements of zones
+func RetirementsHandler(retirer ZoneRetirer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, retirer.List())
+	}
+}
+
+// CancelRetirementHandler cancels the retirement of the zone given in the "zone"
+// query parameter, which accepts updates again
+func CancelRetirementHandler(retirer ZoneRetirer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zone := r.URL.Query().Get("zone")
+		if zone == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("zone parameter is required"))
+			return
+		}
+		if err := retirer.Cancel(zone); err != nil {
+			if errors.Is(err, retire.ErrNotRetiring) {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		logrus.Infof("Admin API retirement of zone %s cancelled by %s", zone, r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/retire"
+)
+
+type fakeRetirer struct {
+	zone      string
+	options   retire.Options
+	planned   bool
+	retiring  bool
+	cancelled string
+}
+
+func (f *fakeRetirer) Plan(_ context.Context, zone string, options retire.Options) (retire.Retirement, error) {
+	f.zone, f.options, f.planned = zone, options, true
+	return retire.Retirement{Zone: zone, Records: []string{"router"}}, nil
+}
+
+func (f *fakeRetirer) Retire(_ context.Context, zone string, options retire.Options) (retire.Retirement, error) {
+	if f.retiring {
+		return retire.Retirement{}, fmt.Errorf("%w: %s", retire.ErrRetiring, zone)
+	}
+	f.zone, f.options = zone, options
+	return retire.Retirement{Zone: zone, State: retire.StateRetiring}, nil
+}
+
+func (f *fakeRetirer) Cancel(zone string) error {
+	if !f.retiring {
+		return fmt.Errorf("%w: %s", retire.ErrNotRetiring, zone)
+	}
+	f.cancelled = zone
+	return nil
+}
+
+func (f *fakeRetirer) List() []retire.Retirement {
+	return []retire.Retirement{{Zone: "example.com", State: retire.StateRetired}}
+}
+
+func TestRetireHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		retiring    bool
+		wantStatus  int
+		wantOptions retire.Options
+		wantPlanned bool
+	}{
+		{"missing zone", "/zones/retire", false, http.StatusBadRequest, retire.Options{}, false},
+		{"invalid grace", "/zones/retire?zone=example.com&grace=-1h", false, http.StatusBadRequest, retire.Options{}, false},
+		{"invalid export", "/zones/retire?zone=example.com&export=maybe", false, http.StatusBadRequest, retire.Options{}, false},
+		{"dry run", "/zones/retire?zone=example.com&tombstone=true&dryRun=true", false, http.StatusOK, retire.Options{Tombstone: true}, true},
+		{"retire", "/zones/retire?zone=example.com&grace=1h&export=true", false, http.StatusAccepted, retire.Options{Grace: time.Hour, Export: true}, false},
+		{"already retiring", "/zones/retire?zone=example.com", true, http.StatusConflict, retire.Options{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retirer := &fakeRetirer{retiring: tt.retiring}
+			rec := httptest.NewRecorder()
+			RetireHandler(retirer).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.url, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code >= 300 {
+				return
+			}
+			if retirer.zone != "example.com" || retirer.options != tt.wantOptions || retirer.planned != tt.wantPlanned {
+				t.Errorf("retirer called with %s/%+v/%v, want example.com/%+v/%v",
+					retirer.zone, retirer.options, retirer.planned, tt.wantOptions, tt.wantPlanned)
+			}
+			var resp retire.Retirement
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Zone != "example.com" {
+				t.Errorf("invalid response %+v: %v", resp, err)
+			}
+		})
+	}
+}
+
+func TestCancelRetirementHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		retiring   bool
+		wantStatus int
+	}{
+		{"missing zone", "/zones/retire", true, http.StatusBadRequest},
+		{"not retiring", "/zones/retire?zone=example.com", false, http.StatusNotFound},
+		{"cancelled", "/zones/retire?zone=example.com", true, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retirer := &fakeRetirer{retiring: tt.retiring}
+			rec := httptest.NewRecorder()
+			CancelRetirementHandler(retirer).ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, tt.url, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNoContent && retirer.cancelled != "example.com" {
+				t.Errorf("Expected example.com to be cancelled, got %q", retirer.cancelled)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Server serves the admin HTTP API
+type Server struct {
+	mux    *http.ServeMux
+	server *http.Server
+
+	// localMux serves the unix socket, whose file permissions replace tokens
+	localMux     *http.ServeMux
+	socketServer *http.Server
+
+	// protect wraps the handlers registered with Handle, when set
+	protect func(http.Handler) http.Handler
+}
+
+// NewServer creates a new admin API server listening on addr
+func NewServer(addr string) *Server {
+	mux := http.NewServeMux()
+	localMux := http.NewServeMux()
+	return &Server{
+		mux: mux,
+		server: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		localMux: localMux,
+		socketServer: &http.Server{
+			Handler:           localMux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// RequireToken protects the handlers registered afterwards with Handle by
+// bearer tokens reviewed by the cluster for the given audience
+func (s *Server) RequireToken(reviewer TokenReviewer, audience string) {
+	s.protect = func(next http.Handler) http.Handler {
+		return RequireToken(reviewer, audience, next)
+	}
+}
+
+// Handle registers a handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.localMux.Handle(pattern, handler)
+	if s.protect != nil {
+		handler = s.protect(handler)
+	}
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleRestricted registers a handler never served without a token: on the
+// listen address only once RequireToken is called, and on the unix socket
+func (s *Server) HandleRestricted(pattern string, handler http.Handler) {
+	s.localMux.Handle(pattern, handler)
+	if s.protect != nil {
+		s.mux.Handle(pattern, s.protect(handler))
+	}
+}
+
+// HandleFunc registers a handler function for the given pattern
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// HandlePublic registers a handler that never requires a token, such as probes
+func (s *Server) HandlePublic(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+	s.localMux.Handle(pattern, handler)
+}
+
+// ListenAndServe serves the admin API until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	logrus.Infof("Starting admin API on %s", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ServeUnix serves the admin API on a unix socket until Shutdown is called. The
+// socket is created with the given permissions, which control access instead of
+// bearer tokens; a stale socket left at path is replaced.
+func (s *Server) ServeUnix(path string, mode os.FileMode) error {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	logrus.Infof("Starting admin API on unix socket %s (mode %04o)", path, mode)
+	if err := s.socketServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the admin API
+func (s *Server) Shutdown(ctx context.Context) error {
+	return errors.Join(s.server.Shutdown(ctx), s.socketServer.Shutdown(ctx))
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("Failed to write admin API response: %v", err)
+	}
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
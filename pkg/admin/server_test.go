@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	// A stale socket left by a previous process is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server := NewServer("")
+	server.RequireToken(&fakeReviewer{}, "ddnsbridge4extdns")
+	server.Handle("GET /bans", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	errs := make(chan error, 1)
+	go func() { errs <- server.ServeUnix(path, 0600) }()
+	defer server.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://admin/bans"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Request over the socket failed: %v", err)
+	}
+	resp.Body.Close()
+	// Tokens are not required on the socket
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", resp.StatusCode)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected socket mode 0600, got %04o", info.Mode().Perm())
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() failed: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("ServeUnix() failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected socket to be removed on shutdown")
+	}
+}
+
+func TestHandleRestricted(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	tests := []struct {
+		name     string
+		auth     bool
+		token    string
+		expected int
+	}{
+		{name: "not served without authentication", expected: http.StatusNotFound},
+		{name: "token required with authentication", auth: true, expected: http.StatusUnauthorized},
+		{name: "served with a token", auth: true, token: "operator", expected: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer("")
+			if tt.auth {
+				server.RequireToken(&fakeReviewer{}, "ddnsbridge4extdns")
+			}
+			server.HandleRestricted("POST /config", next)
+
+			req := httptest.NewRequest(http.MethodPost, "/config", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			server.mux.ServeHTTP(rec, req)
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rec.Code)
+			}
+
+			// The unix socket is guarded by its permissions
+			rec = httptest.NewRecorder()
+			server.localMux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config", nil))
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("Expected status 204 on the socket, got %d", rec.Code)
+			}
+		})
+	}
+}
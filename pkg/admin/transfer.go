@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// OwnershipTransferrer hands the records of a requester over to another
+type OwnershipTransferrer interface {
+	TransferOwnership(ctx context.Context, from, to k8s.RequesterSelector, dryRun bool) ([]string, error)
+}
+
+// TransferResponse is the result of an ownership transfer
+type TransferResponse struct {
+	DryRun      bool     `json:"dryRun"`
+	Transferred []string `json:"transferred"`
+}
+
+// TransferHandler transfers the records of the TSIG key given in the "from" query
+// parameter to the key given in "to". "requester" restricts the transfer to the
+// records last refreshed from an IP address, and "toRequester" sets the address of
+// the new owner. Setting "dryRun=true" only lists the records that would be transferred.
+func TransferHandler(transferrer OwnershipTransferrer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		from := k8s.RequesterSelector{Addr: query.Get("requester"), KeyName: query.Get("from")}
+		to := k8s.RequesterSelector{Addr: query.Get("toRequester"), KeyName: query.Get("to")}
+		if from.KeyName == "" || to.KeyName == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("from and to parameters are required"))
+			return
+		}
+
+		dryRun := false
+		if v := query.Get("dryRun"); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dryRun parameter: %w", err))
+				return
+			}
+			dryRun = parsed
+		}
+
+		logrus.Infof("Admin API ownership transfer requested by %s from %s to %s (dry run: %v)", r.RemoteAddr, from, to, dryRun)
+		transferred, err := transferrer.TransferOwnership(r.Context(), from, to, dryRun)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, TransferResponse{DryRun: dryRun, Transferred: transferred})
+	}
+}
@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+type fakeTransferrer struct {
+	from, to k8s.RequesterSelector
+	dryRun   bool
+}
+
+func (f *fakeTransferrer) TransferOwnership(_ context.Context, from, to k8s.RequesterSelector, dryRun bool) ([]string, error) {
+	f.from, f.to, f.dryRun = from, to, dryRun
+	return []string{"router"}, nil
+}
+
+func TestTransferHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantFrom   k8s.RequesterSelector
+		wantTo     k8s.RequesterSelector
+		wantDryRun bool
+	}{
+		{"missing new key", "/transfer?from=old", http.StatusBadRequest, k8s.RequesterSelector{}, k8s.RequesterSelector{}, false},
+		{"invalid dry run", "/transfer?from=old&to=new&dryRun=maybe", http.StatusBadRequest, k8s.RequesterSelector{}, k8s.RequesterSelector{}, false},
+		{"by key", "/transfer?from=old&to=new", http.StatusOK,
+			k8s.RequesterSelector{KeyName: "old"}, k8s.RequesterSelector{KeyName: "new"}, false},
+		{"with addresses dry run", "/transfer?from=old&to=new&requester=192.168.1.1&toRequester=192.168.1.2&dryRun=true", http.StatusOK,
+			k8s.RequesterSelector{Addr: "192.168.1.1", KeyName: "old"}, k8s.RequesterSelector{Addr: "192.168.1.2", KeyName: "new"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transferrer := &fakeTransferrer{}
+			rec := httptest.NewRecorder()
+			TransferHandler(transferrer).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.url, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if transferrer.from != tt.wantFrom || transferrer.to != tt.wantTo || transferrer.dryRun != tt.wantDryRun {
+				t.Errorf("transferrer called with %v/%v/%v, want %v/%v/%v",
+					transferrer.from, transferrer.to, transferrer.dryRun, tt.wantFrom, tt.wantTo, tt.wantDryRun)
+			}
+			var resp TransferResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(resp.Transferred) != 1 || resp.Transferred[0] != "router" {
+				t.Errorf("unexpected transferred list: %v", resp.Transferred)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Package audit writes an append-only trail of the accepted updates, one JSON
+// object per line, to a file rotated by size
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// Stdout is the path writing the trail to the standard output, without rotation
+const Stdout = "-"
+
+// Kubernetes actions resulting from an update
+const (
+	ActionCreated  = "created"
+	ActionUpdated  = "updated"
+	ActionDeleted  = "deleted"
+	ActionDeferred = "deferred"
+)
+
+// Entry is an accepted update, with the records of its name and type before
+// and after it
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Key        string    `json:"key"`
+	Zone       string    `json:"zone"`
+	Name       string    `json:"name"`
+	RecordType string    `json:"recordType"`
+	Update     string    `json:"update"`
+	Old        []string  `json:"old"`
+	New        []string  `json:"new"`
+	Action     string    `json:"action"`
+}
+
+// Log appends entries to a file, renamed with a .1 suffix once it reaches
+// maxSize bytes, the older files shifting up to maxFiles
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File
+	size int64
+}
+
+// Open opens the trail at path, appending to it when it exists, and keeping
+// maxFiles rotated files. A maxSize of 0 never rotates it.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if path == Stdout {
+		l.out = os.Stdout
+		return l, nil
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the file of the trail and measures it
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file, l.out, l.size = file, file, info.Size()
+	return nil
+}
+
+// Record appends an entry. Failures are logged and counted, they never fail the
+// update that was already applied.
+func (l *Log) Record(entry Entry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		metrics.AuditFailures.Inc()
+		logrus.Errorf("Failed to encode audit entry of %s: %v", entry.Name, err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			metrics.AuditFailures.Inc()
+			logrus.Errorf("Failed to rotate audit log: %v", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.out.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		metrics.AuditFailures.Inc()
+		logrus.Errorf("Failed to write audit entry of %s: %v", entry.Name, err)
+	}
+}
+
+// rotate shifts the rotated files and starts a new file, or goes on appending to
+// the current one when it cannot be shifted
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		logrus.Warnf("Failed to close audit log: %v", err)
+	}
+	l.file = nil
+	err := l.shift()
+	if openErr := l.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift renames the file to its .1 suffix and the rotated files to the next one,
+// dropping the oldest
+func (l *Log) shift() error {
+	os.Remove(rotated(l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotated(l.path, i), rotated(l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, rotated(l.path, 1))
+}
+
+// Close closes the file of the trail
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// rotated returns the path of the rotated file i
+func rotated(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readEntries reads the entries of a trail file
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open(%s) failed: %v", path, err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid entry %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 0, 3)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	entry := Entry{
+		Time:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Client:     "192.0.2.1",
+		Key:        "dhcp.",
+		Zone:       "example.com.",
+		Name:       "host.example.com.",
+		RecordType: "A",
+		Update:     "UPDATE",
+		Old:        []string{"192.0.2.10"},
+		New:        []string{"192.0.2.10", "192.0.2.11"},
+		Action:     ActionUpdated,
+	}
+	l.Record(entry)
+	l.Close()
+
+	// Reopening appends to the trail
+	if l, err = Open(path, 0, 3); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	entry.Action = ActionDeleted
+	l.Record(entry)
+	l.Close()
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if got := entries[0]; got.Client != "192.0.2.1" || got.Key != "dhcp." || len(got.New) != 2 || got.Action != ActionUpdated {
+		t.Errorf("Unexpected entry %+v", got)
+	}
+	if entries[1].Action != ActionDeleted {
+		t.Errorf("Expected the appended entry last, got %+v", entries[1])
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	entry := Entry{Name: "host.example.com.", Action: ActionCreated}
+	line, _ := json.Marshal(entry)
+	// Two entries fit in a file
+	l, err := Open(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 7; i++ {
+		l.Record(entry)
+	}
+
+	tests := []struct {
+		path    string
+		entries int
+	}{
+		{path, 1},
+		{path + ".1", 2},
+		{path + ".2", 2},
+	}
+	for _, tt := range tests {
+		if got := len(readEntries(t, tt.path)); got != tt.entries {
+			t.Errorf("%s holds %d entries, want %d", filepath.Base(tt.path), got, tt.entries)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no more than 2 rotated files, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package ban
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// errBanned closes TCP connections of banned sources
+var errBanned = errors.New("source is banned")
+
+// Ban is a temporary ban of a source address
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// Banner bans sources refused too often within a time window
+type Banner struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	store     Store
+	now       func() time.Time
+}
+
+// New creates a Banner banning a source for duration once it was refused
+// threshold times within window
+func New(threshold int, window, duration time.Duration) *Banner {
+	return NewWithStore(threshold, window, duration, NewMemoryStore())
+}
+
+// NewWithStore creates a Banner keeping refusals and bans in store. Errors of
+// the store are logged and never ban a source.
+func NewWithStore(threshold int, window, duration time.Duration, store Store) *Banner {
+	return &Banner{
+		threshold: threshold,
+		window:    window,
+		duration:  duration,
+		store:     store,
+		now:       time.Now,
+	}
+}
+
+// Fail records a refusal of a source and bans it when it reaches the threshold.
+// A nil Banner records nothing.
+func (b *Banner) Fail(addr net.Addr, reason string) {
+	if b == nil {
+		return
+	}
+	ip := hostOf(addr)
+	if ip == "" {
+		return
+	}
+
+	now := b.now()
+	count, err := b.store.Fail(ip, now, now.Add(-b.window))
+	if err != nil {
+		logrus.Errorf("Failed to record refusal of %s: %v", ip, err)
+		return
+	}
+	if count < b.threshold {
+		return
+	}
+
+	if err := b.store.Ban(Ban{IP: ip, Reason: reason, Until: now.Add(b.duration)}, now); err != nil {
+		logrus.Errorf("Failed to ban %s: %v", ip, err)
+		return
+	}
+	metrics.Bans.WithLabelValues(reason).Inc()
+	logrus.Warnf("Banned %s for %s after %d refusals within %s (last: %s)", ip, b.duration, count, b.window, reason)
+}
+
+// Banned checks if a source is currently banned
+func (b *Banner) Banned(addr net.Addr) bool {
+	if b == nil {
+		return false
+	}
+	ip := hostOf(addr)
+
+	_, banned, err := b.store.Get(ip, b.now())
+	if err != nil {
+		logrus.Errorf("Failed to check ban of %s: %v", ip, err)
+		return false
+	}
+	return banned
+}
+
+// List returns the active bans sorted by address
+func (b *Banner) List() []Ban {
+	bans, err := b.store.List(b.now())
+	if err != nil {
+		logrus.Errorf("Failed to list bans: %v", err)
+		return []Ban{}
+	}
+	sortBans(bans)
+	return bans
+}
+
+// Clear lifts the ban and forgets the refusals of a source, or of all sources
+// when ip is empty, and returns the number of lifted bans
+func (b *Banner) Clear(ip string) (int, error) {
+	return b.store.Clear(ip)
+}
+
+// DecorateReader drops the packets and connections of banned sources before
+// they are parsed, to be set as dns.Server DecorateReader
+func (b *Banner) DecorateReader(reader dns.Reader) dns.Reader {
+	return &bannedReader{Reader: reader, banner: b}
+}
+
+// bannedReader is a dns.Reader skipping the messages of banned sources
+type bannedReader struct {
+	dns.Reader
+	banner *Banner
+}
+
+// ReadTCP closes the connection of a banned source
+func (r *bannedReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	if r.banner.Banned(conn.RemoteAddr()) {
+		metrics.BannedRequests.Inc()
+		return nil, errBanned
+	}
+	return r.Reader.ReadTCP(conn, timeout)
+}
+
+// ReadUDP reads messages until one comes from a source that is not banned
+func (r *bannedReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	for {
+		m, session, err := r.Reader.ReadUDP(conn, timeout)
+		if err != nil || !r.banner.Banned(session.RemoteAddr()) {
+			return m, session, err
+		}
+		metrics.BannedRequests.Inc()
+	}
+}
+
+// hostOf returns the IP address of a network address
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return strings.TrimSpace(addr.String())
+	}
+	return host
+}
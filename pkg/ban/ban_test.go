@@ -0,0 +1,68 @@
+package ban
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBanner(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := New(3, time.Minute, 10*time.Minute)
+	b.now = func() time.Time { return now }
+
+	attacker := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000}
+	other := &net.TCPAddr{IP: net.ParseIP("203.0.113.2"), Port: 40000}
+
+	// Refusals spread over more than the window do not ban
+	b.Fail(attacker, "badsig")
+	now = now.Add(2 * time.Minute)
+	b.Fail(attacker, "badsig")
+	b.Fail(attacker, "badsig")
+	if b.Banned(attacker) {
+		t.Fatal("Expected no ban below the threshold within the window")
+	}
+
+	b.Fail(attacker, "zone")
+	if !b.Banned(attacker) {
+		t.Fatal("Expected ban at the threshold")
+	}
+	// Any port of the address is banned
+	if !b.Banned(&net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 53}) {
+		t.Error("Expected ban to apply to the whole address")
+	}
+	if b.Banned(other) {
+		t.Error("Expected other sources not to be banned")
+	}
+
+	bans := b.List()
+	if len(bans) != 1 || bans[0].IP != "203.0.113.1" || bans[0].Reason != "zone" {
+		t.Errorf("Unexpected bans: %v", bans)
+	}
+
+	// Bans expire
+	now = now.Add(10 * time.Minute)
+	if b.Banned(attacker) {
+		t.Error("Expected ban to expire")
+	}
+
+	// Bans can be cleared
+	for i := 0; i < 3; i++ {
+		b.Fail(other, "notsigned")
+	}
+	if cleared, err := b.Clear("203.0.113.2"); err != nil || cleared != 1 {
+		t.Errorf("Expected 1 cleared ban, got %d", cleared)
+	}
+	if b.Banned(other) {
+		t.Error("Expected ban to be cleared")
+	}
+}
+
+func TestNilBanner(t *testing.T) {
+	var b *Banner
+	addr := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000}
+	b.Fail(addr, "badsig")
+	if b.Banned(addr) {
+		t.Error("Expected a nil banner to ban nothing")
+	}
+}
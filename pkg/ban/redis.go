@@ -0,0 +1,207 @@
+package ban
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/redis"
+)
+
+// redisStore is a Store shared by the replicas through Redis. The refusals of a
+// source are a sorted set of timestamps, its ban a JSON value expiring with it.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a Store keeping refusals and bans in Redis, under keys
+// starting with prefix
+func NewRedisStore(client *redis.Client, prefix string) Store {
+	return &redisStore{client: client, prefix: prefix}
+}
+
+func (s *redisStore) failuresKey(ip string) string { return s.prefix + "ban:failures:" + ip }
+func (s *redisStore) banKey(ip string) string      { return s.prefix + "ban:ip:" + ip }
+
+func (s *redisStore) Fail(ip string, now, cutoff time.Time) (int, error) {
+	key := s.failuresKey(ip)
+	window := now.Sub(cutoff)
+	// Members are random so that simultaneous refusals on several replicas all count
+	member := make([]byte, 8)
+	if _, err := rand.Read(member); err != nil {
+		return 0, err
+	}
+	replies, err := s.client.Pipeline(context.Background(),
+		[]string{"MULTI"},
+		[]string{"ZREMRANGEBYSCORE", key, "-inf", strconv.FormatInt(cutoff.UnixMicro(), 10)},
+		[]string{"ZADD", key, strconv.FormatInt(now.UnixMicro(), 10), hex.EncodeToString(member)},
+		[]string{"ZCARD", key},
+		[]string{"PEXPIRE", key, strconv.FormatInt(max(window.Milliseconds(), 1), 10)},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, err
+	}
+	results, err := execResults(replies)
+	if err != nil {
+		return 0, err
+	}
+	count, err := redis.Int(results[2], nil)
+	return int(count), err
+}
+
+func (s *redisStore) Ban(ban Ban, now time.Time) error {
+	value, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	ttl := max(ban.Until.Sub(now).Milliseconds(), 1)
+	replies, err := s.client.Pipeline(context.Background(),
+		[]string{"SET", s.banKey(ban.IP), string(value), "PX", strconv.FormatInt(ttl, 10)},
+		[]string{"DEL", s.failuresKey(ban.IP)},
+	)
+	if err != nil {
+		return err
+	}
+	return replyError(replies)
+}
+
+func (s *redisStore) Get(ip string, now time.Time) (Ban, bool, error) {
+	value, err := redis.String(s.client.Do(context.Background(), "GET", s.banKey(ip)))
+	if errors.Is(err, redis.ErrNil) {
+		return Ban{}, false, nil
+	}
+	if err != nil {
+		return Ban{}, false, err
+	}
+	var ban Ban
+	if err := json.Unmarshal([]byte(value), &ban); err != nil {
+		return Ban{}, false, fmt.Errorf("invalid ban of %s: %w", ip, err)
+	}
+	return ban, now.Before(ban.Until), nil
+}
+
+func (s *redisStore) List(now time.Time) ([]Ban, error) {
+	keys, err := s.scan(s.banKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	bans := make([]Ban, 0, len(keys))
+	if len(keys) == 0 {
+		return bans, nil
+	}
+	reply, err := s.client.Do(context.Background(), append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	for _, value := range values {
+		// Bans expiring between the scan and the read are nil
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var ban Ban
+		if err := json.Unmarshal([]byte(text), &ban); err != nil || !now.Before(ban.Until) {
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func (s *redisStore) Clear(ip string) (int, error) {
+	ctx := context.Background()
+	if ip != "" {
+		replies, err := s.client.Pipeline(ctx,
+			[]string{"DEL", s.banKey(ip)},
+			[]string{"DEL", s.failuresKey(ip)},
+		)
+		if err != nil {
+			return 0, err
+		}
+		if err := replyError(replies); err != nil {
+			return 0, err
+		}
+		cleared, err := redis.Int(replies[0], nil)
+		return int(cleared), err
+	}
+
+	cleared, err := s.deleteMatching(ctx, s.banKey("*"))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.deleteMatching(ctx, s.failuresKey("*")); err != nil {
+		return cleared, err
+	}
+	return cleared, nil
+}
+
+// deleteMatching deletes the keys matching a pattern and returns their number
+func (s *redisStore) deleteMatching(ctx context.Context, pattern string) (int, error) {
+	keys, err := s.scan(pattern)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	deleted, err := redis.Int(s.client.Do(ctx, append([]string{"DEL"}, keys...)...))
+	return int(deleted), err
+}
+
+// scan returns the keys matching a pattern
+func (s *redisStore) scan(pattern string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := s.client.Do(context.Background(), "SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %v", reply)
+		}
+		cursor, _ = parts[0].(string)
+		batch, _ := parts[1].([]interface{})
+		for _, key := range batch {
+			if k, ok := key.(string); ok {
+				keys = append(keys, k)
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// execResults returns the results of the commands of a MULTI/EXEC pipeline
+func execResults(replies []interface{}) ([]interface{}, error) {
+	if err := replyError(replies); err != nil {
+		return nil, err
+	}
+	results, ok := replies[len(replies)-1].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("transaction aborted")
+	}
+	for _, result := range results {
+		if err, ok := result.(redis.Error); ok {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// replyError returns the first error reply of a pipeline
+func replyError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package ban
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/redis"
+)
+
+// fakeRedis implements the commands of the Redis store, without expiry
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]int64
+}
+
+func newFakeRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	f := &fakeRedis{strings: map[string]string{}, sets: map[string]map[string]int64{}}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	var queued []string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch {
+		case args[0] == "MULTI":
+			inMulti = true
+			io.WriteString(c, "+OK\r\n")
+		case args[0] == "EXEC":
+			inMulti = false
+			io.WriteString(c, fmt.Sprintf("*%d\r\n%s", len(queued), strings.Join(queued, "")))
+			queued = nil
+		case inMulti:
+			queued = append(queued, f.exec(args))
+			io.WriteString(c, "+QUEUED\r\n")
+		default:
+			io.WriteString(c, f.exec(args))
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch args[0] {
+	case "GET":
+		if v, ok := f.strings[args[1]]; ok {
+			return bulk(v)
+		}
+		return "$-1\r\n"
+	case "SET":
+		f.strings[args[1]] = args[2]
+		return "+OK\r\n"
+	case "MGET":
+		out := fmt.Sprintf("*%d\r\n", len(args)-1)
+		for _, key := range args[1:] {
+			if v, ok := f.strings[key]; ok {
+				out += bulk(v)
+			} else {
+				out += "$-1\r\n"
+			}
+		}
+		return out
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := f.strings[key]; ok {
+				deleted++
+			}
+			if _, ok := f.sets[key]; ok {
+				deleted++
+			}
+			delete(f.strings, key)
+			delete(f.sets, key)
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "ZADD":
+		if f.sets[args[1]] == nil {
+			f.sets[args[1]] = map[string]int64{}
+		}
+		score, _ := strconv.ParseInt(args[2], 10, 64)
+		f.sets[args[1]][args[3]] = score
+		return ":1\r\n"
+	case "ZREMRANGEBYSCORE":
+		maxScore, _ := strconv.ParseInt(args[3], 10, 64)
+		for member, score := range f.sets[args[1]] {
+			if score <= maxScore {
+				delete(f.sets[args[1]], member)
+			}
+		}
+		return ":0\r\n"
+	case "ZCARD":
+		return fmt.Sprintf(":%d\r\n", len(f.sets[args[1]]))
+	case "PEXPIRE":
+		return ":1\r\n"
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		var keys []string
+		for key := range f.strings {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range f.sets {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		out := fmt.Sprintf("*2\r\n%s*%d\r\n", bulk("0"), len(keys))
+		for _, key := range keys {
+			out += bulk(key)
+		}
+		return out
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func TestRedisBanner(t *testing.T) {
+	client := redis.New(redis.Options{Addr: newFakeRedis(t)})
+	defer client.Close()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Two replicas share the refusals and bans
+	first := NewWithStore(3, time.Minute, 10*time.Minute, NewRedisStore(client, "test:"))
+	second := NewWithStore(3, time.Minute, 10*time.Minute, NewRedisStore(client, "test:"))
+	first.now = func() time.Time { return now }
+	second.now = func() time.Time { return now }
+
+	attacker := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000}
+	first.Fail(attacker, "badsig")
+	now = now.Add(2 * time.Minute)
+	first.Fail(attacker, "badsig")
+	second.Fail(attacker, "badsig")
+	if first.Banned(attacker) || second.Banned(attacker) {
+		t.Fatal("Expected no ban below the threshold within the window")
+	}
+
+	second.Fail(attacker, "zone")
+	if !first.Banned(attacker) {
+		t.Fatal("Expected the ban of a replica to apply to the others")
+	}
+	bans := first.List()
+	if len(bans) != 1 || bans[0].IP != "203.0.113.1" || bans[0].Reason != "zone" {
+		t.Errorf("Unexpected bans: %v", bans)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if second.Banned(attacker) {
+		t.Error("Expected ban to expire")
+	}
+
+	now = now.Add(-10 * time.Minute)
+	if cleared, err := second.Clear(""); err != nil || cleared != 1 {
+		t.Errorf("Clear() = %d, %v, expected 1 cleared ban", cleared, err)
+	}
+	if first.Banned(attacker) {
+		t.Error("Expected ban to be cleared")
+	}
+}
+
+func TestRedisBannerUnreachable(t *testing.T) {
+	client := redis.New(redis.Options{Addr: "127.0.0.1:1", Timeout: 100 * time.Millisecond})
+	b := NewWithStore(1, time.Minute, time.Minute, NewRedisStore(client, "test:"))
+	addr := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 40000}
+	b.Fail(addr, "badsig")
+	if b.Banned(addr) {
+		t.Error("Expected an unreachable store to ban nothing")
+	}
+}
@@ -0,0 +1,118 @@
+package ban
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store keeps the refusals and bans of sources. Stores shared between
+// replicas enforce bans consistently across them.
+type Store interface {
+	// Fail records a refusal of ip at now and returns its refusals within window
+	Fail(ip string, now, cutoff time.Time) (int, error)
+	// Ban bans a source until ban.Until and forgets its refusals
+	Ban(ban Ban, now time.Time) error
+	// Get returns the active ban of ip
+	Get(ip string, now time.Time) (Ban, bool, error)
+	// List returns the active bans
+	List(now time.Time) ([]Ban, error)
+	// Clear lifts the ban and forgets the refusals of ip, or of all sources
+	// when ip is empty, and returns the number of lifted bans
+	Clear(ip string) (int, error)
+}
+
+// memoryStore is the Store of a single replica
+type memoryStore struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	bans     map[string]Ban
+}
+
+// NewMemoryStore creates a Store local to this replica
+func NewMemoryStore() Store {
+	return &memoryStore{
+		failures: make(map[string][]time.Time),
+		bans:     make(map[string]Ban),
+	}
+}
+
+func (s *memoryStore) Fail(ip string, now, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recent := s.failures[ip][:0]
+	for _, t := range s.failures[ip] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	s.failures[ip] = append(recent, now)
+	return len(s.failures[ip]), nil
+}
+
+func (s *memoryStore) Ban(ban Ban, _ time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, ban.IP)
+	s.bans[ban.IP] = ban
+	return nil
+}
+
+func (s *memoryStore) Get(ip string, now time.Time) (Ban, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ban, ok := s.bans[ip]
+	if !ok {
+		return Ban{}, false, nil
+	}
+	if !now.Before(ban.Until) {
+		delete(s.bans, ip)
+		logrus.Infof("Ban of %s expired", ip)
+		return Ban{}, false, nil
+	}
+	return ban, true, nil
+}
+
+func (s *memoryStore) List(now time.Time) ([]Ban, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bans := make([]Ban, 0, len(s.bans))
+	for ip, ban := range s.bans {
+		if !now.Before(ban.Until) {
+			delete(s.bans, ip)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func (s *memoryStore) Clear(ip string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ip == "" {
+		cleared := len(s.bans)
+		s.bans = make(map[string]Ban)
+		s.failures = make(map[string][]time.Time)
+		return cleared, nil
+	}
+
+	delete(s.failures, ip)
+	if _, ok := s.bans[ip]; !ok {
+		return 0, nil
+	}
+	delete(s.bans, ip)
+	return 1, nil
+}
+
+// sortBans orders bans by address
+func sortBans(bans []Ban) {
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+}
@@ -3,9 +3,21 @@ package config
 import (
 	"encoding/base64"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/quirks"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/sig0"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/zoneauth"
 )
 
 // Config holds the server configuration
@@ -18,32 +30,682 @@ type Config struct {
 	TSIGKey       string
 	TSIGSecret    string
 	TSIGAlgorithm string
+	// Additional keys accepted besides TSIG_KEY, each answered with its own key
+	TSIGKeys []TSIGKeySpec
+	// File of additional keys, one per line, added to TSIGKeys
+	TSIGKeysFile string
+	// Secret (namespace/name) holding TSIG secrets by key name, watched for rotations
+	TSIGSecretRef string
+	// Keys read from TSIGSecretRef, replacing the secrets of the keys above of the
+	// same name; set while running by Live.SetSecretKeys
+	SecretKeys []TSIGKeySpec
+	// Time a replaced TSIG secret keeps verifying requests, none when zero
+	TSIGRotationOverlap time.Duration
+	// Weakest TSIG algorithm accepted from clients and for keys, any when empty
+	TSIGMinAlgorithm string
+	// Public keys of the SIG(0) signers of each zone, read from their files
+	SIG0Keys sig0.Keys
+	// Answer of unsigned requests not authenticated otherwise: "answer" or "refuse"
+	UnsignedRequests string
+	// Accept the UPDATE messages without credentials in every allowed zone
+	// (REQUIRE_TSIG=false), not only in the zones of UNSIGNED_ZONES
+	AcceptUnsigned bool
+
+	// Fudge (seconds) used when signing responses
+	TSIGFudge int
+	// Clock skew accepted on signed requests beyond the fudge they carry
+	TSIGSkewTolerance time.Duration
+
+	// DNS-over-TLS listener, disabled when TLSPort is 0
+	TLSPort         int
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	// Names each TLS client certificate identity (CN or DNS SAN) may update
+	CertACLs map[string][]string
 
-	// Kubernetes settings
+	// Kubernetes settings: the namespace of the pod when running in-cluster,
+	// NamespaceAll for every namespace with NamespaceTemplate or ZoneNamespaces
 	Namespace string
+	// Template deriving the namespace of each record from its hostname, Namespace when empty
+	NamespaceTemplate string
+	// Namespaces of the records of zones, by zone ending with a dot, taking
+	// precedence over NamespaceTemplate and Namespace
+	ZoneNamespaces map[string]string
+
+	// DynamicRecord settings: write updates to DynamicRecords projected into DNSEndpoints
+	DynamicRecords            bool
+	DynamicRecordsAutoApprove bool
+
+	// RecordEvent history settings
+	RecordEvents          bool
+	RecordEventsRetention time.Duration
+	RecordEventsPerRecord int
 
 	// Zone settings
 	AllowedZones []string
+	// How the zone section of an update matches AllowedZones: "strict" or "suffix"
+	ZoneMatching string
+	// Wildcard patterns of zones accepted in addition to AllowedZones
+	ZonePatterns []string
+	// Zones each TSIG key may update in addition to AllowedZones
+	KeyZones map[string][]string
+	// Also accept the zones listed in AllowedZone resources
+	ZoneResources bool
+	// Decoy zones whose updates are accepted, ignored and logged
+	TrapZones []string
+	// Keys allowed to update each zone, any key when a zone is not listed
+	ZoneKeys map[string][]string
+	// Zones each TSIG key is restricted to, any allowed zone when a key is not listed
+	KeyScopes acl.ACL
+	// Networks allowed to update each zone without TSIG
+	UnsignedZones map[string][]*net.IPNet
+	// Networks allowed to send messages, any when empty, and networks allowed to
+	// update each zone, any allowed source when a zone is not listed
+	AllowedSources []*net.IPNet
+	ZoneSources    map[string][]*net.IPNet
+	// Records of a zone removed when not refreshed within a multiple of their TTL,
+	// by zone, checked every TTLExpiryInterval
+	TTLExpiry         map[string]int
+	TTLExpiryInterval time.Duration
 
 	// Custom labels for DNSEndpoint resources
 	CustomLabels map[string]string
 
+	// Response for unsupported opcodes/classes: "notimp", "refused" or "drop"
+	UnsupportedResponse string
+
+	// Admin API listen address, disabled when empty
+	AdminAddr string
+	// Unix socket the admin API is also served on, without tokens, disabled when empty
+	AdminSocket     string
+	AdminSocketMode os.FileMode
+	// Listen address of the pprof profiles, disabled when empty
+	DebugAddr string
+
+	// Require Kubernetes bearer tokens on the admin API
+	AdminAuth bool
+	// Audience admin API tokens must be issued for
+	AdminTokenAudience string
+
+	// Interval of the RBAC self-check gating readiness, disabled when 0
+	RBACCheckInterval time.Duration
+	// Interval of the Kubernetes API and CRD check gating readiness, disabled when 0
+	BackendCheckInterval time.Duration
+
+	// Interval of the DNSEndpoint compaction, disabled when 0
+	CompactionInterval time.Duration
+
+	// Aggregate the records of each requester into one DNSEndpoint
+	GroupByRequester bool
+
+	// Naming of the resources of the records, hyphenated or subdomain
+	ResourceNaming string
+
+	// Updates of a message written per batch, and messages writing at once (0: unbounded)
+	UpdateBatchSize   int
+	UpdateConcurrency int
+
+	// Records of the prerequisite and update sections of a message above which it
+	// is refused before being unpacked (0: unbounded)
+	MaxUpdateRecords int
+
+	// Minimum interval between two writes of a resource, later updates being deferred (0: disabled)
+	WriteInterval time.Duration
+
+	// Attempts of a Kubernetes write failing on a transient error, and the waits
+	// between them, doubled up to the maximum
+	BackendRetryAttempts   int
+	BackendRetryBackoff    time.Duration
+	BackendRetryMaxBackoff time.Duration
+
+	// Read the DNSEndpoints from a watch instead of getting them from the API server
+	EndpointCache bool
+
+	// Journal of the messages failing on an unavailable API server, a file or
+	// "memory" (disabled when empty), its maximum of messages, and the interval
+	// between two attempts to write them
+	WriteJournal               string
+	WriteJournalMaxMessages    int
+	WriteJournalReplayInterval time.Duration
+
+	// Workers writing the messages after they are answered (0: written before),
+	// the messages they queue, and whether a full queue refuses or blocks
+	AsyncWorkers   int
+	AsyncQueueSize int
+	AsyncQueueFull string
+
+	// Window during which a message writing the same records again is answered
+	// without a write (0: disabled), and the records remembered
+	DedupWindow    time.Duration
+	DedupCacheSize int
+
+	// Dnstap output of received messages and sent responses, disabled when empty,
+	// and the identity sent with them (the hostname when empty)
+	DnstapOutput   string
+	DnstapIdentity string
+
+	// Wire capture of the messages of some clients or zones to a pcap file, disabled
+	// when empty, stopped at CaptureMaxSize bytes or after CaptureDuration
+	CaptureFile     string
+	CaptureClients  []string
+	CaptureZones    []string
+	CaptureMaxSize  int
+	CaptureDuration time.Duration
+
+	// Kafka brokers accepted updates are published to, disabled when empty, with the
+	// topic, event encoding ("json" or "avro") and partition key ("zone", "name" or "none")
+	KafkaBrokers      []string
+	KafkaTopic        string
+	KafkaFormat       string
+	KafkaPartitionKey string
+	KafkaTLS          bool
+
+	// File of the audit trail of accepted updates ("-" for stdout), disabled when
+	// empty, rotated at AuditLogMaxSize bytes (0: never) keeping AuditLogMaxFiles files
+	AuditLog         string
+	AuditLogMaxSize  int
+	AuditLogMaxFiles int
+
+	// Messages of a TCP connection processed at once, answered out of order (0: one at a time)
+	TCPPipelineDepth int
+	// TCP session limits (0: unlimited): connections per source address and per key,
+	// and transactions of a key processed at once
+	TCPMaxConnsPerSource int
+	TCPMaxConnsPerKey    int
+	TCPMaxInflightPerKey int
+	// Messages per second of a source address (0: unlimited), and the bursts allowed
+	RateLimit      float64
+	RateLimitBurst int
+	// Read the PROXY protocol header of the TCP connections of the trusted networks,
+	// any when empty
+	ProxyProtocol        bool
+	ProxyTrustedNetworks []*net.IPNet
+
+	// Label dimensions of the tenant metrics (zone, key, type, source), disabled when
+	// empty, and the label combinations kept per metric before overflow
+	MetricsDimensions   []string
+	MetricsSeriesBudget int
+
+	// ACME DNS-01 challenge settings: accept TXT updates of _acme-challenge names
+	ACMEChallenges      bool
+	ACMEChallengeMaxAge time.Duration
+
+	// Accept the PTR and DHCID updates of Windows DHCP servers
+	WindowsDHCP bool
+
+	// Record types accepted from the clients among A, AAAA, CNAME, SRV, MX and NS
+	RecordTypes map[uint16]bool
+
+	// Accept CNAME updates of names below the zones
+	CNAMERecords bool
+
+	// Accept SRV updates, such as the service locators of Active Directory
+	SRVRecords bool
+
+	// Quirk profiles of known clients, by TSIG key name or source CIDR
+	ClientQuirks quirks.Rules
+
+	// Refuse updates of names owned by a client with another DHCID
+	DHCIDEnforce bool
+
+	// Temporary bans of sources refused BanThreshold times within BanWindow, disabled when 0
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+
+	// Redis server sharing the bans and refusals between replicas, disabled when empty
+	RedisAddr      string
+	RedisPassword  string
+	RedisDB        int
+	RedisTLS       bool
+	RedisKeyPrefix string
+
+	// Replication of the accepted-update state (zone serials, write schedule) to
+	// standby replicas: the address streaming it, disabled when empty, the replicas
+	// followed, and the token authenticating the streams
+	ReplicationListen string
+	ReplicationPeers  []string
+	ReplicationToken  string
+
+	// S3-compatible bucket receiving the zone snapshots of the snapshot subcommand
+	SnapshotBucket    string
+	SnapshotEndpoint  string
+	SnapshotRegion    string
+	SnapshotPrefix    string
+	SnapshotAccessKey string
+	SnapshotSecretKey string
+	// Number of snapshots kept per zone
+	SnapshotRetention int
+
+	// External DNS server receiving the records of the cluster from the push subcommand,
+	// host:port, over PushNet ("udp" or "tcp")
+	PushServer string
+	PushNet    string
+	// Key signing the pushed updates, one of TSIG_KEY and TSIG_KEYS
+	PushKey string
+	// Label selector of the pushed DNSEndpoints, every DNSEndpoint when empty
+	PushSelector string
+
+	// Additional listeners restricted to a subset of zones and keys
+	Listeners []Listener
+
+	// SOA answering for the allowed zones, with default and per-zone parameters
+	ServeSOA    bool
+	SOADefaults SOAParams
+	SOAZones    map[string]SOAParams
+	// Answer of ANY queries in serving mode: "records" or "hinfo" (RFC 8482)
+	AnyResponse string
+
+	// Cache of query answers, invalidated on update; disabled when the size is 0
+	QueryCacheSize int
+	QueryCacheTTL  time.Duration
+
+	// Reachability probe of the targets of new records, disabled when ProbeNetwork is empty
+	ProbeNetwork string
+	ProbePort    int
+	ProbeTimeout time.Duration
+	ProbeAction  string
+
+	// Resolution of updates of the same name by different sources
+	ConflictPolicy string
+	// Priority of key names (TSIG keys or certificate identities); names written
+	// with a key cannot be overwritten by a key of lower priority
+	KeyPriorities map[string]int
+
+	// MaxMind databases used to label records with the country and ASN of the requester
+	GeoIPCountryDB string
+	GeoIPASNDB     string
+
+	// Interval of the checks of the configuration file, reloaded when it changes,
+	// disabled when 0
+	ConfigWatchInterval time.Duration
+
 	// Logging
 	LogLevel string
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadConfig() (*Config, error) {
+// Supported values for UnsupportedResponse
+const (
+	UnsupportedResponseNotImp  = "notimp"
+	UnsupportedResponseRefused = "refused"
+	UnsupportedResponseDrop    = "drop"
+)
+
+// Supported values for UnsignedRequests
+const (
+	// UnsignedRequestsAnswer answers unsigned requests without signature
+	UnsignedRequestsAnswer = "answer"
+	// UnsignedRequestsRefuse refuses every unsigned request not authenticated by a client certificate
+	UnsignedRequestsRefuse = "refuse"
+)
+
+// Supported values for AnyResponse
+const (
+	AnyResponseRecords = "records"
+	AnyResponseHINFO   = "hinfo"
+)
+
+// Supported values for ZoneMatching
+const (
+	// ZoneMatchingStrict only accepts the allowed zones themselves as zone section
+	ZoneMatchingStrict = "strict"
+	// ZoneMatchingSuffix also accepts any zone below an allowed zone
+	ZoneMatchingSuffix = "suffix"
+)
+
+// Supported values for ProbeAction
+const (
+	ProbeActionRefuse = "refuse"
+	ProbeActionFlag   = "flag"
+)
+
+// Supported values for ConflictPolicy
+const (
+	ConflictLastWriterWins = "last-writer-wins"
+	ConflictFirstOwnerWins = "first-owner-wins"
+	ConflictMerge          = "merge"
+)
+
+// Supported values for ResourceNaming
+const (
+	// ResourceNamingHyphenated names resources after the hostname relative to the
+	// zone, dots replaced with hyphens
+	ResourceNamingHyphenated = "hyphenated"
+	// ResourceNamingSubdomain names resources after the full DNS name, dots kept
+	ResourceNamingSubdomain = "subdomain"
+	// ResourceNamingHashSuffix names resources after the hyphenated hostname,
+	// followed by a digest of the full DNS name
+	ResourceNamingHashSuffix = "hash-suffix"
+	// ResourceNamingZonePrefixed names resources after the hyphenated zone and
+	// hostname
+	ResourceNamingZonePrefixed = "zone-prefixed"
+)
+
+// Supported values for AsyncQueueFull
+const (
+	// AsyncQueueFullRefuse answers SERVFAIL to the messages finding their queue full
+	AsyncQueueFullRefuse = "refuse"
+	// AsyncQueueFullBlock holds the messages finding their queue full until there
+	// is room
+	AsyncQueueFullBlock = "block"
+)
+
+// NamespaceAll is the NAMESPACE selecting every namespace, when records are
+// written to the namespaces rendered by NAMESPACE_TEMPLATE or of ZONE_NAMESPACES
+const NamespaceAll = "all"
+
+// serviceAccountNamespaceFile holds the namespace of the pod when running in-cluster
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LoadConfig loads configuration from the flags, environment variables, and from
+// the configuration file when one was loaded
+func LoadConfig(flags Flags) (*Config, error) {
+	return load(flags.getenv)
+}
+
+// LoadDocument loads a configuration document: values of environment variables by
+// name, read over the environment of the process. An empty value restores the
+// default of a variable, and unknown variables are refused.
+func LoadDocument(values map[string]string) (*Config, error) {
+	return loadDocument(values, nil)
+}
+
+// loadDocument loads a configuration document read over flags and the environment
+func loadDocument(values map[string]string, flags Flags) (*Config, error) {
+	return loadKnown(values, func(key string) string {
+		if value, ok := values[key]; ok {
+			return value
+		}
+		return flags.getenv(key)
+	})
+}
+
+// loadKnown loads the configuration from lookup, refusing the values that are not
+// variables of the configuration
+func loadKnown(values map[string]string, lookup func(key string) string) (*Config, error) {
+	known := make(map[string]bool, len(values))
+	cfg, err := load(func(key string) string {
+		known[key] = true
+		return lookup(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	unknown := make([]string, 0)
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown variables: %s", strings.Join(unknown, ", "))
+	}
+	return cfg, nil
+}
+
+// load loads the configuration from the variables returned by getenv
+func load(getenv func(key string) string) (*Config, error) {
+	env, err := withSecretFiles(getenv)
+	if err != nil {
+		return nil, err
+	}
 	cfg := &Config{
-		ListenAddr:    getEnv("LISTEN_ADDR", "0.0.0.0"),
-		Port:          getEnvInt("PORT", 5353),
-		TSIGKey:       getEnv("TSIG_KEY", "opnsense-ddns"),
-		TSIGSecret:    getEnv("TSIG_SECRET", "changeme"),
-		TSIGAlgorithm: getEnv("TSIG_ALGORITHM", "hmac-sha256"),
-		Namespace:     getEnv("NAMESPACE", "default"),
-		AllowedZones:  getEnvSlice("ALLOWED_ZONES", ","),
-		CustomLabels:  getEnvMap("CUSTOM_LABELS", ",", "="),
-		LogLevel:      getEnv("LOG_LEVEL", "info"),
+		ListenAddr:        env.getEnv("LISTEN_ADDR", "0.0.0.0"),
+		Port:              env.getEnvInt("PORT", 5353),
+		TSIGKey:           env.getEnv("TSIG_KEY", "opnsense-ddns"),
+		TSIGSecret:        env.getEnv("TSIG_SECRET", "changeme"),
+		TSIGAlgorithm:     env.getEnv("TSIG_ALGORITHM", "hmac-sha256"),
+		UnsignedRequests:  strings.ToLower(env.getEnv("UNSIGNED_REQUESTS", UnsignedRequestsAnswer)),
+		AcceptUnsigned:    !env.getEnvBool("REQUIRE_TSIG", true),
+		Namespace:         env.getEnv("NAMESPACE", defaultNamespace()),
+		NamespaceTemplate: env.getEnv("NAMESPACE_TEMPLATE", ""),
+		AllowedZones:      env.getEnvSlice("ALLOWED_ZONES", ","),
+		ZonePatterns:      env.getEnvSlice("ALLOWED_ZONE_PATTERNS", ","),
+		KeyZones:          env.getEnvListMap("KEY_ZONES", ",", "=", "|"),
+		KeyScopes:         acl.New(env.getEnvListMap("KEY_SCOPES", ",", "=", "|")),
+		ZoneResources:     env.getEnvBool("ALLOWED_ZONE_RESOURCES", false),
+		ZoneMatching:      strings.ToLower(env.getEnv("ZONE_MATCHING", ZoneMatchingStrict)),
+		CustomLabels:      env.getEnvMap("CUSTOM_LABELS", ",", "="),
+		LogLevel:          env.getEnv("LOG_LEVEL", "info"),
+
+		ConfigWatchInterval: env.getEnvDuration("CONFIG_WATCH_INTERVAL", 0),
+
+		UnsupportedResponse: strings.ToLower(env.getEnv("UNSUPPORTED_RESPONSE", UnsupportedResponseNotImp)),
+
+		TSIGFudge:         env.getEnvInt("TSIG_FUDGE", 300),
+		TSIGSkewTolerance: env.getEnvDuration("TSIG_SKEW_TOLERANCE", 0),
+
+		TSIGRotationOverlap: env.getEnvDuration("TSIG_ROTATION_OVERLAP", 0),
+		TSIGMinAlgorithm:    strings.ToLower(env.getEnv("TSIG_MIN_ALGORITHM", "")),
+
+		TLSPort:         env.getEnvInt("TLS_PORT", 0),
+		TLSCertFile:     env.getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:      env.getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile: env.getEnv("TLS_CLIENT_CA_FILE", ""),
+		CertACLs:        env.getEnvListMap("CERT_ACLS", ",", "=", "|"),
+
+		AdminAddr:          env.getEnv("ADMIN_ADDR", ""),
+		DebugAddr:          env.getEnv("DEBUG_ADDR", ""),
+		AdminAuth:          env.getEnvBool("ADMIN_AUTH", false),
+		AdminTokenAudience: env.getEnv("ADMIN_TOKEN_AUDIENCE", "ddnsbridge4extdns"),
+		RBACCheckInterval:  env.getEnvDuration("RBAC_CHECK_INTERVAL", time.Minute),
+
+		BackendCheckInterval: env.getEnvDuration("BACKEND_CHECK_INTERVAL", 30*time.Second),
+
+		DynamicRecords:            env.getEnvBool("DYNAMIC_RECORDS", false),
+		DynamicRecordsAutoApprove: env.getEnvBool("DYNAMIC_RECORDS_AUTO_APPROVE", true),
+
+		RecordEvents:          env.getEnvBool("RECORD_EVENTS", false),
+		RecordEventsRetention: env.getEnvDuration("RECORD_EVENTS_RETENTION", 7*24*time.Hour),
+		RecordEventsPerRecord: env.getEnvInt("RECORD_EVENTS_PER_RECORD", 20),
+
+		CompactionInterval: env.getEnvDuration("COMPACTION_INTERVAL", 0),
+
+		GroupByRequester: env.getEnvBool("GROUP_BY_REQUESTER", false),
+		ResourceNaming:   strings.ToLower(env.getEnv("RESOURCE_NAMING", ResourceNamingHyphenated)),
+
+		UpdateBatchSize:   env.getEnvInt("UPDATE_BATCH_SIZE", 32),
+		UpdateConcurrency: env.getEnvInt("UPDATE_CONCURRENCY", 4),
+		MaxUpdateRecords:  env.getEnvInt("MAX_UPDATE_RECORDS", 1000),
+
+		WriteInterval: env.getEnvDuration("WRITE_INTERVAL", 0),
+
+		BackendRetryAttempts:   env.getEnvInt("BACKEND_RETRY_ATTEMPTS", 3),
+		BackendRetryBackoff:    env.getEnvDuration("BACKEND_RETRY_BACKOFF", 100*time.Millisecond),
+		BackendRetryMaxBackoff: env.getEnvDuration("BACKEND_RETRY_MAX_BACKOFF", 2*time.Second),
+
+		EndpointCache: env.getEnvBool("ENDPOINT_CACHE", false),
+
+		WriteJournal:               env.getEnv("WRITE_JOURNAL", ""),
+		WriteJournalMaxMessages:    env.getEnvInt("WRITE_JOURNAL_MAX_MESSAGES", 10000),
+		WriteJournalReplayInterval: env.getEnvDuration("WRITE_JOURNAL_REPLAY_INTERVAL", 10*time.Second),
+
+		AsyncWorkers:   env.getEnvInt("ASYNC_WORKERS", 0),
+		AsyncQueueSize: env.getEnvInt("ASYNC_QUEUE_SIZE", 1000),
+		AsyncQueueFull: strings.ToLower(env.getEnv("ASYNC_QUEUE_FULL", AsyncQueueFullRefuse)),
+
+		DedupWindow:    env.getEnvDuration("DEDUP_WINDOW", 0),
+		DedupCacheSize: env.getEnvInt("DEDUP_CACHE_SIZE", 10000),
+
+		TCPPipelineDepth: env.getEnvInt("TCP_PIPELINE_DEPTH", 0),
+
+		TCPMaxConnsPerSource: env.getEnvInt("TCP_MAX_CONNS_PER_SOURCE", 0),
+		TCPMaxConnsPerKey:    env.getEnvInt("TCP_MAX_CONNS_PER_KEY", 0),
+		TCPMaxInflightPerKey: env.getEnvInt("TCP_MAX_INFLIGHT_PER_KEY", 0),
+
+		RateLimit:      env.getEnvFloat("RATE_LIMIT", 0),
+		RateLimitBurst: env.getEnvInt("RATE_LIMIT_BURST", 20),
+
+		ProxyProtocol: env.getEnvBool("PROXY_PROTOCOL", false),
+
+		MetricsDimensions:   env.getEnvSlice("METRICS_DIMENSIONS", ","),
+		MetricsSeriesBudget: env.getEnvInt("METRICS_SERIES_BUDGET", 1000),
+
+		CaptureFile:     env.getEnv("CAPTURE_FILE", ""),
+		CaptureClients:  env.getEnvSlice("CAPTURE_CLIENTS", ","),
+		CaptureZones:    env.getEnvSlice("CAPTURE_ZONES", ","),
+		CaptureMaxSize:  env.getEnvInt("CAPTURE_MAX_SIZE", 10<<20),
+		CaptureDuration: env.getEnvDuration("CAPTURE_DURATION", 10*time.Minute),
+
+		KafkaBrokers: env.getEnvSlice("KAFKA_BROKERS", ","),
+		KafkaTopic:   env.getEnv("KAFKA_TOPIC", "ddnsbridge4extdns.updates"),
+		KafkaTLS:     env.getEnvBool("KAFKA_TLS", false),
+
+		AuditLog:         env.getEnv("AUDIT_LOG", ""),
+		AuditLogMaxSize:  env.getEnvInt("AUDIT_LOG_MAX_SIZE", 100<<20),
+		AuditLogMaxFiles: env.getEnvInt("AUDIT_LOG_MAX_FILES", 5),
+
+		DnstapOutput:   env.getEnv("DNSTAP_OUTPUT", ""),
+		DnstapIdentity: env.getEnv("DNSTAP_IDENTITY", ""),
+
+		ACMEChallenges:      env.getEnvBool("ACME_CHALLENGES", false),
+		ACMEChallengeMaxAge: env.getEnvDuration("ACME_CHALLENGE_MAX_AGE", time.Hour),
+
+		WindowsDHCP: env.getEnvBool("WINDOWS_DHCP", false),
+
+		CNAMERecords: env.getEnvBool("CNAME_RECORDS", false),
+
+		SRVRecords: env.getEnvBool("SRV_RECORDS", false),
+
+		DHCIDEnforce: env.getEnvBool("DHCID_ENFORCE", false),
+
+		TrapZones: env.getEnvSlice("TRAP_ZONES", ","),
+
+		BanThreshold: env.getEnvInt("BAN_THRESHOLD", 0),
+		BanWindow:    env.getEnvDuration("BAN_WINDOW", time.Minute),
+		BanDuration:  env.getEnvDuration("BAN_DURATION", 15*time.Minute),
+
+		RedisAddr:      env.getEnv("REDIS_ADDR", ""),
+		RedisPassword:  env.getEnv("REDIS_PASSWORD", ""),
+		RedisDB:        env.getEnvInt("REDIS_DB", 0),
+		RedisTLS:       env.getEnvBool("REDIS_TLS", false),
+		RedisKeyPrefix: env.getEnv("REDIS_KEY_PREFIX", "ddnsbridge4extdns:"),
+
+		ReplicationListen: env.getEnv("REPLICATION_LISTEN", ""),
+		ReplicationPeers:  env.getEnvSlice("REPLICATION_PEERS", ","),
+		ReplicationToken:  env.getEnv("REPLICATION_TOKEN", ""),
+
+		SnapshotBucket:    env.getEnv("SNAPSHOT_BUCKET", ""),
+		SnapshotEndpoint:  env.getEnv("SNAPSHOT_ENDPOINT", "https://s3.amazonaws.com"),
+		SnapshotRegion:    env.getEnv("SNAPSHOT_REGION", "us-east-1"),
+		SnapshotPrefix:    env.getEnv("SNAPSHOT_PREFIX", "ddnsbridge4extdns/"),
+		SnapshotAccessKey: env.getEnv("SNAPSHOT_ACCESS_KEY", ""),
+		SnapshotSecretKey: env.getEnv("SNAPSHOT_SECRET_KEY", ""),
+		SnapshotRetention: env.getEnvInt("SNAPSHOT_RETENTION", 30),
+
+		PushServer:   env.getEnv("PUSH_SERVER", ""),
+		PushNet:      strings.ToLower(env.getEnv("PUSH_NET", "tcp")),
+		PushSelector: env.getEnv("PUSH_SELECTOR", ""),
+	}
+	cfg.PushKey = env.getEnv("PUSH_KEY", cfg.TSIGKey)
+
+	listeners, err := parseListeners(env("LISTENERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTENERS: %w", err)
+	}
+	cfg.Listeners = listeners
+
+	cfg.ProbeNetwork, cfg.ProbePort, err = parseProbe(env.getEnv("PROBE", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROBE: %w", err)
+	}
+	cfg.ProbeTimeout = env.getEnvDuration("PROBE_TIMEOUT", 2*time.Second)
+	cfg.ConflictPolicy = strings.ToLower(env.getEnv("CONFLICT_POLICY", ConflictLastWriterWins))
+	cfg.KeyPriorities, err = parseKeyPriorities(env.getEnvMap("KEY_PRIORITIES", ",", "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KEY_PRIORITIES: %w", err)
+	}
+	socketMode, err := strconv.ParseUint(env.getEnv("ADMIN_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADMIN_SOCKET_MODE: %w", err)
+	}
+	cfg.AdminSocket = env.getEnv("ADMIN_SOCKET", "")
+	cfg.AdminSocketMode = os.FileMode(socketMode)
+	cfg.GeoIPCountryDB = env.getEnv("GEOIP_COUNTRY_DB", "")
+	cfg.GeoIPASNDB = env.getEnv("GEOIP_ASN_DB", "")
+	cfg.ProbeAction = strings.ToLower(env.getEnv("PROBE_ACTION", ProbeActionRefuse))
+
+	cfg.ServeSOA = env.getEnvBool("SERVE_SOA", false)
+	cfg.AnyResponse = strings.ToLower(env.getEnv("ANY_RESPONSE", AnyResponseRecords))
+	cfg.KafkaFormat = strings.ToLower(env.getEnv("KAFKA_FORMAT", "json"))
+	cfg.KafkaPartitionKey = strings.ToLower(env.getEnv("KAFKA_PARTITION_KEY", "zone"))
+	cfg.QueryCacheSize = env.getEnvInt("QUERY_CACHE_SIZE", 1024)
+	cfg.QueryCacheTTL = env.getEnvDuration("QUERY_CACHE_TTL", 30*time.Second)
+	cfg.SOADefaults = SOAParams{
+		MName:   env.getEnv("SOA_MNAME", ""),
+		RName:   env.getEnv("SOA_RNAME", ""),
+		Refresh: uint32(env.getEnvInt("SOA_REFRESH", 3600)),
+		Retry:   uint32(env.getEnvInt("SOA_RETRY", 600)),
+		Expire:  uint32(env.getEnvInt("SOA_EXPIRE", 604800)),
+		Minimum: uint32(env.getEnvInt("SOA_MINIMUM", 60)),
+	}
+	soaZones, err := parseSOAZones(env.getEnvListMap("SOA_ZONE_PARAMS", ",", "=", "|"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA_ZONE_PARAMS: %w", err)
+	}
+	cfg.SOAZones = soaZones
+	cfg.TSIGKeys = parseTSIGKeys(env.getEnvMap("TSIG_KEYS", ",", "="), cfg.TSIGAlgorithm)
+	cfg.TSIGKeysFile = env.getEnv("TSIG_KEYS_FILE", "")
+	if cfg.TSIGKeysFile != "" {
+		fileKeys, err := readTSIGKeysFile(cfg.TSIGKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TSIG_KEYS_FILE: %w", err)
+		}
+		cfg.TSIGKeys = append(cfg.TSIGKeys, parseTSIGKeys(fileKeys, cfg.TSIGAlgorithm)...)
+	}
+	cfg.TSIGSecretRef = env.getEnv("TSIG_SECRET_REF", "")
+	if cfg.TSIGSecretRef != "" {
+		// The secret of TSIG_KEY may come from the Secret only
+		cfg.TSIGSecret = env.getEnv("TSIG_SECRET", "")
+	}
+	cfg.ZoneKeys, err = parseZoneKeys(env.getEnvListMap("ZONE_KEYS", ",", "=", "|"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZONE_KEYS: %w", err)
+	}
+	cfg.TTLExpiry, err = parseTTLExpiry(env.getEnvMap("TTL_EXPIRY", ",", "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TTL_EXPIRY: %w", err)
+	}
+	cfg.TTLExpiryInterval = env.getEnvDuration("TTL_EXPIRY_INTERVAL", time.Minute)
+	cfg.ZoneNamespaces, err = parseZoneNamespaces(env.getEnvMap("ZONE_NAMESPACES", ",", "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZONE_NAMESPACES: %w", err)
+	}
+	cfg.UnsignedZones, err = parseZoneNetworks(env.getEnvListMap("UNSIGNED_ZONES", ",", "=", "|"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid UNSIGNED_ZONES: %w", err)
+	}
+	cfg.AllowedSources, err = parseNetworks(env.getEnvSlice("ALLOWED_SOURCES", ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ALLOWED_SOURCES: %w", err)
+	}
+	cfg.ZoneSources, err = parseZoneNetworks(env.getEnvListMap("ZONE_SOURCES", ",", "=", "|"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZONE_SOURCES: %w", err)
+	}
+
+	if types := env.getEnvSlice("RECORD_TYPES", ","); len(types) > 0 {
+		cfg.RecordTypes, err = update.ParseTypes(types)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECORD_TYPES: %w", err)
+		}
+	}
+
+	cfg.ProxyTrustedNetworks, err = parseNetworks(env.getEnvSlice("PROXY_TRUSTED_NETWORKS", ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY_TRUSTED_NETWORKS: %w", err)
+	}
+
+	cfg.SIG0Keys, err = sig0.Parse(env.getEnvListMap("SIG0_KEYS", ",", "=", "|"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIG0_KEYS: %w", err)
+	}
+
+	cfg.ClientQuirks, err = quirks.Parse(env.getEnvListMap("CLIENT_QUIRKS", ",", "=", "|"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLIENT_QUIRKS: %w", err)
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -57,30 +719,484 @@ func (c *Config) Validate() error {
 	if c.TSIGKey == "" {
 		return fmt.Errorf("TSIG_KEY is required")
 	}
-	if c.TSIGSecret == "" {
+	if c.TSIGSecret == "" && c.TSIGSecretRef == "" {
 		return fmt.Errorf("TSIG_SECRET is required")
 	}
+	if c.TSIGSecretRef != "" {
+		if namespace, name := c.SecretRef(); namespace == "" || name == "" {
+			return fmt.Errorf("TSIG_SECRET_REF must be namespace/name")
+		}
+	}
 	// Validate that TSIG_SECRET is valid base64
 	if _, err := base64.StdEncoding.DecodeString(c.TSIGSecret); err != nil {
 		return fmt.Errorf("TSIG_SECRET must be valid base64: %w", err)
 	}
+	if c.TSIGFudge < 0 || c.TSIGFudge > 65535 {
+		return fmt.Errorf("TSIG_FUDGE must be between 0 and 65535 seconds")
+	}
+	if c.TSIGSkewTolerance < 0 {
+		return fmt.Errorf("TSIG_SKEW_TOLERANCE must not be negative")
+	}
+	if c.TSIGRotationOverlap < 0 {
+		return fmt.Errorf("TSIG_ROTATION_OVERLAP must not be negative")
+	}
+	if c.TSIGMinAlgorithm != "" && c.MinAlgorithmName() == "" {
+		return fmt.Errorf("unsupported TSIG_MIN_ALGORITHM %q", c.TSIGMinAlgorithm)
+	}
+	if err := c.validateTSIGKeys(); err != nil {
+		return fmt.Errorf("invalid TSIG_KEYS: %w", err)
+	}
+	switch c.UnsignedRequests {
+	case "", UnsignedRequestsAnswer, UnsignedRequestsRefuse:
+	default:
+		return fmt.Errorf("UNSIGNED_REQUESTS must be one of answer, refuse")
+	}
+	if c.UnsignedRequests == UnsignedRequestsRefuse && len(c.UnsignedZones) > 0 {
+		return fmt.Errorf("UNSIGNED_ZONES is not supported with UNSIGNED_REQUESTS=refuse")
+	}
+	if c.UnsignedRequests == UnsignedRequestsRefuse && c.AcceptUnsigned {
+		return fmt.Errorf("REQUIRE_TSIG=false is not supported with UNSIGNED_REQUESTS=refuse")
+	}
+	// Any client could forge its address with a PROXY header of its own
+	if c.ProxyProtocol && len(c.ProxyTrustedNetworks) == 0 {
+		return fmt.Errorf("PROXY_PROTOCOL requires PROXY_TRUSTED_NETWORKS, the networks of the load balancers")
+	}
+	if c.WindowsDHCP && c.DynamicRecords {
+		return fmt.Errorf("WINDOWS_DHCP is not supported with DYNAMIC_RECORDS")
+	}
+	for _, rule := range c.ClientQuirks {
+		if rule.Quirks().DHCPRecords && c.DynamicRecords {
+			return fmt.Errorf("CLIENT_QUIRKS profile %s is not supported with DYNAMIC_RECORDS", strings.Join(rule.Profiles, "|"))
+		}
+	}
+	if c.DHCIDEnforce && c.DynamicRecords {
+		return fmt.Errorf("DHCID_ENFORCE is not supported with DYNAMIC_RECORDS")
+	}
+	if c.GroupByRequester {
+		if err := c.validateGrouping(); err != nil {
+			return err
+		}
+	}
+	if c.UpdateBatchSize < 0 || c.UpdateConcurrency < 0 {
+		return fmt.Errorf("UPDATE_BATCH_SIZE and UPDATE_CONCURRENCY must not be negative")
+	}
+	if c.MaxUpdateRecords < 0 {
+		return fmt.Errorf("MAX_UPDATE_RECORDS must not be negative")
+	}
+	if c.WriteInterval < 0 {
+		return fmt.Errorf("WRITE_INTERVAL must not be negative")
+	}
+	if c.BackendRetryAttempts < 0 || c.BackendRetryBackoff < 0 || c.BackendRetryMaxBackoff < 0 {
+		return fmt.Errorf("BACKEND_RETRY_ATTEMPTS, BACKEND_RETRY_BACKOFF and BACKEND_RETRY_MAX_BACKOFF must not be negative")
+	}
+	if c.WriteJournal != "" && (c.WriteJournalMaxMessages <= 0 || c.WriteJournalReplayInterval <= 0) {
+		return fmt.Errorf("WRITE_JOURNAL_MAX_MESSAGES and WRITE_JOURNAL_REPLAY_INTERVAL must be positive with WRITE_JOURNAL")
+	}
+	if c.AsyncWorkers < 0 {
+		return fmt.Errorf("ASYNC_WORKERS must not be negative")
+	}
+	if c.AsyncWorkers > 0 && c.AsyncQueueSize <= 0 {
+		return fmt.Errorf("ASYNC_QUEUE_SIZE must be positive with ASYNC_WORKERS")
+	}
+	switch c.AsyncQueueFull {
+	case "", AsyncQueueFullRefuse, AsyncQueueFullBlock:
+	default:
+		return fmt.Errorf("ASYNC_QUEUE_FULL must be one of refuse, block")
+	}
+	if c.DedupWindow < 0 {
+		return fmt.Errorf("DEDUP_WINDOW must not be negative")
+	}
+	if c.DedupWindow > 0 && c.DedupCacheSize <= 0 {
+		return fmt.Errorf("DEDUP_CACHE_SIZE must be positive with DEDUP_WINDOW")
+	}
+	if c.DedupWindow > 0 && !c.EndpointCache {
+		return fmt.Errorf("DEDUP_WINDOW requires ENDPOINT_CACHE, whose watch reports the records changed by other writers")
+	}
+	if c.ConfigWatchInterval < 0 {
+		return fmt.Errorf("CONFIG_WATCH_INTERVAL must not be negative")
+	}
+	if c.DnstapOutput != "" {
+		network, address, _ := strings.Cut(c.DnstapOutput, ":")
+		if address == "" || (network != "file" && network != "unix" && network != "tcp") {
+			return fmt.Errorf("DNSTAP_OUTPUT must be file:<path>, unix:<path> or tcp:<host:port>")
+		}
+	}
+	if c.CaptureFile != "" && (c.CaptureMaxSize <= 0 || c.CaptureDuration <= 0) {
+		return fmt.Errorf("CAPTURE_MAX_SIZE and CAPTURE_DURATION must be positive when CAPTURE_FILE is set")
+	}
+	if len(c.KafkaBrokers) > 0 {
+		if c.KafkaTopic == "" {
+			return fmt.Errorf("KAFKA_TOPIC is required when KAFKA_BROKERS is set")
+		}
+		if c.KafkaFormat != "json" && c.KafkaFormat != "avro" {
+			return fmt.Errorf("KAFKA_FORMAT must be json or avro")
+		}
+		if c.KafkaPartitionKey != "zone" && c.KafkaPartitionKey != "name" && c.KafkaPartitionKey != "none" {
+			return fmt.Errorf("KAFKA_PARTITION_KEY must be zone, name or none")
+		}
+	}
+	if c.AuditLog != "" && (c.AuditLogMaxSize < 0 || c.AuditLogMaxFiles < 1) {
+		return fmt.Errorf("AUDIT_LOG_MAX_SIZE must not be negative and AUDIT_LOG_MAX_FILES must be at least 1")
+	}
+	if c.TCPPipelineDepth < 0 {
+		return fmt.Errorf("TCP_PIPELINE_DEPTH must not be negative")
+	}
+	if c.TCPMaxConnsPerSource < 0 || c.TCPMaxConnsPerKey < 0 || c.TCPMaxInflightPerKey < 0 {
+		return fmt.Errorf("TCP_MAX_CONNS_PER_SOURCE, TCP_MAX_CONNS_PER_KEY and TCP_MAX_INFLIGHT_PER_KEY must not be negative")
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("RATE_LIMIT must not be negative")
+	}
+	if c.RateLimit > 0 && c.RateLimitBurst < 1 {
+		return fmt.Errorf("RATE_LIMIT_BURST must be at least 1")
+	}
+	for _, dimension := range c.MetricsDimensions {
+		switch dimension {
+		case "zone", "key", "type", "source":
+		default:
+			return fmt.Errorf("METRICS_DIMENSIONS must only hold zone, key, type, source, got %q", dimension)
+		}
+	}
+	if len(c.MetricsDimensions) > 0 && c.MetricsSeriesBudget <= 0 {
+		return fmt.Errorf("METRICS_SERIES_BUDGET must be positive")
+	}
+	if c.QueryCacheSize < 0 {
+		return fmt.Errorf("QUERY_CACHE_SIZE must not be negative")
+	}
+	if c.AdminSocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("ADMIN_SOCKET_MODE must be a permission mode such as 0660")
+	}
+	if c.AdminAuth && c.AdminTokenAudience == "" {
+		return fmt.Errorf("ADMIN_TOKEN_AUDIENCE is required when ADMIN_AUTH is enabled")
+	}
+	if _, err := zoneauth.Patterns(c.ZonePatterns); err != nil {
+		return fmt.Errorf("invalid ALLOWED_ZONE_PATTERNS: %w", err)
+	}
+	for zone := range c.SOAZones {
+		if !c.IsZoneAllowed(zone) {
+			return fmt.Errorf("SOA_ZONE_PARAMS zone %s is not in ALLOWED_ZONES", zone)
+		}
+	}
+	for zone := range c.ZoneKeys {
+		if !matchesZone(zone, c.AllowedZones) {
+			return fmt.Errorf("ZONE_KEYS zone %s is not in ALLOWED_ZONES", zone)
+		}
+	}
+	for key, patterns := range c.KeyScopes {
+		if _, ok := c.Key(key); !ok {
+			return fmt.Errorf("KEY_SCOPES key %s is not one of TSIG_KEY and TSIG_KEYS", key)
+		}
+		if len(patterns) == 0 {
+			return fmt.Errorf("no zone listed for KEY_SCOPES key %s", key)
+		}
+	}
+	for _, key := range c.SIG0Keys {
+		if _, ok := c.Key(key.Name); ok {
+			return fmt.Errorf("SIG0_KEYS key %s is also a TSIG key", key.Name)
+		}
+		for _, zone := range key.Zones {
+			if !matchesZone(normalizeZone(zone), c.AllowedZones) {
+				return fmt.Errorf("SIG0_KEYS zone %s is not in ALLOWED_ZONES", zone)
+			}
+		}
+	}
+	for zone := range c.UnsignedZones {
+		if !matchesZone(zone, c.AllowedZones) {
+			return fmt.Errorf("UNSIGNED_ZONES zone %s is not in ALLOWED_ZONES", zone)
+		}
+	}
+	for zone := range c.ZoneSources {
+		if !matchesZone(zone, c.AllowedZones) {
+			return fmt.Errorf("ZONE_SOURCES zone %s is not in ALLOWED_ZONES", zone)
+		}
+	}
+	for zone := range c.TTLExpiry {
+		if !matchesZone(zone, c.AllowedZones) {
+			return fmt.Errorf("TTL_EXPIRY zone %s is not in ALLOWED_ZONES", zone)
+		}
+	}
+	for zone := range c.ZoneNamespaces {
+		if !matchesZone(zone, c.AllowedZones) {
+			return fmt.Errorf("ZONE_NAMESPACES zone %s is not in ALLOWED_ZONES", zone)
+		}
+	}
+	if len(c.TTLExpiry) > 0 {
+		switch {
+		case c.TTLExpiryInterval <= 0:
+			return fmt.Errorf("TTL_EXPIRY_INTERVAL must be positive when TTL_EXPIRY is set")
+		case c.DynamicRecords:
+			return fmt.Errorf("TTL_EXPIRY is not supported with DYNAMIC_RECORDS")
+		case c.GroupByRequester:
+			return fmt.Errorf("TTL_EXPIRY is not supported with GROUP_BY_REQUESTER")
+		}
+	}
+	for _, listener := range c.Listeners {
+		if err := c.validateListener(listener); err != nil {
+			return err
+		}
+	}
+	if c.BanThreshold < 0 {
+		return fmt.Errorf("BAN_THRESHOLD must not be negative")
+	}
+	if c.BanThreshold > 0 && (c.BanWindow <= 0 || c.BanDuration <= 0) {
+		return fmt.Errorf("BAN_WINDOW and BAN_DURATION must be positive when BAN_THRESHOLD is set")
+	}
+	if c.RedisAddr != "" {
+		if _, _, err := net.SplitHostPort(c.RedisAddr); err != nil {
+			return fmt.Errorf("REDIS_ADDR must be host:port: %w", err)
+		}
+		if c.RedisDB < 0 {
+			return fmt.Errorf("REDIS_DB must not be negative")
+		}
+	}
+	if c.ReplicationListen != "" || len(c.ReplicationPeers) > 0 {
+		if c.ReplicationToken == "" {
+			return fmt.Errorf("REPLICATION_TOKEN is required when REPLICATION_LISTEN or REPLICATION_PEERS is set")
+		}
+		for _, addr := range append([]string{c.ReplicationListen}, c.ReplicationPeers...) {
+			if _, _, err := net.SplitHostPort(addr); addr != "" && err != nil {
+				return fmt.Errorf("REPLICATION_LISTEN and REPLICATION_PEERS must be host:port, got %q: %w", addr, err)
+			}
+		}
+	}
+	if c.SnapshotBucket != "" {
+		if u, err := url.Parse(c.SnapshotEndpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("SNAPSHOT_ENDPOINT must be an http or https URL")
+		}
+		if c.SnapshotRetention < 1 {
+			return fmt.Errorf("SNAPSHOT_RETENTION must be at least 1")
+		}
+	}
+	if c.PushServer != "" {
+		if _, _, err := net.SplitHostPort(c.PushServer); err != nil {
+			return fmt.Errorf("PUSH_SERVER must be host:port: %w", err)
+		}
+		if c.PushNet != "udp" && c.PushNet != "tcp" {
+			return fmt.Errorf("PUSH_NET must be udp or tcp")
+		}
+		if _, ok := c.Key(c.PushKey); !ok {
+			return fmt.Errorf("PUSH_KEY %s is not one of TSIG_KEY and TSIG_KEYS", c.PushKey)
+		}
+	}
+	if c.CompactionInterval < 0 {
+		return fmt.Errorf("COMPACTION_INTERVAL must not be negative")
+	}
+	if c.Namespace == NamespaceAll && c.NamespaceTemplate == "" && len(c.ZoneNamespaces) == 0 {
+		return fmt.Errorf("NAMESPACE=%s requires NAMESPACE_TEMPLATE or ZONE_NAMESPACES", NamespaceAll)
+	}
+	if len(c.ZoneNamespaces) > 0 && c.CompactionInterval > 0 {
+		return fmt.Errorf("COMPACTION_INTERVAL is not supported with ZONE_NAMESPACES")
+	}
+	if c.NamespaceTemplate != "" {
+		if _, err := template.New("namespace").Parse(c.NamespaceTemplate); err != nil {
+			return fmt.Errorf("NAMESPACE_TEMPLATE is not a valid template: %w", err)
+		}
+		if c.CompactionInterval > 0 {
+			return fmt.Errorf("COMPACTION_INTERVAL is not supported with NAMESPACE_TEMPLATE")
+		}
+	}
+	if c.TLSPort < 0 || c.TLSPort > 65535 {
+		return fmt.Errorf("TLS_PORT must be between 0 and 65535")
+	}
+	if c.TLSPort > 0 && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE are required when TLS_PORT is set")
+	}
+	if len(c.CertACLs) > 0 && c.TLSClientCAFile == "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE is required when CERT_ACLS is set")
+	}
 	if len(c.AllowedZones) == 0 {
 		return fmt.Errorf("at least one zone must be configured in ALLOWED_ZONES")
 	}
 	if c.Port < 1 || c.Port > 65535 {
 		return fmt.Errorf("PORT must be between 1 and 65535")
 	}
+	switch c.UnsupportedResponse {
+	case "", UnsupportedResponseNotImp, UnsupportedResponseRefused, UnsupportedResponseDrop:
+	default:
+		return fmt.Errorf("UNSUPPORTED_RESPONSE must be one of notimp, refused, drop")
+	}
+	switch c.AnyResponse {
+	case "", AnyResponseRecords, AnyResponseHINFO:
+	default:
+		return fmt.Errorf("ANY_RESPONSE must be one of records, hinfo")
+	}
+	switch c.ZoneMatching {
+	case "", ZoneMatchingStrict, ZoneMatchingSuffix:
+	default:
+		return fmt.Errorf("ZONE_MATCHING must be one of strict, suffix")
+	}
+	switch c.ResourceNaming {
+	case "", ResourceNamingHyphenated, ResourceNamingSubdomain, ResourceNamingHashSuffix, ResourceNamingZonePrefixed:
+	default:
+		return fmt.Errorf("RESOURCE_NAMING must be one of hyphenated, subdomain, hash-suffix, zone-prefixed")
+	}
+	switch c.ConflictPolicy {
+	case "", ConflictLastWriterWins, ConflictFirstOwnerWins:
+	case ConflictMerge:
+		if c.DynamicRecords {
+			return fmt.Errorf("CONFLICT_POLICY=merge is not supported with DYNAMIC_RECORDS")
+		}
+	default:
+		return fmt.Errorf("CONFLICT_POLICY must be one of last-writer-wins, first-owner-wins, merge")
+	}
+	if c.ProbeNetwork != "" {
+		if c.ProbeTimeout <= 0 {
+			return fmt.Errorf("PROBE_TIMEOUT must be positive")
+		}
+		switch c.ProbeAction {
+		case ProbeActionRefuse, ProbeActionFlag:
+		default:
+			return fmt.Errorf("PROBE_ACTION must be one of refuse, flag")
+		}
+	}
 	return nil
 }
 
-// IsZoneAllowed checks if a zone is in the allowed zones list
+// parseProbe parses a reachability probe: "icmp", or "tcp:<port>"
+func parseProbe(value string) (network string, port int, err error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == "icmp" {
+		return value, 0, nil
+	}
+	network, portStr, ok := strings.Cut(value, ":")
+	if !ok || network != "tcp" {
+		return "", 0, fmt.Errorf("%q must be icmp or tcp:<port>", value)
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid TCP port %q", portStr)
+	}
+	return network, port, nil
+}
+
+// validateGrouping checks the features relying on one DNSEndpoint per name are disabled
+func (c *Config) validateGrouping() error {
+	switch {
+	case c.DynamicRecords:
+		return fmt.Errorf("GROUP_BY_REQUESTER is not supported with DYNAMIC_RECORDS")
+	case c.ConflictPolicy != "" && c.ConflictPolicy != ConflictLastWriterWins:
+		return fmt.Errorf("GROUP_BY_REQUESTER is only supported with CONFLICT_POLICY=last-writer-wins")
+	case len(c.KeyPriorities) > 0:
+		return fmt.Errorf("GROUP_BY_REQUESTER is not supported with KEY_PRIORITIES")
+	case c.DHCIDEnforce:
+		return fmt.Errorf("GROUP_BY_REQUESTER is not supported with DHCID_ENFORCE")
+	case c.CompactionInterval > 0:
+		return fmt.Errorf("GROUP_BY_REQUESTER is not supported with COMPACTION_INTERVAL")
+	case c.ProbeNetwork != "" && c.ProbeAction == ProbeActionFlag:
+		return fmt.Errorf("GROUP_BY_REQUESTER is not supported with PROBE_ACTION=flag")
+	}
+	return nil
+}
+
+// parseKeyPriorities parses the priority of each key name
+func parseKeyPriorities(values map[string]string) (map[string]int, error) {
+	priorities := make(map[string]int, len(values))
+	for key, value := range values {
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q for key %s", value, key)
+		}
+		priorities[key] = priority
+	}
+	return priorities, nil
+}
+
+// defaultNamespace returns the namespace of the pod when running in-cluster, or "default"
+func defaultNamespace() string {
+	data, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "default"
+	}
+	if namespace := strings.TrimSpace(string(data)); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
+
+// AcceptedRecordTypes returns the record types accepted from the clients: those of
+// RecordTypes, A and AAAA when unset, plus CNAME and SRV when enabled on their own
+func (c *Config) AcceptedRecordTypes() map[uint16]bool {
+	types := map[uint16]bool{dns.TypeA: true, dns.TypeAAAA: true}
+	if c.RecordTypes != nil {
+		types = make(map[uint16]bool, len(c.RecordTypes)+2)
+		for rrtype := range c.RecordTypes {
+			types[rrtype] = true
+		}
+	}
+	if c.CNAMERecords {
+		types[dns.TypeCNAME] = true
+	}
+	if c.SRVRecords {
+		types[dns.TypeSRV] = true
+	}
+	return types
+}
+
+// KubernetesNamespace returns the namespace of the Kubernetes client, empty for every namespace
+func (c *Config) KubernetesNamespace() string {
+	if c.Namespace == NamespaceAll {
+		return ""
+	}
+	return c.Namespace
+}
+
+// IsZoneAllowed checks if a zone is in the allowed zones list, or below one of
+// them with suffix zone matching
 func (c *Config) IsZoneAllowed(zone string) bool {
+	if c.ZoneMatching == ZoneMatchingSuffix {
+		return matchesZone(zone, c.AllowedZones)
+	}
+	return isZone(zone, c.AllowedZones)
+}
+
+// ZoneAuthorizer returns the authorizer of the zones accepted by the configuration:
+// ALLOWED_ZONES, ALLOWED_ZONE_PATTERNS and KEY_ZONES
+func (c *Config) ZoneAuthorizer() zoneauth.Authorizer {
+	authorizers := []zoneauth.Authorizer{zoneauth.Static(c.AllowedZones, c.ZoneMatching == ZoneMatchingSuffix)}
+	if len(c.ZonePatterns) > 0 {
+		// Patterns are checked by Validate
+		if patterns, err := zoneauth.Patterns(c.ZonePatterns); err == nil {
+			authorizers = append(authorizers, patterns)
+		}
+	}
+	if len(c.KeyZones) > 0 {
+		authorizers = append(authorizers, zoneauth.KeyACL(acl.New(c.KeyZones)))
+	}
+	return zoneauth.Any(authorizers...)
+}
+
+// IsTrapZone checks if a zone is a decoy zone
+func (c *Config) IsTrapZone(zone string) bool {
+	return matchesZone(zone, c.TrapZones)
+}
+
+// zoneMatching returns the zone matching mode, strict unless configured otherwise
+func (c *Config) zoneMatching() string {
+	if c.ZoneMatching == "" {
+		return ZoneMatchingStrict
+	}
+	return c.ZoneMatching
+}
+
+// isZone checks if a zone is one of zones
+func isZone(zone string, zones []string) bool {
+	zone = strings.TrimSuffix(zone, ".")
+	for _, allowedZone := range zones {
+		if zone == strings.TrimSuffix(allowedZone, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesZone checks if a zone is one of zones or below one of them
+func matchesZone(zone string, zones []string) bool {
 	// Normalize zone by ensuring it ends with a dot
 	if !strings.HasSuffix(zone, ".") {
 		zone = zone + "."
 	}
 
-	for _, allowedZone := range c.AllowedZones {
+	for _, allowedZone := range zones {
 		if !strings.HasSuffix(allowedZone, ".") {
 			allowedZone = allowedZone + "."
 		}
@@ -91,15 +1207,38 @@ func (c *Config) IsZoneAllowed(zone string) bool {
 	return false
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+// ZoneOf returns the longest allowed zone containing a name, or an empty string
+func (c *Config) ZoneOf(name string) string {
+	if !strings.HasSuffix(name, ".") {
+		name = name + "."
+	}
+	name = strings.ToLower(name)
+
+	zone := ""
+	for _, allowedZone := range c.AllowedZones {
+		allowedZone = strings.ToLower(allowedZone)
+		if !strings.HasSuffix(allowedZone, ".") {
+			allowedZone = allowedZone + "."
+		}
+		if (name == allowedZone || strings.HasSuffix(name, "."+allowedZone)) && len(allowedZone) > len(zone) {
+			zone = allowedZone
+		}
+	}
+	return zone
+}
+
+// environment returns the value of a configuration variable, or an empty string
+type environment func(key string) string
+
+func (e environment) getEnv(key, defaultValue string) string {
+	if value := e(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+func (e environment) getEnvInt(key string, defaultValue int) int {
+	if value := e(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
 		}
@@ -107,8 +1246,35 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-func getEnvSlice(key, separator string) []string {
-	value := os.Getenv(key)
+func (e environment) getEnvFloat(key string, defaultValue float64) float64 {
+	if value := e(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func (e environment) getEnvBool(key string, defaultValue bool) bool {
+	if value := e(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func (e environment) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := e(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
+
+func (e environment) getEnvSlice(key, separator string) []string {
+	value := e(key)
 	if value == "" {
 		return []string{}
 	}
@@ -122,8 +1288,8 @@ func getEnvSlice(key, separator string) []string {
 	return result
 }
 
-func getEnvMap(key, pairSeparator, kvSeparator string) map[string]string {
-	value := os.Getenv(key)
+func (e environment) getEnvMap(key, pairSeparator, kvSeparator string) map[string]string {
+	value := e(key)
 	if value == "" {
 		return map[string]string{}
 	}
@@ -143,3 +1309,17 @@ func getEnvMap(key, pairSeparator, kvSeparator string) map[string]string {
 	}
 	return result
 }
+
+func (e environment) getEnvListMap(key, pairSeparator, kvSeparator, listSeparator string) map[string][]string {
+	result := make(map[string][]string)
+	for k, v := range e.getEnvMap(key, pairSeparator, kvSeparator) {
+		values := make([]string, 0)
+		for _, item := range strings.Split(v, listSeparator) {
+			if trimmed := strings.TrimSpace(item); trimmed != "" {
+				values = append(values, trimmed)
+			}
+		}
+		result[k] = values
+	}
+	return result
+}
@@ -1,28 +1,36 @@
 package config
 
 import (
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
 )
 
 func TestLoadConfig(t *testing.T) {
 	// Set up environment variables
 	os.Setenv("TSIG_KEY", "test-key")
-	os.Setenv("TSIG_SECRET", "test-secret")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
 	os.Setenv("ALLOWED_ZONES", "example.com,example.org")
 	defer os.Clearenv()
 
-	cfg, err := LoadConfig()
+	cfg, err := LoadConfig(nil)
 	if err != nil {
-		t.Fatalf("LoadConfig() failed: %v", err)
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
 	}
 
 	if cfg.TSIGKey != "test-key" {
 		t.Errorf("Expected TSIGKey 'test-key', got '%s'", cfg.TSIGKey)
 	}
 
-	if cfg.TSIGSecret != "test-secret" {
-		t.Errorf("Expected TSIGSecret 'test-secret', got '%s'", cfg.TSIGSecret)
+	if cfg.TSIGSecret != "dGVzdC1zZWNyZXQ=" {
+		t.Errorf("Expected TSIGSecret 'dGVzdC1zZWNyZXQ=', got '%s'", cfg.TSIGSecret)
 	}
 
 	if len(cfg.AllowedZones) != 2 {
@@ -30,6 +38,205 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+func TestLoadConfigNamespace(t *testing.T) {
+	os.Setenv("TSIG_KEY", "test-key")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	defer os.Clearenv()
+
+	defer func(file string) { serviceAccountNamespaceFile = file }(serviceAccountNamespaceFile)
+	serviceAccountNamespaceFile = filepath.Join(t.TempDir(), "namespace")
+
+	tests := []struct {
+		name      string
+		env       string
+		file      string
+		namespace string
+	}{
+		{name: "out of cluster", namespace: "default"},
+		{name: "in cluster", file: "dns-system\n", namespace: "dns-system"},
+		{name: "explicit", env: "records", file: "dns-system", namespace: "records"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(serviceAccountNamespaceFile)
+			if tt.file != "" {
+				if err := os.WriteFile(serviceAccountNamespaceFile, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			os.Unsetenv("NAMESPACE")
+			if tt.env != "" {
+				os.Setenv("NAMESPACE", tt.env)
+			}
+
+			cfg, err := LoadConfig(nil)
+			if err != nil {
+				t.Fatalf("LoadConfig(nil) failed: %v", err)
+			}
+			if cfg.Namespace != tt.namespace {
+				t.Errorf("Expected namespace %q, got %q", tt.namespace, cfg.Namespace)
+			}
+		})
+	}
+}
+
+func TestLoadConfigCertACLs(t *testing.T) {
+	os.Setenv("TSIG_KEY", "test-key")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	os.Setenv("TLS_CLIENT_CA_FILE", "ca.crt")
+	os.Setenv("CERT_ACLS", "router.example.com=home.example.com|*.lab.example.com,nas=nas.example.com")
+	defer os.Clearenv()
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+
+	patterns := cfg.CertACLs["router.example.com"]
+	if len(patterns) != 2 || patterns[0] != "home.example.com" || patterns[1] != "*.lab.example.com" {
+		t.Errorf("Expected 2 patterns for router.example.com, got %v", patterns)
+	}
+	if len(cfg.CertACLs["nas"]) != 1 {
+		t.Errorf("Expected 1 pattern for nas, got %v", cfg.CertACLs["nas"])
+	}
+}
+
+func TestLoadConfigTSIGKeysFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# DHCP servers\ndhcp-key = hmac-sha512:c2Vjb25kLXNlY3JldA==\n\nbackup-key=dGhpcmQtc2VjcmV0\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	os.Setenv("TSIG_KEY", "test-key")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	os.Setenv("TSIG_KEYS", "router-key=cm91dGVyLXNlY3JldA==")
+	os.Setenv("TSIG_KEYS_FILE", path)
+	defer os.Clearenv()
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	if len(cfg.Keys()) != 4 {
+		t.Errorf("Expected 4 keys, got %+v", cfg.Keys())
+	}
+	if key, ok := cfg.Key("dhcp-key."); !ok || key.Algorithm != "hmac-sha512" || key.Secret != "c2Vjb25kLXNlY3JldA==" {
+		t.Errorf("Unexpected key dhcp-key: %+v, %v", key, ok)
+	}
+	if key, ok := cfg.Key("backup-key"); !ok || key.Algorithm != "hmac-sha256" {
+		t.Errorf("Unexpected key backup-key: %+v, %v", key, ok)
+	}
+
+	for name, content := range map[string]string{
+		"malformed":    "dhcp-key\n",
+		"duplicate":    "dhcp-key=c2VjcmV0\ndhcp-key=c2VjcmV0\n",
+		"in TSIG_KEYS": "router-key=c2VjcmV0\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		if _, err := LoadConfig(nil); err == nil {
+			t.Errorf("%s: expected TSIG_KEYS_FILE to be refused", name)
+		}
+	}
+	os.Setenv("TSIG_KEYS_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("Expected a missing TSIG_KEYS_FILE to be refused")
+	}
+}
+
+func TestLoadConfigClientQuirks(t *testing.T) {
+	os.Setenv("TSIG_KEY", "test-key")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	os.Setenv("CLIENT_QUIRKS", "router-key=opnsense,10.0.0.0/8=windows-dhcp|dnsmasq")
+	defer os.Clearenv()
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	if q := cfg.ClientQuirks.For("router-key.", nil); !q.SOAQueries || !q.Retransmits || q.DHCPRecords {
+		t.Errorf("Unexpected quirks of router-key: %+v", q)
+	}
+	if q := cfg.ClientQuirks.For("", net.ParseIP("10.1.2.3")); !q.DHCPRecords || !q.AnyZoneClass {
+		t.Errorf("Unexpected quirks of 10.1.2.3: %+v", q)
+	}
+
+	os.Setenv("CLIENT_QUIRKS", "router-key=pfsense")
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("Expected an unknown quirk profile to be refused")
+	}
+
+	os.Setenv("CLIENT_QUIRKS", "10.0.0.0/8=windows-dhcp")
+	os.Setenv("DYNAMIC_RECORDS", "true")
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("Expected the windows-dhcp profile to be refused with DYNAMIC_RECORDS")
+	}
+}
+
+func TestLoadConfigRecordTypes(t *testing.T) {
+	os.Setenv("TSIG_KEY", "test-key")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	defer os.Clearenv()
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	if types := cfg.AcceptedRecordTypes(); !reflect.DeepEqual(types, map[uint16]bool{dns.TypeA: true, dns.TypeAAAA: true}) {
+		t.Errorf("Expected A and AAAA by default, got %v", types)
+	}
+
+	os.Setenv("RECORD_TYPES", "aaaa,MX")
+	os.Setenv("SRV_RECORDS", "true")
+	if cfg, err = LoadConfig(nil); err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	expected := map[uint16]bool{dns.TypeAAAA: true, dns.TypeMX: true, dns.TypeSRV: true}
+	if types := cfg.AcceptedRecordTypes(); !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected %v, got %v", expected, types)
+	}
+
+	os.Setenv("RECORD_TYPES", "A,CAA")
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("Expected an unsupported record type to be refused")
+	}
+}
+
+func TestLoadConfigProxyTrustedNetworks(t *testing.T) {
+	os.Setenv("TSIG_KEY", "test-key")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	os.Setenv("PROXY_PROTOCOL", "true")
+	os.Setenv("PROXY_TRUSTED_NETWORKS", "10.0.0.0/8, 192.0.2.1")
+	defer os.Clearenv()
+
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	if got := cidrs(cfg.ProxyTrustedNetworks); !reflect.DeepEqual(got, []string{"10.0.0.0/8", "192.0.2.1/32"}) {
+		t.Errorf("Unexpected trusted networks: %v", got)
+	}
+
+	os.Setenv("PROXY_TRUSTED_NETWORKS", "10.0.0.0/33")
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("Expected an invalid network to be refused")
+	}
+
+	// Any client could send a header without trusted networks
+	os.Setenv("PROXY_TRUSTED_NETWORKS", "")
+	if _, err := LoadConfig(nil); err == nil {
+		t.Error("Expected PROXY_PROTOCOL without trusted networks to be refused")
+	}
+}
+
 func TestValidate(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -40,7 +247,7 @@ func TestValidate(t *testing.T) {
 			name: "valid config",
 			config: &Config{
 				TSIGKey:      "test-key",
-				TSIGSecret:   "test-secret",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
 				AllowedZones: []string{"example.com"},
 				Port:         53,
 			},
@@ -49,12 +256,55 @@ func TestValidate(t *testing.T) {
 		{
 			name: "missing TSIG key",
 			config: &Config{
-				TSIGSecret:   "test-secret",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
 				AllowedZones: []string{"example.com"},
 				Port:         53,
 			},
 			shouldErr: true,
 		},
+		{
+			name: "TSIG secret from a Secret",
+			config: &Config{
+				TSIGKey:       "test-key",
+				TSIGSecretRef: "ddns/tsig",
+				AllowedZones:  []string{"example.com"},
+				Port:          53,
+			},
+			shouldErr: false,
+		},
+		{
+			name: "TSIG_SECRET_REF without namespace",
+			config: &Config{
+				TSIGKey:       "test-key",
+				TSIGSecretRef: "tsig",
+				AllowedZones:  []string{"example.com"},
+				Port:          53,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "key weaker than TSIG_MIN_ALGORITHM",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				TSIGAlgorithm:    "hmac-sha1",
+				TSIGMinAlgorithm: "hmac-sha256",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unsupported TSIG_MIN_ALGORITHM",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				TSIGMinAlgorithm: "hmac-sha3",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+			},
+			shouldErr: true,
+		},
 		{
 			name: "missing TSIG secret",
 			config: &Config{
@@ -68,7 +318,7 @@ func TestValidate(t *testing.T) {
 			name: "no allowed zones",
 			config: &Config{
 				TSIGKey:      "test-key",
-				TSIGSecret:   "test-secret",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
 				AllowedZones: []string{},
 				Port:         53,
 			},
@@ -78,54 +328,890 @@ func TestValidate(t *testing.T) {
 			name: "invalid port",
 			config: &Config{
 				TSIGKey:      "test-key",
-				TSIGSecret:   "test-secret",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
 				AllowedZones: []string{"example.com"},
 				Port:         0,
 			},
 			shouldErr: true,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := tt.config.Validate()
-			if tt.shouldErr && err == nil {
-				t.Error("Expected error, got nil")
-			}
-			if !tt.shouldErr && err != nil {
-				t.Errorf("Expected no error, got %v", err)
-			}
-		})
-	}
-}
-
-func TestIsZoneAllowed(t *testing.T) {
-	cfg := &Config{
-		AllowedZones: []string{"example.com", "test.org"},
-	}
-
-	tests := []struct {
-		zone    string
-		allowed bool
-	}{
-		{"example.com", true},
-		{"example.com.", true},
-		{"test.example.com", true},
-		{"test.example.com.", true},
-		{"test.org", true},
-		{"test.org.", true},
-		{"sub.test.org", true},
-		{"notallowed.com", false},
-		{"notallowed.com.", false},
-		{"example.net", false},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.zone, func(t *testing.T) {
-			result := cfg.IsZoneAllowed(tt.zone)
-			if result != tt.allowed {
-				t.Errorf("IsZoneAllowed(%s) = %v, want %v", tt.zone, result, tt.allowed)
-			}
-		})
+		{
+			name: "admin auth without audience",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				AdminAuth:    true,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid unsupported response",
+			config: &Config{
+				TSIGKey:             "test-key",
+				TSIGSecret:          "dGVzdC1zZWNyZXQ=",
+				AllowedZones:        []string{"example.com"},
+				Port:                53,
+				UnsupportedResponse: "servfail",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "DHCID enforcement with dynamic records",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				DHCIDEnforce:   true,
+				DynamicRecords: true,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "merge conflict policy with dynamic records",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				ConflictPolicy: ConflictMerge,
+				DynamicRecords: true,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "subdomain resource naming",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				ResourceNaming: ResourceNamingSubdomain,
+			},
+			shouldErr: false,
+		},
+		{
+			name: "hash suffix resource naming",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				ResourceNaming: ResourceNamingHashSuffix,
+			},
+			shouldErr: false,
+		},
+		{
+			name: "unknown resource naming",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				ResourceNaming: "dotted",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "tenant metrics",
+			config: &Config{
+				TSIGKey:             "test-key",
+				TSIGSecret:          "dGVzdC1zZWNyZXQ=",
+				AllowedZones:        []string{"example.com"},
+				Port:                53,
+				MetricsDimensions:   []string{"zone", "key"},
+				MetricsSeriesBudget: 100,
+			},
+			shouldErr: false,
+		},
+		{
+			name: "unknown metrics dimension",
+			config: &Config{
+				TSIGKey:             "test-key",
+				TSIGSecret:          "dGVzdC1zZWNyZXQ=",
+				AllowedZones:        []string{"example.com"},
+				Port:                53,
+				MetricsDimensions:   []string{"zone", "name"},
+				MetricsSeriesBudget: 100,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "tenant metrics without budget",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				MetricsDimensions: []string{"zone"},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "replication",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				ReplicationListen: ":7946",
+				ReplicationPeers:  []string{"ddnsbridge-1.ddnsbridge:7946"},
+				ReplicationToken:  "secret",
+			},
+			shouldErr: false,
+		},
+		{
+			name: "replication without token",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				ReplicationPeers: []string{"ddnsbridge-1.ddnsbridge:7946"},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid replication peer",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				ReplicationPeers: []string{"ddnsbridge-1"},
+				ReplicationToken: "secret",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unknown conflict policy",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				ConflictPolicy: "newest",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid namespace template",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				NamespaceTemplate: "dns-{{.Label",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "namespace template with compaction",
+			config: &Config{
+				TSIGKey:            "test-key",
+				TSIGSecret:         "dGVzdC1zZWNyZXQ=",
+				AllowedZones:       []string{"example.com"},
+				Port:               53,
+				NamespaceTemplate:  "dns-{{.Label 0}}",
+				CompactionInterval: time.Hour,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "grouping with dynamic records",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				GroupByRequester: true,
+				DynamicRecords:   true,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "grouping with first owner wins",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				GroupByRequester: true,
+				ConflictPolicy:   ConflictFirstOwnerWins,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "negative update batch size",
+			config: &Config{
+				TSIGKey:         "test-key",
+				TSIGSecret:      "dGVzdC1zZWNyZXQ=",
+				AllowedZones:    []string{"example.com"},
+				Port:            53,
+				UpdateBatchSize: -1,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "negative max update records",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				MaxUpdateRecords: -1,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "all namespaces without template",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				Namespace:    NamespaceAll,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "all namespaces with template",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				Namespace:         NamespaceAll,
+				NamespaceTemplate: "dns-{{.Label -1}}",
+			},
+			shouldErr: false,
+		},
+		{
+			name: "unknown ANY response",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				AnyResponse:  "refuse",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid dnstap output",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				DnstapOutput: "/var/log/dnstap.fstrm",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "kafka producer",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				KafkaBrokers:      []string{"kafka:9092"},
+				KafkaTopic:        "dns",
+				KafkaFormat:       "avro",
+				KafkaPartitionKey: "zone",
+			},
+			shouldErr: false,
+		},
+		{
+			name: "unknown kafka format",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				KafkaBrokers:      []string{"kafka:9092"},
+				KafkaTopic:        "dns",
+				KafkaFormat:       "xml",
+				KafkaPartitionKey: "zone",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unbounded capture",
+			config: &Config{
+				TSIGKey:         "test-key",
+				TSIGSecret:      "dGVzdC1zZWNyZXQ=",
+				AllowedZones:    []string{"example.com"},
+				Port:            53,
+				CaptureFile:     "/tmp/capture.pcap",
+				CaptureMaxSize:  1 << 20,
+				CaptureDuration: 0,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "negative write interval",
+			config: &Config{
+				TSIGKey:       "test-key",
+				TSIGSecret:    "dGVzdC1zZWNyZXQ=",
+				AllowedZones:  []string{"example.com"},
+				Port:          53,
+				WriteInterval: -time.Second,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "negative retry backoff",
+			config: &Config{
+				TSIGKey:             "test-key",
+				TSIGSecret:          "dGVzdC1zZWNyZXQ=",
+				AllowedZones:        []string{"example.com"},
+				Port:                53,
+				BackendRetryBackoff: -time.Second,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "dedup window without cache size",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				DedupWindow:  5 * time.Minute,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "dedup window without endpoint cache",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				DedupWindow:    5 * time.Minute,
+				DedupCacheSize: 100,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "write journal without replay interval",
+			config: &Config{
+				TSIGKey:                 "test-key",
+				TSIGSecret:              "dGVzdC1zZWNyZXQ=",
+				AllowedZones:            []string{"example.com"},
+				Port:                    53,
+				WriteJournal:            "memory",
+				WriteJournalMaxMessages: 100,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unknown async queue full behaviour",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				AsyncWorkers:   4,
+				AsyncQueueSize: 100,
+				AsyncQueueFull: "drop",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid redis address",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				RedisAddr:    "redis",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unknown zone matching",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				ZoneMatching: "prefix",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "negative TCP pipeline depth",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				TCPPipelineDepth: -1,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "TLS port without certificate",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				TLSPort:      853,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "certificate ACLs without client CA",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				TLSPort:      853,
+				TLSCertFile:  "tls.crt",
+				TLSKeyFile:   "tls.key",
+				CertACLs:     map[string][]string{"router": {"home.example.com"}},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "zone keys below an allowed zone",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				ZoneKeys:     map[string][]string{"prod.example.com.": {"prod-key"}},
+			},
+			shouldErr: false,
+		},
+		{
+			name: "admin socket mode with file type bits",
+			config: &Config{
+				TSIGKey:         "test-key",
+				TSIGSecret:      "dGVzdC1zZWNyZXQ=",
+				AllowedZones:    []string{"example.com"},
+				Port:            53,
+				AdminSocket:     "/run/ddnsbridge4extdns/admin.sock",
+				AdminSocketMode: os.ModeSocket | 0660,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unsigned zones with unsigned requests refused",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				UnsignedRequests: UnsignedRequestsRefuse,
+				UnsignedZones:    map[string][]*net.IPNet{"example.com.": nil},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "all namespaces with zone namespaces",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				Namespace:      NamespaceAll,
+				ZoneNamespaces: map[string]string{"dyn.example.com.": "dyn-dns"},
+			},
+			shouldErr: false,
+		},
+		{
+			name: "zone namespace outside of allowed zones",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				ZoneNamespaces: map[string]string{"lab.example.org.": "lab"},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "zone namespaces with compaction",
+			config: &Config{
+				TSIGKey:            "test-key",
+				TSIGSecret:         "dGVzdC1zZWNyZXQ=",
+				AllowedZones:       []string{"example.com"},
+				Port:               53,
+				ZoneNamespaces:     map[string]string{"dyn.example.com.": "dyn-dns"},
+				CompactionInterval: time.Hour,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "TTL expiry zone outside of allowed zones",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				TTLExpiry:         map[string]int{"example.org.": 3},
+				TTLExpiryInterval: time.Minute,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "TTL expiry with dynamic records",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				TTLExpiry:         map[string]int{"ci.example.com.": 3},
+				TTLExpiryInterval: time.Minute,
+				DynamicRecords:    true,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid snapshot endpoint",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				SnapshotBucket:    "backups",
+				SnapshotEndpoint:  "minio:9000",
+				SnapshotRetention: 30,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "snapshot retention too low",
+			config: &Config{
+				TSIGKey:           "test-key",
+				TSIGSecret:        "dGVzdC1zZWNyZXQ=",
+				AllowedZones:      []string{"example.com"},
+				Port:              53,
+				SnapshotBucket:    "backups",
+				SnapshotEndpoint:  "http://minio:9000",
+				SnapshotRetention: 0,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "push to another key",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				TSIGKeys:     []TSIGKeySpec{{Name: "bind-key", Secret: "YmluZC1zZWNyZXQ="}},
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				PushServer:   "192.0.2.53:53",
+				PushNet:      "tcp",
+				PushKey:      "bind-key.",
+			},
+			shouldErr: false,
+		},
+		{
+			name: "push server without port",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				PushServer:   "192.0.2.53",
+				PushNet:      "tcp",
+				PushKey:      "test-key",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "key scope of an unknown key",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				KeyScopes:    acl.New(map[string][]string{"opnsense": {"dyn.example.com"}}),
+			},
+			shouldErr: true,
+		},
+		{
+			name: "key scope",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				KeyScopes:    acl.New(map[string][]string{"test-key.": {"dyn.example.com"}}),
+			},
+			shouldErr: false,
+		},
+		{
+			name: "unknown push key",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				PushServer:   "192.0.2.53:53",
+				PushNet:      "tcp",
+				PushKey:      "bind-key",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "invalid zone pattern",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				ZonePatterns: []string{"[lab.example.com"},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "unsigned zone not allowed",
+			config: &Config{
+				TSIGKey:       "test-key",
+				TSIGSecret:    "dGVzdC1zZWNyZXQ=",
+				AllowedZones:  []string{"example.com"},
+				Port:          53,
+				UnsignedZones: map[string][]*net.IPNet{"lab.test.": nil},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "zone sources not allowed",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				ZoneSources:  map[string][]*net.IPNet{"lab.test.": nil},
+			},
+			shouldErr: true,
+		},
+		{
+			name: "audit log without rotated files",
+			config: &Config{
+				TSIGKey:          "test-key",
+				TSIGSecret:       "dGVzdC1zZWNyZXQ=",
+				AllowedZones:     []string{"example.com"},
+				Port:             53,
+				AuditLog:         "/var/log/ddns/audit.log",
+				AuditLogMaxSize:  1 << 20,
+				AuditLogMaxFiles: 0,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "rate limit",
+			config: &Config{
+				TSIGKey:        "test-key",
+				TSIGSecret:     "dGVzdC1zZWNyZXQ=",
+				AllowedZones:   []string{"example.com"},
+				Port:           53,
+				RateLimit:      0.5,
+				RateLimitBurst: 5,
+			},
+			shouldErr: false,
+		},
+		{
+			name: "rate limit without burst",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				RateLimit:    10,
+			},
+			shouldErr: true,
+		},
+		{
+			name: "negative rate limit",
+			config: &Config{
+				TSIGKey:      "test-key",
+				TSIGSecret:   "dGVzdC1zZWNyZXQ=",
+				AllowedZones: []string{"example.com"},
+				Port:         53,
+				RateLimit:    -1,
+			},
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.shouldErr && err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if !tt.shouldErr && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestIsZoneAllowed(t *testing.T) {
+	tests := []struct {
+		zone   string
+		strict bool
+		suffix bool
+	}{
+		{"example.com", true, true},
+		{"example.com.", true, true},
+		{"test.example.com", false, true},
+		{"test.example.com.", false, true},
+		{"test.org", true, true},
+		{"test.org.", true, true},
+		{"sub.test.org", false, true},
+		{"notallowed.com", false, false},
+		{"notallowed.com.", false, false},
+		{"example.net", false, false},
+		{"badexample.com", false, false},
+	}
+
+	for _, mode := range []string{"", ZoneMatchingStrict, ZoneMatchingSuffix} {
+		cfg := &Config{
+			AllowedZones: []string{"example.com", "test.org."},
+			ZoneMatching: mode,
+		}
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.zone, func(t *testing.T) {
+				want := tt.strict
+				if mode == ZoneMatchingSuffix {
+					want = tt.suffix
+				}
+				if result := cfg.IsZoneAllowed(tt.zone); result != want {
+					t.Errorf("IsZoneAllowed(%s) = %v, want %v", tt.zone, result, want)
+				}
+			})
+		}
+	}
+}
+
+func TestZoneAuthorizer(t *testing.T) {
+	cfg := &Config{
+		AllowedZones: []string{"example.com"},
+		ZonePatterns: []string{"*.lab.example.org"},
+		KeyZones:     map[string][]string{"team-key": {"team.example.net"}},
+	}
+	authorizer := cfg.ZoneAuthorizer()
+
+	tests := []struct {
+		zone     string
+		key      string
+		expected bool
+	}{
+		{"example.com.", "", true},
+		{"sub.example.com.", "", false},
+		{"a.lab.example.org.", "", true},
+		{"team.example.net.", "team-key", true},
+		{"team.example.net.", "other-key", false},
+		{"example.org.", "team-key", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone+"/"+tt.key, func(t *testing.T) {
+			if got := authorizer.Allows(tt.zone, tt.key); got != tt.expected {
+				t.Errorf("Allows(%s, %s) = %v, want %v", tt.zone, tt.key, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestZoneOf(t *testing.T) {
+	cfg := &Config{
+		AllowedZones: []string{"example.com", "lan.example.com", "168.192.in-addr.arpa."},
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"host.example.com.", "example.com."},
+		{"host.lan.example.com", "lan.example.com."},
+		{"10.1.168.192.in-addr.arpa.", "168.192.in-addr.arpa."},
+		{"10.1.0.10.in-addr.arpa.", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.ZoneOf(tt.name); got != tt.expected {
+				t.Errorf("ZoneOf(%s) = %q, want %q", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsTrapZone(t *testing.T) {
+	cfg := &Config{
+		AllowedZones: []string{"example.com"},
+		TrapZones:    []string{"decoy.example.com", "internal.test"},
+	}
+
+	tests := []struct {
+		zone string
+		trap bool
+	}{
+		{"decoy.example.com.", true},
+		{"sub.decoy.example.com", true},
+		{"internal.test.", true},
+		{"example.com.", false},
+		{"other.example.com.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			if got := cfg.IsTrapZone(tt.zone); got != tt.trap {
+				t.Errorf("IsTrapZone(%s) = %v, want %v", tt.zone, got, tt.trap)
+			}
+		})
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		value   string
+		network string
+		port    int
+		wantErr bool
+	}{
+		{"", "", 0, false},
+		{"icmp", "icmp", 0, false},
+		{"TCP:22", "tcp", 22, false},
+		{"tcp", "", 0, true},
+		{"tcp:0", "", 0, true},
+		{"udp:53", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			network, port, err := parseProbe(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProbe(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if network != tt.network || port != tt.port {
+				t.Errorf("parseProbe(%q) = %s, %d, want %s, %d", tt.value, network, port, tt.network, tt.port)
+			}
+		})
+	}
+}
+
+func TestParseKeyPriorities(t *testing.T) {
+	priorities, err := parseKeyPriorities(map[string]string{"admin": "100", "router": "-1"})
+	if err != nil {
+		t.Fatalf("parseKeyPriorities() failed: %v", err)
+	}
+	if priorities["admin"] != 100 || priorities["router"] != -1 {
+		t.Errorf("parseKeyPriorities() = %v", priorities)
+	}
+
+	if _, err := parseKeyPriorities(map[string]string{"admin": "high"}); err == nil {
+		t.Error("Expected error for a non-numeric priority")
 	}
 }
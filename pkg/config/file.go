@@ -0,0 +1,184 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// fileValues holds the variables of the configuration file, overridden by the
+// environment
+var (
+	fileMu     sync.RWMutex
+	fileValues map[string]string
+)
+
+// getenv returns the value of a variable from the command line, the environment,
+// or the configuration file, the first one setting it winning
+func (f Flags) getenv(key string) string {
+	fileMu.RLock()
+	defer fileMu.RUnlock()
+	return f.lookup(key, fileValues)
+}
+
+// lookup returns the value of a variable from the command line, the environment,
+// or the variables of a configuration file
+func (f Flags) lookup(key string, file map[string]string) string {
+	if value := f[key]; value != "" {
+		return value
+	}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return file[key]
+}
+
+// LoadConfigFile loads the configuration from a YAML (or JSON) file of variables
+// by name, or a TOML file with the .toml extension, overridden by the environment
+// and the flags. Lists and maps are written as such rather than as the
+// comma-separated strings of the environment:
+//
+//	ALLOWED_ZONES: [example.com, lab.example.com]
+//	TSIG_KEYS:
+//	  router-key: hmac-sha512:c2VjcmV0
+//	ZONE_KEYS:
+//	  prod.example.com: [prod-key, ops-key]
+//
+// The file applies to the configuration documents pushed afterwards as well.
+func LoadConfigFile(path string, flags Flags) (*Config, error) {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadKnown(values, func(key string) string {
+		return flags.lookup(key, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+	setFileValues(values)
+	return cfg, nil
+}
+
+// setFileValues replaces the variables of the configuration file
+func setFileValues(values map[string]string) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	fileValues = values
+}
+
+// readConfigFile reads the variables of a configuration file, decoded as TOML
+// with the .toml extension and as YAML otherwise
+func readConfigFile(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	decode := decodeYAML
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		decode = decodeTOML
+	}
+	values, err := parseConfigFile(raw, decode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseConfigFile flattens a configuration file decoded by decode into the
+// strings of the environment variables
+func parseConfigFile(raw []byte, decode func(raw []byte) (map[string]interface{}, error)) (map[string]string, error) {
+	fields, err := decode(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields))
+	for key, field := range fields {
+		value, err := flattenValue(field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// decodeYAML decodes a YAML (or JSON) configuration file, numbers kept as written
+func decodeYAML(raw []byte) (map[string]interface{}, error) {
+	document, err := yaml.YAMLToJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("expected a mapping of variables: %w", err)
+	}
+	return fields, nil
+}
+
+// flattenValue writes a value of the file like in the environment: lists
+// separated by commas, maps as key=value pairs separated by commas, and the lists
+// of maps separated by |
+func flattenValue(field interface{}) (string, error) {
+	switch v := field.(type) {
+	case []interface{}:
+		return flattenList(v, ",")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(v))
+		for _, key := range keys {
+			value, err := flattenScalar(v[key])
+			if list, ok := v[key].([]interface{}); ok {
+				value, err = flattenList(list, "|")
+			}
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", key, err)
+			}
+			pairs = append(pairs, key+"="+value)
+		}
+		return strings.Join(pairs, ","), nil
+	}
+	return flattenScalar(field)
+}
+
+// flattenList joins the scalars of a list with a separator
+func flattenList(list []interface{}, separator string) (string, error) {
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		value, err := flattenScalar(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, value)
+	}
+	return strings.Join(items, separator), nil
+}
+
+// flattenScalar writes a string, number or boolean
+func flattenScalar(field interface{}) (string, error) {
+	switch v := field.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("unexpected nested value %v", field)
+}
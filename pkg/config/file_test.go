@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "scalars",
+			raw:      "TSIG_KEY: router-key\nPORT: 5353\nSERVE_SOA: true\nRATE_LIMIT: 0.5\nTSIG_FUDGE: 5m\n",
+			expected: map[string]string{"TSIG_KEY": "router-key", "PORT": "5353", "SERVE_SOA": "true", "RATE_LIMIT": "0.5", "TSIG_FUDGE": "5m"},
+		},
+		{
+			name:     "list",
+			raw:      "ALLOWED_ZONES: [example.com, lab.example.com]\n",
+			expected: map[string]string{"ALLOWED_ZONES": "example.com,lab.example.com"},
+		},
+		{
+			name:     "map",
+			raw:      "TSIG_KEYS:\n  router-key: hmac-sha512:c2VjcmV0\n  dhcp-key: ZGhjcA==\n",
+			expected: map[string]string{"TSIG_KEYS": "dhcp-key=ZGhjcA==,router-key=hmac-sha512:c2VjcmV0"},
+		},
+		{
+			name:     "map of lists",
+			raw:      "ZONE_KEYS:\n  prod.example.com: [prod-key, ops-key]\n  lab.example.com: lab-key\n",
+			expected: map[string]string{"ZONE_KEYS": "lab.example.com=lab-key,prod.example.com=prod-key|ops-key"},
+		},
+		{
+			name:     "JSON",
+			raw:      `{"ALLOWED_ZONES": ["example.com"], "PORT": 53}`,
+			expected: map[string]string{"ALLOWED_ZONES": "example.com", "PORT": "53"},
+		},
+		{
+			name:    "nested too deep",
+			raw:     "ZONE_KEYS:\n  prod.example.com:\n    keys: [prod-key]\n",
+			wantErr: true,
+		},
+		{
+			name:    "not a mapping",
+			raw:     "- TSIG_KEY\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseConfigFile([]byte(tt.raw), decodeYAML)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(values, tt.expected) {
+				t.Errorf("parseConfigFile() = %v, want %v", values, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseConfigFileTOML(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{
+			name:     "scalars",
+			raw:      "# Router\nTSIG_KEY = \"router-key\"\nPORT = 5_353\nSERVE_SOA = true # answered\nRATE_LIMIT = 0.5\nTSIG_FUDGE = '5m'\n",
+			expected: map[string]string{"TSIG_KEY": "router-key", "PORT": "5353", "SERVE_SOA": "true", "RATE_LIMIT": "0.5", "TSIG_FUDGE": "5m"},
+		},
+		{
+			name:     "escapes",
+			raw:      `CUSTOM_LABELS = "team=\"dns\"\u00e9"`,
+			expected: map[string]string{"CUSTOM_LABELS": "team=\"dns\"\u00e9"},
+		},
+		{
+			name:     "list",
+			raw:      "ALLOWED_ZONES = [\n  \"example.com\", # production\n  \"lab.example.com\",\n]\n",
+			expected: map[string]string{"ALLOWED_ZONES": "example.com,lab.example.com"},
+		},
+		{
+			name:     "table",
+			raw:      "PORT = 53\n\n[TSIG_KEYS]\nrouter-key = \"hmac-sha512:c2VjcmV0\"\ndhcp-key = \"ZGhjcA==\"\n",
+			expected: map[string]string{"PORT": "53", "TSIG_KEYS": "dhcp-key=ZGhjcA==,router-key=hmac-sha512:c2VjcmV0"},
+		},
+		{
+			name:     "map of lists",
+			raw:      "ZONE_KEYS = { \"prod.example.com\" = [\"prod-key\", \"ops-key\"], \"lab.example.com\" = \"lab-key\" }\n",
+			expected: map[string]string{"ZONE_KEYS": "lab.example.com=lab-key,prod.example.com=prod-key|ops-key"},
+		},
+		{
+			name:    "dotted key",
+			raw:     "ZONE_KEYS.prod = \"prod-key\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "defined twice",
+			raw:     "PORT = 53\nPORT = 5353\n",
+			wantErr: true,
+		},
+		{
+			name:    "unquoted string",
+			raw:     "TSIG_FUDGE = 5m\n",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			raw:     "TSIG_KEY = \"router-key\n",
+			wantErr: true,
+		},
+		{
+			name:    "two values on a line",
+			raw:     "PORT = 53 TSIG_KEY = \"router-key\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "nested too deep",
+			raw:     "[ZONE_KEYS]\n\"prod.example.com\" = { keys = [\"prod-key\"] }\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := parseConfigFile([]byte(tt.raw), decodeTOML)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(values, tt.expected) {
+				t.Errorf("parseConfigFile() = %v, want %v", values, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "TSIG_KEY: test-key\nTSIG_SECRET: dGVzdC1zZWNyZXQ=\nALLOWED_ZONES: [example.com, example.org]\nPORT: 5353\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	os.Setenv("PORT", "53")
+	defer os.Clearenv()
+	t.Cleanup(func() { fileValues = nil })
+
+	cfg, err := LoadConfigFile(path, nil)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() failed: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.AllowedZones, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected the zones of the file, got %v", cfg.AllowedZones)
+	}
+	if cfg.Port != 53 {
+		t.Errorf("Expected the environment to override the file, got port %d", cfg.Port)
+	}
+
+	// Pushed documents apply over the file
+	pushed, err := LoadDocument(map[string]string{"ALLOWED_ZONES": "example.net"})
+	if err != nil {
+		t.Fatalf("LoadDocument() failed: %v", err)
+	}
+	if pushed.TSIGKey != "test-key" || !reflect.DeepEqual(pushed.AllowedZones, []string{"example.net"}) {
+		t.Errorf("Expected the pushed zones over the file, got key %q and zones %v", pushed.TSIGKey, pushed.AllowedZones)
+	}
+
+	if err := os.WriteFile(path, []byte(content+"ALLOWED_ZONE: example.net\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := LoadConfigFile(path, nil); err == nil {
+		t.Error("Expected an unknown variable to be refused")
+	}
+
+	// The .toml extension selects TOML
+	path = filepath.Join(t.TempDir(), "config.toml")
+	content = "TSIG_KEY = \"test-key\"\nTSIG_SECRET = \"dGVzdC1zZWNyZXQ=\"\nALLOWED_ZONES = [\"example.com\"]\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if cfg, err = LoadConfigFile(path, nil); err != nil {
+		t.Fatalf("LoadConfigFile() failed: %v", err)
+	}
+	if cfg.TSIGKey != "test-key" || !reflect.DeepEqual(cfg.AllowedZones, []string{"example.com"}) {
+		t.Errorf("Expected the settings of the TOML file, got key %q and zones %v", cfg.TSIGKey, cfg.AllowedZones)
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flags holds the variables set on the command line, overriding the environment
+// and the configuration file
+type Flags map[string]string
+
+// Variables returns the names of the configuration variables
+func Variables() []string {
+	seen := make(map[string]bool)
+	load(func(key string) string {
+		seen[key] = true
+		return ""
+	})
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FlagName returns the command-line flag of a variable: LISTEN_ADDR is set with
+// --listen-addr
+func FlagName(variable string) string {
+	return strings.ToLower(strings.ReplaceAll(variable, "_", "-"))
+}
+
+// RegisterFlags adds a flag for every configuration variable to flags, and returns
+// the Flags holding their values once flags is parsed, for the loaders. The flags
+// take the values of the environment and override it, and the values of the
+// configuration file.
+func RegisterFlags(flags *flag.FlagSet) Flags {
+	values := make(Flags)
+	for _, variable := range Variables() {
+		flags.Func(FlagName(variable), fmt.Sprintf("value of %s", variable), func(value string) error {
+			values[variable] = value
+			return nil
+		})
+	}
+	return values
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlagName(t *testing.T) {
+	tests := []struct {
+		variable string
+		expected string
+	}{
+		{"PORT", "port"},
+		{"LISTEN_ADDR", "listen-addr"},
+		{"TSIG_KEYS", "tsig-keys"},
+	}
+
+	for _, tt := range tests {
+		if got := FlagName(tt.variable); got != tt.expected {
+			t.Errorf("FlagName(%s) = %s, want %s", tt.variable, got, tt.expected)
+		}
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("TSIG_KEY: file-key\nLOG_LEVEL: debug\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	os.Setenv("PORT", "53")
+	os.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	defer os.Clearenv()
+	t.Cleanup(func() { fileValues = nil })
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	values := RegisterFlags(flags)
+	for _, variable := range Variables() {
+		if flags.Lookup(FlagName(variable)) == nil {
+			t.Errorf("Expected a flag for %s", variable)
+		}
+	}
+	if err := flags.Parse([]string{"--port", "5300", "--tsig-key=flag-key", "--allowed-zones", "example.com,example.org", "gc"}); err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if args := flags.Args(); len(args) != 1 || args[0] != "gc" {
+		t.Errorf("Expected the subcommand to remain, got %v", args)
+	}
+
+	cfg, err := LoadConfigFile(path, values)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() failed: %v", err)
+	}
+	if cfg.Port != 5300 {
+		t.Errorf("Expected the flag to override the environment, got port %d", cfg.Port)
+	}
+	if cfg.TSIGKey != "flag-key" {
+		t.Errorf("Expected the flag to override the file, got key %s", cfg.TSIGKey)
+	}
+	if cfg.TSIGSecret != "dGVzdC1zZWNyZXQ=" || cfg.LogLevel != "debug" {
+		t.Errorf("Expected the environment and the file without flags, got secret %s and log level %s", cfg.TSIGSecret, cfg.LogLevel)
+	}
+	if len(cfg.AllowedZones) != 2 {
+		t.Errorf("Expected the zones of the flag, got %v", cfg.AllowedZones)
+	}
+
+	// The flags only apply to the loaders they are given to
+	os.Setenv("ALLOWED_ZONES", "example.com")
+	if cfg, err = LoadConfig(nil); err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if cfg.Port != 53 || cfg.TSIGKey != "file-key" {
+		t.Errorf("Expected the environment and the file without the flags, got port %d and key %s", cfg.Port, cfg.TSIGKey)
+	}
+}
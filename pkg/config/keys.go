@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/tsig"
+)
+
+// TSIGKeySpec is a TSIG key accepted by the server
+type TSIGKeySpec struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+// tsigAlgorithms maps the supported algorithm names to their DNS names
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":    dns.HmacMD5,
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// TSIGAlgorithmName returns the DNS name of a supported algorithm, e.g.
+// "hmac-sha256." for "hmac-sha256" (the default when empty), or an empty string
+func TSIGAlgorithmName(algorithm string) string {
+	if algorithm == "" {
+		return dns.HmacSHA256
+	}
+	return tsigAlgorithms[strings.TrimSuffix(strings.ToLower(algorithm), ".")]
+}
+
+// MinAlgorithmName returns the DNS name of TSIG_MIN_ALGORITHM, or an empty string
+// when any algorithm is accepted
+func (c *Config) MinAlgorithmName() string {
+	if c.TSIGMinAlgorithm == "" {
+		return ""
+	}
+	return TSIGAlgorithmName(c.TSIGMinAlgorithm)
+}
+
+// Keys returns the accepted TSIG keys: TSIG_KEY followed by TSIG_KEYS, the keys
+// of TSIG_KEYS_FILE and the other keys of TSIG_SECRET_REF. The Secret replaces the
+// secret of a key of the same name, and its algorithm when given.
+func (c *Config) Keys() []TSIGKeySpec {
+	keys := make([]TSIGKeySpec, 0, len(c.TSIGKeys)+len(c.SecretKeys)+1)
+	keys = append(keys, TSIGKeySpec{Name: c.TSIGKey, Algorithm: c.TSIGAlgorithm, Secret: c.TSIGSecret})
+	keys = append(keys, c.TSIGKeys...)
+	for _, key := range c.SecretKeys {
+		i := indexOfKey(keys, key.Name)
+		if i < 0 {
+			if key.Algorithm == "" {
+				key.Algorithm = c.TSIGAlgorithm
+			}
+			keys = append(keys, key)
+			continue
+		}
+		keys[i].Secret = key.Secret
+		if key.Algorithm != "" {
+			keys[i].Algorithm = key.Algorithm
+		}
+	}
+	return keys
+}
+
+// indexOfKey returns the index of the key of a name, ignoring case and trailing
+// dot, or -1
+func indexOfKey(keys []TSIGKeySpec, name string) int {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for i, key := range keys {
+		if strings.TrimSuffix(strings.ToLower(key.Name), ".") == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// SecretRef returns the namespace and name of the Secret of TSIG_SECRET_REF
+func (c *Config) SecretRef() (namespace, name string) {
+	namespace, name, _ = strings.Cut(c.TSIGSecretRef, "/")
+	return namespace, name
+}
+
+// Key returns the accepted TSIG key of a name, ignoring case and trailing dot
+func (c *Config) Key(name string) (TSIGKeySpec, bool) {
+	keys := c.Keys()
+	if i := indexOfKey(keys, name); i >= 0 {
+		return keys[i], true
+	}
+	return TSIGKeySpec{}, false
+}
+
+// TSIGSecrets returns the secrets of the accepted keys for the DNS server, by
+// key name with and without trailing dot
+func (c *Config) TSIGSecrets() map[string]string {
+	secrets := make(map[string]string)
+	for _, key := range c.Keys() {
+		name := strings.TrimSuffix(key.Name, ".")
+		secrets[name] = key.Secret
+		secrets[name+"."] = key.Secret
+	}
+	return secrets
+}
+
+// parseTSIGKeys parses additional keys given as "algorithm:secret" or "secret"
+// by key name, the latter using the default algorithm
+func parseTSIGKeys(raw map[string]string, defaultAlgorithm string) []TSIGKeySpec {
+	keys := make([]TSIGKeySpec, 0, len(raw))
+	for name, value := range raw {
+		key := TSIGKeySpec{Name: name, Algorithm: defaultAlgorithm, Secret: value}
+		if algorithm, secret, ok := strings.Cut(value, ":"); ok {
+			key.Algorithm, key.Secret = strings.ToLower(algorithm), secret
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys
+}
+
+// readTSIGKeysFile reads the keys of a file holding one "name=secret" or
+// "name=algorithm:secret" per line, such as a mounted Secret. Blank lines and
+// lines starting with # are ignored.
+func readTSIGKeysFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("line %d: expected name=secret", n)
+		}
+		if _, ok := keys[name]; ok {
+			return nil, fmt.Errorf("line %d: TSIG key %s is configured twice", n, name)
+		}
+		keys[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// validateTSIGKeys checks the algorithm and secret of every key, and that key names are unique
+func (c *Config) validateTSIGKeys() error {
+	seen := make(map[string]bool)
+	for _, key := range c.Keys() {
+		name := strings.TrimSuffix(strings.ToLower(key.Name), ".")
+		if seen[name] {
+			return fmt.Errorf("TSIG key %s is configured twice", key.Name)
+		}
+		seen[name] = true
+		if TSIGAlgorithmName(key.Algorithm) == "" {
+			return fmt.Errorf("unsupported algorithm %q for TSIG key %s", key.Algorithm, key.Name)
+		}
+		if min := c.MinAlgorithmName(); min != "" && tsig.Weaker(TSIGAlgorithmName(key.Algorithm), min) {
+			return fmt.Errorf("algorithm %s of TSIG key %s is weaker than TSIG_MIN_ALGORITHM %s", key.Algorithm, key.Name, c.TSIGMinAlgorithm)
+		}
+		if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil {
+			return fmt.Errorf("secret of TSIG key %s must be valid base64: %w", key.Name, err)
+		}
+	}
+	return nil
+}
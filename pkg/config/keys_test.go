@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestKeys(t *testing.T) {
+	cfg := &Config{
+		TSIGKey:       "router",
+		TSIGSecret:    "dGVzdC1zZWNyZXQ=",
+		TSIGAlgorithm: "hmac-sha256",
+		TSIGKeys: parseTSIGKeys(map[string]string{
+			"dhcp.":   "hmac-sha512:c2Vjb25kLXNlY3JldA==",
+			"backup1": "dGhpcmQtc2VjcmV0",
+		}, "hmac-sha256"),
+	}
+	if err := cfg.validateTSIGKeys(); err != nil {
+		t.Fatalf("validateTSIGKeys() failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		found     bool
+		algorithm string
+		secret    string
+	}{
+		{"router.", true, "hmac-sha256", "dGVzdC1zZWNyZXQ="},
+		{"DHCP", true, "hmac-sha512", "c2Vjb25kLXNlY3JldA=="},
+		{"backup1.", true, "hmac-sha256", "dGhpcmQtc2VjcmV0"},
+		{"unknown", false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := cfg.Key(tt.name)
+			if ok != tt.found || key.Algorithm != tt.algorithm || key.Secret != tt.secret {
+				t.Errorf("Key(%s) = %+v, %v", tt.name, key, ok)
+			}
+		})
+	}
+
+	secrets := cfg.TSIGSecrets()
+	if len(secrets) != 6 || secrets["dhcp"] != "c2Vjb25kLXNlY3JldA==" || secrets["router."] != "dGVzdC1zZWNyZXQ=" {
+		t.Errorf("Unexpected TSIG secrets: %v", secrets)
+	}
+	if TSIGAlgorithmName("HMAC-SHA512.") != dns.HmacSHA512 || TSIGAlgorithmName("") != dns.HmacSHA256 {
+		t.Error("Unexpected algorithm names")
+	}
+}
+
+func TestValidateTSIGKeysErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []TSIGKeySpec
+	}{
+		{"duplicate", []TSIGKeySpec{{Name: "Router.", Secret: "c2VjcmV0"}}},
+		{"unknown algorithm", []TSIGKeySpec{{Name: "dhcp", Algorithm: "gss-tsig", Secret: "c2VjcmV0"}}},
+		{"invalid secret", []TSIGKeySpec{{Name: "dhcp", Algorithm: "hmac-sha1", Secret: "not base64!"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{TSIGKey: "router", TSIGSecret: "dGVzdC1zZWNyZXQ=", TSIGKeys: tt.keys}
+			if err := cfg.validateTSIGKeys(); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Listener is an additional DNS listener restricted to a subset of zones and keys
+type Listener struct {
+	// Addr is the host:port the listener binds on UDP and TCP
+	Addr string
+	// Zones the listener accepts updates for
+	Zones []string
+	// Keys (TSIG key names or certificate identities) the listener accepts, all when empty
+	Keys []string
+}
+
+// AllowsZone checks if a zone is served by the listener
+func (l Listener) AllowsZone(zone string) bool {
+	return matchesZone(zone, l.Zones)
+}
+
+// AllowsKey checks if updates authenticated by a key are accepted by the listener
+func (l Listener) AllowsKey(key string) bool {
+	if len(l.Keys) == 0 {
+		return true
+	}
+	key = strings.TrimSuffix(strings.ToLower(key), ".")
+	for _, k := range l.Keys {
+		if strings.TrimSuffix(strings.ToLower(k), ".") == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseListeners parses listeners in the format
+// "addr=host:port zones=zone1|zone2 keys=key1|key2;addr=..."
+func parseListeners(value string) ([]Listener, error) {
+	listeners := make([]Listener, 0)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var listener Listener
+		for _, field := range strings.Fields(entry) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid listener field %q", field)
+			}
+			switch parts[0] {
+			case "addr":
+				listener.Addr = parts[1]
+			case "zones":
+				listener.Zones = splitList(parts[1])
+			case "keys":
+				listener.Keys = splitList(parts[1])
+			default:
+				return nil, fmt.Errorf("unknown listener field %q", parts[0])
+			}
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// validateListener checks a listener against the configuration
+func (c *Config) validateListener(l Listener) error {
+	if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+		return fmt.Errorf("listener address %q must be host:port: %w", l.Addr, err)
+	}
+	if len(l.Zones) == 0 {
+		return fmt.Errorf("listener %s requires at least one zone", l.Addr)
+	}
+	for _, zone := range l.Zones {
+		if !c.IsZoneAllowed(zone) && !c.IsTrapZone(zone) {
+			return fmt.Errorf("listener %s zone %s is not in ALLOWED_ZONES (ZONE_MATCHING=%s)", l.Addr, zone, c.zoneMatching())
+		}
+	}
+	return nil
+}
+
+// splitList splits a "|" separated list, dropping empty items
+func splitList(value string) []string {
+	result := make([]string, 0)
+	for _, item := range strings.Split(value, "|") {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+	return result
+}
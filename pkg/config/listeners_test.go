@@ -0,0 +1,77 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseListeners(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  []Listener
+		shouldErr bool
+	}{
+		{"empty", "", []Listener{}, false},
+		{
+			name:  "two listeners",
+			value: "addr=0.0.0.0:5354 zones=public.example.com keys=router; addr=192.168.1.10:53 zones=lan.example.com|home.arpa",
+			expected: []Listener{
+				{Addr: "0.0.0.0:5354", Zones: []string{"public.example.com"}, Keys: []string{"router"}},
+				{Addr: "192.168.1.10:53", Zones: []string{"lan.example.com", "home.arpa"}},
+			},
+		},
+		{"unknown field", "addr=0.0.0.0:5354 zone=example.com", nil, true},
+		{"missing value", "addr", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listeners, err := parseListeners(tt.value)
+			if tt.shouldErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if !reflect.DeepEqual(listeners, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, listeners)
+			}
+		})
+	}
+}
+
+func TestListenerRestrictions(t *testing.T) {
+	listener := Listener{Addr: "0.0.0.0:5354", Zones: []string{"public.example.com"}, Keys: []string{"router."}}
+
+	if !listener.AllowsZone("host.public.example.com.") {
+		t.Error("Expected zone below a listener zone to be allowed")
+	}
+	if listener.AllowsZone("lan.example.com.") {
+		t.Error("Expected other zones to be refused")
+	}
+	if !listener.AllowsKey("Router") {
+		t.Error("Expected key to match regardless of case and trailing dot")
+	}
+	if listener.AllowsKey("nas.") {
+		t.Error("Expected other keys to be refused")
+	}
+	if !(Listener{}).AllowsKey("nas.") {
+		t.Error("Expected a listener without keys to accept all keys")
+	}
+
+	cfg := &Config{AllowedZones: []string{"example.com"}, ZoneMatching: ZoneMatchingSuffix}
+	if err := cfg.validateListener(listener); err != nil {
+		t.Errorf("Expected valid listener, got %v", err)
+	}
+	strict := &Config{AllowedZones: []string{"example.com"}}
+	if err := strict.validateListener(listener); err == nil {
+		t.Error("Expected listener zone below ALLOWED_ZONES to be invalid with strict zone matching")
+	}
+	if err := cfg.validateListener(Listener{Addr: "0.0.0.0:5354", Zones: []string{"example.org"}}); err == nil {
+		t.Error("Expected listener zone outside of ALLOWED_ZONES to be invalid")
+	}
+}
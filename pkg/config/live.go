@@ -0,0 +1,203 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrRestartRequired is returned when a configuration document changes settings
+// that only take effect on restart
+var ErrRestartRequired = errors.New("settings require a restart")
+
+// liveFields are the Config fields that can be changed without a restart: zones,
+// keys and the policies the handler reads on every message
+var liveFields = map[string]bool{
+	"AllowedZones":   true,
+	"ZonePatterns":   true,
+	"ZoneMatching":   true,
+	"KeyZones":       true,
+	"ZoneKeys":       true,
+	"KeyScopes":      true,
+	"UnsignedZones":  true,
+	"AllowedSources": true,
+	"ZoneSources":    true,
+	"TrapZones":      true,
+	"CertACLs":       true,
+	"CustomLabels":   true,
+
+	"TSIGKey":       true,
+	"TSIGSecret":    true,
+	"TSIGAlgorithm": true,
+	"TSIGKeys":      true,
+	"TSIGKeysFile":  true,
+	"SecretKeys":    true,
+
+	"UnsignedRequests":    true,
+	"AcceptUnsigned":      true,
+	"UnsupportedResponse": true,
+	"AnyResponse":         true,
+	"ProbeAction":         true,
+	"TSIGFudge":           true,
+	"TSIGSkewTolerance":   true,
+	"TSIGRotationOverlap": true,
+	"TSIGMinAlgorithm":    true,
+	"ServeSOA":            true,
+	"SOADefaults":         true,
+	"SOAZones":            true,
+	"UpdateBatchSize":     true,
+	"MaxUpdateRecords":    true,
+	"LogLevel":            true,
+}
+
+// Change is a setting that differs between two configurations, with secrets
+// replaced by their fingerprint
+type Change struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+	// Live is set when the setting can be changed without a restart
+	Live bool `json:"live"`
+}
+
+// Diff returns the settings that differ between two configurations, sorted by field name
+func Diff(old, updated *Config) []Change {
+	oldFields, newFields := old.Redacted(), updated.Redacted()
+	changes := make([]Change, 0)
+	for field, oldValue := range oldFields {
+		if newValue := newFields[field]; !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, Change{Field: field, Old: oldValue, New: newValue, Live: liveFields[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// Live holds the running configuration, replaced as a whole by Apply
+type Live struct {
+	mu       sync.Mutex
+	current  *Config
+	flags    Flags
+	watchers []func(*Config)
+}
+
+// NewLive creates a Live running cfg, loaded with flags, which the documents and
+// reloads are read over as well
+func NewLive(cfg *Config, flags Flags) *Live {
+	return &Live{current: cfg, flags: flags}
+}
+
+// Current returns the running configuration, which must not be modified
+func (l *Live) Current() *Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current
+}
+
+// OnApply registers a function called with every applied configuration, before
+// Apply returns
+func (l *Live) OnApply(watcher func(*Config)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.watchers = append(l.watchers, watcher)
+}
+
+// Apply loads and validates a configuration document and returns its changes to
+// the running configuration. Unless dryRun, the document replaces the running
+// configuration. A document changing settings that require a restart is refused
+// with ErrRestartRequired, and nothing is applied.
+func (l *Live) Apply(values map[string]string, dryRun bool) ([]Change, error) {
+	updated, err := loadDocument(values, l.flags)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.replace(updated, dryRun)
+}
+
+// Reload reads the configuration file at path again, when set, and applies the
+// configuration of the command line, the environment and the file like Apply,
+// replacing the documents applied since startup. The previous file is kept when
+// the new one does not apply.
+func (l *Live) Reload(path string) ([]Change, error) {
+	values := map[string]string{}
+	if path != "" {
+		var err error
+		if values, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+	}
+	updated, err := loadKnown(values, func(key string) string {
+		return l.flags.lookup(key, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changes, err := l.replace(updated, false)
+	if err != nil {
+		return changes, err
+	}
+	if path != "" {
+		setFileValues(values)
+	}
+	return changes, nil
+}
+
+// replace replaces the running configuration with updated unless dryRun or one of
+// its changes requires a restart, l.mu being held
+func (l *Live) replace(updated *Config, dryRun bool) ([]Change, error) {
+	// The keys of TSIG_SECRET_REF come from the Secret, not from the document
+	updated.SecretKeys = l.current.SecretKeys
+	changes := Diff(l.current, updated)
+	var restart []string
+	for _, change := range changes {
+		if !change.Live {
+			restart = append(restart, change.Field)
+		}
+	}
+	if len(restart) > 0 {
+		return changes, fmt.Errorf("%w: %s", ErrRestartRequired, strings.Join(restart, ", "))
+	}
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	l.current = updated
+	for _, watcher := range l.watchers {
+		watcher(updated)
+	}
+	return changes, nil
+}
+
+// SetSecretKeys replaces the keys read from the Secret of TSIG_SECRET_REF, given
+// as "secret" or "algorithm:secret" by key name, and applies them like Apply.
+// Keys that do not validate, or leaving TSIG_KEY without a secret, are refused.
+func (l *Live) SetSecretKeys(data map[string]string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	updated := *l.current
+	updated.SecretKeys = parseTSIGKeys(data, "")
+	if reflect.DeepEqual(updated.SecretKeys, l.current.SecretKeys) {
+		return nil
+	}
+	if key, _ := updated.Key(updated.TSIGKey); key.Secret == "" {
+		return fmt.Errorf("no secret for TSIG_KEY %s", updated.TSIGKey)
+	}
+	if err := updated.validateTSIGKeys(); err != nil {
+		return err
+	}
+
+	l.current = &updated
+	for _, watcher := range l.watchers {
+		watcher(&updated)
+	}
+	return nil
+}
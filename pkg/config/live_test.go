@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLiveApply(t *testing.T) {
+	t.Setenv("TSIG_KEY", "test-key")
+	t.Setenv("TSIG_SECRET", "dGVzdC1zZWNyZXQ=")
+	t.Setenv("ALLOWED_ZONES", "example.com")
+
+	tests := []struct {
+		name        string
+		values      map[string]string
+		dryRun      bool
+		wantFields  []string
+		wantErr     bool
+		wantRestart bool
+		wantApplied bool
+	}{
+		{"unchanged", map[string]string{"ALLOWED_ZONES": "example.com"}, false, nil, false, false, false},
+		{"zones and keys", map[string]string{"ALLOWED_ZONES": "example.com,example.org", "TSIG_KEYS": "new-key=bmV3LXNlY3JldA=="}, false,
+			[]string{"AllowedZones", "TSIGKeys"}, false, false, true},
+		{"dry run", map[string]string{"UNSIGNED_REQUESTS": "refuse"}, true, []string{"UnsignedRequests"}, false, false, false},
+		{"policy", map[string]string{"TSIG_FUDGE": "60"}, false, []string{"TSIGFudge"}, false, false, true},
+		{"restart required", map[string]string{"ALLOWED_ZONES": "example.org", "PORT": "53"}, false,
+			[]string{"AllowedZones", "Port"}, true, true, false},
+		{"invalid", map[string]string{"UNSIGNED_REQUESTS": "sometimes"}, false, nil, true, false, false},
+		{"unknown variable", map[string]string{"ALLOWED_ZONE": "example.org"}, false, nil, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running, err := LoadConfig(nil)
+			if err != nil {
+				t.Fatalf("LoadConfig(nil) failed: %v", err)
+			}
+			live := NewLive(running, nil)
+			var applied *Config
+			live.OnApply(func(cfg *Config) { applied = cfg })
+
+			changes, err := live.Apply(tt.values, tt.dryRun)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrRestartRequired) != tt.wantRestart {
+				t.Errorf("Apply() error = %v, want restart required %v", err, tt.wantRestart)
+			}
+			fields := make([]string, 0, len(changes))
+			for _, change := range changes {
+				fields = append(fields, change.Field)
+			}
+			if len(fields) != len(tt.wantFields) {
+				t.Fatalf("Apply() changed %v, want %v", fields, tt.wantFields)
+			}
+			for i := range fields {
+				if fields[i] != tt.wantFields[i] {
+					t.Errorf("Apply() changed %v, want %v", fields, tt.wantFields)
+				}
+			}
+			if (applied != nil) != tt.wantApplied {
+				t.Errorf("applied = %v, want %v", applied != nil, tt.wantApplied)
+			}
+			if tt.wantApplied && live.Current() != applied {
+				t.Error("Current() is not the applied configuration")
+			}
+			if !tt.wantApplied && live.Current() != running {
+				t.Error("Current() changed without being applied")
+			}
+		})
+	}
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	old := &Config{TSIGSecret: "b2xkLXNlY3JldA==", Port: 5353}
+	updated := &Config{TSIGSecret: "bmV3LXNlY3JldA==", Port: 5353}
+
+	changes := Diff(old, updated)
+	if len(changes) != 1 || changes[0].Field != "TSIGSecret" || !changes[0].Live {
+		t.Fatalf("Diff() = %+v, want a live TSIGSecret change", changes)
+	}
+	if changes[0].Old != Fingerprint(old.TSIGSecret) || changes[0].New != Fingerprint(updated.TSIGSecret) {
+		t.Errorf("Diff() = %+v, want fingerprints of the secrets", changes[0])
+	}
+}
+
+func TestLiveSetSecretKeys(t *testing.T) {
+	t.Setenv("TSIG_KEY", "test-key")
+	t.Setenv("TSIG_SECRET", "")
+	t.Setenv("TSIG_SECRET_REF", "ddns/tsig")
+	t.Setenv("TSIG_KEYS", "router-key=hmac-sha512:cm91dGVyLXNlY3JldA==")
+	t.Setenv("ALLOWED_ZONES", "example.com")
+
+	running, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	live := NewLive(running, nil)
+	applied := 0
+	live.OnApply(func(*Config) { applied++ })
+
+	tests := []struct {
+		name    string
+		data    map[string]string
+		wantErr bool
+		applied int
+	}{
+		{"no secret for TSIG_KEY", map[string]string{"router-key": "bmV3LXNlY3JldA=="}, true, 0},
+		{"invalid secret", map[string]string{"test-key": "not base64!"}, true, 0},
+		{"rotation", map[string]string{"test-key": "dGVzdC1zZWNyZXQ=", "router-key": "bmV3LXNlY3JldA==", "dhcp-key": "hmac-sha1:ZGhjcC1zZWNyZXQ="}, false, 1},
+		{"unchanged", map[string]string{"test-key": "dGVzdC1zZWNyZXQ=", "router-key": "bmV3LXNlY3JldA==", "dhcp-key": "hmac-sha1:ZGhjcC1zZWNyZXQ="}, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := live.SetSecretKeys(tt.data); (err != nil) != tt.wantErr {
+				t.Fatalf("SetSecretKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if applied != tt.applied {
+				t.Errorf("Expected %d applied configurations, got %d", tt.applied, applied)
+			}
+		})
+	}
+
+	current := live.Current()
+	if len(current.Keys()) != 3 {
+		t.Errorf("Expected 3 keys, got %+v", current.Keys())
+	}
+	if key, _ := current.Key("router-key"); key.Algorithm != "hmac-sha512" || key.Secret != "bmV3LXNlY3JldA==" {
+		t.Errorf("Expected the rotated secret of router-key with its algorithm, got %+v", key)
+	}
+	if key, _ := current.Key("dhcp-key"); key.Algorithm != "hmac-sha1" {
+		t.Errorf("Expected dhcp-key added with hmac-sha1, got %+v", key)
+	}
+
+	// Pushed documents keep the keys of the Secret
+	if _, err := live.Apply(map[string]string{"TSIG_FUDGE": "60"}, false); err != nil {
+		t.Fatalf("Apply() failed: %v", err)
+	}
+	if key, _ := live.Current().Key("test-key"); key.Secret != "dGVzdC1zZWNyZXQ=" {
+		t.Errorf("Expected the secret of TSIG_KEY kept, got %+v", key)
+	}
+}
+
+func TestLiveReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+	}
+	write("TSIG_KEY: test-key\nTSIG_SECRET: dGVzdC1zZWNyZXQ=\nALLOWED_ZONES: [example.com]\n")
+	t.Cleanup(func() { fileValues = nil })
+
+	running, err := LoadConfigFile(path, nil)
+	if err != nil {
+		t.Fatalf("LoadConfigFile() failed: %v", err)
+	}
+	live := NewLive(running, nil)
+	applied := 0
+	live.OnApply(func(*Config) { applied++ })
+
+	tests := []struct {
+		name      string
+		content   string
+		wantErr   bool
+		wantZones []string
+	}{
+		{"live change", "TSIG_KEY: test-key\nTSIG_SECRET: dGVzdC1zZWNyZXQ=\nALLOWED_ZONES: [example.com, example.org]\nCUSTOM_LABELS: {team: network}\n", false, []string{"example.com", "example.org"}},
+		{"restart required", "TSIG_KEY: test-key\nTSIG_SECRET: dGVzdC1zZWNyZXQ=\nALLOWED_ZONES: [example.net]\nPORT: 53\n", true, []string{"example.com", "example.org"}},
+		{"invalid file", "ALLOWED_ZONES: [example.net\n", true, []string{"example.com", "example.org"}},
+		{"unknown variable", "TSIG_KEY: test-key\nALLOWED_ZONE: example.net\n", true, []string{"example.com", "example.org"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.content)
+			if _, err := live.Reload(path); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if zones := live.Current().AllowedZones; !reflect.DeepEqual(zones, tt.wantZones) {
+				t.Errorf("Expected zones %v, got %v", tt.wantZones, zones)
+			}
+		})
+	}
+
+	if applied != 1 {
+		t.Errorf("Expected 1 applied configuration, got %d", applied)
+	}
+	if labels := live.Current().CustomLabels; labels["team"] != "network" {
+		t.Errorf("Expected the reloaded labels, got %v", labels)
+	}
+	// Pushed documents read the file of the last reload that applied
+	pushed, err := LoadDocument(map[string]string{"TSIG_FUDGE": "60"})
+	if err != nil {
+		t.Fatalf("LoadDocument() failed: %v", err)
+	}
+	if !reflect.DeepEqual(pushed.AllowedZones, []string{"example.com", "example.org"}) {
+		t.Errorf("Expected the zones of the reloaded file, got %v", pushed.AllowedZones)
+	}
+}
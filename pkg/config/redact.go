@@ -0,0 +1,87 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"time"
+)
+
+// secretFields are the Config fields replaced by their fingerprint when the
+// configuration is logged
+var secretFields = map[string]bool{
+	"TSIGSecret":        true,
+	"RedisPassword":     true,
+	"ReplicationToken":  true,
+	"SnapshotSecretKey": true,
+}
+
+// Fingerprint identifies a secret without revealing it: the first 8 bytes of
+// its SHA-256 digest, or an empty string when the secret is empty
+func Fingerprint(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// Redacted returns the effective configuration by field name, with secrets
+// replaced by their fingerprint and durations formatted like in the environment
+func (c *Config) Redacted() map[string]interface{} {
+	v := reflect.ValueOf(c).Elem()
+	fields := make(map[string]interface{}, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if secretFields[field.Name] {
+			value = Fingerprint(v.Field(i).String())
+		} else if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		} else if keys, ok := value.([]TSIGKeySpec); ok {
+			value = redactKeys(keys)
+		} else if mode, ok := value.(os.FileMode); ok {
+			value = fmt.Sprintf("%04o", mode)
+		} else if zones, ok := value.(map[string][]*net.IPNet); ok {
+			value = networkStrings(zones)
+		} else if networks, ok := value.([]*net.IPNet); ok {
+			value = cidrs(networks)
+		}
+		fields[field.Name] = value
+	}
+	return fields
+}
+
+// networkStrings formats the networks of each zone as CIDRs
+func networkStrings(zones map[string][]*net.IPNet) map[string][]string {
+	formatted := make(map[string][]string, len(zones))
+	for zone, networks := range zones {
+		formatted[zone] = cidrs(networks)
+	}
+	return formatted
+}
+
+// cidrs formats networks as CIDRs
+func cidrs(networks []*net.IPNet) []string {
+	formatted := make([]string, 0, len(networks))
+	for _, network := range networks {
+		formatted = append(formatted, network.String())
+	}
+	return formatted
+}
+
+// redactKeys replaces the secrets of TSIG keys by their fingerprint
+func redactKeys(keys []TSIGKeySpec) []TSIGKeySpec {
+	redacted := make([]TSIGKeySpec, len(keys))
+	for i, key := range keys {
+		key.Secret = Fingerprint(key.Secret)
+		redacted[i] = key
+	}
+	return redacted
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedacted(t *testing.T) {
+	cfg := &Config{
+		TSIGKey:       "router-key",
+		TSIGSecret:    "dGVzdC1zZWNyZXQ=",
+		RedisPassword: "hunter2",
+		AllowedZones:  []string{"example.com"},
+		Port:          53,
+		BanWindow:     time.Minute,
+
+		AdminSocketMode: 0660,
+		TSIGKeys:        []TSIGKeySpec{{Name: "dhcp", Algorithm: "hmac-sha512", Secret: "c2Vjb25kLXNlY3JldA=="}},
+	}
+	fields := cfg.Redacted()
+
+	if fields["TSIGKey"] != "router-key" || fields["Port"] != 53 {
+		t.Errorf("expected non-secret fields as is, got %v and %v", fields["TSIGKey"], fields["Port"])
+	}
+	if fields["BanWindow"] != "1m0s" {
+		t.Errorf("expected a formatted duration, got %v", fields["BanWindow"])
+	}
+	if keys := fields["TSIGKeys"].([]TSIGKeySpec); keys[0].Secret != Fingerprint("c2Vjb25kLXNlY3JldA==") {
+		t.Errorf("expected the fingerprint of additional keys, got %v", keys[0].Secret)
+	}
+	if cfg.TSIGKeys[0].Secret != "c2Vjb25kLXNlY3JldA==" {
+		t.Error("expected the configuration to be left untouched")
+	}
+	if fields["AdminSocketMode"] != "0660" {
+		t.Errorf("expected an octal socket mode, got %v", fields["AdminSocketMode"])
+	}
+	if fields["TSIGSecret"] != Fingerprint("dGVzdC1zZWNyZXQ=") {
+		t.Errorf("expected the TSIG secret fingerprint, got %v", fields["TSIGSecret"])
+	}
+	if fields["RedisPassword"] != Fingerprint("hunter2") {
+		t.Errorf("expected the Redis password fingerprint, got %v", fields["RedisPassword"])
+	}
+
+	blob, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("failed to encode redacted configuration: %v", err)
+	}
+	for _, secret := range []string{"dGVzdC1zZWNyZXQ=", "hunter2", "c2Vjb25kLXNlY3JldA=="} {
+		if strings.Contains(string(blob), secret) {
+			t.Errorf("redacted configuration leaks %q", secret)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint("") != "" {
+		t.Error("expected no fingerprint for an empty secret")
+	}
+	fp := Fingerprint("secret")
+	if !strings.HasPrefix(fp, "sha256:") || len(fp) != len("sha256:")+16 {
+		t.Errorf("unexpected fingerprint %q", fp)
+	}
+	if fp == Fingerprint("other") {
+		t.Error("expected different secrets to have different fingerprints")
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretVariables are the variables that can also be read from the file named by
+// their _FILE variant, such as a mounted Secret, keeping them out of the
+// environment and the pod spec
+var secretVariables = []string{
+	"TSIG_SECRET",
+	"REDIS_PASSWORD",
+	"REPLICATION_TOKEN",
+	"SNAPSHOT_ACCESS_KEY",
+	"SNAPSHOT_SECRET_KEY",
+}
+
+// withSecretFiles returns the variables of getenv, with the secret variables read
+// from the files of their _FILE variant when set. Trailing newlines of the files
+// are trimmed.
+func withSecretFiles(getenv func(key string) string) (environment, error) {
+	secrets := make(map[string]string)
+	for _, key := range secretVariables {
+		path := getenv(key + "_FILE")
+		if path == "" {
+			continue
+		}
+		if getenv(key) != "" {
+			return nil, fmt.Errorf("%s and %s_FILE are both set", key, key)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_FILE: %w", key, err)
+		}
+		secrets[key] = strings.TrimRight(string(raw), "\r\n")
+	}
+	return func(key string) string {
+		if value, ok := secrets[key]; ok {
+			return value
+		}
+		return getenv(key)
+	}, nil
+}
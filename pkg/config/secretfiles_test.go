@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "tsig-secret")
+	if err := os.WriteFile(secretFile, []byte("ZmlsZS1zZWNyZXQ=\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantSecret string
+		wantErr    bool
+	}{
+		{
+			name:       "secret from the environment",
+			env:        map[string]string{"TSIG_SECRET": "ZW52LXNlY3JldA=="},
+			wantSecret: "ZW52LXNlY3JldA==",
+		},
+		{
+			name:       "secret from a file, newline trimmed",
+			env:        map[string]string{"TSIG_SECRET_FILE": secretFile},
+			wantSecret: "ZmlsZS1zZWNyZXQ=",
+		},
+		{
+			name:    "both set",
+			env:     map[string]string{"TSIG_SECRET": "ZW52LXNlY3JldA==", "TSIG_SECRET_FILE": secretFile},
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			env:     map[string]string{"TSIG_SECRET_FILE": filepath.Join(dir, "missing")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TSIG_KEY", "test-key")
+			t.Setenv("ALLOWED_ZONES", "example.com")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := LoadConfig(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig(nil) error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.TSIGSecret != tt.wantSecret {
+				t.Errorf("Expected secret %s, got %s", tt.wantSecret, cfg.TSIGSecret)
+			}
+		})
+	}
+}
+
+func TestSecretFilesReload(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "tsig-secret")
+	if err := os.WriteFile(secretFile, []byte("b2xkLXNlY3JldA=="), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	t.Setenv("TSIG_KEY", "test-key")
+	t.Setenv("ALLOWED_ZONES", "example.com")
+	t.Setenv("TSIG_SECRET_FILE", secretFile)
+
+	running, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig(nil) failed: %v", err)
+	}
+	live := NewLive(running, nil)
+	if err := os.WriteFile(secretFile, []byte("bmV3LXNlY3JldA=="), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	changes, err := live.Reload("")
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Field != "TSIGSecret" {
+		t.Errorf("Expected the secret to change, got %+v", changes)
+	}
+	if secret := live.Current().TSIGSecret; secret != "bmV3LXNlY3JldA==" {
+		t.Errorf("Expected the secret of the rewritten file, got %s", secret)
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SOAParams are the SOA fields of a served zone. Zero values fall back to the defaults.
+type SOAParams struct {
+	MName   string
+	RName   string
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// SOAFor returns the SOA parameters of a zone: the per-zone overrides on top of the
+// defaults, with MNAME defaulting to the zone and RNAME to its hostmaster
+func (c *Config) SOAFor(zone string) SOAParams {
+	zone = normalizeZone(zone)
+	params := c.SOADefaults
+	if override, ok := c.SOAZones[zone]; ok {
+		params = mergeSOA(params, override)
+	}
+	if params.MName == "" {
+		params.MName = zone
+	}
+	if params.RName == "" {
+		params.RName = "hostmaster." + zone
+	}
+	params.MName = normalizeZone(params.MName)
+	params.RName = normalizeZone(params.RName)
+	return params
+}
+
+// mergeSOA returns base with the non-zero fields of override
+func mergeSOA(base, override SOAParams) SOAParams {
+	if override.MName != "" {
+		base.MName = override.MName
+	}
+	if override.RName != "" {
+		base.RName = override.RName
+	}
+	if override.Refresh != 0 {
+		base.Refresh = override.Refresh
+	}
+	if override.Retry != 0 {
+		base.Retry = override.Retry
+	}
+	if override.Expire != 0 {
+		base.Expire = override.Expire
+	}
+	if override.Minimum != 0 {
+		base.Minimum = override.Minimum
+	}
+	return base
+}
+
+// parseSOAZones parses per-zone SOA overrides given as "field:value" items
+func parseSOAZones(raw map[string][]string) (map[string]SOAParams, error) {
+	zones := make(map[string]SOAParams, len(raw))
+	for zone, items := range raw {
+		var params SOAParams
+		for _, item := range items {
+			parts := strings.SplitN(item, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid SOA parameter %q for zone %s", item, zone)
+			}
+			field, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+			if field == "mname" || field == "rname" {
+				if field == "mname" {
+					params.MName = value
+				} else {
+					params.RName = value
+				}
+				continue
+			}
+
+			seconds, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SOA %s %q for zone %s: %w", field, value, zone, err)
+			}
+			switch field {
+			case "refresh":
+				params.Refresh = uint32(seconds)
+			case "retry":
+				params.Retry = uint32(seconds)
+			case "expire":
+				params.Expire = uint32(seconds)
+			case "minimum":
+				params.Minimum = uint32(seconds)
+			default:
+				return nil, fmt.Errorf("unknown SOA parameter %q for zone %s", field, zone)
+			}
+		}
+		zones[normalizeZone(zone)] = params
+	}
+	return zones, nil
+}
+
+// normalizeZone lowercases a zone and ensures it ends with a dot
+func normalizeZone(zone string) string {
+	zone = strings.ToLower(zone)
+	if !strings.HasSuffix(zone, ".") {
+		zone = zone + "."
+	}
+	return zone
+}
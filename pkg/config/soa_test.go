@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestSOAFor(t *testing.T) {
+	zones, err := parseSOAZones(map[string][]string{
+		"Example.com": {"mname:ns1.example.com", "refresh:7200", "minimum:300"},
+	})
+	if err != nil {
+		t.Fatalf("parseSOAZones() failed: %v", err)
+	}
+
+	cfg := &Config{
+		AllowedZones: []string{"example.com", "example.org"},
+		SOADefaults:  SOAParams{Refresh: 3600, Retry: 600, Expire: 604800, Minimum: 60},
+		SOAZones:     zones,
+	}
+
+	tests := []struct {
+		zone     string
+		expected SOAParams
+	}{
+		{"example.com.", SOAParams{MName: "ns1.example.com.", RName: "hostmaster.example.com.", Refresh: 7200, Retry: 600, Expire: 604800, Minimum: 300}},
+		{"example.org", SOAParams{MName: "example.org.", RName: "hostmaster.example.org.", Refresh: 3600, Retry: 600, Expire: 604800, Minimum: 60}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			if got := cfg.SOAFor(tt.zone); got != tt.expected {
+				t.Errorf("SOAFor(%s) = %+v, want %+v", tt.zone, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSOAZonesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  map[string][]string
+	}{
+		{"missing value", map[string][]string{"example.com": {"refresh"}}},
+		{"invalid number", map[string][]string{"example.com": {"retry:soon"}}},
+		{"unknown field", map[string][]string{"example.com": {"serial:1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseSOAZones(tt.raw); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
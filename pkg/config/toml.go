@@ -0,0 +1,320 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeTOML decodes a TOML configuration file into the values decoded from YAML:
+// strings, numbers, booleans, arrays and tables. Only the TOML a file of
+// variables needs is read: keys set once, [TABLE] sections and inline tables of
+// one level, arrays, strings of one line, numbers and booleans. Dotted keys,
+// arrays of tables, multi-line strings and dates are refused.
+func decodeTOML(raw []byte) (map[string]interface{}, error) {
+	p := &tomlParser{input: string(raw), line: 1}
+	document := make(map[string]interface{})
+	table := document
+	for {
+		p.skipSpace(true)
+		if p.done() {
+			return document, nil
+		}
+		if p.peek() == '[' {
+			name, err := p.tableHeader()
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			if _, ok := document[name]; ok {
+				return nil, p.errorf("%s is defined twice", name)
+			}
+			table = make(map[string]interface{})
+			document[name] = table
+		} else if err := p.keyValue(table); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	}
+}
+
+// tomlParser reads a TOML document
+type tomlParser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *tomlParser) done() bool { return p.pos >= len(p.input) }
+
+func (p *tomlParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips the spaces and comments, and the new lines with newlines
+func (p *tomlParser) skipSpace(newlines bool) {
+	for !p.done() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.done() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine checks that nothing but a comment follows on the line
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace(false)
+	if p.done() || p.peek() == '\n' {
+		return nil
+	}
+	return fmt.Errorf("expected a new line, got %q", p.peek())
+}
+
+// tableHeader reads the name of a [TABLE] section
+func (p *tomlParser) tableHeader() (string, error) {
+	p.pos++
+	if p.peek() == '[' {
+		return "", fmt.Errorf("arrays of tables are not supported")
+	}
+	p.skipSpace(false)
+	name, err := p.key()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace(false)
+	if p.peek() != ']' {
+		return "", fmt.Errorf("expected ] after table %s", name)
+	}
+	p.pos++
+	return name, nil
+}
+
+// keyValue reads a key = value pair into table
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	key, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace(false)
+	if p.peek() != '=' {
+		return fmt.Errorf("expected = after %s", key)
+	}
+	p.pos++
+	p.skipSpace(false)
+	value, err := p.value()
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if _, ok := table[key]; ok {
+		return fmt.Errorf("%s is defined twice", key)
+	}
+	table[key] = value
+	return nil
+}
+
+// key reads a bare or quoted key
+func (p *tomlParser) key() (string, error) {
+	var key string
+	switch p.peek() {
+	case '"', '\'':
+		var err error
+		if key, err = p.str(); err != nil {
+			return "", err
+		}
+	default:
+		start := p.pos
+		for !p.done() && isBareKeyChar(p.peek()) {
+			p.pos++
+		}
+		if start == p.pos {
+			return "", fmt.Errorf("expected a key, got %q", p.peek())
+		}
+		key = p.input[start:p.pos]
+	}
+	p.skipSpace(false)
+	if p.peek() == '.' {
+		return "", fmt.Errorf("dotted key %s is not supported, quote names holding dots", key)
+	}
+	return key, nil
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value reads a string, number, boolean, array or inline table
+func (p *tomlParser) value() (interface{}, error) {
+	switch p.peek() {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inlineTable()
+	}
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t\r\n,]}#", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.input[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, fmt.Errorf("expected a value, got %q", p.peek())
+	}
+	return tomlNumber(token)
+}
+
+// tomlNumber reads an integer or a float as a number of the YAML decoder
+func tomlNumber(token string) (json.Number, error) {
+	digits := strings.ReplaceAll(token, "_", "")
+	if n, err := strconv.ParseInt(digits, 0, 64); err == nil {
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	}
+	if _, err := strconv.ParseFloat(digits, 64); err == nil && !strings.ContainsAny(digits, "xXnN") {
+		return json.Number(strings.TrimPrefix(digits, "+")), nil
+	}
+	return "", fmt.Errorf("unsupported value %s, strings are quoted", token)
+}
+
+// str reads a basic "string" with its escapes, or a literal 'string'
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.input[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", fmt.Errorf("multi-line strings are not supported")
+	}
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.done() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// escape reads the escape sequence of a basic string, after its backslash
+func (p *tomlParser) escape(b *strings.Builder) error {
+	if p.done() {
+		return fmt.Errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.input) {
+			return fmt.Errorf("invalid escape \\%c", c)
+		}
+		code, err := strconv.ParseUint(p.input[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return fmt.Errorf("invalid escape \\%c%s", c, p.input[p.pos:p.pos+size])
+		}
+		p.pos += size
+		b.WriteRune(rune(code))
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+// array reads an array, spread over lines or not
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.pos++
+	items := make([]interface{}, 0)
+	for {
+		p.skipSpace(true)
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		p.skipSpace(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected , or ] in array, got %q", p.peek())
+		}
+	}
+}
+
+// inlineTable reads a { key = value } table on one line
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.pos++
+	table := make(map[string]interface{})
+	p.skipSpace(false)
+	if p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+	for {
+		p.skipSpace(false)
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, fmt.Errorf("expected , or } in inline table, got %q", p.peek())
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchFile calls onChange whenever the modification time or the size of the file
+// at path changes, checking it every interval until ctx is done. A file missing
+// for a check, e.g. while it is replaced, is not a change.
+func WatchFile(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+			last = info
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ZoneAllowsKey checks if a key may update a name: when the name is in a zone of
+// ZONE_KEYS, the key must be one of the keys listed for the closest such zone
+func (c *Config) ZoneAllowsKey(name, key string) bool {
+	zone := policyZone(name, func(zone string) bool {
+		_, ok := c.ZoneKeys[zone]
+		return ok
+	})
+	if zone == "" {
+		return true
+	}
+	key = strings.TrimSuffix(strings.ToLower(key), ".")
+	if key == "" {
+		return false
+	}
+	for _, k := range c.ZoneKeys[zone] {
+		if strings.TrimSuffix(strings.ToLower(k), ".") == key {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyAllowsName checks if a key may update a name: a key of KEY_SCOPES, or a
+// SIG(0) key of SIG0_KEYS, may only update the names of its zones
+func (c *Config) KeyAllowsName(key, name string) bool {
+	if c.SIG0Keys.Knows(key) && !c.SIG0Keys.AllowsName(key, name) {
+		return false
+	}
+	return !c.KeyScopes.Knows(key) || c.KeyScopes.Allows(key, name)
+}
+
+// ZoneAllowsUnsigned checks if an unsigned update of a name is accepted from an IP:
+// the name must be in a zone of UNSIGNED_ZONES, and the IP in one of the networks
+// listed for the closest such zone, unless REQUIRE_TSIG=false accepts any
+func (c *Config) ZoneAllowsUnsigned(name string, ip net.IP) bool {
+	if c.AcceptUnsigned {
+		return true
+	}
+	zone := policyZone(name, func(zone string) bool {
+		_, ok := c.UnsignedZones[zone]
+		return ok
+	})
+	return zone != "" && containsIP(c.UnsignedZones[zone], ip)
+}
+
+// SourceAllowed checks if an IP may send messages: it must be in one of the
+// networks of ALLOWED_SOURCES, when set
+func (c *Config) SourceAllowed(ip net.IP) bool {
+	return len(c.AllowedSources) == 0 || containsIP(c.AllowedSources, ip)
+}
+
+// ZoneAllowsSource checks if an update of a name is accepted from an IP: when the
+// name is in a zone of ZONE_SOURCES, the IP must be in one of the networks listed
+// for the closest such zone
+func (c *Config) ZoneAllowsSource(name string, ip net.IP) bool {
+	zone := policyZone(name, func(zone string) bool {
+		_, ok := c.ZoneSources[zone]
+		return ok
+	})
+	return zone == "" || containsIP(c.ZoneSources[zone], ip)
+}
+
+// containsIP checks if one of the networks contains an IP
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyZone returns the closest zone holding a name for which has reports a policy,
+// or an empty string
+func policyZone(name string, has func(zone string) bool) string {
+	zone := normalizeZone(name)
+	for zone != "." && zone != "" {
+		if has(zone) {
+			return zone
+		}
+		i := strings.Index(zone, ".")
+		zone = zone[i+1:]
+	}
+	return ""
+}
+
+// parseZoneKeys parses the keys allowed to update each zone
+func parseZoneKeys(raw map[string][]string) (map[string][]string, error) {
+	zones := make(map[string][]string, len(raw))
+	for zone, keys := range raw {
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("no key listed for zone %s", zone)
+		}
+		zones[normalizeZone(zone)] = keys
+	}
+	return zones, nil
+}
+
+// parseZoneNetworks parses the networks of each zone, given as CIDRs or single IPs
+func parseZoneNetworks(raw map[string][]string) (map[string][]*net.IPNet, error) {
+	zones := make(map[string][]*net.IPNet, len(raw))
+	for zone, items := range raw {
+		if len(items) == 0 {
+			return nil, fmt.Errorf("no network listed for zone %s", zone)
+		}
+		networks, err := parseNetworks(items)
+		if err != nil {
+			return nil, fmt.Errorf("%w for zone %s", err, zone)
+		}
+		zones[normalizeZone(zone)] = networks
+	}
+	return zones, nil
+}
+
+// parseNetworks parses networks given as CIDRs or single IPs
+func parseNetworks(items []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		network, err := parseNetwork(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// parseTTLExpiry parses the multiple of their TTL the records of each zone live
+// without refresh
+func parseTTLExpiry(raw map[string]string) (map[string]int, error) {
+	zones := make(map[string]int, len(raw))
+	for zone, value := range raw {
+		multiple, err := strconv.Atoi(value)
+		if err != nil || multiple < 1 {
+			return nil, fmt.Errorf("invalid TTL multiple %q for zone %s, must be a positive integer", value, zone)
+		}
+		zones[normalizeZone(zone)] = multiple
+	}
+	return zones, nil
+}
+
+// parseZoneNamespaces parses the namespaces of the records of zones, by zone
+func parseZoneNamespaces(raw map[string]string) (map[string]string, error) {
+	zones := make(map[string]string, len(raw))
+	for zone, namespace := range raw {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q for zone %s: %s", namespace, zone, strings.Join(errs, ", "))
+		}
+		zones[normalizeZone(zone)] = namespace
+	}
+	return zones, nil
+}
+
+// parseNetwork parses a CIDR, or a single IP as a host network
+func parseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
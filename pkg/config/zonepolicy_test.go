@@ -0,0 +1,196 @@
+package config
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+)
+
+func TestZoneAllowsKey(t *testing.T) {
+	keys, err := parseZoneKeys(map[string][]string{
+		"Prod.example.com":    {"prod-key.", "ops-key"},
+		"db.prod.example.com": {"db-key"},
+	})
+	if err != nil {
+		t.Fatalf("parseZoneKeys() failed: %v", err)
+	}
+	cfg := &Config{ZoneKeys: keys}
+
+	tests := []struct {
+		name     string
+		key      string
+		expected bool
+	}{
+		{"host.lab.example.com.", "any-key", true},
+		{"host.lab.example.com.", "", true},
+		{"host.prod.example.com.", "prod-key", true},
+		{"prod.example.com", "OPS-KEY.", true},
+		{"host.prod.example.com.", "other-key", false},
+		{"host.prod.example.com.", "", false},
+		{"pg.db.prod.example.com.", "db-key", true},
+		{"pg.db.prod.example.com.", "prod-key", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.key, func(t *testing.T) {
+			if got := cfg.ZoneAllowsKey(tt.name, tt.key); got != tt.expected {
+				t.Errorf("ZoneAllowsKey(%s, %s) = %v, want %v", tt.name, tt.key, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKeyAllowsName(t *testing.T) {
+	cfg := &Config{KeyScopes: acl.New(map[string][]string{
+		"OPNsense.": {"dyn.example.com"},
+		"dhcp":      {"*.lan.example.com", "2.0.192.in-addr.arpa"},
+	})}
+
+	tests := []struct {
+		name     string
+		key      string
+		expected bool
+	}{
+		{"router.dyn.example.com.", "opnsense", true},
+		{"dyn.example.com", "opnsense.", true},
+		{"www.example.com.", "opnsense", false},
+		{"host.lan.example.com.", "dhcp", true},
+		{"lan.example.com.", "dhcp", false},
+		{"10.2.0.192.in-addr.arpa.", "dhcp", true},
+		{"www.example.com.", "other-key", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.key, func(t *testing.T) {
+			if got := cfg.KeyAllowsName(tt.key, tt.name); got != tt.expected {
+				t.Errorf("KeyAllowsName(%s, %s) = %v, want %v", tt.key, tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestZoneAllowsUnsigned(t *testing.T) {
+	zones, err := parseZoneNetworks(map[string][]string{
+		"lab.example.com": {"10.0.0.0/24", "192.0.2.7", "2001:db8::/64"},
+	})
+	if err != nil {
+		t.Fatalf("parseZoneNetworks() failed: %v", err)
+	}
+	cfg := &Config{UnsignedZones: zones}
+
+	tests := []struct {
+		name     string
+		ip       string
+		expected bool
+	}{
+		{"host.lab.example.com.", "10.0.0.42", true},
+		{"lab.example.com", "192.0.2.7", true},
+		{"host.lab.example.com.", "2001:db8::1", true},
+		{"host.lab.example.com.", "192.0.2.8", false},
+		{"host.lab.example.com.", "10.0.1.1", false},
+		{"host.prod.example.com.", "10.0.0.42", false},
+		{"host.otherlab.example.com.", "10.0.0.42", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.ip, func(t *testing.T) {
+			if got := cfg.ZoneAllowsUnsigned(tt.name, net.ParseIP(tt.ip)); got != tt.expected {
+				t.Errorf("ZoneAllowsUnsigned(%s, %s) = %v, want %v", tt.name, tt.ip, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSourcePolicies(t *testing.T) {
+	sources, err := parseNetworks([]string{"10.0.0.0/8", "192.0.2.7"})
+	if err != nil {
+		t.Fatalf("parseNetworks() failed: %v", err)
+	}
+	zones, err := parseZoneNetworks(map[string][]string{"lab.example.com": {"10.1.0.0/16"}})
+	if err != nil {
+		t.Fatalf("parseZoneNetworks() failed: %v", err)
+	}
+	cfg := &Config{AllowedSources: sources, ZoneSources: zones}
+
+	tests := []struct {
+		name   string
+		ip     string
+		source bool
+		zone   bool
+	}{
+		{"host.lab.example.com.", "10.1.2.3", true, true},
+		{"host.lab.example.com.", "10.2.0.1", true, false},
+		{"host.lab.example.com.", "192.0.2.7", true, false},
+		{"host.prod.example.com.", "192.0.2.7", true, true},
+		{"host.prod.example.com.", "198.51.100.1", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if got := cfg.SourceAllowed(ip); got != tt.source {
+				t.Errorf("SourceAllowed(%s) = %v, want %v", tt.ip, got, tt.source)
+			}
+			if got := cfg.ZoneAllowsSource(tt.name, ip); got != tt.zone {
+				t.Errorf("ZoneAllowsSource(%s, %s) = %v, want %v", tt.name, tt.ip, got, tt.zone)
+			}
+		})
+	}
+
+	if open := (&Config{}); !open.SourceAllowed(net.ParseIP("198.51.100.1")) || !open.ZoneAllowsSource("host.example.com.", nil) {
+		t.Error("Expected any source to be allowed without ALLOWED_SOURCES and ZONE_SOURCES")
+	}
+}
+
+func TestParseZonePolicyErrors(t *testing.T) {
+	if _, err := parseZoneKeys(map[string][]string{"example.com": {}}); err == nil {
+		t.Error("Expected error for a zone without keys, got nil")
+	}
+	tests := []struct {
+		name string
+		raw  map[string][]string
+	}{
+		{"no network", map[string][]string{"example.com": {}}},
+		{"invalid CIDR", map[string][]string{"example.com": {"10.0.0.0/33"}}},
+		{"invalid IP", map[string][]string{"example.com": {"lab-router"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseZoneNetworks(tt.raw); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestParseTTLExpiry(t *testing.T) {
+	zones, err := parseTTLExpiry(map[string]string{"CI.example.com": "3", "laptops.example.com.": "2"})
+	if err != nil {
+		t.Fatalf("parseTTLExpiry() failed: %v", err)
+	}
+	if len(zones) != 2 || zones["ci.example.com."] != 3 || zones["laptops.example.com."] != 2 {
+		t.Errorf("Unexpected TTL expiry: %v", zones)
+	}
+	for _, value := range []string{"0", "-1", "two"} {
+		if _, err := parseTTLExpiry(map[string]string{"example.com": value}); err == nil {
+			t.Errorf("Expected error for multiple %q, got nil", value)
+		}
+	}
+}
+
+func TestParseZoneNamespaces(t *testing.T) {
+	zones, err := parseZoneNamespaces(map[string]string{"Dyn.example.com": "dyn-dns", "lab.example.org.": "lab"})
+	if err != nil {
+		t.Fatalf("parseZoneNamespaces() failed: %v", err)
+	}
+	if len(zones) != 2 || zones["dyn.example.com."] != "dyn-dns" || zones["lab.example.org."] != "lab" {
+		t.Errorf("Unexpected zone namespaces: %v", zones)
+	}
+	for _, namespace := range []string{"", "Dyn", "dyn_dns", "dyn.dns"} {
+		if _, err := parseZoneNamespaces(map[string]string{"example.com": namespace}); err == nil {
+			t.Errorf("Expected error for namespace %q, got nil", namespace)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package diag
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxStackSize bounds the goroutine stacks captured in a dump
+const maxStackSize = 64 << 20
+
+// Dump is a snapshot of the state of the bridge for incident triage
+type Dump struct {
+	Time       time.Time              `json:"time"`
+	Goroutines int                    `json:"goroutines"`
+	Sections   map[string]interface{} `json:"sections"`
+	Stacks     string                 `json:"stacks"`
+}
+
+// Source reports the state of a component
+type Source func() interface{}
+
+// Dumper collects the state of the registered components
+type Dumper struct {
+	mu      sync.Mutex
+	sources map[string]Source
+}
+
+// NewDumper creates a Dumper without sources
+func NewDumper() *Dumper {
+	return &Dumper{sources: make(map[string]Source)}
+}
+
+// Register adds the section reported by a component, replacing a section of the same name
+func (d *Dumper) Register(name string, source Source) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sources[name] = source
+}
+
+// Dump captures the goroutine stacks and the state of every component
+func (d *Dumper) Dump() Dump {
+	d.mu.Lock()
+	names := make([]string, 0, len(d.sources))
+	for name := range d.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sources := make([]Source, len(names))
+	for i, name := range names {
+		sources[i] = d.sources[name]
+	}
+	d.mu.Unlock()
+
+	dump := Dump{
+		Time:       time.Now().UTC(),
+		Goroutines: runtime.NumGoroutine(),
+		Sections:   make(map[string]interface{}, len(names)),
+		Stacks:     stacks(),
+	}
+	for i, name := range names {
+		dump.Sections[name] = sources[i]()
+	}
+	return dump
+}
+
+// Log captures a dump and writes it to the log as a single JSON entry
+func (d *Dumper) Log(reason string) Dump {
+	dump := d.Dump()
+	blob, err := json.Marshal(dump)
+	if err != nil {
+		logrus.Errorf("Failed to encode diagnostic dump: %v", err)
+		return dump
+	}
+	logrus.WithField("dump", string(blob)).Warnf("Diagnostic dump (%s)", reason)
+	return dump
+}
+
+// HandleSignals logs a dump on each of the given signals until ctx is done.
+// Handling SIGQUIT replaces the default behavior of the runtime, which dumps the
+// stacks and exits.
+func (d *Dumper) HandleSignals(ctx context.Context, signals ...os.Signal) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s := <-sig:
+				d.Log(s.String())
+			}
+		}
+	}()
+}
+
+// stacks returns the stacks of all goroutines
+func stacks() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackSize {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,55 @@
+package diag
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorLog(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewErrorLog(3)
+	l.now = func() time.Time { return now }
+
+	if recent := l.Recent(); len(recent) != 0 {
+		t.Fatalf("Expected an empty log, got %v", recent)
+	}
+
+	for _, kind := range []string{"badsig", "zone", "notauth", "internal"} {
+		l.Record(kind, "refused")
+		now = now.Add(time.Second)
+	}
+
+	recent := l.Recent()
+	kinds := make([]string, 0, len(recent))
+	for _, err := range recent {
+		kinds = append(kinds, err.Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "zone,notauth,internal" {
+		t.Errorf("Expected the last 3 errors oldest first, got %s", got)
+	}
+
+	// A nil log records nothing
+	var disabled *ErrorLog
+	disabled.Record("badsig", "refused")
+	if recent := disabled.Recent(); len(recent) != 0 {
+		t.Errorf("Expected a nil log to be empty, got %v", recent)
+	}
+}
+
+func TestDumper(t *testing.T) {
+	d := NewDumper()
+	d.Register("cache", func() interface{} { return map[string]int{"entries": 2} })
+
+	dump := d.Dump()
+	if dump.Goroutines == 0 {
+		t.Error("Expected the goroutine count")
+	}
+	if !strings.Contains(dump.Stacks, "TestDumper") {
+		t.Error("Expected the stacks to contain the current goroutine")
+	}
+	cache, ok := dump.Sections["cache"].(map[string]int)
+	if !ok || cache["entries"] != 2 {
+		t.Errorf("Unexpected cache section: %v", dump.Sections["cache"])
+	}
+}
@@ -0,0 +1,60 @@
+package diag
+
+import (
+	"sync"
+	"time"
+)
+
+// Error is an error recently answered to a client
+type Error struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// ErrorLog keeps the most recent errors in a ring
+type ErrorLog struct {
+	mu     sync.Mutex
+	errors []Error
+	next   int
+	full   bool
+	now    func() time.Time
+}
+
+// NewErrorLog creates a log keeping the last size errors, or nil when size is 0
+func NewErrorLog(size int) *ErrorLog {
+	if size <= 0 {
+		return nil
+	}
+	return &ErrorLog{errors: make([]Error, size), now: time.Now}
+}
+
+// Record adds an error, overwriting the oldest one when the log is full.
+// A nil ErrorLog records nothing.
+func (l *ErrorLog) Record(kind, message string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors[l.next] = Error{Time: l.now().UTC(), Kind: kind, Message: message}
+	l.next = (l.next + 1) % len(l.errors)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns the recorded errors, oldest first
+func (l *ErrorLog) Recent() []Error {
+	if l == nil {
+		return []Error{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]Error{}, l.errors[:l.next]...)
+	}
+	recent := make([]Error, 0, len(l.errors))
+	recent = append(recent, l.errors[l.next:]...)
+	return append(recent, l.errors[:l.next]...)
+}
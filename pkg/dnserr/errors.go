@@ -0,0 +1,108 @@
+// Package dnserr defines the errors a DNS UPDATE can fail with, and maps them to the
+// rcode and extended DNS error (RFC 8914) the client is answered with
+package dnserr
+
+import (
+	"errors"
+
+	"github.com/miekg/dns"
+)
+
+// noEDE marks errors answered without an extended DNS error
+const noEDE = -1
+
+// Error is a class of DNS UPDATE failure
+type Error struct {
+	kind  string
+	text  string
+	rcode int
+	ede   int
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.text
+}
+
+// Kind returns the short name of the error class, used as metric label
+func (e *Error) Kind() string {
+	return e.kind
+}
+
+var (
+	// ErrMalformed is returned for messages that are not valid DNS UPDATEs
+	ErrMalformed = &Error{"malformed", "malformed update", dns.RcodeFormatError, noEDE}
+	// ErrUnsupportedRecordType is returned when no record of the update can be handled
+	ErrUnsupportedRecordType = &Error{"unsupported_type", "unsupported record type", dns.RcodeFormatError, int(dns.ExtendedErrorCodeNotSupported)}
+	// ErrUnsupportedClass is returned for records of a class other than IN, ANY or NONE
+	ErrUnsupportedClass = &Error{"unsupported_class", "unsupported class", dns.RcodeNotImplemented, int(dns.ExtendedErrorCodeNotSupported)}
+	// ErrZoneNotAllowed is returned for zones the bridge does not serve
+	ErrZoneNotAllowed = &Error{"zone_not_allowed", "zone not allowed", dns.RcodeRefused, int(dns.ExtendedErrorCodeNotAuthoritative)}
+	// ErrNotZone is returned for names outside of the served zones
+	ErrNotZone = &Error{"not_zone", "name outside of the served zones", dns.RcodeNotZone, int(dns.ExtendedErrorCodeNotAuthoritative)}
+	// ErrZoneRetired is returned for names of a zone being decommissioned
+	ErrZoneRetired = &Error{"zone_retired", "zone retired", dns.RcodeRefused, int(dns.ExtendedErrorCodeProhibited)}
+	// ErrNotSigned is returned for updates carrying no credentials
+	ErrNotSigned = &Error{"not_signed", "update must be signed", dns.RcodeRefused, int(dns.ExtendedErrorCodeProhibited)}
+	// ErrSourceNotAllowed is returned for messages of a source outside of ALLOWED_SOURCES or ZONE_SOURCES
+	ErrSourceNotAllowed = &Error{"source_not_allowed", "source not allowed", dns.RcodeRefused, int(dns.ExtendedErrorCodeProhibited)}
+	// ErrNotAuthorized is returned when the credentials do not allow the update
+	ErrNotAuthorized = &Error{"not_authorized", "update not authorized", dns.RcodeRefused, int(dns.ExtendedErrorCodeProhibited)}
+	// ErrTSIGKeyUnknown is returned for signatures made with an unknown key or algorithm
+	ErrTSIGKeyUnknown = &Error{"tsig_badkey", "unknown TSIG key", dns.RcodeNotAuth, noEDE}
+	// ErrTSIGBadSignature is returned for signatures that do not verify
+	ErrTSIGBadSignature = &Error{"tsig_badsig", "bad TSIG signature", dns.RcodeNotAuth, noEDE}
+	// ErrTSIGBadTime is returned for signatures made too far from the server time
+	ErrTSIGBadTime = &Error{"tsig_badtime", "TSIG time outside of the allowed skew", dns.RcodeNotAuth, noEDE}
+	// ErrSIG0BadSignature is returned for SIG(0) signatures that do not verify with
+	// a key of SIG0_KEYS
+	ErrSIG0BadSignature = &Error{"sig0_badsig", "bad SIG(0) signature", dns.RcodeNotAuth, noEDE}
+	// ErrNameOwned is returned when another source owns the name
+	ErrNameOwned = &Error{"name_owned", "name owned by another source", dns.RcodeRefused, int(dns.ExtendedErrorCodeProhibited)}
+	// ErrKeyOutranked is returned when the name was written with a key of higher priority
+	ErrKeyOutranked = &Error{"key_outranked", "name written with a higher priority key", dns.RcodeRefused, int(dns.ExtendedErrorCodeProhibited)}
+	// ErrTargetUnreachable is returned when the target of a record failed the reachability probe
+	ErrTargetUnreachable = &Error{"target_unreachable", "target unreachable", dns.RcodeRefused, int(dns.ExtendedErrorCodeOther)}
+	// ErrSessionLimit is returned when a key has too many connections or transactions in flight over TCP
+	ErrSessionLimit = &Error{"session_limit", "too many sessions", dns.RcodeRefused, int(dns.ExtendedErrorCodeOther)}
+	// ErrRateLimited is returned when a source sends messages faster than the rate limit
+	ErrRateLimited = &Error{"rate_limited", "rate limit exceeded", dns.RcodeRefused, int(dns.ExtendedErrorCodeOther)}
+	// ErrNameCollision is returned when the resource of a name holds the records of another name
+	ErrNameCollision = &Error{"name_collision", "resource name held by another name", dns.RcodeServerFailure, int(dns.ExtendedErrorCodeOther)}
+	// ErrBackendConflict is returned when Kubernetes refused a write racing another one
+	ErrBackendConflict = &Error{"backend_conflict", "conflicting backend write", dns.RcodeServerFailure, int(dns.ExtendedErrorCodeOther)}
+	// ErrBackendUnavailable is returned when Kubernetes could not be reached in time
+	ErrBackendUnavailable = &Error{"backend_unavailable", "backend unavailable", dns.RcodeServerFailure, int(dns.ExtendedErrorCodeNetworkError)}
+)
+
+// internal classifies errors outside of the taxonomy
+var internal = &Error{"internal", "internal error", dns.RcodeServerFailure, noEDE}
+
+// classify returns the class of err
+func classify(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return internal
+}
+
+// Rcode returns the rcode a failed update is answered with
+func Rcode(err error) int {
+	return classify(err).rcode
+}
+
+// Kind returns the short name of the class of err
+func Kind(err error) string {
+	return classify(err).kind
+}
+
+// ExtendedError returns the extended DNS error of err, with the text of its class so
+// internal details are not disclosed to clients
+func ExtendedError(err error) (*dns.EDNS0_EDE, bool) {
+	e := classify(err)
+	if e.ede == noEDE {
+		return nil, false
+	}
+	return &dns.EDNS0_EDE{InfoCode: uint16(e.ede), ExtraText: e.text}, true
+}
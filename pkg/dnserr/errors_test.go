@@ -0,0 +1,43 @@
+package dnserr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		rcode int
+		kind  string
+		ede   bool
+	}{
+		{"zone not allowed", fmt.Errorf("zone example.net.: %w", ErrZoneNotAllowed), dns.RcodeRefused, "zone_not_allowed", true},
+		{"bad time", fmt.Errorf("key k: %w", ErrTSIGBadTime), dns.RcodeNotAuth, "tsig_badtime", false},
+		{"backend conflict", fmt.Errorf("%w: %w", ErrBackendConflict, errors.New("409")), dns.RcodeServerFailure, "backend_conflict", true},
+		{"unsupported type", ErrUnsupportedRecordType, dns.RcodeFormatError, "unsupported_type", true},
+		{"unclassified", errors.New("boom"), dns.RcodeServerFailure, "internal", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Rcode(tt.err); got != tt.rcode {
+				t.Errorf("Rcode() = %s, want %s", dns.RcodeToString[got], dns.RcodeToString[tt.rcode])
+			}
+			if got := Kind(tt.err); got != tt.kind {
+				t.Errorf("Kind() = %s, want %s", got, tt.kind)
+			}
+			ede, ok := ExtendedError(tt.err)
+			if ok != tt.ede {
+				t.Fatalf("ExtendedError() ok = %v, want %v", ok, tt.ede)
+			}
+			if ok && ede.ExtraText != classify(tt.err).text {
+				t.Errorf("Expected EDE text of the class, got %q", ede.ExtraText)
+			}
+		})
+	}
+}
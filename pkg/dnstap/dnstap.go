@@ -0,0 +1,162 @@
+package dnstap
+
+import (
+	"net"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MessageType is the dnstap Message.Type of a logged message
+type MessageType uint64
+
+// Message types logged by the bridge, as defined by dnstap.proto
+const (
+	AuthQuery      MessageType = 1
+	AuthResponse   MessageType = 2
+	UpdateQuery    MessageType = 13
+	UpdateResponse MessageType = 14
+)
+
+// Socket protocols, as defined by dnstap.proto
+const (
+	protocolUDP = 1
+	protocolTCP = 2
+	protocolDOT = 3
+)
+
+// Field numbers of the Dnstap and Message protobuf messages
+const (
+	dnstapIdentity = 1
+	dnstapVersion  = 2
+	dnstapMessage  = 14
+	dnstapType     = 15
+
+	messageType             = 1
+	messageSocketFamily     = 2
+	messageSocketProtocol   = 3
+	messageQueryAddress     = 4
+	messageResponseAddress  = 5
+	messageQueryPort        = 6
+	messageResponsePort     = 7
+	messageQueryTimeSec     = 8
+	messageQueryTimeNsec    = 9
+	messageQueryMessage     = 10
+	messageQueryZone        = 11
+	messageResponseTimeSec  = 12
+	messageResponseTimeNsec = 13
+	messageResponseMessage  = 14
+)
+
+// Message is a DNS message exchanged with a client
+type Message struct {
+	Type MessageType
+	// Client is the address of the client, Server the address it reached
+	Client net.Addr
+	Server net.Addr
+	// TLS is set for messages received over DNS-over-TLS
+	TLS bool
+	// Zone is the packed zone of the query, if known
+	Zone []byte
+	// QueryTime and Query are set for queries and responses, ResponseTime and
+	// Response for responses only
+	QueryTime    time.Time
+	Query        []byte
+	ResponseTime time.Time
+	Response     []byte
+}
+
+// Encode encodes a message as a Dnstap protobuf payload
+func Encode(identity, version string, m Message) []byte {
+	var msg []byte
+	msg = protowire.AppendTag(msg, messageType, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, uint64(m.Type))
+	msg = appendSocket(msg, m)
+	if len(m.Zone) > 0 {
+		msg = appendBytes(msg, messageQueryZone, m.Zone)
+	}
+	if !m.QueryTime.IsZero() {
+		msg = appendTime(msg, messageQueryTimeSec, messageQueryTimeNsec, m.QueryTime)
+	}
+	if m.Query != nil {
+		msg = appendBytes(msg, messageQueryMessage, m.Query)
+	}
+	if !m.ResponseTime.IsZero() {
+		msg = appendTime(msg, messageResponseTimeSec, messageResponseTimeNsec, m.ResponseTime)
+	}
+	if m.Response != nil {
+		msg = appendBytes(msg, messageResponseMessage, m.Response)
+	}
+
+	var b []byte
+	if identity != "" {
+		b = appendBytes(b, dnstapIdentity, []byte(identity))
+	}
+	if version != "" {
+		b = appendBytes(b, dnstapVersion, []byte(version))
+	}
+	b = appendBytes(b, dnstapMessage, msg)
+	b = protowire.AppendTag(b, dnstapType, protowire.VarintType)
+	return protowire.AppendVarint(b, 1) // MESSAGE
+}
+
+// appendSocket appends the socket family, protocol, addresses and ports of a message
+func appendSocket(b []byte, m Message) []byte {
+	clientIP, clientPort, protocol := splitAddr(m.Client)
+	serverIP, serverPort, _ := splitAddr(m.Server)
+	if clientIP == nil {
+		return b
+	}
+	if m.TLS {
+		protocol = protocolDOT
+	}
+
+	family := uint64(1) // INET
+	if clientIP.To4() == nil {
+		family = 2 // INET6
+	} else {
+		clientIP = clientIP.To4()
+		if serverIP != nil && serverIP.To4() != nil {
+			serverIP = serverIP.To4()
+		}
+	}
+	b = protowire.AppendTag(b, messageSocketFamily, protowire.VarintType)
+	b = protowire.AppendVarint(b, family)
+	if protocol != 0 {
+		b = protowire.AppendTag(b, messageSocketProtocol, protowire.VarintType)
+		b = protowire.AppendVarint(b, protocol)
+	}
+	b = appendBytes(b, messageQueryAddress, clientIP)
+	b = protowire.AppendTag(b, messageQueryPort, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(clientPort))
+	if serverIP != nil {
+		b = appendBytes(b, messageResponseAddress, serverIP)
+		b = protowire.AppendTag(b, messageResponsePort, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(serverPort))
+	}
+	return b
+}
+
+// splitAddr returns the IP, port and dnstap socket protocol of an address
+func splitAddr(addr net.Addr) (net.IP, int, uint64) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port, protocolUDP
+	case *net.TCPAddr:
+		return a.IP, a.Port, protocolTCP
+	default:
+		return nil, 0, 0
+	}
+}
+
+func appendBytes(b []byte, field protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func appendTime(b []byte, secField, nsecField protowire.Number, t time.Time) []byte {
+	b = protowire.AppendTag(b, secField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(t.Unix()))
+	b = protowire.AppendTag(b, nsecField, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, uint32(t.Nanosecond()))
+}
@@ -0,0 +1,225 @@
+package dnstap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields decodes the fields of a protobuf message, keeping the last value of each field
+func fields(t *testing.T, b []byte) map[protowire.Number]interface{} {
+	t.Helper()
+	result := make(map[protowire.Number]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			result[num], b = v, b[n:]
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			result[num], b = v, b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			result[num], b = v, b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+	return result
+}
+
+// readFrame reads a frame, returning the control type of control frames
+func readFrame(t *testing.T, r io.Reader) (control uint32, payload []byte) {
+	t.Helper()
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	isControl := length == 0
+	if isControl {
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			t.Fatalf("failed to read control frame: %v", err)
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("failed to read frame: %v", err)
+	}
+	if isControl {
+		return binary.BigEndian.Uint32(payload), payload[4:]
+	}
+	return 0, payload
+}
+
+// writeControl writes a control frame without fields, as a collector does
+func writeControl(t *testing.T, w io.Writer, controlType uint32) {
+	t.Helper()
+	frame := binary.BigEndian.AppendUint32(nil, 0)
+	frame = binary.BigEndian.AppendUint32(frame, 4)
+	frame = binary.BigEndian.AppendUint32(frame, controlType)
+	if _, err := w.Write(frame); err != nil {
+		t.Fatalf("failed to write control frame: %v", err)
+	}
+}
+
+func testMessage() Message {
+	return Message{
+		Type:      UpdateQuery,
+		Client:    &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 40000},
+		Server:    &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53},
+		Zone:      []byte("\x07example\x03com\x00"),
+		QueryTime: time.Unix(1700000000, 500),
+		Query:     []byte{0x12, 0x34},
+	}
+}
+
+func TestEncode(t *testing.T) {
+	frame := fields(t, Encode("bridge-0", "ddnsbridge4extdns", testMessage()))
+	if string(frame[dnstapIdentity].([]byte)) != "bridge-0" || frame[dnstapType] != uint64(1) {
+		t.Fatalf("unexpected Dnstap fields: %v", frame)
+	}
+
+	msg := fields(t, frame[dnstapMessage].([]byte))
+	expected := map[protowire.Number]interface{}{
+		messageType:           uint64(UpdateQuery),
+		messageSocketFamily:   uint64(1),
+		messageSocketProtocol: uint64(protocolTCP),
+		messageQueryPort:      uint64(40000),
+		messageResponsePort:   uint64(53),
+		messageQueryTimeSec:   uint64(1700000000),
+		messageQueryTimeNsec:  uint32(500),
+	}
+	for field, value := range expected {
+		if msg[field] != value {
+			t.Errorf("field %d = %v, want %v", field, msg[field], value)
+		}
+	}
+	if ip := net.IP(msg[messageQueryAddress].([]byte)); !ip.Equal(net.ParseIP("192.168.1.1")) || len(ip) != 4 {
+		t.Errorf("unexpected query address %v", ip)
+	}
+	if _, ok := msg[messageResponseMessage]; ok {
+		t.Error("expected no response in a query message")
+	}
+
+	// DNS-over-TLS messages are reported as such
+	tls := testMessage()
+	tls.TLS = true
+	msg = fields(t, fields(t, Encode("", "", tls))[dnstapMessage].([]byte))
+	if msg[messageSocketProtocol] != uint64(protocolDOT) {
+		t.Errorf("expected DOT protocol, got %v", msg[messageSocketProtocol])
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		spec      string
+		network   string
+		shouldErr bool
+	}{
+		{"file:/var/log/dnstap.fstrm", "file", false},
+		{"unix:/var/run/dnstap.sock", "unix", false},
+		{"tcp:collector:6000", "tcp", false},
+		{"tcp:collector", "", true},
+		{"udp:collector:6000", "", true},
+		{"/var/log/dnstap.fstrm", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			network, _, err := ParseOutput(tt.spec)
+			if (err != nil) != tt.shouldErr || network != tt.network {
+				t.Errorf("ParseOutput(%q) = %q, %v", tt.spec, network, err)
+			}
+		})
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	output, err := Open("file:"+path, "bridge-0", "")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	output.Log(testMessage())
+	output.Log(testMessage())
+	output.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	if control, fields := readFrame(t, r); control != controlStart || string(fields[8:]) != contentType {
+		t.Fatalf("expected START with content type, got %d %q", control, fields)
+	}
+	for i := 0; i < 2; i++ {
+		if control, payload := readFrame(t, r); control != 0 || len(payload) == 0 {
+			t.Fatalf("expected data frame %d, got control %d", i, control)
+		}
+	}
+	if control, _ := readFrame(t, r); control != controlStop {
+		t.Fatalf("expected STOP, got %d", control)
+	}
+}
+
+func TestSocketOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	frames := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if control, _ := readFrame(t, conn); control != controlReady {
+			t.Errorf("expected READY, got %d", control)
+			return
+		}
+		writeControl(t, conn, controlAccept)
+		if control, _ := readFrame(t, conn); control != controlStart {
+			t.Errorf("expected START, got %d", control)
+			return
+		}
+		_, payload := readFrame(t, conn)
+		frames <- payload
+		if control, _ := readFrame(t, conn); control != controlStop {
+			t.Errorf("expected STOP, got %d", control)
+			return
+		}
+		writeControl(t, conn, controlFinish)
+	}()
+
+	output, err := Open("unix:"+path, "", "")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	output.Log(testMessage())
+	select {
+	case payload := <-frames:
+		if len(payload) == 0 {
+			t.Error("expected a dnstap payload")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the dnstap frame")
+	}
+	output.Close()
+}
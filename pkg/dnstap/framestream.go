@@ -0,0 +1,115 @@
+package dnstap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// contentType is the Frame Streams content type of dnstap payloads
+const contentType = "protobuf:dnstap.Dnstap"
+
+// Frame Streams control frame types
+const (
+	controlAccept = 0x01
+	controlStart  = 0x02
+	controlStop   = 0x03
+	controlReady  = 0x04
+	controlFinish = 0x05
+
+	controlFieldContentType = 0x01
+)
+
+// maxControlFrame bounds the control frames read from a collector
+const maxControlFrame = 512
+
+// frameWriter writes dnstap payloads as Frame Streams data frames
+type frameWriter struct {
+	w io.Writer
+	// bidirectional writers handshake with the collector, as on sockets
+	rw            io.ReadWriter
+	bidirectional bool
+}
+
+// newFileWriter starts a unidirectional frame stream, as written to files
+func newFileWriter(w io.Writer) (*frameWriter, error) {
+	fw := &frameWriter{w: w}
+	if err := fw.writeControl(controlStart, true); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// newSocketWriter starts a bidirectional frame stream with a collector
+func newSocketWriter(rw io.ReadWriter) (*frameWriter, error) {
+	fw := &frameWriter{w: rw, rw: rw, bidirectional: true}
+	if err := fw.writeControl(controlReady, true); err != nil {
+		return nil, err
+	}
+	if err := fw.expectControl(controlAccept); err != nil {
+		return nil, err
+	}
+	if err := fw.writeControl(controlStart, true); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// writeFrame writes a data frame
+func (fw *frameWriter) writeFrame(payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := fw.w.Write(frame)
+	return err
+}
+
+// close stops the stream, waiting for the collector to finish on sockets
+func (fw *frameWriter) close() error {
+	if err := fw.writeControl(controlStop, false); err != nil {
+		return err
+	}
+	if fw.bidirectional {
+		return fw.expectControl(controlFinish)
+	}
+	return nil
+}
+
+// writeControl writes a control frame, with the dnstap content type when asked
+func (fw *frameWriter) writeControl(controlType uint32, withContentType bool) error {
+	control := binary.BigEndian.AppendUint32(nil, controlType)
+	if withContentType {
+		control = binary.BigEndian.AppendUint32(control, controlFieldContentType)
+		control = binary.BigEndian.AppendUint32(control, uint32(len(contentType)))
+		control = append(control, contentType...)
+	}
+	// An escape sequence, the length of the control frame, then the frame
+	frame := binary.BigEndian.AppendUint32(nil, 0)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(control)))
+	frame = append(frame, control...)
+	_, err := fw.w.Write(frame)
+	return err
+}
+
+// expectControl reads a control frame of the given type from the collector
+func (fw *frameWriter) expectControl(controlType uint32) error {
+	var header [8]byte
+	if _, err := io.ReadFull(fw.rw, header[:]); err != nil {
+		return fmt.Errorf("failed to read control frame: %w", err)
+	}
+	if escape := binary.BigEndian.Uint32(header[:4]); escape != 0 {
+		return fmt.Errorf("expected control frame, got data frame")
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if length < 4 || length > maxControlFrame {
+		return fmt.Errorf("invalid control frame length %d", length)
+	}
+	control := make([]byte, length)
+	if _, err := io.ReadFull(fw.rw, control); err != nil {
+		return fmt.Errorf("failed to read control frame: %w", err)
+	}
+	if got := binary.BigEndian.Uint32(control[:4]); got != controlType {
+		return fmt.Errorf("expected control frame %d, got %d", controlType, got)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package dnstap
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// queueSize is the number of messages buffered while the collector is slow or unreachable
+const queueSize = 1024
+
+// reconnectDelay is the delay between two connections to an unreachable collector
+const reconnectDelay = 5 * time.Second
+
+// Output sends dnstap messages to a file or a collector socket in the background.
+// Messages are dropped, never delayed, when the collector does not keep up.
+type Output struct {
+	identity string
+	version  string
+	network  string
+	address  string
+
+	queue chan []byte
+	done  chan struct{}
+}
+
+// ParseOutput splits an output specification: "file:<path>", "unix:<path>" or "tcp:<host:port>"
+func ParseOutput(spec string) (network, address string, err error) {
+	network, address, ok := strings.Cut(spec, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("dnstap output %q must be file:<path>, unix:<path> or tcp:<host:port>", spec)
+	}
+	switch network {
+	case "file", "unix":
+	case "tcp":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("dnstap output %q: %w", spec, err)
+		}
+	default:
+		return "", "", fmt.Errorf("dnstap output %q must be file:<path>, unix:<path> or tcp:<host:port>", spec)
+	}
+	return network, address, nil
+}
+
+// Open starts an output writing to spec, sending identity and version with each message
+func Open(spec, identity, version string) (*Output, error) {
+	network, address, err := ParseOutput(spec)
+	if err != nil {
+		return nil, err
+	}
+	o := &Output{
+		identity: identity,
+		version:  version,
+		network:  network,
+		address:  address,
+		queue:    make(chan []byte, queueSize),
+		done:     make(chan struct{}),
+	}
+	if network == "file" {
+		// Fail early on an unwritable file, sockets are retried in the background
+		file, err := os.OpenFile(address, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dnstap file: %w", err)
+		}
+		fw, err := newFileWriter(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write dnstap file: %w", err)
+		}
+		go o.runFile(file, fw)
+	} else {
+		go o.runSocket()
+	}
+	return o, nil
+}
+
+// Log queues a message. A nil Output logs nothing.
+func (o *Output) Log(m Message) {
+	if o == nil {
+		return
+	}
+	select {
+	case o.queue <- Encode(o.identity, o.version, m):
+	default:
+		metrics.DnstapDropped.Inc()
+	}
+}
+
+// Close writes the queued messages and ends the stream
+func (o *Output) Close() {
+	if o == nil {
+		return
+	}
+	close(o.queue)
+	<-o.done
+}
+
+// runFile writes the queued messages to a file until the output is closed
+func (o *Output) runFile(file *os.File, fw *frameWriter) {
+	defer close(o.done)
+	defer file.Close()
+	for payload := range o.queue {
+		if err := fw.writeFrame(payload); err != nil {
+			logrus.Errorf("Failed to write dnstap file %s, dnstap disabled: %v", o.address, err)
+			o.drain()
+			return
+		}
+	}
+	if err := fw.close(); err != nil {
+		logrus.Errorf("Failed to close dnstap file %s: %v", o.address, err)
+	}
+}
+
+// runSocket sends the queued messages to a collector, reconnecting when the
+// connection is lost, until the output is closed
+func (o *Output) runSocket() {
+	defer close(o.done)
+	for {
+		conn, fw, err := o.connect()
+		if err != nil {
+			logrus.Warnf("Failed to connect to dnstap collector %s:%s: %v", o.network, o.address, err)
+			if !o.dropFor(reconnectDelay) {
+				return
+			}
+			continue
+		}
+		logrus.Infof("Connected to dnstap collector %s:%s", o.network, o.address)
+
+		closed := true
+		for payload := range o.queue {
+			if err = fw.writeFrame(payload); err != nil {
+				closed = false
+				break
+			}
+		}
+		if closed {
+			if err := fw.close(); err != nil {
+				logrus.Debugf("Failed to stop dnstap stream: %v", err)
+			}
+			conn.Close()
+			return
+		}
+		logrus.Warnf("Lost dnstap collector %s:%s: %v", o.network, o.address, err)
+		metrics.DnstapDropped.Inc()
+		conn.Close()
+	}
+}
+
+// connect opens a frame stream to the collector
+func (o *Output) connect() (net.Conn, *frameWriter, error) {
+	conn, err := net.DialTimeout(o.network, o.address, reconnectDelay)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(reconnectDelay))
+	fw, err := newSocketWriter(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, fw, nil
+}
+
+// dropFor drops the messages queued during d. It returns false once the output is closed.
+func (o *Output) dropFor(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case _, ok := <-o.queue:
+			if !ok {
+				return false
+			}
+			metrics.DnstapDropped.Inc()
+		}
+	}
+}
+
+// drain drops the messages queued until the output is closed
+func (o *Output) drain() {
+	for range o.queue {
+		metrics.DnstapDropped.Inc()
+	}
+}
@@ -0,0 +1,107 @@
+// Package geoip resolves the country and autonomous system of requester addresses
+// from local MaxMind DB (MMDB) files
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Info is what is known about an address
+type Info struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, empty when unknown
+	Country string
+	// ASN is the autonomous system number, 0 when unknown
+	ASN uint
+	// Organization is the organization of the autonomous system
+	Organization string
+}
+
+// countryRecord holds the fields read from a GeoIP2/GeoLite2 Country or City database
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// asnRecord holds the fields read from a GeoLite2 ASN database
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Resolver looks up addresses in a country and an ASN database, either being optional
+type Resolver struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// Open opens the country and ASN databases, skipping those whose path is empty
+func Open(countryPath, asnPath string) (*Resolver, error) {
+	r := &Resolver{}
+	var err error
+	if countryPath != "" {
+		if r.country, err = maxminddb.Open(countryPath); err != nil {
+			return nil, fmt.Errorf("failed to open country database: %w", err)
+		}
+	}
+	if asnPath != "" {
+		if r.asn, err = maxminddb.Open(asnPath); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to open ASN database: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns what the databases know about ip. Lookup errors leave the
+// corresponding fields empty.
+func (r *Resolver) Lookup(ip net.IP) Info {
+	var info Info
+	if r == nil || ip == nil {
+		return info
+	}
+	if r.country != nil {
+		var record countryRecord
+		if err := r.country.Lookup(ip, &record); err == nil {
+			info.Country = record.Country.ISOCode
+		}
+	}
+	if r.asn != nil {
+		var record asnRecord
+		if err := r.asn.Lookup(ip, &record); err == nil {
+			info.ASN = record.Number
+			info.Organization = record.Organization
+		}
+	}
+	return info
+}
+
+// LookupAddr returns what the databases know about the IP of a network address
+func (r *Resolver) LookupAddr(addr net.Addr) Info {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return r.Lookup(a.IP)
+	case *net.TCPAddr:
+		return r.Lookup(a.IP)
+	}
+	return Info{}
+}
+
+// Close closes the databases
+func (r *Resolver) Close() error {
+	if r == nil {
+		return nil
+	}
+	var errs []error
+	if r.country != nil {
+		errs = append(errs, r.country.Close())
+	}
+	if r.asn != nil {
+		errs = append(errs, r.asn.Close())
+	}
+	return errors.Join(errs...)
+}
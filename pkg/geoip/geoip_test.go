@@ -0,0 +1,39 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenInvalidDatabase(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"), ""); err == nil {
+		t.Error("Expected error for missing country database")
+	}
+
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	if err := os.WriteFile(path, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("", path); err == nil {
+		t.Error("Expected error for invalid ASN database")
+	}
+}
+
+func TestLookupWithoutDatabases(t *testing.T) {
+	resolver, err := Open("", "")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer resolver.Close()
+
+	if info := resolver.LookupAddr(&net.UDPAddr{IP: net.ParseIP("192.0.2.1")}); info != (Info{}) {
+		t.Errorf("Expected no information, got %+v", info)
+	}
+
+	var disabled *Resolver
+	if info := disabled.Lookup(net.ParseIP("192.0.2.1")); info != (Info{}) {
+		t.Errorf("Expected no information from a nil resolver, got %+v", info)
+	}
+}
@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errNotChecked is reported by a check that has not completed yet
+var errNotChecked = errors.New("not checked yet")
+
+// Checker aggregates the results of named readiness checks
+type Checker struct {
+	mu      sync.RWMutex
+	results map[string]error
+}
+
+// NewChecker creates a new readiness checker
+func NewChecker() *Checker {
+	return &Checker{
+		results: make(map[string]error),
+	}
+}
+
+// Register adds a check which is failing until its first result is set
+func (c *Checker) Register(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.results[name]; !ok {
+		c.results[name] = errNotChecked
+	}
+}
+
+// Set records the result of a check and logs when its state changes
+func (c *Checker) Set(name string, err error) {
+	c.mu.Lock()
+	previous, known := c.results[name]
+	c.results[name] = err
+	c.mu.Unlock()
+
+	switch {
+	case err != nil && (!known || previous == nil || previous.Error() != err.Error()):
+		logrus.Warnf("Readiness check %s failing: %v", name, err)
+	case err == nil && known && previous != nil:
+		logrus.Infof("Readiness check %s passing", name)
+	}
+}
+
+// Ready reports whether all checks pass, with the failure reason of each failing check
+func (c *Checker) Ready() (bool, map[string]string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	failures := make(map[string]string)
+	for name, err := range c.results {
+		if err != nil {
+			failures[name] = err.Error()
+		}
+	}
+	return len(failures) == 0, failures
+}
+
+// RunPeriodic registers a check and runs it every interval until ctx is done
+func (c *Checker) RunPeriodic(ctx context.Context, name string, interval time.Duration, check func(ctx context.Context) error) {
+	c.Register(name)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			c.Set(name, check(checkCtx))
+			cancel()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Countdown registers a check which passes once the returned function was called
+// n times, such as once per started listener
+func (c *Checker) Countdown(name string, n int) func() {
+	c.Register(name)
+	var mu sync.Mutex
+	left := n
+	if left <= 0 {
+		c.Set(name, nil)
+	}
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if left <= 0 {
+			return
+		}
+		left--
+		if left == 0 {
+			c.Set(name, nil)
+		}
+	}
+}
+
+// HealthzHandler reports that the process is alive
+func (c *Checker) HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// ReadyzHandler reports whether all checks pass, listing the reasons otherwise
+func (c *Checker) ReadyzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		ready, failures := c.Ready()
+		if ready {
+			w.Write([]byte("ok\n"))
+			return
+		}
+
+		names := make([]string, 0, len(failures))
+		for name := range failures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, name := range names {
+			w.Write([]byte(name + ": " + failures[name] + "\n"))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	checker := NewChecker()
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		checker.ReadyzHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("Expected ready without checks, got %d", rec.Code)
+	}
+
+	checker.Register("rbac")
+	if rec := get(); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready before the first check, got %d", rec.Code)
+	}
+
+	checker.Set("rbac", nil)
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("Expected ready after a passing check, got %d", rec.Code)
+	}
+
+	checker.Set("rbac", errors.New("cannot create dnsendpoints"))
+	rec := get()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready after a failing check, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "rbac: cannot create dnsendpoints") {
+		t.Errorf("Expected failure reason in body, got %q", rec.Body.String())
+	}
+}
+
+func TestCountdown(t *testing.T) {
+	checker := NewChecker()
+	started := checker.Countdown("listeners", 2)
+
+	if ready, _ := checker.Ready(); ready {
+		t.Error("Expected not ready before the listeners start")
+	}
+	started()
+	if ready, _ := checker.Ready(); ready {
+		t.Error("Expected not ready with a listener left")
+	}
+	started()
+	started()
+	if ready, failures := checker.Ready(); !ready {
+		t.Errorf("Expected ready once the listeners started, got %v", failures)
+	}
+}
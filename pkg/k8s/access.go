@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// requiredAccess lists the verbs the bridge needs on each resource it manages
+func (c *Client) requiredAccess() map[schema.GroupVersionResource][]string {
+	access := map[schema.GroupVersionResource][]string{
+		c.gvr: {"get", "list", "create", "update", "delete"},
+	}
+	if c.dynamicRecords {
+		access[recordGVR] = []string{"get", "list", "watch", "create", "update", "delete"}
+	}
+	if c.recordEvents {
+		access[eventGVR] = []string{"list", "create", "delete"}
+	}
+	return access
+}
+
+// CheckBackend verifies that the Kubernetes API is reachable and serves the
+// resources the bridge manages, so that a missing CRD is reported before the first update
+func (c *Client) CheckBackend(ctx context.Context) error {
+	resources := make([]schema.GroupVersionResource, 0, 3)
+	for gvr := range c.requiredAccess() {
+		resources = append(resources, gvr)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Resource < resources[j].Resource })
+
+	for _, gvr := range resources {
+		_, err := c.dynamicClient.Resource(gvr).Namespace(c.namespace).List(ctx, metav1.ListOptions{Limit: 1})
+		switch {
+		case apierrors.IsNotFound(err):
+			return fmt.Errorf("%s.%s not served, is its CRD installed?", gvr.Resource, gvr.Group)
+		case err != nil:
+			return fmt.Errorf("failed to list %s.%s: %w", gvr.Resource, gvr.Group, err)
+		}
+	}
+	return nil
+}
+
+// CheckAccess verifies with SelfSubjectAccessReviews that the bridge is still
+// allowed to manage its resources, so RBAC drift is detected before the next update
+func (c *Client) CheckAccess(ctx context.Context) error {
+	if c.authClient == nil {
+		return nil
+	}
+
+	var denied []string
+	for gvr, verbs := range c.requiredAccess() {
+		for _, verb := range verbs {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: c.namespace,
+						Verb:      verb,
+						Group:     gvr.Group,
+						Resource:  gvr.Resource,
+					},
+				},
+			}
+			result, err := c.authClient.SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to review access: %w", err)
+			}
+			if !result.Status.Allowed {
+				denied = append(denied, fmt.Sprintf("%s %s.%s", verb, gvr.Resource, gvr.Group))
+			}
+		}
+	}
+
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		scope := "namespace " + c.namespace
+		if c.namespace == metav1.NamespaceAll {
+			scope = "all namespaces"
+		}
+		return fmt.Errorf("missing RBAC permissions in %s: %s", scope, strings.Join(denied, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	denied := map[string]bool{}
+
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = !denied[attrs.Verb+" "+attrs.Resource]
+		return true, review, nil
+	})
+
+	client := newFakeClient(Options{RecordEvents: true})
+	client.authClient = clientset.AuthorizationV1()
+
+	if err := client.CheckAccess(context.Background()); err != nil {
+		t.Fatalf("Expected access to be granted, got %v", err)
+	}
+
+	denied["create dnsendpoints"] = true
+	denied["delete recordevents"] = true
+	err := client.CheckAccess(context.Background())
+	if err == nil {
+		t.Fatal("Expected access check to fail")
+	}
+	for _, want := range []string{"create dnsendpoints.externaldns.k8s.io", "delete recordevents.ddnsbridge4extdns.io", "namespace default"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in error, got %v", want, err)
+		}
+	}
+}
+
+func TestCheckBackend(t *testing.T) {
+	client := newFakeClient(Options{DynamicRecords: true})
+	if err := client.CheckBackend(context.Background()); err != nil {
+		t.Fatalf("Expected the backend to be ready, got %v", err)
+	}
+
+	client.dynamicClient.(*fake.FakeDynamicClient).PrependReactor("list", "dynamicrecords", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(recordGVR.GroupResource(), "")
+	})
+	err := client.CheckBackend(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "dynamicrecords.ddnsbridge4extdns.io not served") {
+		t.Errorf("Expected a missing CRD to be reported, got %v", err)
+	}
+
+	client.dynamicClient.(*fake.FakeDynamicClient).PrependReactor("list", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewServiceUnavailable("apiserver down")
+	})
+	if err := client.CheckBackend(context.Background()); err == nil || !strings.Contains(err.Error(), "failed to list dnsendpoints") {
+		t.Errorf("Expected an unreachable API to be reported, got %v", err)
+	}
+}
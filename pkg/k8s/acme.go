@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// typeTXT is the DNS record type of ACME challenges (dns.TypeTXT)
+const typeTXT = 16
+
+// labelChallenge marks DNSEndpoints holding ACME DNS-01 challenges
+const labelChallenge = "ddnsbridge4extdns/acme-challenge"
+
+// challengeCleanupInterval is how often orphaned ACME challenges are looked for
+const challengeCleanupInterval = 5 * time.Minute
+
+// applyChallenge adds or removes ACME challenge strings of a TXT DNSEndpoint
+func (c *Client) applyChallenge(ctx context.Context, req Requester, upd *update.DNSUpdate) (changed bool, err error) {
+	resourceName := c.hostResourceName(upd)
+
+	existing, err := c.getChallenge(ctx, resourceName, upd.Name)
+	if err != nil {
+		return false, err
+	}
+	values, ttl := challengeValues(existing)
+	if upd.TTL > 0 {
+		ttl = int64(upd.TTL)
+	}
+
+	var desired []string
+	switch upd.Type {
+	case update.UpdateTypeCreate, update.UpdateTypeUpdate:
+		desired = values
+		for _, text := range upd.Text {
+			if !containsString(desired, text) {
+				desired = append(desired, text)
+			}
+		}
+	case update.UpdateTypeDelete:
+		// A delete without strings removes the whole RRset
+		if upd.Text != nil {
+			for _, value := range values {
+				if !containsString(upd.Text, value) {
+					desired = append(desired, value)
+				}
+			}
+		}
+	default:
+		return false, fmt.Errorf("unsupported update type: %v", upd.Type)
+	}
+
+	if len(desired) == 0 {
+		if existing == nil {
+			return false, nil
+		}
+		if err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Delete(ctx, resourceName, metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return false, fmt.Errorf("failed to delete ACME challenge: %w", err)
+		}
+		logrus.Infof("Removed ACME challenge %s/%s", c.namespace, resourceName)
+		return true, nil
+	}
+
+	targets := make([]interface{}, 0, len(desired))
+	for _, value := range desired {
+		targets = append(targets, value)
+	}
+	endpointLabels := c.endpointLabels(upd.Zone, req.IP(), req.KeyName)
+	endpointLabels[labelChallenge] = "true"
+	endpoint := c.newEndpoint(resourceName, endpointLabels, upd.Name, "TXT", ttl, targets)
+	return c.upsertEndpoint(ctx, endpoint)
+}
+
+// getChallenge returns the ACME challenge DNSEndpoint of a name, or nil when there is none
+func (c *Client) getChallenge(ctx context.Context, resourceName, dnsName string) (*unstructured.Unstructured, error) {
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get ACME challenge: %w", err)
+	}
+
+	// Never touch a resource holding another name
+	if existing.GetLabels()[labelChallenge] != "true" {
+		return nil, fmt.Errorf("DNSEndpoint %s exists and does not hold an ACME challenge", resourceName)
+	}
+	if name, ok := singleDNSName(existing); ok && name != strings.ToLower(strings.TrimSuffix(dnsName, ".")) {
+		return nil, fmt.Errorf("DNSEndpoint %s holds the ACME challenge of %s", resourceName, name)
+	}
+	return existing, nil
+}
+
+// challengeValues returns the strings and TTL of an ACME challenge DNSEndpoint
+func challengeValues(endpoint *unstructured.Unstructured) ([]string, int64) {
+	if endpoint == nil {
+		return nil, 0
+	}
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	var values []string
+	var ttl int64
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if recordTTL, ok := fields["recordTTL"].(int64); ok {
+			ttl = recordTTL
+		}
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		values = append(values, targets...)
+	}
+	return values, ttl
+}
+
+// RunChallengeJanitor removes ACME challenges older than maxAge until ctx is done
+func (c *Client) RunChallengeJanitor(ctx context.Context, maxAge time.Duration) {
+	ticker := time.NewTicker(challengeCleanupInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.cleanupChallenges(ctx, time.Now().Add(-maxAge)); err != nil {
+			logrus.Errorf("Failed to clean up ACME challenges: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanupChallenges removes ACME challenges created before the cutoff, left behind
+// by clients that never sent their cleanup update
+func (c *Client) cleanupChallenges(ctx context.Context, cutoff time.Time) ([]string, error) {
+	selector := labels.Set{labelManagedBy: managedByValue, labelChallenge: "true"}.String()
+	list, err := c.dynamicClient.Resource(c.gvr).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ACME challenges: %w", err)
+	}
+
+	removed := make([]string, 0)
+	for _, item := range list.Items {
+		if !item.GetCreationTimestamp().Time.Before(cutoff) {
+			continue
+		}
+		name := c.displayName(item.GetNamespace(), item.GetName())
+		if err := c.dynamicClient.Resource(c.gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return removed, fmt.Errorf("failed to delete ACME challenge %s: %w", name, err)
+		}
+		logrus.Infof("Removed orphaned ACME challenge %s/%s", item.GetNamespace(), item.GetName())
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// containsString checks if a slice contains a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
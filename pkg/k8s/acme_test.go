@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func challengeUpdate(updateType update.UpdateType, text ...string) *update.DNSUpdate {
+	return &update.DNSUpdate{
+		Type:       updateType,
+		RecordType: dns.TypeTXT,
+		Name:       "_acme-challenge.test.example.com.",
+		Zone:       "example.com.",
+		Text:       text,
+		TTL:        60,
+	}
+}
+
+func TestApplyChallenge(t *testing.T) {
+	client := newFakeClient(Options{DynamicRecords: true, AutoApprove: false})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}, KeyName: "cert-manager."}
+
+	steps := []struct {
+		name     string
+		upd      *update.DNSUpdate
+		expected []string
+	}{
+		{"remove leftovers", challengeUpdate(update.UpdateTypeDelete), nil},
+		{"present", challengeUpdate(update.UpdateTypeCreate, "token-1"), []string{"token-1"}},
+		{"present wildcard", challengeUpdate(update.UpdateTypeCreate, "token-2"), []string{"token-1", "token-2"}},
+		{"present again", challengeUpdate(update.UpdateTypeCreate, "token-2"), []string{"token-1", "token-2"}},
+		{"cleanup", challengeUpdate(update.UpdateTypeDelete, "token-1"), []string{"token-2"}},
+		{"cleanup wildcard", challengeUpdate(update.UpdateTypeDelete, "token-2"), nil},
+	}
+
+	for _, step := range steps {
+		if _, err := client.ApplyUpdate(req, step.upd); err != nil {
+			t.Fatalf("%s: ApplyUpdate() failed: %v", step.name, err)
+		}
+		rrsets, err := client.LookupRRsets("_acme-challenge.test.example.com.", "example.com.")
+		if err != nil {
+			t.Fatalf("%s: LookupRRsets() failed: %v", step.name, err)
+		}
+		if values := rrsets[dns.TypeTXT]; !reflect.DeepEqual(values, step.expected) {
+			t.Errorf("%s: expected %v, got %v", step.name, step.expected, values)
+		}
+	}
+}
+
+func TestCleanupChallenges(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5353}}
+
+	if _, err := client.ApplyUpdate(req, challengeUpdate(update.UpdateTypeCreate, "token")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.1")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	// The fake client leaves the creation timestamp empty, any cutoff is later
+	removed, err := client.cleanupChallenges(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("cleanupChallenges() failed: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"dns--acme-challenge-test"}) {
+		t.Errorf("Expected the challenge to be removed, got %v", removed)
+	}
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected other DNSEndpoints to be kept: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func BenchmarkCompareEndpoint(b *testing.B) {
+	client := newFakeClient(Options{CustomLabels: map[string]string{"team": "network"}})
+	upd := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+	labels := client.endpointLabels(upd.Zone, "192.168.1.1", "router.")
+	existing := client.newEndpoint(client.endpointResourceName(upd), labels, upd.Name, "A", 300, []interface{}{"192.0.2.10"})
+	desired := client.newEndpoint(client.endpointResourceName(upd), labels, upd.Name, "A", 300, []interface{}{"192.0.2.11"})
+	b.ReportAllocs()
+	for b.Loop() {
+		compareEndpoint(existing, desired)
+	}
+}
+
+func BenchmarkApplyUpdate(b *testing.B) {
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+
+	for _, tt := range []struct {
+		name string
+		opts Options
+	}{
+		{"endpoint", Options{}},
+		{"dynamic-record", Options{DynamicRecords: true, AutoApprove: true}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			client := newFakeClient(tt.opts)
+			ips := []string{"192.0.2.10", "192.0.2.11"}
+			if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, ips[0])); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				i++
+				if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, ips[i%2])); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
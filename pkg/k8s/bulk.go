@@ -0,0 +1,239 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// ErrInvalidRecord is returned when an imported record is rejected by validation
+var ErrInvalidRecord = errors.New("invalid record")
+
+// Record is a managed record as exported and imported over the admin API
+type Record struct {
+	// Name is the fully qualified name of the record
+	Name string `json:"name"`
+	// Type is the record type: A, AAAA or PTR
+	Type string `json:"type"`
+	// TTL of the record in seconds
+	TTL int64 `json:"ttl"`
+	// Targets of the record; imported records hold exactly one
+	Targets []string `json:"targets"`
+	// Requester is the client that last refreshed the record
+	Requester string `json:"requester,omitempty"`
+	// Key is the TSIG key or certificate identity that last refreshed the record
+	Key string `json:"key,omitempty"`
+}
+
+// ImportResult counts the outcome of a bulk import
+type ImportResult struct {
+	Applied   int `json:"applied"`
+	Unchanged int `json:"unchanged"`
+}
+
+// importAddr is the requester address of imported records
+type importAddr string
+
+func (a importAddr) Network() string { return "import" }
+func (a importAddr) String() string  { return string(a) }
+
+// ExportRecords returns the A, AAAA and PTR records managed by the bridge, sorted by name and type
+func (c *Client) ExportRecords(ctx context.Context) ([]Record, error) {
+	gvr, kind := c.gvr, "DNSEndpoint"
+	if c.dynamicRecords {
+		gvr, kind = recordGVR, "DynamicRecord"
+	}
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(gvr).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %ss: %w", kind, err)
+	}
+
+	records := make([]Record, 0, len(list.Items))
+	for i := range list.Items {
+		item := &list.Items[i]
+		if item.GetLabels()[labelRetired] == "true" {
+			continue
+		}
+		if c.dynamicRecords {
+			spec := getSpec(item)
+			requester, _, _ := unstructured.NestedString(spec, "requester")
+			key, _, _ := unstructured.NestedString(spec, "keyName")
+			for _, entry := range recordEntries(item) {
+				if fields, ok := entry.(map[string]interface{}); ok {
+					records = appendRecord(records, fields, requester, key)
+				}
+			}
+			continue
+		}
+
+		// The annotations of the last update are exact, the labels of older
+		// resources are sanitized
+		requester, key := item.GetLabels()[labelAskBy], item.GetLabels()[labelKey]
+		if annotations := item.GetAnnotations(); annotations[annotationUpdatedAt] != "" {
+			requester, key = annotations[annotationClient], annotations[annotationKey]
+		}
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		for _, entry := range entries {
+			fields, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			records = appendRecord(records, fields, requester, key)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Type < records[j].Type
+	})
+	return records, nil
+}
+
+// appendRecord appends the record described by the fields of an endpoint or DynamicRecord spec
+func appendRecord(records []Record, fields map[string]interface{}, requester, key string) []Record {
+	recordType, _, _ := unstructured.NestedString(fields, "recordType")
+	switch recordType {
+	case "A", "AAAA", "PTR", "CNAME", "SRV", "MX", "NS":
+	default:
+		return records
+	}
+	dnsName, _, _ := unstructured.NestedString(fields, "dnsName")
+	ttl, _, _ := unstructured.NestedInt64(fields, "recordTTL")
+	targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+	return append(records, Record{
+		Name:      strings.ToLower(strings.TrimSuffix(dnsName, ".")),
+		Type:      recordType,
+		TTL:       ttl,
+		Targets:   targets,
+		Requester: requester,
+		Key:       key,
+	})
+}
+
+// ImportRecords validates all records against the zones, then applies them like
+// DNS updates. Records already up to date are left alone, so importing the same
+// document twice changes nothing. With dryRun the records are only validated.
+func (c *Client) ImportRecords(ctx context.Context, records []Record, zones []string, dryRun bool) (ImportResult, error) {
+	updates := make([]*update.DNSUpdate, 0, len(records))
+	for i, record := range records {
+		upd, err := importUpdate(record, zones)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("%w %d (%s): %v", ErrInvalidRecord, i, record.Name, err)
+		}
+		updates = append(updates, upd)
+	}
+
+	result := ImportResult{}
+	if dryRun {
+		result.Applied = len(updates)
+		return result, nil
+	}
+	for i, upd := range updates {
+		req := Requester{Addr: importAddr(records[i].Requester), KeyName: records[i].Key}
+		changed, err := c.ApplyUpdate(req, upd)
+		if err != nil {
+			return result, fmt.Errorf("failed to import %s %s: %w", records[i].Name, records[i].Type, err)
+		}
+		if changed {
+			result.Applied++
+		} else {
+			result.Unchanged++
+		}
+	}
+	logrus.Infof("Imported %d record(s), %d already up to date", result.Applied, result.Unchanged)
+	return result, nil
+}
+
+// importUpdate validates a record and converts it to the DNS update adding it
+func importUpdate(record Record, zones []string) (*update.DNSUpdate, error) {
+	name := strings.ToLower(strings.TrimSuffix(record.Name, "."))
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	zone := longestZone(name, zones)
+	if zone == "" {
+		return nil, fmt.Errorf("name is not in an allowed zone")
+	}
+	if record.TTL < 0 || record.TTL > 1<<31-1 {
+		return nil, fmt.Errorf("invalid TTL %d", record.TTL)
+	}
+	if len(record.Targets) != 1 {
+		return nil, fmt.Errorf("exactly one target is required, got %d", len(record.Targets))
+	}
+
+	// A record replaces the RRset of its name and type
+	upd := &update.DNSUpdate{
+		Type: update.UpdateTypeUpdate,
+		Name: name + ".",
+		Zone: zone + ".",
+		TTL:  uint32(record.TTL),
+	}
+	target := record.Targets[0]
+	switch strings.ToUpper(record.Type) {
+	case "A":
+		upd.RecordType = recordTypeCodes["A"]
+		upd.IP = net.ParseIP(target).To4()
+		if upd.IP == nil {
+			return nil, fmt.Errorf("invalid IPv4 target %q", target)
+		}
+	case "AAAA":
+		upd.RecordType = recordTypeCodes["AAAA"]
+		upd.IP = net.ParseIP(target)
+		if upd.IP == nil || upd.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 target %q", target)
+		}
+	case "PTR":
+		if !isReverseName(name) {
+			return nil, fmt.Errorf("PTR records require a reverse name")
+		}
+		if target == "" || strings.ContainsAny(target, " \t") {
+			return nil, fmt.Errorf("invalid PTR target %q", target)
+		}
+		upd.RecordType = typePTR
+		upd.Target = target
+	case "CNAME":
+		if name == zone {
+			return nil, fmt.Errorf("CNAME records are not allowed at the zone apex")
+		}
+		if target == "" || strings.ContainsAny(target, " \t") {
+			return nil, fmt.Errorf("invalid CNAME target %q", target)
+		}
+		upd.RecordType = typeCNAME
+		upd.Target = target
+	case "SRV":
+		upd.RecordType = typeSRV
+		if err := parseSRVTarget(upd, target); err != nil {
+			return nil, err
+		}
+	case "MX":
+		upd.RecordType = typeMX
+		if err := parseMXTarget(upd, target); err != nil {
+			return nil, err
+		}
+	case "NS":
+		if name == zone {
+			return nil, fmt.Errorf("NS records are not allowed at the zone apex")
+		}
+		if target == "" || strings.ContainsAny(target, " \t") {
+			return nil, fmt.Errorf("invalid NS target %q", target)
+		}
+		upd.RecordType = typeNS
+		upd.Target = target
+	default:
+		return nil, fmt.Errorf("unsupported record type %q", record.Type)
+	}
+	return upd, nil
+}
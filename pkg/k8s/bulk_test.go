@@ -0,0 +1,72 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestExportImportRecords(t *testing.T) {
+	ctx := context.Background()
+	zones := []string{"example.com"}
+	client := newFakeClient(Options{})
+
+	if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	records, err := client.ExportRecords(ctx)
+	if err != nil {
+		t.Fatalf("ExportRecords() failed: %v", err)
+	}
+	want := []Record{{Name: "test.example.com", Type: "A", TTL: 300, Targets: []string{"192.0.2.10"}, Requester: "192.168.1.1", Key: "router-a"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("ExportRecords() = %+v, want %+v", records, want)
+	}
+
+	// Importing the export again changes nothing
+	result, err := client.ImportRecords(ctx, records, zones, false)
+	if err != nil {
+		t.Fatalf("ImportRecords() failed: %v", err)
+	}
+	if result != (ImportResult{Unchanged: 1}) {
+		t.Errorf("ImportRecords() = %+v, want 1 unchanged", result)
+	}
+
+	records = append(records, Record{Name: "nas.example.com.", Type: "aaaa", TTL: 60, Targets: []string{"2001:db8::1"}})
+	result, err = client.ImportRecords(ctx, records, zones, false)
+	if err != nil {
+		t.Fatalf("ImportRecords() failed: %v", err)
+	}
+	if result != (ImportResult{Applied: 1, Unchanged: 1}) {
+		t.Errorf("ImportRecords() = %+v, want 1 applied and 1 unchanged", result)
+	}
+
+	invalid := []struct {
+		name   string
+		record Record
+	}{
+		{"outside zones", Record{Name: "web.example.org", Type: "A", Targets: []string{"192.0.2.1"}}},
+		{"IPv6 in A", Record{Name: "web.example.com", Type: "A", Targets: []string{"2001:db8::1"}}},
+		{"several targets", Record{Name: "web.example.com", Type: "A", Targets: []string{"192.0.2.1", "192.0.2.2"}}},
+		{"unsupported type", Record{Name: "web.example.com", Type: "MX", Targets: []string{"mail.example.com"}}},
+		{"forward PTR", Record{Name: "web.example.com", Type: "PTR", Targets: []string{"host.example.com"}}},
+		{"CNAME at apex", Record{Name: "example.com", Type: "CNAME", Targets: []string{"host.example.net"}}},
+		{"SRV without port", Record{Name: "_ldap._tcp.example.com", Type: "SRV", Targets: []string{"0 100 dc1.example.com"}}},
+		{"NS at apex", Record{Name: "example.com", Type: "NS", Targets: []string{"ns1.example.net"}}},
+	}
+	for _, tt := range invalid {
+		// A single invalid record rejects the whole document
+		doc := []Record{{Name: "web.example.com", Type: "A", Targets: []string{"192.0.2.1"}}, tt.record}
+		if _, err := client.ImportRecords(ctx, doc, zones, false); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("%s: expected ErrInvalidRecord, got %v", tt.name, err)
+		}
+	}
+	records, _ = client.ExportRecords(ctx)
+	if len(records) != 2 {
+		t.Errorf("Expected invalid documents to write nothing, got %+v", records)
+	}
+}
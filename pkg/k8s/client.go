@@ -3,33 +3,169 @@ package k8s
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	authenticationv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
 )
 
+// Labels set on managed resources
+const (
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	labelZone      = "ddnsbridge4extdns/zone"
+	labelAskBy     = "ddnsbridge4extdns/ask-by"
+	labelKey       = "ddnsbridge4extdns/key"
+	// labelUnreachable flags records whose target failed the reachability probe
+	labelUnreachable = "ddnsbridge4extdns/unreachable"
+	// Country and autonomous system of the requester, when GeoIP is enabled
+	labelCountry = "ddnsbridge4extdns/country"
+	labelASN     = "ddnsbridge4extdns/asn"
+
+	managedByValue = "ddnsbridge4extdns"
+)
+
+// Requester identifies the client that sent an update
+type Requester struct {
+	// Addr is the remote address of the client
+	Addr net.Addr
+	// KeyName is the TSIG key that signed the update, if any
+	KeyName string
+	// Country is the ISO code of the country of the client, when known
+	Country string
+	// ASN is the autonomous system of the client, 0 when unknown
+	ASN uint
+}
+
+// setGeoLabels labels a resource with the country and autonomous system of the requester
+func (r Requester) setGeoLabels(labels map[string]interface{}) {
+	if r.Country != "" {
+		labels[labelCountry] = sanitizeLabel(r.Country)
+	}
+	if r.ASN != 0 {
+		labels[labelASN] = strconv.FormatUint(uint64(r.ASN), 10)
+	}
+}
+
+// IP returns the IP address of the requester
+func (r Requester) IP() string {
+	return requesterFromAddr(r.Addr)
+}
+
+// Options configures a Client
+type Options struct {
+	// Namespace where the resources are managed, every namespace when empty
+	// (only together with NamespaceTemplate or ZoneNamespaces)
+	Namespace string
+	// CustomLabels are added to every DNSEndpoint
+	CustomLabels map[string]string
+	// DynamicRecords writes accepted updates to DynamicRecord resources
+	// which are projected into DNSEndpoints by the record controller
+	DynamicRecords bool
+	// AutoApprove marks new DynamicRecords as approved
+	AutoApprove bool
+	// RecordEvents emits a RecordEvent resource per accepted change
+	RecordEvents bool
+	// EventRetention is how long RecordEvents are kept
+	EventRetention time.Duration
+	// EventsPerRecord caps the number of RecordEvents kept per record
+	EventsPerRecord int
+	// FirstOwnerWins refuses updates of names owned by another source
+	FirstOwnerWins bool
+	// MergeTargets publishes the targets of every source updating a name
+	MergeTargets bool
+	// KeyPriorities ranks key names: names written with a key cannot be
+	// overwritten or deleted with a key of lower priority (0 when not listed)
+	KeyPriorities map[string]int
+	// NamespaceTemplate derives the namespace of each record from its hostname
+	// instead of using Namespace
+	NamespaceTemplate *NamespaceTemplate
+	// ZoneNamespaces are the namespaces of the records of zones, by zone ending
+	// with a dot, taking precedence over NamespaceTemplate and Namespace
+	ZoneNamespaces map[string]string
+	// GroupByRequester aggregates the records of each requester into one DNSEndpoint
+	GroupByRequester bool
+	// SubdomainNames names the resources of the records after their full DNS name,
+	// dots kept, instead of the hyphenated hostname relative to the zone
+	SubdomainNames bool
+	// HashedNames suffixes the hyphenated hostname naming the resources of the
+	// records with a digest of their full DNS name, so that names never collide
+	HashedNames bool
+	// ZonePrefixedNames prefixes the hyphenated hostname naming the resources of
+	// the records with their hyphenated zone
+	ZonePrefixedNames bool
+	// WriteInterval is the minimum interval between two writes of a resource,
+	// later updates being deferred and coalesced (0: no throttling)
+	WriteInterval time.Duration
+	// TTLExpiry is the multiple of their TTL the records of a zone live without
+	// refresh, by zone ending with a dot
+	TTLExpiry map[string]int
+	// RetryAttempts is the number of attempts of a write failing on a conflict,
+	// a timeout or throttling (0 or 1: no retry)
+	RetryAttempts int
+	// RetryBackoff is the wait before the first retry, doubled before each next
+	// one up to RetryMaxBackoff
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// EndpointCache reads the DNSEndpoints from a watch, started by
+	// RunEndpointCache, instead of getting them from the API server
+	EndpointCache bool
+}
+
 // Client manages Kubernetes DNSEndpoint resources
 type Client struct {
-	dynamicClient dynamic.Interface
-	namespace     string
-	gvr           schema.GroupVersionResource
-	customLabels  map[string]string
+	dynamicClient  dynamic.Interface
+	authClient     authorizationv1client.AuthorizationV1Interface
+	authnClient    authenticationv1client.AuthenticationV1Interface
+	namespace      string
+	gvr            schema.GroupVersionResource
+	customLabels   *customLabels
+	dynamicRecords bool
+	autoApprove    bool
+
+	recordEvents    bool
+	eventRetention  time.Duration
+	eventsPerRecord int
+
+	firstOwnerWins bool
+	mergeTargets   bool
+	keyPriorities  map[string]int
+
+	namespaceTemplate *NamespaceTemplate
+	zoneNamespaces    map[string]string
+	groupByRequester  bool
+	subdomainNames    bool
+	hashedNames       bool
+	zonePrefixedNames bool
+
+	ttlExpiry map[string]int
+
+	throttle *writeThrottle
+	retry    retryPolicy
+	locks    *resourceLocks
+
+	endpointCache *endpointCache
 }
 
 // NewClient creates a new Kubernetes client
-func NewClient(namespace string, customLabels map[string]string) (*Client, error) {
+func NewClient(opts Options) (*Client, error) {
 	config, err := getKubeConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
@@ -40,6 +176,31 @@ func NewClient(namespace string, customLabels map[string]string) (*Client, error
 		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
 
+	authClient, err := authorizationv1client.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorization client: %w", err)
+	}
+
+	authnClient, err := authenticationv1client.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authentication client: %w", err)
+	}
+
+	client := newClient(dynamicClient, opts)
+	client.authClient = authClient
+	client.authnClient = authnClient
+	return client, nil
+}
+
+// NewClientForDynamic creates a client on top of an existing dynamic client, such
+// as the fake of k8s.io/client-go/dynamic/fake. Access reviews and token reviews
+// are not available.
+func NewClientForDynamic(dynamicClient dynamic.Interface, opts Options) *Client {
+	return newClient(dynamicClient, opts)
+}
+
+// newClient creates a client on top of an existing dynamic client
+func newClient(dynamicClient dynamic.Interface, opts Options) *Client {
 	// DNSEndpoint CRD from ExternalDNS
 	gvr := schema.GroupVersionResource{
 		Group:    "externaldns.k8s.io",
@@ -47,55 +208,194 @@ func NewClient(namespace string, customLabels map[string]string) (*Client, error
 		Resource: "dnsendpoints",
 	}
 
-	if customLabels == nil {
-		customLabels = map[string]string{}
+	// Key names are compared with the key label of the stored resources
+	keyPriorities := make(map[string]int, len(opts.KeyPriorities))
+	for key, priority := range opts.KeyPriorities {
+		keyPriorities[sanitizeLabel(key)] = priority
+	}
+
+	var endpoints *endpointCache
+	if opts.EndpointCache {
+		endpoints = newEndpointCache(dynamicClient, gvr)
+		dynamicClient = endpoints
 	}
 
 	return &Client{
-		dynamicClient: dynamicClient,
-		namespace:     namespace,
-		gvr:           gvr,
-		customLabels:  customLabels,
-	}, nil
+		dynamicClient:  dynamicClient,
+		namespace:      opts.Namespace,
+		gvr:            gvr,
+		customLabels:   &customLabels{labels: opts.CustomLabels},
+		dynamicRecords: opts.DynamicRecords,
+		autoApprove:    opts.AutoApprove,
+
+		recordEvents:    opts.RecordEvents,
+		eventRetention:  opts.EventRetention,
+		eventsPerRecord: opts.EventsPerRecord,
+
+		firstOwnerWins: opts.FirstOwnerWins,
+		mergeTargets:   opts.MergeTargets,
+		keyPriorities:  keyPriorities,
+
+		namespaceTemplate: opts.NamespaceTemplate,
+		zoneNamespaces:    opts.ZoneNamespaces,
+		groupByRequester:  opts.GroupByRequester,
+		subdomainNames:    opts.SubdomainNames,
+		hashedNames:       opts.HashedNames,
+		zonePrefixedNames: opts.ZonePrefixedNames,
+
+		ttlExpiry: opts.TTLExpiry,
+
+		throttle: newWriteThrottle(opts.WriteInterval),
+		retry:    newRetryPolicy(opts.RetryAttempts, opts.RetryBackoff, opts.RetryMaxBackoff),
+		locks:    newResourceLocks(),
+
+		endpointCache: endpoints,
+	}
 }
 
-// ApplyUpdate applies a DNS update to Kubernetes as a DNSEndpoint resource
-func (c *Client) ApplyUpdate(client net.Addr, upd *update.DNSUpdate) (changed bool, err error) {
+// ApplyUpdate applies a DNS update to Kubernetes as a DNSEndpoint resource,
+// or as a DynamicRecord resource when dynamic records are enabled
+func (c *Client) ApplyUpdate(req Requester, upd *update.DNSUpdate) (changed bool, err error) {
 	ctx := context.Background()
 
-	switch upd.Type {
-	case update.UpdateTypeCreate, update.UpdateTypeUpdate:
-		return c.createOrUpdateEndpoint(ctx, client, upd)
-	case update.UpdateTypeDelete:
-		return true, c.deleteEndpoint(ctx, upd)
-	default:
-		return false, fmt.Errorf("unsupported update type: %v", upd.Type)
+	// Write the record in the namespace derived from its hostname
+	c, err = c.forRecord(upd.Name, upd.Zone)
+	if err != nil {
+		return false, err
+	}
+
+	// Updates of a resource written recently are written later. ACME challenges
+	// are awaited by their client and never deferred.
+	if c.throttle != nil && upd.RecordType != typeTXT && c.throttle.deferWrite(c.throttleKey(req, upd), c, req, upd) {
+		return true, nil
 	}
+	defer c.locks.lock(c.throttleKey(req, upd))()
+	err = c.Retry(func() (err error) {
+		changed, err = c.applyUpdate(ctx, req, upd)
+		return err
+	})
+	return changed, err
 }
 
-// createOrUpdateEndpoint creates or updates a DNSEndpoint resource
-func (c *Client) createOrUpdateEndpoint(ctx context.Context, client net.Addr, upd *update.DNSUpdate) (changed bool, err error) {
-	hostname := upd.GetHostname()
-	resourceName := sanitizeResourceName(hostname)
+// applyUpdate writes a DNS update to the resources of its namespace
+func (c *Client) applyUpdate(ctx context.Context, req Requester, upd *update.DNSUpdate) (changed bool, err error) {
+	// ACME challenges are short-lived: written directly, without approval nor history
+	if upd.RecordType == typeTXT {
+		changed, err = c.applyChallenge(ctx, req, upd)
+		return changed, classifyError(err)
+	}
 
-	recordType := "A"
-	if upd.RecordType == 28 { // dns.TypeAAAA
-		recordType = "AAAA"
+	if upd.RecordType == typeDHCID {
+		changed, err = c.applyDHCID(ctx, upd)
+	} else if c.dynamicRecords {
+		changed, err = c.applyRecord(ctx, req, upd)
+	} else if c.groupByRequester {
+		changed, err = c.applyGroupUpdate(ctx, req, upd)
+	} else {
+		switch upd.Type {
+		case update.UpdateTypeCreate, update.UpdateTypeUpdate:
+			changed, err = c.createOrUpdateEndpoint(ctx, req, upd)
+		case update.UpdateTypeDelete:
+			changed, err = c.deleteEndpoint(ctx, req, upd)
+		default:
+			return false, fmt.Errorf("unsupported update type: %v", upd.Type)
+		}
 	}
 
+	// Keep a history of accepted changes, without failing the update
+	if err == nil && changed && c.recordEvents {
+		if evErr := c.emitEvent(ctx, req, upd); evErr != nil {
+			logrus.Errorf("Failed to emit RecordEvent for %s: %v", upd.Name, evErr)
+		}
+	}
+	return changed, classifyError(err)
+}
+
+// createOrUpdateEndpoint creates or updates a DNSEndpoint resource. A write
+// refused because another update changed or created the resource since it was
+// read fails as a backend conflict, and Retry merges the update again into the
+// resource as written by the other update.
+func (c *Client) createOrUpdateEndpoint(ctx context.Context, req Requester, upd *update.DNSUpdate) (changed bool, err error) {
+	resourceName := c.endpointResourceName(upd)
+
+	target := recordTarget(upd)
+
+	if err := c.checkResourceName(ctx, resourceName, upd.Name); err != nil {
+		return false, err
+	}
+	if c.checksOwnership() {
+		if err := c.checkEndpointOwnership(ctx, resourceName, req); err != nil {
+			return false, err
+		}
+	}
+
+	labels := c.endpointLabels(upd.Zone, req.IP(), req.KeyName)
+	if upd.Unreachable {
+		labels[labelUnreachable] = "true"
+	}
+	req.setGeoLabels(labels)
+	endpoint := c.newEndpoint(resourceName, labels, upd.Name, recordTypeString(upd.RecordType), int64(upd.TTL), []interface{}{
+		target,
+	})
+	now := time.Now()
+	c.setExpiry(endpoint, upd.Name, upd.TTL, now)
+	setUpdateMetadata(endpoint, req, upd, now)
+
+	// A name has a single canonical name: aliases of several sources are not merged
+	if c.mergeTargets && upd.RecordType != typeCNAME {
+		return c.mergeEndpoint(ctx, req, endpoint, target, joinsRRset(upd))
+	}
+	if joinsRRset(upd) {
+		if err := c.joinExistingTargets(ctx, endpoint, target); err != nil {
+			return false, err
+		}
+	}
+	if err := c.keepOtherRRsets(ctx, endpoint); err != nil {
+		return false, err
+	}
+	return c.upsertEndpoint(ctx, endpoint)
+}
+
+// endpointLabels builds the labels of a DNSEndpoint
+func (c *Client) endpointLabels(zone, requester, keyName string) map[string]interface{} {
 	// Build labels map with default labels
 	labels := map[string]interface{}{
-		"app.kubernetes.io/managed-by": "ddnsbridge4extdns",
-		"ddnsbridge4extdns/zone":       sanitizeLabel(upd.Zone),
-		"ddnsbridge4extdns/ask-by":     sanitizeLabel(strings.Split(client.String(), ":")[0]),
+		labelManagedBy: managedByValue,
+		labelZone:      sanitizeLabel(zone),
+		labelAskBy:     sanitizeLabel(requester),
+	}
+	// Track the TSIG key that last refreshed the record
+	if keyName != "" {
+		labels[labelKey] = sanitizeLabel(keyName)
 	}
 
 	// Add custom labels (user-defined labels take precedence)
-	for k, v := range c.customLabels {
+	c.customLabels.mu.RLock()
+	for k, v := range c.customLabels.labels {
 		labels[k] = v
 	}
+	c.customLabels.mu.RUnlock()
+	return labels
+}
+
+// customLabels holds the labels added to every resource, shared by the copies of
+// a client
+type customLabels struct {
+	mu     sync.RWMutex
+	labels map[string]string
+}
+
+// SetCustomLabels replaces the labels added to the resources written from now on.
+// The resources already written keep their labels until their next update.
+func (c *Client) SetCustomLabels(labels map[string]string) {
+	c.customLabels.mu.Lock()
+	defer c.customLabels.mu.Unlock()
+	c.customLabels.labels = labels
+}
 
-	endpoint := &unstructured.Unstructured{
+// newEndpoint builds a DNSEndpoint holding a single endpoint
+func (c *Client) newEndpoint(resourceName string, labels map[string]interface{}, dnsName, recordType string, ttl int64, targets []interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "externaldns.k8s.io/v1alpha1",
 			"kind":       "DNSEndpoint",
@@ -107,29 +407,38 @@ func (c *Client) createOrUpdateEndpoint(ctx context.Context, client net.Addr, up
 			"spec": map[string]interface{}{
 				"endpoints": []interface{}{
 					map[string]interface{}{
-						"dnsName":    upd.Name,
+						"dnsName":    dnsName,
 						"recordType": recordType,
-						"recordTTL":  int64(upd.TTL),
-						"targets": []interface{}{
-							upd.IP.String(),
-						},
+						"recordTTL":  ttl,
+						"targets":    targets,
 					},
 				},
 			},
 		},
 	}
+}
+
+// upsertEndpoint creates the DNSEndpoint or updates it when it differs from the existing one
+func (c *Client) upsertEndpoint(ctx context.Context, endpoint *unstructured.Unstructured) (changed bool, err error) {
+	resourceName := endpoint.GetName()
 
 	// Try to get existing resource
 	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
 	if err == nil {
+		keepExpiry(existing, endpoint)
+		keepUpdateMetadata(existing, endpoint)
 		labelsMatch, specMatch, existingStr, desiredStr := compareEndpoint(existing, endpoint)
-		if labelsMatch && specMatch {
+		if labelsMatch && specMatch && annotationsMatch(existing, endpoint) && reflect.DeepEqual(existing.GetOwnerReferences(), endpoint.GetOwnerReferences()) {
 			logrus.Debugf("DNSEndpoint already exists, skipping update: %s/%s", c.namespace, resourceName)
 			return false, nil
 		}
 
 		logrus.Debugf("DNSEndpoint differs; updating %s/%s\nExisting: %s\nDesired:  %s", c.namespace, resourceName, existingStr, desiredStr)
 		endpoint.SetResourceVersion(existing.GetResourceVersion())
+		// Keep annotations such as the DHCID of the record
+		if endpoint.GetAnnotations() == nil && len(existing.GetAnnotations()) > 0 {
+			endpoint.SetAnnotations(existing.GetAnnotations())
+		}
 		_, err = c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Update(ctx, endpoint, metav1.UpdateOptions{})
 		if err != nil {
 			return false, fmt.Errorf("failed to update DNSEndpoint: %w", err)
@@ -151,22 +460,134 @@ func (c *Client) createOrUpdateEndpoint(ctx context.Context, client net.Addr, up
 	return true, nil
 }
 
-// deleteEndpoint deletes a DNSEndpoint resource
-func (c *Client) deleteEndpoint(ctx context.Context, upd *update.DNSUpdate) error {
-	hostname := upd.GetHostname()
-	resourceName := sanitizeResourceName(hostname)
+// typeANY is the record type of a delete of every RRset of a name (dns.TypeANY)
+const typeANY = 255
+
+// matchedType returns the record type of the entries an update applies to, empty
+// for every type
+func matchedType(upd *update.DNSUpdate) string {
+	if upd.RecordType == typeANY {
+		return ""
+	}
+	return recordTypeString(upd.RecordType)
+}
+
+// deleteEndpoint deletes the records of an update from its DNSEndpoint: a single
+// target, the RRset of its type, or every RRset of the name with typeANY. The
+// targets of the requester are withdrawn instead when the targets of several sources
+// are merged.
+func (c *Client) deleteEndpoint(ctx context.Context, req Requester, upd *update.DNSUpdate) (changed bool, err error) {
+	resourceName := c.endpointResourceName(upd)
 
-	err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Delete(ctx, resourceName, metav1.DeleteOptions{})
+	// The resource of another name is not the one of this name
+	if err := c.checkResourceName(ctx, resourceName, upd.Name); err != nil {
+		if errors.Is(err, dnserr.ErrNameCollision) {
+			return false, nil
+		}
+		return false, err
+	}
+	if c.checksOwnership() {
+		if err := c.checkEndpointOwnership(ctx, resourceName, req); err != nil {
+			return false, err
+		}
+	}
+	if c.mergeTargets && upd.RecordType != typeCNAME {
+		return c.unmergeEndpoint(ctx, req, resourceName, upd)
+	}
+
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+	if upd.RecordType != typeANY {
+		existing, err := endpoints.Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			if isNotFoundError(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to get DNSEndpoint: %w", err)
+		}
+		// The RRsets of the other types of the name are left untouched
+		recordType := recordTypeString(upd.RecordType)
+		if _, ok := typeTargets(existing, recordType); !ok {
+			logrus.Debugf("DNSEndpoint %s/%s holds no %s record, nothing to delete", c.namespace, resourceName, recordType)
+			return false, nil
+		}
+		if target := recordTarget(upd); target != "" {
+			return c.removeEndpointTarget(ctx, req, upd, existing, recordType, target)
+		}
+		updated, err := withoutRRset(existing, recordType)
+		if err != nil {
+			return false, err
+		}
+		if updated != nil {
+			setUpdateMetadata(updated, req, upd, time.Now())
+			if _, err := endpoints.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+				return false, fmt.Errorf("failed to update DNSEndpoint: %w", err)
+			}
+			logrus.Infof("Removed the %s records from DNSEndpoint %s/%s", recordType, c.namespace, resourceName)
+			return true, nil
+		}
+	}
+
+	err = endpoints.Delete(ctx, resourceName, metav1.DeleteOptions{})
 	if err != nil {
 		// Ignore not found errors
 		if !isNotFoundError(err) {
-			return fmt.Errorf("failed to delete DNSEndpoint: %w", err)
+			return false, fmt.Errorf("failed to delete DNSEndpoint: %w", err)
 		}
 	} else {
 		logrus.Infof("Successfully deleted DNSEndpoint %s/%s", c.namespace, resourceName)
 	}
 
-	return nil
+	return true, nil
+}
+
+// removeEndpointTarget removes a single target from the RRset of a record type of
+// a DNSEndpoint, removing the RRset once no target is left, and the DNSEndpoint
+// once no RRset is left
+func (c *Client) removeEndpointTarget(ctx context.Context, req Requester, upd *update.DNSUpdate, existing *unstructured.Unstructured, recordType, target string) (changed bool, err error) {
+	entries, _, _ := unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	i := entryOfType(entries, recordType)
+	if i < 0 {
+		return false, nil
+	}
+	entry, _ := entries[i].(map[string]interface{})
+	targets, _, _ := unstructured.NestedStringSlice(entry, "targets")
+	if !containsString(targets, target) {
+		logrus.Debugf("DNSEndpoint %s/%s has no target %s, nothing to delete", c.namespace, existing.GetName(), target)
+		return false, nil
+	}
+
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+	remaining := make([]interface{}, 0, len(targets)-1)
+	for _, t := range targets {
+		if t != target {
+			remaining = append(remaining, t)
+		}
+	}
+	updated := existing.DeepCopy()
+	if len(remaining) == 0 {
+		if updated, err = withoutRRset(existing, recordType); err != nil {
+			return false, err
+		}
+	} else {
+		entry["targets"] = remaining
+		if err := unstructured.SetNestedSlice(updated.Object, entries, "spec", "endpoints"); err != nil {
+			return false, fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+		}
+	}
+	if updated == nil {
+		if err := endpoints.Delete(ctx, existing.GetName(), metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return false, fmt.Errorf("failed to delete DNSEndpoint: %w", err)
+		}
+		logrus.Infof("Successfully deleted DNSEndpoint %s/%s", c.namespace, existing.GetName())
+		return true, nil
+	}
+
+	setUpdateMetadata(updated, req, upd, time.Now())
+	if _, err := endpoints.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to update DNSEndpoint: %w", err)
+	}
+	logrus.Infof("Removed target %s from DNSEndpoint %s/%s", target, c.namespace, existing.GetName())
+	return true, nil
 }
 
 // getKubeConfig returns the Kubernetes configuration
@@ -190,6 +611,67 @@ func getKubeConfig() (*rest.Config, error) {
 	return nil, fmt.Errorf("no kubeconfig found (in-cluster, KUBECONFIG); last error: %w", cfgErr)
 }
 
+// endpointResourceName returns the name of the DNSEndpoint of an update. Reverse names
+// keep their full name so PTR records never collide with forward names.
+func (c *Client) endpointResourceName(upd *update.DNSUpdate) string {
+	if isReverseName(upd.Name) && !c.subdomainNames && !c.hashedNames && !c.zonePrefixedNames {
+		return sanitizeResourceName(upd.Name)
+	}
+	return c.hostResourceName(upd)
+}
+
+// hostResourceName returns the name of the DynamicRecord or ACME challenge of an
+// update, named after its hostname relative to the zone, or after the zone itself
+// for the apex
+func (c *Client) hostResourceName(upd *update.DNSUpdate) string {
+	switch {
+	case c.subdomainNames:
+		return subdomainResourceName(upd.Name)
+	case c.hashedNames:
+		return hashedResourceName(upd)
+	case c.zonePrefixedNames:
+		return zonePrefixedResourceName(upd)
+	}
+	return sanitizeResourceName(relativeName(upd))
+}
+
+// subdomainResourceName converts a DNS name to a resource name keeping its dots, a
+// valid DNS subdomain name (RFC 1123): "Host.Example.com." becomes "host.example.com".
+// Characters other than letters, digits and hyphens become hyphens, and labels not
+// starting or ending with a letter or digit get a "dns" prefix or suffix.
+func subdomainResourceName(dnsName string) string {
+	dnsName = strings.TrimSuffix(dnsName, ".")
+	if dnsName == "" {
+		return ""
+	}
+	labels := strings.Split(strings.ToLower(dnsName), ".")
+	for i, label := range labels {
+		converted := make([]rune, 0, len(label))
+		for _, r := range label {
+			if isAlphanumericLower(r) {
+				converted = append(converted, r)
+			} else {
+				converted = append(converted, '-')
+			}
+		}
+		label = string(converted)
+		if label == "" || !isAlphanumericLower(rune(label[0])) {
+			label = "dns" + label
+		}
+		if !isAlphanumericLower(rune(label[len(label)-1])) {
+			label += "dns"
+		}
+		labels[i] = label
+	}
+	name := strings.Join(labels, ".")
+
+	// Truncate to 253 characters (Kubernetes limit), ending with a letter or digit
+	if len(name) > 253 {
+		name = strings.TrimRight(name[:253], ".-")
+	}
+	return name
+}
+
 // sanitizeResourceName converts a hostname to a valid Kubernetes resource name
 func sanitizeResourceName(hostname string) string {
 	// Remove trailing dots and replace dots with hyphens
@@ -243,11 +725,65 @@ func dnsNameToK8sName(name string) string {
 	return string(result)
 }
 
+// requesterFromAddr returns the IP address of a requester
+func requesterFromAddr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return strings.Split(addr.String(), ":")[0]
+}
+
+// recordTypeString returns the DNSEndpoint recordType of a DNS record type
+func recordTypeString(rrtype uint16) string {
+	switch rrtype {
+	case typeAAAA:
+		return "AAAA"
+	case typeTXT:
+		return "TXT"
+	case typePTR:
+		return "PTR"
+	case typeDHCID:
+		return "DHCID"
+	case typeCNAME:
+		return "CNAME"
+	case typeSRV:
+		return "SRV"
+	case typeMX:
+		return "MX"
+	case typeNS:
+		return "NS"
+	case typeANY:
+		return "ANY"
+	}
+	return "A"
+}
+
 // isAlphanumericLower checks if a rune is alphanumeric
 func isAlphanumericLower(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
 }
 
+// classifyError tags Kubernetes errors with the backend error they are answered with
+func classifyError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsConflict(err), apierrors.IsAlreadyExists(err):
+		return fmt.Errorf("%w: %w", dnserr.ErrBackendConflict, err)
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsServiceUnavailable(err), errors.Is(err, context.DeadlineExceeded), isNetworkError(err):
+		return fmt.Errorf("%w: %w", dnserr.ErrBackendUnavailable, err)
+	}
+	return err
+}
+
+// isNetworkError checks if a request did not reach the API server, for example
+// while it restarts
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 // isNotFoundError checks if an error is a not found error
 func isNotFoundError(err error) bool {
 	return apierrors.IsNotFound(err)
@@ -1,10 +1,26 @@
 package k8s
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
 	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestSanitizeResourceName(t *testing.T) {
@@ -30,6 +46,35 @@ func TestSanitizeResourceName(t *testing.T) {
 	}
 }
 
+func TestSubdomainResourceName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Host.Example.com.", "host.example.com"},
+		{"_acme-challenge.host.example.com", "dns-acme-challenge.host.example.com"},
+		{"test_host.example.com", "test-host.example.com"},
+		{"host-.example.com", "host-dns.example.com"},
+		{"1.1.168.192.in-addr.arpa.", "1.1.168.192.in-addr.arpa"},
+		{"", ""},
+		{strings.Repeat("a.", 150) + "com", strings.Repeat("a.", 126) + "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result := subdomainResourceName(tt.input)
+			if result != tt.expected {
+				t.Errorf("subdomainResourceName(%s) = %s, want %s", tt.input, result, tt.expected)
+			}
+			if result != "" {
+				if errs := validation.IsDNS1123Subdomain(result); len(errs) > 0 {
+					t.Errorf("subdomainResourceName(%s) = %s is not a subdomain name: %v", tt.input, result, errs)
+				}
+			}
+		})
+	}
+}
+
 func TestSanitizeLabel(t *testing.T) {
 	tests := []struct {
 		input       string
@@ -150,3 +195,141 @@ func TestUpdateTypeHandling(t *testing.T) {
 		})
 	}
 }
+
+func TestClassifyError(t *testing.T) {
+	resource := schema.GroupResource{Group: "externaldns.k8s.io", Resource: "dnsendpoints"}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"conflict", apierrors.NewConflict(resource, "test", errors.New("modified")), dnserr.ErrBackendConflict},
+		{"already exists", apierrors.NewAlreadyExists(resource, "test"), dnserr.ErrBackendConflict},
+		{"unavailable", apierrors.NewServiceUnavailable("down"), dnserr.ErrBackendUnavailable},
+		{"throttled", apierrors.NewTooManyRequests("slow down", 1), dnserr.ErrBackendUnavailable},
+		{"unreachable", fmt.Errorf("failed to get DNSEndpoint: %w", &url.Error{Op: "Get", URL: "https://10.96.0.1/apis", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}), dnserr.ErrBackendUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(tt.err)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("classifyError() = %v, want %v", err, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected the Kubernetes error to be kept, got %v", err)
+			}
+		})
+	}
+
+	if err := classifyError(apierrors.NewForbidden(resource, "test", errors.New("denied"))); errors.Is(err, dnserr.ErrBackendConflict) || errors.Is(err, dnserr.ErrBackendUnavailable) {
+		t.Errorf("Expected forbidden to stay unclassified, got %v", err)
+	}
+}
+
+func TestDeleteSemantics(t *testing.T) {
+	// deleteRR returns the delete of a record type, with the data of a single record
+	deleteRR := func(rrtype uint16, ip string) *update.DNSUpdate {
+		upd := testUpdate(update.UpdateTypeDelete, ip)
+		upd.RecordType = rrtype
+		return upd
+	}
+	tests := []struct {
+		name    string
+		upd     *update.DNSUpdate
+		deleted bool
+	}{
+		{"RRset of another type", deleteRR(dns.TypeAAAA, ""), false},
+		{"record of another target", deleteRR(dns.TypeA, "192.0.2.99"), false},
+		{"record", deleteRR(dns.TypeA, "192.0.2.10"), true},
+		{"RRset", deleteRR(dns.TypeA, ""), true},
+		{"every RRset", deleteRR(dns.TypeANY, ""), true},
+	}
+
+	for _, opts := range []Options{{}, {DynamicRecords: true}} {
+		for _, tt := range tests {
+			client := newFakeClient(opts)
+			if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+				t.Fatalf("ApplyUpdate() failed: %v", err)
+			}
+			changed, err := client.ApplyUpdate(routerA, tt.upd)
+			if err != nil {
+				t.Fatalf("%s: ApplyUpdate() failed: %v", tt.name, err)
+			}
+			gvr := endpointGVR
+			if opts.DynamicRecords {
+				gvr = recordGVR
+			}
+			_, err = client.dynamicClient.Resource(gvr).Namespace("default").Get(context.Background(), "test", metav1.GetOptions{})
+			if deleted := apierrors.IsNotFound(err); deleted != tt.deleted || changed != tt.deleted {
+				t.Errorf("%+v %s: expected deleted %v, got deleted %v and changed %v", opts, tt.name, tt.deleted, deleted, changed)
+			}
+		}
+	}
+}
+
+func TestSetCustomLabels(t *testing.T) {
+	client := newFakeClient(Options{CustomLabels: map[string]string{"team": "network"}})
+	staged := *client
+
+	if labels := client.endpointLabels("example.com.", "192.0.2.1", ""); labels["team"] != "network" {
+		t.Errorf("Expected the configured label, got %v", labels)
+	}
+	client.SetCustomLabels(map[string]string{"owner": "dhcp"})
+	labels := staged.endpointLabels("example.com.", "192.0.2.1", "")
+	if _, ok := labels["team"]; ok || labels["owner"] != "dhcp" {
+		t.Errorf("Expected copies of the client to use the replaced labels, got %v", labels)
+	}
+}
+
+func TestUpdateConflictMergedAgain(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}}
+	client := newFakeClient(Options{RetryAttempts: 3})
+	retries := 0
+	client.retry.sleep = func(time.Duration) { retries++ }
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	// Another update adds a target between the read and the write of the next one
+	fakeClient := client.dynamicClient.(*fake.FakeDynamicClient)
+	conflicts := 0
+	fakeClient.PrependReactor("update", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		obj, err := fakeClient.Tracker().Get(endpointGVR, "default", "test")
+		if err != nil {
+			t.Fatalf("Get() failed: %v", err)
+		}
+		concurrent := obj.(*unstructured.Unstructured).DeepCopy()
+		endpoints, _, _ := unstructured.NestedSlice(concurrent.Object, "spec", "endpoints")
+		endpoints[0].(map[string]interface{})["targets"] = []interface{}{"192.0.2.10", "192.0.2.30"}
+		unstructured.SetNestedSlice(concurrent.Object, endpoints, "spec", "endpoints")
+		concurrent.SetResourceVersion("2")
+		if err := fakeClient.Tracker().Update(endpointGVR, concurrent, "default"); err != nil {
+			t.Fatalf("Update() failed: %v", err)
+		}
+		return true, nil, apierrors.NewConflict(endpointGVR.GroupResource(), "test", errors.New("object was modified"))
+	})
+
+	changed, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.0.2.20"))
+	if err != nil || !changed {
+		t.Fatalf("Expected the conflicting update to be merged again, got %v, %v", changed, err)
+	}
+	obj, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	targets := endpoints[0].(map[string]interface{})["targets"].([]interface{})
+	if len(targets) != 3 {
+		t.Errorf("Expected the targets of both updates, got %v", targets)
+	}
+	if retries != 1 {
+		t.Errorf("Expected the backend retries to merge the update again once, got %d retries", retries)
+	}
+}
@@ -0,0 +1,21 @@
+package k8s
+
+import "github.com/tJouve/ddnsbridge4extdns/pkg/update"
+
+// typeCNAME is the DNS record type of aliases (dns.TypeCNAME)
+const typeCNAME = 5
+
+// recordTarget returns the DNSEndpoint target of an update: its address, the
+// target of a PTR, CNAME or NS record, or the data of an SRV or MX record
+func recordTarget(upd *update.DNSUpdate) string {
+	if upd.IP != nil {
+		return upd.IP.String()
+	}
+	switch upd.RecordType {
+	case typeSRV:
+		return srvTarget(upd)
+	case typeMX:
+		return mxTarget(upd)
+	}
+	return upd.Target
+}
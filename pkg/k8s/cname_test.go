@@ -0,0 +1,74 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// cnameUpdate returns an update of the CNAME of the test name
+func cnameUpdate(updateType update.UpdateType, target string) *update.DNSUpdate {
+	return &update.DNSUpdate{
+		Type:       updateType,
+		RecordType: dns.TypeCNAME,
+		Name:       "test.example.com.",
+		Zone:       "example.com.",
+		Target:     target,
+		TTL:        300,
+	}
+}
+
+func TestApplyCNAMEUpdates(t *testing.T) {
+	for _, opts := range []Options{{}, {MergeTargets: true}, {GroupByRequester: true}} {
+		client := newFakeClient(opts)
+		for _, target := range []string{"home.dyn.example.net.", "office.dyn.example.net."} {
+			if _, err := client.ApplyUpdate(routerA, cnameUpdate(update.UpdateTypeCreate, target)); err != nil {
+				t.Fatalf("ApplyUpdate() failed: %v", err)
+			}
+		}
+		// Another source replaces the alias, even when merging targets
+		if _, err := client.ApplyUpdate(routerB, cnameUpdate(update.UpdateTypeCreate, "lab.dyn.example.net.")); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+
+		rrsets, err := client.LookupRRsets("test.example.com.", "example.com.")
+		if err != nil {
+			t.Fatalf("LookupRRsets() failed: %v", err)
+		}
+		expected := map[uint16][]string{dns.TypeCNAME: {"lab.dyn.example.net."}}
+		if !reflect.DeepEqual(rrsets, expected) {
+			t.Errorf("%+v: expected %v, got %v", opts, expected, rrsets)
+		}
+		if opts.GroupByRequester {
+			// The groups are not named after the record
+			continue
+		}
+
+		if _, err := client.ApplyUpdate(routerB, cnameUpdate(update.UpdateTypeDelete, "")); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+		if targets := endpointTargets(t, client); targets != nil {
+			t.Errorf("%+v: expected the alias to be deleted, got %v", opts, targets)
+		}
+	}
+}
+
+func TestApplyCNAMERecord(t *testing.T) {
+	client := newFakeClient(Options{DynamicRecords: true})
+	if _, err := client.ApplyUpdate(routerA, cnameUpdate(update.UpdateTypeCreate, "home.dyn.example.net.")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(context.Background(), "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DynamicRecord not created: %v", err)
+	}
+	targets, ok := typeTargets(record, "CNAME")
+	if !ok || !reflect.DeepEqual(targets, []string{"home.dyn.example.net."}) {
+		t.Errorf("Expected a CNAME to home.dyn.example.net., got %v", recordEntries(record))
+	}
+}
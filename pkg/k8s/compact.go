@@ -0,0 +1,200 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// RunCompactor merges fragmented DNSEndpoints every interval until ctx is done
+func (c *Client) RunCompactor(ctx context.Context, interval time.Duration, zones []string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.CompactEndpoints(ctx, zones); err != nil {
+			logrus.Errorf("Failed to compact DNSEndpoints: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CompactEndpoints merges managed DNSEndpoints holding the same dnsName under
+// another resource name into the canonical DNSEndpoint of that name, and returns
+// the names of the removed fragments. DNSEndpoints owned by another resource are left alone.
+func (c *Client) CompactEndpoints(ctx context.Context, zones []string) ([]string, error) {
+	return c.compactEndpoints(ctx, zones, false)
+}
+
+// compactEndpoints compacts the DNSEndpoints; with dryRun it only returns the fragments
+func (c *Client) compactEndpoints(ctx context.Context, zones []string, dryRun bool) ([]string, error) {
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := endpoints.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNSEndpoints: %w", err)
+	}
+
+	// Group the resources by the canonical name of the dnsName they hold
+	byName := make(map[string]*unstructured.Unstructured)
+	groups := make(map[string][]*unstructured.Unstructured)
+	for i := range list.Items {
+		item := &list.Items[i]
+		byName[item.GetName()] = item
+		if len(item.GetOwnerReferences()) > 0 {
+			continue
+		}
+		dnsName, ok := singleDNSName(item)
+		if !ok {
+			logrus.Debugf("Skipping compaction of DNSEndpoint %s/%s holding several names", c.namespace, item.GetName())
+			continue
+		}
+		canonical := c.canonicalResourceName(dnsName, zones)
+		if canonical == "" {
+			continue
+		}
+		groups[canonical] = append(groups[canonical], item)
+	}
+
+	removed := make([]string, 0)
+	for canonical, items := range groups {
+		fragments := make([]*unstructured.Unstructured, 0, len(items))
+		for _, item := range items {
+			if item.GetName() != canonical {
+				fragments = append(fragments, item)
+			}
+		}
+		if len(fragments) == 0 {
+			continue
+		}
+
+		existing := byName[canonical]
+		if existing != nil && len(existing.GetOwnerReferences()) > 0 {
+			logrus.Warnf("Skipping compaction into DNSEndpoint %s/%s owned by another resource", c.namespace, canonical)
+			continue
+		}
+
+		if dryRun {
+			for _, fragment := range fragments {
+				removed = append(removed, fragment.GetName())
+			}
+			continue
+		}
+		if err := c.mergeFragments(ctx, canonical, existing, fragments); err != nil {
+			return removed, err
+		}
+		for _, fragment := range fragments {
+			if err := endpoints.Delete(ctx, fragment.GetName(), metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+				return removed, fmt.Errorf("failed to delete DNSEndpoint %s: %w", fragment.GetName(), err)
+			}
+			logrus.Infof("Compacted DNSEndpoint %s/%s into %s", c.namespace, fragment.GetName(), canonical)
+			removed = append(removed, fragment.GetName())
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// mergeFragments writes the endpoints of all fragments into the canonical DNSEndpoint.
+// Entries already present in the canonical resource win, then the most recent fragments.
+func (c *Client) mergeFragments(ctx context.Context, canonical string, existing *unstructured.Unstructured, fragments []*unstructured.Unstructured) error {
+	sort.Slice(fragments, func(i, j int) bool {
+		return fragments[j].GetCreationTimestamp().Time.Before(fragments[i].GetCreationTimestamp().Time)
+	})
+
+	sources := fragments
+	resourceLabels := getLabels(fragments[0])
+	if existing != nil {
+		sources = append([]*unstructured.Unstructured{existing}, fragments...)
+		resourceLabels = getLabels(existing)
+	}
+
+	// Keep one entry per record type
+	merged := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		entries, _, _ := unstructured.NestedSlice(source.Object, "spec", "endpoints")
+		for _, entry := range entries {
+			fields, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			recordType, _ := fields["recordType"].(string)
+			if seen[recordType] {
+				continue
+			}
+			seen[recordType] = true
+			merged = append(merged, entry)
+		}
+	}
+
+	endpoint := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "externaldns.k8s.io/v1alpha1",
+			"kind":       "DNSEndpoint",
+			"metadata": map[string]interface{}{
+				"name":      canonical,
+				"namespace": c.namespace,
+				"labels":    resourceLabels,
+			},
+			"spec": map[string]interface{}{
+				"endpoints": merged,
+			},
+		},
+	}
+	_, err := c.upsertEndpoint(ctx, endpoint)
+	return err
+}
+
+// singleDNSName returns the dnsName of a DNSEndpoint whose endpoints all share one name
+func singleDNSName(u *unstructured.Unstructured) (string, bool) {
+	entries, _, _ := unstructured.NestedSlice(u.Object, "spec", "endpoints")
+	dnsName := ""
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		name, _ := fields["dnsName"].(string)
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name == "" || (dnsName != "" && name != dnsName) {
+			return "", false
+		}
+		dnsName = name
+	}
+	return dnsName, dnsName != ""
+}
+
+// canonicalResourceName returns the resource name an update of dnsName is written to,
+// relative to the longest matching zone, or an empty string when no zone matches
+func (c *Client) canonicalResourceName(dnsName string, zones []string) string {
+	zone := longestZone(dnsName, zones)
+	if zone == "" && !isReverseName(dnsName) {
+		return ""
+	}
+	return c.endpointResourceName(&update.DNSUpdate{Name: dnsName, Zone: zone})
+}
+
+// longestZone returns the longest zone dnsName belongs to, or an empty string
+func longestZone(dnsName string, zones []string) string {
+	zone := ""
+	for _, z := range zones {
+		z = strings.ToLower(strings.TrimSuffix(z, "."))
+		if (dnsName == z || strings.HasSuffix(dnsName, "."+z)) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	return zone
+}
@@ -0,0 +1,112 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCanonicalResourceName(t *testing.T) {
+	zones := []string{"example.com", "lan.example.com."}
+
+	tests := []struct {
+		dnsName   string
+		subdomain bool
+		expected  string
+	}{
+		{"host.example.com", false, "host"},
+		{"host.lan.example.com", false, "host"},
+		{"a.b.example.com", false, "a-b"},
+		{"host.example.org", false, ""},
+		{"1.1.168.192.in-addr.arpa", false, "1-1-168-192-in-addr-arpa"},
+		{"host.lan.example.com", true, "host.lan.example.com"},
+		{"host.example.org", true, ""},
+		{"1.1.168.192.in-addr.arpa", true, "1.1.168.192.in-addr.arpa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dnsName, func(t *testing.T) {
+			client := newFakeClient(Options{SubdomainNames: tt.subdomain})
+			if got := client.canonicalResourceName(tt.dnsName, zones); got != tt.expected {
+				t.Errorf("canonicalResourceName(%q) = %q, expected %q", tt.dnsName, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompactEndpointsToSubdomainNames(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]interface{}{labelManagedBy: managedByValue}
+	builder := newFakeClient(Options{})
+	hyphenated := builder.newEndpoint("host", labels, "host.example.com.", "A", 300, []interface{}{"192.168.1.1"})
+	client := newFakeClient(Options{SubdomainNames: true}, hyphenated)
+
+	removed, err := client.CompactEndpoints(ctx, []string{"example.com"})
+	if err != nil {
+		t.Fatalf("CompactEndpoints() failed: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"host"}) {
+		t.Errorf("Expected removed [host], got %v", removed)
+	}
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "host.example.com", metav1.GetOptions{}); err != nil {
+		t.Errorf("DNSEndpoint not renamed to host.example.com: %v", err)
+	}
+}
+
+func TestCompactEndpoints(t *testing.T) {
+	ctx := context.Background()
+	labels := map[string]interface{}{labelManagedBy: managedByValue}
+	builder := newFakeClient(Options{})
+
+	objects := []*unstructured.Unstructured{
+		// Fragments of host.example.com from an older naming strategy
+		builder.newEndpoint("host-example-com-a", labels, "host.example.com.", "A", 300, []interface{}{"192.168.1.1"}),
+		builder.newEndpoint("host-example-com-aaaa", labels, "host.example.com.", "AAAA", 300, []interface{}{"fd00::1"}),
+		// Already canonical
+		builder.newEndpoint("other", labels, "other.example.com.", "A", 300, []interface{}{"192.168.1.2"}),
+		// Outside of the allowed zones
+		builder.newEndpoint("foreign-example-org", labels, "foreign.example.org.", "A", 300, []interface{}{"192.168.1.3"}),
+	}
+	runtimeObjects := make([]runtime.Object, 0, len(objects))
+	for _, obj := range objects {
+		runtimeObjects = append(runtimeObjects, obj)
+	}
+	client := newFakeClient(Options{}, runtimeObjects...)
+
+	removed, err := client.CompactEndpoints(ctx, []string{"example.com"})
+	if err != nil {
+		t.Fatalf("CompactEndpoints() failed: %v", err)
+	}
+	expected := []string{"host-example-com-a", "host-example-com-aaaa"}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected removed %v, got %v", expected, removed)
+	}
+
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "host", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Canonical DNSEndpoint not created: %v", err)
+	}
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 merged endpoints, got %d", len(entries))
+	}
+
+	for _, name := range []string{"other", "foreign-example-org"} {
+		if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, name, metav1.GetOptions{}); err != nil {
+			t.Errorf("Expected DNSEndpoint %s to be kept: %v", name, err)
+		}
+	}
+
+	// A second run has nothing left to compact
+	removed, err = client.CompactEndpoints(ctx, []string{"example.com"})
+	if err != nil {
+		t.Fatalf("CompactEndpoints() failed: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("Expected nothing to compact, got %v", removed)
+	}
+}
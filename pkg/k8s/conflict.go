@@ -0,0 +1,293 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// annotationSources stores the targets of each source of the RRsets of a merged
+// DNSEndpoint, by record type
+const annotationSources = "ddnsbridge4extdns/sources"
+
+// source identifies the requester as stored in the ownership labels
+func (r Requester) source() string {
+	return sanitizeLabel(r.IP()) + "/" + sanitizeLabel(r.KeyName)
+}
+
+// ownerSource returns the source that last wrote a resource, empty when not tracked
+func ownerSource(obj *unstructured.Unstructured) string {
+	labels := obj.GetLabels()
+	askBy, ok := labels[labelAskBy]
+	if !ok {
+		return ""
+	}
+	return askBy + "/" + labels[labelKey]
+}
+
+// checkOwner refuses the update of a resource owned by another source
+func checkOwner(obj *unstructured.Unstructured, req Requester) error {
+	owner := ownerSource(obj)
+	if owner == "" || owner == req.source() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is owned by %s", dnserr.ErrNameOwned, obj.GetName(), owner)
+}
+
+// checkPriority refuses the update of a resource written with a key of higher priority
+func (c *Client) checkPriority(obj *unstructured.Unstructured, req Requester) error {
+	ownerKey, ok := obj.GetLabels()[labelKey]
+	if !ok {
+		return nil
+	}
+	if c.keyPriorities[ownerKey] > c.keyPriorities[sanitizeLabel(req.KeyName)] {
+		return fmt.Errorf("%w: %s was written with key %s", dnserr.ErrKeyOutranked, obj.GetName(), ownerKey)
+	}
+	return nil
+}
+
+// checksOwnership reports whether updates are checked against the stored owner
+func (c *Client) checksOwnership() bool {
+	return c.firstOwnerWins || len(c.keyPriorities) > 0
+}
+
+// checkOwnership refuses the update of a resource the requester may not overwrite
+func (c *Client) checkOwnership(obj *unstructured.Unstructured, req Requester) error {
+	if c.firstOwnerWins {
+		if err := checkOwner(obj, req); err != nil {
+			return err
+		}
+	}
+	return c.checkPriority(obj, req)
+}
+
+// checkEndpointOwnership refuses the update of a DNSEndpoint the requester may not overwrite
+func (c *Client) checkEndpointOwnership(ctx context.Context, resourceName string, req Requester) error {
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+	return c.checkOwnership(existing, req)
+}
+
+// mergeEndpoint publishes the target of the requester next to the targets of the other
+// sources of the name, added to the targets the requester published before with join,
+// replacing them otherwise
+func (c *Client) mergeEndpoint(ctx context.Context, req Requester, endpoint *unstructured.Unstructured, target string, join bool) (changed bool, err error) {
+	recordType := singleRecordType(endpoint)
+	all := map[string]map[string][]string{}
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, endpoint.GetName(), metav1.GetOptions{})
+	if err == nil {
+		all = allEndpointSources(existing)
+		annotations := existing.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for k, v := range endpoint.GetAnnotations() {
+			annotations[k] = v
+		}
+		endpoint.SetAnnotations(annotations)
+		if err := withOtherRRsets(existing, endpoint); err != nil {
+			return false, err
+		}
+	} else if !isNotFoundError(err) {
+		return false, fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+
+	sources := all[recordType]
+	if sources == nil {
+		sources = map[string][]string{}
+	}
+	source := req.source()
+	if !join {
+		sources[source] = nil
+	}
+	if !containsString(sources[source], target) {
+		sources[source] = append(sources[source], target)
+	}
+	all[recordType] = sources
+	if err := setEndpointSources(endpoint, all); err != nil {
+		return false, err
+	}
+	return c.upsertEndpoint(ctx, endpoint)
+}
+
+// unmergeEndpoint withdraws the targets of the requester from a merged DNSEndpoint,
+// deleting it once no source is left
+func (c *Client) unmergeEndpoint(ctx context.Context, req Requester, resourceName string, upd *update.DNSUpdate) (changed bool, err error) {
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+	existing, err := endpoints.Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+
+	// The RRsets of the other types of the name are left untouched
+	all := allEndpointSources(existing)
+	recordTypes := []string{recordTypeString(upd.RecordType)}
+	if upd.RecordType == typeANY {
+		recordTypes = recordTypes[:0]
+		for recordType := range all {
+			recordTypes = append(recordTypes, recordType)
+		}
+	} else if _, ok := all[recordTypes[0]]; !ok {
+		return false, nil
+	}
+
+	source := req.source()
+	left := 0
+	for _, recordType := range recordTypes {
+		sources := all[recordType]
+		if deleted := recordTarget(upd); deleted != "" {
+			// Only the given target is withdrawn
+			remaining := make([]string, 0, len(sources[source]))
+			for _, target := range sources[source] {
+				if target != deleted {
+					remaining = append(remaining, target)
+				}
+			}
+			sources[source] = remaining
+		} else {
+			sources[source] = nil
+		}
+		if len(sources[source]) == 0 {
+			delete(sources, source)
+		}
+	}
+	for _, sources := range all {
+		left += len(sources)
+	}
+
+	if left == 0 {
+		if err := endpoints.Delete(ctx, resourceName, metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return false, fmt.Errorf("failed to delete DNSEndpoint: %w", err)
+		}
+		logrus.Infof("Successfully deleted DNSEndpoint %s/%s", c.namespace, resourceName)
+		return true, nil
+	}
+
+	updated := existing.DeepCopy()
+	if err := setEndpointSources(updated, all); err != nil {
+		return false, err
+	}
+	logrus.Infof("Withdrew the targets of %s from DNSEndpoint %s/%s", source, c.namespace, resourceName)
+	return c.upsertEndpoint(ctx, updated)
+}
+
+// allEndpointSources returns the targets of each source of each RRset of a DNSEndpoint,
+// by record type. Targets published before merging are attributed to the source that
+// last wrote the endpoint, and sources stored before the RRsets of a name shared its
+// DNSEndpoint to its single RRset.
+func allEndpointSources(endpoint *unstructured.Unstructured) map[string]map[string][]string {
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	all := map[string]map[string][]string{}
+	if value, ok := endpoint.GetAnnotations()[annotationSources]; ok {
+		var single map[string][]string
+		if err := json.Unmarshal([]byte(value), &all); err == nil {
+			// Sources of RRsets since removed are dropped
+			for recordType := range all {
+				if entryOfType(entries, recordType) < 0 {
+					delete(all, recordType)
+				}
+			}
+			return all
+		} else if err := json.Unmarshal([]byte(value), &single); err == nil {
+			return map[string]map[string][]string{singleRecordType(endpoint): single}
+		}
+		logrus.Warnf("Ignoring invalid %s annotation on DNSEndpoint %s", annotationSources, endpoint.GetName())
+		all = map[string]map[string][]string{}
+	}
+
+	owner := ownerSource(endpoint)
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		recordType, _ := entry["recordType"].(string)
+		targets, _, _ := unstructured.NestedStringSlice(entry, "targets")
+		if all[recordType] == nil {
+			all[recordType] = map[string][]string{}
+		}
+		all[recordType][owner] = append(all[recordType][owner], targets...)
+	}
+	return all
+}
+
+// setEndpointSources stores the sources of the RRsets of a DNSEndpoint and publishes the
+// union of their targets. RRsets left without a source are removed.
+func setEndpointSources(endpoint *unstructured.Unstructured, all map[string]map[string][]string) error {
+	endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	kept := make([]interface{}, 0, len(endpoints))
+	stored := map[string]map[string][]string{}
+	for _, e := range endpoints {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("DNSEndpoint %s has an invalid endpoint", endpoint.GetName())
+		}
+		recordType, _ := entry["recordType"].(string)
+		sources := all[recordType]
+		if len(sources) == 0 {
+			continue
+		}
+		stored[recordType] = sources
+
+		seen := map[string]bool{}
+		var targets []string
+		for _, sourceTargets := range sources {
+			for _, target := range sourceTargets {
+				if !seen[target] {
+					seen[target] = true
+					targets = append(targets, target)
+				}
+			}
+		}
+		sort.Strings(targets)
+		merged := make([]interface{}, 0, len(targets))
+		for _, target := range targets {
+			merged = append(merged, target)
+		}
+		entry["targets"] = merged
+		kept = append(kept, entry)
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("DNSEndpoint %s has no endpoint", endpoint.GetName())
+	}
+
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode sources: %w", err)
+	}
+	annotations := endpoint.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotationSources] = string(value)
+	endpoint.SetAnnotations(annotations)
+	return unstructured.SetNestedSlice(endpoint.Object, kept, "spec", "endpoints")
+}
+
+// singleRecordType returns the record type of the first endpoint of a DNSEndpoint,
+// the only one of a desired DNSEndpoint
+func singleRecordType(endpoint *unstructured.Unstructured) string {
+	endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	if len(endpoints) == 0 {
+		return ""
+	}
+	entry, _ := endpoints[0].(map[string]interface{})
+	recordType, _ := entry["recordType"].(string)
+	return recordType
+}
@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+var (
+	routerA = Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "router-a."}
+	routerB = Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.2.1")}, KeyName: "router-b."}
+)
+
+// endpointTargets returns the targets of the test DNSEndpoint
+func endpointTargets(t *testing.T, client *Client) []string {
+	t.Helper()
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(context.Background(), "test", metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	targets, _, _ := unstructured.NestedStringSlice(endpoints[0].(map[string]interface{}), "targets")
+	return targets
+}
+
+func TestFirstOwnerWins(t *testing.T) {
+	for _, opts := range []Options{{FirstOwnerWins: true}, {FirstOwnerWins: true, DynamicRecords: true}} {
+		client := newFakeClient(opts)
+
+		if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+		if _, err := client.ApplyUpdate(routerB, testUpdate(update.UpdateTypeCreate, "192.0.2.20")); !errors.Is(err, dnserr.ErrNameOwned) {
+			t.Errorf("Expected update by another source to be refused, got %v", err)
+		}
+		if _, err := client.ApplyUpdate(routerB, testUpdate(update.UpdateTypeDelete, "")); !errors.Is(err, dnserr.ErrNameOwned) {
+			t.Errorf("Expected delete by another source to be refused, got %v", err)
+		}
+
+		// The owner keeps control of its name
+		if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.11")); err != nil {
+			t.Errorf("Expected owner update to be accepted, got %v", err)
+		}
+		if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeDelete, "")); err != nil {
+			t.Errorf("Expected owner delete to be accepted, got %v", err)
+		}
+		if _, err := client.ApplyUpdate(routerB, testUpdate(update.UpdateTypeCreate, "192.0.2.20")); err != nil {
+			t.Errorf("Expected released name to be claimable, got %v", err)
+		}
+	}
+}
+
+func TestMergeTargets(t *testing.T) {
+	client := newFakeClient(Options{MergeTargets: true})
+
+	steps := []struct {
+		name    string
+		req     Requester
+		upd     *update.DNSUpdate
+		targets []string
+	}{
+		{"first source", routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10"), []string{"192.0.2.10"}},
+		{"second source", routerB, testUpdate(update.UpdateTypeCreate, "192.0.2.20"), []string{"192.0.2.10", "192.0.2.20"}},
+		{"source moves", routerA, testUpdate(update.UpdateTypeUpdate, "192.0.2.11"), []string{"192.0.2.11", "192.0.2.20"}},
+		{"source adds a target", routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.12"), []string{"192.0.2.11", "192.0.2.12", "192.0.2.20"}},
+		{"other target delete", routerA, testUpdate(update.UpdateTypeDelete, "192.0.2.20"), []string{"192.0.2.11", "192.0.2.12", "192.0.2.20"}},
+		{"source withdraws", routerB, testUpdate(update.UpdateTypeDelete, ""), []string{"192.0.2.11", "192.0.2.12"}},
+		{"target delete", routerA, testUpdate(update.UpdateTypeDelete, "192.0.2.12"), []string{"192.0.2.11"}},
+		{"last source withdraws", routerA, testUpdate(update.UpdateTypeDelete, "192.0.2.11"), nil},
+	}
+
+	for _, step := range steps {
+		if _, err := client.ApplyUpdate(step.req, step.upd); err != nil {
+			t.Fatalf("%s: ApplyUpdate() failed: %v", step.name, err)
+		}
+		if got := endpointTargets(t, client); !reflect.DeepEqual(got, step.targets) {
+			t.Errorf("%s: targets = %v, want %v", step.name, got, step.targets)
+		}
+	}
+}
+
+func TestKeyPriorities(t *testing.T) {
+	admin := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "admin."}
+	router := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "router."}
+
+	for _, opts := range []Options{
+		{KeyPriorities: map[string]int{"admin": 100, "router": 10}},
+		{KeyPriorities: map[string]int{"admin.": 100}, DynamicRecords: true},
+	} {
+		client := newFakeClient(opts)
+
+		if _, err := client.ApplyUpdate(router, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+		// A higher priority key takes over the name
+		if _, err := client.ApplyUpdate(admin, testUpdate(update.UpdateTypeCreate, "192.0.2.20")); err != nil {
+			t.Fatalf("Expected higher priority key to be accepted, got %v", err)
+		}
+		if _, err := client.ApplyUpdate(router, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); !errors.Is(err, dnserr.ErrKeyOutranked) {
+			t.Errorf("Expected update with lower priority key to be refused, got %v", err)
+		}
+		if _, err := client.ApplyUpdate(router, testUpdate(update.UpdateTypeDelete, "")); !errors.Is(err, dnserr.ErrKeyOutranked) {
+			t.Errorf("Expected delete with lower priority key to be refused, got %v", err)
+		}
+		if _, err := client.ApplyUpdate(admin, testUpdate(update.UpdateTypeDelete, "")); err != nil {
+			t.Errorf("Expected delete with owner key to be accepted, got %v", err)
+		}
+	}
+}
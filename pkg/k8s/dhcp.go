@@ -0,0 +1,198 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// DNS record types sent by DHCP servers (dns.TypePTR, dns.TypeDHCID)
+const (
+	typePTR   = 12
+	typeDHCID = 49
+)
+
+// annotationDHCID stores the DHCID identifying the DHCP client owning a name
+const annotationDHCID = "ddnsbridge4extdns/dhcid"
+
+// recordTypeCodes maps DNSEndpoint record types to DNS record types
+var recordTypeCodes = map[string]uint16{
+	"A":     1,
+	"AAAA":  28,
+	"TXT":   typeTXT,
+	"PTR":   typePTR,
+	"CNAME": typeCNAME,
+	"SRV":   typeSRV,
+	"MX":    typeMX,
+	"NS":    typeNS,
+}
+
+// isReverseName checks if a name belongs to a reverse mapping zone
+func isReverseName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return strings.HasSuffix(name, ".in-addr.arpa") || strings.HasSuffix(name, ".ip6.arpa")
+}
+
+// applyDHCID stores or removes the DHCID of a name on its DNSEndpoint
+func (c *Client) applyDHCID(ctx context.Context, upd *update.DNSUpdate) (changed bool, err error) {
+	// DHCIDs are only stored on the DNSEndpoint of a single name
+	if c.groupByRequester {
+		logrus.Debugf("Ignoring DHCID of grouped name %s", upd.Name)
+		return false, nil
+	}
+
+	resourceName := c.endpointResourceName(upd)
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+
+	existing, err := endpoints.Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil {
+		if !isNotFoundError(err) {
+			return false, fmt.Errorf("failed to get DNSEndpoint: %w", err)
+		}
+		if upd.Type != update.UpdateTypeDelete {
+			logrus.Warnf("Ignoring DHCID of %s without address record", upd.Name)
+		}
+		return false, nil
+	}
+
+	annotations := existing.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	current, ok := annotations[annotationDHCID]
+	switch upd.Type {
+	case update.UpdateTypeCreate, update.UpdateTypeUpdate:
+		if ok && current == upd.Target {
+			return false, nil
+		}
+		annotations[annotationDHCID] = upd.Target
+	case update.UpdateTypeDelete:
+		if !ok || (upd.Target != "" && current != upd.Target) {
+			return false, nil
+		}
+		delete(annotations, annotationDHCID)
+	default:
+		return false, fmt.Errorf("unsupported update type: %v", upd.Type)
+	}
+
+	existing.SetAnnotations(annotations)
+	if _, err := endpoints.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to update DHCID of DNSEndpoint: %w", err)
+	}
+	logrus.Debugf("Successfully updated DHCID of DNSEndpoint %s/%s", c.namespace, resourceName)
+	return true, nil
+}
+
+// LookupRRsets returns the records published for a name by DNS record type,
+// including the DHCID stored on its DNSEndpoint
+func (c *Client) LookupRRsets(name, zone string) (map[uint16][]string, error) {
+	entries, dhcid, err := c.lookupEntries(context.Background(), name, zone)
+	if err != nil {
+		return nil, err
+	}
+	rrsets := make(map[uint16][]string)
+	addRRsets(rrsets, name, entries)
+
+	// The DHCID only identifies the owner of names the bridge publishes
+	if dhcid != "" && len(rrsets) > 0 {
+		rrsets[typeDHCID] = []string{dhcid}
+	}
+	return rrsets, nil
+}
+
+// LookupRecords returns the records published for a name of any type, with
+// their TTL, including the DHCID stored on its DNSEndpoint
+func (c *Client) LookupRecords(name, zone string) ([]Record, error) {
+	entries, dhcid, err := c.lookupEntries(context.Background(), name, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(entries))
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok || !entryMatches(entry, name, "", "") {
+			continue
+		}
+		recordType, _, _ := unstructured.NestedString(fields, "recordType")
+		ttl, _, _ := unstructured.NestedInt64(fields, "recordTTL")
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		if recordType == "" || len(targets) == 0 {
+			continue
+		}
+		records = append(records, Record{
+			Name:    strings.ToLower(strings.TrimSuffix(name, ".")),
+			Type:    recordType,
+			TTL:     ttl,
+			Targets: targets,
+		})
+	}
+	if dhcid != "" && len(records) > 0 {
+		records = append(records, Record{
+			Name:    strings.ToLower(strings.TrimSuffix(name, ".")),
+			Type:    "DHCID",
+			Targets: []string{dhcid},
+		})
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Type < records[j].Type
+	})
+	return records, nil
+}
+
+// lookupEntries returns the endpoint entries that may hold a name, and the DHCID
+// stored on its DNSEndpoint
+func (c *Client) lookupEntries(ctx context.Context, name, zone string) (entries []interface{}, dhcid string, err error) {
+	c, err = c.forRecord(name, zone)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Grouped names may be held by the DNSEndpoint of any requester
+	if c.groupByRequester {
+		entries, err := c.lookupGroups(ctx, name)
+		return entries, "", err
+	}
+
+	resourceName := c.endpointResourceName(&update.DNSUpdate{Name: name, Zone: zone})
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+
+	entries, _, _ = unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	return entries, existing.GetAnnotations()[annotationDHCID], nil
+}
+
+// addRRsets adds the targets of the endpoint entries holding a name to its RRsets
+func addRRsets(rrsets map[uint16][]string, name string, entries []interface{}) {
+	dnsName := strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		entryName, _ := fields["dnsName"].(string)
+		if strings.ToLower(strings.TrimSuffix(entryName, ".")) != dnsName {
+			continue
+		}
+		recordType, _ := fields["recordType"].(string)
+		rrtype, ok := recordTypeCodes[recordType]
+		if !ok {
+			continue
+		}
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		rrsets[rrtype] = append(rrsets[rrtype], targets...)
+	}
+}
@@ -0,0 +1,109 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestIsReverseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"10.1.168.192.in-addr.arpa.", true},
+		{"1.0.0.0.8.b.d.0.1.0.0.2.IP6.ARPA", true},
+		{"host.example.com.", false},
+		{"in-addr.arpa.example.com.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isReverseName(tt.name); got != tt.expected {
+				t.Errorf("isReverseName(%q) = %v, expected %v", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyDHCPUpdates(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 53}, KeyName: "dhcp."}
+
+	dhcid := &update.DNSUpdate{
+		Type:       update.UpdateTypeCreate,
+		RecordType: dns.TypeDHCID,
+		Name:       "test.example.com.",
+		Zone:       "example.com.",
+		Target:     "AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=",
+		TTL:        1200,
+	}
+	ptr := &update.DNSUpdate{
+		Type:       update.UpdateTypeCreate,
+		RecordType: dns.TypePTR,
+		Name:       "100.1.168.192.in-addr.arpa.",
+		Zone:       "1.168.192.in-addr.arpa.",
+		Target:     "test.example.com.",
+		TTL:        1200,
+	}
+
+	// A DHCID without address record is ignored
+	if changed, err := client.ApplyUpdate(req, dhcid); err != nil || changed {
+		t.Fatalf("Expected DHCID without address to be ignored, got changed=%v err=%v", changed, err)
+	}
+
+	for _, upd := range []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.168.1.100"), dhcid, ptr} {
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate(%s) failed: %v", upd, err)
+		}
+	}
+
+	rrsets, err := client.LookupRRsets("test.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("LookupRRsets() failed: %v", err)
+	}
+	expected := map[uint16][]string{
+		dns.TypeA:     {"192.168.1.100"},
+		dns.TypeDHCID: {dhcid.Target},
+	}
+	if !reflect.DeepEqual(rrsets, expected) {
+		t.Errorf("Expected %v, got %v", expected, rrsets)
+	}
+
+	records, err := client.LookupRecords("test.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("LookupRecords() failed: %v", err)
+	}
+	expectedRecords := []Record{
+		{Name: "test.example.com", Type: "A", TTL: 300, Targets: []string{"192.168.1.100"}},
+		{Name: "test.example.com", Type: "DHCID", Targets: []string{dhcid.Target}},
+	}
+	if !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("Expected records %v, got %v", expectedRecords, records)
+	}
+
+	// The PTR record lives under its full reverse name
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "100-1-168-192-in-addr-arpa", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("PTR DNSEndpoint not created: %v", err)
+	}
+	if _, ok := endpoint.GetLabels()[labelZone]; !ok {
+		t.Error("Expected PTR DNSEndpoint to carry the zone label")
+	}
+
+	// Refreshing the address keeps the DHCID
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.101")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	rrsets, _ = client.LookupRRsets("test.example.com.", "example.com.")
+	if len(rrsets[dns.TypeDHCID]) != 1 {
+		t.Error("Expected DHCID to be kept on address refresh")
+	}
+}
@@ -0,0 +1,310 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// Actions of a Change
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change is a change of a resource a dry run would make
+type Change struct {
+	Action    string `json:"action"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Before and After are the resource before and after the change, nil when it
+	// does not exist
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// DryRunUpdates applies updates as if req sent them, against the resources of the
+// cluster, and returns the changes of resources they would make without writing
+// anything. Writes are neither throttled nor recorded as RecordEvents. An update
+// refused by the ownership policies returns its error.
+func (c *Client) DryRunUpdates(ctx context.Context, req Requester, updates []*update.DNSUpdate) ([]Change, error) {
+	recorder := newDryRunClient(c.dynamicClient)
+	dry := *c
+	dry.dynamicClient = recorder
+	dry.throttle = nil
+	dry.recordEvents = false
+
+	for _, upd := range updates {
+		rc, err := dry.forRecord(upd.Name, upd.Zone)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rc.applyUpdate(ctx, req, upd); err != nil {
+			return recorder.changes(), err
+		}
+	}
+	return recorder.changes(), nil
+}
+
+// dryRunKey identifies a resource written by a dry run
+type dryRunKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+// dryRunClient reads the resources of a dynamic client, and keeps the writes in
+// memory: the following reads see them, the cluster does not
+type dryRunClient struct {
+	dynamic.Interface
+
+	mu sync.Mutex
+	// written holds the resources written, nil when deleted
+	written map[dryRunKey]*unstructured.Unstructured
+	// original holds the resources before their first write, nil when absent
+	original map[dryRunKey]*unstructured.Unstructured
+	order    []dryRunKey
+}
+
+// newDryRunClient creates a dry run on top of a dynamic client
+func newDryRunClient(client dynamic.Interface) *dryRunClient {
+	return &dryRunClient{
+		Interface: client,
+		written:   make(map[dryRunKey]*unstructured.Unstructured),
+		original:  make(map[dryRunKey]*unstructured.Unstructured),
+	}
+}
+
+// Resource returns the resources of a kind, written in memory
+func (d *dryRunClient) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := d.Interface.Resource(gvr)
+	return &dryRunResource{ResourceInterface: resource, namespaceable: resource, client: d, gvr: gvr}
+}
+
+// stagedWrite is a resource written by a dry run, before and after its writes,
+// nil when absent
+type stagedWrite struct {
+	key           dryRunKey
+	before, after *unstructured.Unstructured
+}
+
+// writes returns the resources written, in the order of their first write.
+// Resources written back to their original state are left out.
+func (d *dryRunClient) writes() []stagedWrite {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writes := make([]stagedWrite, 0, len(d.order))
+	for _, key := range d.order {
+		before, after := d.original[key], d.written[key]
+		if before == nil && after == nil {
+			continue
+		}
+		if before != nil && after != nil && reflect.DeepEqual(before.Object, after.Object) {
+			continue
+		}
+		writes = append(writes, stagedWrite{key: key, before: before, after: after})
+	}
+	return writes
+}
+
+// changes returns the changes of the resources written, in the order of their
+// first write
+func (d *dryRunClient) changes() []Change {
+	writes := d.writes()
+	changes := make([]Change, 0, len(writes))
+	for _, w := range writes {
+		change := Change{Namespace: w.key.namespace, Name: w.key.name}
+		switch {
+		case w.before == nil:
+			change.Action, change.Kind, change.After = ChangeCreate, w.after.GetKind(), w.after.Object
+		case w.after == nil:
+			change.Action, change.Kind, change.Before = ChangeDelete, w.before.GetKind(), w.before.Object
+		default:
+			change.Action, change.Kind, change.Before, change.After = ChangeUpdate, w.after.GetKind(), w.before.Object, w.after.Object
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// dryRunResource reads the resources of a kind from the cluster and the writes of its dry run
+type dryRunResource struct {
+	dynamic.ResourceInterface
+	namespaceable dynamic.NamespaceableResourceInterface
+	client        *dryRunClient
+	gvr           schema.GroupVersionResource
+	namespace     string
+}
+
+// Namespace returns the resources of a namespace
+func (r *dryRunResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &dryRunResource{
+		ResourceInterface: r.namespaceable.Namespace(namespace),
+		namespaceable:     r.namespaceable,
+		client:            r.client,
+		gvr:               r.gvr,
+		namespace:         namespace,
+	}
+}
+
+// key returns the key of a resource of the namespace
+func (r *dryRunResource) key(name string) dryRunKey {
+	return dryRunKey{gvr: r.gvr, namespace: r.namespace, name: name}
+}
+
+// current returns a resource as the dry run sees it, nil when it does not exist.
+// The lock of the client must be held.
+func (r *dryRunResource) current(ctx context.Context, name string) (*unstructured.Unstructured, error) {
+	key := r.key(name)
+	if obj, ok := r.client.written[key]; ok {
+		return obj, nil
+	}
+	obj, err := r.ResourceInterface.Get(ctx, name, metav1.GetOptions{})
+	if isNotFoundError(err) {
+		return nil, nil
+	}
+	return obj, err
+}
+
+// write replaces a resource in memory, nil deleting it. The lock of the client must be held.
+func (r *dryRunResource) write(key dryRunKey, existing, obj *unstructured.Unstructured) {
+	if _, ok := r.client.original[key]; !ok {
+		if existing != nil {
+			existing = existing.DeepCopy()
+		}
+		r.client.original[key] = existing
+		r.client.order = append(r.client.order, key)
+	}
+	r.client.written[key] = obj
+}
+
+// Get returns a resource, as written by the dry run
+func (r *dryRunResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	obj, err := r.current(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, apierrors.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+// List returns the resources of the cluster, replaced by the writes of the dry run
+func (r *dryRunResource) List(ctx context.Context, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.ResourceInterface.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	items := make([]unstructured.Unstructured, 0, len(list.Items))
+	for _, item := range list.Items {
+		key := dryRunKey{gvr: r.gvr, namespace: item.GetNamespace(), name: item.GetName()}
+		if _, ok := r.client.written[key]; !ok {
+			items = append(items, item)
+		}
+	}
+	for _, key := range r.client.order {
+		obj := r.client.written[key]
+		if key.gvr != r.gvr || obj == nil || (r.namespace != metav1.NamespaceAll && key.namespace != r.namespace) {
+			continue
+		}
+		if selector.Matches(labels.Set(obj.GetLabels())) {
+			items = append(items, *obj.DeepCopy())
+		}
+	}
+	list.Items = items
+	return list, nil
+}
+
+// Create creates a resource in memory
+func (r *dryRunResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	created := obj.DeepCopy()
+	if created.GetName() == "" {
+		// The API server would complete the generated name
+		created.SetName(created.GetGenerateName())
+	}
+	created.SetNamespace(r.namespace)
+
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	existing, err := r.current(ctx, created.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, apierrors.NewAlreadyExists(r.gvr.GroupResource(), created.GetName())
+	}
+	r.write(r.key(created.GetName()), nil, created)
+	return created.DeepCopy(), nil
+}
+
+// Update replaces a resource in memory
+func (r *dryRunResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	updated := obj.DeepCopy()
+	updated.SetNamespace(r.namespace)
+
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	existing, err := r.current(ctx, updated.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, apierrors.NewNotFound(r.gvr.GroupResource(), updated.GetName())
+	}
+	r.write(r.key(updated.GetName()), existing, updated)
+	return updated.DeepCopy(), nil
+}
+
+// UpdateStatus replaces the status of a resource in memory
+func (r *dryRunResource) UpdateStatus(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	r.client.mu.Lock()
+	existing, err := r.current(ctx, obj.GetName())
+	r.client.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, apierrors.NewNotFound(r.gvr.GroupResource(), obj.GetName())
+	}
+	updated := existing.DeepCopy()
+	if status, ok := obj.Object["status"]; ok {
+		updated.Object["status"] = status
+	}
+	return r.Update(ctx, updated, options)
+}
+
+// Delete deletes a resource in memory
+func (r *dryRunResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	existing, err := r.current(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return apierrors.NewNotFound(r.gvr.GroupResource(), name)
+	}
+	r.write(r.key(name), existing, nil)
+	return nil
+}
@@ -0,0 +1,72 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestDryRunUpdates(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{})
+	if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	other := testUpdate(update.UpdateTypeCreate, "192.0.2.30")
+	other.Name = "other.example.com."
+
+	tests := []struct {
+		name     string
+		updates  []*update.DNSUpdate
+		expected []string
+	}{
+		{"update", []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.11")}, []string{"update test"}},
+		{"unchanged", []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.10")}, []string{}},
+		{"create", []*update.DNSUpdate{other}, []string{"create other"}},
+		{"delete", []*update.DNSUpdate{testUpdate(update.UpdateTypeDelete, "")}, []string{"delete test"}},
+		{"delete and create", []*update.DNSUpdate{testUpdate(update.UpdateTypeDelete, ""), testUpdate(update.UpdateTypeCreate, "192.0.2.12")}, []string{"update test"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := client.DryRunUpdates(ctx, routerA, tt.updates)
+			if err != nil {
+				t.Fatalf("DryRunUpdates() failed: %v", err)
+			}
+			got := make([]string, 0, len(changes))
+			for _, change := range changes {
+				got = append(got, change.Action+" "+change.Name)
+				if change.Kind != "DNSEndpoint" || change.Namespace != "default" {
+					t.Errorf("Unexpected change %+v", change)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected changes %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	// Nothing was written
+	if targets := endpointTargets(t, client); len(targets) != 1 || targets[0] != "192.0.2.10" {
+		t.Errorf("Expected the DNSEndpoint to be unchanged, got %v", targets)
+	}
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "other", metav1.GetOptions{}); !isNotFoundError(err) {
+		t.Errorf("Expected the dry run not to create other, got %v", err)
+	}
+}
+
+func TestDryRunUpdatesOwnership(t *testing.T) {
+	client := newFakeClient(Options{FirstOwnerWins: true})
+	if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if _, err := client.DryRunUpdates(context.Background(), routerB, []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.20")}); !errors.Is(err, dnserr.ErrNameOwned) {
+		t.Errorf("Expected the dry run of another source to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// endpointCacheWriteTimeout bounds the wait for the watch to deliver a write,
+// the resource being read from the API server meanwhile. The watch skips the
+// versions written between two lists of a reconnection.
+const endpointCacheWriteTimeout = time.Minute
+
+// deletedVersion is the version of a deleted resource
+const deletedVersion = "deleted"
+
+// endpointCache reads the DNSEndpoints from a watch once it is synced, instead of
+// getting them from the API server, and passes the writes to the API server. A
+// resource written is read from the API server until the watch delivers the
+// write, so that an update never reads what it has just overwritten.
+type endpointCache struct {
+	dynamic.Interface
+	gvr schema.GroupVersionResource
+
+	mu sync.Mutex
+	// store holds the watched DNSEndpoints, nil until the watch is synced
+	store cache.Store
+	// namespace is the namespace watched, all when empty
+	namespace string
+	// pending holds the resources written and not yet delivered by the watch
+	pending map[string]pendingWrite
+	// listeners are notified of the DNS names of the DNSEndpoints changed by
+	// other writers
+	listeners []func(names []string)
+}
+
+// pendingWrite is a write the watch has not delivered yet
+type pendingWrite struct {
+	// version is the resource version written, deletedVersion for a deletion,
+	// empty when unknown after a conflict
+	version string
+	expires time.Time
+}
+
+// newEndpointCache creates a cache of the DNSEndpoints on top of a dynamic client.
+// Reads pass through until RunEndpointCache syncs it.
+func newEndpointCache(client dynamic.Interface, gvr schema.GroupVersionResource) *endpointCache {
+	return &endpointCache{Interface: client, gvr: gvr, pending: make(map[string]pendingWrite)}
+}
+
+// RunEndpointCache watches the DNSEndpoints and reads them from the watch until
+// ctx is done. It fails when the client was created without EndpointCache.
+func (c *Client) RunEndpointCache(ctx context.Context) error {
+	e := c.endpointCache
+	if e == nil {
+		return fmt.Errorf("DNSEndpoint cache not enabled")
+	}
+	// Watch every DNSEndpoint, also those of other owners the updates must not overwrite
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(e.Interface, recordResyncPeriod, c.listNamespace(), nil)
+	informer := factory.ForResource(c.gvr).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if !e.delivered(obj, watch.Added) {
+				e.notify(obj)
+			}
+		},
+		UpdateFunc: func(old, obj interface{}) {
+			// Resyncs deliver the resources unchanged
+			if sameVersion(old, obj) {
+				return
+			}
+			if !e.delivered(obj, watch.Modified) {
+				e.notify(old, obj)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if !e.delivered(obj, watch.Deleted) {
+				e.notify(obj)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register DNSEndpoint cache handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync DNSEndpoint cache")
+	}
+	e.mu.Lock()
+	e.store = informer.GetStore()
+	e.namespace = c.listNamespace()
+	e.mu.Unlock()
+	logrus.Infof("DNSEndpoint cache started in namespace %q", c.listNamespace())
+
+	<-ctx.Done()
+	e.mu.Lock()
+	e.store = nil
+	e.mu.Unlock()
+	return nil
+}
+
+// delivered forgets the pending write of a resource the watch delivered, and
+// checks if the event is that write
+func (e *endpointCache) delivered(obj interface{}, event watch.EventType) bool {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+	key := resourceKey(resource)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w, ok := e.pending[key]
+	if !ok {
+		return false
+	}
+	switch {
+	case w.version == "":
+		// The write of another writer the cache missed
+		delete(e.pending, key)
+		return false
+	case w.version == deletedVersion:
+		// A resource added after its deletion was created again
+		if event == watch.Modified {
+			return false
+		}
+		delete(e.pending, key)
+		return event == watch.Deleted
+	case event == watch.Deleted || resource.GetResourceVersion() != w.version:
+		return false
+	}
+	delete(e.pending, key)
+	return true
+}
+
+// sameVersion checks if two versions of a resource are the same
+func sameVersion(old, obj interface{}) bool {
+	a, ok := old.(*unstructured.Unstructured)
+	b, ok2 := obj.(*unstructured.Unstructured)
+	return ok && ok2 && a.GetResourceVersion() != "" && a.GetResourceVersion() == b.GetResourceVersion()
+}
+
+// notify passes the DNS names of changed DNSEndpoints to the listeners
+func (e *endpointCache) notify(objs ...interface{}) {
+	var names []string
+	for _, obj := range objs {
+		resource, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		endpoints, _, _ := unstructured.NestedSlice(resource.Object, "spec", "endpoints")
+		for _, endpoint := range endpoints {
+			if m, ok := endpoint.(map[string]interface{}); ok {
+				if name, ok := m["dnsName"].(string); ok && name != "" {
+					names = append(names, fqdn(name))
+				}
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	listeners := e.listeners
+	e.mu.Unlock()
+	for _, listener := range listeners {
+		listener(names)
+	}
+}
+
+// fqdn returns a name lowercased, ending with a dot
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// OnEndpointChange calls fn with the DNS names, lowercased and ending with a
+// dot, of the DNSEndpoints the watch of EndpointCache sees created, changed or
+// deleted by another writer than this replica: another replica, the expiry of
+// records, a collection or kubectl. It does nothing without EndpointCache.
+func (c *Client) OnEndpointChange(fn func(names []string)) {
+	e := c.endpointCache
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+// Watches checks if the watch of EndpointCache is synced and sees the
+// DNSEndpoints of a name, so that OnEndpointChange reports their changes
+func (c *Client) Watches(name, zone string) bool {
+	e := c.endpointCache
+	if e == nil {
+		return false
+	}
+	rc, err := c.forRecord(name, zone)
+	if err != nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.store != nil && (e.namespace == metav1.NamespaceAll || rc.namespace == e.namespace)
+}
+
+// written records the write of a resource, to be read from the API server until
+// the watch delivers it. The version is empty when unknown.
+func (e *endpointCache) written(namespace, name, version string) {
+	key := namespace + "/" + name
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.store == nil {
+		return
+	}
+	// The watch may have delivered the write already
+	current, exists, _ := e.store.GetByKey(key)
+	switch {
+	case version == deletedVersion && !exists:
+		delete(e.pending, key)
+		return
+	case version != "" && exists && current.(*unstructured.Unstructured).GetResourceVersion() == version:
+		delete(e.pending, key)
+		return
+	case version == "":
+		if _, ok := e.pending[key]; ok {
+			return
+		}
+	}
+	e.pending[key] = pendingWrite{version: version, expires: time.Now().Add(endpointCacheWriteTimeout)}
+}
+
+// cached returns the cached resource of a namespace, and whether the cache serves it
+func (e *endpointCache) cached(namespace, name string) (*unstructured.Unstructured, bool) {
+	key := namespace + "/" + name
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.store == nil || (e.namespace != metav1.NamespaceAll && namespace != e.namespace) {
+		return nil, false
+	}
+	if w, ok := e.pending[key]; ok {
+		if time.Now().Before(w.expires) {
+			return nil, false
+		}
+		logrus.Debugf("DNSEndpoint cache never received the write of %s, reading it from the watch again", key)
+		delete(e.pending, key)
+	}
+	obj, exists, _ := e.store.GetByKey(key)
+	if !exists {
+		return nil, true
+	}
+	return obj.(*unstructured.Unstructured).DeepCopy(), true
+}
+
+// Resource returns the resources of a kind, DNSEndpoints read from the cache
+func (e *endpointCache) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	resource := e.Interface.Resource(gvr)
+	if gvr != e.gvr {
+		return resource
+	}
+	return &cachedResource{NamespaceableResourceInterface: resource, cache: e}
+}
+
+// cachedResource reads the DNSEndpoints of its namespace from the cache
+type cachedResource struct {
+	dynamic.NamespaceableResourceInterface
+	cache *endpointCache
+}
+
+// Namespace returns the DNSEndpoints of a namespace
+func (r *cachedResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &cachedNamespaceResource{ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace), cache: r.cache, namespace: namespace}
+}
+
+// cachedNamespaceResource reads the DNSEndpoints of a namespace from the cache
+type cachedNamespaceResource struct {
+	dynamic.ResourceInterface
+	cache     *endpointCache
+	namespace string
+}
+
+// Get returns a DNSEndpoint from the cache, or from the API server while it is
+// not synced or a write of the resource is pending
+func (r *cachedNamespaceResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if len(subresources) == 0 && options.ResourceVersion == "" {
+		if obj, ok := r.cache.cached(r.namespace, name); ok {
+			metrics.EndpointCacheReads.WithLabelValues("cache").Inc()
+			if obj == nil {
+				return nil, apierrors.NewNotFound(r.cache.gvr.GroupResource(), name)
+			}
+			return obj, nil
+		}
+	}
+	metrics.EndpointCacheReads.WithLabelValues("api").Inc()
+	return r.ResourceInterface.Get(ctx, name, options, subresources...)
+}
+
+// Create creates a DNSEndpoint
+func (r *cachedNamespaceResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	created, err := r.ResourceInterface.Create(ctx, obj, options, subresources...)
+	r.written(obj.GetName(), created, err)
+	return created, err
+}
+
+// Update updates a DNSEndpoint
+func (r *cachedNamespaceResource) Update(ctx context.Context, obj *unstructured.Unstructured, options metav1.UpdateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	updated, err := r.ResourceInterface.Update(ctx, obj, options, subresources...)
+	r.written(obj.GetName(), updated, err)
+	return updated, err
+}
+
+// Delete deletes a DNSEndpoint
+func (r *cachedNamespaceResource) Delete(ctx context.Context, name string, options metav1.DeleteOptions, subresources ...string) error {
+	err := r.ResourceInterface.Delete(ctx, name, options, subresources...)
+	switch {
+	case err == nil:
+		r.cache.written(r.namespace, name, deletedVersion)
+	case apierrors.IsConflict(err) || isNotFoundError(err):
+		// The cache missed a write of the resource
+		r.cache.written(r.namespace, name, "")
+	}
+	return err
+}
+
+// written records the result of a write of a DNSEndpoint. A conflict means the
+// cache missed a write, the resource is read from the API server until the
+// watch delivers it.
+func (r *cachedNamespaceResource) written(name string, obj *unstructured.Unstructured, err error) {
+	switch {
+	case err == nil && obj != nil:
+		r.cache.written(r.namespace, obj.GetName(), obj.GetResourceVersion())
+	case apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) || isNotFoundError(err):
+		r.cache.written(r.namespace, name, "")
+	}
+}
@@ -0,0 +1,170 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic/fake"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestEndpointCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFakeClient(Options{EndpointCache: true})
+	cache := client.endpointCache
+	api := cache.Interface.(*fake.FakeDynamicClient)
+
+	// Reads pass through until the cache is synced
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{}); !isNotFoundError(err) {
+		t.Fatalf("Expected a NotFound error, got %v", err)
+	}
+	if len(api.Actions()) != 1 {
+		t.Errorf("Expected the read to reach the API server, got %d actions", len(api.Actions()))
+	}
+	if client.Watches("test.example.com.", "example.com.") {
+		t.Error("Expected no name watched before the sync")
+	}
+	var mu sync.Mutex
+	var changed []string
+	client.OnEndpointChange(func(names []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		changed = append(changed, names...)
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.RunEndpointCache(ctx)
+	}()
+	waitFor(t, "the cache to sync", func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.store != nil
+	})
+	settled := func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return len(cache.pending) == 0
+	}
+
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+	for _, ip := range []string{"192.0.2.10", "192.0.2.10", "192.0.2.20"} {
+		if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeUpdate, ip)); err != nil {
+			t.Fatalf("ApplyUpdate(%s) failed: %v", ip, err)
+		}
+		waitFor(t, "the watch to deliver the write", settled)
+	}
+
+	// The updates read the resource from the cache, and only wrote it
+	gets := 0
+	for _, action := range api.Actions() {
+		if action.GetVerb() == "get" {
+			gets++
+		}
+	}
+	if gets != 1 {
+		t.Errorf("Expected only the read before the sync to reach the API server, got %d", gets)
+	}
+
+	obj, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	targets := endpoints[0].(map[string]interface{})["targets"].([]interface{})
+	if len(targets) != 1 || targets[0] != "192.0.2.20" {
+		t.Errorf("Expected the target of the last update, got %v", targets)
+	}
+
+	if !client.Watches("test.example.com.", "example.com.") {
+		t.Error("Expected the name to be watched once synced")
+	}
+
+	// Changes of other writers are notified
+	mu.Lock()
+	changed = nil
+	mu.Unlock()
+	if err := api.Resource(endpointGVR).Namespace("default").Delete(ctx, "test", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	waitFor(t, "the deletion to be notified", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(changed) == 1 && changed[0] == "test.example.com."
+	})
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("RunEndpointCache() failed: %v", err)
+	}
+}
+
+func TestEndpointCachePendingWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newFakeClient(Options{EndpointCache: true})
+	cache := client.endpointCache
+	go client.RunEndpointCache(ctx)
+	waitFor(t, "the cache to sync", func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return cache.store != nil
+	})
+
+	// A write the watch has not delivered yet is read from the API server
+	cache.written("default", "test", "42")
+	if _, ok := cache.cached("default", "test"); ok {
+		t.Error("Expected a pending write to be read from the API server")
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetNamespace("default")
+	obj.SetName("test")
+	obj.SetResourceVersion("41")
+	if cache.delivered(obj, watch.Modified) {
+		t.Error("Expected an older version not to be taken for the write")
+	}
+	if _, ok := cache.cached("default", "test"); ok {
+		t.Error("Expected an older version not to end the pending write")
+	}
+	obj.SetResourceVersion("42")
+	if !cache.delivered(obj, watch.Modified) {
+		t.Error("Expected the delivered write to be recognized, not notified")
+	}
+	if _, ok := cache.cached("default", "test"); !ok {
+		t.Error("Expected the delivered write to be read from the cache")
+	}
+
+	// A write the watch never delivers is read from the cache again after a while
+	cache.written("default", "test", "43")
+	cache.mu.Lock()
+	w := cache.pending["default/test"]
+	w.expires = time.Now().Add(-time.Second)
+	cache.pending["default/test"] = w
+	cache.mu.Unlock()
+	if _, ok := cache.cached("default", "test"); !ok {
+		t.Error("Expected an expired pending write to be read from the cache")
+	}
+
+	// Namespaces outside the watch are read from the API server
+	if _, ok := cache.cached("other", "test"); ok {
+		t.Error("Expected a namespace outside the watch to be read from the API server")
+	}
+}
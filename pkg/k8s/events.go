@@ -0,0 +1,164 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// RecordEvent CRD holding the history of accepted changes
+var eventGVR = schema.GroupVersionResource{
+	Group:    "ddnsbridge4extdns.io",
+	Version:  "v1alpha1",
+	Resource: "recordevents",
+}
+
+// labelRecord links a RecordEvent to the name of the record it describes
+const labelRecord = "ddnsbridge4extdns/record"
+
+// recordLabel returns the labelRecord value of a resource name, cut to the 63
+// characters of a label value
+func recordLabel(resourceName string) string {
+	if len(resourceName) > 63 {
+		return strings.TrimRight(resourceName[:63], ".-")
+	}
+	return resourceName
+}
+
+// eventPruneInterval is how often expired RecordEvents are removed
+const eventPruneInterval = time.Hour
+
+// emitEvent creates a RecordEvent for an accepted change and enforces the per-record cap
+func (c *Client) emitEvent(ctx context.Context, req Requester, upd *update.DNSUpdate) error {
+	resourceName := c.endpointResourceName(upd)
+	events := c.dynamicClient.Resource(eventGVR).Namespace(c.namespace)
+
+	targets := []interface{}{}
+	if upd.IP != nil {
+		targets = append(targets, upd.IP.String())
+	}
+	if upd.Target != "" {
+		targets = append(targets, upd.Target)
+	}
+
+	event := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": eventGVR.GroupVersion().String(),
+			"kind":       "RecordEvent",
+			"metadata": map[string]interface{}{
+				"generateName": resourceName + "-",
+				"namespace":    c.namespace,
+				"labels": map[string]interface{}{
+					labelManagedBy: managedByValue,
+					labelRecord:    recordLabel(resourceName),
+					labelZone:      sanitizeLabel(upd.Zone),
+				},
+			},
+			"spec": map[string]interface{}{
+				"time":       time.Now().UTC().Format(time.RFC3339Nano),
+				"action":     upd.Type.String(),
+				"dnsName":    upd.Name,
+				"zone":       upd.Zone,
+				"recordType": recordTypeString(upd.RecordType),
+				"recordTTL":  int64(upd.TTL),
+				"targets":    targets,
+				"requester":  req.IP(),
+				"keyName":    req.KeyName,
+			},
+		},
+	}
+	if _, err := events.Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create RecordEvent: %w", err)
+	}
+	logrus.Debugf("Emitted RecordEvent %s for %s", upd.Type, upd.Name)
+
+	if c.eventsPerRecord <= 0 {
+		return nil
+	}
+
+	selector := labels.Set{labelManagedBy: managedByValue, labelRecord: recordLabel(resourceName)}.String()
+	list, err := events.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list RecordEvents: %w", err)
+	}
+	if len(list.Items) <= c.eventsPerRecord {
+		return nil
+	}
+
+	// Drop the oldest events beyond the cap
+	items := list.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		return eventTime(&items[i]).Before(eventTime(&items[j]))
+	})
+	for _, item := range items[:len(items)-c.eventsPerRecord] {
+		if err := events.Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return fmt.Errorf("failed to delete RecordEvent %s: %w", item.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// RunEventPruner removes RecordEvents older than the retention until ctx is done
+func (c *Client) RunEventPruner(ctx context.Context) {
+	if c.eventRetention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(eventPruneInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.pruneEvents(ctx, time.Now()); err != nil {
+			logrus.Errorf("Failed to prune RecordEvents: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneEvents removes RecordEvents older than the retention at the given time
+func (c *Client) pruneEvents(ctx context.Context, now time.Time) error {
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(eventGVR).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list RecordEvents: %w", err)
+	}
+
+	pruned := 0
+	for i := range list.Items {
+		item := &list.Items[i]
+		if now.Sub(eventTime(item)) <= c.eventRetention {
+			continue
+		}
+		if err := c.dynamicClient.Resource(eventGVR).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return fmt.Errorf("failed to delete RecordEvent %s: %w", item.GetName(), err)
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		logrus.Infof("Pruned %d expired RecordEvent(s) in namespace %q", pruned, c.listNamespace())
+	}
+	return nil
+}
+
+// eventTime returns the time of a RecordEvent, falling back to its creation time
+func eventTime(event *unstructured.Unstructured) time.Time {
+	if value, _, _ := unstructured.NestedString(event.Object, "spec", "time"); value != "" {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t
+		}
+	}
+	return event.GetCreationTimestamp().Time
+}
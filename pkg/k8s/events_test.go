@@ -0,0 +1,97 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// newFakeEventClient returns a fake client generating names like the API server does
+func newFakeEventClient(opts Options) *Client {
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		endpointGVR: "DNSEndpointList",
+		recordGVR:   "DynamicRecordList",
+		eventGVR:    "RecordEventList",
+	})
+	generated := 0
+	dynamicClient.PrependReactor("create", "recordevents", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() == "" {
+			generated++
+			obj.SetName(fmt.Sprintf("%s%05d", obj.GetGenerateName(), generated))
+		}
+		return false, nil, nil
+	})
+	opts.Namespace = "default"
+	return newClient(dynamicClient, opts)
+}
+
+func TestEmitEvent(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeEventClient(Options{RecordEvents: true, EventsPerRecord: 2})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+
+	for _, ip := range []string{"192.168.1.100", "192.168.1.101", "192.168.1.102"} {
+		if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, ip)); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+	// Unchanged updates do not emit events
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.102")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	list, err := client.dynamicClient.Resource(eventGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Fatalf("Expected 2 events kept for the record, got %d", len(list.Items))
+	}
+	for _, item := range list.Items {
+		if item.GetLabels()[labelRecord] != "test" {
+			t.Errorf("Expected record label 'test', got %q", item.GetLabels()[labelRecord])
+		}
+		targets, _, _ := unstructured.NestedStringSlice(item.Object, "spec", "targets")
+		if len(targets) == 1 && targets[0] == "192.168.1.100" {
+			t.Error("Expected the oldest event to be dropped")
+		}
+	}
+}
+
+func TestPruneEvents(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeEventClient(Options{RecordEvents: true, EventRetention: time.Hour})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.100")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	if err := client.pruneEvents(ctx, time.Now()); err != nil {
+		t.Fatalf("pruneEvents() failed: %v", err)
+	}
+	list, _ := client.dynamicClient.Resource(eventGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 1 {
+		t.Fatalf("Expected recent event to be kept, got %d events", len(list.Items))
+	}
+
+	if err := client.pruneEvents(ctx, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("pruneEvents() failed: %v", err)
+	}
+	list, _ = client.dynamicClient.Resource(eventGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 0 {
+		t.Errorf("Expected expired event to be pruned, got %d events", len(list.Items))
+	}
+}
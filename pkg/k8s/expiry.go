@@ -0,0 +1,169 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// annotationExpires holds the time a record is removed unless refreshed before
+const annotationExpires = "ddnsbridge4extdns/expires"
+
+// expiryZone returns the closest zone of a name with a TTL expiry policy and the
+// multiple of their TTL its records live without refresh, or an empty zone
+func (c *Client) expiryZone(name string) (string, int) {
+	zone := strings.ToLower(name)
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	for zone != "." && zone != "" {
+		if multiple, ok := c.ttlExpiry[zone]; ok {
+			return zone, multiple
+		}
+		zone = zone[strings.Index(zone, ".")+1:]
+	}
+	return "", 0
+}
+
+// ExpiresRecords checks if the records of a name expire unless refreshed, by
+// TTL_EXPIRY
+func (c *Client) ExpiresRecords(name string) bool {
+	zone, _ := c.expiryZone(name)
+	return zone != ""
+}
+
+// setExpiry stamps a DNSEndpoint written by a client with the time it expires
+// unless refreshed, when its zone has a TTL expiry policy
+func (c *Client) setExpiry(endpoint *unstructured.Unstructured, name string, ttl uint32, now time.Time) {
+	_, multiple := c.expiryZone(name)
+	if multiple == 0 || ttl == 0 {
+		return
+	}
+	expires := now.Add(time.Duration(multiple) * time.Duration(ttl) * time.Second).UTC()
+	annotations := endpoint.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotationExpires] = expires.Format(time.RFC3339)
+	endpoint.SetAnnotations(annotations)
+}
+
+// keepExpiry completes the annotations of a refreshed DNSEndpoint with those of the
+// existing one, and keeps the existing expiry when the refresh extends it by less
+// than the TTL, so that clients refreshing more often than the TTL cause no write
+func keepExpiry(existing, endpoint *unstructured.Unstructured) {
+	annotations := endpoint.GetAnnotations()
+	desired, ok := annotations[annotationExpires]
+	if !ok {
+		return
+	}
+	merged := make(map[string]string, len(existing.GetAnnotations())+len(annotations))
+	for k, v := range existing.GetAnnotations() {
+		merged[k] = v
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+
+	if current, ok := existing.GetAnnotations()[annotationExpires]; ok {
+		currentTime, err1 := time.Parse(time.RFC3339, current)
+		desiredTime, err2 := time.Parse(time.RFC3339, desired)
+		if err1 == nil && err2 == nil && desiredTime.Sub(currentTime) < time.Duration(endpointTTL(endpoint))*time.Second {
+			merged[annotationExpires] = current
+		}
+	}
+	endpoint.SetAnnotations(merged)
+}
+
+// endpointTTL returns the TTL of the first endpoint of a DNSEndpoint
+func endpointTTL(endpoint *unstructured.Unstructured) int64 {
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	if len(entries) == 0 {
+		return 0
+	}
+	fields, _ := entries[0].(map[string]interface{})
+	ttl, _, _ := unstructured.NestedInt64(fields, "recordTTL")
+	return ttl
+}
+
+// RunExpiry removes the expired records every interval until ctx is done
+func (c *Client) RunExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.ExpireRecords(ctx, time.Now()); err != nil {
+			logrus.Errorf("Failed to remove expired records: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireRecords deletes the DNSEndpoints of the zones with a TTL expiry policy whose
+// expiry is before now, and returns their names. A DNSEndpoint refreshed since it
+// was listed is left alone.
+func (c *Client) ExpireRecords(ctx context.Context, now time.Time) ([]string, error) {
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(c.gvr).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNSEndpoints: %w", err)
+	}
+	items := list.Items
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].GetNamespace() != items[j].GetNamespace() {
+			return items[i].GetNamespace() < items[j].GetNamespace()
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+
+	expired := make([]string, 0)
+	for i := range items {
+		item := &items[i]
+		value, ok := item.GetAnnotations()[annotationExpires]
+		if !ok || len(item.GetOwnerReferences()) > 0 {
+			continue
+		}
+		expires, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			logrus.Warnf("Ignoring invalid %s annotation on DNSEndpoint %s/%s", annotationExpires, item.GetNamespace(), item.GetName())
+			continue
+		}
+		dnsName, ok := singleDNSName(item)
+		if !ok || !expires.Before(now) {
+			continue
+		}
+		// The policy of the zone may have been removed since the record was written
+		zone, _ := c.expiryZone(dnsName)
+		if zone == "" {
+			continue
+		}
+
+		resourceVersion := item.GetResourceVersion()
+		err = c.dynamicClient.Resource(c.gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion},
+		})
+		if err != nil {
+			if isNotFoundError(err) || apierrors.IsConflict(err) {
+				continue
+			}
+			return expired, fmt.Errorf("failed to delete expired DNSEndpoint %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		metrics.RecordsExpired.WithLabelValues(strings.TrimSuffix(zone, ".")).Inc()
+		logrus.Infof("Removed DNSEndpoint %s/%s of %s, not refreshed since it expired at %s", item.GetNamespace(), item.GetName(), dnsName, value)
+		expired = append(expired, c.displayName(item.GetNamespace(), item.GetName()))
+	}
+	return expired, nil
+}
@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestExpireRecords(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{TTLExpiry: map[string]int{"ci.example.com.": 3}})
+	endpoints := client.dynamicClient.Resource(client.gvr).Namespace("default")
+
+	runner := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+	runner.Name, runner.Zone, runner.TTL = "runner.ci.example.com.", "example.com.", 60
+	server := testUpdate(update.UpdateTypeCreate, "192.0.2.20")
+	server.Name, server.TTL = "server.example.com.", 60
+	for _, upd := range []*update.DNSUpdate{runner, server} {
+		if _, err := client.ApplyUpdate(routerA, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+
+	endpoint, _ := endpoints.Get(ctx, "runner-ci", metav1.GetOptions{})
+	expires, err := time.Parse(time.RFC3339, endpoint.GetAnnotations()[annotationExpires])
+	if err != nil {
+		t.Fatalf("Expected an expiry on runner-ci, got %v", endpoint.GetAnnotations())
+	}
+	if lifetime := time.Until(expires); lifetime < 170*time.Second || lifetime > 180*time.Second {
+		t.Errorf("Expected runner-ci to expire in 3 TTLs, got %s", lifetime)
+	}
+	endpoint, _ = endpoints.Get(ctx, "server", metav1.GetOptions{})
+	if _, ok := endpoint.GetAnnotations()[annotationExpires]; ok {
+		t.Error("Expected no expiry outside of the policy zones")
+	}
+
+	// A refresh within the TTL does not write
+	changed, err := client.ApplyUpdate(routerA, runner)
+	if err != nil || changed {
+		t.Errorf("Expected an early refresh to be skipped, got changed=%v err=%v", changed, err)
+	}
+
+	names, err := client.ExpireRecords(ctx, time.Now())
+	if err != nil || len(names) != 0 {
+		t.Errorf("Expected nothing to expire yet, got %v, %v", names, err)
+	}
+	names, err = client.ExpireRecords(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ExpireRecords() failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "runner-ci" {
+		t.Errorf("Expected runner-ci to expire, got %v", names)
+	}
+	list, _ := endpoints.List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 1 || list.Items[0].GetName() != "server" {
+		t.Errorf("Expected only server to remain, got %d items", len(list.Items))
+	}
+}
+
+func TestKeepExpiry(t *testing.T) {
+	client := newFakeClient(Options{TTLExpiry: map[string]int{"example.com.": 2}})
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		current string
+		refresh time.Duration
+		want    string
+	}{
+		{"refresh within the TTL", "2026-10-16T12:02:00Z", 30 * time.Second, "2026-10-16T12:02:00Z"},
+		{"refresh after the TTL", "2026-10-16T12:02:00Z", 90 * time.Second, "2026-10-16T12:03:30Z"},
+		{"invalid current expiry", "never", 0, "2026-10-16T12:02:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := client.newEndpoint("test", map[string]interface{}{}, "test.example.com.", "A", 60, []interface{}{"192.0.2.1"})
+			existing.SetAnnotations(map[string]string{annotationExpires: tt.current, annotationDHCID: "dhcid"})
+			endpoint := client.newEndpoint("test", map[string]interface{}{}, "test.example.com.", "A", 60, []interface{}{"192.0.2.1"})
+			client.setExpiry(endpoint, "test.example.com.", 60, now.Add(tt.refresh))
+
+			keepExpiry(existing, endpoint)
+			annotations := endpoint.GetAnnotations()
+			if annotations[annotationExpires] != tt.want || annotations[annotationDHCID] != "dhcid" {
+				t.Errorf("Unexpected annotations %v, want expiry %s", annotations, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequesterSelector selects managed records by the requester that last refreshed them
+type RequesterSelector struct {
+	// Addr is the IP address of the requester
+	Addr string
+	// KeyName is the TSIG key of the requester
+	KeyName string
+}
+
+// IsEmpty checks if the selector matches nothing in particular
+func (s RequesterSelector) IsEmpty() bool {
+	return s.Addr == "" && s.KeyName == ""
+}
+
+// String returns a string representation of the selector
+func (s RequesterSelector) String() string {
+	return fmt.Sprintf("addr=%q key=%q", s.Addr, s.KeyName)
+}
+
+// labelSelector builds the label selector matching the requester
+func (s RequesterSelector) labelSelector() string {
+	set := labels.Set{labelManagedBy: managedByValue}
+	if s.Addr != "" {
+		set[labelAskBy] = sanitizeLabel(s.Addr)
+	}
+	if s.KeyName != "" {
+		set[labelKey] = sanitizeLabel(s.KeyName)
+	}
+	return set.String()
+}
+
+// CollectByRequester deletes all managed records last refreshed by the selected requester
+// and returns the names of the deleted resources, prefixed with their namespace when the
+// namespace of records is templated. With dryRun nothing is deleted.
+func (c *Client) CollectByRequester(ctx context.Context, sel RequesterSelector, dryRun bool) ([]string, error) {
+	if sel.IsEmpty() {
+		return nil, fmt.Errorf("requester selector requires an address or a key")
+	}
+
+	// In DynamicRecord mode the DNSEndpoints are garbage collected with their record
+	gvr, kind := c.gvr, "DNSEndpoint"
+	if c.dynamicRecords {
+		gvr, kind = recordGVR, "DynamicRecord"
+	}
+	list, err := c.dynamicClient.Resource(gvr).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: sel.labelSelector()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %ss: %w", kind, err)
+	}
+
+	items := list.Items
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].GetNamespace() != items[j].GetNamespace() {
+			return items[i].GetNamespace() < items[j].GetNamespace()
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, c.displayName(item.GetNamespace(), item.GetName()))
+	}
+
+	if dryRun {
+		logrus.Infof("Garbage collection dry run for requester %s: %d %s(s) would be deleted", sel, len(names), kind)
+		return names, nil
+	}
+
+	deleted := make([]string, 0, len(names))
+	for i, item := range items {
+		if err := c.dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+			return deleted, fmt.Errorf("failed to delete %s %s: %w", kind, names[i], err)
+		}
+		logrus.Infof("Garbage collected %s %s/%s of requester %s", kind, item.GetNamespace(), item.GetName(), sel)
+		deleted = append(deleted, names[i])
+	}
+	return deleted, nil
+}
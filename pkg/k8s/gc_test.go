@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestCollectByRequester(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{})
+
+	oldRouter := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "old-router"}
+	newRouter := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}, KeyName: "new-router"}
+
+	hosts := []struct {
+		name      string
+		requester Requester
+	}{
+		{"a.example.com.", oldRouter},
+		{"b.example.com.", oldRouter},
+		{"c.example.com.", newRouter},
+	}
+	for _, h := range hosts {
+		upd := testUpdate(update.UpdateTypeCreate, "192.168.1.100")
+		upd.Name = h.name
+		if _, err := client.ApplyUpdate(h.requester, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+
+	if _, err := client.CollectByRequester(ctx, RequesterSelector{}, false); err == nil {
+		t.Error("Expected error for empty selector")
+	}
+
+	names, err := client.CollectByRequester(ctx, RequesterSelector{Addr: "192.168.1.1"}, true)
+	if err != nil {
+		t.Fatalf("CollectByRequester() failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Expected dry run to select [a b], got %v", names)
+	}
+	list, _ := client.dynamicClient.Resource(client.gvr).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 3 {
+		t.Errorf("Expected dry run to keep 3 endpoints, got %d", len(list.Items))
+	}
+
+	names, err = client.CollectByRequester(ctx, RequesterSelector{KeyName: "old-router"}, false)
+	if err != nil {
+		t.Fatalf("CollectByRequester() failed: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 deleted endpoints, got %v", names)
+	}
+	list, _ = client.dynamicClient.Resource(client.gvr).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 1 || list.Items[0].GetName() != "c" {
+		t.Errorf("Expected only endpoint c to remain, got %d items", len(list.Items))
+	}
+}
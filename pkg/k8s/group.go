@@ -0,0 +1,214 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// labelGroup marks the DNSEndpoints aggregating the records of a requester
+const labelGroup = "ddnsbridge4extdns/group"
+
+// groupResourceName returns the name of the DNSEndpoint aggregating the records of a requester
+func groupResourceName(req Requester) string {
+	return groupName(sanitizeLabel(req.IP()), sanitizeLabel(req.KeyName))
+}
+
+// groupName returns the name of a DNSEndpoint group from the ownership labels of a requester
+func groupName(askBy, key string) string {
+	name := "requester-" + askBy
+	if key != "" {
+		name += "-" + key
+	}
+	return sanitizeResourceName(name)
+}
+
+// applyGroupUpdate writes a DNS update to the DNSEndpoint aggregating the records
+// of its requester. A name added to a group is withdrawn from the other groups.
+func (c *Client) applyGroupUpdate(ctx context.Context, req Requester, upd *update.DNSUpdate) (changed bool, err error) {
+	resourceName := groupResourceName(req)
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+
+	var entries []interface{}
+	existing, err := endpoints.Get(ctx, resourceName, metav1.GetOptions{})
+	if err == nil {
+		entries, _, _ = unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	} else if !isNotFoundError(err) {
+		return false, fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+
+	target := recordTarget(upd)
+	recordType := matchedType(upd)
+
+	kept := make([]interface{}, 0, len(entries)+1)
+	found := false
+	var joined []string
+	for _, entry := range entries {
+		if !entryMatches(entry, upd.Name, recordType, "") {
+			kept = append(kept, entry)
+			continue
+		}
+		found = true
+		fields, _ := entry.(map[string]interface{})
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		switch {
+		case upd.Type == update.UpdateTypeDelete && target != "":
+			// Deleting a single record keeps the other targets of the entry
+			remaining := make([]interface{}, 0, len(targets))
+			for _, t := range targets {
+				if t != target {
+					remaining = append(remaining, t)
+				}
+			}
+			if len(remaining) > 0 {
+				fields["targets"] = remaining
+				kept = append(kept, fields)
+			}
+		case joinsRRset(upd):
+			joined = targets
+		}
+	}
+
+	switch upd.Type {
+	case update.UpdateTypeCreate, update.UpdateTypeUpdate:
+		kept = append(kept, map[string]interface{}{
+			"dnsName":    upd.Name,
+			"recordType": recordType,
+			"recordTTL":  int64(upd.TTL),
+			"targets":    addTarget(joined, target),
+		})
+		if !found {
+			if err := c.releaseName(ctx, resourceName, upd.Name, recordType); err != nil {
+				return false, err
+			}
+		}
+	case update.UpdateTypeDelete:
+		if !found {
+			return false, nil
+		}
+		if len(kept) == 0 {
+			if err := endpoints.Delete(ctx, resourceName, metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+				return false, fmt.Errorf("failed to delete DNSEndpoint: %w", err)
+			}
+			logrus.Infof("Successfully deleted DNSEndpoint %s/%s", c.namespace, resourceName)
+			return true, nil
+		}
+	default:
+		return false, fmt.Errorf("unsupported update type: %v", upd.Type)
+	}
+	sortEntries(kept)
+
+	labels := c.endpointLabels("", req.IP(), req.KeyName)
+	delete(labels, labelZone)
+	labels[labelGroup] = "true"
+	req.setGeoLabels(labels)
+	endpoint := c.newEndpoint(resourceName, labels, "", "", 0, nil)
+	if err := unstructured.SetNestedSlice(endpoint.Object, kept, "spec", "endpoints"); err != nil {
+		return false, fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+	}
+	return c.upsertEndpoint(ctx, endpoint)
+}
+
+// releaseName withdraws a name and record type from the groups of other requesters
+func (c *Client) releaseName(ctx context.Context, except, dnsName, recordType string) error {
+	endpoints := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace)
+	selector := labels.Set{labelManagedBy: managedByValue, labelGroup: "true"}.String()
+	list, err := endpoints.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list DNSEndpoint groups: %w", err)
+	}
+
+	for i := range list.Items {
+		group := &list.Items[i]
+		if group.GetName() == except {
+			continue
+		}
+		entries, _, _ := unstructured.NestedSlice(group.Object, "spec", "endpoints")
+		kept := make([]interface{}, 0, len(entries))
+		for _, entry := range entries {
+			if !entryMatches(entry, dnsName, recordType, "") {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == len(entries) {
+			continue
+		}
+
+		if len(kept) == 0 {
+			err = endpoints.Delete(ctx, group.GetName(), metav1.DeleteOptions{})
+		} else {
+			updated := group.DeepCopy()
+			if err := unstructured.SetNestedSlice(updated.Object, kept, "spec", "endpoints"); err != nil {
+				return fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+			}
+			_, err = endpoints.Update(ctx, updated, metav1.UpdateOptions{})
+		}
+		if err != nil && !isNotFoundError(err) {
+			return fmt.Errorf("failed to withdraw %s from DNSEndpoint %s: %w", dnsName, group.GetName(), err)
+		}
+		logrus.Infof("Withdrew %s %s from DNSEndpoint %s/%s", dnsName, recordType, c.namespace, group.GetName())
+	}
+	return nil
+}
+
+// lookupGroups returns the entries of a name held by the groups of every requester
+func (c *Client) lookupGroups(ctx context.Context, name string) ([]interface{}, error) {
+	selector := labels.Set{labelManagedBy: managedByValue, labelGroup: "true"}.String()
+	list, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNSEndpoint groups: %w", err)
+	}
+
+	matches := make([]interface{}, 0)
+	for _, group := range list.Items {
+		entries, _, _ := unstructured.NestedSlice(group.Object, "spec", "endpoints")
+		for _, entry := range entries {
+			if entryMatches(entry, name, "", "") {
+				matches = append(matches, entry)
+			}
+		}
+	}
+	return matches, nil
+}
+
+// entryMatches checks if an endpoint entry holds a name, and the record type and
+// target when they are not empty
+func entryMatches(entry interface{}, dnsName, recordType, target string) bool {
+	fields, ok := entry.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	entryName, _ := fields["dnsName"].(string)
+	if !strings.EqualFold(strings.TrimSuffix(entryName, "."), strings.TrimSuffix(dnsName, ".")) {
+		return false
+	}
+	if entryType, _ := fields["recordType"].(string); recordType != "" && entryType != recordType {
+		return false
+	}
+	if target == "" {
+		return true
+	}
+	targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+	return containsString(targets, target)
+}
+
+// sortEntries orders endpoint entries by name and record type
+func sortEntries(entries []interface{}) {
+	key := func(entry interface{}) string {
+		fields, _ := entry.(map[string]interface{})
+		name, _ := fields["dnsName"].(string)
+		recordType, _ := fields["recordType"].(string)
+		return strings.ToLower(name) + " " + recordType
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return key(entries[i]) < key(entries[j])
+	})
+}
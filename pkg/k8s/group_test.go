@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// groupEntries returns the dnsName and targets of each entry of a DNSEndpoint group
+func groupEntries(t *testing.T, client *Client, name string) map[string][]string {
+	t.Helper()
+	group, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	entries := map[string][]string{}
+	endpoints, _, _ := unstructured.NestedSlice(group.Object, "spec", "endpoints")
+	for _, entry := range endpoints {
+		fields := entry.(map[string]interface{})
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		entries[fields["dnsName"].(string)+" "+fields["recordType"].(string)] = targets
+	}
+	return entries
+}
+
+func TestGroupByRequester(t *testing.T) {
+	client := newFakeClient(Options{GroupByRequester: true})
+	groupA, groupB := groupResourceName(routerA), groupResourceName(routerB)
+	if groupA != "requester-192-168-1-1-router-a" {
+		t.Fatalf("groupResourceName() = %s", groupA)
+	}
+
+	named := func(updateType update.UpdateType, name, ip string) *update.DNSUpdate {
+		upd := testUpdate(updateType, ip)
+		upd.Name = name
+		return upd
+	}
+
+	steps := []struct {
+		name string
+		req  Requester
+		upd  *update.DNSUpdate
+		a, b map[string][]string
+	}{
+		{"first record", routerA, named(update.UpdateTypeCreate, "web.example.com.", "192.0.2.10"),
+			map[string][]string{"web.example.com. A": {"192.0.2.10"}}, nil},
+		{"second record", routerA, named(update.UpdateTypeCreate, "nas.example.com.", "192.0.2.11"),
+			map[string][]string{"web.example.com. A": {"192.0.2.10"}, "nas.example.com. A": {"192.0.2.11"}}, nil},
+		{"record moves", routerA, named(update.UpdateTypeUpdate, "web.example.com.", "192.0.2.12"),
+			map[string][]string{"web.example.com. A": {"192.0.2.12"}, "nas.example.com. A": {"192.0.2.11"}}, nil},
+		{"target added", routerA, named(update.UpdateTypeCreate, "web.example.com.", "192.0.2.13"),
+			map[string][]string{"web.example.com. A": {"192.0.2.12", "192.0.2.13"}, "nas.example.com. A": {"192.0.2.11"}}, nil},
+		{"name taken by another requester", routerB, named(update.UpdateTypeCreate, "nas.example.com.", "192.0.2.20"),
+			map[string][]string{"web.example.com. A": {"192.0.2.12", "192.0.2.13"}}, map[string][]string{"nas.example.com. A": {"192.0.2.20"}}},
+		{"delete of another target", routerA, named(update.UpdateTypeDelete, "web.example.com.", "192.0.2.99"),
+			map[string][]string{"web.example.com. A": {"192.0.2.12", "192.0.2.13"}}, map[string][]string{"nas.example.com. A": {"192.0.2.20"}}},
+		{"delete of a target", routerA, named(update.UpdateTypeDelete, "web.example.com.", "192.0.2.13"),
+			map[string][]string{"web.example.com. A": {"192.0.2.12"}}, map[string][]string{"nas.example.com. A": {"192.0.2.20"}}},
+		{"last record deleted", routerA, named(update.UpdateTypeDelete, "web.example.com.", ""),
+			nil, map[string][]string{"nas.example.com. A": {"192.0.2.20"}}},
+	}
+
+	for _, step := range steps {
+		if _, err := client.ApplyUpdate(step.req, step.upd); err != nil {
+			t.Fatalf("%s: ApplyUpdate() failed: %v", step.name, err)
+		}
+		if got := groupEntries(t, client, groupA); !reflect.DeepEqual(got, step.a) {
+			t.Errorf("%s: group A = %v, want %v", step.name, got, step.a)
+		}
+		if got := groupEntries(t, client, groupB); !reflect.DeepEqual(got, step.b) {
+			t.Errorf("%s: group B = %v, want %v", step.name, got, step.b)
+		}
+	}
+
+	rrsets, err := client.LookupRRsets("nas.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("LookupRRsets() failed: %v", err)
+	}
+	if !reflect.DeepEqual(rrsets[dns.TypeA], []string{"192.0.2.20"}) {
+		t.Errorf("LookupRRsets() = %v", rrsets)
+	}
+}
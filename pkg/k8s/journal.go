@@ -0,0 +1,303 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// JournalMemory is the path keeping the journal in memory only
+const JournalMemory = "memory"
+
+// journalRewriteEvery is the number of messages written between two rewrites of
+// the journal file without them. Messages written again after a crash leave the
+// same records.
+const journalRewriteEvery = 100
+
+// ErrJournalFull is returned when the journal holds its maximum of messages
+var ErrJournalFull = errors.New("write journal full")
+
+// Journal queues the messages that could not be written while the API server was
+// unavailable, in order, so that they are written once it is back. The messages are
+// appended to a file, synced before they are acknowledged, unless the journal is
+// kept in memory.
+type Journal struct {
+	path        string
+	maxMessages int
+
+	mu       sync.Mutex
+	messages []journalMessage
+	file     *os.File
+}
+
+// journalMessage is a queued message, the updates of a requester
+type journalMessage struct {
+	Time time.Time `json:"time"`
+	// Addr is the remote address of the requester
+	Addr    string              `json:"addr"`
+	Key     string              `json:"key,omitempty"`
+	Country string              `json:"country,omitempty"`
+	ASN     uint                `json:"asn,omitempty"`
+	Updates []*update.DNSUpdate `json:"updates"`
+}
+
+// requester returns the requester of a queued message
+func (m journalMessage) requester() Requester {
+	return Requester{Addr: importAddr(m.Addr), KeyName: m.Key, Country: m.Country, ASN: m.ASN}
+}
+
+// OpenJournal opens the journal at path, or in memory, holding at most
+// maxMessages messages. The messages of a journal file are loaded to be written
+// again.
+func OpenJournal(path string, maxMessages int) (*Journal, error) {
+	j := &Journal{path: path, maxMessages: maxMessages}
+	if path == JournalMemory {
+		return j, nil
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write journal: %w", err)
+	}
+	j.file = file
+	if len(j.messages) > 0 {
+		logrus.Infof("Write journal %s holds %d message(s) to write", path, len(j.messages))
+	}
+	return j, nil
+}
+
+// load reads the messages of the journal file. A last line cut by a crash is
+// dropped, it was never acknowledged.
+func (j *Journal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open write journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if len(raw) > 0 && raw[len(raw)-1] != '\n' {
+			logrus.Warnf("Dropping the truncated last message of write journal %s", j.path)
+			break
+		}
+		if len(raw) > 0 {
+			var message journalMessage
+			if err := json.Unmarshal(raw, &message); err != nil {
+				return fmt.Errorf("invalid write journal %s, line %d: %w", j.path, line, err)
+			}
+			j.messages = append(j.messages, message)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Accepts checks if the updates of a message can be queued: replaying them
+// leaves the same records whenever and however often they are written. ACME
+// challenges are awaited by their client and never queued.
+func (j *Journal) Accepts(updates []*update.DNSUpdate) bool {
+	for _, upd := range updates {
+		if upd.RecordType == typeTXT {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of queued messages
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.messages)
+}
+
+// Queue appends the updates of a requester to the journal, and returns once
+// they are on disk
+func (j *Journal) Queue(req Requester, updates []*update.DNSUpdate) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.messages) >= j.maxMessages {
+		metrics.JournalMessages.WithLabelValues("refused").Inc()
+		return fmt.Errorf("%w: %d messages", ErrJournalFull, len(j.messages))
+	}
+
+	message := journalMessage{Time: time.Now().UTC(), Key: req.KeyName, Country: req.Country, ASN: req.ASN, Updates: updates}
+	if req.Addr != nil {
+		message.Addr = req.Addr.String()
+	}
+	if j.file != nil {
+		line, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to encode journal message: %w", err)
+		}
+		if _, err := j.file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write journal message: %w", err)
+		}
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync write journal: %w", err)
+		}
+	}
+	j.messages = append(j.messages, message)
+	metrics.JournalMessages.WithLabelValues("queued").Inc()
+	return nil
+}
+
+// message returns the queued message at index i
+func (j *Journal) message(i int) (journalMessage, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if i >= len(j.messages) {
+		return journalMessage{}, false
+	}
+	return j.messages[i], true
+}
+
+// remove removes the n oldest queued messages, rewriting the journal file with
+// the others
+func (j *Journal) remove(n int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if n == 0 {
+		return nil
+	}
+	j.messages = j.messages[n:]
+	if j.file == nil {
+		return nil
+	}
+
+	tmp := j.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite write journal: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, message := range j.messages {
+		if err = encoder.Encode(message); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rewrite write journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to rewrite write journal: %w", err)
+	}
+	j.file.Close()
+	j.file = file
+	return nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// RunJournal writes the messages of the journal every interval until ctx is done
+func (c *Client) RunJournal(ctx context.Context, j *Journal, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := c.ReplayJournal(j); err != nil {
+			logrus.Warnf("Write journal waiting for the API server: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReplayJournal writes the queued messages in order and returns the number of
+// messages written. It stops at the first message failing on an unavailable API
+// server, to be written again later; a message failing otherwise, for example on
+// a conflicting owner, is logged and dropped like a deferred write.
+func (c *Client) ReplayJournal(j *Journal) (written int, err error) {
+	done := 0
+	defer func() {
+		if removeErr := j.remove(done); err == nil {
+			err = removeErr
+		}
+	}()
+	for ; ; done++ {
+		if done == journalRewriteEvery {
+			if err := j.remove(done); err != nil {
+				return written, err
+			}
+			done = 0
+		}
+		message, ok := j.message(done)
+		if !ok {
+			if written > 0 {
+				logrus.Infof("Wrote %d message(s) of the write journal", written)
+			}
+			return written, nil
+		}
+
+		req := message.requester()
+		err := c.Retry(func() error {
+			return c.writeMessage(req, message.Updates)
+		})
+		switch {
+		case errors.Is(err, dnserr.ErrBackendUnavailable):
+			return written, err
+		case err != nil:
+			logrus.Errorf("Failed to write journal message of %s queued at %s: %v", req.Addr, message.Time.Format(time.RFC3339), err)
+			metrics.JournalMessages.WithLabelValues("failed").Inc()
+		default:
+			metrics.JournalMessages.WithLabelValues("replayed").Inc()
+			written++
+		}
+	}
+}
+
+// writeMessage writes the updates of a message as a transaction
+func (c *Client) writeMessage(req Requester, updates []*update.DNSUpdate) error {
+	unlock, err := c.LockUpdates(req, updates)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := c.BeginUpdates(req, updates)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Commit(tx.Len())
+	return err
+}
@@ -0,0 +1,132 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestJournalReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "journal")
+	journal, err := OpenJournal(path, 10)
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router-a."}
+	first := testUpdate(update.UpdateTypeUpdate, "192.0.2.10")
+	second := testUpdate(update.UpdateTypeUpdate, "192.0.2.20")
+	for _, upd := range []*update.DNSUpdate{first, second} {
+		if err := journal.Queue(req, []*update.DNSUpdate{upd}); err != nil {
+			t.Fatalf("Queue() failed: %v", err)
+		}
+	}
+	journal.Close()
+
+	// The messages outlive a restart
+	journal, err = OpenJournal(path, 10)
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	defer journal.Close()
+	if journal.Len() != 2 {
+		t.Fatalf("Expected 2 messages loaded, got %d", journal.Len())
+	}
+
+	client := newFakeClient(Options{})
+	down := true
+	client.dynamicClient.(*fake.FakeDynamicClient).PrependReactor("*", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if down {
+			return true, nil, apierrors.NewServiceUnavailable("down")
+		}
+		return false, nil, nil
+	})
+	if written, err := client.ReplayJournal(journal); !errors.Is(err, dnserr.ErrBackendUnavailable) || written != 0 {
+		t.Errorf("Expected the replay to wait for the API server, got %d, %v", written, err)
+	}
+	if journal.Len() != 2 {
+		t.Errorf("Expected the messages to be kept, got %d", journal.Len())
+	}
+
+	down = false
+	if written, err := client.ReplayJournal(journal); err != nil || written != 2 {
+		t.Fatalf("Expected the 2 messages to be written, got %d, %v", written, err)
+	}
+	if journal.Len() != 0 {
+		t.Errorf("Expected an empty journal, got %d messages", journal.Len())
+	}
+	if raw, err := os.ReadFile(path); err != nil || len(raw) != 0 {
+		t.Errorf("Expected an empty journal file, got %q, %v", raw, err)
+	}
+
+	// The messages were written in order, as their requester
+	obj, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	targets := endpoints[0].(map[string]interface{})["targets"].([]interface{})
+	if len(targets) != 1 || targets[0] != "192.0.2.20" {
+		t.Errorf("Expected the target of the last message, got %v", targets)
+	}
+	if got := obj.GetAnnotations()[annotationClient]; got != "192.168.1.1" {
+		t.Errorf("Expected the requester of the message, got %q", got)
+	}
+}
+
+func TestJournalLoadTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	content := `{"time":"2026-01-01T00:00:00Z","addr":"192.168.1.1:5353","updates":[{"Type":1,"RecordType":1,"Name":"test.example.com.","Zone":"example.com.","IP":"192.0.2.10","TTL":300}]}
+{"time":"2026-01-01T00:00:01Z","addr":"192.168.1.1:53`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	journal, err := OpenJournal(path, 10)
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	defer journal.Close()
+	if journal.Len() != 1 {
+		t.Errorf("Expected the truncated message to be dropped, got %d messages", journal.Len())
+	}
+
+	if err := os.WriteFile(path, []byte("not json\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := OpenJournal(path, 10); err == nil {
+		t.Error("Expected an invalid journal to be refused")
+	}
+}
+
+func TestJournalLimits(t *testing.T) {
+	journal, err := OpenJournal(JournalMemory, 1)
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}}
+	if err := journal.Queue(req, []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.10")}); err != nil {
+		t.Fatalf("Queue() failed: %v", err)
+	}
+	if err := journal.Queue(req, []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.20")}); !errors.Is(err, ErrJournalFull) {
+		t.Errorf("Expected a full journal, got %v", err)
+	}
+
+	challenge := &update.DNSUpdate{Type: update.UpdateTypeCreate, RecordType: dns.TypeTXT, Name: "_acme-challenge.example.com.", Zone: "example.com.", Text: []string{"token"}}
+	if journal.Accepts([]*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.10"), challenge}) {
+		t.Error("Expected ACME challenges not to be queued")
+	}
+}
@@ -0,0 +1,80 @@
+package k8s
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// resourceLocks serializes the updates of each resource, so that an update never
+// reads a resource another update is writing, while the updates of different
+// resources run in parallel. The locks are per replica.
+type resourceLocks struct {
+	mu    sync.Mutex
+	locks map[string]*resourceLock
+}
+
+// resourceLock is the lock of a resource and the number of updates holding or
+// waiting for it
+type resourceLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// newResourceLocks creates the locks of the resources
+func newResourceLocks() *resourceLocks {
+	return &resourceLocks{locks: make(map[string]*resourceLock)}
+}
+
+// lock locks resources, in order so that updates locking several of them never
+// wait for each other, and returns the function unlocking them
+func (l *resourceLocks) lock(keys ...string) func() {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	held := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		l.mu.Lock()
+		r, ok := l.locks[key]
+		if !ok {
+			r = &resourceLock{}
+			l.locks[key] = r
+		}
+		r.refs++
+		l.mu.Unlock()
+		r.mu.Lock()
+		held = append(held, key)
+	}
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, key := range held {
+			r := l.locks[key]
+			r.mu.Unlock()
+			r.refs--
+			if r.refs == 0 {
+				delete(l.locks, key)
+			}
+		}
+	}
+}
+
+// LockUpdates locks the resources updates are written to, until the returned
+// function is called. Lock them before staging a transaction and release them
+// once it is committed or failed, so that the updates of other messages wait
+// for it.
+func (c *Client) LockUpdates(req Requester, updates []*update.DNSUpdate) (func(), error) {
+	keys := make([]string, len(updates))
+	for i, upd := range updates {
+		rc, err := c.forRecord(upd.Name, upd.Zone)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = rc.throttleKey(req, upd)
+	}
+	return c.locks.lock(keys...), nil
+}
@@ -0,0 +1,101 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestResourceLocks(t *testing.T) {
+	locks := newResourceLocks()
+	unlock := locks.lock("default/a", "default/b", "default/a")
+
+	// Other resources are not held up
+	done := make(chan struct{})
+	go func() {
+		locks.lock("default/c")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected another resource to be locked at once")
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		locks.lock("default/b")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("Expected a locked resource to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the resource to be locked once released")
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected the released locks to be forgotten, got %d", len(locks.locks))
+	}
+}
+
+func TestConcurrentUpdatesOfAName(t *testing.T) {
+	client := newFakeClient(Options{})
+	var mu sync.Mutex
+	writes := 0
+	client.dynamicClient.(*fake.FakeDynamicClient).PrependReactor("*", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		switch action.GetVerb() {
+		case "get":
+			// Let the other updates run between the read and the write
+			time.Sleep(time.Millisecond)
+		case "create", "update":
+			mu.Lock()
+			writes++
+			mu.Unlock()
+		}
+		return false, nil, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP(fmt.Sprintf("192.168.1.%d", i))}}
+			if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, fmt.Sprintf("192.0.2.%d", i))); err != nil {
+				t.Errorf("ApplyUpdate() failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each update read the resource written by the previous one: none was refused
+	if writes != 10 {
+		t.Errorf("Expected 10 writes, got %d", writes)
+	}
+
+	obj, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(context.Background(), "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	targets := endpoints[0].(map[string]interface{})["targets"].([]interface{})
+	if len(targets) != 10 {
+		t.Errorf("Expected the targets of the 10 updates, got %v", targets)
+	}
+}
@@ -0,0 +1,105 @@
+package k8s
+
+import (
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// Annotations of the DNSEndpoint of a name describing its last update, exact where
+// the resource name and the labels are sanitized
+const (
+	annotationDNSName    = "ddnsbridge4extdns/dns-name"
+	annotationZone       = "ddnsbridge4extdns/zone"
+	annotationRecordType = "ddnsbridge4extdns/record-type"
+	annotationUpdatedAt  = "ddnsbridge4extdns/updated-at"
+	annotationKey        = "ddnsbridge4extdns/key"
+	annotationClient     = "ddnsbridge4extdns/client"
+)
+
+// metadataAnnotations are the annotations replaced by every update of a name
+var metadataAnnotations = []string{
+	annotationDNSName, annotationZone, annotationRecordType,
+	annotationUpdatedAt, annotationKey, annotationClient,
+}
+
+// setUpdateMetadata annotates the DNSEndpoint of a name with its name and zone,
+// and the record type, time, TSIG key and client of an update
+func setUpdateMetadata(endpoint *unstructured.Unstructured, req Requester, upd *update.DNSUpdate, now time.Time) {
+	annotations := endpoint.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for _, key := range metadataAnnotations {
+		delete(annotations, key)
+	}
+	annotations[annotationDNSName] = strings.ToLower(strings.TrimSuffix(upd.Name, "."))
+	annotations[annotationZone] = strings.ToLower(strings.TrimSuffix(upd.Zone, "."))
+	annotations[annotationRecordType] = recordTypeString(upd.RecordType)
+	annotations[annotationUpdatedAt] = now.UTC().Format(time.RFC3339)
+	if upd.RecordType == typeANY {
+		annotations[annotationRecordType] = "ANY"
+	}
+	if req.KeyName != "" {
+		annotations[annotationKey] = strings.TrimSuffix(req.KeyName, ".")
+	}
+	if ip := req.IP(); ip != "" {
+		annotations[annotationClient] = ip
+	}
+	endpoint.SetAnnotations(annotations)
+}
+
+// keepUpdateMetadata completes the annotations of a DNSEndpoint carrying the
+// metadata of an update with the other annotations of the existing one, such as
+// its DHCID
+func keepUpdateMetadata(existing, endpoint *unstructured.Unstructured) {
+	annotations := endpoint.GetAnnotations()
+	if _, ok := annotations[annotationUpdatedAt]; !ok {
+		return
+	}
+	for key, value := range existing.GetAnnotations() {
+		if _, ok := annotations[key]; !ok && !isMetadataAnnotation(key) {
+			annotations[key] = value
+		}
+	}
+	endpoint.SetAnnotations(annotations)
+}
+
+// annotationsMatch reports if a DNSEndpoint keeps the annotations of the existing
+// one, the time of the update aside: refreshing a record without changes is not a
+// change
+func annotationsMatch(existing, endpoint *unstructured.Unstructured) bool {
+	desired := endpoint.GetAnnotations()
+	if desired == nil {
+		return true
+	}
+	current := existing.GetAnnotations()
+	if len(current) != len(desired) {
+		return false
+	}
+	for key, value := range desired {
+		if currentValue, ok := current[key]; !ok || (currentValue != value && key != annotationUpdatedAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// isMetadataAnnotation reports if an annotation describes the last update of a name
+func isMetadataAnnotation(key string) bool {
+	for _, metadata := range metadataAnnotations {
+		if key == metadata {
+			return true
+		}
+	}
+	return false
+}
+
+// updatedName returns the name of the records of a DNSEndpoint as annotated by
+// its last update, or an empty string
+func updatedName(obj *unstructured.Unstructured) string {
+	return obj.GetAnnotations()[annotationDNSName]
+}
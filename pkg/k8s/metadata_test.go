@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestUpdateMetadata(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "router-a."}
+	client := newFakeClient(Options{})
+	get := func() map[string]string {
+		obj, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get() failed: %v", err)
+		}
+		return obj.GetAnnotations()
+	}
+
+	create := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+	if _, err := client.ApplyUpdate(req, create); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	annotations := get()
+	want := map[string]string{
+		annotationDNSName:    "test.example.com",
+		annotationZone:       "example.com",
+		annotationRecordType: "A",
+		annotationKey:        "router-a",
+		annotationClient:     "192.168.1.1",
+	}
+	for key, value := range want {
+		if annotations[key] != value {
+			t.Errorf("Annotation %s = %q, want %q", key, annotations[key], value)
+		}
+	}
+	if annotations[annotationUpdatedAt] == "" {
+		t.Error("Expected the time of the update to be annotated")
+	}
+
+	dhcid := &update.DNSUpdate{Type: update.UpdateTypeCreate, RecordType: typeDHCID, Name: create.Name, Zone: create.Zone, Target: "AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA="}
+	if _, err := client.ApplyUpdate(req, dhcid); err != nil {
+		t.Fatalf("ApplyUpdate(DHCID) failed: %v", err)
+	}
+	updatedAt := get()[annotationUpdatedAt]
+
+	// Refreshing the record changes nothing
+	if changed, err := client.ApplyUpdate(req, create); err != nil || changed {
+		t.Errorf("Expected a refresh not to change the DNSEndpoint, got %v, %v", changed, err)
+	}
+
+	other := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.2")}}
+	aaaa := testUpdate(update.UpdateTypeCreate, "2001:db8::10")
+	aaaa.Name, aaaa.RecordType = create.Name, dns.TypeAAAA
+	if changed, err := client.ApplyUpdate(other, aaaa); err != nil || !changed {
+		t.Fatalf("Expected the AAAA record to change the DNSEndpoint, got %v, %v", changed, err)
+	}
+	annotations = get()
+	if annotations[annotationRecordType] != "AAAA" || annotations[annotationClient] != "192.168.1.2" {
+		t.Errorf("Expected the annotations of the last update, got %v", annotations)
+	}
+	if _, ok := annotations[annotationKey]; ok {
+		t.Errorf("Expected no key for an update without TSIG, got %q", annotations[annotationKey])
+	}
+	if annotations[annotationDHCID] != dhcid.Target {
+		t.Errorf("Expected the DHCID to be kept, got %v", annotations)
+	}
+	if annotations[annotationUpdatedAt] < updatedAt {
+		t.Errorf("Expected the time of the update to move forward, got %s after %s", annotations[annotationUpdatedAt], updatedAt)
+	}
+}
+
+func TestUpdatedNameCollision(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}}
+	client := newFakeClient(Options{})
+	dashed := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+	dashed.Name = "foo-bar.example.com."
+	if _, err := client.ApplyUpdate(req, dashed); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	obj, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "foo-bar", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	if got := updatedName(obj); got != "foo-bar.example.com" {
+		t.Errorf("updatedName() = %q, want foo-bar.example.com", got)
+	}
+	if err := client.checkResourceName(ctx, "foo-bar", "foo-bar.example.com."); err != nil {
+		t.Errorf("Expected no collision with the annotated name itself, got %v", err)
+	}
+	if err := client.checkResourceName(ctx, "foo-bar", "foo.bar.example.com."); err == nil {
+		t.Error("Expected a name collision with the annotated name")
+	}
+}
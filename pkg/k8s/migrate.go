@@ -0,0 +1,235 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MigrateLayout rewrites the managed DNSEndpoints to the naming layout of the client:
+// one DNSEndpoint per name relative to the longest matching zone, or one per requester
+// when grouping. Records are written to their new resource before the old one is
+// deleted, so they stay published during the migration. It returns the names of the
+// migrated resources; with dryRun nothing is changed.
+func (c *Client) MigrateLayout(ctx context.Context, zones []string, dryRun bool) ([]string, error) {
+	if c.spansNamespaces() {
+		return nil, fmt.Errorf("layout migration is not supported with a namespace template or zone namespaces")
+	}
+	if c.groupByRequester {
+		return c.migrateToGroups(ctx, dryRun)
+	}
+
+	migrated, err := c.splitGroups(ctx, zones, dryRun)
+	if err != nil {
+		return migrated, err
+	}
+	// Fragments left by former naming strategies end up in the canonical resource
+	compacted, err := c.compactEndpoints(ctx, zones, dryRun)
+	migrated = append(migrated, compacted...)
+	sort.Strings(migrated)
+	return migrated, err
+}
+
+// migratableEndpoints lists the managed DNSEndpoints the layout applies to,
+// leaving out the projections of DynamicRecords and the ACME challenges
+func (c *Client) migratableEndpoints(ctx context.Context) ([]*unstructured.Unstructured, error) {
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNSEndpoints: %w", err)
+	}
+
+	items := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		item := &list.Items[i]
+		if len(item.GetOwnerReferences()) > 0 || item.GetLabels()[labelChallenge] == "true" {
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].GetName() < items[j].GetName()
+	})
+	return items, nil
+}
+
+// migrateToGroups moves the entries of per-name DNSEndpoints into the group of the
+// requester that last wrote them
+func (c *Client) migrateToGroups(ctx context.Context, dryRun bool) ([]string, error) {
+	items, err := c.migratableEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := make([]string, 0)
+	for _, item := range items {
+		itemLabels := item.GetLabels()
+		askBy, ok := itemLabels[labelAskBy]
+		if itemLabels[labelGroup] == "true" || !ok {
+			continue
+		}
+		name := groupName(askBy, itemLabels[labelKey])
+		if name == item.GetName() {
+			continue
+		}
+		if dryRun {
+			migrated = append(migrated, item.GetName())
+			continue
+		}
+
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		groupLabels := copyLabels(item)
+		delete(groupLabels, labelZone)
+		groupLabels[labelGroup] = "true"
+		if err := c.addToEndpoint(ctx, name, groupLabels, entries, true); err != nil {
+			return migrated, err
+		}
+		if err := c.deleteMigrated(ctx, item.GetName(), name); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, item.GetName())
+	}
+	return migrated, nil
+}
+
+// splitGroups moves the entries of DNSEndpoint groups into the DNSEndpoint of their name.
+// Entries outside of the zones stay in their group.
+func (c *Client) splitGroups(ctx context.Context, zones []string, dryRun bool) ([]string, error) {
+	items, err := c.migratableEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := make([]string, 0)
+	for _, item := range items {
+		if item.GetLabels()[labelGroup] != "true" {
+			continue
+		}
+
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		byName := make(map[string][]interface{})
+		zoneOf := make(map[string]string)
+		remaining := make([]interface{}, 0)
+		for _, entry := range entries {
+			fields, _ := entry.(map[string]interface{})
+			dnsName, _ := fields["dnsName"].(string)
+			dnsName = strings.ToLower(strings.TrimSuffix(dnsName, "."))
+			canonical := c.canonicalResourceName(dnsName, zones)
+			if canonical == "" {
+				remaining = append(remaining, entry)
+				continue
+			}
+			byName[canonical] = append(byName[canonical], entry)
+			zoneOf[canonical] = longestZone(dnsName, zones)
+		}
+		if len(byName) == 0 {
+			continue
+		}
+		if dryRun {
+			migrated = append(migrated, item.GetName())
+			continue
+		}
+
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			nameLabels := copyLabels(item)
+			delete(nameLabels, labelGroup)
+			if zone := zoneOf[name]; zone != "" {
+				nameLabels[labelZone] = sanitizeLabel(zone)
+			}
+			if err := c.addToEndpoint(ctx, name, nameLabels, byName[name], false); err != nil {
+				return migrated, err
+			}
+		}
+
+		if len(remaining) > 0 {
+			updated := item.DeepCopy()
+			if err := unstructured.SetNestedSlice(updated.Object, remaining, "spec", "endpoints"); err != nil {
+				return migrated, fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+			}
+			if _, err := c.upsertEndpoint(ctx, updated); err != nil {
+				return migrated, err
+			}
+			logrus.Warnf("Kept %d entries outside of the allowed zones in DNSEndpoint %s/%s", len(remaining), c.namespace, item.GetName())
+		} else if err := c.deleteMigrated(ctx, item.GetName(), fmt.Sprintf("%d DNSEndpoint(s)", len(names))); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, item.GetName())
+	}
+	return migrated, nil
+}
+
+// addToEndpoint writes entries into a DNSEndpoint, created with the given labels when
+// missing. The entries replace those of the same name and record type when replace is
+// set; otherwise the entries already present win.
+func (c *Client) addToEndpoint(ctx context.Context, resourceName string, endpointLabels map[string]interface{}, entries []interface{}, replace bool) error {
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil && !isNotFoundError(err) {
+		return fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+
+	merged := make([]interface{}, 0, len(entries))
+	endpoint := c.newEndpoint(resourceName, endpointLabels, "", "", 0, nil)
+	if err == nil {
+		endpoint = existing.DeepCopy()
+		merged, _, _ = unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	}
+	for _, entry := range entries {
+		fields, _ := entry.(map[string]interface{})
+		dnsName, _ := fields["dnsName"].(string)
+		recordType, _ := fields["recordType"].(string)
+
+		kept := make([]interface{}, 0, len(merged)+1)
+		present := false
+		for _, current := range merged {
+			if entryMatches(current, dnsName, recordType, "") {
+				present = true
+				if !replace {
+					kept = append(kept, current)
+				}
+				continue
+			}
+			kept = append(kept, current)
+		}
+		if replace || !present {
+			kept = append(kept, entry)
+		}
+		merged = kept
+	}
+	sortEntries(merged)
+
+	if err := unstructured.SetNestedSlice(endpoint.Object, merged, "spec", "endpoints"); err != nil {
+		return fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+	}
+	_, err = c.upsertEndpoint(ctx, endpoint)
+	return err
+}
+
+// copyLabels returns a copy of the labels of a resource
+func copyLabels(u *unstructured.Unstructured) map[string]interface{} {
+	copied := make(map[string]interface{})
+	for k, v := range getLabels(u) {
+		copied[k] = v
+	}
+	return copied
+}
+
+// deleteMigrated deletes a DNSEndpoint whose entries were moved
+func (c *Client) deleteMigrated(ctx context.Context, resourceName, destination string) error {
+	if err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Delete(ctx, resourceName, metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+		return fmt.Errorf("failed to delete DNSEndpoint %s: %w", resourceName, err)
+	}
+	logrus.Infof("Migrated DNSEndpoint %s/%s into %s", c.namespace, resourceName, destination)
+	return nil
+}
@@ -0,0 +1,90 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	ctx := context.Background()
+	zones := []string{"example.com"}
+	perName := newFakeClient(Options{})
+	grouped := newClient(perName.dynamicClient, Options{Namespace: "default", GroupByRequester: true})
+
+	for _, step := range []struct {
+		req  Requester
+		name string
+		ip   string
+	}{
+		{routerA, "web.example.com.", "192.0.2.10"},
+		{routerA, "nas.example.com.", "192.0.2.11"},
+		{routerB, "printer.example.com.", "192.0.2.20"},
+	} {
+		upd := testUpdate(update.UpdateTypeCreate, step.ip)
+		upd.Name = step.name
+		if _, err := perName.ApplyUpdate(step.req, upd); err != nil {
+			t.Fatalf("ApplyUpdate(%s) failed: %v", step.name, err)
+		}
+	}
+
+	resourceNames := func() []string {
+		list, err := perName.dynamicClient.Resource(endpointGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("List() failed: %v", err)
+		}
+		names := make([]string, 0, len(list.Items))
+		for _, item := range list.Items {
+			names = append(names, item.GetName())
+		}
+		return names
+	}
+
+	// A dry run changes nothing
+	migrated, err := grouped.MigrateLayout(ctx, zones, true)
+	if err != nil {
+		t.Fatalf("MigrateLayout() failed: %v", err)
+	}
+	if want := []string{"nas", "printer", "web"}; !reflect.DeepEqual(migrated, want) {
+		t.Errorf("MigrateLayout() dry run = %v, want %v", migrated, want)
+	}
+	if got := resourceNames(); !reflect.DeepEqual(got, []string{"nas", "printer", "web"}) {
+		t.Errorf("Expected dry run to keep the resources, got %v", got)
+	}
+
+	// Per-name resources are grouped per requester
+	if _, err := grouped.MigrateLayout(ctx, zones, false); err != nil {
+		t.Fatalf("MigrateLayout() failed: %v", err)
+	}
+	groupA, groupB := groupResourceName(routerA), groupResourceName(routerB)
+	if got := resourceNames(); !reflect.DeepEqual(got, []string{groupA, groupB}) {
+		t.Errorf("Expected groups after migration, got %v", got)
+	}
+	want := map[string][]string{"nas.example.com. A": {"192.0.2.11"}, "web.example.com. A": {"192.0.2.10"}}
+	if got := groupEntries(t, grouped, groupA); !reflect.DeepEqual(got, want) {
+		t.Errorf("group A = %v, want %v", got, want)
+	}
+
+	// Groups are split back into per-name resources
+	migrated, err = perName.MigrateLayout(ctx, zones, false)
+	if err != nil {
+		t.Fatalf("MigrateLayout() failed: %v", err)
+	}
+	if !reflect.DeepEqual(migrated, []string{groupA, groupB}) {
+		t.Errorf("MigrateLayout() = %v, want the groups", migrated)
+	}
+	if got := resourceNames(); !reflect.DeepEqual(got, []string{"nas", "printer", "web"}) {
+		t.Errorf("Expected per-name resources after migration, got %v", got)
+	}
+	rrsets, err := perName.LookupRRsets("web.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("LookupRRsets() failed: %v", err)
+	}
+	if len(rrsets) != 1 {
+		t.Errorf("LookupRRsets() = %v, want the A record of web", rrsets)
+	}
+}
@@ -0,0 +1,39 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// DNS record types of mail exchangers and name servers (dns.TypeMX and dns.TypeNS)
+const (
+	typeMX = 15
+	typeNS = 2
+)
+
+// mxTarget returns the DNSEndpoint target of an MX update, "preference exchange" as
+// ExternalDNS expects it, or an empty string when deleting the RRset
+func mxTarget(upd *update.DNSUpdate) string {
+	if upd.Target == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d %s", upd.Priority, upd.Target)
+}
+
+// parseMXTarget sets the preference and exchange of an MX update from a DNSEndpoint
+// target
+func parseMXTarget(upd *update.DNSUpdate, target string) error {
+	fields := strings.Fields(target)
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid MX target %q, expected preference and exchange", target)
+	}
+	preference, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid MX target %q: %w", target, err)
+	}
+	upd.Priority, upd.Target = uint16(preference), fields[1]
+	return nil
+}
@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestApplyMXAndNSUpdates(t *testing.T) {
+	tests := []struct {
+		name     string
+		upd      *update.DNSUpdate
+		expected []string
+	}{
+		{"MX", &update.DNSUpdate{RecordType: dns.TypeMX, Priority: 10, Target: "mail.example.com."}, []string{"10 mail.example.com."}},
+		{"NS", &update.DNSUpdate{RecordType: dns.TypeNS, Target: "ns1.test.example.com."}, []string{"ns1.test.example.com."}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(Options{})
+			upd := tt.upd
+			upd.Type, upd.Name, upd.Zone, upd.TTL = update.UpdateTypeCreate, "test.example.com.", "example.com.", 300
+			if _, err := client.ApplyUpdate(routerA, upd); err != nil {
+				t.Fatalf("ApplyUpdate() failed: %v", err)
+			}
+			rrsets, err := client.LookupRRsets("test.example.com.", "example.com.")
+			if err != nil {
+				t.Fatalf("LookupRRsets() failed: %v", err)
+			}
+			if got := rrsets[upd.RecordType]; !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, rrsets)
+			}
+		})
+	}
+}
+
+func TestParseMXTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		expected  *update.DNSUpdate
+		shouldErr bool
+	}{
+		{"valid", "10 mail.example.com.", &update.DNSUpdate{Priority: 10, Target: "mail.example.com."}, false},
+		{"missing preference", "mail.example.com.", nil, true},
+		{"preference out of range", "70000 mail.example.com.", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upd := &update.DNSUpdate{}
+			err := parseMXTarget(upd, tt.target)
+			if (err != nil) != tt.shouldErr {
+				t.Fatalf("parseMXTarget() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+			if !tt.shouldErr && !reflect.DeepEqual(upd, tt.expected) {
+				t.Errorf("parseMXTarget() = %+v, expected %+v", upd, tt.expected)
+			}
+		})
+	}
+}
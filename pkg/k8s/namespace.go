@@ -0,0 +1,132 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceTemplate derives the namespace of a record from its hostname
+type NamespaceTemplate struct {
+	tmpl *template.Template
+}
+
+// NamespaceData is the data a NamespaceTemplate is executed with
+type NamespaceData struct {
+	// Name is the hostname, without trailing dot
+	Name string
+	// Zone is the zone of the update, without trailing dot
+	Zone string
+	// Labels are the labels of the hostname below the zone, leftmost first
+	Labels []string
+}
+
+// Label returns the i-th label below the zone, counted from the right when negative,
+// or an empty string when out of range
+func (d NamespaceData) Label(i int) string {
+	if i < 0 {
+		i += len(d.Labels)
+	}
+	if i < 0 || i >= len(d.Labels) {
+		return ""
+	}
+	return d.Labels[i]
+}
+
+// ParseNamespaceTemplate parses a text/template rendering the namespace of a record,
+// e.g. "dns-{{.Label -1}}"
+func ParseNamespaceTemplate(text string) (*NamespaceTemplate, error) {
+	tmpl, err := template.New("namespace").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &NamespaceTemplate{tmpl: tmpl}, nil
+}
+
+// Execute renders the namespace of a hostname in a zone
+func (t *NamespaceTemplate) Execute(name, zone string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+	zone = strings.TrimSuffix(zone, ".")
+
+	data := NamespaceData{Name: name, Zone: zone, Labels: []string{}}
+	if relative := strings.TrimSuffix(name, "."+zone); relative != name {
+		data.Labels = strings.Split(relative, ".")
+	}
+
+	var out strings.Builder
+	if err := t.tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render namespace of %s: %w", name, err)
+	}
+	namespace := strings.Trim(dnsNameToK8sName(out.String()), "-")
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return "", fmt.Errorf("invalid namespace %q rendered for %s: %s", namespace, name, strings.Join(errs, ", "))
+	}
+	return namespace, nil
+}
+
+// inNamespace returns a copy of the client managing resources in another namespace
+func (c *Client) inNamespace(namespace string) *Client {
+	scoped := *c
+	scoped.namespace = namespace
+	return &scoped
+}
+
+// forRecord returns the client managing the resources of a record, in the namespace
+// of its closest zone with one, or derived from its hostname when the namespace of
+// records is templated
+func (c *Client) forRecord(name, zone string) (*Client, error) {
+	if namespace := c.zoneNamespace(name); namespace != "" {
+		return c.inNamespace(namespace), nil
+	}
+	if c.namespaceTemplate == nil {
+		return c, nil
+	}
+	namespace, err := c.namespaceTemplate.Execute(name, zone)
+	if err != nil {
+		return nil, err
+	}
+	return c.inNamespace(namespace), nil
+}
+
+// zoneNamespace returns the namespace of the closest zone of a name in
+// ZoneNamespaces, or an empty string
+func (c *Client) zoneNamespace(name string) string {
+	zone := strings.ToLower(name)
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	for zone != "." && zone != "" {
+		if namespace, ok := c.zoneNamespaces[zone]; ok {
+			return namespace
+		}
+		zone = zone[strings.Index(zone, ".")+1:]
+	}
+	return ""
+}
+
+// spansNamespaces reports if records are written to namespaces other than the
+// namespace of the client
+func (c *Client) spansNamespaces() bool {
+	return c.namespaceTemplate != nil || len(c.zoneNamespaces) > 0
+}
+
+// displayName returns the name of a resource reported to the admin API,
+// prefixed with its namespace when records span namespaces
+func (c *Client) displayName(namespace, name string) string {
+	if c.spansNamespaces() {
+		return namespace + "/" + name
+	}
+	return name
+}
+
+// listNamespace returns the namespace the maintenance tasks list resources in:
+// every namespace when records span namespaces
+func (c *Client) listNamespace() string {
+	if c.spansNamespaces() {
+		return metav1.NamespaceAll
+	}
+	return c.namespace
+}
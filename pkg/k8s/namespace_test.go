@@ -0,0 +1,141 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestNamespaceTemplate(t *testing.T) {
+	tests := []struct {
+		template  string
+		name      string
+		namespace string
+		wantErr   bool
+	}{
+		{"dns-{{.Label 0}}", "web.example.com.", "dns-web", false},
+		{"dns-{{.Label -1}}", "web.team_a.example.com.", "dns-team-a", false},
+		{"{{.Label 1}}-{{.Zone}}", "web.Team.example.com.", "team-example-com", false},
+		{"dns-{{.Label 1}}", "web.example.com.", "dns", false},
+		{"{{.Label 1}}", "web.example.com.", "", true},
+		{"{{.Label 0}}", "example.com.", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template+" "+tt.name, func(t *testing.T) {
+			tmpl, err := ParseNamespaceTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseNamespaceTemplate() failed: %v", err)
+			}
+			namespace, err := tmpl.Execute(tt.name, "example.com.")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if namespace != tt.namespace {
+				t.Errorf("Execute() = %q, want %q", namespace, tt.namespace)
+			}
+		})
+	}
+
+	if _, err := ParseNamespaceTemplate("dns-{{.Label"); err == nil {
+		t.Error("Expected error for an invalid template")
+	}
+}
+
+func TestApplyUpdateNamespaceTemplate(t *testing.T) {
+	ctx := context.Background()
+	tmpl, err := ParseNamespaceTemplate("dns-{{.Label -1}}")
+	if err != nil {
+		t.Fatalf("ParseNamespaceTemplate() failed: %v", err)
+	}
+	client := newFakeClient(Options{NamespaceTemplate: tmpl})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "router."}
+
+	for _, name := range []string{"web.team-a.example.com.", "web.team-b.example.com."} {
+		upd := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+		upd.Name = name
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate(%s) failed: %v", name, err)
+		}
+	}
+
+	for _, namespace := range []string{"dns-team-a", "dns-team-b"} {
+		list, err := client.dynamicClient.Resource(endpointGVR).Namespace(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("List() failed: %v", err)
+		}
+		if len(list.Items) != 1 {
+			t.Errorf("Expected 1 DNSEndpoint in %s, got %d", namespace, len(list.Items))
+		}
+	}
+
+	// Prerequisites are evaluated against the templated namespace
+	rrsets, err := client.LookupRRsets("web.team-a.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("LookupRRsets() failed: %v", err)
+	}
+	if len(rrsets[dns.TypeA]) != 1 {
+		t.Errorf("LookupRRsets() = %v, want the A record of web.team-a", rrsets)
+	}
+
+	// Garbage collection spans every namespace
+	names, err := client.CollectByRequester(ctx, RequesterSelector{Addr: "192.168.1.1"}, false)
+	if err != nil {
+		t.Fatalf("CollectByRequester() failed: %v", err)
+	}
+	want := []string{"dns-team-a/web-team-a", "dns-team-b/web-team-b"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("CollectByRequester() = %v, want %v", names, want)
+	}
+}
+
+func TestApplyUpdateZoneNamespaces(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{ZoneNamespaces: map[string]string{
+		"dyn.example.com.": "dyn-dns",
+		"lab.example.org.": "lab",
+	}})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "router."}
+
+	tests := []struct {
+		name      string
+		zone      string
+		namespace string
+	}{
+		{"host.dyn.example.com.", "example.com.", "dyn-dns"},
+		{"a.b.Lab.example.org.", "lab.example.org.", "lab"},
+		{"web.example.com.", "example.com.", "default"},
+	}
+
+	for _, tt := range tests {
+		upd := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+		upd.Name, upd.Zone = tt.name, tt.zone
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate(%s) failed: %v", tt.name, err)
+		}
+		list, err := client.dynamicClient.Resource(endpointGVR).Namespace(tt.namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("List() failed: %v", err)
+		}
+		if len(list.Items) != 1 {
+			t.Errorf("Expected the DNSEndpoint of %s in %s, got %d", tt.name, tt.namespace, len(list.Items))
+		}
+		rrsets, err := client.LookupRRsets(tt.name, tt.zone)
+		if err != nil {
+			t.Fatalf("LookupRRsets(%s) failed: %v", tt.name, err)
+		}
+		if len(rrsets) != 1 {
+			t.Errorf("Expected the records of %s to be read from %s, got %v", tt.name, tt.namespace, rrsets)
+		}
+	}
+
+	if got := client.listNamespace(); got != metav1.NamespaceAll {
+		t.Errorf("Expected maintenance tasks to list every namespace, got %q", got)
+	}
+}
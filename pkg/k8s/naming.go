@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// maxResourceName is the longest name of a resource (DNS subdomain name, RFC 1123)
+const maxResourceName = 253
+
+// relativeName returns the hostname of an update relative to its zone, or its
+// full name for the apex of the zone
+func relativeName(upd *update.DNSUpdate) string {
+	if hostname := upd.GetHostname(); hostname != "@" {
+		return hostname
+	}
+	return upd.Name
+}
+
+// hashedResourceName names a resource after the hyphenated hostname of an update,
+// followed by the first 8 bytes of the SHA-256 digest of its full DNS name, so that
+// foo-bar.example.com and foo.bar.example.com get resources of their own
+func hashedResourceName(upd *update.DNSUpdate) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSuffix(upd.Name, "."))))
+	digest := hex.EncodeToString(sum[:8])
+	name := sanitizeResourceName(relativeName(upd))
+	if name == "" {
+		return digest
+	}
+	if len(name) > maxResourceName-len(digest)-1 {
+		name = strings.TrimRight(name[:maxResourceName-len(digest)-1], "-")
+	}
+	return name + "-" + digest
+}
+
+// zonePrefixedResourceName names a resource after the hyphenated zone of an update
+// and its hyphenated hostname, separated by two hyphens: "example-com--host"
+func zonePrefixedResourceName(upd *update.DNSUpdate) string {
+	zone := sanitizeResourceName(upd.Zone)
+	hostname := upd.GetHostname()
+	if zone == "" || hostname == "@" || hostname == strings.TrimSuffix(upd.Name, ".") {
+		return sanitizeResourceName(upd.Name)
+	}
+	name := zone + "--" + dnsNameToK8sName(hostname)
+	if len(name) > maxResourceName {
+		name = name[:maxResourceName]
+	}
+	return name
+}
+
+// checkResourceName refuses to write the records of dnsName to a DNSEndpoint
+// holding the records of another name, whose name its naming strategy confused
+// with dnsName
+func (c *Client) checkResourceName(ctx context.Context, resourceName, dnsName string) error {
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+	// The annotated name is exact, the names of older resources are read from
+	// their endpoints
+	held, ok := updatedName(existing), true
+	if held == "" {
+		held, ok = singleDNSName(existing)
+	}
+	if !ok || held == strings.ToLower(strings.TrimSuffix(dnsName, ".")) {
+		return nil
+	}
+	logrus.Errorf("DNSEndpoint %s/%s of %s already holds the records of %s; RESOURCE_NAMING=hash-suffix names them apart", c.namespace, resourceName, dnsName, held)
+	return fmt.Errorf("%w: %s holds the records of %s", dnserr.ErrNameCollision, resourceName, held)
+}
@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestEndpointResourceName(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		dnsName  string
+		zone     string
+		expected string
+	}{
+		{"hyphenated", Options{}, "foo.bar.example.com.", "example.com.", "foo-bar"},
+		{"hyphenated apex", Options{}, "example.com.", "example.com.", "example-com"},
+		{"hyphenated reverse", Options{}, "1.1.168.192.in-addr.arpa.", "168.192.in-addr.arpa.", "1-1-168-192-in-addr-arpa"},
+		{"subdomain", Options{SubdomainNames: true}, "foo.bar.example.com.", "example.com.", "foo.bar.example.com"},
+		{"hash suffix", Options{HashedNames: true}, "foo.bar.example.com.", "example.com.", "foo-bar-0b90d8ccf2d94b53"},
+		{"hash suffix of a colliding name", Options{HashedNames: true}, "foo-bar.example.com.", "example.com.", "foo-bar-2d4c7a1dc23f915a"},
+		{"hash suffix apex", Options{HashedNames: true}, "Example.com.", "example.com.", "example-com-a379a6f6eeafb9a5"},
+		{"zone prefixed", Options{ZonePrefixedNames: true}, "foo.bar.example.com.", "example.com.", "example-com--foo-bar"},
+		{"zone prefixed apex", Options{ZonePrefixedNames: true}, "example.com.", "example.com.", "example-com"},
+		{"zone prefixed reverse", Options{ZonePrefixedNames: true}, "1.1.168.192.in-addr.arpa.", "168.192.in-addr.arpa.", "168-192-in-addr-arpa--1-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(tt.opts)
+			got := client.endpointResourceName(&update.DNSUpdate{Name: tt.dnsName, Zone: tt.zone})
+			if got != tt.expected {
+				t.Errorf("endpointResourceName(%s) = %q, want %q", tt.dnsName, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHashedResourceNameLength(t *testing.T) {
+	label := strings.Repeat("a", 63)
+	name := strings.Repeat(label+".", 4) + "example.com."
+	got := hashedResourceName(&update.DNSUpdate{Name: name, Zone: "example.com."})
+	if len(got) > maxResourceName {
+		t.Errorf("Expected at most %d characters, got %d", maxResourceName, len(got))
+	}
+	if digest := got[strings.LastIndex(got, "-")+1:]; len(digest) != 16 {
+		t.Errorf("Expected the name to end with the digest, got %q", got)
+	}
+}
+
+func TestResourceNameCollision(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1")}, KeyName: "router."}
+	dashed := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+	dashed.Name = "foo-bar.example.com."
+	dotted := testUpdate(update.UpdateTypeCreate, "192.0.2.20")
+	dotted.Name = "foo.bar.example.com."
+
+	client := newFakeClient(Options{})
+	if _, err := client.ApplyUpdate(req, dashed); err != nil {
+		t.Fatalf("ApplyUpdate(%s) failed: %v", dashed.Name, err)
+	}
+	if _, err := client.ApplyUpdate(req, dotted); !errors.Is(err, dnserr.ErrNameCollision) {
+		t.Errorf("Expected a name collision, got %v", err)
+	}
+	deletion := testUpdate(update.UpdateTypeDelete, "")
+	deletion.Name, deletion.RecordType = dotted.Name, typeANY
+	if changed, err := client.ApplyUpdate(req, deletion); err != nil || changed {
+		t.Errorf("Expected the deletion of %s to leave the resource of %s, got %v, %v", dotted.Name, dashed.Name, changed, err)
+	}
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "foo-bar", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the resource of %s to be kept: %v", dashed.Name, err)
+	}
+
+	hashed := newFakeClient(Options{HashedNames: true})
+	for _, upd := range []*update.DNSUpdate{dashed, dotted} {
+		if _, err := hashed.ApplyUpdate(req, upd); err != nil {
+			t.Errorf("ApplyUpdate(%s) failed with hashed names: %v", upd.Name, err)
+		}
+	}
+	list, err := hashed.dynamicClient.Resource(endpointGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("Expected a DNSEndpoint per name, got %d", len(list.Items))
+	}
+}
@@ -0,0 +1,112 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RunEndpointWatch calls sync with the records published by the DNSEndpoints matching
+// a label selector (every DNSEndpoint when empty), once they are listed and after
+// every change, until ctx is done. Changes made while sync runs are coalesced into the
+// next call, and the periodic resync calls it again so failed syncs are retried.
+func (c *Client) RunEndpointWatch(ctx context.Context, selector string, sync func([]Record)) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, recordResyncPeriod, c.listNamespace(),
+		func(options *metav1.ListOptions) { options.LabelSelector = selector })
+	informer := factory.ForResource(c.gvr).Informer()
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(_, _ interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register DNSEndpoint handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync DNSEndpoint cache")
+	}
+	logrus.Infof("DNSEndpoint watch started in namespace %q (selector: %q)", c.listNamespace(), selector)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		}
+		sync(publishedRecords(informer.GetStore().List()))
+	}
+}
+
+// publishedRecords returns the records of DNSEndpoints by name and type, sorted. The
+// targets of a name and type published by several DNSEndpoints are merged, with the
+// lowest TTL.
+func publishedRecords(objects []interface{}) []Record {
+	type rrsetKey struct{ name, recordType string }
+	byKey := make(map[rrsetKey]*Record)
+	for _, obj := range objects {
+		item, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		for _, entry := range entries {
+			fields, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			dnsName, _, _ := unstructured.NestedString(fields, "dnsName")
+			recordType, _, _ := unstructured.NestedString(fields, "recordType")
+			ttl, _, _ := unstructured.NestedInt64(fields, "recordTTL")
+			targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+			if dnsName == "" || recordType == "" || len(targets) == 0 {
+				continue
+			}
+
+			key := rrsetKey{strings.ToLower(strings.TrimSuffix(dnsName, ".")), strings.ToUpper(recordType)}
+			record, ok := byKey[key]
+			if !ok {
+				record = &Record{Name: key.name, Type: key.recordType, TTL: ttl}
+				byKey[key] = record
+			} else if ttl > 0 && (record.TTL == 0 || ttl < record.TTL) {
+				record.TTL = ttl
+			}
+			for _, target := range targets {
+				if !containsString(record.Targets, target) {
+					record.Targets = append(record.Targets, target)
+				}
+			}
+		}
+	}
+
+	records := make([]Record, 0, len(byKey))
+	for _, record := range byKey {
+		sort.Strings(record.Targets)
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Type < records[j].Type
+	})
+	return records
+}
@@ -0,0 +1,42 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testPublishedEndpoint(name string, endpoints ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "externaldns.k8s.io/v1alpha1",
+		"kind":       "DNSEndpoint",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       map[string]interface{}{"endpoints": endpoints},
+	}}
+}
+
+func TestPublishedRecords(t *testing.T) {
+	objects := []interface{}{
+		testPublishedEndpoint("web",
+			map[string]interface{}{"dnsName": "WWW.example.com.", "recordType": "A", "recordTTL": int64(300), "targets": []interface{}{"192.0.2.2"}},
+			map[string]interface{}{"dnsName": "www.example.com", "recordType": "TXT", "targets": []interface{}{"owner=cluster"}},
+		),
+		testPublishedEndpoint("web-2",
+			map[string]interface{}{"dnsName": "www.example.com", "recordType": "A", "recordTTL": int64(60), "targets": []interface{}{"192.0.2.1", "192.0.2.2"}},
+			map[string]interface{}{"dnsName": "empty.example.com", "recordType": "A", "targets": []interface{}{}},
+		),
+		testPublishedEndpoint("alias",
+			map[string]interface{}{"dnsName": "app.example.com", "recordType": "CNAME", "targets": []interface{}{"www.example.com"}},
+		),
+	}
+
+	want := []Record{
+		{Name: "app.example.com", Type: "CNAME", Targets: []string{"www.example.com"}},
+		{Name: "www.example.com", Type: "A", TTL: 60, Targets: []string{"192.0.2.1", "192.0.2.2"}},
+		{Name: "www.example.com", Type: "TXT", Targets: []string{"owner=cluster"}},
+	}
+	if got := publishedRecords(objects); !reflect.DeepEqual(got, want) {
+		t.Errorf("publishedRecords() = %+v, want %+v", got, want)
+	}
+}
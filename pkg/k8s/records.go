@@ -0,0 +1,431 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// DynamicRecord CRD owned by the bridge
+var recordGVR = schema.GroupVersionResource{
+	Group:    "ddnsbridge4extdns.io",
+	Version:  "v1alpha1",
+	Resource: "dynamicrecords",
+}
+
+// projectedLabels are copied from a DynamicRecord to its DNSEndpoint
+var projectedLabels = []string{labelUnreachable, labelCountry, labelASN}
+
+// recordResyncPeriod is how often every DynamicRecord is projected again,
+// which also retries projections that previously failed
+const recordResyncPeriod = 5 * time.Minute
+
+// Phases reported in the DynamicRecord status
+const (
+	RecordPhasePending   = "Pending"
+	RecordPhaseProjected = "Projected"
+	RecordPhaseFailed    = "Failed"
+)
+
+// applyRecord writes a DNS update to a DynamicRecord resource. The records of a
+// name share its DynamicRecord, one endpoints entry per record type, as in a
+// DNSEndpoint.
+func (c *Client) applyRecord(ctx context.Context, req Requester, upd *update.DNSUpdate) (changed bool, err error) {
+	resourceName := c.hostResourceName(upd)
+	records := c.dynamicClient.Resource(recordGVR).Namespace(c.namespace)
+
+	switch upd.Type {
+	case update.UpdateTypeCreate, update.UpdateTypeUpdate:
+	case update.UpdateTypeDelete:
+		existing, err := records.Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			if isNotFoundError(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to get DynamicRecord: %w", err)
+		}
+		if c.checksOwnership() {
+			if err := c.checkOwnership(existing, req); err != nil {
+				return false, err
+			}
+		}
+		entries, ok := withoutRecord(recordEntries(existing), upd)
+		// A record of another type, or another target, is left untouched
+		if !ok {
+			logrus.Debugf("DynamicRecord %s/%s does not hold the deleted record", c.namespace, resourceName)
+			return false, nil
+		}
+		// Deleting the records of one type keeps the entries of the other types
+		if len(entries) > 0 {
+			updated := existing.DeepCopy()
+			if err := setRecordEntries(updated, entries); err != nil {
+				return false, err
+			}
+			if _, err := records.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+				return false, fmt.Errorf("failed to update DynamicRecord: %w", err)
+			}
+			logrus.Infof("Removed %s records of %s from DynamicRecord %s/%s", recordTypeString(upd.RecordType), upd.Name, c.namespace, resourceName)
+			return true, nil
+		}
+		// The projected DNSEndpoint is owned by the record and garbage collected with it
+		err = records.Delete(ctx, resourceName, metav1.DeleteOptions{})
+		if err != nil {
+			if !isNotFoundError(err) {
+				return false, fmt.Errorf("failed to delete DynamicRecord: %w", err)
+			}
+		} else {
+			logrus.Infof("Successfully deleted DynamicRecord %s/%s", c.namespace, resourceName)
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported update type: %v", upd.Type)
+	}
+
+	record := c.newRecord(resourceName, req, upd)
+
+	existing, err := records.Get(ctx, resourceName, metav1.GetOptions{})
+	if err == nil {
+		if err := c.checkOwnership(existing, req); err != nil {
+			return false, err
+		}
+		// Keep the approval decision taken on the existing record
+		approved, _, _ := unstructured.NestedBool(existing.Object, "spec", "approved")
+		if err := unstructured.SetNestedField(record.Object, approved, "spec", "approved"); err != nil {
+			return false, fmt.Errorf("failed to set DynamicRecord approval: %w", err)
+		}
+		// An add joins the targets of the record of the same type, and the
+		// entries of the other types are kept
+		current := existing.DeepCopy()
+		if err := setRecordEntries(current, recordEntries(existing)); err != nil {
+			return false, err
+		}
+		if targets, ok := typeTargets(current, recordTypeString(upd.RecordType)); ok && joinsRRset(upd) {
+			entries, _, _ := unstructured.NestedSlice(record.Object, "spec", "endpoints")
+			entries[0].(map[string]interface{})["targets"] = addTarget(targets, recordTarget(upd))
+			if err := setRecordEntries(record, entries); err != nil {
+				return false, err
+			}
+		}
+		if err := withOtherRRsets(current, record); err != nil {
+			return false, err
+		}
+		flagged := existing.GetLabels()[labelUnreachable] == "true"
+		if reflect.DeepEqual(getSpec(existing), getSpec(record)) && flagged == upd.Unreachable {
+			logrus.Debugf("DynamicRecord already up to date, skipping update: %s/%s", c.namespace, resourceName)
+			return false, nil
+		}
+
+		record.SetResourceVersion(existing.GetResourceVersion())
+		labels := existing.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for k, v := range record.GetLabels() {
+			labels[k] = v
+		}
+		if req.KeyName == "" {
+			delete(labels, labelKey)
+		}
+		if !upd.Unreachable {
+			delete(labels, labelUnreachable)
+		}
+		if req.Country == "" {
+			delete(labels, labelCountry)
+		}
+		if req.ASN == 0 {
+			delete(labels, labelASN)
+		}
+		record.SetLabels(labels)
+		if _, err := records.Update(ctx, record, metav1.UpdateOptions{}); err != nil {
+			return false, fmt.Errorf("failed to update DynamicRecord: %w", err)
+		}
+		logrus.Debugf("Successfully updated DynamicRecord %s/%s", c.namespace, resourceName)
+		return true, nil
+	}
+	if !isNotFoundError(err) {
+		return false, fmt.Errorf("failed to get DynamicRecord: %w", err)
+	}
+
+	if _, err := records.Create(ctx, record, metav1.CreateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to create DynamicRecord: %w", err)
+	}
+	logrus.Infof("Successfully created DynamicRecord %s/%s (approved: %v)", c.namespace, resourceName, c.autoApprove)
+
+	return true, nil
+}
+
+// newRecord builds a DynamicRecord from a DNS update
+func (c *Client) newRecord(resourceName string, req Requester, upd *update.DNSUpdate) *unstructured.Unstructured {
+	labels := map[string]interface{}{
+		labelManagedBy: managedByValue,
+		labelAskBy:     sanitizeLabel(req.IP()),
+	}
+	if req.KeyName != "" {
+		labels[labelKey] = sanitizeLabel(req.KeyName)
+	}
+	if upd.Unreachable {
+		labels[labelUnreachable] = "true"
+	}
+	req.setGeoLabels(labels)
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": recordGVR.GroupVersion().String(),
+			"kind":       "DynamicRecord",
+			"metadata": map[string]interface{}{
+				"name":      resourceName,
+				"namespace": c.namespace,
+				"labels":    labels,
+			},
+			"spec": map[string]interface{}{
+				"dnsName": upd.Name,
+				"zone":    upd.Zone,
+				"endpoints": []interface{}{
+					map[string]interface{}{
+						"dnsName":    upd.Name,
+						"recordType": recordTypeString(upd.RecordType),
+						"recordTTL":  int64(upd.TTL),
+						"targets":    []interface{}{recordTarget(upd)},
+					},
+				},
+				"requester": req.IP(),
+				"keyName":   req.KeyName,
+				"approved":  c.autoApprove,
+			},
+		},
+	}
+}
+
+// recordEntries returns the endpoints entries of a DynamicRecord, one per record
+// type. Records written by earlier versions hold the fields of their single
+// record type in their spec, read as its only entry.
+func recordEntries(record *unstructured.Unstructured) []interface{} {
+	spec := getSpec(record)
+	if entries, ok, _ := unstructured.NestedSlice(spec, "endpoints"); ok {
+		return entries
+	}
+	recordType, _, _ := unstructured.NestedString(spec, "recordType")
+	if recordType == "" {
+		return nil
+	}
+	dnsName, _, _ := unstructured.NestedString(spec, "dnsName")
+	ttl, _, _ := unstructured.NestedInt64(spec, "recordTTL")
+	targets, _, _ := unstructured.NestedSlice(spec, "targets")
+	return []interface{}{
+		map[string]interface{}{
+			"dnsName":    dnsName,
+			"recordType": recordType,
+			"recordTTL":  ttl,
+			"targets":    targets,
+		},
+	}
+}
+
+// setRecordEntries sets the endpoints entries of a DynamicRecord, dropping the
+// single record type fields of earlier versions
+func setRecordEntries(record *unstructured.Unstructured, entries []interface{}) error {
+	if err := unstructured.SetNestedSlice(record.Object, entries, "spec", "endpoints"); err != nil {
+		return fmt.Errorf("failed to set DynamicRecord endpoints: %w", err)
+	}
+	for _, field := range []string{"recordType", "recordTTL", "targets"} {
+		unstructured.RemoveNestedField(record.Object, "spec", field)
+	}
+	return nil
+}
+
+// withoutRecord returns the entries of a DynamicRecord without the records a delete
+// removes: a single target, the RRset of its type, or every RRset with typeANY.
+// Entries left without target are dropped. It reports whether the delete matched
+// a record.
+func withoutRecord(entries []interface{}, upd *update.DNSUpdate) ([]interface{}, bool) {
+	recordType, target := matchedType(upd), recordTarget(upd)
+	kept := make([]interface{}, 0, len(entries))
+	matched := false
+	for _, entry := range entries {
+		if !entryMatches(entry, upd.Name, recordType, target) {
+			kept = append(kept, entry)
+			continue
+		}
+		matched = true
+		if target == "" {
+			continue
+		}
+		fields := entry.(map[string]interface{})
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		remaining := make([]interface{}, 0, len(targets))
+		for _, t := range targets {
+			if t != target {
+				remaining = append(remaining, t)
+			}
+		}
+		if len(remaining) > 0 {
+			fields["targets"] = remaining
+			kept = append(kept, fields)
+		}
+	}
+	return kept, matched
+}
+
+// RunRecordController projects DynamicRecords into DNSEndpoints until ctx is done
+func (c *Client) RunRecordController(ctx context.Context) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, recordResyncPeriod, c.listNamespace(), nil)
+	informer := factory.ForResource(recordGVR).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.reconcileRecord(ctx, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			c.reconcileRecord(ctx, obj)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register DynamicRecord handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync DynamicRecord cache")
+	}
+	logrus.Infof("DynamicRecord controller started in namespace %q", c.listNamespace())
+
+	<-ctx.Done()
+	return nil
+}
+
+// reconcileRecord projects a DynamicRecord and reports the outcome in its status
+func (c *Client) reconcileRecord(ctx context.Context, obj interface{}) {
+	record, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	// Project the record next to it
+	phase, message, changed, err := c.inNamespace(record.GetNamespace()).projectRecord(ctx, record)
+	if err != nil {
+		logrus.Errorf("Failed to project DynamicRecord %s/%s: %v", record.GetNamespace(), record.GetName(), err)
+		phase, message = RecordPhaseFailed, err.Error()
+	}
+
+	if err := c.updateRecordStatus(ctx, record, phase, message, changed); err != nil {
+		logrus.Errorf("Failed to update DynamicRecord %s/%s status: %v", record.GetNamespace(), record.GetName(), err)
+	}
+}
+
+// projectRecord creates, updates or removes the DNSEndpoint of a DynamicRecord
+func (c *Client) projectRecord(ctx context.Context, record *unstructured.Unstructured) (phase, message string, changed bool, err error) {
+	spec := getSpec(record)
+	resourceName := record.GetName()
+
+	if approved, _, _ := unstructured.NestedBool(spec, "approved"); !approved {
+		// Withdraw a DNSEndpoint projected before the approval was revoked
+		existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err == nil && isOwnedBy(existing, record) {
+			if err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Delete(ctx, resourceName, metav1.DeleteOptions{}); err != nil && !isNotFoundError(err) {
+				return "", "", false, fmt.Errorf("failed to delete DNSEndpoint: %w", err)
+			}
+			logrus.Infof("Withdrew DNSEndpoint %s/%s of unapproved DynamicRecord", c.namespace, resourceName)
+			changed = true
+		}
+		return RecordPhasePending, "awaiting approval", changed, nil
+	}
+
+	dnsName, _, _ := unstructured.NestedString(spec, "dnsName")
+	zone, _, _ := unstructured.NestedString(spec, "zone")
+	requester, _, _ := unstructured.NestedString(spec, "requester")
+	keyName, _, _ := unstructured.NestedString(spec, "keyName")
+	entries := recordEntries(record)
+	if dnsName == "" || len(entries) == 0 {
+		return "", "", false, fmt.Errorf("DynamicRecord spec requires dnsName and endpoints")
+	}
+	for _, entry := range entries {
+		fields, _ := entry.(map[string]interface{})
+		if targets, _, _ := unstructured.NestedStringSlice(fields, "targets"); len(targets) == 0 {
+			return "", "", false, fmt.Errorf("DynamicRecord endpoints require targets")
+		}
+		// The entries of records edited declaratively may omit their name
+		fields["dnsName"] = dnsName
+	}
+
+	labels := c.endpointLabels(zone, requester, keyName)
+	for _, key := range projectedLabels {
+		if value, ok := record.GetLabels()[key]; ok {
+			labels[key] = value
+		}
+	}
+	// The DNSEndpoint holds the entries of the record, one per record type
+	endpoint := c.newEndpoint(resourceName, labels, dnsName, "", 0, nil)
+	sortEntries(entries)
+	if err := unstructured.SetNestedSlice(endpoint.Object, entries, "spec", "endpoints"); err != nil {
+		return "", "", false, fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+	}
+	endpoint.SetOwnerReferences([]metav1.OwnerReference{recordOwnerReference(record)})
+
+	changed, err = c.upsertEndpoint(ctx, endpoint)
+	if err != nil {
+		return "", "", false, err
+	}
+	return RecordPhaseProjected, fmt.Sprintf("projected to DNSEndpoint %s", resourceName), changed, nil
+}
+
+// updateRecordStatus writes the status of a DynamicRecord when it changed
+func (c *Client) updateRecordStatus(ctx context.Context, record *unstructured.Unstructured, phase, message string, changed bool) error {
+	current, _, _ := unstructured.NestedMap(record.Object, "status")
+	status := map[string]interface{}{}
+	for k, v := range current {
+		status[k] = v
+	}
+	status["phase"] = phase
+	status["message"] = message
+	status["observedGeneration"] = record.GetGeneration()
+	if phase == RecordPhaseProjected {
+		status["endpoint"] = record.GetName()
+	} else {
+		delete(status, "endpoint")
+	}
+	if changed {
+		status["lastProjectedTime"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if reflect.DeepEqual(current, status) {
+		return nil
+	}
+
+	updated := record.DeepCopy()
+	if err := unstructured.SetNestedMap(updated.Object, status, "status"); err != nil {
+		return err
+	}
+	_, err := c.dynamicClient.Resource(recordGVR).Namespace(record.GetNamespace()).UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// recordOwnerReference makes a DynamicRecord the controller of its DNSEndpoint
+func recordOwnerReference(record *unstructured.Unstructured) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: record.GetAPIVersion(),
+		Kind:       record.GetKind(),
+		Name:       record.GetName(),
+		UID:        record.GetUID(),
+		Controller: &controller,
+	}
+}
+
+// isOwnedBy checks if a resource is controlled by the given owner
+func isOwnedBy(obj, owner *unstructured.Unstructured) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+var endpointGVR = schema.GroupVersionResource{
+	Group:    "externaldns.k8s.io",
+	Version:  "v1alpha1",
+	Resource: "dnsendpoints",
+}
+
+func newFakeClient(opts Options, objects ...runtime.Object) *Client {
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		endpointGVR: "DNSEndpointList",
+		recordGVR:   "DynamicRecordList",
+	}, objects...)
+	if opts.Namespace == "" {
+		opts.Namespace = "default"
+	}
+	return newClient(dynamicClient, opts)
+}
+
+func testUpdate(updateType update.UpdateType, ip string) *update.DNSUpdate {
+	return &update.DNSUpdate{
+		Type:       updateType,
+		RecordType: dns.TypeA,
+		Name:       "test.example.com.",
+		Zone:       "example.com.",
+		IP:         net.ParseIP(ip),
+		TTL:        300,
+	}
+}
+
+func TestApplyRecord(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{DynamicRecords: true, AutoApprove: false})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+
+	changed, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.100"))
+	if err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if !changed {
+		t.Error("Expected record to be created")
+	}
+
+	record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DynamicRecord not created: %v", err)
+	}
+	if approved, _, _ := unstructured.NestedBool(record.Object, "spec", "approved"); approved {
+		t.Error("Expected record to await approval")
+	}
+
+	// The endpoint must not be written directly in DynamicRecord mode
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{}); err == nil {
+		t.Error("Expected no DNSEndpoint before projection")
+	}
+
+	// Approve the record, a new update must keep the approval
+	if err := unstructured.SetNestedField(record.Object, true, "spec", "approved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Update(ctx, record, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeUpdate, "192.168.1.101")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	record, _ = client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if approved, _, _ := unstructured.NestedBool(record.Object, "spec", "approved"); !approved {
+		t.Error("Expected approval to be kept on update")
+	}
+	targets, _ := typeTargets(record, "A")
+	if len(targets) != 1 || targets[0] != "192.168.1.101" {
+		t.Errorf("Expected targets [192.168.1.101], got %v", targets)
+	}
+
+	// Deleting removes the record
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeDelete, "")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if _, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{}); err == nil {
+		t.Error("Expected DynamicRecord to be deleted")
+	}
+}
+
+func TestProjectRecord(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{DynamicRecords: true, AutoApprove: true})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.100")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DynamicRecord not created: %v", err)
+	}
+	record.SetUID("record-uid")
+
+	phase, _, changed, err := client.projectRecord(ctx, record)
+	if err != nil {
+		t.Fatalf("projectRecord() failed: %v", err)
+	}
+	if phase != RecordPhaseProjected || !changed {
+		t.Errorf("Expected projected and changed, got phase=%s changed=%v", phase, changed)
+	}
+
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DNSEndpoint not projected: %v", err)
+	}
+	if !isOwnedBy(endpoint, record) {
+		t.Error("Expected DNSEndpoint to be owned by the DynamicRecord")
+	}
+
+	// Projecting again is a no-op
+	_, _, changed, err = client.projectRecord(ctx, record)
+	if err != nil {
+		t.Fatalf("projectRecord() failed: %v", err)
+	}
+	if changed {
+		t.Error("Expected second projection to be unchanged")
+	}
+
+	// Revoking the approval withdraws the endpoint
+	if err := unstructured.SetNestedField(record.Object, false, "spec", "approved"); err != nil {
+		t.Fatal(err)
+	}
+	phase, _, _, err = client.projectRecord(ctx, record)
+	if err != nil {
+		t.Fatalf("projectRecord() failed: %v", err)
+	}
+	if phase != RecordPhasePending {
+		t.Errorf("Expected phase %s, got %s", RecordPhasePending, phase)
+	}
+	if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{}); err == nil {
+		t.Error("Expected DNSEndpoint to be withdrawn")
+	}
+}
+
+func TestUnreachableLabel(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+
+	for _, opts := range []Options{{}, {DynamicRecords: true, AutoApprove: true}} {
+		client := newFakeClient(opts)
+		gvr := endpointGVR
+		if opts.DynamicRecords {
+			gvr = recordGVR
+		}
+
+		upd := testUpdate(update.UpdateTypeCreate, "192.168.1.100")
+		upd.Unreachable = true
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+		obj, err := client.dynamicClient.Resource(gvr).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s not created: %v", gvr.Resource, err)
+		}
+		if obj.GetLabels()[labelUnreachable] != "true" {
+			t.Errorf("Expected %s to be flagged unreachable, got labels %v", gvr.Resource, obj.GetLabels())
+		}
+
+		// The flag is lifted once the target answers again
+		changed, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.100"))
+		if err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+		if !changed {
+			t.Errorf("Expected %s to be updated when the flag is lifted", gvr.Resource)
+		}
+		obj, _ = client.dynamicClient.Resource(gvr).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+		if _, ok := obj.GetLabels()[labelUnreachable]; ok {
+			t.Errorf("Expected unreachable flag on %s to be lifted", gvr.Resource)
+		}
+	}
+}
+
+func TestGeoLabels(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 5353}, Country: "FR", ASN: 3215}
+
+	client := newFakeClient(Options{})
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DNSEndpoint not created: %v", err)
+	}
+	labels := endpoint.GetLabels()
+	if labels[labelCountry] != "fr" || labels[labelASN] != "3215" {
+		t.Errorf("Expected country and ASN labels, got %v", labels)
+	}
+
+	// DynamicRecords carry the labels to their projected DNSEndpoint
+	client = newFakeClient(Options{DynamicRecords: true, AutoApprove: true})
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DynamicRecord not created: %v", err)
+	}
+	if _, _, _, err := client.projectRecord(ctx, record); err != nil {
+		t.Fatalf("projectRecord() failed: %v", err)
+	}
+	endpoint, err = client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("DNSEndpoint not projected: %v", err)
+	}
+	if labels := endpoint.GetLabels(); labels[labelCountry] != "fr" || labels[labelASN] != "3215" {
+		t.Errorf("Expected projected country and ASN labels, got %v", labels)
+	}
+}
+
+func TestApplyRecordDualStack(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+	aaaa := func(updateType update.UpdateType, ip string) *update.DNSUpdate {
+		upd := testUpdate(updateType, ip)
+		upd.RecordType = dns.TypeAAAA
+		return upd
+	}
+	// legacy is a DynamicRecord written by an earlier version, holding a single
+	// record type in its spec
+	legacy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": recordGVR.GroupVersion().String(),
+		"kind":       "DynamicRecord",
+		"metadata": map[string]interface{}{
+			"name":      "test",
+			"namespace": "default",
+			"labels":    map[string]interface{}{labelManagedBy: managedByValue, labelAskBy: "192.168.1.1", labelKey: "router"},
+		},
+		"spec": map[string]interface{}{
+			"dnsName":    "test.example.com.",
+			"zone":       "example.com.",
+			"recordType": "A",
+			"recordTTL":  int64(300),
+			"targets":    []interface{}{"192.168.1.100"},
+			"requester":  "192.168.1.1",
+			"keyName":    "router.",
+			"approved":   true,
+		},
+	}}
+
+	for _, tt := range []struct {
+		name    string
+		objects []runtime.Object
+		updates []*update.DNSUpdate
+	}{
+		{
+			name:    "A then AAAA",
+			updates: []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.168.1.100"), aaaa(update.UpdateTypeCreate, "2001:db8::100")},
+		},
+		{
+			name:    "AAAA then A",
+			updates: []*update.DNSUpdate{aaaa(update.UpdateTypeCreate, "2001:db8::100"), testUpdate(update.UpdateTypeCreate, "192.168.1.100")},
+		},
+		{
+			name:    "AAAA RRset replaced",
+			updates: []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.168.1.100"), aaaa(update.UpdateTypeCreate, "2001:db8::1"), aaaa(update.UpdateTypeUpdate, "2001:db8::100")},
+		},
+		{
+			name:    "AAAA added to an earlier record",
+			objects: []runtime.Object{legacy},
+			updates: []*update.DNSUpdate{aaaa(update.UpdateTypeCreate, "2001:db8::100")},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(Options{DynamicRecords: true, AutoApprove: true}, tt.objects...)
+			for _, upd := range tt.updates {
+				if _, err := client.ApplyUpdate(req, upd); err != nil {
+					t.Fatalf("ApplyUpdate() failed: %v", err)
+				}
+			}
+
+			record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("DynamicRecord not created: %v", err)
+			}
+			for recordType, target := range map[string]string{"A": "192.168.1.100", "AAAA": "2001:db8::100"} {
+				if targets, _ := typeTargets(record, recordType); len(targets) != 1 || targets[0] != target {
+					t.Errorf("Expected the %s record %s in the DynamicRecord, got %v", recordType, target, targets)
+				}
+			}
+			if _, ok, _ := unstructured.NestedFieldNoCopy(record.Object, "spec", "targets"); ok {
+				t.Error("Expected the single record type fields of the earlier version to be dropped")
+			}
+
+			// Both record types are projected into the DNSEndpoint
+			record.SetUID("record-uid")
+			if _, _, _, err := client.projectRecord(ctx, record); err != nil {
+				t.Fatalf("projectRecord() failed: %v", err)
+			}
+			endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("DNSEndpoint not projected: %v", err)
+			}
+			entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+			if len(entries) != 2 || singleRecordType(endpoint) != "A" {
+				t.Errorf("Expected the A and AAAA entries, ordered by type, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestDeleteRecordDualStack(t *testing.T) {
+	ctx := context.Background()
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router."}
+	client := newFakeClient(Options{DynamicRecords: true, AutoApprove: true})
+	aaaa := testUpdate(update.UpdateTypeCreate, "2001:db8::100")
+	aaaa.RecordType = dns.TypeAAAA
+	for _, upd := range []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.168.1.100"), aaaa} {
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+	get := func() *unstructured.Unstructured {
+		record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		return record
+	}
+
+	// Deleting the AAAA RRset keeps the A record
+	deleted := testUpdate(update.UpdateTypeDelete, "")
+	deleted.RecordType = dns.TypeAAAA
+	if changed, err := client.ApplyUpdate(req, deleted); err != nil || !changed {
+		t.Fatalf("ApplyUpdate() = %v, %v, expected the AAAA RRset to be deleted", changed, err)
+	}
+	record := get()
+	if record == nil {
+		t.Fatal("Expected the DynamicRecord to be kept with its A record")
+	}
+	if _, ok := typeTargets(record, "AAAA"); ok {
+		t.Error("Expected the AAAA RRset to be removed")
+	}
+	if targets, _ := typeTargets(record, "A"); len(targets) != 1 {
+		t.Errorf("Expected the A record to be kept, got %v", targets)
+	}
+
+	// Deleting the last record deletes the DynamicRecord
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeDelete, "192.168.1.100")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if get() != nil {
+		t.Error("Expected the DynamicRecord to be deleted with its last record")
+	}
+}
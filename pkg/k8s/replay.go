@@ -0,0 +1,188 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// Address record types (dns.TypeA, dns.TypeAAAA)
+const (
+	typeA    = 1
+	typeAAAA = 28
+)
+
+// ReplayFilter selects the RecordEvents replayed
+type ReplayFilter struct {
+	// Since and Until bound the time of the events, unbounded when zero
+	Since time.Time
+	Until time.Time
+	// Zone restricts the events to the names of a zone and of the zones below it
+	Zone string
+}
+
+// matches checks if the filter selects an event of a name at a time
+func (f ReplayFilter) matches(name string, at time.Time) bool {
+	if !f.Since.IsZero() && at.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && at.After(f.Until) {
+		return false
+	}
+	zone := strings.ToLower(strings.TrimSuffix(f.Zone, "."))
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// ReplayEvents applies again the changes recorded by the RecordEvents selected by
+// filter, oldest first, as if their requester sent them again, and returns a line per
+// change. It rebuilds the records after an accidental deletion of DNSEndpoints.
+// Replayed changes are not recorded again, and with dryRun nothing is written. A
+// change refused by the ownership policies is skipped; other errors stop the replay.
+func (c *Client) ReplayEvents(ctx context.Context, filter ReplayFilter, dryRun bool) ([]string, error) {
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(eventGVR).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RecordEvents: %w", err)
+	}
+
+	type replayed struct {
+		at  time.Time
+		req Requester
+		upd *update.DNSUpdate
+	}
+	events := make([]replayed, 0, len(list.Items))
+	for i := range list.Items {
+		item := &list.Items[i]
+		req, upd, err := eventUpdate(item)
+		if err != nil {
+			logrus.Warnf("Skipping RecordEvent %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+		at := eventTime(item)
+		if filter.matches(upd.Name, at) {
+			events = append(events, replayed{at, req, upd})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	// The history already holds the replayed changes
+	replayer := *c
+	replayer.recordEvents = false
+
+	applied := make([]string, 0, len(events))
+	for _, event := range events {
+		line := fmt.Sprintf("%s %s", event.at.UTC().Format(time.RFC3339), event.upd)
+		if dryRun {
+			applied = append(applied, line)
+			continue
+		}
+		rc, err := replayer.forRecord(event.upd.Name, event.upd.Zone)
+		if err != nil {
+			return applied, err
+		}
+		err = rc.Retry(func() error {
+			_, err := rc.applyUpdate(ctx, event.req, event.upd)
+			return err
+		})
+		if err != nil {
+			if errors.Is(err, dnserr.ErrNameOwned) || errors.Is(err, dnserr.ErrKeyOutranked) {
+				logrus.Warnf("Skipping replay of %s: %v", event.upd, err)
+				continue
+			}
+			return applied, fmt.Errorf("failed to replay %s: %w", line, err)
+		}
+		logrus.Infof("Replayed %s", line)
+		applied = append(applied, line)
+	}
+	return applied, nil
+}
+
+// eventUpdate rebuilds the requester and update recorded by a RecordEvent
+func eventUpdate(event *unstructured.Unstructured) (Requester, *update.DNSUpdate, error) {
+	spec := getSpec(event)
+	action, _, _ := unstructured.NestedString(spec, "action")
+	dnsName, _, _ := unstructured.NestedString(spec, "dnsName")
+	zone, _, _ := unstructured.NestedString(spec, "zone")
+	recordType, _, _ := unstructured.NestedString(spec, "recordType")
+	ttl, _, _ := unstructured.NestedInt64(spec, "recordTTL")
+	targets, _, _ := unstructured.NestedStringSlice(spec, "targets")
+	requester, _, _ := unstructured.NestedString(spec, "requester")
+	keyName, _, _ := unstructured.NestedString(spec, "keyName")
+	if dnsName == "" || zone == "" {
+		return Requester{}, nil, fmt.Errorf("dnsName and zone are required")
+	}
+
+	upd := &update.DNSUpdate{Name: dnsName, Zone: zone, TTL: uint32(ttl)}
+	switch action {
+	case update.UpdateTypeCreate.String():
+		upd.Type = update.UpdateTypeCreate
+	case update.UpdateTypeUpdate.String():
+		upd.Type = update.UpdateTypeUpdate
+	case update.UpdateTypeDelete.String():
+		upd.Type = update.UpdateTypeDelete
+	default:
+		return Requester{}, nil, fmt.Errorf("unknown action %q", action)
+	}
+
+	switch recordType {
+	case "A", "AAAA":
+		upd.RecordType = typeA
+		if recordType == "AAAA" {
+			upd.RecordType = typeAAAA
+		}
+		if len(targets) > 0 {
+			upd.IP = net.ParseIP(targets[0])
+			if upd.IP == nil {
+				return Requester{}, nil, fmt.Errorf("invalid target %q", targets[0])
+			}
+		}
+	case "SRV":
+		upd.RecordType = typeSRV
+		if len(targets) > 0 {
+			if err := parseSRVTarget(upd, targets[0]); err != nil {
+				return Requester{}, nil, err
+			}
+		}
+	case "MX":
+		upd.RecordType = typeMX
+		if len(targets) > 0 {
+			if err := parseMXTarget(upd, targets[0]); err != nil {
+				return Requester{}, nil, err
+			}
+		}
+	case "ANY":
+		upd.RecordType = typeANY
+	case "PTR", "DHCID", "CNAME", "NS":
+		upd.RecordType = recordTypeCodes[recordType]
+		if recordType == "DHCID" {
+			upd.RecordType = typeDHCID
+		}
+		if len(targets) > 0 {
+			upd.Target = targets[0]
+		}
+	default:
+		return Requester{}, nil, fmt.Errorf("unsupported record type %q", recordType)
+	}
+	if upd.Type != update.UpdateTypeDelete && upd.IP == nil && upd.Target == "" {
+		return Requester{}, nil, fmt.Errorf("%s without target", action)
+	}
+
+	req := Requester{KeyName: keyName}
+	if ip := net.ParseIP(requester); ip != nil {
+		req.Addr = &net.UDPAddr{IP: ip}
+	}
+	return req, upd, nil
+}
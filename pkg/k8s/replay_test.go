@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestReplayEvents(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeEventClient(Options{RecordEvents: true})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router"}
+
+	start := time.Now()
+	changes := []struct {
+		updateType update.UpdateType
+		name       string
+		ip         string
+	}{
+		{update.UpdateTypeCreate, "a.example.com.", "192.168.1.100"},
+		{update.UpdateTypeCreate, "b.example.com.", "192.168.1.101"},
+		{update.UpdateTypeUpdate, "a.example.com.", "192.168.1.102"},
+		{update.UpdateTypeCreate, "c.lab.example.com.", "192.168.1.103"},
+		{update.UpdateTypeDelete, "b.example.com.", ""},
+	}
+	for _, change := range changes {
+		upd := testUpdate(change.updateType, change.ip)
+		upd.Name = change.name
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+		// Events are ordered by their time
+		time.Sleep(time.Millisecond)
+	}
+
+	// Accidental mass deletion
+	endpoints := client.dynamicClient.Resource(client.gvr).Namespace("default")
+	list, _ := endpoints.List(ctx, metav1.ListOptions{})
+	for _, item := range list.Items {
+		if err := endpoints.Delete(ctx, item.GetName(), metav1.DeleteOptions{}); err != nil {
+			t.Fatalf("Delete() failed: %v", err)
+		}
+	}
+
+	lines, err := client.ReplayEvents(ctx, ReplayFilter{Zone: "Lab.Example.com."}, true)
+	if err != nil {
+		t.Fatalf("ReplayEvents() failed: %v", err)
+	}
+	if len(lines) != 1 {
+		t.Errorf("Expected the zone filter to select 1 event, got %v", lines)
+	}
+	if lines, _ := client.ReplayEvents(ctx, ReplayFilter{Until: start}, true); len(lines) != 0 {
+		t.Errorf("Expected no event before the start, got %v", lines)
+	}
+	if list, _ := endpoints.List(ctx, metav1.ListOptions{}); len(list.Items) != 0 {
+		t.Errorf("Expected dry runs to write nothing, got %d endpoints", len(list.Items))
+	}
+
+	lines, err = client.ReplayEvents(ctx, ReplayFilter{Since: start}, false)
+	if err != nil {
+		t.Fatalf("ReplayEvents() failed: %v", err)
+	}
+	if len(lines) != 5 {
+		t.Errorf("Expected 5 replayed events, got %v", lines)
+	}
+	list, _ = endpoints.List(ctx, metav1.ListOptions{})
+	targets := map[string][]string{}
+	for _, item := range list.Items {
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		for _, entry := range entries {
+			fields := entry.(map[string]interface{})
+			ips, _, _ := unstructured.NestedStringSlice(fields, "targets")
+			targets[fields["dnsName"].(string)] = ips
+		}
+	}
+	if len(targets) != 2 || len(targets["a.example.com."]) != 1 || targets["a.example.com."][0] != "192.168.1.102" || targets["c.lab.example.com."] == nil {
+		t.Errorf("Unexpected records after replay: %v", targets)
+	}
+
+	// Replayed changes are not recorded again
+	events, _ := client.dynamicClient.Resource(eventGVR).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(events.Items) != len(changes) {
+		t.Errorf("Expected %d events, got %d", len(changes), len(events.Items))
+	}
+}
+
+func TestEventUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    map[string]interface{}
+		wantErr bool
+	}{
+		{"create", map[string]interface{}{"action": "CREATE", "dnsName": "a.example.com.", "zone": "example.com.", "recordType": "AAAA", "targets": []interface{}{"2001:db8::1"}}, false},
+		{"delete whole RRset", map[string]interface{}{"action": "DELETE", "dnsName": "a.example.com.", "zone": "example.com.", "recordType": "A", "targets": []interface{}{}}, false},
+		{"ptr", map[string]interface{}{"action": "CREATE", "dnsName": "1.2.0.192.in-addr.arpa.", "zone": "2.0.192.in-addr.arpa.", "recordType": "PTR", "targets": []interface{}{"a.example.com."}}, false},
+		{"unknown action", map[string]interface{}{"action": "RENAME", "dnsName": "a.example.com.", "zone": "example.com.", "recordType": "A"}, true},
+		{"challenge", map[string]interface{}{"action": "CREATE", "dnsName": "_acme-challenge.example.com.", "zone": "example.com.", "recordType": "TXT"}, true},
+		{"invalid target", map[string]interface{}{"action": "CREATE", "dnsName": "a.example.com.", "zone": "example.com.", "recordType": "A", "targets": []interface{}{"router"}}, true},
+		{"create without target", map[string]interface{}{"action": "CREATE", "dnsName": "a.example.com.", "zone": "example.com.", "recordType": "A"}, true},
+		{"missing zone", map[string]interface{}{"action": "CREATE", "dnsName": "a.example.com.", "recordType": "A", "targets": []interface{}{"192.0.2.1"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &unstructured.Unstructured{Object: map[string]interface{}{"spec": tt.spec}}
+			_, _, err := eventUpdate(event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("eventUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
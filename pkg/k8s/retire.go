@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Marks of the resources tombstoned by a zone retirement
+const (
+	labelRetired      = "ddnsbridge4extdns/retired"
+	annotationRetired = "ddnsbridge4extdns/retired-at"
+)
+
+// RetireZone removes the managed records of a zone and of the zones below it, and
+// returns the names of their resources, prefixed with their namespace when the
+// namespace of records is templated. Resources are deleted, or tombstoned when
+// tombstone is set: they are kept, labeled as retired, but publish nothing. With
+// dryRun nothing is changed. Resources tombstoned by an earlier retirement are skipped.
+func (c *Client) RetireZone(ctx context.Context, zone string, tombstone, dryRun bool) ([]string, error) {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	if zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+
+	// In DynamicRecord mode the DNSEndpoints follow their record
+	gvr, kind := c.gvr, "DNSEndpoint"
+	if c.dynamicRecords {
+		gvr, kind = recordGVR, "DynamicRecord"
+	}
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(gvr).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %ss: %w", kind, err)
+	}
+
+	items := make([]unstructured.Unstructured, 0, len(list.Items))
+	for _, item := range list.Items {
+		if item.GetLabels()[labelRetired] == "true" {
+			continue
+		}
+		dnsName, ok := c.retiredName(&item)
+		if ok && (dnsName == zone || strings.HasSuffix(dnsName, "."+zone)) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].GetNamespace() != items[j].GetNamespace() {
+			return items[i].GetNamespace() < items[j].GetNamespace()
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, c.displayName(item.GetNamespace(), item.GetName()))
+	}
+
+	if dryRun {
+		logrus.Infof("Retirement dry run of zone %s: %d %s(s) would be removed", zone, len(names), kind)
+		return names, nil
+	}
+
+	retired := make([]string, 0, len(names))
+	for i := range items {
+		item := &items[i]
+		if tombstone {
+			c.tombstone(item, time.Now())
+			_, err = c.dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Update(ctx, item, metav1.UpdateOptions{})
+		} else {
+			err = c.dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		}
+		if err != nil && !isNotFoundError(err) {
+			return retired, fmt.Errorf("failed to retire %s %s: %w", kind, names[i], err)
+		}
+		logrus.Infof("Retired %s %s/%s of zone %s (tombstone: %v)", kind, item.GetNamespace(), item.GetName(), zone, tombstone)
+		retired = append(retired, names[i])
+	}
+	return retired, nil
+}
+
+// retiredName returns the DNS name of a managed resource, lowercased without the
+// trailing dot
+func (c *Client) retiredName(item *unstructured.Unstructured) (string, bool) {
+	if !c.dynamicRecords {
+		// Owned DNSEndpoints are projections, removed with their owner
+		if len(item.GetOwnerReferences()) > 0 {
+			return "", false
+		}
+		return singleDNSName(item)
+	}
+	dnsName, _, _ := unstructured.NestedString(item.Object, "spec", "dnsName")
+	dnsName = strings.ToLower(strings.TrimSuffix(dnsName, "."))
+	return dnsName, dnsName != ""
+}
+
+// tombstone empties a resource and marks it as retired at now. A DynamicRecord
+// loses its approval, which withdraws its DNSEndpoint.
+func (c *Client) tombstone(item *unstructured.Unstructured, now time.Time) {
+	resourceLabels := item.GetLabels()
+	if resourceLabels == nil {
+		resourceLabels = map[string]string{}
+	}
+	resourceLabels[labelRetired] = "true"
+	item.SetLabels(resourceLabels)
+
+	annotations := item.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[annotationRetired] = now.UTC().Format(time.RFC3339)
+	// A tombstone must not be removed as expired
+	delete(annotations, annotationExpires)
+	item.SetAnnotations(annotations)
+
+	if c.dynamicRecords {
+		_ = unstructured.SetNestedField(item.Object, false, "spec", "approved")
+		return
+	}
+	_ = unstructured.SetNestedSlice(item.Object, []interface{}{}, "spec", "endpoints")
+}
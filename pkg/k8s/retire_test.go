@@ -0,0 +1,105 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestRetireZone(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "router"}
+	for _, name := range []string{"a.lab.example.com.", "b.lab.example.com.", "lab.example.com.", "c.example.com.", "otherlab.example.com."} {
+		upd := testUpdate(update.UpdateTypeCreate, "192.168.1.100")
+		upd.Name = name
+		if _, err := client.ApplyUpdate(req, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+
+	if _, err := client.RetireZone(ctx, ".", false, true); err == nil {
+		t.Error("Expected an error without zone")
+	}
+
+	names, err := client.RetireZone(ctx, "Lab.Example.com.", true, true)
+	if err != nil {
+		t.Fatalf("RetireZone() failed: %v", err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("Expected dry run to select 3 endpoints, got %v", names)
+	}
+
+	names, err = client.RetireZone(ctx, "lab.example.com", true, false)
+	if err != nil {
+		t.Fatalf("RetireZone() failed: %v", err)
+	}
+	if len(names) != 3 {
+		t.Errorf("Expected 3 tombstoned endpoints, got %v", names)
+	}
+	list, _ := client.dynamicClient.Resource(client.gvr).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 5 {
+		t.Fatalf("Expected tombstones to be kept, got %d endpoints", len(list.Items))
+	}
+	tombstones := 0
+	for _, item := range list.Items {
+		if item.GetLabels()[labelRetired] != "true" {
+			continue
+		}
+		tombstones++
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		if len(entries) != 0 || item.GetAnnotations()[annotationRetired] == "" {
+			t.Errorf("Expected %s to be emptied and stamped, got %v", item.GetName(), item.Object)
+		}
+	}
+	if tombstones != 3 {
+		t.Errorf("Expected 3 tombstones, got %d", tombstones)
+	}
+	records, err := client.ExportRecords(ctx)
+	if err != nil || len(records) != 2 {
+		t.Errorf("Expected tombstones not to be exported, got %v (%v)", records, err)
+	}
+
+	// Tombstones are left alone by later retirements
+	names, err = client.RetireZone(ctx, "example.com", false, false)
+	if err != nil {
+		t.Fatalf("RetireZone() failed: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 deleted endpoints, got %v", names)
+	}
+	list, _ = client.dynamicClient.Resource(client.gvr).Namespace("default").List(ctx, metav1.ListOptions{})
+	if len(list.Items) != 3 {
+		t.Errorf("Expected the 3 tombstones to remain, got %d endpoints", len(list.Items))
+	}
+}
+
+func TestRetireZoneDynamicRecords(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{DynamicRecords: true, AutoApprove: true})
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+	upd := testUpdate(update.UpdateTypeCreate, "192.168.1.100")
+	if _, err := client.ApplyUpdate(req, upd); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	names, err := client.RetireZone(ctx, "example.com", true, false)
+	if err != nil {
+		t.Fatalf("RetireZone() failed: %v", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("Expected 1 tombstoned DynamicRecord, got %v", names)
+	}
+	record, err := client.dynamicClient.Resource(recordGVR).Namespace("default").Get(ctx, names[0], metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the DynamicRecord to be kept: %v", err)
+	}
+	if approved, _, _ := unstructured.NestedBool(record.Object, "spec", "approved"); approved {
+		t.Error("Expected the tombstoned DynamicRecord to lose its approval")
+	}
+}
@@ -0,0 +1,62 @@
+package k8s
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// retryPolicy retries the writes failing on errors a later attempt may not hit:
+// conflicts with a concurrent writer, timeouts and throttling of the API server
+type retryPolicy struct {
+	// attempts is the number of attempts of a write, 1 never retrying it
+	attempts int
+	// backoff is the wait before the first retry, doubled before each next one
+	// up to maxBackoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+	sleep      func(time.Duration)
+}
+
+// newRetryPolicy creates a policy making attempts attempts of a write
+func newRetryPolicy(attempts int, backoff, maxBackoff time.Duration) retryPolicy {
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+	return retryPolicy{attempts: attempts, backoff: backoff, maxBackoff: maxBackoff, sleep: time.Sleep}
+}
+
+// Retry calls fn until it succeeds, fails with an error that is not retriable, or
+// runs out of attempts, and returns its last error. The waits between attempts
+// grow exponentially, with jitter so that racing writers do not meet again, and
+// honor the delay the API server asks for.
+func (c *Client) Retry(fn func() error) error {
+	p := c.retry
+	delay := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.attempts || !isRetriable(err) {
+			return err
+		}
+
+		wait := delay/2 + rand.N(delay/2+1)
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > wait {
+			wait = min(time.Duration(seconds)*time.Second, p.maxBackoff)
+		}
+		metrics.BackendRetries.WithLabelValues(dnserr.Kind(err)).Inc()
+		logrus.Warnf("Kubernetes write failed (attempt %d of %d), retrying in %s: %v", attempt, p.attempts, wait, err)
+		p.sleep(wait)
+		delay = min(delay*2, p.maxBackoff)
+	}
+}
+
+// isRetriable checks if a write failed on an error a later attempt may not hit
+func isRetriable(err error) bool {
+	return errors.Is(err, dnserr.ErrBackendConflict) || errors.Is(err, dnserr.ErrBackendUnavailable)
+}
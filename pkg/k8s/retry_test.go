@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRetry(t *testing.T) {
+	resource := schema.GroupResource{Group: "externaldns.k8s.io", Resource: "dnsendpoints"}
+	conflict := classifyError(apierrors.NewConflict(resource, "test", errors.New("modified")))
+	throttled := classifyError(apierrors.NewTooManyRequests("slow down", 1))
+	forbidden := classifyError(apierrors.NewForbidden(resource, "test", errors.New("denied")))
+
+	tests := []struct {
+		name      string
+		attempts  int
+		errs      []error
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   error
+	}{
+		{"success", 3, []error{nil}, 1, nil, nil},
+		{"conflict then success", 3, []error{conflict, nil}, 2, []time.Duration{100 * time.Millisecond}, nil},
+		{"attempts exhausted", 3, []error{conflict, conflict, conflict}, 3, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, conflict},
+		{"retry after honored", 3, []error{throttled, nil}, 2, []time.Duration{time.Second}, nil},
+		{"not retriable", 3, []error{forbidden}, 1, nil, forbidden},
+		{"no retry", 1, []error{conflict}, 1, nil, conflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var waits []time.Duration
+			client := newFakeClient(Options{})
+			client.retry = newRetryPolicy(tt.attempts, 100*time.Millisecond, 2*time.Second)
+			client.retry.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+			calls := 0
+			err := client.Retry(func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if err != tt.wantErr {
+				t.Errorf("Retry() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, calls)
+			}
+			if len(waits) != len(tt.wantWaits) {
+				t.Fatalf("Expected waits of %v, got %v", tt.wantWaits, waits)
+			}
+			for i, want := range tt.wantWaits {
+				// Waits are jittered down to half of the backoff
+				if waits[i] > want || waits[i] < want/2 {
+					t.Errorf("Wait %d = %s, want between %s and %s", i+1, waits[i], want/2, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryMaxBackoff(t *testing.T) {
+	client := newFakeClient(Options{})
+	client.retry = newRetryPolicy(10, time.Second, 3*time.Second)
+	var longest time.Duration
+	client.retry.sleep = func(d time.Duration) { longest = max(longest, d) }
+
+	err := client.Retry(func() error {
+		return fmt.Errorf("write failed: %w", classifyError(apierrors.NewServerTimeout(schema.GroupResource{}, "update", 0)))
+	})
+	if !isRetriable(err) {
+		t.Errorf("Expected the last error to be returned, got %v", err)
+	}
+	if longest > 3*time.Second {
+		t.Errorf("Expected waits of at most 3s, got %s", longest)
+	}
+}
@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// joinsRRset checks if an update adds its target to the RRset of its name and type,
+// as RFC 2136 adds do. Adds superseding an RRset delete (update.UpdateTypeUpdate)
+// replace the RRset instead, and so do CNAMEs, a name having a single canonical name.
+func joinsRRset(upd *update.DNSUpdate) bool {
+	return upd.Type == update.UpdateTypeCreate && upd.RecordType != typeCNAME
+}
+
+// addTarget returns the targets of an RRset with target added, without duplicates
+// and sorted, so that the order of the adds does not change the resource
+func addTarget(targets []string, target string) []interface{} {
+	all := append([]string{target}, targets...)
+	sort.Strings(all)
+	joined := make([]interface{}, 0, len(all))
+	for i, t := range all {
+		if i == 0 || t != all[i-1] {
+			joined = append(joined, t)
+		}
+	}
+	return joined
+}
+
+// entryOfType returns the index of the endpoint entry of a record type, -1 when
+// the DNSEndpoint holds no RRset of that type
+func entryOfType(entries []interface{}, recordType string) int {
+	for i, entry := range entries {
+		fields, _ := entry.(map[string]interface{})
+		if entryType, _ := fields["recordType"].(string); entryType == recordType {
+			return i
+		}
+	}
+	return -1
+}
+
+// typeTargets returns the targets of the RRset of a record type of a DNSEndpoint,
+// and whether it holds one
+func typeTargets(endpoint *unstructured.Unstructured, recordType string) ([]string, bool) {
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	i := entryOfType(entries, recordType)
+	if i < 0 {
+		return nil, false
+	}
+	targets, _, _ := unstructured.NestedStringSlice(entries[i].(map[string]interface{}), "targets")
+	return targets, true
+}
+
+// joinExistingTargets adds the targets of the existing RRset of the same record type
+// to the single endpoint of a desired DNSEndpoint
+func (c *Client) joinExistingTargets(ctx context.Context, endpoint *unstructured.Unstructured, target string) error {
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, endpoint.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+	targets, ok := typeTargets(existing, singleRecordType(endpoint))
+	if !ok {
+		return nil
+	}
+
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	entry, _ := entries[0].(map[string]interface{})
+	entry["targets"] = addTarget(targets, target)
+	if err := unstructured.SetNestedSlice(endpoint.Object, entries, "spec", "endpoints"); err != nil {
+		return fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+	}
+	return nil
+}
+
+// keepOtherRRsets adds the RRsets of the other record types of the existing
+// DNSEndpoint to the single endpoint of a desired DNSEndpoint, so that the A and
+// AAAA records of a dual-stack name share its DNSEndpoint
+func (c *Client) keepOtherRRsets(ctx context.Context, endpoint *unstructured.Unstructured) error {
+	existing, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Get(ctx, endpoint.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get DNSEndpoint: %w", err)
+	}
+	return withOtherRRsets(existing, endpoint)
+}
+
+// withOtherRRsets adds the entries of existing of the other record types than the
+// entry of endpoint to endpoint, ordered by record type. A CNAME stands alone: it
+// replaces the other RRsets of its name, and is replaced by them.
+func withOtherRRsets(existing, endpoint *unstructured.Unstructured) error {
+	recordType := singleRecordType(endpoint)
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	if len(entries) != 1 || recordType == recordTypeString(typeCNAME) {
+		return nil
+	}
+
+	existingEntries, _, _ := unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	for _, entry := range existingEntries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if entryType, _ := fields["recordType"].(string); entryType == recordType || entryType == recordTypeString(typeCNAME) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+	if err := unstructured.SetNestedSlice(endpoint.Object, entries, "spec", "endpoints"); err != nil {
+		return fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+	}
+	return nil
+}
+
+// withoutRRset returns a copy of a DNSEndpoint without the RRset of a record type,
+// nil when no other RRset is left
+func withoutRRset(existing *unstructured.Unstructured, recordType string) (*unstructured.Unstructured, error) {
+	entries, _, _ := unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	kept := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		fields, _ := entry.(map[string]interface{})
+		if entryType, _ := fields["recordType"].(string); entryType != recordType {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	updated := existing.DeepCopy()
+	if err := unstructured.SetNestedSlice(updated.Object, kept, "spec", "endpoints"); err != nil {
+		return nil, fmt.Errorf("failed to set DNSEndpoint endpoints: %w", err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,127 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestRoundRobinTargets(t *testing.T) {
+	steps := []struct {
+		name    string
+		upd     *update.DNSUpdate
+		targets []string
+	}{
+		{"first add", testUpdate(update.UpdateTypeCreate, "192.0.2.10"), []string{"192.0.2.10"}},
+		{"second add", testUpdate(update.UpdateTypeCreate, "192.0.2.12"), []string{"192.0.2.10", "192.0.2.12"}},
+		{"sorted", testUpdate(update.UpdateTypeCreate, "192.0.2.11"), []string{"192.0.2.10", "192.0.2.11", "192.0.2.12"}},
+		{"duplicate", testUpdate(update.UpdateTypeCreate, "192.0.2.10"), []string{"192.0.2.10", "192.0.2.11", "192.0.2.12"}},
+		{"record delete", testUpdate(update.UpdateTypeDelete, "192.0.2.11"), []string{"192.0.2.10", "192.0.2.12"}},
+		{"replace", testUpdate(update.UpdateTypeUpdate, "192.0.2.20"), []string{"192.0.2.20"}},
+	}
+
+	for _, opts := range []Options{{}, {MergeTargets: true}} {
+		client := newFakeClient(opts)
+		for _, step := range steps {
+			if _, err := client.ApplyUpdate(routerA, step.upd); err != nil {
+				t.Fatalf("%s: ApplyUpdate() failed: %v", step.name, err)
+			}
+			if got := endpointTargets(t, client); !reflect.DeepEqual(got, step.targets) {
+				t.Errorf("%+v %s: targets = %v, want %v", opts, step.name, got, step.targets)
+			}
+		}
+	}
+}
+
+// endpointRRsets returns the targets of each RRset of the test DNSEndpoint, in the
+// order of its entries
+func endpointRRsets(t *testing.T, client *Client) [][]string {
+	t.Helper()
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(context.Background(), "test", metav1.GetOptions{})
+	if err != nil {
+		return nil
+	}
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	rrsets := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		fields := entry.(map[string]interface{})
+		recordType, _ := fields["recordType"].(string)
+		targets, _, _ := unstructured.NestedStringSlice(fields, "targets")
+		rrsets = append(rrsets, append([]string{recordType}, targets...))
+	}
+	return rrsets
+}
+
+func TestDualStack(t *testing.T) {
+	// typed returns an update of the test name of another record type
+	typed := func(updateType update.UpdateType, rrtype uint16, ip string) *update.DNSUpdate {
+		upd := testUpdate(updateType, ip)
+		upd.RecordType = rrtype
+		return upd
+	}
+	steps := []struct {
+		name   string
+		upd    *update.DNSUpdate
+		rrsets [][]string
+	}{
+		{"AAAA", typed(update.UpdateTypeCreate, dns.TypeAAAA, "2001:db8::1"), [][]string{{"AAAA", "2001:db8::1"}}},
+		{"A next to AAAA", testUpdate(update.UpdateTypeCreate, "192.0.2.10"), [][]string{{"A", "192.0.2.10"}, {"AAAA", "2001:db8::1"}}},
+		{"A replaced", testUpdate(update.UpdateTypeUpdate, "192.0.2.11"), [][]string{{"A", "192.0.2.11"}, {"AAAA", "2001:db8::1"}}},
+		{"AAAA joined", typed(update.UpdateTypeCreate, dns.TypeAAAA, "2001:db8::2"), [][]string{{"A", "192.0.2.11"}, {"AAAA", "2001:db8::1", "2001:db8::2"}}},
+		{"AAAA RRset deleted", typed(update.UpdateTypeDelete, dns.TypeAAAA, ""), [][]string{{"A", "192.0.2.11"}}},
+		{"AAAA again", typed(update.UpdateTypeCreate, dns.TypeAAAA, "2001:db8::1"), [][]string{{"A", "192.0.2.11"}, {"AAAA", "2001:db8::1"}}},
+		{"last A deleted", testUpdate(update.UpdateTypeDelete, "192.0.2.11"), [][]string{{"AAAA", "2001:db8::1"}}},
+		{"A again", testUpdate(update.UpdateTypeCreate, "192.0.2.10"), [][]string{{"A", "192.0.2.10"}, {"AAAA", "2001:db8::1"}}},
+		{"every RRset deleted", typed(update.UpdateTypeDelete, dns.TypeANY, ""), nil},
+	}
+
+	for _, opts := range []Options{{}, {MergeTargets: true}} {
+		client := newFakeClient(opts)
+		for _, step := range steps {
+			if _, err := client.ApplyUpdate(routerA, step.upd); err != nil {
+				t.Fatalf("%s: ApplyUpdate() failed: %v", step.name, err)
+			}
+			if got := endpointRRsets(t, client); !reflect.DeepEqual(got, step.rrsets) {
+				t.Errorf("%+v %s: RRsets = %v, want %v", opts, step.name, got, step.rrsets)
+			}
+		}
+	}
+
+	// A CNAME stands alone
+	client := newFakeClient(Options{})
+	for _, upd := range []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.10"), typed(update.UpdateTypeCreate, dns.TypeAAAA, "2001:db8::1"), cnameUpdate(update.UpdateTypeCreate, "home.dyn.example.net.")} {
+		if _, err := client.ApplyUpdate(routerA, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+	if got, want := endpointRRsets(t, client), [][]string{{"CNAME", "home.dyn.example.net."}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RRsets = %v, want %v", got, want)
+	}
+}
+
+func TestAddTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		targets  []string
+		target   string
+		expected []interface{}
+	}{
+		{"empty", nil, "192.0.2.1", []interface{}{"192.0.2.1"}},
+		{"sorted", []string{"192.0.2.3"}, "192.0.2.1", []interface{}{"192.0.2.1", "192.0.2.3"}},
+		{"duplicate", []string{"192.0.2.1", "192.0.2.3"}, "192.0.2.3", []interface{}{"192.0.2.1", "192.0.2.3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addTarget(tt.targets, tt.target); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("addTarget() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
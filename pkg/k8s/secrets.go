@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Secrets holding the TSIG secrets of TSIG_SECRET_REF
+var secretGVR = schema.GroupVersionResource{
+	Version:  "v1",
+	Resource: "secrets",
+}
+
+// TSIGSecret reads the TSIG secrets of a Secret, by key name
+func (c *Client) TSIGSecret(ctx context.Context, namespace, name string) (map[string]string, error) {
+	secret, err := c.dynamicClient.Resource(secretGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Secret %s/%s: %w", namespace, name, classifyError(err))
+	}
+	return secretData(secret)
+}
+
+// WatchTSIGSecret calls apply with the TSIG secrets of a Secret every time it
+// changes, until ctx is done. The secrets are kept when the Secret is deleted.
+func (c *Client) WatchTSIGSecret(ctx context.Context, namespace, name string, apply func(map[string]string)) error {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, recordResyncPeriod, namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = selector
+	})
+	informer := factory.ForResource(secretGVR).Informer()
+	changed := func(obj interface{}) {
+		secret, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		data, err := secretData(secret)
+		if err != nil {
+			logrus.Errorf("Ignoring Secret %s/%s: %v", namespace, name, err)
+			return
+		}
+		apply(data)
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: changed,
+		UpdateFunc: func(_, obj interface{}) {
+			changed(obj)
+		},
+		DeleteFunc: func(interface{}) {
+			logrus.Warnf("Secret %s/%s deleted, keeping its TSIG secrets", namespace, name)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register Secret handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync Secret %s/%s", namespace, name)
+	}
+	logrus.Infof("Watching TSIG secrets of Secret %s/%s", namespace, name)
+
+	<-ctx.Done()
+	return nil
+}
+
+// secretData decodes the data of a Secret, without the surrounding whitespace of
+// values written from files
+func secretData(secret *unstructured.Unstructured) (map[string]string, error) {
+	encoded, _, err := unstructured.NestedStringMap(secret.Object, "data")
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]string, len(encoded))
+	for key, value := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("data %s is not valid base64: %w", key, err)
+		}
+		data[key] = strings.TrimSpace(string(raw))
+	}
+	return data, nil
+}
@@ -0,0 +1,37 @@
+package k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestTSIGSecret(t *testing.T) {
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "tsig", "namespace": "ddns"},
+		"data": map[string]interface{}{
+			// "dGVzdC1zZWNyZXQ=\n", as written from a file
+			"test-key": "ZEdWemRDMXpaV055WlhRPQo=",
+			// "hmac-sha512:cm91dGVyLXNlY3JldA=="
+			"router-key": "aG1hYy1zaGE1MTI6Y205MWRHVnlMWE5sWTNKbGRBPT0=",
+		},
+	}}
+	client := newFakeClient(Options{}, secret)
+
+	data, err := client.TSIGSecret(context.Background(), "ddns", "tsig")
+	if err != nil {
+		t.Fatalf("TSIGSecret() failed: %v", err)
+	}
+	expected := map[string]string{"test-key": "dGVzdC1zZWNyZXQ=", "router-key": "hmac-sha512:cm91dGVyLXNlY3JldA=="}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("TSIGSecret() = %v, expected %v", data, expected)
+	}
+
+	if _, err := client.TSIGSecret(context.Background(), "ddns", "missing"); err == nil {
+		t.Error("Expected a missing Secret to fail")
+	}
+}
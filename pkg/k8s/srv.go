@@ -0,0 +1,40 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// typeSRV is the DNS record type of service locators (dns.TypeSRV)
+const typeSRV = 33
+
+// srvTarget returns the DNSEndpoint target of an SRV update, "priority weight port
+// target" as ExternalDNS expects it, or an empty string when deleting the RRset
+func srvTarget(upd *update.DNSUpdate) string {
+	if upd.Target == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d %d %d %s", upd.Priority, upd.Weight, upd.Port, upd.Target)
+}
+
+// parseSRVTarget sets the priority, weight, port and target of an SRV update from
+// a DNSEndpoint target
+func parseSRVTarget(upd *update.DNSUpdate, target string) error {
+	fields := strings.Fields(target)
+	if len(fields) != 4 {
+		return fmt.Errorf("invalid SRV target %q, expected priority, weight, port and target", target)
+	}
+	values := make([]uint16, 3)
+	for i, field := range fields[:3] {
+		value, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid SRV target %q: %w", target, err)
+		}
+		values[i] = uint16(value)
+	}
+	upd.Priority, upd.Weight, upd.Port, upd.Target = values[0], values[1], values[2], fields[3]
+	return nil
+}
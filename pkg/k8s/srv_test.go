@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// srvUpdate returns an update of the SRV record of the test name
+func srvUpdate(updateType update.UpdateType, port uint16, target string) *update.DNSUpdate {
+	return &update.DNSUpdate{
+		Type:       updateType,
+		RecordType: dns.TypeSRV,
+		Name:       "test.example.com.",
+		Zone:       "example.com.",
+		Priority:   0,
+		Weight:     100,
+		Port:       port,
+		Target:     target,
+		TTL:        600,
+	}
+}
+
+func TestApplySRVUpdates(t *testing.T) {
+	client := newFakeClient(Options{MergeTargets: true})
+	if _, err := client.ApplyUpdate(routerA, srvUpdate(update.UpdateTypeCreate, 389, "dc1.example.com.")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if _, err := client.ApplyUpdate(routerB, srvUpdate(update.UpdateTypeCreate, 389, "dc2.example.com.")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	// The records of both sources are kept
+	rrsets, err := client.LookupRRsets("test.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("LookupRRsets() failed: %v", err)
+	}
+	got := rrsets[dns.TypeSRV]
+	sort.Strings(got)
+	expected := []string{"0 100 389 dc1.example.com.", "0 100 389 dc2.example.com."}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, rrsets)
+	}
+
+	if _, err := client.ApplyUpdate(routerA, srvUpdate(update.UpdateTypeDelete, 0, "")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if targets := endpointTargets(t, client); !reflect.DeepEqual(targets, []string{"0 100 389 dc2.example.com."}) {
+		t.Errorf("Expected the record of the other source to be kept, got %v", targets)
+	}
+}
+
+func TestParseSRVTarget(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		expected  *update.DNSUpdate
+		shouldErr bool
+	}{
+		{"valid", "10 60 5060 sip.example.com.", &update.DNSUpdate{Priority: 10, Weight: 60, Port: 5060, Target: "sip.example.com."}, false},
+		{"missing port", "10 60 sip.example.com.", nil, true},
+		{"port out of range", "10 60 70000 sip.example.com.", nil, true},
+		{"negative weight", "10 -1 5060 sip.example.com.", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upd := &update.DNSUpdate{}
+			err := parseSRVTarget(upd, tt.target)
+			if (err != nil) != tt.shouldErr {
+				t.Fatalf("parseSRVTarget() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+			if !tt.shouldErr && !reflect.DeepEqual(upd, tt.expected) {
+				t.Errorf("parseSRVTarget() = %+v, expected %+v", upd, tt.expected)
+			}
+		})
+	}
+}
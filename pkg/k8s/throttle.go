@@ -0,0 +1,253 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/replica"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// throttlePruneEvery is the number of writes between two prunings of idle resources
+const throttlePruneEvery = 1024
+
+// writeThrottle writes each resource at most once per interval. Updates of a
+// resource written less than an interval ago are deferred to the end of the
+// interval, the latest update of an RRset by a requester replacing the
+// updates it deferred before.
+type writeThrottle struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	resources map[string]*throttledResource
+	writes    int
+	// replicator streams the writes to the standby replicas
+	replicator *replica.Replicator
+}
+
+// throttledResource is the write schedule of a resource
+type throttledResource struct {
+	last    time.Time
+	pending []deferredWrite
+	timer   *time.Timer
+}
+
+// deferredWrite is an update waiting for the next write of its resource
+type deferredWrite struct {
+	client *Client
+	req    Requester
+	upd    *update.DNSUpdate
+}
+
+// newWriteThrottle creates a throttle, or nil when interval is 0
+func newWriteThrottle(interval time.Duration) *writeThrottle {
+	if interval <= 0 {
+		return nil
+	}
+	return &writeThrottle{
+		interval:  interval,
+		now:       time.Now,
+		resources: make(map[string]*throttledResource),
+	}
+}
+
+// deferWrite reserves the write of a resource, or defers the update when the
+// resource was written less than an interval ago and returns true
+func (t *writeThrottle) deferWrite(key string, c *Client, req Requester, upd *update.DNSUpdate) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if !t.isThrottled(key, now) {
+		t.reserveLocked(key, now)
+		return false
+	}
+	t.queueLocked(key, now, c, req, upd)
+	return true
+}
+
+// throttled checks if the updates of a resource are deferred, without
+// reserving its write
+func (t *writeThrottle) throttled(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.isThrottled(key, t.now())
+}
+
+// reserve records the write of a resource, whose next updates are deferred
+func (t *writeThrottle) reserve(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reserveLocked(key, t.now())
+}
+
+// queue defers an update to the next write of its resource
+func (t *writeThrottle) queue(key string, c *Client, req Requester, upd *update.DNSUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queueLocked(key, t.now(), c, req, upd)
+}
+
+// isThrottled checks if a resource was written less than an interval ago or has
+// deferred updates. The lock must be held.
+func (t *writeThrottle) isThrottled(key string, now time.Time) bool {
+	r := t.resources[key]
+	return r != nil && (len(r.pending) > 0 || now.Sub(r.last) < t.interval)
+}
+
+// reserveLocked records the write of a resource at now, keeping its deferred
+// updates. The lock must be held.
+func (t *writeThrottle) reserveLocked(key string, now time.Time) {
+	t.writes++
+	if t.writes%throttlePruneEvery == 0 {
+		t.prune(now)
+	}
+	if r := t.resources[key]; r != nil {
+		r.last = now
+	} else {
+		t.resources[key] = &throttledResource{last: now}
+	}
+	t.publish(key, now)
+}
+
+// queueLocked defers an update to the end of the interval of its resource. The
+// lock must be held.
+func (t *writeThrottle) queueLocked(key string, now time.Time, c *Client, req Requester, upd *update.DNSUpdate) {
+	r := t.resources[key]
+	if r == nil {
+		r = &throttledResource{last: now}
+		t.resources[key] = r
+	}
+	kept := r.pending[:0]
+	for _, w := range r.pending {
+		if supersedes(req, upd, w) {
+			metrics.ThrottledWrites.WithLabelValues("coalesced").Inc()
+			continue
+		}
+		kept = append(kept, w)
+	}
+	r.pending = append(kept, deferredWrite{client: c, req: req, upd: upd})
+	metrics.ThrottledWrites.WithLabelValues("deferred").Inc()
+
+	if r.timer == nil {
+		r.timer = time.AfterFunc(r.last.Add(t.interval).Sub(now), func() { t.flush(key) })
+	}
+	logrus.Debugf("Deferred write of %s for %s", key, upd.String())
+}
+
+// flush writes the deferred updates of a resource
+func (t *writeThrottle) flush(key string) {
+	t.mu.Lock()
+	r := t.resources[key]
+	if r == nil {
+		t.mu.Unlock()
+		return
+	}
+	pending := r.pending
+	r.pending, r.timer, r.last = nil, nil, t.now()
+	t.publish(key, r.last)
+	t.mu.Unlock()
+
+	for _, w := range pending {
+		unlock := w.client.locks.lock(key)
+		err := w.client.Retry(func() error {
+			_, err := w.client.applyUpdate(context.Background(), w.req, w.upd)
+			return err
+		})
+		unlock()
+		if err != nil {
+			logrus.Errorf("Failed to apply deferred update %s: %v", w.upd.String(), err)
+			metrics.ThrottledWrites.WithLabelValues("failed").Inc()
+		}
+	}
+}
+
+// publish streams the write of a resource at last to the standby replicas
+func (t *writeThrottle) publish(key string, last time.Time) {
+	t.replicator.Publish(replica.Entry{Kind: replica.KindWrite, Key: key, Time: last})
+}
+
+// Snapshot returns the last writes of the resources written less than an
+// interval ago, implementing replica.State
+func (t *writeThrottle) Snapshot() []replica.Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var entries []replica.Entry
+	for key, r := range t.resources {
+		if now.Sub(r.last) < t.interval {
+			entries = append(entries, replica.Entry{Kind: replica.KindWrite, Key: key, Time: r.last})
+		}
+	}
+	return entries
+}
+
+// Apply records the write of a resource by another replica, so that the updates
+// of the resource after a failover are deferred to the end of its interval
+func (t *writeThrottle) Apply(entry replica.Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.now().Sub(entry.Time) >= t.interval {
+		return
+	}
+	r := t.resources[entry.Key]
+	if r == nil {
+		t.resources[entry.Key] = &throttledResource{last: entry.Time}
+	} else if entry.Time.After(r.last) {
+		r.last = entry.Time
+	}
+}
+
+// prune forgets the resources without deferred updates written more than an interval ago
+func (t *writeThrottle) prune(now time.Time) {
+	for key, r := range t.resources {
+		if len(r.pending) == 0 && now.Sub(r.last) >= t.interval {
+			delete(t.resources, key)
+		}
+	}
+}
+
+// supersedes checks if an update makes a deferred update of the same RRset and
+// requester pointless: the update replaces or deletes the RRset, or repeats the
+// deferred update
+func supersedes(req Requester, upd *update.DNSUpdate, w deferredWrite) bool {
+	if w.upd.RecordType != upd.RecordType || w.upd.Name != upd.Name || w.req.IP() != req.IP() || w.req.KeyName != req.KeyName {
+		return false
+	}
+	target := recordTarget(upd)
+	switch upd.Type {
+	case update.UpdateTypeUpdate:
+		return true
+	case update.UpdateTypeDelete:
+		return target == "" || recordTarget(w.upd) == target
+	}
+	return w.upd.Type == upd.Type && recordTarget(w.upd) == target
+}
+
+// ReplicateWrites streams the write schedule of the throttled resources to the
+// standby replicas, and merges the schedule of the replicas this one follows.
+// It does nothing when writes are not throttled.
+func (c *Client) ReplicateWrites(replicator *replica.Replicator) {
+	if c.throttle == nil {
+		return
+	}
+	c.throttle.replicator = replicator
+	replicator.Register(replica.KindWrite, c.throttle)
+}
+
+// throttleKey returns the resource an update is written to, which also keys the
+// lock serializing its updates
+func (c *Client) throttleKey(req Requester, upd *update.DNSUpdate) string {
+	name := c.endpointResourceName(upd)
+	switch {
+	case c.dynamicRecords:
+		name = "dynamicrecord/" + c.hostResourceName(upd)
+	case c.groupByRequester:
+		name = groupResourceName(req)
+	}
+	return c.namespace + "/" + name
+}
@@ -0,0 +1,120 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/replica"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestWriteThrottle(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{WriteInterval: time.Hour})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	client.throttle.now = func() time.Time { return now }
+
+	writes := 0
+	client.dynamicClient.(*fake.FakeDynamicClient).PrependReactor("*", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetVerb() == "create" || action.GetVerb() == "update" {
+			writes++
+		}
+		return false, nil, nil
+	})
+
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+	other := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}}
+	for _, ip := range []string{"192.168.1.100", "192.168.1.101", "192.168.1.102"} {
+		changed, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeUpdate, ip))
+		if err != nil || !changed {
+			t.Fatalf("ApplyUpdate() = %v, %v", changed, err)
+		}
+	}
+	// Updates of another requester are not coalesced with them
+	if _, err := client.ApplyUpdate(other, testUpdate(update.UpdateTypeUpdate, "192.168.1.200")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if writes != 1 {
+		t.Fatalf("Expected 1 write within the interval, got %d", writes)
+	}
+
+	key := "default/" + client.endpointResourceName(testUpdate(update.UpdateTypeCreate, ""))
+	pending := client.throttle.resources[key].pending
+	if len(pending) != 2 || pending[0].upd.IP.String() != "192.168.1.102" || pending[1].req.IP() != "192.168.1.2" {
+		t.Fatalf("Unexpected deferred updates: %v", pending)
+	}
+
+	client.throttle.resources[key].timer.Stop()
+	now = now.Add(time.Hour)
+	client.throttle.flush(key)
+	if writes != 3 {
+		t.Errorf("Expected the 2 deferred updates to be written, got %d writes", writes)
+	}
+	endpoint, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(ctx, client.endpointResourceName(testUpdate(update.UpdateTypeCreate, "")), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get() failed: %v", err)
+	}
+	entries, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	targets, _, _ := unstructured.NestedStringSlice(entries[0].(map[string]interface{}), "targets")
+	if len(targets) != 1 || targets[0] != "192.168.1.200" {
+		t.Errorf("Expected the latest update to be written, got targets %v", targets)
+	}
+
+	// The next write waits for the interval again
+	if _, err := client.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.103")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if writes != 3 {
+		t.Errorf("Expected the update to be deferred, got %d writes", writes)
+	}
+	client.throttle.resources[key].timer.Stop()
+}
+
+func TestWriteThrottleDisabled(t *testing.T) {
+	if newWriteThrottle(0) != nil {
+		t.Error("Expected no throttle without interval")
+	}
+}
+
+func TestWriteThrottleReplication(t *testing.T) {
+	active := newFakeClient(Options{WriteInterval: time.Hour})
+	standby := newFakeClient(Options{WriteInterval: time.Hour})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	active.throttle.now = func() time.Time { return now }
+	standby.throttle.now = func() time.Time { return now.Add(time.Minute) }
+
+	req := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+	if _, err := active.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.100")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	entries := active.throttle.Snapshot()
+	if len(entries) != 1 || !entries[0].Time.Equal(now) {
+		t.Fatalf("Unexpected snapshot %v", entries)
+	}
+	for _, entry := range entries {
+		standby.throttle.Apply(entry)
+	}
+	// Entries older than an interval are ignored
+	standby.throttle.Apply(replica.Entry{Kind: replica.KindWrite, Key: "default/old", Time: now.Add(-time.Hour)})
+	if _, ok := standby.throttle.resources["default/old"]; ok {
+		t.Error("Expected an expired write not to be applied")
+	}
+
+	// After a failover, the standby defers the update of the resource written by the active replica
+	if _, err := standby.ApplyUpdate(req, testUpdate(update.UpdateTypeCreate, "192.168.1.101")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	r := standby.throttle.resources[entries[0].Key]
+	if r == nil || len(r.pending) != 1 {
+		t.Fatalf("Expected the update to be deferred, got %+v", r)
+	}
+	r.timer.Stop()
+}
@@ -0,0 +1,106 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrUnauthenticated is returned when a bearer token is rejected by the TokenReview API
+var ErrUnauthenticated = errors.New("token not authenticated")
+
+// Identity is the Kubernetes user a bearer token belongs to
+type Identity struct {
+	Username string
+	UID      string
+	Groups   []string
+	Extra    map[string][]string
+}
+
+// ReviewToken authenticates a bearer token with a TokenReview, requiring it
+// to be issued for one of the given audiences
+func (c *Client) ReviewToken(ctx context.Context, token string, audiences []string) (*Identity, error) {
+	if c.authnClient == nil {
+		return nil, fmt.Errorf("token review is not available")
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: audiences,
+		},
+	}
+	result, err := c.authnClient.TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !result.Status.Authenticated {
+		if result.Status.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnauthenticated, result.Status.Error)
+		}
+		return nil, ErrUnauthenticated
+	}
+	if len(audiences) > 0 && !audienceMatches(result.Status.Audiences, audiences) {
+		return nil, fmt.Errorf("%w: audience mismatch", ErrUnauthenticated)
+	}
+
+	user := result.Status.User
+	identity := &Identity{
+		Username: user.Username,
+		UID:      user.UID,
+		Groups:   user.Groups,
+	}
+	if len(user.Extra) > 0 {
+		identity.Extra = make(map[string][]string, len(user.Extra))
+		for k, v := range user.Extra {
+			identity.Extra[k] = v
+		}
+	}
+	return identity, nil
+}
+
+// AuthorizeRequest checks with a SubjectAccessReview whether an identity may
+// use verb on the non-resource URL path
+func (c *Client) AuthorizeRequest(ctx context.Context, identity *Identity, path, verb string) (allowed bool, reason string, err error) {
+	if c.authClient == nil {
+		return false, "", fmt.Errorf("access review is not available")
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(identity.Extra))
+	for k, v := range identity.Extra {
+		extra[k] = v
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   identity.Username,
+			UID:    identity.UID,
+			Groups: identity.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: path,
+				Verb: verb,
+			},
+		},
+	}
+	result, err := c.authClient.SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to review access: %w", err)
+	}
+	return result.Status.Allowed, result.Status.Reason, nil
+}
+
+// audienceMatches reports whether the token audiences intersect the required ones
+func audienceMatches(got, want []string) bool {
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
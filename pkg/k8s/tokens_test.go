@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestReviewToken(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "valid":
+			review.Status.Authenticated = true
+			review.Status.Audiences = review.Spec.Audiences
+			review.Status.User = authenticationv1.UserInfo{Username: "alice", Groups: []string{"ops"}}
+		case "other-audience":
+			review.Status.Authenticated = true
+			review.Status.Audiences = []string{"https://kubernetes.default.svc"}
+		default:
+			review.Status.Error = "invalid bearer token"
+		}
+		return true, review, nil
+	})
+
+	client := newFakeClient(Options{})
+	client.authnClient = clientset.AuthenticationV1()
+
+	identity, err := client.ReviewToken(context.Background(), "valid", []string{"ddnsbridge4extdns"})
+	if err != nil {
+		t.Fatalf("Expected token to be authenticated, got %v", err)
+	}
+	if identity.Username != "alice" || len(identity.Groups) != 1 {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+
+	for _, token := range []string{"invalid", "other-audience"} {
+		if _, err := client.ReviewToken(context.Background(), token, []string{"ddnsbridge4extdns"}); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Expected %s token to be rejected, got %v", token, err)
+		}
+	}
+}
+
+func TestAuthorizeRequest(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "alice" && attrs.Path == "/bans" && attrs.Verb == "get"
+		return true, review, nil
+	})
+
+	client := newFakeClient(Options{})
+	client.authClient = clientset.AuthorizationV1()
+
+	tests := []struct {
+		user    string
+		verb    string
+		allowed bool
+	}{
+		{"alice", "get", true},
+		{"alice", "delete", false},
+		{"bob", "get", false},
+	}
+	for _, tt := range tests {
+		allowed, _, err := client.AuthorizeRequest(context.Background(), &Identity{Username: tt.user}, "/bans", tt.verb)
+		if err != nil {
+			t.Fatalf("AuthorizeRequest() error = %v", err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("AuthorizeRequest(%s, %s) = %v, want %v", tt.user, tt.verb, allowed, tt.allowed)
+		}
+	}
+}
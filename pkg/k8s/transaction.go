@@ -0,0 +1,199 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// Transaction writes the updates of a message together. The updates are staged in
+// memory, then their resources are written in order; a write failing rolls back
+// the writes before it, so that the message is applied entirely or not at all.
+type Transaction struct {
+	client  dynamic.Interface
+	writes  []stagedWrite
+	results []*unstructured.Unstructured
+	next    int
+
+	req     Requester
+	updates []*update.DNSUpdate
+	clients []*Client
+	changed []bool
+
+	// throttle schedules the resources of the transaction once it is committed:
+	// reserved are written now, deferred are written at the end of their interval
+	throttle *writeThrottle
+	reserved []string
+	deferred []throttledUpdate
+}
+
+// throttledUpdate is an update of a transaction deferred by WRITE_INTERVAL
+type throttledUpdate struct {
+	key    string
+	client *Client
+	upd    *update.DNSUpdate
+}
+
+// BeginUpdates stages updates as if req sent them, without writing anything. An
+// update refused by the ownership policies returns its error. Updates deferred by
+// WRITE_INTERVAL are written later, outside of the transaction, and only once
+// it is committed.
+func (c *Client) BeginUpdates(req Requester, updates []*update.DNSUpdate) (*Transaction, error) {
+	ctx := context.Background()
+	recorder := newDryRunClient(c.dynamicClient)
+	staged := *c
+	staged.dynamicClient = recorder
+	staged.throttle = nil
+	staged.recordEvents = false
+
+	tx := &Transaction{
+		client:  c.dynamicClient,
+		req:     req,
+		updates: updates,
+		clients: make([]*Client, len(updates)),
+		changed: make([]bool, len(updates)),
+
+		throttle: c.throttle,
+	}
+	for i, upd := range updates {
+		rc, err := c.forRecord(upd.Name, upd.Zone)
+		if err != nil {
+			return nil, err
+		}
+		if rc.throttle != nil && upd.RecordType != typeTXT {
+			key := rc.throttleKey(req, upd)
+			if rc.throttle.throttled(key) {
+				tx.deferred = append(tx.deferred, throttledUpdate{key: key, client: rc, upd: upd})
+				tx.changed[i] = true
+				continue
+			}
+			tx.reserved = append(tx.reserved, key)
+		}
+		src, err := staged.forRecord(upd.Name, upd.Zone)
+		if err != nil {
+			return nil, err
+		}
+		if tx.changed[i], err = src.applyUpdate(ctx, req, upd); err != nil {
+			return nil, err
+		}
+		tx.clients[i] = rc
+	}
+	tx.writes = recorder.writes()
+	tx.results = make([]*unstructured.Unstructured, len(tx.writes))
+	return tx, nil
+}
+
+// Changed checks if the update at index i changes a resource
+func (t *Transaction) Changed(i int) bool {
+	return t.changed[i]
+}
+
+// Deferred checks if the update at index i is written later, by WRITE_INTERVAL
+func (t *Transaction) Deferred(i int) bool {
+	return t.changed[i] && t.clients[i] == nil
+}
+
+// Len returns the number of resources the transaction writes
+func (t *Transaction) Len() int {
+	return len(t.writes)
+}
+
+// Commit writes the next n resources of the transaction, and returns true once
+// all of them are written. A write failing rolls back the resources already
+// written and returns its error.
+func (t *Transaction) Commit(n int) (done bool, err error) {
+	ctx := context.Background()
+	for end := min(t.next+n, len(t.writes)); t.next < end; t.next++ {
+		result, err := t.write(ctx, t.writes[t.next])
+		if err != nil {
+			logrus.Warnf("Failed to write %s, rolling back %d written resources: %v", t.writes[t.next].key.name, t.next, err)
+			t.rollback(ctx)
+			return false, classifyError(err)
+		}
+		t.results[t.next] = result
+	}
+	if t.next < len(t.writes) {
+		return false, nil
+	}
+
+	// The updates of the resources written now wait for the next interval
+	if t.throttle != nil {
+		for _, key := range t.reserved {
+			t.throttle.reserve(key)
+		}
+		for _, d := range t.deferred {
+			t.throttle.queue(d.key, d.client, t.req, d.upd)
+		}
+		t.reserved, t.deferred = nil, nil
+	}
+
+	// Keep a history of the accepted changes, without failing the message
+	for i, upd := range t.updates {
+		rc := t.clients[i]
+		if !t.changed[i] || rc == nil || !rc.recordEvents || upd.RecordType == typeTXT {
+			continue
+		}
+		if err := rc.emitEvent(ctx, t.req, upd); err != nil {
+			logrus.Errorf("Failed to emit RecordEvent for %s: %v", upd.Name, err)
+		}
+	}
+	return true, nil
+}
+
+// resource returns the resources of the namespace of a write
+func (t *Transaction) resource(w stagedWrite) dynamic.ResourceInterface {
+	return t.client.Resource(w.key.gvr).Namespace(w.key.namespace)
+}
+
+// write writes a staged resource to the cluster. Updates and deletes are refused
+// when the resource changed since it was staged.
+func (t *Transaction) write(ctx context.Context, w stagedWrite) (*unstructured.Unstructured, error) {
+	switch {
+	case w.before == nil:
+		return t.resource(w).Create(ctx, w.after, metav1.CreateOptions{})
+	case w.after == nil:
+		var options metav1.DeleteOptions
+		if rv := w.before.GetResourceVersion(); rv != "" {
+			options.Preconditions = &metav1.Preconditions{ResourceVersion: &rv}
+		}
+		return nil, t.resource(w).Delete(ctx, w.key.name, options)
+	default:
+		return t.resource(w).Update(ctx, w.after, metav1.UpdateOptions{})
+	}
+}
+
+// rollback restores the resources written, in the reverse order of their writes
+func (t *Transaction) rollback(ctx context.Context) {
+	for i := t.next - 1; i >= 0; i-- {
+		w := t.writes[i]
+		var err error
+		switch {
+		case w.before == nil:
+			err = t.resource(w).Delete(ctx, w.key.name, metav1.DeleteOptions{})
+		case w.after == nil:
+			restored := w.before.DeepCopy()
+			restored.SetResourceVersion("")
+			restored.SetUID("")
+			restored.SetCreationTimestamp(metav1.Time{})
+			restored.SetManagedFields(nil)
+			_, err = t.resource(w).Create(ctx, restored, metav1.CreateOptions{})
+		default:
+			restored := w.before.DeepCopy()
+			restored.SetResourceVersion(t.results[i].GetResourceVersion())
+			_, err = t.resource(w).Update(ctx, restored, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			logrus.Errorf("Failed to roll back %s: %v", w.key.name, err)
+			metrics.TransactionRollbacks.WithLabelValues("failed").Inc()
+			continue
+		}
+		metrics.TransactionRollbacks.WithLabelValues("restored").Inc()
+	}
+	t.next = 0
+}
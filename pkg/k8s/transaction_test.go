@@ -0,0 +1,197 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+// otherUpdate adds an address to other.example.com.
+func otherUpdate(ip string) *update.DNSUpdate {
+	upd := testUpdate(update.UpdateTypeCreate, ip)
+	upd.Name = "other.example.com."
+	return upd
+}
+
+// failCreates fails the creation of the DNSEndpoint of other.example.com.
+func failCreates(client *Client) {
+	client.dynamicClient.(*fake.FakeDynamicClient).PrependReactor("create", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if obj.GetName() == "other" {
+			return true, nil, errors.New("etcd unavailable")
+		}
+		return false, nil, nil
+	})
+}
+
+func TestTransactionRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		updates  []*update.DNSUpdate
+		expected []string
+	}{
+		{"create", "", []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.10"), otherUpdate("192.0.2.20")}, nil},
+		{"update", "192.0.2.1", []*update.DNSUpdate{testUpdate(update.UpdateTypeUpdate, "192.0.2.10"), otherUpdate("192.0.2.20")}, []string{"192.0.2.1"}},
+		{"delete", "192.0.2.1", []*update.DNSUpdate{testUpdate(update.UpdateTypeDelete, "192.0.2.1"), otherUpdate("192.0.2.20")}, []string{"192.0.2.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(Options{})
+			if tt.existing != "" {
+				if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, tt.existing)); err != nil {
+					t.Fatalf("ApplyUpdate() failed: %v", err)
+				}
+			}
+			failCreates(client)
+
+			tx, err := client.BeginUpdates(routerA, tt.updates)
+			if err != nil {
+				t.Fatalf("BeginUpdates() failed: %v", err)
+			}
+			if tx.Len() != 2 {
+				t.Fatalf("Expected 2 staged resources, got %d", tx.Len())
+			}
+			if done, err := tx.Commit(tx.Len()); done || err == nil {
+				t.Fatalf("Expected the commit to fail, got %v, %v", done, err)
+			}
+			if got := endpointTargets(t, client); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected targets %v after the rollback, got %v", tt.expected, got)
+			}
+			if _, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(context.Background(), "other", metav1.GetOptions{}); !isNotFoundError(err) {
+				t.Errorf("Expected no DNSEndpoint for other.example.com., got %v", err)
+			}
+		})
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	client := newFakeClient(Options{})
+	updates := []*update.DNSUpdate{testUpdate(update.UpdateTypeCreate, "192.0.2.10"), otherUpdate("192.0.2.20"), otherUpdate("192.0.2.21")}
+
+	tx, err := client.BeginUpdates(routerA, updates)
+	if err != nil {
+		t.Fatalf("BeginUpdates() failed: %v", err)
+	}
+	if got := endpointTargets(t, client); got != nil {
+		t.Fatalf("Expected nothing written before the commit, got %v", got)
+	}
+	if done, err := tx.Commit(1); done || err != nil {
+		t.Fatalf("Commit(1) = %v, %v, expected a resource left", done, err)
+	}
+	if done, err := tx.Commit(1); !done || err != nil {
+		t.Fatalf("Commit(1) = %v, %v, expected the transaction done", done, err)
+	}
+	for i := range updates {
+		if !tx.Changed(i) {
+			t.Errorf("Expected update %d to be changed", i)
+		}
+		if tx.Deferred(i) {
+			t.Errorf("Expected update %d to be written without WRITE_INTERVAL", i)
+		}
+	}
+	if got := endpointTargets(t, client); !reflect.DeepEqual(got, []string{"192.0.2.10"}) {
+		t.Errorf("Expected targets [192.0.2.10], got %v", got)
+	}
+	other, err := client.dynamicClient.Resource(endpointGVR).Namespace("default").Get(context.Background(), "other", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the DNSEndpoint of other.example.com., got %v", err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(other.Object, "spec", "endpoints")
+	targets, _, _ := unstructured.NestedStringSlice(endpoints[0].(map[string]interface{}), "targets")
+	if !reflect.DeepEqual(targets, []string{"192.0.2.20", "192.0.2.21"}) {
+		t.Errorf("Expected targets [192.0.2.20 192.0.2.21], got %v", targets)
+	}
+}
+
+func TestTransactionDeferredWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail makes the second update of the transaction fail
+		fail func(*Client)
+	}{
+		{"refused at staging", func(client *Client) {
+			// Owned by another requester, written by another replica
+			owner := newClient(client.dynamicClient, Options{Namespace: "default"})
+			if _, err := owner.ApplyUpdate(routerB, otherUpdate("192.0.2.30")); err != nil {
+				t.Fatalf("ApplyUpdate() failed: %v", err)
+			}
+		}},
+		{"rolled back", failCreates},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(Options{FirstOwnerWins: true, WriteInterval: time.Hour})
+			now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			client.throttle.now = func() time.Time { return now }
+			if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+				t.Fatalf("ApplyUpdate() failed: %v", err)
+			}
+			tt.fail(client)
+
+			// The first update is throttled, the second one fails
+			updates := []*update.DNSUpdate{testUpdate(update.UpdateTypeUpdate, "192.0.2.20"), otherUpdate("192.0.2.21")}
+			tx, err := client.BeginUpdates(routerA, updates)
+			if err == nil {
+				if !tx.Deferred(0) {
+					t.Fatal("Expected the first update to be deferred")
+				}
+				_, err = tx.Commit(tx.Len())
+			}
+			if err == nil {
+				t.Fatal("Expected the transaction to fail")
+			}
+
+			key := client.throttleKey(routerA, updates[0])
+			if r := client.throttle.resources[key]; len(r.pending) != 0 || r.timer != nil {
+				t.Errorf("Expected no deferred write of a failed transaction, got %+v", r.pending)
+			}
+			if got := endpointTargets(t, client); !reflect.DeepEqual(got, []string{"192.0.2.10"}) {
+				t.Errorf("Expected targets [192.0.2.10], got %v", got)
+			}
+		})
+	}
+}
+
+func TestTransactionDeferredAfterCommit(t *testing.T) {
+	client := newFakeClient(Options{WriteInterval: time.Hour})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	client.throttle.now = func() time.Time { return now }
+	if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	updates := []*update.DNSUpdate{testUpdate(update.UpdateTypeUpdate, "192.0.2.20"), otherUpdate("192.0.2.21")}
+	tx, err := client.BeginUpdates(routerA, updates)
+	if err != nil {
+		t.Fatalf("BeginUpdates() failed: %v", err)
+	}
+	key := client.throttleKey(routerA, updates[0])
+	if len(client.throttle.resources[key].pending) != 0 {
+		t.Fatal("Expected nothing deferred before the commit")
+	}
+	if done, err := tx.Commit(tx.Len()); !done || err != nil {
+		t.Fatalf("Commit() = %v, %v", done, err)
+	}
+	r := client.throttle.resources[key]
+	if len(r.pending) != 1 || r.pending[0].upd != updates[0] {
+		t.Fatalf("Expected the first update deferred once committed, got %+v", r.pending)
+	}
+	r.timer.Stop()
+	// The resource written by the transaction is throttled as well
+	if !client.throttle.throttled(client.throttleKey(routerA, updates[1])) {
+		t.Error("Expected the written resource to be throttled")
+	}
+}
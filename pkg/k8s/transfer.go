@@ -0,0 +1,148 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TransferOwnership hands the managed records of a TSIG key over to another key, e.g.
+// when a router is replaced by a device with its own key. The records of from, which
+// must name a key and may also name an address, get the key of to, and its address
+// when set. The ownership labels and the sources annotation of each resource are
+// rewritten in a single update, conditioned on the version that was listed, so an
+// update of the old owner racing with the transfer makes it fail instead of being
+// lost. It returns the names of the transferred resources; with dryRun nothing is changed.
+func (c *Client) TransferOwnership(ctx context.Context, from, to RequesterSelector, dryRun bool) ([]string, error) {
+	if from.KeyName == "" || to.KeyName == "" {
+		return nil, fmt.Errorf("ownership transfer requires the current and the new key")
+	}
+
+	gvr, kind := c.gvr, "DNSEndpoint"
+	if c.dynamicRecords {
+		gvr, kind = recordGVR, "DynamicRecord"
+	}
+	selector := labels.Set{labelManagedBy: managedByValue}.String()
+	list, err := c.dynamicClient.Resource(gvr).Namespace(c.listNamespace()).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %ss: %w", kind, err)
+	}
+
+	items := make([]*unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		if updated, ok := transferOwner(&list.Items[i], from, to); ok {
+			items = append(items, updated)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].GetNamespace() != items[j].GetNamespace() {
+			return items[i].GetNamespace() < items[j].GetNamespace()
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, c.displayName(item.GetNamespace(), item.GetName()))
+	}
+
+	if dryRun {
+		logrus.Infof("Ownership transfer dry run from %s to %s: %d %s(s) would be transferred", from, to, len(names), kind)
+		return names, nil
+	}
+
+	transferred := make([]string, 0, len(names))
+	for i, item := range items {
+		if item.GetLabels()[labelGroup] == "true" && !c.dynamicRecords {
+			err = c.inNamespace(item.GetNamespace()).transferGroup(ctx, item)
+		} else {
+			_, err = c.dynamicClient.Resource(gvr).Namespace(item.GetNamespace()).Update(ctx, item, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return transferred, fmt.Errorf("failed to transfer %s %s: %w", kind, names[i], err)
+		}
+		logrus.Infof("Transferred %s %s/%s from %s to %s", kind, item.GetNamespace(), item.GetName(), from, to)
+		transferred = append(transferred, names[i])
+	}
+	return transferred, nil
+}
+
+// transferGroup moves the entries of a transferred DNSEndpoint group to the group of
+// its new owner, since the name of a group is derived from its owner
+func (c *Client) transferGroup(ctx context.Context, group *unstructured.Unstructured) error {
+	groupLabels := group.GetLabels()
+	name := groupName(groupLabels[labelAskBy], groupLabels[labelKey])
+	if name == group.GetName() {
+		_, err := c.dynamicClient.Resource(c.gvr).Namespace(c.namespace).Update(ctx, group, metav1.UpdateOptions{})
+		return err
+	}
+	entries, _, _ := unstructured.NestedSlice(group.Object, "spec", "endpoints")
+	if err := c.addToEndpoint(ctx, name, copyLabels(group), entries, true); err != nil {
+		return err
+	}
+	return c.deleteMigrated(ctx, group.GetName(), name)
+}
+
+// transferOwner returns a copy of a resource owned by from with its ownership given to
+// to, and whether it was owned by from. A merged DNSEndpoint is owned by from when its
+// labels or one of its sources name it.
+func transferOwner(obj *unstructured.Unstructured, from, to RequesterSelector) (*unstructured.Unstructured, bool) {
+	fromKey, toKey := sanitizeLabel(from.KeyName), sanitizeLabel(to.KeyName)
+	matches := func(askBy, key string) bool {
+		return key == fromKey && (from.Addr == "" || askBy == sanitizeLabel(from.Addr))
+	}
+	newAskBy := func(askBy string) string {
+		if to.Addr != "" {
+			return sanitizeLabel(to.Addr)
+		}
+		return askBy
+	}
+
+	updated := obj.DeepCopy()
+	transferred := false
+	objLabels := updated.GetLabels()
+	if askBy, ok := objLabels[labelAskBy]; ok && matches(askBy, objLabels[labelKey]) {
+		objLabels[labelAskBy] = newAskBy(askBy)
+		objLabels[labelKey] = toKey
+		updated.SetLabels(objLabels)
+		transferred = true
+	}
+
+	if _, ok := updated.GetAnnotations()[annotationSources]; ok {
+		all := allEndpointSources(updated)
+		moved := make(map[string]map[string][]string, len(all))
+		for recordType, sources := range all {
+			moved[recordType] = make(map[string][]string, len(sources))
+			for source, targets := range sources {
+				askBy, key := splitSource(source)
+				if matches(askBy, key) {
+					source = newAskBy(askBy) + "/" + toKey
+					transferred = true
+				}
+				moved[recordType][source] = append(moved[recordType][source], targets...)
+			}
+		}
+		if transferred {
+			if err := setEndpointSources(updated, moved); err != nil {
+				logrus.Warnf("Failed to transfer the sources of DNSEndpoint %s: %v", updated.GetName(), err)
+				return nil, false
+			}
+		}
+	}
+	return updated, transferred
+}
+
+// splitSource splits a source of the sources annotation into its address and key labels
+func splitSource(source string) (askBy, key string) {
+	i := strings.LastIndex(source, "/")
+	if i < 0 {
+		return source, ""
+	}
+	return source[:i], source[i+1:]
+}
@@ -0,0 +1,127 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/update"
+)
+
+func TestTransferOwnership(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{FirstOwnerWins: true})
+
+	oldRouter := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, KeyName: "old-router"}
+	newRouter := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 5353}, KeyName: "new-router"}
+	other := Requester{Addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.3"), Port: 5353}, KeyName: "other"}
+
+	for name, requester := range map[string]Requester{"a.example.com.": oldRouter, "b.example.com.": oldRouter, "c.example.com.": other} {
+		upd := testUpdate(update.UpdateTypeCreate, "192.168.1.100")
+		upd.Name = name
+		if _, err := client.ApplyUpdate(requester, upd); err != nil {
+			t.Fatalf("ApplyUpdate() failed: %v", err)
+		}
+	}
+
+	from := RequesterSelector{KeyName: "old-router"}
+	to := RequesterSelector{Addr: "192.168.1.2", KeyName: "new-router"}
+	if _, err := client.TransferOwnership(ctx, RequesterSelector{Addr: "192.168.1.1"}, to, false); err == nil {
+		t.Error("Expected error without the current key")
+	}
+
+	names, err := client.TransferOwnership(ctx, from, to, true)
+	if err != nil {
+		t.Fatalf("TransferOwnership() failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("Expected dry run to select [a b], got %v", names)
+	}
+
+	upd := testUpdate(update.UpdateTypeCreate, "192.168.1.101")
+	upd.Name = "a.example.com."
+	if _, err := client.ApplyUpdate(newRouter, upd); !errors.Is(err, dnserr.ErrNameOwned) {
+		t.Fatalf("Expected the old router to own a before the transfer, got %v", err)
+	}
+
+	names, err = client.TransferOwnership(ctx, from, to, false)
+	if err != nil {
+		t.Fatalf("TransferOwnership() failed: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 transferred endpoints, got %v", names)
+	}
+	endpoint, _ := client.dynamicClient.Resource(client.gvr).Namespace("default").Get(ctx, "b", metav1.GetOptions{})
+	if labels := endpoint.GetLabels(); labels[labelKey] != "new-router" || labels[labelAskBy] != "192-168-1-2" {
+		t.Errorf("Unexpected ownership labels after transfer: %v", labels)
+	}
+	endpoint, _ = client.dynamicClient.Resource(client.gvr).Namespace("default").Get(ctx, "c", metav1.GetOptions{})
+	if endpoint.GetLabels()[labelKey] != "other" {
+		t.Errorf("Expected c to keep its owner, got %v", endpoint.GetLabels())
+	}
+
+	if _, err := client.ApplyUpdate(newRouter, upd); err != nil {
+		t.Errorf("Expected the new router to update a after the transfer, got %v", err)
+	}
+	if _, err := client.ApplyUpdate(oldRouter, upd); !errors.Is(err, dnserr.ErrNameOwned) {
+		t.Errorf("Expected the old router to lose a after the transfer, got %v", err)
+	}
+}
+
+func TestTransferOwnershipSources(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{MergeTargets: true})
+
+	if _, err := client.ApplyUpdate(routerA, testUpdate(update.UpdateTypeCreate, "192.0.2.10")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+	if _, err := client.ApplyUpdate(routerB, testUpdate(update.UpdateTypeCreate, "192.0.2.20")); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	// Router B wrote the endpoint last, router A only owns one of its sources
+	names, err := client.TransferOwnership(ctx, RequesterSelector{KeyName: routerA.KeyName}, RequesterSelector{KeyName: "router-c"}, false)
+	if err != nil {
+		t.Fatalf("TransferOwnership() failed: %v", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("Expected 1 transferred endpoint, got %v", names)
+	}
+
+	endpoint, _ := client.dynamicClient.Resource(client.gvr).Namespace("default").Get(ctx, "test", metav1.GetOptions{})
+	sources := allEndpointSources(endpoint)["A"]
+	if len(sources) != 2 || len(sources["192-168-1-1/router-c"]) != 1 || len(sources[routerB.source()]) != 1 {
+		t.Errorf("Unexpected sources after transfer: %v", sources)
+	}
+	if endpoint.GetLabels()[labelKey] != sanitizeLabel(routerB.KeyName) {
+		t.Errorf("Expected the labels of router B to be kept, got %v", endpoint.GetLabels())
+	}
+}
+
+func TestTransferOwnershipGroup(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeClient(Options{GroupByRequester: true})
+
+	upd := testUpdate(update.UpdateTypeCreate, "192.0.2.10")
+	upd.Name = "web.example.com."
+	if _, err := client.ApplyUpdate(routerA, upd); err != nil {
+		t.Fatalf("ApplyUpdate() failed: %v", err)
+	}
+
+	if _, err := client.TransferOwnership(ctx, RequesterSelector{KeyName: routerA.KeyName}, RequesterSelector{KeyName: "router-c"}, false); err != nil {
+		t.Fatalf("TransferOwnership() failed: %v", err)
+	}
+
+	endpoints := client.dynamicClient.Resource(client.gvr).Namespace("default")
+	if _, err := endpoints.Get(ctx, groupResourceName(routerA), metav1.GetOptions{}); !isNotFoundError(err) {
+		t.Errorf("Expected the group of router A to be removed, got %v", err)
+	}
+	routerC := Requester{Addr: routerA.Addr, KeyName: "router-c"}
+	if got := groupEntries(t, client, groupResourceName(routerC)); len(got["web.example.com. A"]) != 1 {
+		t.Errorf("Expected the group of router C to hold web.example.com, got %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AllowedZone CRD listing zones accepted in addition to ALLOWED_ZONES
+var allowedZoneGVR = schema.GroupVersionResource{
+	Group:    "ddnsbridge4extdns.io",
+	Version:  "v1alpha1",
+	Resource: "allowedzones",
+}
+
+// ZoneList allows the zones listed in AllowedZone resources. It implements
+// zoneauth.Authorizer and stays empty until Run is called.
+type ZoneList struct {
+	client *Client
+
+	mu sync.RWMutex
+	// keys allowed per resource and zone, any key when empty
+	zones map[string]map[string][]string
+}
+
+// NewZoneList creates the list of zones of the AllowedZone resources
+func (c *Client) NewZoneList() *ZoneList {
+	return &ZoneList{client: c, zones: make(map[string]map[string][]string)}
+}
+
+// Allows checks if an AllowedZone resource lists the zone, and the key when it restricts keys
+func (l *ZoneList) Allows(zone, key string) bool {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	key = strings.TrimSuffix(strings.ToLower(key), ".")
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, zones := range l.zones {
+		keys, ok := zones[zone]
+		if !ok {
+			continue
+		}
+		if len(keys) == 0 || containsString(keys, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run keeps the list in sync with the AllowedZone resources until ctx is done
+func (l *ZoneList) Run(ctx context.Context) error {
+	c := l.client
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, recordResyncPeriod, c.listNamespace(), nil)
+	informer := factory.ForResource(allowedZoneGVR).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			l.set(obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			l.set(obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			l.remove(obj)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register AllowedZone handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to sync AllowedZone cache")
+	}
+	logrus.Infof("AllowedZone watch started in namespace %q", c.listNamespace())
+
+	<-ctx.Done()
+	return nil
+}
+
+// set records the zones of an AllowedZone resource
+func (l *ZoneList) set(obj interface{}) {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	zones, _, _ := unstructured.NestedStringSlice(resource.Object, "spec", "zones")
+	keys, _, _ := unstructured.NestedStringSlice(resource.Object, "spec", "keys")
+	for i, key := range keys {
+		keys[i] = strings.TrimSuffix(strings.ToLower(key), ".")
+	}
+
+	allowed := make(map[string][]string, len(zones))
+	for _, zone := range zones {
+		allowed[strings.ToLower(strings.TrimSuffix(zone, "."))] = keys
+	}
+
+	l.mu.Lock()
+	l.zones[resourceKey(resource)] = allowed
+	l.mu.Unlock()
+	logrus.Debugf("AllowedZone %s lists zones %v", resourceKey(resource), zones)
+}
+
+// remove forgets the zones of a deleted AllowedZone resource
+func (l *ZoneList) remove(obj interface{}) {
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	delete(l.zones, resourceKey(resource))
+	l.mu.Unlock()
+	logrus.Debugf("AllowedZone %s removed", resourceKey(resource))
+}
+
+// resourceKey identifies a resource by namespace and name
+func resourceKey(resource *unstructured.Unstructured) string {
+	return resource.GetNamespace() + "/" + resource.GetName()
+}
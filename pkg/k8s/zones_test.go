@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testAllowedZone(name string, zones, keys []interface{}) *unstructured.Unstructured {
+	spec := map[string]interface{}{"zones": zones}
+	if keys != nil {
+		spec["keys"] = keys
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": allowedZoneGVR.GroupVersion().String(),
+		"kind":       "AllowedZone",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       spec,
+	}}
+}
+
+func TestZoneList(t *testing.T) {
+	list := newFakeClient(Options{}).NewZoneList()
+	list.set(testAllowedZone("lab", []interface{}{"Lab.example.com."}, nil))
+	list.set(testAllowedZone("prod", []interface{}{"prod.example.com"}, []interface{}{"Prod-Key."}))
+
+	tests := []struct {
+		name     string
+		zone     string
+		key      string
+		expected bool
+	}{
+		{"any key", "lab.example.com.", "", true},
+		{"restricted key", "prod.example.com.", "prod-key.", true},
+		{"other key", "prod.example.com.", "lab-key", false},
+		{"zone below", "team.lab.example.com.", "", false},
+		{"unlisted zone", "example.org.", "prod-key", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := list.Allows(tt.zone, tt.key); got != tt.expected {
+				t.Errorf("Allows(%s, %s) = %v, want %v", tt.zone, tt.key, got, tt.expected)
+			}
+		})
+	}
+
+	// Updated and deleted resources replace and withdraw their zones
+	list.set(testAllowedZone("lab", []interface{}{"lab2.example.com"}, nil))
+	if list.Allows("lab.example.com.", "") || !list.Allows("lab2.example.com.", "") {
+		t.Error("Expected updated resource to replace its zones")
+	}
+	list.remove(testAllowedZone("prod", nil, nil))
+	if list.Allows("prod.example.com.", "prod-key") {
+		t.Error("Expected deleted resource to withdraw its zones")
+	}
+}
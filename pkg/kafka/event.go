@@ -0,0 +1,103 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+// Event is an update accepted by the bridge
+type Event struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Name       string    `json:"name"`
+	Zone       string    `json:"zone"`
+	RecordType string    `json:"recordType"`
+	TTL        int64     `json:"ttl"`
+	Targets    []string  `json:"targets"`
+	Requester  string    `json:"requester"`
+	Key        string    `json:"key"`
+}
+
+// Supported event encodings
+const (
+	FormatJSON = "json"
+	FormatAvro = "avro"
+)
+
+// EventSchema is the Avro schema of events, in its Parsing Canonical Form.
+// Avro messages use the single object encoding: they start with the
+// CRC-64-AVRO fingerprint of this schema.
+const EventSchema = `{"name":"io.ddnsbridge4extdns.UpdateEvent","type":"record","fields":[` +
+	`{"name":"time","type":"long"},` +
+	`{"name":"action","type":"string"},` +
+	`{"name":"name","type":"string"},` +
+	`{"name":"zone","type":"string"},` +
+	`{"name":"recordType","type":"string"},` +
+	`{"name":"ttl","type":"long"},` +
+	`{"name":"targets","type":{"type":"array","items":"string"}},` +
+	`{"name":"requester","type":"string"},` +
+	`{"name":"key","type":"string"}]}`
+
+// eventFingerprint is the CRC-64-AVRO fingerprint of EventSchema
+var eventFingerprint = fingerprint([]byte(EventSchema))
+
+// Encode encodes an event in the given format
+func (e Event) Encode(format string) ([]byte, error) {
+	if format == FormatAvro {
+		return e.encodeAvro(), nil
+	}
+	return json.Marshal(e)
+}
+
+// encodeAvro encodes an event with the Avro single object encoding; the time
+// is in milliseconds since the epoch
+func (e Event) encodeAvro() []byte {
+	b := []byte{0xC3, 0x01}
+	b = binary.LittleEndian.AppendUint64(b, eventFingerprint)
+	b = appendLong(b, e.Time.UnixMilli())
+	b = appendString(b, e.Action)
+	b = appendString(b, e.Name)
+	b = appendString(b, e.Zone)
+	b = appendString(b, e.RecordType)
+	b = appendLong(b, e.TTL)
+	if len(e.Targets) > 0 {
+		b = appendLong(b, int64(len(e.Targets)))
+		for _, target := range e.Targets {
+			b = appendString(b, target)
+		}
+	}
+	b = appendLong(b, 0) // end of the targets array
+	b = appendString(b, e.Requester)
+	return appendString(b, e.Key)
+}
+
+// appendLong appends an Avro long: a zig-zag encoded varint
+func appendLong(b []byte, v int64) []byte {
+	return binary.AppendVarint(b, v)
+}
+
+// appendString appends an Avro string: its length, then its UTF-8 bytes
+func appendString(b []byte, s string) []byte {
+	b = appendLong(b, int64(len(s)))
+	return append(b, s...)
+}
+
+// fingerprint computes the CRC-64-AVRO (Rabin) fingerprint of a schema
+func fingerprint(schema []byte) uint64 {
+	const empty = 0xc15d213aa4d7a795
+	var table [256]uint64
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (empty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+
+	fp := uint64(empty)
+	for _, c := range schema {
+		fp = (fp >> 8) ^ table[byte(fp)^c]
+	}
+	return fp
+}
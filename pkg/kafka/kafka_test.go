@@ -0,0 +1,351 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testEvent() Event {
+	return Event{
+		Time:       time.UnixMilli(1700000000123).UTC(),
+		Action:     "CREATE",
+		Name:       "host.example.com.",
+		Zone:       "example.com.",
+		RecordType: "A",
+		TTL:        300,
+		Targets:    []string{"192.0.2.1"},
+		Requester:  "10.0.0.1",
+		Key:        "dhcp-key",
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	b, err := testEvent().Encode(FormatJSON)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	var decoded Event
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("invalid JSON %s: %v", b, err)
+	}
+	if decoded.Name != "host.example.com." || decoded.Zone != "example.com." || decoded.Targets[0] != "192.0.2.1" {
+		t.Errorf("decoded event = %+v", decoded)
+	}
+}
+
+func TestEncodeAvro(t *testing.T) {
+	b, err := testEvent().Encode(FormatAvro)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !bytes.Equal(b[:2], []byte{0xC3, 0x01}) {
+		t.Fatalf("missing single object marker: % x", b[:2])
+	}
+	if fp := binary.LittleEndian.Uint64(b[2:10]); fp != eventFingerprint {
+		t.Errorf("fingerprint = %x, expected %x", fp, eventFingerprint)
+	}
+
+	d := avroDecoder{b: b[10:]}
+	if v := d.long(); v != 1700000000123 {
+		t.Errorf("time = %d", v)
+	}
+	for _, expected := range []string{"CREATE", "host.example.com.", "example.com.", "A"} {
+		if v := d.string(); v != expected {
+			t.Errorf("field = %q, expected %q", v, expected)
+		}
+	}
+	if v := d.long(); v != 300 {
+		t.Errorf("ttl = %d", v)
+	}
+	if n := d.long(); n != 1 {
+		t.Fatalf("targets block size = %d", n)
+	}
+	if v := d.string(); v != "192.0.2.1" {
+		t.Errorf("target = %q", v)
+	}
+	if n := d.long(); n != 0 {
+		t.Errorf("targets not terminated: %d", n)
+	}
+	if v := d.string(); v != "10.0.0.1" {
+		t.Errorf("requester = %q", v)
+	}
+	if v := d.string(); v != "dhcp-key" {
+		t.Errorf("key = %q", v)
+	}
+	if len(d.b) != 0 {
+		t.Errorf("%d trailing bytes", len(d.b))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// From the Avro specification test vectors
+	if fp := fingerprint([]byte(`"int"`)); fp != 0x7275d51a3f395c8f {
+		t.Errorf("fingerprint(int) = %x", fp)
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// From the Kafka partitioner tests
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for input, expected := range tests {
+		if h := int32(murmur2([]byte(input))); h != expected {
+			t.Errorf("murmur2(%q) = %d, expected %d", input, h, expected)
+		}
+	}
+}
+
+func TestNewProducerValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "no brokers", config: Config{Topic: "t"}},
+		{name: "no topic", config: Config{Brokers: []string{"localhost:9092"}}},
+		{name: "bad format", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", Format: "xml"}},
+		{name: "bad key", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", PartitionKey: "requester"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewProducer(tt.config); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestProducerPublishes(t *testing.T) {
+	broker := newFakeBroker(t, 3)
+	producer, err := NewProducer(Config{Brokers: []string{broker.addr}, Topic: "updates", ClientID: "test"})
+	if err != nil {
+		t.Fatalf("NewProducer() error = %v", err)
+	}
+
+	first, second := testEvent(), testEvent()
+	second.Name, second.Action = "other.example.com.", "DELETE"
+	producer.Publish(first)
+	producer.Publish(second)
+	producer.Close()
+
+	records := broker.records()
+	if len(records) != 2 {
+		t.Fatalf("broker received %d records, expected 2", len(records))
+	}
+	// Both events share the zone key, hence the partition
+	expected := int32((murmur2([]byte("example.com")) & 0x7fffffff) % 3)
+	for _, r := range records {
+		if r.partition != expected {
+			t.Errorf("record in partition %d, expected %d", r.partition, expected)
+		}
+		if string(r.key) != "example.com" {
+			t.Errorf("record key = %q", r.key)
+		}
+		var event Event
+		if err := json.Unmarshal(r.value, &event); err != nil {
+			t.Errorf("invalid record value %s: %v", r.value, err)
+		}
+	}
+}
+
+// avroDecoder reads Avro binary values
+type avroDecoder struct {
+	b []byte
+}
+
+func (d *avroDecoder) long() int64 {
+	v, n := binary.Varint(d.b)
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *avroDecoder) string() string {
+	n := d.long()
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// fakeRecord is a record received by the fake broker
+type fakeRecord struct {
+	partition int32
+	key       []byte
+	value     []byte
+}
+
+// fakeBroker is a single Kafka broker leading every partition of every topic
+type fakeBroker struct {
+	t          *testing.T
+	addr       string
+	partitions int32
+	received   chan fakeRecord
+}
+
+func newFakeBroker(t *testing.T, partitions int32) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	b := &fakeBroker{t: t, addr: listener.Addr().String(), partitions: partitions, received: make(chan fakeRecord, 100)}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+// records returns the records received so far
+func (b *fakeBroker) records() []fakeRecord {
+	var records []fakeRecord
+	for {
+		select {
+		case r := <-b.received:
+			records = append(records, r)
+		default:
+			return records
+		}
+	}
+}
+
+func (b *fakeBroker) serve(c net.Conn) {
+	defer c.Close()
+	for {
+		var size int32
+		if err := binary.Read(c, binary.BigEndian, &size); err != nil {
+			return
+		}
+		request := make([]byte, size)
+		if _, err := io.ReadFull(c, request); err != nil {
+			return
+		}
+		d := &decoder{b: request}
+		apiKey := d.int16()
+		d.int16() // version
+		correlation := d.int32()
+		d.string() // client ID
+
+		e := &encoder{}
+		e.int32(0)
+		e.int32(correlation)
+		switch apiKey {
+		case apiMetadata:
+			b.metadata(d, e)
+		case apiProduce:
+			b.produce(d, e)
+		default:
+			b.t.Errorf("unexpected API key %d", apiKey)
+			return
+		}
+		binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+		if _, err := c.Write(e.b); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(d *decoder, e *encoder) {
+	d.count()
+	topic := d.string()
+	host, port, _ := net.SplitHostPort(b.addr)
+	portNum, _ := strconv.Atoi(port)
+
+	e.int32(1)
+	e.int32(1)
+	e.string(host)
+	e.int32(int32(portNum))
+	e.int16(-1) // rack
+	e.int32(1)  // controller
+	e.int32(1)
+	e.int16(0)
+	e.string(topic)
+	e.int8(0)
+	e.int32(b.partitions)
+	for i := int32(0); i < b.partitions; i++ {
+		e.int16(0)
+		e.int32(i)
+		e.int32(1) // leader
+		e.int32(0) // replicas
+		e.int32(0) // isr
+	}
+}
+
+func (b *fakeBroker) produce(d *decoder, e *encoder) {
+	d.string() // transactional ID
+	if acks := d.int16(); acks != 1 {
+		b.t.Errorf("acks = %d", acks)
+	}
+	d.int32() // timeout
+	d.count()
+	topic := d.string()
+
+	e.int32(1)
+	e.string(topic)
+	partitions := d.count()
+	e.int32(int32(partitions))
+	for ; partitions > 0; partitions-- {
+		partition := d.int32()
+		batch := d.take(int(d.int32()))
+		b.readBatch(partition, batch)
+		e.int32(partition)
+		e.int16(0)
+		e.int64(0)
+		e.int64(-1)
+	}
+	e.int32(0) // throttle time
+}
+
+// readBatch checks a record batch and records its records
+func (b *fakeBroker) readBatch(partition int32, batch []byte) {
+	d := &decoder{b: batch}
+	d.int64() // base offset
+	if length := d.int32(); int(length) != len(d.b) {
+		b.t.Errorf("batch length = %d, expected %d", length, len(d.b))
+	}
+	d.int32() // leader epoch
+	if magic := d.int8(); magic != 2 {
+		b.t.Errorf("magic = %d", magic)
+	}
+	crc := uint32(d.int32())
+	if sum := crc32.Checksum(d.b, crc32c); sum != crc {
+		b.t.Errorf("batch CRC = %x, expected %x", crc, sum)
+	}
+	d.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	count := d.int32()
+	for i := int32(0); i < count; i++ {
+		length, n := binary.Varint(d.b)
+		r := d.b[n : n+int(length)]
+		d.b = d.b[n+int(length):]
+		r = r[1:] // attributes
+		_, n = binary.Varint(r)
+		r = r[n:]
+		_, n = binary.Varint(r)
+		r = r[n:]
+		keyLen, n := binary.Varint(r)
+		r = r[n:]
+		var key []byte
+		if keyLen >= 0 {
+			key, r = r[:keyLen], r[keyLen:]
+		}
+		valueLen, n := binary.Varint(r)
+		r = r[n:]
+		b.received <- fakeRecord{partition: partition, key: key, value: r[:valueLen]}
+	}
+}
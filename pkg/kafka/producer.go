@@ -0,0 +1,331 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// Partition keys of events
+const (
+	PartitionByZone = "zone"
+	PartitionByName = "name"
+	PartitionByNone = "none"
+)
+
+// queueSize is the number of events buffered while the brokers are slow or unreachable
+const queueSize = 4096
+
+// maxBatch is the number of events sent in a single produce request
+const maxBatch = 500
+
+// requestTimeout bounds a request to a broker, including the acknowledgement
+const requestTimeout = 10 * time.Second
+
+// Config configures a Producer
+type Config struct {
+	// Brokers are the bootstrap brokers, as host:port
+	Brokers []string
+	// Topic receives the events
+	Topic string
+	// Format is the encoding of events: json or avro
+	Format string
+	// PartitionKey is the event field the partition is chosen by: zone, name or none
+	PartitionKey string
+	// ClientID identifies the producer to the brokers
+	ClientID string
+	// TLS enables TLS to the brokers when not nil
+	TLS *tls.Config
+}
+
+// Producer publishes events to a Kafka topic in the background. Events are
+// dropped, never delayed, when the brokers do not keep up.
+type Producer struct {
+	config Config
+
+	queue chan Event
+	done  chan struct{}
+
+	// Owned by the run loop
+	metadata *metadata
+	conns    map[string]*conn
+	next     int
+}
+
+// NewProducer starts a producer; brokers are connected to in the background
+func NewProducer(config Config) (*Producer, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("at least one Kafka broker is required")
+	}
+	if config.Topic == "" {
+		return nil, fmt.Errorf("a Kafka topic is required")
+	}
+	switch config.Format {
+	case "":
+		config.Format = FormatJSON
+	case FormatJSON, FormatAvro:
+	default:
+		return nil, fmt.Errorf("unsupported Kafka event format %q", config.Format)
+	}
+	switch config.PartitionKey {
+	case "":
+		config.PartitionKey = PartitionByZone
+	case PartitionByZone, PartitionByName, PartitionByNone:
+	default:
+		return nil, fmt.Errorf("unsupported Kafka partition key %q", config.PartitionKey)
+	}
+
+	p := &Producer{
+		config: config,
+		queue:  make(chan Event, queueSize),
+		done:   make(chan struct{}),
+		conns:  map[string]*conn{},
+	}
+	go p.run()
+	return p, nil
+}
+
+// Publish queues an event. A nil Producer publishes nothing.
+func (p *Producer) Publish(event Event) {
+	if p == nil {
+		return
+	}
+	select {
+	case p.queue <- event:
+	default:
+		metrics.KafkaEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Close sends the queued events and closes the broker connections
+func (p *Producer) Close() {
+	if p == nil {
+		return
+	}
+	close(p.queue)
+	<-p.done
+}
+
+// run sends the queued events in batches until the producer is closed
+func (p *Producer) run() {
+	defer close(p.done)
+	defer p.closeConns()
+
+	batch := make([]Event, 0, maxBatch)
+	for event := range p.queue {
+		batch = append(batch[:0], event)
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case event, ok := <-p.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+		p.send(batch)
+	}
+}
+
+// send publishes a batch of events, refreshing the metadata and retrying once on failure
+func (p *Producer) send(events []Event) {
+	messages := make([]message, 0, len(events))
+	for _, event := range events {
+		value, err := event.Encode(p.config.Format)
+		if err != nil {
+			logrus.Errorf("Failed to encode Kafka event for %s: %v", event.Name, err)
+			metrics.KafkaEvents.WithLabelValues("dropped").Inc()
+			continue
+		}
+		messages = append(messages, message{key: p.key(event), value: value, time: event.Time})
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	err := p.produce(messages)
+	if err != nil {
+		logrus.Debugf("Retrying Kafka produce after error: %v", err)
+		p.metadata = nil
+		p.closeConns()
+		err = p.produce(messages)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to publish %d event(s) to Kafka topic %s: %v", len(messages), p.config.Topic, err)
+		p.metadata = nil
+		p.closeConns()
+		metrics.KafkaEvents.WithLabelValues("dropped").Add(float64(len(messages)))
+		return
+	}
+	metrics.KafkaEvents.WithLabelValues("published").Add(float64(len(messages)))
+}
+
+// key returns the partition key of an event, nil to spread events evenly
+func (p *Producer) key(event Event) []byte {
+	switch p.config.PartitionKey {
+	case PartitionByZone:
+		return []byte(strings.TrimSuffix(event.Zone, "."))
+	case PartitionByName:
+		return []byte(strings.TrimSuffix(event.Name, "."))
+	}
+	return nil
+}
+
+// produce sends messages to the leaders of their partitions
+func (p *Producer) produce(messages []message) error {
+	if p.metadata == nil {
+		md, err := p.fetchMetadata()
+		if err != nil {
+			return err
+		}
+		p.metadata = md
+	}
+
+	// Group the messages by leader, then by partition
+	byLeader := map[int32]map[int32][]message{}
+	for _, m := range messages {
+		partition := p.partition(m.key)
+		leader := p.metadata.leaders[partition]
+		if leader < 0 {
+			return fmt.Errorf("partition %s/%d has no leader", p.config.Topic, partition)
+		}
+		if byLeader[leader] == nil {
+			byLeader[leader] = map[int32][]message{}
+		}
+		byLeader[leader][partition] = append(byLeader[leader][partition], m)
+	}
+
+	for leader, partitions := range byLeader {
+		addr, ok := p.metadata.brokers[leader]
+		if !ok {
+			return fmt.Errorf("unknown leader %d of topic %s", leader, p.config.Topic)
+		}
+		batches := make(map[int32][]byte, len(partitions))
+		for partition, ms := range partitions {
+			batches[partition] = encodeRecordBatch(ms)
+		}
+		c, err := p.conn(addr)
+		if err != nil {
+			return err
+		}
+		response, err := c.roundTrip(apiProduce, produceVersion, encodeProduceRequest(p.config.Topic, requestTimeout, batches), requestTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to produce to %s: %w", addr, err)
+		}
+		if err := decodeProduceResponse(response); err != nil {
+			return fmt.Errorf("failed to produce to %s: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// partition picks the partition of a key like the default Kafka partitioner,
+// or the next partition in turn when there is no key
+func (p *Producer) partition(key []byte) int32 {
+	n := len(p.metadata.leaders)
+	if key == nil {
+		p.next = (p.next + 1) % n
+		return int32(p.next)
+	}
+	return int32((murmur2(key) & 0x7fffffff) % uint32(n))
+}
+
+// fetchMetadata asks the bootstrap brokers in turn for the metadata of the topic
+func (p *Producer) fetchMetadata() (*metadata, error) {
+	var errs []error
+	for _, addr := range p.config.Brokers {
+		c, err := p.conn(addr)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		response, err := c.roundTrip(apiMetadata, metadataVersion, encodeMetadataRequest(p.config.Topic), requestTimeout)
+		if err == nil {
+			var md *metadata
+			if md, err = decodeMetadataResponse(response, p.config.Topic); err == nil {
+				return md, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("metadata from %s: %w", addr, err))
+		p.closeConn(addr)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// conn returns the connection to a broker, connecting when needed
+func (p *Producer) conn(addr string) (*conn, error) {
+	if c, ok := p.conns[addr]; ok {
+		return c, nil
+	}
+	dialer := &net.Dialer{Timeout: requestTimeout}
+	var nc net.Conn
+	var err error
+	if p.config.TLS != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, p.config.TLS)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka broker %s: %w", addr, err)
+	}
+	c := &conn{Conn: nc, clientID: p.config.ClientID}
+	p.conns[addr] = c
+	return c, nil
+}
+
+func (p *Producer) closeConn(addr string) {
+	if c, ok := p.conns[addr]; ok {
+		c.Close()
+		delete(p.conns, addr)
+	}
+}
+
+func (p *Producer) closeConns() {
+	for addr := range p.conns {
+		p.closeConn(addr)
+	}
+}
+
+// murmur2 is the hash of the default Kafka partitioner
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
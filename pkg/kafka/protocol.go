@@ -0,0 +1,306 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// API keys and versions of the requests the producer sends
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+// maxResponseSize bounds the responses read from a broker
+const maxResponseSize = 16 << 20
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// errTruncated is returned when a response ends before its last field
+var errTruncated = errors.New("truncated response")
+
+// brokerError is an error code returned by a broker
+type brokerError int16
+
+func (e brokerError) Error() string {
+	return "broker error code " + strconv.Itoa(int(e))
+}
+
+// encoder appends the big-endian fields of a request
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *encoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *encoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *encoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// decoder reads the big-endian fields of a response, remembering the first error
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		d.err = errTruncated
+		return make([]byte, max(n, 0))
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) int8() int8   { return int8(d.take(1)[0]) }
+func (d *decoder) int16() int16 { return int16(binary.BigEndian.Uint16(d.take(2))) }
+func (d *decoder) int32() int32 { return int32(binary.BigEndian.Uint32(d.take(4))) }
+func (d *decoder) int64() int64 { return int64(binary.BigEndian.Uint64(d.take(8))) }
+
+// string reads a nullable string; null reads as empty
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+// count reads the length of an array; null reads as empty
+func (d *decoder) count() int {
+	n := d.int32()
+	if n < 0 || d.err != nil {
+		return 0
+	}
+	if int(n) > len(d.b) {
+		d.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+// metadata is the part of a metadata response the producer uses
+type metadata struct {
+	// brokers maps node IDs to addresses
+	brokers map[int32]string
+	// leaders holds the leader of each partition of the topic, by partition ID
+	leaders []int32
+}
+
+// encodeMetadataRequest encodes a metadata v1 request for a topic
+func encodeMetadataRequest(topic string) []byte {
+	e := &encoder{}
+	e.int32(1)
+	e.string(topic)
+	return e.b
+}
+
+// decodeMetadataResponse decodes a metadata v1 response for a topic
+func decodeMetadataResponse(b []byte, topic string) (*metadata, error) {
+	d := &decoder{b: b}
+	md := &metadata{brokers: map[int32]string{}}
+	for i := d.count(); i > 0; i-- {
+		node := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		md.brokers[node] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+
+	found := false
+	for i := d.count(); i > 0; i-- {
+		code := d.int16()
+		name := d.string()
+		d.int8() // internal
+		var leaders []int32
+		for j := d.count(); j > 0; j-- {
+			d.int16() // partition error, the leader may still be known
+			partition := d.int32()
+			leader := d.int32()
+			for k := d.count(); k > 0; k-- {
+				d.int32() // replicas
+			}
+			for k := d.count(); k > 0; k-- {
+				d.int32() // in-sync replicas
+			}
+			if partition >= 0 && int(partition) < 1<<16 {
+				for len(leaders) <= int(partition) {
+					leaders = append(leaders, -1)
+				}
+				leaders[partition] = leader
+			}
+		}
+		if name != topic {
+			continue
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("topic %s: %w", topic, brokerError(code))
+		}
+		found = true
+		md.leaders = leaders
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if !found || len(md.leaders) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return md, nil
+}
+
+// message is a record of a batch
+type message struct {
+	key   []byte
+	value []byte
+	time  time.Time
+}
+
+// encodeRecordBatch encodes messages as a v2 record batch
+func encodeRecordBatch(messages []message) []byte {
+	first := messages[0].time.UnixMilli()
+	last := first
+	var records []byte
+	for i, m := range messages {
+		ts := m.time.UnixMilli()
+		last = max(last, ts)
+		var r []byte
+		r = append(r, 0) // attributes
+		r = binary.AppendVarint(r, ts-first)
+		r = binary.AppendVarint(r, int64(i))
+		if m.key == nil {
+			r = binary.AppendVarint(r, -1)
+		} else {
+			r = binary.AppendVarint(r, int64(len(m.key)))
+			r = append(r, m.key...)
+		}
+		r = binary.AppendVarint(r, int64(len(m.value)))
+		r = append(r, m.value...)
+		r = binary.AppendVarint(r, 0) // headers
+		records = binary.AppendVarint(records, int64(len(r)))
+		records = append(records, r...)
+	}
+
+	// The checksum covers the batch from the attributes on
+	body := &encoder{}
+	body.int16(0) // attributes: no compression
+	body.int32(int32(len(messages) - 1))
+	body.int64(first)
+	body.int64(last)
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(messages)))
+	body.b = append(body.b, records...)
+
+	e := &encoder{}
+	e.int64(0)                      // base offset
+	e.int32(int32(len(body.b) + 9)) // length after this field
+	e.int32(-1)                     // partition leader epoch
+	e.int8(2)                       // magic
+	e.b = binary.BigEndian.AppendUint32(e.b, crc32.Checksum(body.b, crc32c))
+	e.b = append(e.b, body.b...)
+	return e.b
+}
+
+// encodeProduceRequest encodes a produce v3 request writing batches to the
+// partitions of a topic, acknowledged by the partition leaders
+func encodeProduceRequest(topic string, timeout time.Duration, batches map[int32][]byte) []byte {
+	e := &encoder{}
+	e.int16(-1) // transactional ID
+	e.int16(1)  // acks
+	e.int32(int32(timeout.Milliseconds()))
+	e.int32(1)
+	e.string(topic)
+	e.int32(int32(len(batches)))
+	for partition, batch := range batches {
+		e.int32(partition)
+		e.bytes(batch)
+	}
+	return e.b
+}
+
+// decodeProduceResponse decodes a produce v3 response, returning the first partition error
+func decodeProduceResponse(b []byte) error {
+	d := &decoder{b: b}
+	var err error
+	for i := d.count(); i > 0; i-- {
+		topic := d.string()
+		for j := d.count(); j > 0; j-- {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 && err == nil {
+				err = fmt.Errorf("partition %s/%d: %w", topic, partition, brokerError(code))
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return err
+}
+
+// conn is a connection to a broker
+type conn struct {
+	net.Conn
+	clientID    string
+	correlation int32
+}
+
+// roundTrip sends a request and reads its response
+func (c *conn) roundTrip(apiKey, version int16, body []byte, timeout time.Duration) ([]byte, error) {
+	c.correlation++
+	e := &encoder{}
+	e.int32(0) // size, set below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(c.correlation)
+	e.string(c.clientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(e.b); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(c, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size < 4 || size > maxResponseSize {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	if correlation := int32(binary.BigEndian.Uint32(header[4:])); correlation != c.correlation {
+		return nil, fmt.Errorf("unexpected correlation ID %d, expected %d", correlation, c.correlation)
+	}
+	response := make([]byte, size-4)
+	if _, err := io.ReadFull(c, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ddnsbridge4extdns"
+
+var (
+	// TSIGFailures counts requests rejected because of their TSIG, by reason
+	TSIGFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tsig_failures_total",
+		Help:      "Requests rejected because TSIG verification failed, by reason (badtime, badsig, badkey).",
+	}, []string{"reason"})
+
+	// TSIGSkewTolerated counts requests accepted thanks to the configured clock-skew tolerance
+	TSIGSkewTolerated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tsig_skew_tolerated_total",
+		Help:      "Requests signed outside of their fudge but accepted within the configured clock-skew tolerance.",
+	})
+
+	// TSIGClockSkew observes the absolute clock skew of signed requests
+	TSIGClockSkew = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tsig_clock_skew_seconds",
+		Help:      "Absolute difference between the TSIG time signed of requests and the server time.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	})
+
+	// UpdateDuration observes the time to answer UPDATE messages, by rcode
+	UpdateDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "update_duration_seconds",
+		Help:      "Time to answer UPDATE messages, by rcode; exemplars carry the source address and ID of a message.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"rcode"})
+
+	// TSIGSecretMatches counts the verified signatures, by secret that matched
+	TSIGSecretMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tsig_secret_matches_total",
+		Help:      "Signed requests verified, by secret that matched (current, or previous during a rotation overlap).",
+	}, []string{"secret"})
+
+	// UnsignedUpdates counts the UPDATE messages carrying no credentials, by outcome
+	UnsignedUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unsigned_updates_total",
+		Help:      "UPDATE messages without TSIG, SIG(0) or client certificate, by outcome (refused or accepted).",
+	}, []string{"outcome"})
+
+	// UpdateErrors counts the updates answered with an error, by error kind
+	UpdateErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "update_errors_total",
+		Help:      "Updates refused or failed, by error kind (zone_not_allowed, tsig_badsig, backend_conflict, ...).",
+	}, []string{"kind"})
+
+	// RejectedRecords counts the records of update sections skipped, by record type and reason
+	RejectedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "update_records_rejected_total",
+		Help:      "Records of update sections skipped, by record type and reason (unsupported_type, disabled_type, malformed, ...).",
+	}, []string{"type", "reason"})
+
+	// Retransmits counts the UDP retransmissions of updates from clients with the
+	// retransmits quirk, by outcome
+	Retransmits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "update_retransmits_total",
+		Help:      "UDP retransmissions of updates not applied again, answered with the first response (answered) or dropped while the first is in flight (in_flight).",
+	}, []string{"outcome"})
+
+	// QueryCache counts the lookups of the query result cache, by result
+	QueryCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "query_cache_lookups_total",
+		Help:      "Lookups of the query result cache, by result (hit, miss).",
+	}, []string{"result"})
+
+	// DnstapDropped counts the dnstap messages dropped because the collector did not keep up
+	DnstapDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dnstap_dropped_total",
+		Help:      "Dnstap messages dropped because the output was full, unreachable or failing.",
+	})
+
+	// KafkaEvents counts the update events published to Kafka or dropped
+	KafkaEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_events_total",
+		Help:      "Update events published to Kafka, by outcome (published or dropped).",
+	}, []string{"outcome"})
+
+	// ThrottledWrites counts the Kubernetes writes deferred, coalesced or failing after being deferred
+	ThrottledWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "throttled_writes_total",
+		Help:      "Updates whose Kubernetes write was deferred by WRITE_INTERVAL, by outcome (deferred, coalesced or failed).",
+	}, []string{"outcome"})
+
+	// UpdateBatches counts the batches updates are written to Kubernetes in
+	UpdateBatches = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "update_batches_total",
+		Help:      "Batches of updates written to Kubernetes; large messages are split in several batches.",
+	})
+
+	// OversizedUpdates counts the UPDATE messages refused for their record count
+	OversizedUpdates = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "oversized_updates_total",
+		Help:      "UPDATE messages refused with FORMERR before being unpacked, holding more records than MAX_UPDATE_RECORDS.",
+	})
+
+	// BackendRetries counts the Kubernetes writes retried after a transient error
+	BackendRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_retries_total",
+		Help:      "Kubernetes writes retried after a transient error, by error (backend_conflict or backend_unavailable).",
+	}, []string{"error"})
+
+	// JournalMessages counts the messages queued in the write journal while the API server was unavailable
+	JournalMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "journal_messages_total",
+		Help:      "Messages queued in WRITE_JOURNAL while the API server was unavailable, by outcome (queued, refused when full, replayed or failed).",
+	}, []string{"outcome"})
+
+	// AsyncMessages counts the messages written after being answered
+	AsyncMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "async_messages_total",
+		Help:      "Messages written to Kubernetes after being answered with ASYNC_WORKERS, by outcome (queued, refused when the queue is full, written or failed).",
+	}, []string{"outcome"})
+
+	// AsyncQueueDepth is the number of answered messages waiting for their write
+	AsyncQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "async_queue_depth",
+		Help:      "Messages answered and waiting in the write queues of ASYNC_WORKERS.",
+	})
+
+	// TransactionRollbacks counts the resources restored after a write of their message failed
+	TransactionRollbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transaction_rollbacks_total",
+		Help:      "Resources written by a message whose next write failed, by outcome of their rollback (restored or failed).",
+	}, []string{"outcome"})
+
+	// ProbeFailures counts the targets that failed the reachability probe, by action taken
+	ProbeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "probe_failures_total",
+		Help:      "Record targets that failed the reachability probe, by action taken (refuse, flag).",
+	}, []string{"action"})
+
+	// DHCIDConflicts counts updates refused because another client owns the name
+	DHCIDConflicts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dhcid_conflicts_total",
+		Help:      "Updates refused with YXRRSET because the name is owned by a client with another DHCID.",
+	})
+
+	// TrapUpdates counts the updates of decoy zones, by zone and authentication outcome
+	TrapUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trap_updates_total",
+		Help:      "Updates received for decoy zones, accepted and ignored, by zone and whether they carried a valid TSIG.",
+	}, []string{"zone", "authenticated"})
+
+	// Bans counts the sources banned after repeated refusals, by reason of the last refusal
+	Bans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bans_total",
+		Help:      "Sources temporarily banned after repeated refusals, by reason of the last refusal.",
+	}, []string{"reason"})
+
+	// RecordsExpired counts the records removed by the TTL expiry of their zone
+	RecordsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_expired_total",
+		Help:      "Records removed because they were not refreshed within the TTL multiple of their zone, by zone.",
+	}, []string{"zone"})
+
+	// PushedUpdates counts the UPDATE messages sent to the external server of the push subcommand
+	PushedUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pushed_updates_total",
+		Help:      "UPDATE messages pushed to the external DNS server, by zone and result (success, failure).",
+	}, []string{"zone", "result"})
+
+	// TCPSessionsRefused counts the TCP connections and transactions refused by the session limits
+	TCPSessionsRefused = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tcp_sessions_refused_total",
+		Help:      "TCP connections and transactions refused by the session limits, by limit (source, key, inflight).",
+	}, []string{"limit"})
+
+	// RateLimitedRequests counts the messages of sources over the rate limit
+	RateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Messages of sources over RATE_LIMIT, by action (dropped over UDP, refused over TCP).",
+	}, []string{"action"})
+
+	// EndpointCacheReads counts the reads of DNSEndpoints with ENDPOINT_CACHE
+	EndpointCacheReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "endpoint_cache_reads_total",
+		Help:      "Reads of DNSEndpoints with ENDPOINT_CACHE, by source (cache, api while unsynced or a write is pending).",
+	}, []string{"source"})
+
+	// DeduplicatedMessages counts the messages answered without a write with DEDUP_WINDOW
+	DeduplicatedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deduplicated_messages_total",
+		Help:      "Messages writing the same records again within DEDUP_WINDOW, answered without a Kubernetes call.",
+	})
+
+	// AuditFailures counts the audit entries that could not be written
+	AuditFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_failures_total",
+		Help:      "Accepted updates whose audit entry could not be written.",
+	})
+
+	// ProxyHeaderFailures counts the connections closed for a missing or invalid PROXY header
+	ProxyHeaderFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_header_failures_total",
+		Help:      "TCP connections of trusted load balancers closed because their PROXY protocol header was missing or invalid.",
+	})
+
+	// BannedRequests counts the packets and connections of banned sources dropped unparsed
+	BannedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "banned_requests_total",
+		Help:      "Packets and connections of banned sources dropped before being parsed.",
+	})
+)
+
+// ObserveMessage observes a value of a DNS message, with the source address and
+// ID of the message as exemplar, so that a slow bucket leads to the log lines of
+// the message
+func ObserveMessage(observer prometheus.Observer, value float64, source string, id uint16) {
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok {
+		exemplars.ObserveWithExemplar(value, prometheus.Labels{"source": source, "message_id": strconv.Itoa(int(id))})
+		return
+	}
+	observer.Observe(value)
+}
+
+// Handler serves the metrics in the Prometheus exposition format, or in the
+// OpenMetrics format, which carries the exemplars, when the scraper accepts it
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerExemplars(t *testing.T) {
+	ObserveMessage(UpdateDuration.WithLabelValues("NOTAUTH"), 0.42, "192.0.2.1:5353", 4242)
+
+	// Exemplars are only exposed in the OpenMetrics format
+	for _, tt := range []struct {
+		accept   string
+		expected bool
+	}{
+		{"application/openmetrics-text; version=1.0.0", true},
+		{"text/plain", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, req)
+		body, _ := io.ReadAll(rec.Body)
+
+		got := false
+		for _, line := range strings.Split(string(body), "\n") {
+			_, exemplar, ok := strings.Cut(line, " # ")
+			if strings.HasPrefix(line, `ddnsbridge4extdns_update_duration_seconds_bucket{rcode="NOTAUTH",le="0.5"}`) && ok &&
+				strings.Contains(exemplar, `source="192.0.2.1:5353"`) && strings.Contains(exemplar, `message_id="4242"`) {
+				got = true
+			}
+		}
+		if got != tt.expected {
+			t.Errorf("Accept %q: expected exemplar exposed = %v, got %v", tt.accept, tt.expected, got)
+		}
+	}
+}
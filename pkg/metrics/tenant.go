@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Dimensions of the tenant metrics
+const (
+	DimensionZone   = "zone"
+	DimensionKey    = "key"
+	DimensionType   = "type"
+	DimensionSource = "source"
+)
+
+// Dimensions lists the dimensions the tenant metrics may be labelled with
+var Dimensions = []string{DimensionZone, DimensionKey, DimensionType, DimensionSource}
+
+// Overflow is the value of every dimension of the series beyond the cardinality budget
+const Overflow = "other"
+
+// Tenants counts the updates of each tenant, labelled with the configured dimensions.
+// Once the budget of series is spent, new label combinations are counted in a single
+// overflow series. A nil Tenants counts nothing.
+type Tenants struct {
+	dimensions map[string]bool
+	budget     int
+	updates    *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	overflowed prometheus.Counter
+
+	mu sync.Mutex
+	// series holds the label values counted, by metric
+	series map[string]map[string]bool
+}
+
+// NewTenants creates the tenant metrics labelled with dimensions, keeping at most
+// budget series per metric, and registers them with registerer
+func NewTenants(dimensions []string, budget int, registerer prometheus.Registerer) (*Tenants, error) {
+	t := &Tenants{dimensions: make(map[string]bool), budget: budget, series: make(map[string]map[string]bool)}
+	for _, dimension := range dimensions {
+		if !isDimension(dimension) {
+			return nil, fmt.Errorf("unknown dimension %q", dimension)
+		}
+		t.dimensions[dimension] = true
+	}
+
+	t.updates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_updates_total",
+		Help:      "Records applied, by the enabled dimensions of their tenant (zone, key, type, source).",
+	}, t.labels(true))
+	t.errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_update_errors_total",
+		Help:      "Updates refused or failed, by the enabled dimensions of their tenant (zone, key, source) and error kind.",
+	}, append(t.labels(false), "kind"))
+	t.overflowed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_series_overflow_total",
+		Help:      "Observations of the tenant metrics counted in the overflow series because the cardinality budget was spent.",
+	})
+	for _, collector := range []prometheus.Collector{t.updates, t.errors, t.overflowed} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register tenant metrics: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// isDimension checks if a name is a dimension of the tenant metrics
+func isDimension(name string) bool {
+	for _, dimension := range Dimensions {
+		if name == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+// labels returns the enabled dimensions, in the order of Dimensions, with the record
+// type or without it
+func (t *Tenants) labels(withType bool) []string {
+	labels := make([]string, 0, len(t.dimensions))
+	for _, dimension := range Dimensions {
+		if t.dimensions[dimension] && (withType || dimension != DimensionType) {
+			labels = append(labels, dimension)
+		}
+	}
+	return labels
+}
+
+// Update counts a record of type recordType applied in a zone from source with keyName
+func (t *Tenants) Update(zone, keyName, recordType, source string) {
+	if t == nil {
+		return
+	}
+	values := t.values(map[string]string{DimensionZone: zone, DimensionKey: keyName, DimensionType: recordType, DimensionSource: source}, true)
+	t.updates.WithLabelValues(t.budgeted("updates", values)...).Inc()
+}
+
+// Error counts an update of a zone from source with keyName failed with an error kind
+func (t *Tenants) Error(zone, keyName, source, kind string) {
+	if t == nil {
+		return
+	}
+	values := t.values(map[string]string{DimensionZone: zone, DimensionKey: keyName, DimensionSource: source}, false)
+	t.errors.WithLabelValues(append(t.budgeted("errors", values), kind)...).Inc()
+}
+
+// values returns the values of the enabled dimensions, lowercased without trailing dot
+func (t *Tenants) values(byDimension map[string]string, withType bool) []string {
+	labels := t.labels(withType)
+	values := make([]string, len(labels))
+	for i, label := range labels {
+		values[i] = strings.ToLower(strings.TrimSuffix(byDimension[label], "."))
+	}
+	return values
+}
+
+// budgeted returns the values of a series of a metric, or the overflow values when
+// the series is new and the budget of the metric is spent
+func (t *Tenants) budgeted(metric string, values []string) []string {
+	key := strings.Join(values, "\x00")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	series := t.series[metric]
+	if series == nil {
+		series = make(map[string]bool)
+		t.series[metric] = series
+	}
+	if series[key] {
+		return values
+	}
+	if len(series) < t.budget {
+		series[key] = true
+		return values
+	}
+	t.overflowed.Inc()
+	overflow := make([]string, len(values))
+	for i := range overflow {
+		overflow[i] = Overflow
+	}
+	return overflow
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTenants(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	tenants, err := NewTenants([]string{DimensionKey, DimensionZone}, 2, registry)
+	if err != nil {
+		t.Fatalf("NewTenants() failed: %v", err)
+	}
+
+	tenants.Update("a.example.com.", "router-a.", "A", "192.0.2.1")
+	tenants.Update("b.example.com.", "router-b.", "AAAA", "192.0.2.2")
+	// Beyond the budget of 2 series
+	tenants.Update("c.example.com.", "router-c.", "A", "192.0.2.3")
+	tenants.Update("d.example.com.", "router-d.", "A", "192.0.2.4")
+	// Known series are still counted
+	tenants.Update("A.example.com", "router-a", "AAAA", "192.0.2.5")
+	tenants.Error("a.example.com.", "router-a.", "192.0.2.1", "name_owned")
+
+	tests := []struct {
+		zone, key string
+		expected  float64
+	}{
+		{"a.example.com", "router-a", 2},
+		{"b.example.com", "router-b", 1},
+		{Overflow, Overflow, 2},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tenants.updates.WithLabelValues(tt.zone, tt.key)); got != tt.expected {
+			t.Errorf("updates of %s/%s = %v, expected %v", tt.zone, tt.key, got, tt.expected)
+		}
+	}
+	if got := testutil.ToFloat64(tenants.overflowed); got != 2 {
+		t.Errorf("overflowed = %v, expected 2", got)
+	}
+	if got := testutil.ToFloat64(tenants.errors.WithLabelValues("a.example.com", "router-a", "name_owned")); got != 1 {
+		t.Errorf("errors = %v, expected 1", got)
+	}
+	if got := testutil.CollectAndCount(tenants.updates); got != 3 {
+		t.Errorf("Expected 3 update series, got %d", got)
+	}
+
+	var nilTenants *Tenants
+	nilTenants.Update("a.example.com.", "", "A", "192.0.2.1")
+
+	if _, err := NewTenants([]string{"name"}, 10, prometheus.NewRegistry()); err == nil {
+		t.Error("Expected an unknown dimension to be refused")
+	}
+}
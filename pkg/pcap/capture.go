@@ -0,0 +1,267 @@
+package pcap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Options configures a Capture
+type Options struct {
+	// Path of the pcap file, truncated when the capture starts
+	Path string
+	// Clients restricts the capture to these networks, when not empty
+	Clients []*net.IPNet
+	// Zones restricts the capture to the messages of names in these zones, when not empty
+	Zones []string
+	// MaxSize stops the capture once the file reaches this size in bytes
+	MaxSize int64
+	// Duration stops the capture after this time
+	Duration time.Duration
+}
+
+// Capture writes the raw DNS messages of the matching clients and zones to a
+// pcap file, wrapped in synthetic IP, UDP and TCP headers, until its size or
+// duration limit is reached
+type Capture struct {
+	options Options
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	packets int
+	stopped bool
+	// seqs holds the next TCP sequence number of each direction of a connection
+	seqs map[string]uint32
+}
+
+// Start creates the capture file and stops the capture after options.Duration
+func Start(options Options) (*Capture, error) {
+	file, err := os.OpenFile(options.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	if err := writeFileHeader(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write capture file: %w", err)
+	}
+	c := &Capture{options: options, file: file, size: 24, seqs: make(map[string]uint32)}
+	if options.Duration > 0 {
+		time.AfterFunc(options.Duration, func() { c.Stop("duration reached") })
+	}
+	return c, nil
+}
+
+// ParseClients parses client addresses and networks
+func ParseClients(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid client address %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client network %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Stop closes the capture file. A nil Capture has nothing to stop.
+func (c *Capture) Stop(reason string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop(reason)
+}
+
+// stop closes the capture file, with the lock held
+func (c *Capture) stop(reason string) {
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	if err := c.file.Close(); err != nil {
+		logrus.Errorf("Failed to close capture file %s: %v", c.options.Path, err)
+	}
+	logrus.Infof("Stopped capture to %s (%s): %d packets, %d bytes", c.options.Path, reason, c.packets, c.size)
+}
+
+// Path returns the path of the capture file
+func (c *Capture) Path() string {
+	return c.options.Path
+}
+
+// Matches checks if the messages of a client about a name are captured
+func (c *Capture) Matches(client net.Addr, name string) bool {
+	if len(c.options.Clients) > 0 {
+		ip := endpointOf(client).ip
+		found := false
+		for _, network := range c.options.Clients {
+			if network.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(c.options.Zones) > 0 {
+		for _, zone := range c.options.Zones {
+			if dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Write records a message sent from src to dst over UDP, or over TCP when tcp is set
+func (c *Capture) Write(src, dst net.Addr, tcp bool, message []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+
+	from, to := endpointOf(src), endpointOf(dst)
+	var packet []byte
+	if tcp {
+		// Messages are pushed back to back on a connection opened before the capture
+		framed := make([]byte, 2+len(message))
+		framed[0], framed[1] = byte(len(message)>>8), byte(len(message))
+		copy(framed[2:], message)
+		forward, backward := src.String()+">"+dst.String(), dst.String()+">"+src.String()
+		seq := c.seqs[forward]
+		c.seqs[forward] = seq + uint32(len(framed))
+		packet = tcpPacket(from, to, seq, c.seqs[backward], framed)
+	} else {
+		packet = udpPacket(from, to, message)
+	}
+
+	record := appendRecord(nil, time.Now(), packet)
+	if c.options.MaxSize > 0 && c.size+int64(len(record)) > c.options.MaxSize {
+		c.stop("size limit reached")
+		return
+	}
+	if _, err := c.file.Write(record); err != nil {
+		logrus.Errorf("Failed to write capture file %s: %v", c.options.Path, err)
+		c.stop("write error")
+		return
+	}
+	c.size += int64(len(record))
+	c.packets++
+}
+
+// DecorateReader records the raw messages of matching clients and zones as
+// they are read, before they are parsed, to be set as dns.Server DecorateReader
+func (c *Capture) DecorateReader(reader dns.Reader) dns.Reader {
+	return &captureReader{Reader: reader, capture: c}
+}
+
+// captureReader is a dns.Reader recording the messages it reads
+type captureReader struct {
+	dns.Reader
+	capture *Capture
+}
+
+func (r *captureReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	m, err := r.Reader.ReadTCP(conn, timeout)
+	if err == nil && r.capture.Matches(conn.RemoteAddr(), questionName(m)) {
+		r.capture.Write(conn.RemoteAddr(), conn.LocalAddr(), true, m)
+	}
+	return m, err
+}
+
+func (r *captureReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	m, session, err := r.Reader.ReadUDP(conn, timeout)
+	if err == nil && r.capture.Matches(session.RemoteAddr(), questionName(m)) {
+		r.capture.Write(session.RemoteAddr(), conn.LocalAddr(), false, m)
+	}
+	return m, session, err
+}
+
+// questionName returns the name of the first question of a raw message, or
+// the root when it cannot be read
+func questionName(m []byte) string {
+	if len(m) < 12 || m[4] == 0 && m[5] == 0 {
+		return "."
+	}
+	name, _, err := dns.UnpackDomainName(m, 12)
+	if err != nil {
+		return "."
+	}
+	return name
+}
+
+// Wrap returns a writer recording the responses to a message when it matches
+func (c *Capture) Wrap(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	name := "."
+	if len(r.Question) > 0 {
+		name = r.Question[0].Name
+	}
+	if !c.Matches(w.RemoteAddr(), name) {
+		return w
+	}
+	return &captureWriter{ResponseWriter: w, capture: c}
+}
+
+// captureWriter is a dns.ResponseWriter recording the responses it writes
+type captureWriter struct {
+	dns.ResponseWriter
+	capture *Capture
+}
+
+// Write writes a packed response and records it
+func (w *captureWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if err == nil {
+		w.record(b)
+	}
+	return n, err
+}
+
+// WriteMsg writes a response and records it
+func (w *captureWriter) WriteMsg(m *dns.Msg) error {
+	if err := w.ResponseWriter.WriteMsg(m); err != nil {
+		return err
+	}
+	if b, err := m.Pack(); err == nil {
+		w.record(b)
+	}
+	return nil
+}
+
+// ConnectionState returns the TLS state of the connection, if any
+func (w *captureWriter) ConnectionState() *tls.ConnectionState {
+	if stater, ok := w.ResponseWriter.(dns.ConnectionStater); ok {
+		return stater.ConnectionState()
+	}
+	return nil
+}
+
+func (w *captureWriter) record(b []byte) {
+	_, tcp := w.LocalAddr().(*net.TCPAddr)
+	w.capture.Write(w.LocalAddr(), w.RemoteAddr(), tcp, b)
+}
@@ -0,0 +1,154 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// linkTypeRaw is the link type of packets starting with their IPv4 or IPv6 header
+const linkTypeRaw = 101
+
+// snapLen is the largest packet recorded; longer TCP messages are truncated
+const snapLen = 65535
+
+// IP protocol numbers
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// writeFileHeader writes the global header of a pcap file
+func writeFileHeader(w io.Writer) error {
+	var b [24]byte
+	binary.LittleEndian.PutUint32(b[0:], 0xa1b2c3d4) // magic, microsecond timestamps
+	binary.LittleEndian.PutUint16(b[4:], 2)          // version 2.4
+	binary.LittleEndian.PutUint16(b[6:], 4)
+	binary.LittleEndian.PutUint32(b[16:], snapLen)
+	binary.LittleEndian.PutUint32(b[20:], linkTypeRaw)
+	_, err := w.Write(b[:])
+	return err
+}
+
+// appendRecord appends a packet record: its header, then the packet truncated to snapLen
+func appendRecord(b []byte, t time.Time, packet []byte) []byte {
+	captured := packet[:min(len(packet), snapLen)]
+	b = binary.LittleEndian.AppendUint32(b, uint32(t.Unix()))
+	b = binary.LittleEndian.AppendUint32(b, uint32(t.Nanosecond()/1000))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(captured)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+	return append(b, captured...)
+}
+
+// endpoint is an address and port of a packet
+type endpoint struct {
+	ip   net.IP
+	port int
+}
+
+// endpointOf returns the address and port of a UDP or TCP address
+func endpointOf(addr net.Addr) endpoint {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return endpoint{ip: a.IP, port: a.Port}
+	case *net.TCPAddr:
+		return endpoint{ip: a.IP, port: a.Port}
+	}
+	if addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			p, _ := strconv.Atoi(port)
+			return endpoint{ip: net.ParseIP(host), port: p}
+		}
+	}
+	return endpoint{ip: net.IPv4zero}
+}
+
+// udpPacket builds the IP packet of a UDP datagram
+func udpPacket(src, dst endpoint, payload []byte) []byte {
+	segment := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], uint16(src.port))
+	binary.BigEndian.PutUint16(segment[2:], uint16(dst.port))
+	binary.BigEndian.PutUint16(segment[4:], uint16(8+len(payload)))
+	segment = append(segment, payload...)
+	return ipPacket(src.ip, dst.ip, protoUDP, segment, 6)
+}
+
+// tcpPacket builds the IP packet of a TCP segment pushing payload at seq
+func tcpPacket(src, dst endpoint, seq, ack uint32, payload []byte) []byte {
+	segment := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], uint16(src.port))
+	binary.BigEndian.PutUint16(segment[2:], uint16(dst.port))
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = 5 << 4 // data offset
+	segment[13] = 0x18   // PSH, ACK
+	binary.BigEndian.PutUint16(segment[14:], 65535)
+	segment = append(segment, payload...)
+	return ipPacket(src.ip, dst.ip, protoTCP, segment, 16)
+}
+
+// ipPacket wraps a UDP or TCP segment in an IPv4 or IPv6 header, filling the
+// segment checksum at checksumOffset
+func ipPacket(src, dst net.IP, proto byte, segment []byte, checksumOffset int) []byte {
+	src4, dst4 := src.To4(), dst.To4()
+	if src4 != nil && dst4 != nil {
+		sum := pseudoSum(src4, dst4, proto, len(segment))
+		binary.BigEndian.PutUint16(segment[checksumOffset:], finish(sum, segment))
+
+		header := make([]byte, 20, 20+len(segment))
+		header[0] = 0x45 // version 4, 20 bytes header
+		binary.BigEndian.PutUint16(header[2:], uint16(min(20+len(segment), 0xffff)))
+		header[8] = 64 // TTL
+		header[9] = proto
+		copy(header[12:], src4)
+		copy(header[16:], dst4)
+		binary.BigEndian.PutUint16(header[10:], finish(0, header))
+		return append(header, segment...)
+	}
+
+	src16, dst16 := src.To16(), dst.To16()
+	if src16 == nil {
+		src16 = net.IPv6unspecified
+	}
+	if dst16 == nil {
+		dst16 = net.IPv6unspecified
+	}
+	sum := pseudoSum(src16, dst16, proto, len(segment))
+	binary.BigEndian.PutUint16(segment[checksumOffset:], finish(sum, segment))
+
+	header := make([]byte, 40, 40+len(segment))
+	header[0] = 6 << 4
+	binary.BigEndian.PutUint16(header[4:], uint16(min(len(segment), 0xffff)))
+	header[6] = proto
+	header[7] = 64 // hop limit
+	copy(header[8:], src16)
+	copy(header[24:], dst16)
+	return append(header, segment...)
+}
+
+// pseudoSum sums the pseudo-header covered by UDP and TCP checksums
+func pseudoSum(src, dst net.IP, proto byte, length int) uint32 {
+	var sum uint32
+	for _, ip := range []net.IP{src, dst} {
+		for i := 0; i < len(ip); i += 2 {
+			sum += uint32(ip[i])<<8 | uint32(ip[i+1])
+		}
+	}
+	return sum + uint32(proto) + uint32(length&0xffff) + uint32(length>>16)
+}
+
+// finish adds data to a one's complement sum and returns the checksum
+func finish(sum uint32, data []byte) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,143 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// readRecords reads the packets of a pcap file
+func readRecords(t *testing.T, path string) [][]byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read capture: %v", err)
+	}
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(b[20:]) != linkTypeRaw {
+		t.Fatalf("invalid pcap header: % x", b[:min(len(b), 24)])
+	}
+	var packets [][]byte
+	for b = b[24:]; len(b) >= 16; {
+		n := binary.LittleEndian.Uint32(b[8:])
+		packets = append(packets, b[16:16+n])
+		b = b[16+n:]
+	}
+	if len(b) != 0 {
+		t.Fatalf("%d trailing bytes", len(b))
+	}
+	return packets
+}
+
+func testMessage(t *testing.T, name string) []byte {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(name))
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack message: %v", err)
+	}
+	return b
+}
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	clients, err := ParseClients([]string{"192.0.2.0/24", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseClients() error = %v", err)
+	}
+	c, err := Start(Options{Path: path, Clients: clients, Zones: []string{"example.com"}})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	router := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+	server := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+	message := testMessage(t, "example.com")
+	c.Write(router, server, false, message)
+	c.Write(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}, &net.TCPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}, true, message)
+	c.Stop("test")
+	c.Write(router, server, false, message)
+
+	packets := readRecords(t, path)
+	if len(packets) != 2 {
+		t.Fatalf("captured %d packets, expected 2", len(packets))
+	}
+
+	udp := packets[0]
+	if udp[0] != 0x45 || udp[9] != protoUDP || finish(0, udp[:20]) != 0 {
+		t.Errorf("invalid IPv4 header: % x", udp[:20])
+	}
+	if sum := pseudoSum(udp[12:16], udp[16:20], protoUDP, len(udp)-20); finish(sum, udp[20:]) != 0 {
+		t.Error("invalid UDP checksum")
+	}
+	if string(udp[28:]) != string(message) {
+		t.Error("UDP payload differs from the message")
+	}
+
+	tcp := packets[1]
+	if tcp[0]>>4 != 6 || tcp[6] != protoTCP {
+		t.Errorf("invalid IPv6 header: % x", tcp[:40])
+	}
+	if length := binary.BigEndian.Uint16(tcp[60:]); int(length) != len(message) || string(tcp[62:]) != string(message) {
+		t.Error("TCP payload differs from the framed message")
+	}
+}
+
+func TestCaptureMatches(t *testing.T) {
+	clients, _ := ParseClients([]string{"192.0.2.0/24"})
+	c := &Capture{options: Options{Clients: clients, Zones: []string{"example.com."}}}
+	tests := []struct {
+		client string
+		name   string
+		want   bool
+	}{
+		{"192.0.2.10", "host.example.com.", true},
+		{"192.0.2.10", "example.com", true},
+		{"192.0.2.10", "example.org.", false},
+		{"198.51.100.1", "host.example.com.", false},
+	}
+	for _, tt := range tests {
+		addr := &net.UDPAddr{IP: net.ParseIP(tt.client), Port: 53}
+		if got := c.Matches(addr, tt.name); got != tt.want {
+			t.Errorf("Matches(%s, %s) = %v, want %v", tt.client, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCaptureMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	message := testMessage(t, "example.com")
+	// Room for a single packet
+	c, err := Start(Options{Path: path, MaxSize: 24 + 16 + 28 + int64(len(message)) + 10})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	for i := 0; i < 3; i++ {
+		c.Write(addr, addr, false, message)
+	}
+	if packets := readRecords(t, path); len(packets) != 1 {
+		t.Errorf("captured %d packets, expected 1", len(packets))
+	}
+}
+
+func TestParseClientsInvalid(t *testing.T) {
+	for _, value := range []string{"not-an-ip", "192.0.2.0/33"} {
+		if _, err := ParseClients([]string{value}); err == nil {
+			t.Errorf("ParseClients(%q) expected an error", value)
+		}
+	}
+}
+
+func TestQuestionName(t *testing.T) {
+	if name := questionName(testMessage(t, "Example.COM")); name != "Example.COM." {
+		t.Errorf("questionName() = %q", name)
+	}
+	if name := questionName([]byte{1, 2, 3}); name != "." {
+		t.Errorf("questionName(truncated) = %q", name)
+	}
+}
@@ -0,0 +1,108 @@
+// Package probe checks that the target address of a record answers before it is published
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Prober checks that a target address is reachable
+type Prober interface {
+	Probe(ctx context.Context, ip net.IP) error
+}
+
+// New creates a prober for network ("tcp" or "icmp"), port being the TCP port to connect to
+func New(network string, port int, timeout time.Duration) (Prober, error) {
+	switch network {
+	case "tcp":
+		return &TCPProber{Port: port, Timeout: timeout}, nil
+	case "icmp":
+		return &ICMPProber{Timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("unsupported probe network %q", network)
+}
+
+// TCPProber considers a target reachable when it accepts connections on Port
+type TCPProber struct {
+	Port    int
+	Timeout time.Duration
+}
+
+// Probe implements Prober
+func (p *TCPProber) Probe(ctx context.Context, ip net.IP) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(p.Port)))
+	if err != nil {
+		return fmt.Errorf("tcp port %d of %s not reachable: %w", p.Port, ip, err)
+	}
+	return conn.Close()
+}
+
+// ICMPProber considers a target reachable when it answers an echo request. It uses
+// unprivileged ICMP sockets, allowed by the net.ipv4.ping_group_range sysctl.
+type ICMPProber struct {
+	Timeout time.Duration
+}
+
+// Probe implements Prober
+func (p *ICMPProber) Probe(ctx context.Context, ip net.IP) error {
+	network, protocol := "udp4", 1
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, protocol = "udp6", 58
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		return fmt.Errorf("failed to open ICMP socket: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(p.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	// The kernel sets the identifier of unprivileged echo requests, match replies on the sequence
+	seq := rand.IntN(1 << 16)
+	request, err := (&icmp.Message{
+		Type: echoType,
+		Body: &icmp.Echo{Seq: seq, Data: []byte("ddnsbridge4extdns")},
+	}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(request, &net.UDPAddr{IP: ip}); err != nil {
+		return fmt.Errorf("failed to send ICMP echo to %s: %w", ip, err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("no ICMP echo reply from %s: %w", ip, err)
+		}
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.Seq == seq {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	prober, err := New("tcp", port, time.Second)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := prober.Probe(context.Background(), net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("Expected open port to be reachable, got %v", err)
+	}
+
+	listener.Close()
+	if err := prober.Probe(context.Background(), net.ParseIP("127.0.0.1")); err == nil {
+		t.Error("Expected closed port to be unreachable")
+	}
+}
+
+func TestNewUnsupported(t *testing.T) {
+	if _, err := New("udp", 53, time.Second); err == nil {
+		t.Error("Expected error for unsupported network")
+	}
+}
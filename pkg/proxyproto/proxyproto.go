@@ -0,0 +1,249 @@
+// Package proxyproto reads the PROXY protocol header (v1 and v2) that load
+// balancers prepend to TCP connections, so that the server sees the address of
+// the client instead of the address of the load balancer
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// signatureV2 starts a PROXY protocol v2 header
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length is the maximum length of a v1 header, CRLF included
+const maxV1Length = 107
+
+// ErrNoHeader is returned for a connection that does not start with a PROXY header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Listener reads the PROXY header of the connections it accepts from trusted
+// networks, none when trusted is empty. Connections from other networks are
+// served with their own address. A trusted connection without a valid header
+// within timeout is closed.
+func Listener(inner net.Listener, trusted []*net.IPNet, timeout time.Duration) net.Listener {
+	ln := &listener{
+		Listener: inner,
+		trusted:  trusted,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go ln.run()
+	return ln
+}
+
+// listener reads the headers of the accepted connections concurrently, so that a
+// slow client does not hold the others
+type listener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+
+	conns     chan net.Conn
+	err       error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// run accepts the connections of the inner listener until it fails
+func (ln *listener) run() {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			ln.close(err)
+			return
+		}
+		go ln.handshake(c)
+	}
+}
+
+// handshake reads the header of a connection and hands it to Accept
+func (ln *listener) handshake(c net.Conn) {
+	if !ln.trusts(c.RemoteAddr()) {
+		ln.deliver(c)
+		return
+	}
+	wrapped, err := readHeader(c, ln.timeout)
+	if err != nil {
+		metrics.ProxyHeaderFailures.Inc()
+		logrus.Warnf("Closed TCP connection from %s: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	ln.deliver(wrapped)
+}
+
+// deliver hands a connection to Accept, or closes it once the listener is closed
+func (ln *listener) deliver(c net.Conn) {
+	select {
+	case ln.conns <- c:
+	case <-ln.done:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection with the address of its client
+func (ln *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		return c, nil
+	case <-ln.done:
+		if ln.err != nil {
+			return nil, ln.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the inner listener
+func (ln *listener) Close() error {
+	return ln.close(nil)
+}
+
+// close closes the inner listener once, Accept then returning cause
+func (ln *listener) close(cause error) error {
+	var err error
+	ln.closeOnce.Do(func() {
+		ln.err = cause
+		close(ln.done)
+		err = ln.Listener.Close()
+	})
+	return err
+}
+
+// trusts checks if a load balancer at addr may send a PROXY header
+func (ln *listener) trusts(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range ln.trusted {
+		if network.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// conn is a connection whose remote address is the client address of its header
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readHeader reads the PROXY header of a connection. The connection keeps the
+// address of the load balancer for LOCAL (v2) and UNKNOWN (v1) headers, sent by
+// health checks.
+func readHeader(c net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+		defer c.SetReadDeadline(time.Time{})
+	}
+	reader := bufio.NewReaderSize(c, 256)
+	start, err := reader.Peek(len(signatureV2))
+	if err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(start, signatureV2):
+		remote, err = readV2(reader)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		remote, err = readV1(reader)
+	default:
+		return nil, ErrNoHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = c.RemoteAddr()
+	}
+	return &conn{Conn: c, reader: reader, remote: remote}, nil
+}
+
+// readV1 reads a text header, "PROXY TCP4 src dst sport dport\r\n"
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Length {
+			return nil, fmt.Errorf("PROXY v1 header longer than %d bytes", maxV1Length)
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("malformed PROXY v1 source %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 reads a binary header, skipping its TLVs
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("reading PROXY v2 header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("reading PROXY v2 addresses: %w", err)
+	}
+
+	// LOCAL connections come from the load balancer itself
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("truncated PROXY v2 IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("truncated PROXY v2 IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:]))}, nil
+	}
+	// Other transports keep the address of the load balancer
+	return nil, nil
+}
@@ -0,0 +1,194 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// headerV2 builds a v2 header with a command, a family and its address payload
+func headerV2(command, family byte, payload []byte) []byte {
+	header := append([]byte(nil), signatureV2...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// addressesV4 builds the IPv4 address payload of a v2 header
+func addressesV4(src, dst string, sport, dport uint16) []byte {
+	payload := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	payload = binary.BigEndian.AppendUint16(payload, sport)
+	return binary.BigEndian.AppendUint16(payload, dport)
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     []byte
+		wantRemote string
+		wantErr    bool
+	}{
+		{
+			name:       "v1 TCP4",
+			header:     []byte("PROXY TCP4 198.51.100.7 192.0.2.53 40000 53\r\n"),
+			wantRemote: "198.51.100.7:40000",
+		},
+		{
+			name:       "v1 TCP6",
+			header:     []byte("PROXY TCP6 2001:db8::7 2001:db8::53 40000 53\r\n"),
+			wantRemote: "[2001:db8::7]:40000",
+		},
+		{
+			name:       "v1 UNKNOWN keeps the load balancer",
+			header:     []byte("PROXY UNKNOWN\r\n"),
+			wantRemote: "pipe",
+		},
+		{
+			name:    "v1 malformed",
+			header:  []byte("PROXY TCP4 198.51.100.7\r\n"),
+			wantErr: true,
+		},
+		{
+			name:       "v2 IPv4",
+			header:     headerV2(1, 0x11, addressesV4("198.51.100.7", "192.0.2.53", 40000, 53)),
+			wantRemote: "198.51.100.7:40000",
+		},
+		{
+			name:       "v2 IPv4 with TLVs",
+			header:     headerV2(1, 0x11, append(addressesV4("198.51.100.7", "192.0.2.53", 40000, 53), 0x04, 0, 1, 0)),
+			wantRemote: "198.51.100.7:40000",
+		},
+		{
+			name:       "v2 LOCAL keeps the load balancer",
+			header:     headerV2(0, 0, nil),
+			wantRemote: "pipe",
+		},
+		{
+			name:    "v2 truncated addresses",
+			header:  headerV2(1, 0x11, []byte{198, 51, 100, 7}),
+			wantErr: true,
+		},
+		{
+			name:    "no header",
+			header:  []byte("\x00\x1c\x12\x34\x28\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go func() {
+				client.Write(tt.header)
+				client.Write([]byte("payload"))
+			}()
+
+			c, err := readHeader(server, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := c.RemoteAddr().String(); got != tt.wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, tt.wantRemote)
+			}
+			payload := make([]byte, len("payload"))
+			if _, err := io.ReadFull(c, payload); err != nil || string(payload) != "payload" {
+				t.Errorf("Read() = %q, %v, want the bytes following the header", payload, err)
+			}
+		})
+	}
+}
+
+func TestListenerTrustedNetworks(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("192.0.2.0/24")
+	tests := []struct {
+		name       string
+		trusted    []*net.IPNet
+		write      string
+		wantRemote string
+		wantClosed bool
+	}{
+		{
+			name:       "trusted source with a header",
+			trusted:    []*net.IPNet{loopback},
+			write:      "PROXY TCP4 198.51.100.7 192.0.2.53 40000 53\r\n",
+			wantRemote: "198.51.100.7:40000",
+		},
+		{
+			name:       "no source trusted by default",
+			write:      "PROXY TCP4 198.51.100.7 192.0.2.53 40000 53\r\n",
+			wantRemote: "127.0.0.1",
+		},
+		{
+			name:       "trusted source without a header",
+			trusted:    []*net.IPNet{loopback},
+			write:      "not a PROXY header",
+			wantClosed: true,
+		},
+		{
+			name:       "untrusted source keeps its address",
+			trusted:    []*net.IPNet{other},
+			write:      "PROXY TCP4 198.51.100.7 192.0.2.53 40000 53\r\n",
+			wantRemote: "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Listen() failed: %v", err)
+			}
+			ln := Listener(inner, tt.trusted, 200*time.Millisecond)
+			defer ln.Close()
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				if c, err := ln.Accept(); err == nil {
+					accepted <- c
+				}
+			}()
+
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatalf("Dial() failed: %v", err)
+			}
+			defer client.Close()
+			client.Write([]byte(tt.write))
+
+			if tt.wantClosed {
+				client.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := client.Read(make([]byte, 1)); err == nil {
+					t.Error("Expected the connection to be closed")
+				}
+				select {
+				case <-accepted:
+					t.Error("Expected the connection not to be accepted")
+				default:
+				}
+				return
+			}
+
+			select {
+			case c := <-accepted:
+				defer c.Close()
+				remote := c.RemoteAddr().String()
+				if host, _, err := net.SplitHostPort(remote); err == nil && tt.wantRemote == host {
+					remote = host
+				}
+				if remote != tt.wantRemote {
+					t.Errorf("RemoteAddr() = %s, want %s", c.RemoteAddr(), tt.wantRemote)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Expected the connection to be accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package push sends the records published in the cluster to an external DNS server
+// with signed RFC 2136 updates, the reverse of the bridge
+package push
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// defaultTTL is the TTL of records published without one
+const defaultTTL = 300
+
+// maxRRsetsPerUpdate bounds the RRsets changed by a single UPDATE message, keeping
+// the first push of a large zone within the message size
+const maxRRsetsPerUpdate = 100
+
+// Exchanger sends a message and returns the response; *dns.Client implements it
+type Exchanger interface {
+	Exchange(m *dns.Msg, address string) (*dns.Msg, time.Duration, error)
+}
+
+// Options configures a Pusher
+type Options struct {
+	// Server is the address of the DNS server, host:port
+	Server string
+	// KeyName, Algorithm and Secret sign the updates with TSIG
+	KeyName   string
+	Algorithm string
+	Secret    string
+	// ZoneOf returns the zone of a name among the pushed zones, or an empty string for
+	// the names that are not pushed
+	ZoneOf func(name string) string
+}
+
+// rrsetKey identifies an RRset
+type rrsetKey struct {
+	name, recordType string
+}
+
+// Pusher replaces the RRsets of the server by the published records that changed
+// since its last push
+type Pusher struct {
+	options Options
+	client  Exchanger
+
+	mu sync.Mutex
+	// pushed holds the RRsets the server is known to hold, by zone
+	pushed map[string]map[rrsetKey]k8s.Record
+}
+
+// New creates a Pusher sending updates with client
+func New(options Options, client Exchanger) *Pusher {
+	options.KeyName = dns.Fqdn(strings.ToLower(options.KeyName))
+	return &Pusher{options: options, client: client, pushed: make(map[string]map[rrsetKey]k8s.Record)}
+}
+
+// Sync brings the server in line with the published records: changed RRsets are
+// replaced and RRsets no longer published are deleted, in one or a few UPDATE
+// messages per zone. The first Sync replaces every published RRset. A zone whose
+// update fails is retried as a whole at the next Sync.
+func (p *Pusher) Sync(records []k8s.Record) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	desired := make(map[string]map[rrsetKey]k8s.Record)
+	for _, record := range records {
+		zone := p.options.ZoneOf(record.Name)
+		if zone == "" {
+			continue
+		}
+		if desired[zone] == nil {
+			desired[zone] = make(map[rrsetKey]k8s.Record)
+		}
+		desired[zone][rrsetKey{record.Name, record.Type}] = record
+	}
+
+	var errs []error
+	for _, zone := range zonesOf(desired, p.pushed) {
+		if err := p.syncZone(zone, desired[zone]); err != nil {
+			metrics.PushedUpdates.WithLabelValues(zone, "failure").Inc()
+			errs = append(errs, fmt.Errorf("failed to push zone %s: %w", zone, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncZone replaces the RRsets of a zone that changed and deletes the removed ones
+func (p *Pusher) syncZone(zone string, desired map[rrsetKey]k8s.Record) error {
+	pushed := p.pushed[zone]
+	var changed []k8s.Record
+	var removed []rrsetKey
+	for key, record := range desired {
+		if previous, ok := pushed[key]; !ok || !sameRecord(previous, record) {
+			changed = append(changed, record)
+		}
+	}
+	for key := range pushed {
+		if _, ok := desired[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return lessKey(rrsetKey{changed[i].Name, changed[i].Type}, rrsetKey{changed[j].Name, changed[j].Type})
+	})
+	sort.Slice(removed, func(i, j int) bool { return lessKey(removed[i], removed[j]) })
+
+	applied := make(map[rrsetKey]k8s.Record, len(pushed))
+	for key, record := range pushed {
+		applied[key] = record
+	}
+	for len(changed) > 0 || len(removed) > 0 {
+		msg := new(dns.Msg)
+		msg.SetUpdate(zone)
+		count := 0
+		for len(removed) > 0 && count < maxRRsetsPerUpdate {
+			msg.RemoveRRset([]dns.RR{rrsetHeader(removed[0])})
+			delete(applied, removed[0])
+			removed = removed[1:]
+			count++
+		}
+		for len(changed) > 0 && count < maxRRsetsPerUpdate {
+			record := changed[0]
+			changed = changed[1:]
+			rrs, err := recordRRs(record)
+			if err != nil {
+				logrus.Warnf("Not pushing %s %s: %v", record.Type, record.Name, err)
+				continue
+			}
+			msg.RemoveRRset([]dns.RR{rrsetHeader(rrsetKey{record.Name, record.Type})})
+			msg.Insert(rrs)
+			applied[rrsetKey{record.Name, record.Type}] = record
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		if err := p.send(msg); err != nil {
+			return err
+		}
+		metrics.PushedUpdates.WithLabelValues(zone, "success").Inc()
+		logrus.Infof("Pushed %d change(s) of zone %s to %s", count, zone, p.options.Server)
+		// The server holds what was sent so far, even if a later message fails
+		p.pushed[zone] = copyRRsets(applied)
+	}
+	if len(applied) == 0 {
+		delete(p.pushed, zone)
+	}
+	return nil
+}
+
+// send signs an UPDATE and checks the answer of the server
+func (p *Pusher) send(msg *dns.Msg) error {
+	if p.options.Secret != "" {
+		msg.SetTsig(p.options.KeyName, p.options.Algorithm, 300, time.Now().Unix())
+	}
+	resp, _, err := p.client.Exchange(msg, p.options.Server)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("server answered %s", dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
+
+// NewClient returns a DNS client signing with the key of options over a network,
+// "udp" or "tcp"
+func NewClient(options Options, network string, timeout time.Duration) *dns.Client {
+	client := &dns.Client{Net: network, Timeout: timeout}
+	if options.Secret != "" {
+		client.TsigSecret = map[string]string{dns.Fqdn(strings.ToLower(options.KeyName)): options.Secret}
+	}
+	return client
+}
+
+// recordRRs returns the resource records of a published record
+func recordRRs(record k8s.Record) ([]dns.RR, error) {
+	ttl := record.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	rrs := make([]dns.RR, 0, len(record.Targets))
+	for _, target := range record.Targets {
+		if record.Type == "TXT" && !strings.HasPrefix(target, `"`) {
+			target = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(target) + `"`
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(record.Name), ttl, record.Type, target))
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		if rr == nil {
+			return nil, fmt.Errorf("empty target")
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// rrsetHeader returns an RR naming an RRset, for its deletion
+func rrsetHeader(key rrsetKey) dns.RR {
+	return &dns.ANY{Hdr: dns.RR_Header{Name: dns.Fqdn(key.name), Rrtype: dns.StringToType[key.recordType], Class: dns.ClassINET}}
+}
+
+// sameRecord checks if two records hold the same TTL and targets
+func sameRecord(a, b k8s.Record) bool {
+	if a.TTL != b.TTL || len(a.Targets) != len(b.Targets) {
+		return false
+	}
+	for i := range a.Targets {
+		if a.Targets[i] != b.Targets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// zonesOf returns the zones of the desired and pushed RRsets
+func zonesOf(desired, pushed map[string]map[rrsetKey]k8s.Record) []string {
+	zones := make([]string, 0, len(desired)+len(pushed))
+	for zone := range desired {
+		zones = append(zones, zone)
+	}
+	for zone := range pushed {
+		if _, ok := desired[zone]; !ok {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// lessKey orders RRsets by name and type
+func lessKey(a, b rrsetKey) bool {
+	if a.name != b.name {
+		return a.name < b.name
+	}
+	return a.recordType < b.recordType
+}
+
+// copyRRsets copies the RRsets of a zone
+func copyRRsets(rrsets map[rrsetKey]k8s.Record) map[rrsetKey]k8s.Record {
+	copied := make(map[rrsetKey]k8s.Record, len(rrsets))
+	for key, record := range rrsets {
+		copied[key] = record
+	}
+	return copied
+}
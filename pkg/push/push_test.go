@@ -0,0 +1,144 @@
+package push
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+)
+
+// fakeExchanger records the UPDATE messages it receives, failing when fail is set
+type fakeExchanger struct {
+	messages []*dns.Msg
+	fail     bool
+}
+
+func (f *fakeExchanger) Exchange(m *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	f.messages = append(f.messages, m.Copy())
+	resp := new(dns.Msg).SetReply(m)
+	if f.fail {
+		resp.Rcode = dns.RcodeRefused
+	}
+	return resp, 0, nil
+}
+
+// zoneOf pushes example.com only
+func zoneOf(name string) string {
+	if name == "example.com" || strings.HasSuffix(name, ".example.com") {
+		return "example.com."
+	}
+	return ""
+}
+
+// updateText summarizes the update section of a message, one line per RR
+func updateText(msg *dns.Msg) []string {
+	lines := make([]string, 0, len(msg.Ns))
+	for _, rr := range msg.Ns {
+		hdr := rr.Header()
+		line := fmt.Sprintf("%s %s %s", dns.ClassToString[hdr.Class], hdr.Name, dns.TypeToString[hdr.Rrtype])
+		if hdr.Class == dns.ClassINET {
+			line = strings.Join(strings.Fields(rr.String())[3:], " ") + " " + fmt.Sprint(hdr.Ttl)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestSync(t *testing.T) {
+	client := &fakeExchanger{}
+	pusher := New(Options{Server: "192.0.2.53:53", KeyName: "bind-key", Algorithm: dns.HmacSHA256, Secret: "c2VjcmV0", ZoneOf: zoneOf}, client)
+
+	steps := []struct {
+		name    string
+		records []k8s.Record
+		fail    bool
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "first push",
+			records: []k8s.Record{
+				{Name: "www.example.com", Type: "A", TTL: 60, Targets: []string{"192.0.2.1", "192.0.2.2"}},
+				{Name: "www.example.com", Type: "TXT", Targets: []string{"owner=cluster"}},
+				{Name: "www.example.org", Type: "A", Targets: []string{"192.0.2.9"}},
+			},
+			want: []string{
+				"ANY www.example.com. A", "A 192.0.2.1 60", "A 192.0.2.2 60",
+				"ANY www.example.com. TXT", `TXT "owner=cluster" 300`,
+			},
+		},
+		{
+			name: "unchanged",
+			records: []k8s.Record{
+				{Name: "www.example.com", Type: "A", TTL: 60, Targets: []string{"192.0.2.1", "192.0.2.2"}},
+				{Name: "www.example.com", Type: "TXT", Targets: []string{"owner=cluster"}},
+			},
+		},
+		{
+			name: "changed and removed",
+			records: []k8s.Record{
+				{Name: "www.example.com", Type: "A", TTL: 60, Targets: []string{"192.0.2.3"}},
+			},
+			want: []string{"ANY www.example.com. TXT", "ANY www.example.com. A", "A 192.0.2.3 60"},
+		},
+		{
+			name:    "refused",
+			records: []k8s.Record{},
+			fail:    true,
+			want:    []string{"ANY www.example.com. A"},
+			wantErr: true,
+		},
+		{
+			name:    "retried",
+			records: []k8s.Record{},
+			want:    []string{"ANY www.example.com. A"},
+		},
+	}
+
+	for _, step := range steps {
+		client.messages, client.fail = nil, step.fail
+		err := pusher.Sync(step.records)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: Sync() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		if len(step.want) == 0 {
+			if len(client.messages) != 0 {
+				t.Fatalf("%s: expected no update, got %v", step.name, client.messages)
+			}
+			continue
+		}
+		if len(client.messages) != 1 {
+			t.Fatalf("%s: expected 1 update, got %d", step.name, len(client.messages))
+		}
+		msg := client.messages[0]
+		if msg.Question[0].Name != "example.com." || msg.IsTsig() == nil || msg.IsTsig().Hdr.Name != "bind-key." {
+			t.Errorf("%s: expected an update of example.com. signed with bind-key., got %v", step.name, msg)
+		}
+		got := updateText(msg)
+		if strings.Join(got, "\n") != strings.Join(step.want, "\n") {
+			t.Errorf("%s: update =\n%s\nwant\n%s", step.name, strings.Join(got, "\n"), strings.Join(step.want, "\n"))
+		}
+	}
+}
+
+func TestSyncSplitsLargeZones(t *testing.T) {
+	client := &fakeExchanger{}
+	pusher := New(Options{Server: "192.0.2.53:53", ZoneOf: zoneOf}, client)
+
+	records := make([]k8s.Record, 0, 250)
+	for i := 0; i < 250; i++ {
+		records = append(records, k8s.Record{Name: fmt.Sprintf("host%03d.example.com", i), Type: "A", Targets: []string{"192.0.2.1"}})
+	}
+	if err := pusher.Sync(records); err != nil {
+		t.Fatalf("Sync() failed: %v", err)
+	}
+	if len(client.messages) != 3 {
+		t.Fatalf("expected 3 updates, got %d", len(client.messages))
+	}
+	if client.messages[0].IsTsig() != nil {
+		t.Error("expected unsigned updates without secret")
+	}
+}
@@ -0,0 +1,125 @@
+package quirks
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Quirks are the deviations from RFC 2136 tolerated from a client
+type Quirks struct {
+	// ZoneFromName accepts a zone section naming a host below an allowed zone, as
+	// clients configured with the updated name as their zone send it: the update
+	// applies to the allowed zone enclosing it
+	ZoneFromName bool
+	// AnyZoneClass accepts a zone section of class ANY, handled as IN
+	AnyZoneClass bool
+	// SOAQueries answers the SOA queries clients send to find the zone of a name
+	// before updating it, even when SERVE_SOA is disabled
+	SOAQueries bool
+	// DHCPRecords accepts the PTR and DHCID updates of DHCP servers
+	DHCPRecords bool
+	// Retransmits answers the UDP retransmissions of an update with the response to
+	// the first transmission instead of applying it again
+	Retransmits bool
+}
+
+// Profiles are the quirks of the known clients, by name
+var Profiles = map[string]Quirks{
+	// OPNsense runs nsupdate without a zone: it finds the zone with an SOA query,
+	// and retransmits over UDP after 3 seconds
+	"opnsense": {SOAQueries: true, Retransmits: true},
+	// ddclient is often configured with the host name as its zone
+	"ddclient": {ZoneFromName: true, SOAQueries: true},
+	// Windows DHCP servers update PTR and DHCID records, find the zone with an SOA
+	// query, and retransmit over UDP while the first transmission is applied
+	"windows-dhcp": {DHCPRecords: true, SOAQueries: true, Retransmits: true},
+	// dnsmasq hooks send the zone section of the lease name, of class ANY
+	"dnsmasq": {ZoneFromName: true, AnyZoneClass: true},
+}
+
+// Names returns the names of the profiles, sorted
+func Names() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// merge returns the quirks of q and other
+func (q Quirks) merge(other Quirks) Quirks {
+	return Quirks{
+		ZoneFromName: q.ZoneFromName || other.ZoneFromName,
+		AnyZoneClass: q.AnyZoneClass || other.AnyZoneClass,
+		SOAQueries:   q.SOAQueries || other.SOAQueries,
+		DHCPRecords:  q.DHCPRecords || other.DHCPRecords,
+		Retransmits:  q.Retransmits || other.Retransmits,
+	}
+}
+
+// Rule applies the quirks of profiles to the messages signed with a key, or sent
+// from a network
+type Rule struct {
+	Key      string
+	Network  *net.IPNet
+	Profiles []string
+	quirks   Quirks
+}
+
+// Quirks returns the quirks of the profiles of the rule
+func (r Rule) Quirks() Quirks {
+	return r.quirks
+}
+
+// Rules select the quirks of the clients. A client matching several rules gets
+// the quirks of all of them.
+type Rules []Rule
+
+// Parse parses the profiles of the clients, by TSIG key name or source CIDR
+func Parse(profiles map[string][]string) (Rules, error) {
+	selectors := make([]string, 0, len(profiles))
+	for selector := range profiles {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+
+	rules := make(Rules, 0, len(profiles))
+	for _, selector := range selectors {
+		rule := Rule{Profiles: profiles[selector]}
+		if _, network, err := net.ParseCIDR(selector); err == nil {
+			rule.Network = network
+		} else {
+			rule.Key = normalizeKey(selector)
+		}
+		for _, name := range rule.Profiles {
+			quirks, ok := Profiles[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown quirk profile %q for %s, expected one of %s", name, selector, strings.Join(Names(), ", "))
+			}
+			rule.quirks = rule.quirks.merge(quirks)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// For returns the quirks of a client signing with keyName, empty when unsigned,
+// from ip
+func (r Rules) For(keyName string, ip net.IP) Quirks {
+	var quirks Quirks
+	key := normalizeKey(keyName)
+	for _, rule := range r {
+		if (rule.Key != "" && rule.Key == key) || (rule.Network != nil && ip != nil && rule.Network.Contains(ip)) {
+			quirks = quirks.merge(rule.quirks)
+		}
+	}
+	return quirks
+}
+
+// normalizeKey lowercases a key name without its trailing dot
+func normalizeKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
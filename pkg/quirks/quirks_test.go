@@ -0,0 +1,60 @@
+package quirks
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		profiles  map[string][]string
+		shouldErr bool
+	}{
+		{"key", map[string][]string{"router-key": {"opnsense"}}, false},
+		{"network", map[string][]string{"10.0.0.0/8": {"windows-dhcp", "dnsmasq"}}, false},
+		{"case insensitive", map[string][]string{"router-key": {"DDClient"}}, false},
+		{"unknown profile", map[string][]string{"router-key": {"pfsense"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.profiles)
+			if (err != nil) != tt.shouldErr {
+				t.Errorf("Parse() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+		})
+	}
+}
+
+func TestRulesFor(t *testing.T) {
+	rules, err := Parse(map[string][]string{
+		"Router-Key.":     {"opnsense"},
+		"192.0.2.0/24":    {"windows-dhcp"},
+		"198.51.100.0/24": {"dnsmasq"},
+	})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		ip       string
+		expected Quirks
+	}{
+		{"key", "router-key.", "203.0.113.1", Profiles["opnsense"]},
+		{"network", "", "192.0.2.10", Profiles["windows-dhcp"]},
+		{"key and network", "router-key", "198.51.100.1", Quirks{SOAQueries: true, Retransmits: true, ZoneFromName: true, AnyZoneClass: true}},
+		{"other client", "other-key", "203.0.113.1", Quirks{}},
+		{"no address", "", "", Quirks{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.For(tt.key, net.ParseIP(tt.ip)); got != tt.expected {
+				t.Errorf("For(%q, %s) = %+v, expected %+v", tt.key, tt.ip, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Package ratelimit bounds the rate of the messages of each source address with a
+// token bucket
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneEvery is the number of messages between two prunings of the idle sources
+const pruneEvery = 1024
+
+// Limiter gives each source a bucket of burst tokens refilled at rate tokens per
+// second, a message taking one token
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	allowed int
+}
+
+// bucket holds the tokens left to a source at the time of its last message
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a Limiter allowing rate messages per second to each source, with
+// bursts of burst messages, or returns nil when rate is not positive
+func New(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token of a source, and reports if it had one left. A nil
+// Limiter allows every message.
+func (l *Limiter) Allow(source string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.allowed++
+	if l.allowed%pruneEvery == 0 {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[source]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[source] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Sources returns the number of sources whose bucket is not full
+func (l *Limiter) Sources() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(l.now())
+	return len(l.buckets)
+}
+
+// refill returns the tokens of a bucket at now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+	return tokens
+}
+
+// prune forgets the sources whose bucket is full again, they start over with a
+// full bucket anyway
+func (l *Limiter) prune(now time.Time) {
+	for source, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, source)
+		}
+	}
+}
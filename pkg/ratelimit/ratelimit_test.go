@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		source  string
+		want    bool
+	}{
+		{"burst 1", 0, "192.0.2.1", true},
+		{"burst 2", 0, "192.0.2.1", true},
+		{"burst 3", 0, "192.0.2.1", true},
+		{"burst exhausted", 0, "192.0.2.1", false},
+		{"other source has its own bucket", 0, "192.0.2.2", true},
+		{"refilled one token", 500 * time.Millisecond, "192.0.2.1", true},
+		{"refilled token taken", 0, "192.0.2.1", false},
+		{"refill bounded by the burst", time.Hour, "192.0.2.1", true},
+		{"burst after refill 2", 0, "192.0.2.1", true},
+		{"burst after refill 3", 0, "192.0.2.1", true},
+		{"burst after refill exhausted", 0, "192.0.2.1", false},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+		if got := limiter.Allow(tt.source); got != tt.want {
+			t.Errorf("%s: Allow(%s) = %v, want %v", tt.name, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterPrunesIdleSources(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := New(1, 5)
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow("192.0.2.1")
+	limiter.Allow("192.0.2.2")
+	if got := limiter.Sources(); got != 2 {
+		t.Fatalf("Sources() = %d, want 2", got)
+	}
+
+	now = now.Add(2 * time.Second)
+	limiter.Allow("192.0.2.2")
+	if got := limiter.Sources(); got != 1 {
+		t.Errorf("Sources() = %d after 192.0.2.1 refilled, want 1", got)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	limiter := New(0, 10)
+	if limiter != nil {
+		t.Fatal("Expected no limiter without a rate")
+	}
+	if !limiter.Allow("192.0.2.1") {
+		t.Error("Expected a nil limiter to allow every message")
+	}
+}
@@ -0,0 +1,276 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// defaultTimeout bounds a command when Options.Timeout is 0
+const defaultTimeout = 2 * time.Second
+
+// poolSize is the number of idle connections kept open
+const poolSize = 8
+
+// ErrNil is returned by String when a key does not exist
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Options configures a Client
+type Options struct {
+	// Addr is the host:port of the server
+	Addr string
+	// Password authenticates the connections when not empty
+	Password string
+	// DB is the database selected on each connection
+	DB int
+	// TLS enables TLS to the server when not nil
+	TLS *tls.Config
+	// Timeout bounds each command, 2 seconds when 0
+	Timeout time.Duration
+}
+
+// Client sends commands to a Redis server over a small pool of connections.
+// Replies are decoded to string, int64, []interface{} or nil.
+type Client struct {
+	options Options
+	idle    chan *conn
+}
+
+// New creates a client; connections are opened on first use
+func New(options Options) *Client {
+	if options.Timeout == 0 {
+		options.Timeout = defaultTimeout
+	}
+	return &Client{options: options, idle: make(chan *conn, poolSize)}
+}
+
+// Do sends a command and returns its reply
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, err := c.Pipeline(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends commands at once on the same connection and returns their
+// replies, error replies included as Error values
+func (c *Client) Pipeline(ctx context.Context, commands ...[]string) ([]interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.options.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	replies, err := cn.pipeline(deadline, commands)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return replies, nil
+}
+
+// Close closes the idle connections
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// String returns a reply as a string, ErrNil when it is nil
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", ErrNil
+	}
+	return "", fmt.Errorf("redis: unexpected %T reply", reply)
+}
+
+// Int returns a reply as an integer
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("redis: unexpected %T reply", reply)
+}
+
+// get returns an idle connection or opens a new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.options.Timeout}
+	var nc net.Conn
+	var err error
+	if c.options.TLS != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: c.options.TLS}).DialContext(ctx, "tcp", c.options.Addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", c.options.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis %s: %w", c.options.Addr, err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	var setup [][]string
+	if c.options.Password != "" {
+		setup = append(setup, []string{"AUTH", c.options.Password})
+	}
+	if c.options.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.options.DB)})
+	}
+	if len(setup) > 0 {
+		replies, err := cn.pipeline(time.Now().Add(c.options.Timeout), setup)
+		if err == nil {
+			for _, reply := range replies {
+				if replyErr, ok := reply.(Error); ok {
+					err = replyErr
+				}
+			}
+		}
+		if err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("failed to set up Redis connection: %w", err)
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, closing it when the pool is full
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// conn is a connection to the server
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// pipeline writes commands and reads their replies
+func (cn *conn) pipeline(deadline time.Time, commands [][]string) ([]interface{}, error) {
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var b []byte
+	for _, args := range commands {
+		b = appendCommand(b, args)
+	}
+	if _, err := cn.Write(b); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(commands))
+	for i := range replies {
+		reply, err := readReply(cn.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// appendCommand appends a command as an array of bulk strings
+func appendCommand(b []byte, args []string) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, '\r', '\n')
+	for _, arg := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(arg)), 10)
+		b = append(b, '\r', '\n')
+		b = append(b, arg...)
+		b = append(b, '\r', '\n')
+	}
+	return b
+}
+
+// readReply reads a RESP2 reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply line %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return Error(value), nil
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", value)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", value)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
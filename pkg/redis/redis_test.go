@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected interface{}
+	}{
+		{name: "simple string", input: "+OK\r\n", expected: "OK"},
+		{name: "error", input: "-ERR unknown command\r\n", expected: Error("ERR unknown command")},
+		{name: "integer", input: ":42\r\n", expected: int64(42)},
+		{name: "bulk string", input: "$5\r\nhe\r\no\r\n", expected: "he\r\no"},
+		{name: "nil bulk string", input: "$-1\r\n", expected: nil},
+		{name: "array", input: "*3\r\n$1\r\na\r\n:1\r\n*0\r\n", expected: []interface{}{"a", int64(1), []interface{}{}}},
+		{name: "nil array", input: "*-1\r\n", expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("readReply() error = %v", err)
+			}
+			if !reflect.DeepEqual(reply, tt.expected) {
+				t.Errorf("readReply() = %#v, expected %#v", reply, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadReplyInvalid(t *testing.T) {
+	for _, input := range []string{"?\r\n", "+OK\n", "$3\r\nab", ":x\r\n"} {
+		if _, err := readReply(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("readReply(%q) expected an error", input)
+		}
+	}
+}
+
+func TestAppendCommand(t *testing.T) {
+	got := string(appendCommand(nil, []string{"SET", "key", ""}))
+	expected := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$0\r\n\r\n"
+	if got != expected {
+		t.Errorf("appendCommand() = %q, expected %q", got, expected)
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The server expects AUTH and SELECT, then answers GET with the key
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for {
+			reply, err := readReply(r)
+			if err != nil {
+				return
+			}
+			args, _ := reply.([]interface{})
+			switch args[0] {
+			case "AUTH":
+				if args[1] != "secret" {
+					c.Write([]byte("-WRONGPASS invalid password\r\n"))
+					continue
+				}
+				c.Write([]byte("+OK\r\n"))
+			case "SELECT":
+				c.Write([]byte("+OK\r\n"))
+			case "GET":
+				key := args[1].(string)
+				c.Write(appendCommand(nil, []string{key})[4:])
+			default:
+				c.Write([]byte("-ERR unknown command\r\n"))
+			}
+		}
+	}()
+
+	client := New(Options{Addr: listener.Addr().String(), Password: "secret", DB: 2})
+	defer client.Close()
+
+	value, err := String(client.Do(context.Background(), "GET", "some-key"))
+	if err != nil || value != "some-key" {
+		t.Errorf("GET = %q, %v", value, err)
+	}
+	// The connection is reused
+	_, err = client.Do(context.Background(), "FLUSHALL")
+	var replyErr Error
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(string(replyErr), "ERR") {
+		t.Errorf("FLUSHALL error = %v, expected an error reply", err)
+	}
+	if _, err := String(nil, nil); !errors.Is(err, ErrNil) {
+		t.Errorf("String(nil) error = %v, expected ErrNil", err)
+	}
+}
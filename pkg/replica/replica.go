@@ -0,0 +1,264 @@
+package replica
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of replicated state
+const (
+	// KindSerial is the SOA serial of a zone: Key is the zone
+	KindSerial = "serial"
+	// KindWrite is the last write of a throttled resource: Key is the resource
+	KindWrite = "write"
+)
+
+// Kinds of the control entries of a stream
+const (
+	// kindPing keeps an idle stream alive
+	kindPing = "ping"
+	// kindError ends a stream refused by the replica: Key is the reason
+	kindError = "error"
+)
+
+// pingInterval is the time between two pings of an idle stream; a follower
+// reconnects after three missed pings
+const pingInterval = 10 * time.Second
+
+// queueSize is the number of entries buffered for a follower. A follower
+// falling further behind is disconnected, and resumes with a snapshot.
+const queueSize = 4096
+
+// retryDelay is the time before a follower reconnects
+const retryDelay = 5 * time.Second
+
+// ErrUnauthorized is returned when a follower presents another token
+var ErrUnauthorized = errors.New("replication token refused")
+
+// Entry is an accepted piece of state of a replica
+type Entry struct {
+	Kind   string    `json:"kind"`
+	Key    string    `json:"key,omitempty"`
+	Serial uint32    `json:"serial,omitempty"`
+	Time   time.Time `json:"time,omitzero"`
+}
+
+// State is replicated state of a kind. Its methods are called without the lock
+// of the Replicator, so it may publish while holding its own.
+type State interface {
+	// Snapshot returns the current entries of the state
+	Snapshot() []Entry
+	// Apply merges an entry of another replica into the state
+	Apply(Entry)
+}
+
+// hello opens a stream, authenticating the follower
+type hello struct {
+	Token string `json:"token"`
+}
+
+// Replicator streams the state accepted by this replica to the replicas that
+// follow it, and merges the state streamed by the replicas it follows. Entries
+// applied from a stream are not streamed again: every replica follows every other.
+// A nil Replicator replicates nothing.
+type Replicator struct {
+	token string
+
+	mu        sync.Mutex
+	states    map[string]State
+	followers map[chan Entry]bool
+}
+
+// New creates a Replicator authenticating the streams with token
+func New(token string) *Replicator {
+	return &Replicator{
+		token:     token,
+		states:    make(map[string]State),
+		followers: make(map[chan Entry]bool),
+	}
+}
+
+// Register replicates a state of a kind
+func (r *Replicator) Register(kind string, state State) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[kind] = state
+}
+
+// Publish streams an entry accepted by this replica to its followers
+func (r *Replicator) Publish(entry Entry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for queue := range r.followers {
+		select {
+		case queue <- entry:
+		default:
+			// Too far behind: the follower resumes with a snapshot
+			logrus.Warnf("Replication follower lagging by %d entries, disconnecting it", len(queue))
+			delete(r.followers, queue)
+			close(queue)
+		}
+	}
+}
+
+// Serve streams the state to the followers connecting to ln, until ln is closed
+func (r *Replicator) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := r.serveConn(conn); err != nil {
+				logrus.Warnf("Replication stream to %s ended: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serveConn streams a snapshot then the published entries to a follower
+func (r *Replicator) serveConn(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(pingInterval))
+	var h hello
+	if err := json.NewDecoder(conn).Decode(&h); err != nil {
+		return fmt.Errorf("failed to read hello: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if subtle.ConstantTimeCompare([]byte(h.Token), []byte(r.token)) != 1 {
+		json.NewEncoder(conn).Encode(Entry{Kind: kindError, Key: ErrUnauthorized.Error()})
+		return ErrUnauthorized
+	}
+
+	// Subscribe before the snapshot, so that no entry is missed in between
+	queue := make(chan Entry, queueSize)
+	r.mu.Lock()
+	r.followers[queue] = true
+	states := make([]State, 0, len(r.states))
+	for _, state := range r.states {
+		states = append(states, state)
+	}
+	r.mu.Unlock()
+	defer r.unsubscribe(queue)
+	var snapshot []Entry
+	for _, state := range states {
+		snapshot = append(snapshot, state.Snapshot()...)
+	}
+	logrus.Infof("Replication follower %s connected, sending %d entries", conn.RemoteAddr(), len(snapshot))
+
+	w := bufio.NewWriter(conn)
+	encoder := json.NewEncoder(w)
+	for _, entry := range snapshot {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		select {
+		case entry, ok := <-queue:
+			if !ok {
+				return fmt.Errorf("follower lagging")
+			}
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		case <-ping.C:
+			if err := encoder.Encode(Entry{Kind: kindPing}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unsubscribe stops publishing to a follower
+func (r *Replicator) unsubscribe(queue chan Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.followers[queue] {
+		delete(r.followers, queue)
+		close(queue)
+	}
+}
+
+// Follow merges the state streamed by the replica at addr, reconnecting until ctx is done
+func (r *Replicator) Follow(ctx context.Context, addr string) {
+	for {
+		err := r.follow(ctx, addr)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Warnf("Replication stream from %s interrupted, reconnecting in %s: %v", addr, retryDelay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// follow merges the entries of a stream until it ends
+func (r *Replicator) follow(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := json.NewEncoder(conn).Encode(hello{Token: r.token}); err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+	logrus.Infof("Following the replication stream of %s", addr)
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * pingInterval))
+		var entry Entry
+		if err := decoder.Decode(&entry); err != nil {
+			return err
+		}
+		switch entry.Kind {
+		case kindPing:
+		case kindError:
+			return fmt.Errorf("refused by %s: %s", addr, entry.Key)
+		default:
+			r.apply(entry)
+		}
+	}
+}
+
+// apply merges an entry into the state of its kind
+func (r *Replicator) apply(entry Entry) {
+	r.mu.Lock()
+	state := r.states[entry.Kind]
+	r.mu.Unlock()
+	if state == nil {
+		logrus.Debugf("Ignoring replicated entry of unknown kind %q", entry.Kind)
+		return
+	}
+	state.Apply(entry)
+}
@@ -0,0 +1,141 @@
+package replica
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serials is a State keeping the highest serial of each zone
+type serials struct {
+	mu      sync.Mutex
+	serials map[string]uint32
+}
+
+func newSerials(initial map[string]uint32) *serials {
+	s := &serials{serials: make(map[string]uint32)}
+	for zone, serial := range initial {
+		s.serials[zone] = serial
+	}
+	return s
+}
+
+func (s *serials) Snapshot() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []Entry
+	for zone, serial := range s.serials {
+		entries = append(entries, Entry{Kind: KindSerial, Key: zone, Serial: serial})
+	}
+	return entries
+}
+
+func (s *serials) Apply(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.Serial > s.serials[entry.Key] {
+		s.serials[entry.Key] = entry.Serial
+	}
+}
+
+func (s *serials) get(zone string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.serials[zone]
+}
+
+// serve streams the state of a replicator from a local listener
+func serve(t *testing.T, r *Replicator) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go r.Serve(ln)
+	return ln.Addr().String()
+}
+
+// waitSerial waits for a zone to reach a serial
+func waitSerial(t *testing.T, s *serials, zone string, expected uint32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.get(zone) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected serial %d for %s, got %d", expected, zone, s.get(zone))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	active := New("secret")
+	activeSerials := newSerials(map[string]uint32{"example.com.": 100, "example.org.": 5})
+	active.Register(KindSerial, activeSerials)
+	addr := serve(t, active)
+
+	standby := New("secret")
+	standbySerials := newSerials(map[string]uint32{"example.org.": 10})
+	standby.Register(KindSerial, standbySerials)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go standby.Follow(ctx, addr)
+
+	// The snapshot is merged, the serials of the standby ahead being kept
+	waitSerial(t, standbySerials, "example.com.", 100)
+	if got := standbySerials.get("example.org."); got != 10 {
+		t.Errorf("Expected the serial of the standby to be kept, got %d", got)
+	}
+
+	// Then the published entries
+	waitFollowers(t, active, 1)
+	active.Publish(Entry{Kind: KindSerial, Key: "example.com.", Serial: 101})
+	waitSerial(t, standbySerials, "example.com.", 101)
+
+	// Entries of unknown kinds are ignored
+	active.Publish(Entry{Kind: "unknown", Key: "example.com."})
+	active.Publish(Entry{Kind: KindSerial, Key: "example.net.", Serial: 7})
+	waitSerial(t, standbySerials, "example.net.", 7)
+}
+
+func TestReplicationUnauthorized(t *testing.T) {
+	active := New("secret")
+	active.Register(KindSerial, newSerials(map[string]uint32{"example.com.": 100}))
+	addr := serve(t, active)
+
+	standby := New("other")
+	standbySerials := newSerials(nil)
+	standby.Register(KindSerial, standbySerials)
+	if err := standby.follow(context.Background(), addr); err == nil {
+		t.Fatal("Expected a follower with another token to be refused")
+	}
+	if got := standbySerials.get("example.com."); got != 0 {
+		t.Errorf("Expected no entry to be applied, got serial %d", got)
+	}
+}
+
+func TestPublishNil(t *testing.T) {
+	var r *Replicator
+	r.Register(KindSerial, newSerials(nil))
+	r.Publish(Entry{Kind: KindSerial, Key: "example.com.", Serial: 1})
+}
+
+// waitFollowers waits for a replicator to stream to a number of followers
+func waitFollowers(t *testing.T, r *Replicator, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		n := len(r.followers)
+		r.mu.Unlock()
+		if n == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d followers, got %d", expected, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
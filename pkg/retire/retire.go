@@ -0,0 +1,238 @@
+// Package retire decommissions zones: updates of a retiring zone are refused at
+// once, its records optionally exported, and its managed records deleted or
+// tombstoned once a grace period elapsed
+package retire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// States of a retirement
+const (
+	// StateRetiring is the grace period: updates are refused, records still published
+	StateRetiring = "retiring"
+	// StateRetired means the records of the zone were removed
+	StateRetired = "retired"
+	// StateFailed means the removal of the records failed; updates stay refused
+	StateFailed = "failed"
+)
+
+// removeTimeout bounds the removal of the records of a zone
+const removeTimeout = 5 * time.Minute
+
+// ErrRetiring is returned when retiring a zone already retiring or retired
+var ErrRetiring = errors.New("zone already retiring")
+
+// ErrNotRetiring is returned when cancelling the retirement of a zone not retiring
+var ErrNotRetiring = errors.New("zone not retiring")
+
+// Remover removes the managed records of a zone; *k8s.Client implements it
+type Remover interface {
+	RetireZone(ctx context.Context, zone string, tombstone, dryRun bool) ([]string, error)
+}
+
+// Exporter keeps a copy of the records of a zone and returns where; the snapshot
+// bucket implements it
+type Exporter interface {
+	Export(ctx context.Context, zone string, now time.Time) (string, error)
+}
+
+// Options configures a retirement
+type Options struct {
+	// Grace is the time the records stay published after updates are refused, the
+	// difference between Started and Removes of a Retirement
+	Grace time.Duration `json:"-"`
+	// Export keeps a copy of the records before they are removed
+	Export bool `json:"export"`
+	// Tombstone keeps the resources of the records, emptied, instead of deleting them
+	Tombstone bool `json:"tombstone"`
+}
+
+// Retirement is the state of the retirement of a zone
+type Retirement struct {
+	Zone    string  `json:"zone"`
+	Options Options `json:"options"`
+	State   string  `json:"state"`
+	// Started is when updates started to be refused, Removes when the records are removed
+	Started time.Time `json:"started"`
+	Removes time.Time `json:"removes"`
+	// Exported is the key of the export of the records
+	Exported string `json:"exported,omitempty"`
+	// Records are the resources removed, or that would be removed by a dry run
+	Records []string `json:"records"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// retirement is a retirement in progress
+type retirement struct {
+	Retirement
+	timer *time.Timer
+}
+
+// Manager runs the retirements of zones. Retirements live in the process: zones
+// must be removed from the configuration before a restart.
+type Manager struct {
+	remover  Remover
+	exporter Exporter
+
+	mu    sync.Mutex
+	zones map[string]*retirement
+}
+
+// New creates a Manager removing records with remover. exporter may be nil when no
+// export store is configured.
+func New(remover Remover, exporter Exporter) *Manager {
+	return &Manager{remover: remover, exporter: exporter, zones: make(map[string]*retirement)}
+}
+
+// normalizeZone lowercases a zone and removes its trailing dot
+func normalizeZone(zone string) string {
+	return strings.ToLower(strings.TrimSuffix(zone, "."))
+}
+
+// Retiring returns the retiring or retired zone holding a name, or an empty string
+// when updates of the name are accepted
+func (m *Manager) Retiring(name string) string {
+	if m == nil {
+		return ""
+	}
+	name = normalizeZone(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for zone := range m.zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// Plan returns the retirement of a zone without starting it, with the records it
+// would remove
+func (m *Manager) Plan(ctx context.Context, zone string, options Options) (Retirement, error) {
+	zone = normalizeZone(zone)
+	if options.Export && m.exporter == nil {
+		return Retirement{}, fmt.Errorf("export requires a snapshot bucket")
+	}
+	records, err := m.remover.RetireZone(ctx, zone, options.Tombstone, true)
+	if err != nil {
+		return Retirement{}, err
+	}
+	now := time.Now()
+	return Retirement{Zone: zone, Options: options, Started: now, Removes: now.Add(options.Grace), Records: records}, nil
+}
+
+// Retire refuses the updates of a zone from now on, exports its records when asked,
+// and removes its records once the grace period elapsed. A failed export cancels the
+// retirement; a failed retirement may be started again.
+func (m *Manager) Retire(ctx context.Context, zone string, options Options) (Retirement, error) {
+	zone = normalizeZone(zone)
+	if zone == "" {
+		return Retirement{}, fmt.Errorf("zone is required")
+	}
+	if options.Grace < 0 {
+		return Retirement{}, fmt.Errorf("grace period must not be negative")
+	}
+	if options.Export && m.exporter == nil {
+		return Retirement{}, fmt.Errorf("export requires a snapshot bucket")
+	}
+
+	now := time.Now()
+	r := &retirement{Retirement: Retirement{Zone: zone, Options: options, State: StateRetiring, Started: now, Removes: now.Add(options.Grace)}}
+	m.mu.Lock()
+	if existing, ok := m.zones[zone]; ok && existing.State != StateFailed {
+		m.mu.Unlock()
+		return Retirement{}, fmt.Errorf("%w: %s is %s", ErrRetiring, zone, existing.State)
+	}
+	m.zones[zone] = r
+	m.mu.Unlock()
+	logrus.Infof("Retiring zone %s: updates refused, records removed at %s (export: %v, tombstone: %v)",
+		zone, r.Removes.Format(time.RFC3339), options.Export, options.Tombstone)
+
+	// Updates are refused before the export, so it holds the final records
+	if options.Export {
+		key, err := m.exporter.Export(ctx, zone, now)
+		if err != nil {
+			m.mu.Lock()
+			delete(m.zones, zone)
+			m.mu.Unlock()
+			logrus.Errorf("Retirement of zone %s cancelled: %v", zone, err)
+			return Retirement{}, fmt.Errorf("failed to export zone %s: %w", zone, err)
+		}
+		m.mu.Lock()
+		r.Exported = key
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.zones[zone] != r {
+		// Cancelled during the export
+		return r.snapshot(), nil
+	}
+	r.timer = time.AfterFunc(options.Grace, func() { m.remove(r) })
+	return r.snapshot(), nil
+}
+
+// remove removes the records of a retiring zone at the end of its grace period
+func (m *Manager) remove(r *retirement) {
+	ctx, cancel := context.WithTimeout(context.Background(), removeTimeout)
+	defer cancel()
+	records, err := m.remover.RetireZone(ctx, r.Zone, r.Options.Tombstone, false)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.Records = records
+	if err != nil {
+		r.State, r.Error = StateFailed, err.Error()
+		logrus.Errorf("Failed to retire zone %s: %v", r.Zone, err)
+		return
+	}
+	r.State = StateRetired
+	logrus.Infof("Retired zone %s: %d record(s) removed", r.Zone, len(records))
+}
+
+// Cancel stops the retirement of a zone: its updates are accepted again. Records
+// already removed are not restored.
+func (m *Manager) Cancel(zone string) error {
+	zone = normalizeZone(zone)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.zones[zone]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotRetiring, zone)
+	}
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	delete(m.zones, zone)
+	logrus.Infof("Retirement of zone %s cancelled in state %s", zone, r.State)
+	return nil
+}
+
+// List returns the retirements, by zone
+func (m *Manager) List() []Retirement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Retirement, 0, len(m.zones))
+	for _, r := range m.zones {
+		list = append(list, r.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Zone < list[j].Zone })
+	return list
+}
+
+// snapshot copies the state of a retirement; the lock of its manager must be held
+func (r *retirement) snapshot() Retirement {
+	copied := r.Retirement
+	copied.Records = append([]string{}, r.Records...)
+	return copied
+}
@@ -0,0 +1,155 @@
+package retire
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRemover struct {
+	mu      sync.Mutex
+	records map[string][]string
+	removed []string
+	err     error
+}
+
+func (f *fakeRemover) RetireZone(_ context.Context, zone string, _, dryRun bool) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if !dryRun {
+		f.removed = append(f.removed, zone)
+	}
+	return f.records[zone], nil
+}
+
+type fakeExporter struct {
+	err error
+}
+
+func (f fakeExporter) Export(_ context.Context, zone string, _ time.Time) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	return "retired/" + zone + ".json", nil
+}
+
+// waitState waits for the retirement of a zone to leave the retiring state
+func waitState(t *testing.T, m *Manager, zone string) Retirement {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, r := range m.List() {
+			if r.Zone == zone && r.State != StateRetiring {
+				return r
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Retirement of %s did not complete", zone)
+	return Retirement{}
+}
+
+func TestRetire(t *testing.T) {
+	ctx := context.Background()
+	remover := &fakeRemover{records: map[string][]string{"lab.example.com": {"a", "b"}}}
+	m := New(remover, fakeExporter{})
+
+	plan, err := m.Plan(ctx, "Lab.Example.com.", Options{Grace: time.Hour})
+	if err != nil {
+		t.Fatalf("Plan() failed: %v", err)
+	}
+	if len(plan.Records) != 2 || m.Retiring("a.lab.example.com") != "" || len(remover.removed) != 0 {
+		t.Errorf("Expected a plan changing nothing, got %+v", plan)
+	}
+
+	r, err := m.Retire(ctx, "Lab.Example.com.", Options{Grace: time.Hour, Export: true})
+	if err != nil {
+		t.Fatalf("Retire() failed: %v", err)
+	}
+	if r.State != StateRetiring || r.Exported != "retired/lab.example.com.json" || r.Removes.Sub(r.Started) != time.Hour {
+		t.Errorf("Unexpected retirement %+v", r)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"lab.example.com.", "lab.example.com"},
+		{"NAS.lab.example.com", "lab.example.com"},
+		{"otherlab.example.com", ""},
+		{"example.com", ""},
+	}
+	for _, tt := range tests {
+		if got := m.Retiring(tt.name); got != tt.want {
+			t.Errorf("Retiring(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := m.Retire(ctx, "lab.example.com", Options{}); !errors.Is(err, ErrRetiring) {
+		t.Errorf("Expected ErrRetiring, got %v", err)
+	}
+	if err := m.Cancel("lab.example.com"); err != nil {
+		t.Fatalf("Cancel() failed: %v", err)
+	}
+	if m.Retiring("nas.lab.example.com") != "" || len(m.List()) != 0 {
+		t.Error("Expected updates to be accepted after the cancellation")
+	}
+	if err := m.Cancel("lab.example.com"); !errors.Is(err, ErrNotRetiring) {
+		t.Errorf("Expected ErrNotRetiring, got %v", err)
+	}
+
+	// Without grace period the records are removed right away, and updates stay refused
+	if _, err := m.Retire(ctx, "lab.example.com", Options{}); err != nil {
+		t.Fatalf("Retire() failed: %v", err)
+	}
+	r = waitState(t, m, "lab.example.com")
+	if r.State != StateRetired || len(r.Records) != 2 {
+		t.Errorf("Unexpected retirement %+v", r)
+	}
+	if m.Retiring("nas.lab.example.com") == "" {
+		t.Error("Expected updates of a retired zone to be refused")
+	}
+}
+
+func TestRetireFailures(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := New(&fakeRemover{}, nil).Retire(ctx, "example.com", Options{Export: true}); err == nil {
+		t.Error("Expected an export without exporter to fail")
+	}
+
+	m := New(&fakeRemover{}, fakeExporter{err: errors.New("bucket unreachable")})
+	if _, err := m.Retire(ctx, "example.com", Options{Export: true}); err == nil || !strings.Contains(err.Error(), "bucket unreachable") {
+		t.Errorf("Expected the export error, got %v", err)
+	}
+	if m.Retiring("example.com") != "" {
+		t.Error("Expected a failed export to cancel the retirement")
+	}
+
+	remover := &fakeRemover{err: errors.New("forbidden")}
+	m = New(remover, nil)
+	if _, err := m.Retire(ctx, "example.com", Options{}); err != nil {
+		t.Fatalf("Retire() failed: %v", err)
+	}
+	r := waitState(t, m, "example.com")
+	if r.State != StateFailed || r.Error != "forbidden" || m.Retiring("example.com") == "" {
+		t.Errorf("Unexpected retirement %+v", r)
+	}
+
+	// A failed retirement may be started again
+	remover.mu.Lock()
+	remover.err = nil
+	remover.mu.Unlock()
+	if _, err := m.Retire(ctx, "example.com", Options{}); err != nil {
+		t.Fatalf("Retire() after a failure failed: %v", err)
+	}
+	if r := waitState(t, m, "example.com"); r.State != StateRetired {
+		t.Errorf("Unexpected retirement %+v", r)
+	}
+}
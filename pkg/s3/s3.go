@@ -0,0 +1,251 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds a request when Options.Timeout is 0
+const defaultTimeout = 30 * time.Second
+
+// emptyHash is the SHA-256 digest of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Options configures a Client
+type Options struct {
+	// Endpoint is the base URL of the S3-compatible service, e.g. https://s3.eu-west-1.amazonaws.com
+	Endpoint string
+	// Region signs the requests, us-east-1 for most S3-compatible services
+	Region string
+	// Bucket holds the objects, addressed path-style under the endpoint
+	Bucket string
+	// AccessKey and SecretKey sign the requests with AWS Signature Version 4
+	AccessKey string
+	SecretKey string
+	// Timeout bounds each request, 30 seconds when 0
+	Timeout time.Duration
+}
+
+// Object is an object of a listing
+type Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+// Client stores objects in a bucket of an S3-compatible service
+type Client struct {
+	options Options
+	http    *http.Client
+	// now returns the signing time
+	now func() time.Time
+}
+
+// New creates a client for a bucket
+func New(options Options) (*Client, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", options.Endpoint)
+	}
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+	if options.Timeout == 0 {
+		options.Timeout = defaultTimeout
+	}
+	options.Endpoint = strings.TrimSuffix(options.Endpoint, "/")
+	return &Client{options: options, http: &http.Client{Timeout: options.Timeout}, now: time.Now}, nil
+}
+
+// Put stores an object
+func (c *Client) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	_, err := c.do(ctx, http.MethodPut, key, nil, header, body)
+	return err
+}
+
+// Delete removes an object; removing a missing object is not an error
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+// listResult is a page of a ListObjectsV2 response
+type listResult struct {
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// List returns the objects whose key starts with prefix, sorted by key
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("invalid S3 listing: %w", err)
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// do sends a signed request on the bucket, or one of its objects when key is set,
+// and returns the response body
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) ([]byte, error) {
+	path := "/" + c.options.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	target := c.options.Endpoint + escapePath(path)
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req, body)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("S3 %s %s failed: %s: %s", method, path, resp.Status, errorCode(data))
+	}
+	return data, nil
+}
+
+// errorCode returns the code of an S3 error document, or its raw content
+func errorCode(data []byte) string {
+	var doc struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(data, &doc); err == nil && doc.Code != "" {
+		return doc.Code + " " + doc.Message
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (c *Client) sign(req *http.Request, body []byte) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := emptyHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	scope := date + "/" + c.options.Region + "/s3/aws4_request"
+	signedHeaders, canonicalHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+c.options.SecretKey), date)
+	for _, part := range []string{c.options.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.options.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalHeaders returns the signed header names and the canonical headers of a
+// request: the host and every content or x-amz header
+func canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			values[lower] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(names, ";"), canonical.String()
+}
+
+// canonicalQuery encodes query parameters sorted by name, with spaces as %20
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// escapePath escapes each segment of an object path as required by the signature:
+// every byte but letters, digits and "-._~"
+func escapePath(path string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		b := path[i]
+		if b == '/' || b == '-' || b == '.' || b == '_' || b == '~' ||
+			('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') {
+			escaped.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&escaped, "%%%02X", b)
+	}
+	return escaped.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,111 @@
+package sig0
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// messageRetention is the time a raw message is kept for the handler to verify it
+const messageRetention = 30 * time.Second
+
+// messagePruneEvery is the number of kept messages between two prunings
+const messagePruneEvery = 256
+
+// Messages keeps the raw SIG(0) signed messages read by the servers until the
+// handler verifies them: the signature covers the message as sent, which the
+// parsed message does not preserve
+type Messages struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	raw  map[messageKey]rawMessage
+	kept int
+}
+
+// messageKey identifies a message by its source and ID
+type messageKey struct {
+	source string
+	id     uint16
+}
+
+// rawMessage is a message as read, kept until it expires
+type rawMessage struct {
+	raw     []byte
+	expires time.Time
+}
+
+// NewMessages creates an empty Messages
+func NewMessages() *Messages {
+	return &Messages{now: time.Now, raw: make(map[messageKey]rawMessage)}
+}
+
+// DecorateReader keeps the SIG(0) signed messages as they are read, to be set as
+// dns.Server DecorateReader
+func (m *Messages) DecorateReader(reader dns.Reader) dns.Reader {
+	return &messageReader{Reader: reader, messages: m}
+}
+
+// Take returns the raw message read from source that msg was parsed from
+func (m *Messages) Take(source net.Addr, msg *dns.Msg) ([]byte, bool) {
+	key := messageKey{source: source.String(), id: msg.Id}
+	m.mu.Lock()
+	kept, ok := m.raw[key]
+	delete(m.raw, key)
+	m.mu.Unlock()
+	if !ok || !m.now().Before(kept.expires) {
+		return nil, false
+	}
+
+	// Another message with the same ID may have replaced it
+	parsed := new(dns.Msg)
+	if err := parsed.Unpack(kept.raw); err != nil || parsed.String() != msg.String() {
+		return nil, false
+	}
+	return kept.raw, true
+}
+
+// keep keeps a raw message when it carries a SIG(0)
+func (m *Messages) keep(source net.Addr, raw []byte) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(raw); err != nil || Signature(msg) == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.kept++
+	if m.kept%messagePruneEvery == 0 {
+		for key, kept := range m.raw {
+			if !now.Before(kept.expires) {
+				delete(m.raw, key)
+			}
+		}
+	}
+	m.raw[messageKey{source: source.String(), id: msg.Id}] = rawMessage{raw: append([]byte(nil), raw...), expires: now.Add(messageRetention)}
+}
+
+// messageReader is a dns.Reader keeping the SIG(0) signed messages it reads
+type messageReader struct {
+	dns.Reader
+	messages *Messages
+}
+
+func (r *messageReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	m, err := r.Reader.ReadTCP(conn, timeout)
+	if err == nil {
+		r.messages.keep(conn.RemoteAddr(), m)
+	}
+	return m, err
+}
+
+func (r *messageReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	m, session, err := r.Reader.ReadUDP(conn, timeout)
+	if err == nil {
+		r.messages.keep(session.RemoteAddr(), m)
+	}
+	return m, session, err
+}
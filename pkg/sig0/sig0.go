@@ -0,0 +1,141 @@
+// Package sig0 authenticates updates signed with SIG(0) (RFC 2931) by the public
+// keys configured for their zones
+package sig0
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+)
+
+// ErrNoKey is returned for a signature made with a key not configured for the zone
+var ErrNoKey = errors.New("no SIG(0) key")
+
+// Key is the public KEY record of a SIG(0) signer, read from the file of
+// dnssec-keygen, and the zones it may update
+type Key struct {
+	Name  string
+	Tag   uint16
+	File  string
+	Zones []string
+	key   *dns.KEY
+}
+
+// Keys are the SIG(0) keys of the zones, empty when none is configured
+type Keys []Key
+
+// Parse reads the public key files configured for each zone. A key listed for
+// several zones may update all of them.
+func Parse(zones map[string][]string) (Keys, error) {
+	names := make([]string, 0, len(zones))
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+
+	byFile := make(map[string]int)
+	keys := make(Keys, 0, len(zones))
+	for _, zone := range names {
+		if len(zones[zone]) == 0 {
+			return nil, fmt.Errorf("no key file listed for zone %s", zone)
+		}
+		for _, file := range zones[zone] {
+			if i, ok := byFile[file]; ok {
+				keys[i].Zones = append(keys[i].Zones, zone)
+				continue
+			}
+			key, err := readKey(file)
+			if err != nil {
+				return nil, fmt.Errorf("key of zone %s: %w", zone, err)
+			}
+			byFile[file] = len(keys)
+			keys = append(keys, Key{Name: key.Hdr.Name, Tag: key.KeyTag(), File: file, Zones: []string{zone}, key: key})
+		}
+	}
+	return keys, nil
+}
+
+// readKey reads the KEY record of a public key file, skipping blank lines and
+// ; comments
+func readKey(path string) (*dns.KEY, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		key, ok := rr.(*dns.KEY)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a KEY record, got %s", path, dns.TypeToString[rr.Header().Rrtype])
+		}
+		return key, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s: no KEY record", path)
+}
+
+// Signature returns the SIG(0) record of a message, the last of its additional
+// section, or nil
+func Signature(m *dns.Msg) *dns.SIG {
+	if len(m.Extra) == 0 {
+		return nil
+	}
+	sig, ok := m.Extra[len(m.Extra)-1].(*dns.SIG)
+	if !ok || sig.TypeCovered != 0 {
+		return nil
+	}
+	return sig
+}
+
+// Verify checks the SIG(0) of a raw message against the key of its signer, and
+// returns the name of the key
+func (k Keys) Verify(raw []byte, sig *dns.SIG) (string, error) {
+	for _, key := range k {
+		if !strings.EqualFold(key.Name, sig.SignerName) || key.Tag != sig.KeyTag || key.key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key.key, raw); err != nil {
+			return "", fmt.Errorf("signature of key %s: %w", key.Name, err)
+		}
+		return key.Name, nil
+	}
+	return "", fmt.Errorf("%w %s (tag %d)", ErrNoKey, sig.SignerName, sig.KeyTag)
+}
+
+// Knows checks if a name is the name of a SIG(0) key
+func (k Keys) Knows(name string) bool {
+	return k.zones().Knows(name)
+}
+
+// AllowsName checks if the SIG(0) key of a name may update a name in its zones
+func (k Keys) AllowsName(key, name string) bool {
+	return k.zones().Allows(key, name)
+}
+
+// zones returns the zones of the keys, by key name
+func (k Keys) zones() acl.ACL {
+	zones := make(map[string][]string, len(k))
+	for _, key := range k {
+		zones[key.Name] = append(zones[key.Name], key.Zones...)
+	}
+	return acl.New(zones)
+}
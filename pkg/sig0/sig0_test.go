@@ -0,0 +1,160 @@
+package sig0
+
+import (
+	"crypto"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newKey generates a SIG(0) key of name and writes its public key file
+func newKey(t *testing.T, name string) (string, *dns.KEY, crypto.Signer) {
+	t.Helper()
+	key := &dns.KEY{DNSKEY: dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     512,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}}
+	private, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "K"+name+"key")
+	if err := os.WriteFile(path, []byte("; Generated by dnssec-keygen\n"+key.String()+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	return path, key, private.(crypto.Signer)
+}
+
+// signed returns an update of zone signed with key
+func signed(t *testing.T, zone string, key *dns.KEY, signer crypto.Signer) []byte {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	rr, _ := dns.NewRR("host." + zone + " 300 IN A 192.0.2.10")
+	m.Insert([]dns.RR{rr})
+	now := uint32(time.Now().Unix())
+	sig := &dns.SIG{RRSIG: dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeSIG, Class: dns.ClassANY},
+		Algorithm:  key.Algorithm,
+		Expiration: now + 300,
+		Inception:  now - 300,
+		KeyTag:     key.KeyTag(),
+		SignerName: key.Hdr.Name,
+	}}
+	raw, err := sig.Sign(signer, m)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	return raw
+}
+
+func TestVerify(t *testing.T) {
+	path, key, signer := newKey(t, "host.example.com.")
+	otherPath, _, _ := newKey(t, "other.example.com.")
+	_, stranger, strangerSigner := newKey(t, "host.example.com.")
+	keys, err := Parse(map[string][]string{"example.com": {path, otherPath}, "example.org": {path}})
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	tampered := signed(t, "example.com.", key, signer)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		raw     []byte
+		wantErr error
+	}{
+		{"valid", signed(t, "example.com.", key, signer), nil},
+		{"tampered", tampered, dns.ErrSig},
+		{"unknown key", signed(t, "example.com.", stranger, strangerSigner), ErrNoKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			if err := m.Unpack(tt.raw); err != nil {
+				t.Fatalf("Unpack() failed: %v", err)
+			}
+			sig := Signature(m)
+			if sig == nil {
+				t.Fatal("Expected a SIG(0)")
+			}
+			signerName, err := keys.Verify(tt.raw, sig)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Verify() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || signerName != "host.example.com." {
+				t.Errorf("Verify() = %q, %v", signerName, err)
+			}
+		})
+	}
+
+	if !keys.AllowsName("host.example.com.", "host.example.org.") || keys.AllowsName("other.example.com.", "host.example.org.") {
+		t.Error("Expected each key to update the names of its own zones only")
+	}
+}
+
+func TestParse(t *testing.T) {
+	path, _, _ := newKey(t, "host.example.com.")
+	notKey := filepath.Join(t.TempDir(), "a")
+	if err := os.WriteFile(notKey, []byte("host.example.com. 300 IN A 192.0.2.10\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		zones     map[string][]string
+		shouldErr bool
+	}{
+		{"key file", map[string][]string{"example.com": {path}}, false},
+		{"missing file", map[string][]string{"example.com": {filepath.Join(t.TempDir(), "missing")}}, true},
+		{"not a KEY", map[string][]string{"example.com": {notKey}}, true},
+		{"no file", map[string][]string{"example.com": {}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.zones)
+			if (err != nil) != tt.shouldErr {
+				t.Errorf("Parse() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+		})
+	}
+}
+
+func TestMessagesTake(t *testing.T) {
+	_, key, signer := newKey(t, "host.example.com.")
+	raw := signed(t, "example.com.", key, signer)
+	source := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5353}
+	m := new(dns.Msg)
+	if err := m.Unpack(raw); err != nil {
+		t.Fatalf("Unpack() failed: %v", err)
+	}
+
+	messages := NewMessages()
+	messages.keep(source, raw)
+	replaced := m.Copy()
+	replaced.Ns = nil
+	if _, ok := messages.Take(source, replaced); ok {
+		t.Error("Expected a different message with the same ID not to match")
+	}
+
+	messages.keep(source, raw)
+	if got, ok := messages.Take(source, m); !ok || string(got) != string(raw) {
+		t.Errorf("Take() = %v, expected the raw message", ok)
+	}
+	if _, ok := messages.Take(source, m); ok {
+		t.Error("Expected the message to be taken once")
+	}
+}
@@ -0,0 +1,173 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/s3"
+)
+
+// timeLayout names the snapshots, sorting them by time
+const timeLayout = "20060102T150405Z"
+
+// Exporter lists the managed records
+type Exporter interface {
+	ExportRecords(ctx context.Context) ([]k8s.Record, error)
+}
+
+// Store keeps the snapshots
+type Store interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	List(ctx context.Context, prefix string) ([]s3.Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Document is the content of a snapshot, in the format of the records admin endpoints
+// so that a snapshot can be restored with POST /records. The zone and time of a
+// snapshot are in its key.
+type Document struct {
+	Records []k8s.Record `json:"records"`
+}
+
+// Snapshotter exports each managed zone to a store, as <prefix><zone>/<time>.json,
+// keeping the last snapshots of each zone
+type Snapshotter struct {
+	exporter  Exporter
+	store     Store
+	zones     []string
+	prefix    string
+	retention int
+	// zoneOf returns the longest zone of a name, or an empty string
+	zoneOf func(name string) string
+}
+
+// New creates a Snapshotter of the zones, keeping retention snapshots per zone.
+// zoneOf returns the zone of a name among zones.
+func New(exporter Exporter, store Store, zones []string, zoneOf func(string) string, prefix string, retention int) *Snapshotter {
+	normalized := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		normalized = append(normalized, normalizeZone(zone))
+	}
+	return &Snapshotter{exporter: exporter, store: store, zones: normalized, zoneOf: zoneOf, prefix: prefix, retention: retention}
+}
+
+// Snapshot writes a snapshot of every zone taken at now, then removes the snapshots
+// beyond the retention. It returns the keys of the written snapshots. Zones without
+// records get an empty snapshot, so a restore point exists for every zone.
+func (s *Snapshotter) Snapshot(ctx context.Context, now time.Time) ([]string, error) {
+	records, err := s.exporter.ExportRecords(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export records: %w", err)
+	}
+
+	byZone := make(map[string][]k8s.Record, len(s.zones))
+	for _, record := range records {
+		zone := normalizeZone(s.zoneOf(record.Name))
+		byZone[zone] = append(byZone[zone], record)
+	}
+
+	now = now.UTC().Truncate(time.Second)
+	written := make([]string, 0, len(s.zones))
+	for _, zone := range s.zones {
+		zoneRecords := byZone[zone]
+		if zoneRecords == nil {
+			zoneRecords = []k8s.Record{}
+		}
+		body, err := json.MarshalIndent(Document{Records: zoneRecords}, "", "  ")
+		if err != nil {
+			return written, fmt.Errorf("failed to encode snapshot of %s: %w", zone, err)
+		}
+		key := s.zonePrefix(zone) + now.Format(timeLayout) + ".json"
+		if err := s.store.Put(ctx, key, body, "application/json"); err != nil {
+			return written, fmt.Errorf("failed to store snapshot of %s: %w", zone, err)
+		}
+		logrus.Infof("Stored snapshot %s with %d record(s)", key, len(zoneRecords))
+		written = append(written, key)
+
+		if err := s.prune(ctx, zone); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Export writes the records of a single zone taken at now to
+// <prefix>retired/<zone>/<time>.json, outside of the retention of the snapshots, and
+// returns its key. It keeps a restore point of a zone being retired.
+func (s *Snapshotter) Export(ctx context.Context, zone string, now time.Time) (string, error) {
+	zone = normalizeZone(zone)
+	records, err := s.exporter.ExportRecords(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to export records: %w", err)
+	}
+	zoneRecords := []k8s.Record{}
+	for _, record := range records {
+		name := normalizeZone(record.Name)
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			zoneRecords = append(zoneRecords, record)
+		}
+	}
+
+	body, err := json.MarshalIndent(Document{Records: zoneRecords}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode export of %s: %w", zone, err)
+	}
+	key := s.prefix + "retired/" + strings.TrimSuffix(zone, ".") + "/" + now.UTC().Format(timeLayout) + ".json"
+	if err := s.store.Put(ctx, key, body, "application/json"); err != nil {
+		return "", fmt.Errorf("failed to store export of %s: %w", zone, err)
+	}
+	logrus.Infof("Stored export %s with %d record(s)", key, len(zoneRecords))
+	return key, nil
+}
+
+// Run takes a snapshot every interval until ctx is done. Failed snapshots are logged
+// and retried at the next interval.
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Snapshot(ctx, time.Now()); err != nil {
+			logrus.Errorf("Snapshot failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune removes the oldest snapshots of a zone beyond the retention
+func (s *Snapshotter) prune(ctx context.Context, zone string) error {
+	objects, err := s.store.List(ctx, s.zonePrefix(zone))
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots of %s: %w", zone, err)
+	}
+	// Keys sort by time, oldest first
+	for i := 0; i < len(objects)-s.retention; i++ {
+		if err := s.store.Delete(ctx, objects[i].Key); err != nil {
+			return fmt.Errorf("failed to remove snapshot %s: %w", objects[i].Key, err)
+		}
+		logrus.Infof("Removed snapshot %s beyond the retention of %d", objects[i].Key, s.retention)
+	}
+	return nil
+}
+
+// zonePrefix returns the key prefix of the snapshots of a zone
+func (s *Snapshotter) zonePrefix(zone string) string {
+	return s.prefix + strings.TrimSuffix(zone, ".") + "/"
+}
+
+// normalizeZone lowercases a zone and ensures it ends with a dot
+func normalizeZone(zone string) string {
+	zone = strings.ToLower(zone)
+	if zone != "" && !strings.HasSuffix(zone, ".") {
+		zone = zone + "."
+	}
+	return zone
+}
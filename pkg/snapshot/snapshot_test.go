@@ -0,0 +1,137 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/k8s"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/s3"
+)
+
+type fakeExporter []k8s.Record
+
+func (f fakeExporter) ExportRecords(context.Context) ([]k8s.Record, error) {
+	return f, nil
+}
+
+type fakeStore map[string][]byte
+
+func (f fakeStore) Put(_ context.Context, key string, body []byte, _ string) error {
+	f[key] = body
+	return nil
+}
+
+func (f fakeStore) List(_ context.Context, prefix string) ([]s3.Object, error) {
+	var objects []s3.Object
+	for key := range f {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, s3.Object{Key: key})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (f fakeStore) Delete(_ context.Context, key string) error {
+	delete(f, key)
+	return nil
+}
+
+func TestSnapshot(t *testing.T) {
+	exporter := fakeExporter{
+		{Name: "router.example.com.", Type: "A", TTL: 300, Targets: []string{"192.0.2.1"}},
+		{Name: "nas.lab.example.com.", Type: "A", TTL: 300, Targets: []string{"192.0.2.2"}},
+		{Name: "stray.example.org.", Type: "A", TTL: 300, Targets: []string{"192.0.2.3"}},
+	}
+	zoneOf := func(name string) string {
+		for _, zone := range []string{"lab.example.com.", "example.com.", "empty.example.net."} {
+			if strings.HasSuffix(name, "."+zone) {
+				return zone
+			}
+		}
+		return ""
+	}
+	store := fakeStore{}
+	s := New(exporter, store, []string{"example.com", "Lab.Example.com.", "empty.example.net"}, zoneOf, "snapshots/", 2)
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		keys, err := s.Snapshot(context.Background(), start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("Snapshot() failed: %v", err)
+		}
+		if len(keys) != 3 {
+			t.Fatalf("Expected a snapshot per zone, got %v", keys)
+		}
+	}
+
+	if len(store) != 6 {
+		t.Errorf("Expected 2 snapshots per zone after pruning, got %d", len(store))
+	}
+	if _, ok := store["snapshots/example.com/20261016T120000Z.json"]; ok {
+		t.Error("Expected the oldest snapshot to be removed")
+	}
+
+	tests := []struct {
+		key   string
+		names []string
+	}{
+		{"snapshots/example.com/20261016T140000Z.json", []string{"router.example.com."}},
+		{"snapshots/lab.example.com/20261016T140000Z.json", []string{"nas.lab.example.com."}},
+		{"snapshots/empty.example.net/20261016T140000Z.json", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			var doc Document
+			if err := json.Unmarshal(store[tt.key], &doc); err != nil {
+				t.Fatalf("Invalid snapshot: %v", err)
+			}
+			if doc.Records == nil || len(doc.Records) != len(tt.names) {
+				t.Fatalf("Unexpected records: %v", doc.Records)
+			}
+			for i, record := range doc.Records {
+				if record.Name != tt.names[i] {
+					t.Errorf("Unexpected record %s, want %s", record.Name, tt.names[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	exporter := fakeExporter{
+		{Name: "router.example.com.", Type: "A", TTL: 300, Targets: []string{"192.0.2.1"}},
+		{Name: "nas.lab.example.com.", Type: "A", TTL: 300, Targets: []string{"192.0.2.2"}},
+		{Name: "lab.example.com.", Type: "A", TTL: 300, Targets: []string{"192.0.2.3"}},
+		{Name: "otherlab.example.com.", Type: "A", TTL: 300, Targets: []string{"192.0.2.4"}},
+	}
+	store := fakeStore{}
+	s := New(exporter, store, []string{"example.com"}, func(string) string { return "example.com." }, "snapshots/", 1)
+
+	key, err := s.Export(context.Background(), "Lab.Example.com", time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if key != "snapshots/retired/lab.example.com/20261016T120000Z.json" {
+		t.Errorf("Unexpected key %s", key)
+	}
+	var doc Document
+	if err := json.Unmarshal(store[key], &doc); err != nil {
+		t.Fatalf("Invalid export: %v", err)
+	}
+	if len(doc.Records) != 2 || doc.Records[0].Name != "nas.lab.example.com." || doc.Records[1].Name != "lab.example.com." {
+		t.Errorf("Unexpected records: %v", doc.Records)
+	}
+
+	// Exports are outside of the retention of the snapshots
+	if _, err := s.Snapshot(context.Background(), time.Date(2026, 10, 16, 13, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("Snapshot() failed: %v", err)
+	}
+	if _, ok := store[key]; !ok {
+		t.Error("Expected the export to be kept")
+	}
+}
@@ -0,0 +1,203 @@
+// Package tcplimit limits the TCP sessions of each client: the connections of a
+// source address, the connections signed with a TSIG key, and the transactions a
+// key has in flight over TCP
+package tcplimit
+
+import (
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// Limits configures a Limiter; a limit of 0 disables it
+type Limits struct {
+	// ConnsPerSource bounds the open connections of a source IP address
+	ConnsPerSource int
+	// ConnsPerKey bounds the open connections whose messages are signed with a key
+	ConnsPerKey int
+	// InflightPerKey bounds the transactions of a key processed at once over TCP
+	InflightPerKey int
+}
+
+// Limiter enforces Limits on the connections of its listeners
+type Limiter struct {
+	limits Limits
+
+	mu       sync.Mutex
+	sources  map[string]int
+	keys     map[string]int
+	inflight map[string]int
+	// conns holds the open connections by local and remote address
+	conns map[string]*conn
+}
+
+// New creates a Limiter, or returns nil when every limit is disabled
+func New(limits Limits) *Limiter {
+	if limits.ConnsPerSource <= 0 && limits.ConnsPerKey <= 0 && limits.InflightPerKey <= 0 {
+		return nil
+	}
+	return &Limiter{
+		limits:   limits,
+		sources:  make(map[string]int),
+		keys:     make(map[string]int),
+		inflight: make(map[string]int),
+		conns:    make(map[string]*conn),
+	}
+}
+
+// connKey identifies a connection by its addresses
+func connKey(local, remote net.Addr) string {
+	return local.String() + "|" + remote.String()
+}
+
+// sourceOf returns the IP address of a remote address
+func sourceOf(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Listener returns a listener tracking the connections of inner, closing those
+// accepted beyond the limit of their source
+func (l *Limiter) Listener(inner net.Listener) net.Listener {
+	return &listener{Listener: inner, limiter: l}
+}
+
+// BindKey binds the connection of a message to the TSIG key it is signed with, the
+// first time a signed message is received on it. It returns false when the key
+// already holds as many connections as allowed; the connection should be closed.
+func (l *Limiter) BindKey(local, remote net.Addr, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.conns[connKey(local, remote)]
+	if !ok || c.key != "" {
+		// Not accepted by a listener of the limiter, or already bound
+		return true
+	}
+	if l.limits.ConnsPerKey > 0 && l.keys[key] >= l.limits.ConnsPerKey {
+		metrics.TCPSessionsRefused.WithLabelValues("key").Inc()
+		logrus.Warnf("Refused TCP connection from %s: key %s already holds %d connection(s)", remote, key, l.keys[key])
+		return false
+	}
+	c.key = key
+	l.keys[key]++
+	return true
+}
+
+// Begin counts a transaction of a key in flight. It returns false when the key has
+// as many transactions in flight as allowed; End must only be called after true.
+func (l *Limiter) Begin(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.InflightPerKey > 0 && l.inflight[key] >= l.limits.InflightPerKey {
+		metrics.TCPSessionsRefused.WithLabelValues("inflight").Inc()
+		return false
+	}
+	l.inflight[key]++
+	return true
+}
+
+// End counts a transaction of a key answered
+func (l *Limiter) End(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight[key]--
+	if l.inflight[key] <= 0 {
+		delete(l.inflight, key)
+	}
+}
+
+// Stats returns the open connections by source and by key, and the transactions in
+// flight by key
+func (l *Limiter) Stats() map[string]map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return map[string]map[string]int{
+		"sources":  copyCounts(l.sources),
+		"keys":     copyCounts(l.keys),
+		"inflight": copyCounts(l.inflight),
+	}
+}
+
+// accept counts a new connection, returning false when its source is at its limit
+func (l *Limiter) accept(c *conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.ConnsPerSource > 0 && l.sources[c.source] >= l.limits.ConnsPerSource {
+		return false
+	}
+	l.sources[c.source]++
+	l.conns[connKey(c.LocalAddr(), c.RemoteAddr())] = c
+	return true
+}
+
+// release forgets a closed connection
+func (l *Limiter) release(c *conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, connKey(c.LocalAddr(), c.RemoteAddr()))
+	if l.sources[c.source]--; l.sources[c.source] <= 0 {
+		delete(l.sources, c.source)
+	}
+	if c.key != "" {
+		if l.keys[c.key]--; l.keys[c.key] <= 0 {
+			delete(l.keys, c.key)
+		}
+	}
+}
+
+// listener tracks the connections it accepts
+type listener struct {
+	net.Listener
+	limiter *Limiter
+}
+
+// Accept returns the next connection within the limit of its source; connections
+// beyond it are closed without being read
+func (ln *listener) Accept() (net.Conn, error) {
+	for {
+		inner, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		c := &conn{Conn: inner, limiter: ln.limiter, source: sourceOf(inner.RemoteAddr())}
+		if ln.limiter.accept(c) {
+			return c, nil
+		}
+		metrics.TCPSessionsRefused.WithLabelValues("source").Inc()
+		logrus.Warnf("Refused TCP connection from %s: source already holds %d connection(s)", inner.RemoteAddr(), ln.limiter.limits.ConnsPerSource)
+		inner.Close()
+	}
+}
+
+// conn is a tracked connection
+type conn struct {
+	net.Conn
+	limiter *Limiter
+	source  string
+	// key is the TSIG key the connection is bound to, guarded by limiter.mu
+	key  string
+	once sync.Once
+}
+
+// Close closes the connection and releases its sessions
+func (c *conn) Close() error {
+	c.once.Do(func() { c.limiter.release(c) })
+	return c.Conn.Close()
+}
+
+// copyCounts copies a map of counters
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,134 @@
+package tcplimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// dialAccepted dials a listener and returns the client side and the accepted server
+// side of the connection, or a nil server side when the listener closed it
+func dialAccepted(t *testing.T, ln net.Listener, accepted chan net.Conn) (net.Conn, net.Conn) {
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	select {
+	case server := <-accepted:
+		return client, server
+	case <-time.After(200 * time.Millisecond):
+		return client, nil
+	}
+}
+
+func TestListenerLimitsSources(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	limiter := New(Limits{ConnsPerSource: 2})
+	ln := limiter.Listener(inner)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	_, first := dialAccepted(t, ln, accepted)
+	_, second := dialAccepted(t, ln, accepted)
+	if first == nil || second == nil {
+		t.Fatal("Expected the connections within the limit to be accepted")
+	}
+
+	refused, server := dialAccepted(t, ln, accepted)
+	if server != nil {
+		t.Fatal("Expected the connection beyond the limit to be refused")
+	}
+	refused.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := refused.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the refused connection to be closed")
+	}
+
+	// Closing a connection frees its slot
+	first.Close()
+	if _, server := dialAccepted(t, ln, accepted); server == nil {
+		t.Error("Expected a connection to be accepted after another one closed")
+	}
+	if got := limiter.Stats()["sources"]["127.0.0.1"]; got != 2 {
+		t.Errorf("connections of 127.0.0.1 = %d, want 2", got)
+	}
+}
+
+func TestBindKey(t *testing.T) {
+	limiter := New(Limits{ConnsPerKey: 1})
+	local := &net.TCPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+	conns := make([]*conn, 3)
+	for i := range conns {
+		remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000 + i}
+		conns[i] = &conn{Conn: fakeConn{local: local, remote: remote}, limiter: limiter, source: "192.0.2.1"}
+		limiter.accept(conns[i])
+	}
+
+	if !limiter.BindKey(local, conns[0].RemoteAddr(), "dhcp") {
+		t.Fatal("Expected the first connection of the key to be bound")
+	}
+	if !limiter.BindKey(local, conns[0].RemoteAddr(), "dhcp") {
+		t.Error("Expected further messages of a bound connection to be accepted")
+	}
+	if limiter.BindKey(local, conns[1].RemoteAddr(), "dhcp") {
+		t.Error("Expected a second connection of the key to be refused")
+	}
+	if !limiter.BindKey(local, conns[2].RemoteAddr(), "router") {
+		t.Error("Expected a connection of another key to be bound")
+	}
+	// Connections not accepted by a listener of the limiter are not bound
+	if !limiter.BindKey(local, &net.TCPAddr{IP: net.ParseIP("192.0.2.9"), Port: 1}, "dhcp") {
+		t.Error("Expected an untracked connection to be accepted")
+	}
+
+	conns[0].Close()
+	if !limiter.BindKey(local, conns[1].RemoteAddr(), "dhcp") {
+		t.Error("Expected the key to be bound again once its connection closed")
+	}
+}
+
+func TestBegin(t *testing.T) {
+	limiter := New(Limits{InflightPerKey: 2})
+	if !limiter.Begin("dhcp") || !limiter.Begin("dhcp") {
+		t.Fatal("Expected the transactions within the limit to begin")
+	}
+	if limiter.Begin("dhcp") {
+		t.Error("Expected a transaction beyond the limit to be refused")
+	}
+	if !limiter.Begin("router") {
+		t.Error("Expected a transaction of another key to begin")
+	}
+	limiter.End("dhcp")
+	if !limiter.Begin("dhcp") {
+		t.Error("Expected a transaction to begin once another one ended")
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	if New(Limits{}) != nil {
+		t.Error("Expected no limiter without limits")
+	}
+}
+
+// fakeConn is a connection with fixed addresses
+type fakeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c fakeConn) LocalAddr() net.Addr  { return c.local }
+func (c fakeConn) RemoteAddr() net.Addr { return c.remote }
+func (c fakeConn) Close() error         { return nil }
@@ -0,0 +1,57 @@
+package tsig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const benchmarkSecret = "dGVzdC1zZWNyZXQtZm9yLWJlbmNobWFya3M="
+
+// signedUpdate returns a packed UPDATE signed with benchmarkSecret
+func signedUpdate(b *testing.B) []byte {
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	rr, _ := dns.NewRR("host.example.com. 300 IN A 192.0.2.1")
+	msg.Insert([]dns.RR{rr})
+	msg.SetTsig("test-key.", dns.HmacSHA256, 300, time.Now().Unix())
+	buf, _, err := dns.TsigGenerate(msg, benchmarkSecret, "", false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return buf
+}
+
+func BenchmarkSign(b *testing.B) {
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Rcode = dns.RcodeSuccess
+	b.ReportAllocs()
+	for b.Loop() {
+		msg.Extra = nil
+		msg.SetTsig("test-key.", dns.HmacSHA256, 300, time.Now().Unix())
+		if _, _, err := dns.TsigGenerate(msg, benchmarkSecret, "", false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	buf := signedUpdate(b)
+	msg := new(dns.Msg)
+	if err := msg.Unpack(buf); err != nil {
+		b.Fatal(err)
+	}
+	record := msg.IsTsig()
+	// TsigVerify rewrites the additional count of the message it strips
+	wire := make([]byte, len(buf))
+	b.ReportAllocs()
+	for b.Loop() {
+		copy(wire, buf)
+		status := dns.TsigVerify(wire, benchmarkSecret, "", false)
+		if _, err := Verify(status, record, time.Now(), 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
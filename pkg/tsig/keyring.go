@@ -0,0 +1,209 @@
+package tsig
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/metrics"
+)
+
+// algorithms are the HMAC algorithms, from the weakest
+var algorithms = []string{dns.HmacMD5, dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512}
+
+// Weaker checks if an algorithm is weaker than min, an unknown algorithm being
+// weaker than any other
+func Weaker(algorithm, min string) bool {
+	return strength(algorithm) < strength(min)
+}
+
+// strength returns the rank of an algorithm among algorithms, -1 when unknown
+func strength(algorithm string) int {
+	algorithm = dns.CanonicalName(algorithm)
+	for i, known := range algorithms {
+		if known == algorithm {
+			return i
+		}
+	}
+	return -1
+}
+
+// verifiedRetention is the time the previous secret that verified a request is
+// kept to sign its response
+const verifiedRetention = time.Minute
+
+// Keyring is a dns.TsigProvider whose secrets can be replaced while the servers
+// using it run. A replaced secret keeps verifying requests during the rotation
+// overlap, so that clients can switch to the new secret one after the other.
+type Keyring struct {
+	now func() time.Time
+
+	mu       sync.RWMutex
+	secrets  map[string]string
+	previous map[string][]previousSecret
+	overlap  time.Duration
+	// weakest algorithm accepted, any when empty
+	minAlgorithm string
+	// previous secrets that verified requests, by request MAC
+	verified map[string]previousSecret
+}
+
+// previousSecret is a replaced secret, accepted until it expires
+type previousSecret struct {
+	secret  string
+	expires time.Time
+}
+
+// NewKeyring creates a Keyring of base64 secrets by key name
+func NewKeyring(secrets map[string]string) *Keyring {
+	return &Keyring{
+		now:      time.Now,
+		secrets:  secrets,
+		previous: make(map[string][]previousSecret),
+		verified: make(map[string]previousSecret),
+	}
+}
+
+// SetOverlap sets the time a replaced secret keeps verifying requests, none when zero
+func (k *Keyring) SetOverlap(overlap time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.overlap = overlap
+}
+
+// SetMinAlgorithm refuses the signatures of an algorithm weaker than min as if
+// their key was unknown, none when empty
+func (k *Keyring) SetMinAlgorithm(min string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.minAlgorithm = min
+}
+
+// SetSecrets replaces the secrets; messages signed with a removed key no longer
+// verify, and those signed with a replaced secret verify during the overlap
+func (k *Keyring) SetSecrets(secrets map[string]string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := k.now()
+	previous := make(map[string][]previousSecret)
+	for name, secret := range secrets {
+		var kept []previousSecret
+		if old, ok := k.secrets[name]; ok && old != secret && k.overlap > 0 {
+			kept = append(kept, previousSecret{secret: old, expires: now.Add(k.overlap)})
+		}
+		for _, p := range k.previous[name] {
+			if p.secret != secret && now.Before(p.expires) {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) > 0 {
+			previous[name] = kept
+		}
+	}
+	k.secrets = secrets
+	k.previous = previous
+}
+
+// VerifiedSecret returns the previous secret that verified a request, by its MAC,
+// to sign the response with it; false when the current secret verified it
+func (k *Keyring) VerifiedSecret(mac string) (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	p, ok := k.verified[mac]
+	return p.secret, ok
+}
+
+// Generate implements dns.TsigProvider
+func (k *Keyring) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	k.mu.RLock()
+	secret, ok := k.secrets[t.Hdr.Name]
+	min := k.minAlgorithm
+	k.mu.RUnlock()
+	if !ok {
+		return nil, dns.ErrSecret
+	}
+	if min != "" && Weaker(t.Algorithm, min) {
+		return nil, dns.ErrKeyAlg
+	}
+	return sign(secret, msg, t)
+}
+
+// sign computes the MAC of msg with a base64 secret
+func sign(secret string, msg []byte, t *dns.TSIG) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	switch dns.CanonicalName(t.Algorithm) {
+	case dns.HmacSHA1:
+		h = hmac.New(sha1.New, raw)
+	case dns.HmacSHA224:
+		h = hmac.New(sha256.New224, raw)
+	case dns.HmacSHA256:
+		h = hmac.New(sha256.New, raw)
+	case dns.HmacSHA384:
+		h = hmac.New(sha512.New384, raw)
+	case dns.HmacSHA512:
+		h = hmac.New(sha512.New, raw)
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+// Verify implements dns.TsigProvider, trying the previous secrets of the key when
+// the current one does not match
+func (k *Keyring) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := k.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if hmac.Equal(expected, mac) {
+		metrics.TSIGSecretMatches.WithLabelValues("current").Inc()
+		return nil
+	}
+
+	now := k.now()
+	k.mu.RLock()
+	previous := k.previous[t.Hdr.Name]
+	k.mu.RUnlock()
+	for _, p := range previous {
+		if !now.Before(p.expires) {
+			continue
+		}
+		if expected, err := sign(p.secret, msg, t); err == nil && hmac.Equal(expected, mac) {
+			k.remember(t.MAC, p, now)
+			metrics.TSIGSecretMatches.WithLabelValues("previous").Inc()
+			return nil
+		}
+	}
+	return dns.ErrSig
+}
+
+// remember keeps the previous secret that verified a request to sign its response,
+// dropping the secrets of requests never answered
+func (k *Keyring) remember(mac string, p previousSecret, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for key, v := range k.verified {
+		if !now.Before(v.expires) {
+			delete(k.verified, key)
+		}
+	}
+	k.verified[mac] = previousSecret{secret: p.secret, expires: now.Add(verifiedRetention)}
+}
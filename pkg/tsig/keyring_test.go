@@ -0,0 +1,125 @@
+package tsig
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestKeyring(t *testing.T) {
+	const secret = "dGVzdC1zZWNyZXQ="
+
+	tests := []struct {
+		name      string
+		algorithm string
+		secrets   map[string]string
+		wantErr   error
+	}{
+		{"valid", dns.HmacSHA256, map[string]string{"test-key.": secret}, nil},
+		{"sha512", dns.HmacSHA512, map[string]string{"test-key.": secret}, nil},
+		{"replaced secret", dns.HmacSHA256, map[string]string{"test-key.": "b3RoZXItc2VjcmV0"}, dns.ErrSig},
+		{"removed key", dns.HmacSHA256, map[string]string{"other-key.": secret}, dns.ErrSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.SetUpdate("example.com.")
+			msg.SetTsig("test-key.", tt.algorithm, 300, time.Now().Unix())
+			buf, _, err := dns.TsigGenerate(msg, secret, "", false)
+			if err != nil {
+				t.Fatalf("TsigGenerate() failed: %v", err)
+			}
+
+			keyring := NewKeyring(map[string]string{"test-key.": secret})
+			keyring.SetSecrets(tt.secrets)
+			err = dns.TsigVerifyWithProvider(buf, keyring, "", false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("TsigVerifyWithProvider() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyringRotationOverlap(t *testing.T) {
+	const oldSecret, newSecret = "dGVzdC1zZWNyZXQ=", "b3RoZXItc2VjcmV0"
+
+	tests := []struct {
+		name    string
+		overlap time.Duration
+		elapsed time.Duration
+		secret  string
+		wantErr error
+	}{
+		{"new secret", time.Hour, 0, newSecret, nil},
+		{"old secret within overlap", time.Hour, 30 * time.Minute, oldSecret, nil},
+		{"old secret after overlap", time.Hour, 2 * time.Hour, oldSecret, dns.ErrSig},
+		{"old secret without overlap", 0, 0, oldSecret, dns.ErrSig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			keyring := NewKeyring(map[string]string{"test-key.": oldSecret})
+			keyring.now = func() time.Time { return now }
+			keyring.SetOverlap(tt.overlap)
+			keyring.SetSecrets(map[string]string{"test-key.": newSecret})
+			now = now.Add(tt.elapsed)
+
+			msg := new(dns.Msg)
+			msg.SetUpdate("example.com.")
+			msg.SetTsig("test-key.", dns.HmacSHA256, 300, time.Now().Unix())
+			buf, mac, err := dns.TsigGenerate(msg, tt.secret, "", false)
+			if err != nil {
+				t.Fatalf("TsigGenerate() failed: %v", err)
+			}
+			err = dns.TsigVerifyWithProvider(buf, keyring, "", false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("TsigVerifyWithProvider() = %v, want %v", err, tt.wantErr)
+			}
+
+			// The response is signed with the secret the client still uses
+			secret, ok := keyring.VerifiedSecret(mac)
+			if wantPrevious := err == nil && tt.secret == oldSecret; ok != wantPrevious || (ok && secret != oldSecret) {
+				t.Errorf("VerifiedSecret() = %q, %v, want previous %v", secret, ok, wantPrevious)
+			}
+		})
+	}
+}
+
+func TestKeyringMinAlgorithm(t *testing.T) {
+	const secret = "dGVzdC1zZWNyZXQ="
+
+	tests := []struct {
+		name      string
+		algorithm string
+		min       string
+		wantErr   error
+	}{
+		{"any algorithm", dns.HmacSHA1, "", nil},
+		{"stronger", dns.HmacSHA512, dns.HmacSHA256, nil},
+		{"minimum", dns.HmacSHA256, dns.HmacSHA256, nil},
+		{"weaker", dns.HmacSHA1, dns.HmacSHA256, dns.ErrKeyAlg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.SetUpdate("example.com.")
+			msg.SetTsig("test-key.", tt.algorithm, 300, time.Now().Unix())
+			buf, _, err := dns.TsigGenerate(msg, secret, "", false)
+			if err != nil {
+				t.Fatalf("TsigGenerate() failed: %v", err)
+			}
+
+			keyring := NewKeyring(map[string]string{"test-key.": secret})
+			keyring.SetMinAlgorithm(tt.min)
+			err = dns.TsigVerifyWithProvider(buf, keyring, "", false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("TsigVerifyWithProvider() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
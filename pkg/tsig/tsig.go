@@ -0,0 +1,48 @@
+// Package tsig classifies the outcome of the TSIG verification of requests
+package tsig
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+)
+
+// Result describes a verified signature
+type Result struct {
+	// Skew is the absolute difference between the time signed and the server time
+	Skew time.Duration
+	// Tolerated is set when the signature was made outside of its fudge but within
+	// the clock-skew tolerance
+	Tolerated bool
+}
+
+// Verify classifies the verification status the DNS server recorded for a signed
+// request, accepting signatures that only failed their time check when the clock
+// skew is within tolerance
+func Verify(status error, record *dns.TSIG, now time.Time, tolerance time.Duration) (Result, error) {
+	signed := time.Unix(int64(record.TimeSigned), 0)
+	skew := now.Sub(signed)
+	if skew < 0 {
+		skew = -skew
+	}
+	result := Result{Skew: skew}
+
+	switch {
+	case status == nil:
+		return result, nil
+	case errors.Is(status, dns.ErrTime):
+		// The signature is valid, only the time check failed
+		if skew <= tolerance {
+			result.Tolerated = true
+			return result, nil
+		}
+		return result, fmt.Errorf("%w: clock skew %s exceeds fudge %ds", dnserr.ErrTSIGBadTime, skew.Round(time.Second), record.Fudge)
+	case errors.Is(status, dns.ErrSecret), errors.Is(status, dns.ErrKeyAlg):
+		return result, fmt.Errorf("%w %s (algorithm %s): %v", dnserr.ErrTSIGKeyUnknown, record.Hdr.Name, record.Algorithm, status)
+	default:
+		return result, fmt.Errorf("%w for key %s: %v", dnserr.ErrTSIGBadSignature, record.Hdr.Name, status)
+	}
+}
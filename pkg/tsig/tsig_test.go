@@ -0,0 +1,50 @@
+package tsig
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	record := &dns.TSIG{
+		Hdr:        dns.RR_Header{Name: "test-key."},
+		Algorithm:  dns.HmacSHA256,
+		TimeSigned: uint64(now.Add(-10 * time.Minute).Unix()),
+		Fudge:      300,
+	}
+
+	tests := []struct {
+		name      string
+		status    error
+		tolerance time.Duration
+		tolerated bool
+		err       error
+	}{
+		{"verified", nil, 0, false, nil},
+		{"skew tolerated", dns.ErrTime, 15 * time.Minute, true, nil},
+		{"bad time", dns.ErrTime, 5 * time.Minute, false, dnserr.ErrTSIGBadTime},
+		{"unknown key", dns.ErrSecret, 0, false, dnserr.ErrTSIGKeyUnknown},
+		{"unknown algorithm", dns.ErrKeyAlg, 0, false, dnserr.ErrTSIGKeyUnknown},
+		{"bad signature", dns.ErrSig, 0, false, dnserr.ErrTSIGBadSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Verify(tt.status, record, now, tt.tolerance)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+			if result.Tolerated != tt.tolerated {
+				t.Errorf("Tolerated = %v, want %v", result.Tolerated, tt.tolerated)
+			}
+			if result.Skew != 10*time.Minute {
+				t.Errorf("Skew = %s, want 10m", result.Skew)
+			}
+		})
+	}
+}
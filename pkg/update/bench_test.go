@@ -0,0 +1,55 @@
+package update
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// benchmarkUpdate builds an UPDATE message replacing the A record of n names
+func benchmarkUpdate(n int) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	for i := 0; i < n; i++ {
+		rr, _ := dns.NewRR(fmt.Sprintf("host%d.example.com. 300 IN A 192.0.2.%d", i, i%254+1))
+		msg.RemoveRRset([]dns.RR{rr})
+		msg.Insert([]dns.RR{rr})
+	}
+	return msg
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, n := range []int{1, 16, 128} {
+		b.Run(fmt.Sprintf("records=%d", n), func(b *testing.B) {
+			parser := NewParser()
+			msg := benchmarkUpdate(n)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := parser.Parse(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnpackAndParse(b *testing.B) {
+	buf, err := benchmarkUpdate(16).Pack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	parser := NewParser()
+	b.ReportAllocs()
+	for b.Loop() {
+		msg := new(dns.Msg)
+		if err := msg.Unpack(buf); err != nil {
+			b.Fatal(err)
+		}
+		updates, err := parser.Parse(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		Coalesce(updates)
+	}
+}
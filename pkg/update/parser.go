@@ -3,10 +3,12 @@ package update
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	"github.com/miekg/dns"
 	"github.com/sirupsen/logrus"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
 )
 
 // UpdateType represents the type of DNS update operation
@@ -18,58 +20,182 @@ const (
 	UpdateTypeDelete
 )
 
-// DNSUpdate represents a parsed DNS update for A or AAAA records
+// DNSUpdate represents a parsed DNS update for A, AAAA, ACME challenge TXT, CNAME,
+// SRV, MX, NS, or Windows DHCP PTR and DHCID records
 type DNSUpdate struct {
 	Type       UpdateType
-	RecordType uint16 // dns.TypeA or dns.TypeAAAA
+	RecordType uint16 // dns.TypeA, dns.TypeAAAA, dns.TypeTXT, dns.TypePTR, dns.TypeDHCID, dns.TypeCNAME, dns.TypeSRV, dns.TypeMX or dns.TypeNS
 	Name       string
 	Zone       string
 	IP         net.IP
+	Text       []string // TXT strings, nil when deleting the whole TXT RRset
+	Target     string   // PTR, CNAME, SRV, MX or NS target or DHCID digest, empty when deleting the whole RRset
+	Priority   uint16   // SRV priority or MX preference
+	Weight     uint16   // SRV weight
+	Port       uint16   // SRV port
 	TTL        uint32
+	// Unreachable is set when the target failed the reachability probe but is published flagged
+	Unreachable bool
 }
 
+// acmeChallengeLabel is the first label of names holding ACME DNS-01 challenges
+const acmeChallengeLabel = "_acme-challenge."
+
 // Parser parses DNS UPDATE messages
-type Parser struct{}
+type Parser struct {
+	// ACMEChallenges accepts TXT updates of _acme-challenge names
+	ACMEChallenges bool
+	// DHCP accepts the PTR and DHCID updates sent by DHCP servers
+	DHCP bool
+	// DHCID accepts DHCID updates identifying the client owning a name
+	DHCID bool
+	// Types lists the accepted record types among those of SelectableTypes; nil
+	// accepts A and AAAA
+	Types map[uint16]bool
+}
+
+// SelectableTypes are the record types accepted through Parser.Types, by name
+var SelectableTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"SRV":   dns.TypeSRV,
+	"MX":    dns.TypeMX,
+	"NS":    dns.TypeNS,
+}
+
+// ParseTypes parses a list of record type names into Parser.Types
+func ParseTypes(names []string) (map[uint16]bool, error) {
+	types := make(map[uint16]bool, len(names))
+	for _, name := range names {
+		rrtype, ok := SelectableTypes[strings.ToUpper(name)]
+		if !ok {
+			selectable := make([]string, 0, len(SelectableTypes))
+			for s := range SelectableTypes {
+				selectable = append(selectable, s)
+			}
+			sort.Strings(selectable)
+			return nil, fmt.Errorf("unsupported record type %q, expected one of %s", name, strings.Join(selectable, ", "))
+		}
+		types[rrtype] = true
+	}
+	return types, nil
+}
+
+// accepts checks if a record type is accepted
+func (p *Parser) accepts(rrtype uint16) bool {
+	if p.Types == nil {
+		return rrtype == dns.TypeA || rrtype == dns.TypeAAAA
+	}
+	return p.Types[rrtype]
+}
 
 // NewParser creates a new DNS UPDATE parser
 func NewParser() *Parser {
 	return &Parser{}
 }
 
-// Parse parses a DNS UPDATE message and extracts A/AAAA record changes
+// Reasons a record of the update section is rejected
+const (
+	// RejectUnsupportedType is a record type the bridge never handles, e.g. CAA or HINFO
+	RejectUnsupportedType = "unsupported_type"
+	// RejectDisabledType is a record of a type whose support is not enabled
+	RejectDisabledType = "disabled_type"
+	// RejectNotChallenge is a TXT record of a name other than an ACME challenge
+	RejectNotChallenge = "not_acme_challenge"
+	// RejectCNAMEAtApex is a CNAME record of the zone apex, which holds other records
+	RejectCNAMEAtApex = "cname_at_apex"
+	// RejectNSAtApex is an NS record of the zone apex, which is delegated by the parent
+	// zone rather than by the bridge
+	RejectNSAtApex = "ns_at_apex"
+	// RejectUnsupportedClass is a record of a class other than IN, ANY or NONE
+	RejectUnsupportedClass = "unsupported_class"
+	// RejectMalformed is a record whose data does not match its type
+	RejectMalformed = "malformed"
+)
+
+// Rejection describes a record of the update section that was skipped
+type Rejection struct {
+	Name   string
+	Type   string
+	Class  string
+	Reason string
+}
+
+// String returns a string representation of the rejection
+func (r Rejection) String() string {
+	return fmt.Sprintf("%s %s %s: %s", r.Name, r.Class, r.Type, r.Reason)
+}
+
+// Parse parses a DNS UPDATE message and extracts A/AAAA record changes. A message
+// with an empty update section, such as a prerequisite-only ping, has no changes.
 func (p *Parser) Parse(msg *dns.Msg) ([]*DNSUpdate, error) {
+	updates, _, err := p.ParseReport(msg)
+	return updates, err
+}
+
+// ParseReport parses a DNS UPDATE message like Parse, and also returns the records of
+// the update section that were skipped, with the reason why
+func (p *Parser) ParseReport(msg *dns.Msg) ([]*DNSUpdate, []Rejection, error) {
 	if msg.Opcode != dns.OpcodeUpdate {
-		return nil, fmt.Errorf("not a DNS UPDATE message (opcode: %d)", msg.Opcode)
+		return nil, nil, fmt.Errorf("%w: not a DNS UPDATE message (opcode: %d)", dnserr.ErrMalformed, msg.Opcode)
 	}
 
 	if len(msg.Question) == 0 {
-		return nil, fmt.Errorf("UPDATE message has no zone section")
+		return nil, nil, fmt.Errorf("%w: UPDATE message has no zone section", dnserr.ErrMalformed)
 	}
 
 	zone := msg.Question[0].Name
 	updates := make([]*DNSUpdate, 0)
+	var rejections []Rejection
 
 	// Process the update section (actual updates from Ns section)
 	for _, rr := range msg.Ns {
-		update, err := p.parseRR(rr, zone)
-		if err != nil {
-			// Skip non-A/AAAA records silently
+		update, reason := p.parseRR(rr, zone)
+		if reason != "" {
+			header := rr.Header()
+			rejections = append(rejections, Rejection{
+				Name:   header.Name,
+				Type:   typeString(header.Rrtype),
+				Class:  classString(header.Class),
+				Reason: reason,
+			})
 			continue
 		}
-		if update != nil {
-			updates = append(updates, update)
+		updates = append(updates, update)
+	}
+
+	if len(updates) == 0 && len(msg.Ns) > 0 {
+		skipped := make([]string, 0, len(rejections))
+		for _, rejection := range rejections {
+			skipped = append(skipped, rejection.String())
 		}
+		return nil, rejections, fmt.Errorf("%w: no valid A or AAAA updates found in message (skipped %s)",
+			dnserr.ErrUnsupportedRecordType, strings.Join(skipped, ", "))
 	}
 
-	if len(updates) == 0 {
-		return nil, fmt.Errorf("no valid A or AAAA updates found in message")
+	return updates, rejections, nil
+}
+
+// typeString returns the mnemonic of a record type, or its TYPEnnn form
+func typeString(rrtype uint16) string {
+	if s, ok := dns.TypeToString[rrtype]; ok {
+		return s
 	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
 
-	return updates, nil
+// classString returns the mnemonic of a class, or its CLASSnnn form
+func classString(class uint16) string {
+	if s, ok := dns.ClassToString[class]; ok {
+		return s
+	}
+	return fmt.Sprintf("CLASS%d", class)
 }
 
-// parseRR parses a single resource record from the update section
-func (p *Parser) parseRR(rr dns.RR, zone string) (*DNSUpdate, error) {
+// parseRR parses a single resource record from the update section, or returns the
+// reason it is rejected
+func (p *Parser) parseRR(rr dns.RR, zone string) (*DNSUpdate, string) {
 	header := rr.Header()
 
 	update := &DNSUpdate{
@@ -81,12 +207,12 @@ func (p *Parser) parseRR(rr dns.RR, zone string) (*DNSUpdate, error) {
 	// Determine update type based on class and TTL
 	switch header.Class {
 	case dns.ClassANY:
-		// Class ANY with TTL 0 means delete
+		// Class ANY deletes the RRset of the type, or every RRset with type ANY
 		update.Type = UpdateTypeDelete
 		update.RecordType = header.Rrtype
 
 	case dns.ClassNONE:
-		// Class NONE means delete specific record
+		// Class NONE deletes the record with the given data from its RRset
 		update.Type = UpdateTypeDelete
 		update.RecordType = header.Rrtype
 
@@ -100,44 +226,183 @@ func (p *Parser) parseRR(rr dns.RR, zone string) (*DNSUpdate, error) {
 		}
 		update.RecordType = header.Rrtype
 	default:
-		return nil, fmt.Errorf("unsupported class: %d", header.Class)
+		return nil, RejectUnsupportedClass
 	}
 
-	// Extract IP address for A/AAAA records
+	// Extract IP address for A/AAAA records and strings for ACME challenges
 	switch header.Rrtype {
 	case dns.TypeA:
+		if !p.accepts(dns.TypeA) {
+			return nil, RejectDisabledType
+		}
 		if a, ok := rr.(*dns.A); ok {
 			update.IP = a.A
 		} else if update.Type != UpdateTypeDelete {
-			return nil, fmt.Errorf("invalid A record")
+			return nil, RejectMalformed
 		}
 
 	case dns.TypeAAAA:
+		if !p.accepts(dns.TypeAAAA) {
+			return nil, RejectDisabledType
+		}
 		if aaaa, ok := rr.(*dns.AAAA); ok {
 			update.IP = aaaa.AAAA
 		} else if update.Type != UpdateTypeDelete {
-			return nil, fmt.Errorf("invalid AAAA record")
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeTXT:
+		if !p.ACMEChallenges {
+			return nil, RejectDisabledType
+		}
+		if !IsACMEChallenge(header.Name) {
+			return nil, RejectNotChallenge
+		}
+		if txt, ok := rr.(*dns.TXT); ok {
+			update.Text = txt.Txt
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypePTR:
+		if !p.DHCP {
+			return nil, RejectDisabledType
+		}
+		if ptr, ok := rr.(*dns.PTR); ok {
+			update.Target = ptr.Ptr
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeDHCID:
+		if !p.DHCP && !p.DHCID {
+			return nil, RejectDisabledType
+		}
+		if dhcid, ok := rr.(*dns.DHCID); ok {
+			update.Target = dhcid.Digest
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeCNAME:
+		if !p.accepts(dns.TypeCNAME) {
+			return nil, RejectDisabledType
+		}
+		if strings.EqualFold(dns.Fqdn(header.Name), dns.Fqdn(zone)) {
+			return nil, RejectCNAMEAtApex
+		}
+		if cname, ok := rr.(*dns.CNAME); ok {
+			update.Target = cname.Target
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeSRV:
+		if !p.accepts(dns.TypeSRV) {
+			return nil, RejectDisabledType
+		}
+		if srv, ok := rr.(*dns.SRV); ok {
+			update.Priority, update.Weight, update.Port, update.Target = srv.Priority, srv.Weight, srv.Port, srv.Target
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeMX:
+		if !p.accepts(dns.TypeMX) {
+			return nil, RejectDisabledType
+		}
+		if mx, ok := rr.(*dns.MX); ok {
+			update.Priority, update.Target = mx.Preference, mx.Mx
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeNS:
+		if !p.accepts(dns.TypeNS) {
+			return nil, RejectDisabledType
+		}
+		if strings.EqualFold(dns.Fqdn(header.Name), dns.Fqdn(zone)) {
+			return nil, RejectNSAtApex
+		}
+		if ns, ok := rr.(*dns.NS); ok {
+			update.Target = ns.Ns
+		} else if update.Type != UpdateTypeDelete {
+			return nil, RejectMalformed
+		}
+
+	case dns.TypeANY:
+		// Only class ANY is meaningful: deleting every RRset of the name
+		if header.Class != dns.ClassANY {
+			return nil, RejectMalformed
 		}
 
 	default:
-		// Skip other record types
-		return nil, nil
+		return nil, RejectUnsupportedType
 	}
 
-	return update, nil
+	return update, ""
 }
 
-// String returns a string representation of the update
-func (u *DNSUpdate) String() string {
-	var typeStr string
-	switch u.Type {
+// Coalesce groups the updates of a message: an RRset delete superseded by an add of
+// the same name and type is dropped, the add becoming an UpdateTypeUpdate replacing the
+// RRset, and DHCID updates are moved after the records they annotate. Other adds join
+// the RRset of their name and type.
+func Coalesce(updates []*DNSUpdate) []*DNSUpdate {
+	result := make([]*DNSUpdate, 0, len(updates))
+	dhcids := make([]*DNSUpdate, 0)
+	for i, upd := range updates {
+		if upd.isRRsetDelete() && upd.RecordType != dns.TypeTXT {
+			if add := supersededBy(upd, updates[i+1:]); add != nil {
+				add.Type = UpdateTypeUpdate
+				continue
+			}
+		}
+		if upd.RecordType == dns.TypeDHCID {
+			dhcids = append(dhcids, upd)
+			continue
+		}
+		result = append(result, upd)
+	}
+	return append(result, dhcids...)
+}
+
+// isRRsetDelete checks if an update deletes a whole RRset
+func (u *DNSUpdate) isRRsetDelete() bool {
+	return u.Type == UpdateTypeDelete && u.IP == nil && u.Text == nil && u.Target == ""
+}
+
+// supersededBy returns the first later update adding a record with the same name and
+// type, or nil
+func supersededBy(upd *DNSUpdate, later []*DNSUpdate) *DNSUpdate {
+	for _, other := range later {
+		if other.Type != UpdateTypeDelete && other.RecordType == upd.RecordType && strings.EqualFold(other.Name, upd.Name) {
+			return other
+		}
+	}
+	return nil
+}
+
+// IsACMEChallenge checks if a name holds ACME DNS-01 challenges
+func IsACMEChallenge(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), acmeChallengeLabel)
+}
+
+// String returns the name of the update type
+func (t UpdateType) String() string {
+	switch t {
 	case UpdateTypeCreate:
-		typeStr = "CREATE"
+		return "CREATE"
 	case UpdateTypeUpdate:
-		typeStr = "UPDATE"
+		return "UPDATE"
 	case UpdateTypeDelete:
-		typeStr = "DELETE"
+		return "DELETE"
 	}
+	return ""
+}
+
+// String returns a string representation of the update
+func (u *DNSUpdate) String() string {
+	typeStr := u.Type.String()
 
 	var recordTypeStr string
 	switch u.RecordType {
@@ -145,8 +410,44 @@ func (u *DNSUpdate) String() string {
 		recordTypeStr = "A"
 	case dns.TypeAAAA:
 		recordTypeStr = "AAAA"
+	case dns.TypeTXT:
+		recordTypeStr = "TXT"
+	case dns.TypePTR:
+		recordTypeStr = "PTR"
+	case dns.TypeDHCID:
+		recordTypeStr = "DHCID"
+	case dns.TypeCNAME:
+		recordTypeStr = "CNAME"
+	case dns.TypeSRV:
+		recordTypeStr = "SRV"
+	case dns.TypeMX:
+		recordTypeStr = "MX"
+	case dns.TypeNS:
+		recordTypeStr = "NS"
+	case dns.TypeANY:
+		recordTypeStr = "ANY"
 	}
 
+	if u.RecordType == dns.TypeSRV && u.Target != "" {
+		msg := fmt.Sprintf("%s %s %s -> %d %d %d %s (TTL: %d)", typeStr, recordTypeStr, u.Name, u.Priority, u.Weight, u.Port, u.Target, u.TTL)
+		logrus.Debugf("Parsed DNS update: %s", msg)
+		return msg
+	}
+	if u.RecordType == dns.TypeMX && u.Target != "" {
+		msg := fmt.Sprintf("%s %s %s -> %d %s (TTL: %d)", typeStr, recordTypeStr, u.Name, u.Priority, u.Target, u.TTL)
+		logrus.Debugf("Parsed DNS update: %s", msg)
+		return msg
+	}
+	if u.Target != "" {
+		msg := fmt.Sprintf("%s %s %s -> %s (TTL: %d)", typeStr, recordTypeStr, u.Name, u.Target, u.TTL)
+		logrus.Debugf("Parsed DNS update: %s", msg)
+		return msg
+	}
+	if u.Text != nil {
+		msg := fmt.Sprintf("%s %s %s -> %q (TTL: %d)", typeStr, recordTypeStr, u.Name, u.Text, u.TTL)
+		logrus.Debugf("Parsed DNS update: %s", msg)
+		return msg
+	}
 	if u.IP != nil {
 		msg := fmt.Sprintf("%s %s %s -> %s (TTL: %d)", typeStr, recordTypeStr, u.Name, u.IP.String(), u.TTL)
 		logrus.Debugf("Parsed DNS update: %s", msg)
@@ -1,10 +1,14 @@
 package update
 
 import (
+	"errors"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/miekg/dns"
+	"github.com/tJouve/ddnsbridge4extdns/pkg/dnserr"
 )
 
 func TestParseUpdate(t *testing.T) {
@@ -176,7 +180,342 @@ func TestParseNoZone(t *testing.T) {
 	}
 
 	_, err := parser.Parse(msg)
-	if err == nil {
-		t.Error("Expected error for message without zone, got nil")
+	if !errors.Is(err, dnserr.ErrMalformed) {
+		t.Errorf("Expected ErrMalformed for message without zone, got %v", err)
+	}
+}
+
+func TestParsePrerequisiteOnly(t *testing.T) {
+	parser := NewParser()
+
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.NameUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeANY, Class: dns.ClassINET}}})
+
+	updates, err := parser.Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() of a prerequisite-only message failed: %v", err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("Expected no updates, got %d", len(updates))
+	}
+
+	// An update section holding only unsupported records is still refused
+	msg.Ns = []dns.RR{&dns.MX{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET, Ttl: 300}, Preference: 10, Mx: "mail.example.com."}}
+	if _, err := parser.Parse(msg); !errors.Is(err, dnserr.ErrUnsupportedRecordType) {
+		t.Errorf("Expected ErrUnsupportedRecordType, got %v", err)
+	}
+}
+
+func TestParseACMEChallenge(t *testing.T) {
+	txt, _ := dns.NewRR(`_acme-challenge.test.example.com. 60 IN TXT "token"`)
+
+	// The flow of the cert-manager and lego RFC2136 providers
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.RemoveRRset([]dns.RR{txt})
+	msg.Insert([]dns.RR{txt})
+
+	// TXT records are skipped unless ACME challenges are enabled
+	if _, err := NewParser().Parse(msg); !errors.Is(err, dnserr.ErrUnsupportedRecordType) {
+		t.Errorf("Expected ErrUnsupportedRecordType for TXT update without ACME challenges, got %v", err)
+	}
+
+	parser := &Parser{ACMEChallenges: true}
+	updates, err := parser.Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d", len(updates))
+	}
+	if updates[0].Type != UpdateTypeDelete || updates[0].Text != nil {
+		t.Errorf("Expected RRset delete, got %s", updates[0])
+	}
+	if updates[1].Type != UpdateTypeCreate || len(updates[1].Text) != 1 || updates[1].Text[0] != "token" {
+		t.Errorf("Expected insert of \"token\", got %s", updates[1])
+	}
+
+	// Cleanup removes the specific value
+	msg = new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Remove([]dns.RR{txt})
+	updates, err = parser.Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if updates[0].Type != UpdateTypeDelete || len(updates[0].Text) != 1 {
+		t.Errorf("Expected delete of \"token\", got %s", updates[0])
+	}
+
+	// TXT records of other names are still skipped
+	other, _ := dns.NewRR(`test.example.com. 60 IN TXT "token"`)
+	msg = new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Insert([]dns.RR{other})
+	if _, err := parser.Parse(msg); err == nil {
+		t.Error("Expected error for TXT update of another name, got nil")
+	}
+}
+
+func TestParseDHCPUpdate(t *testing.T) {
+	a, _ := dns.NewRR("host.example.com. 1200 IN A 192.168.1.10")
+	dhcid, _ := dns.NewRR("host.example.com. 1200 IN DHCID AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=")
+	ptr, _ := dns.NewRR("10.1.168.192.in-addr.arpa. 1200 IN PTR host.example.com.")
+
+	// A lease renewal replacing the address of an owned name
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.RemoveRRset([]dns.RR{a})
+	msg.Insert([]dns.RR{dhcid, a, ptr})
+
+	// PTR and DHCID records are skipped unless DHCP is enabled
+	updates, err := NewParser().Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(updates) != 2 {
+		t.Errorf("Expected 2 updates without DHCP, got %d", len(updates))
+	}
+
+	parser := &Parser{DHCP: true}
+	updates, err = parser.Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(updates) != 4 {
+		t.Fatalf("Expected 4 updates, got %d", len(updates))
+	}
+	if updates[3].RecordType != dns.TypePTR || updates[3].Target != "host.example.com." {
+		t.Errorf("Expected PTR to host.example.com., got %s", updates[3])
+	}
+
+	// The A RRset delete is dropped, the add replacing the RRset instead
+	coalesced := Coalesce(updates)
+	expected := []struct {
+		rrtype     uint16
+		updateType UpdateType
+	}{
+		{dns.TypeA, UpdateTypeUpdate},
+		{dns.TypePTR, UpdateTypeCreate},
+		{dns.TypeDHCID, UpdateTypeCreate},
+	}
+	if len(coalesced) != len(expected) {
+		t.Fatalf("Expected %d coalesced updates, got %d", len(expected), len(coalesced))
+	}
+	for i, e := range expected {
+		if coalesced[i].RecordType != e.rrtype || coalesced[i].Type != e.updateType {
+			t.Errorf("Expected %s of %s at %d, got %s", e.updateType, dns.TypeToString[e.rrtype], i, coalesced[i])
+		}
+	}
+}
+
+func TestParseCNAMEUpdate(t *testing.T) {
+	cname, _ := dns.NewRR("www.example.com. 300 IN CNAME home.dyn.example.net.")
+	apex, _ := dns.NewRR("example.com. 300 IN CNAME home.dyn.example.net.")
+
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.RemoveRRset([]dns.RR{cname})
+	msg.Insert([]dns.RR{cname, apex})
+
+	// CNAME records are skipped unless enabled
+	_, rejections, err := NewParser().ParseReport(msg)
+	if err == nil || len(rejections) != 3 || rejections[0].Reason != RejectDisabledType {
+		t.Fatalf("Expected the CNAME records to be skipped, got %v (%v)", rejections, err)
+	}
+
+	updates, rejections, err := (&Parser{Types: map[uint16]bool{dns.TypeCNAME: true}}).ParseReport(msg)
+	if err != nil {
+		t.Fatalf("ParseReport() failed: %v", err)
+	}
+	if len(rejections) != 1 || rejections[0].Reason != RejectCNAMEAtApex {
+		t.Errorf("Expected the CNAME of the apex to be skipped, got %v", rejections)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d", len(updates))
+	}
+	if updates[0].Type != UpdateTypeDelete || updates[0].RecordType != dns.TypeCNAME || updates[0].Target != "" {
+		t.Errorf("Expected the CNAME RRset delete, got %s", updates[0])
+	}
+	if updates[1].Type != UpdateTypeCreate || updates[1].Target != "home.dyn.example.net." {
+		t.Errorf("Expected a CNAME to home.dyn.example.net., got %s", updates[1])
+	}
+	if coalesced := Coalesce(updates); len(coalesced) != 1 {
+		t.Errorf("Expected the delete to be superseded by the add, got %v", coalesced)
+	}
+}
+
+func TestParseSRVUpdate(t *testing.T) {
+	srv, _ := dns.NewRR("_ldap._tcp.example.com. 600 IN SRV 0 100 389 dc1.example.com.")
+
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Insert([]dns.RR{srv})
+	msg.Remove([]dns.RR{dns.Copy(srv)})
+
+	// SRV records are skipped unless enabled
+	if _, err := NewParser().Parse(msg); !errors.Is(err, dnserr.ErrUnsupportedRecordType) {
+		t.Fatalf("Expected the SRV records to be skipped, got %v", err)
+	}
+
+	updates, err := (&Parser{Types: map[uint16]bool{dns.TypeSRV: true}}).Parse(msg)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d", len(updates))
+	}
+	for i, expected := range []UpdateType{UpdateTypeCreate, UpdateTypeDelete} {
+		upd := updates[i]
+		if upd.Type != expected || upd.RecordType != dns.TypeSRV || upd.Priority != 0 || upd.Weight != 100 || upd.Port != 389 || upd.Target != "dc1.example.com." {
+			t.Errorf("Unexpected update %d: %s", i, upd)
+		}
+	}
+	if got := updates[0].String(); got != "CREATE SRV _ldap._tcp.example.com. -> 0 100 389 dc1.example.com. (TTL: 600)" {
+		t.Errorf("Unexpected String() %q", got)
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	a, _ := dns.NewRR("host.example.com. 300 IN A 192.168.1.10")
+	caa, _ := dns.NewRR(`example.com. 300 IN CAA 0 issue "letsencrypt.org"`)
+	mx, _ := dns.NewRR("example.com. 300 IN MX 10 mail.example.com.")
+	txt, _ := dns.NewRR(`host.example.com. 300 IN TXT "hello"`)
+	challenge, _ := dns.NewRR(`_acme-challenge.example.com. 60 IN TXT "token"`)
+	ptr, _ := dns.NewRR("10.1.168.192.in-addr.arpa. 300 IN PTR host.example.com.")
+	chaos := &dns.A{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeA, Class: dns.ClassCHAOS, Ttl: 300}, A: net.ParseIP("192.168.1.10")}
+	// An A record whose data could not be decoded
+	unknown := &dns.RFC3597{Hdr: dns.RR_Header{Name: "bad.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, Rdata: "00"}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Ns = []dns.RR{a, caa, mx, txt, challenge, ptr, chaos, unknown}
+
+	updates, rejections, err := (&Parser{ACMEChallenges: true}).ParseReport(msg)
+	if err != nil {
+		t.Fatalf("ParseReport() failed: %v", err)
+	}
+	if len(updates) != 2 {
+		t.Errorf("Expected the A record and the challenge, got %d updates", len(updates))
+	}
+	want := []Rejection{
+		{"example.com.", "CAA", "IN", RejectUnsupportedType},
+		{"example.com.", "MX", "IN", RejectDisabledType},
+		{"host.example.com.", "TXT", "IN", RejectNotChallenge},
+		{"10.1.168.192.in-addr.arpa.", "PTR", "IN", RejectDisabledType},
+		{"host.example.com.", "A", "CH", RejectUnsupportedClass},
+		{"bad.example.com.", "A", "IN", RejectMalformed},
+	}
+	if len(rejections) != len(want) {
+		t.Fatalf("Expected %d rejections, got %v", len(want), rejections)
+	}
+	for i := range want {
+		if rejections[i] != want[i] {
+			t.Errorf("rejection %d = %v, want %v", i, rejections[i], want[i])
+		}
+	}
+
+	// A message without any valid record names the skipped ones in its error
+	msg.Ns = []dns.RR{mx, challenge}
+	_, rejections, err = NewParser().ParseReport(msg)
+	if !errors.Is(err, dnserr.ErrUnsupportedRecordType) || len(rejections) != 2 {
+		t.Fatalf("Expected ErrUnsupportedRecordType with 2 rejections, got %v (%v)", err, rejections)
+	}
+	if !strings.Contains(err.Error(), "_acme-challenge.example.com. IN TXT: disabled_type") {
+		t.Errorf("Expected the error to name the skipped records, got %v", err)
+	}
+}
+
+func TestParseMXAndNSUpdates(t *testing.T) {
+	mx, _ := dns.NewRR("example.com. 300 IN MX 10 mail.example.com.")
+	ns, _ := dns.NewRR("lab.example.com. 3600 IN NS ns1.lab.example.com.")
+	apexNS, _ := dns.NewRR("example.com. 3600 IN NS ns1.example.net.")
+	a, _ := dns.NewRR("host.example.com. 300 IN A 192.0.2.10")
+
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Insert([]dns.RR{mx, ns, apexNS, a})
+
+	types, err := ParseTypes([]string{"mx", "NS"})
+	if err != nil {
+		t.Fatalf("ParseTypes() failed: %v", err)
+	}
+	updates, rejections, err := (&Parser{Types: types}).ParseReport(msg)
+	if err != nil {
+		t.Fatalf("ParseReport() failed: %v", err)
+	}
+	// A is not listed, and the NS records of the apex are delegated by the parent zone
+	want := []Rejection{
+		{"example.com.", "NS", "IN", RejectNSAtApex},
+		{"host.example.com.", "A", "IN", RejectDisabledType},
+	}
+	if !reflect.DeepEqual(rejections, want) {
+		t.Errorf("Expected rejections %v, got %v", want, rejections)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d", len(updates))
+	}
+	if updates[0].RecordType != dns.TypeMX || updates[0].Priority != 10 || updates[0].Target != "mail.example.com." {
+		t.Errorf("Expected the MX record, got %s", updates[0])
+	}
+	if updates[1].RecordType != dns.TypeNS || updates[1].Target != "ns1.lab.example.com." {
+		t.Errorf("Expected the NS record, got %s", updates[1])
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		types     []string
+		shouldErr bool
+	}{
+		{"address records", []string{"A", "AAAA"}, false},
+		{"case insensitive", []string{"cname", "Srv"}, false},
+		{"empty", []string{}, false},
+		{"unsupported", []string{"A", "CAA"}, true},
+		{"feature type", []string{"TXT"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTypes(tt.types)
+			if (err != nil) != tt.shouldErr {
+				t.Errorf("ParseTypes() error = %v, shouldErr %v", err, tt.shouldErr)
+			}
+		})
+	}
+}
+
+func TestParseDeleteClasses(t *testing.T) {
+	a, _ := dns.NewRR("host.example.com. 300 IN A 192.0.2.10")
+	anyRRsets := &dns.ANY{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeANY, Class: dns.ClassANY}}
+	anyIN := &dns.ANY{Hdr: dns.RR_Header{Name: "host.example.com.", Rrtype: dns.TypeANY, Class: dns.ClassINET}}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.Remove([]dns.RR{dns.Copy(a)})
+	msg.RemoveRRset([]dns.RR{a})
+	msg.Ns = append(msg.Ns, anyRRsets, anyIN)
+
+	updates, rejections, err := NewParser().ParseReport(msg)
+	if err != nil {
+		t.Fatalf("ParseReport() failed: %v", err)
+	}
+	if len(rejections) != 1 || rejections[0].Reason != RejectMalformed {
+		t.Errorf("Expected type ANY of class IN to be malformed, got %v", rejections)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("Expected 3 updates, got %d", len(updates))
+	}
+	// Class NONE deletes a single record, class ANY an RRset, or every RRset with type ANY
+	if updates[0].Type != UpdateTypeDelete || !updates[0].IP.Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("Expected the delete of a single record, got %s", updates[0])
+	}
+	if updates[1].Type != UpdateTypeDelete || updates[1].RecordType != dns.TypeA || updates[1].IP != nil {
+		t.Errorf("Expected the delete of the A RRset, got %s", updates[1])
+	}
+	if updates[2].Type != UpdateTypeDelete || updates[2].RecordType != dns.TypeANY {
+		t.Errorf("Expected the delete of every RRset, got %s", updates[2])
 	}
 }
@@ -0,0 +1,146 @@
+package zoneauth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+)
+
+// Authorizer decides if updates of a zone are accepted, given the TSIG key
+// authenticating them (empty when unsigned or authenticated otherwise)
+type Authorizer interface {
+	Allows(zone, key string) bool
+}
+
+// Func adapts a function to an Authorizer
+type Func func(zone, key string) bool
+
+// Allows calls f
+func (f Func) Allows(zone, key string) bool {
+	return f(zone, key)
+}
+
+// anyOf allows a zone when one of its authorizers does
+type anyOf []Authorizer
+
+// Any combines authorizers: a zone is allowed when one of them allows it.
+// Nil authorizers are ignored.
+func Any(authorizers ...Authorizer) Authorizer {
+	combined := make(anyOf, 0, len(authorizers))
+	for _, a := range authorizers {
+		if a != nil {
+			combined = append(combined, a)
+		}
+	}
+	return combined
+}
+
+// Allows checks the authorizers in order
+func (a anyOf) Allows(zone, key string) bool {
+	for _, authorizer := range a {
+		if authorizer.Allows(zone, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// static allows a fixed list of zones
+type static struct {
+	zones  []string
+	suffix bool
+}
+
+// Static allows the zones of a list, and the zones below them with suffix
+func Static(zones []string, suffix bool) Authorizer {
+	normalized := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		normalized = append(normalized, normalizeZone(zone))
+	}
+	return static{zones: normalized, suffix: suffix}
+}
+
+// Allows checks if the zone is listed, whatever the key
+func (s static) Allows(zone, _ string) bool {
+	zone = normalizeZone(zone)
+	for _, allowed := range s.zones {
+		if zone == allowed || (s.suffix && strings.HasSuffix(zone, "."+allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// patterns allows the zones matching wildcard patterns, split in labels
+type patterns [][]string
+
+// Patterns allows the zones matching wildcard patterns, whose labels are matched
+// one by one with path.Match: "*.lab.example.com" matches "a.lab.example.com"
+// but not "a.b.lab.example.com", and "dev-*.example.com" matches "dev-1.example.com"
+func Patterns(list []string) (Authorizer, error) {
+	compiled := make(patterns, 0, len(list))
+	for _, pattern := range list {
+		labels := strings.Split(strings.TrimSuffix(strings.ToLower(pattern), "."), ".")
+		for _, label := range labels {
+			if label == "" {
+				return nil, fmt.Errorf("invalid zone pattern %q: empty label", pattern)
+			}
+			if _, err := path.Match(label, ""); err != nil {
+				return nil, fmt.Errorf("invalid zone pattern %q: %w", pattern, err)
+			}
+		}
+		compiled = append(compiled, labels)
+	}
+	return compiled, nil
+}
+
+// Allows checks if the zone matches one of the patterns, whatever the key
+func (p patterns) Allows(zone, _ string) bool {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(zone), "."), ".")
+	for _, pattern := range p {
+		if matchLabels(pattern, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels checks if the labels of a zone match the labels of a pattern
+func matchLabels(pattern, labels []string) bool {
+	if len(pattern) != len(labels) {
+		return false
+	}
+	for i := range pattern {
+		if ok, _ := path.Match(pattern[i], labels[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// keyACL allows each key the zones of its ACL entry
+type keyACL struct {
+	acl acl.ACL
+}
+
+// KeyACL allows the zones a key is granted by an ACL from key names to zone
+// patterns, in the format of acl.New
+func KeyACL(a acl.ACL) Authorizer {
+	return keyACL{acl: a}
+}
+
+// Allows checks if the ACL grants the zone to the key
+func (k keyACL) Allows(zone, key string) bool {
+	return key != "" && k.acl.Allows(key, zone)
+}
+
+// normalizeZone lowercases a zone and ensures it ends with a dot
+func normalizeZone(zone string) string {
+	zone = strings.ToLower(zone)
+	if !strings.HasSuffix(zone, ".") {
+		zone = zone + "."
+	}
+	return zone
+}
@@ -0,0 +1,113 @@
+package zoneauth
+
+import (
+	"testing"
+
+	"github.com/tJouve/ddnsbridge4extdns/pkg/acl"
+)
+
+func TestStatic(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffix   bool
+		zone     string
+		expected bool
+	}{
+		{"listed zone", false, "example.com.", true},
+		{"case and trailing dot", false, "EXAMPLE.com", true},
+		{"subzone strict", false, "lab.example.com.", false},
+		{"subzone suffix", true, "lab.example.com.", true},
+		{"other zone", true, "example.org.", false},
+		{"lookalike zone", true, "badexample.com.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Static([]string{"Example.com"}, tt.suffix)
+			if got := a.Allows(tt.zone, ""); got != tt.expected {
+				t.Errorf("Allows(%s) = %v, want %v", tt.zone, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	a, err := Patterns([]string{"*.lab.example.com", "dev-*.example.org."})
+	if err != nil {
+		t.Fatalf("Patterns() failed: %v", err)
+	}
+
+	tests := []struct {
+		zone     string
+		expected bool
+	}{
+		{"team1.lab.example.com.", true},
+		{"Team1.Lab.Example.com", true},
+		{"lab.example.com.", false},
+		{"a.b.lab.example.com.", false},
+		{"dev-42.example.org.", true},
+		{"prod-42.example.org.", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			if got := a.Allows(tt.zone, "key"); got != tt.expected {
+				t.Errorf("Allows(%s) = %v, want %v", tt.zone, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPatternsErrors(t *testing.T) {
+	for _, pattern := range []string{"lab..example.com", "[a.example.com"} {
+		if _, err := Patterns([]string{pattern}); err == nil {
+			t.Errorf("Patterns(%q): expected error, got nil", pattern)
+		}
+	}
+}
+
+func TestKeyACL(t *testing.T) {
+	a := KeyACL(acl.New(map[string][]string{
+		"lab-key": {"lab.example.com"},
+	}))
+
+	tests := []struct {
+		name     string
+		zone     string
+		key      string
+		expected bool
+	}{
+		{"granted zone", "lab.example.com.", "lab-key.", true},
+		{"zone below grant", "team.lab.example.com.", "lab-key", true},
+		{"other zone", "example.com.", "lab-key", false},
+		{"unknown key", "lab.example.com.", "other-key", false},
+		{"no key", "lab.example.com.", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.Allows(tt.zone, tt.key); got != tt.expected {
+				t.Errorf("Allows(%s, %s) = %v, want %v", tt.zone, tt.key, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAny(t *testing.T) {
+	labOnly := Func(func(zone, key string) bool { return zone == "lab.example.com." })
+	keyOnly := Func(func(zone, key string) bool { return key == "admin" })
+	a := Any(labOnly, nil, keyOnly)
+
+	if !a.Allows("lab.example.com.", "") {
+		t.Error("Expected zone allowed by the first authorizer")
+	}
+	if !a.Allows("example.com.", "admin") {
+		t.Error("Expected zone allowed by the last authorizer")
+	}
+	if a.Allows("example.com.", "") {
+		t.Error("Expected zone refused by every authorizer")
+	}
+	if Any().Allows("example.com.", "admin") {
+		t.Error("Expected empty combination to refuse every zone")
+	}
+}
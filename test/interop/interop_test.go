@@ -0,0 +1,286 @@
+//go:build interop
+
+// Package interop drives the bridge with real RFC 2136 clients running in
+// containers: nsupdate (BIND), knsupdate (Knot) and the ExternalDNS rfc2136
+// provider. It needs docker and a cluster with the DNSEndpoint CRD reachable
+// through KUBECONFIG; see "Interop Tests" in the README.
+package interop
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	zone    = "interop.test."
+	keyName = "interop-key."
+)
+
+// algorithms are the TSIG algorithms every client is run with
+var algorithms = []string{"hmac-sha1", "hmac-sha256", "hmac-sha512"}
+
+var endpointGVR = schema.GroupVersionResource{
+	Group:    "externaldns.k8s.io",
+	Version:  "v1alpha1",
+	Resource: "dnsendpoints",
+}
+
+// bridge is a running bridge process
+type bridge struct {
+	port   int
+	secret string
+	alg    string
+	log    *bytes.Buffer
+}
+
+func TestInterop(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is required")
+	}
+	endpoints := endpointClient(t)
+	binary := buildBridge(t)
+
+	for _, alg := range algorithms {
+		t.Run(alg, func(t *testing.T) {
+			b := startBridge(t, binary, alg)
+			t.Run("nsupdate", func(t *testing.T) { testNsupdate(t, b, endpoints) })
+			t.Run("knsupdate", func(t *testing.T) { testKnsupdate(t, b, endpoints) })
+			t.Run("external-dns", func(t *testing.T) { testExternalDNS(t, b) })
+			if t.Failed() {
+				t.Logf("bridge log:\n%s", b.log.String())
+			}
+		})
+	}
+}
+
+// testNsupdate adds two names in one nsupdate session over TCP, then deletes them.
+// nsupdate fails when a response signature does not verify against its request.
+func testNsupdate(t *testing.T, b *bridge, endpoints dynamic.ResourceInterface) {
+	host := "bind-" + strings.TrimPrefix(b.alg, "hmac-")
+	script := fmt.Sprintf(`server 127.0.0.1 %d
+zone %s
+update add %s.%s 300 A 192.0.2.1
+send
+update add %s-2.%s 300 A 192.0.2.2
+send
+`, b.port, zone, host, zone, host, zone)
+	runClient(t, getEnv("INTEROP_BIND_IMAGE", "internetsystemsconsortium/bind9:9.20"), script,
+		"--entrypoint", "nsupdate", "-v", "-y", b.alg+":"+keyName+":"+b.secret)
+	waitEndpoint(t, endpoints, host+"."+zone, "192.0.2.1")
+	waitEndpoint(t, endpoints, host+"-2."+zone, "192.0.2.2")
+
+	script = fmt.Sprintf(`server 127.0.0.1 %d
+zone %s
+update delete %s.%s A
+update delete %s-2.%s A
+send
+`, b.port, zone, host, zone, host, zone)
+	runClient(t, getEnv("INTEROP_BIND_IMAGE", "internetsystemsconsortium/bind9:9.20"), script,
+		"--entrypoint", "nsupdate", "-y", b.alg+":"+keyName+":"+b.secret)
+	waitEndpoint(t, endpoints, host+"."+zone, "")
+	waitEndpoint(t, endpoints, host+"-2."+zone, "")
+}
+
+// testKnsupdate adds then replaces a name with knsupdate, which also verifies
+// the signature of every response
+func testKnsupdate(t *testing.T, b *bridge, endpoints dynamic.ResourceInterface) {
+	host := "knot-" + strings.TrimPrefix(b.alg, "hmac-")
+	script := fmt.Sprintf(`server 127.0.0.1 %d
+zone %s
+origin %s
+update add %s 300 A 192.0.2.10
+send
+update delete %s A
+update add %s 300 A 192.0.2.11
+send
+quit
+`, b.port, zone, zone, host, host, host)
+	runClient(t, getEnv("INTEROP_KNOT_IMAGE", "cznic/knot:latest"), script,
+		"--entrypoint", "knsupdate", "-y", b.alg+":"+keyName+":"+b.secret)
+	waitEndpoint(t, endpoints, host+"."+zone, "192.0.2.11")
+}
+
+// testExternalDNS runs the ExternalDNS rfc2136 provider once. ExternalDNS reads the
+// zone with a signed AXFR before updating it, which the bridge does not serve: the
+// run must fail on the refused transfer, not on the TSIG of the answer.
+func testExternalDNS(t *testing.T, b *bridge) {
+	args := []string{
+		"run", "--rm", "--network", "host",
+		getEnv("INTEROP_EXTERNALDNS_IMAGE", "registry.k8s.io/external-dns/external-dns:v0.15.1"),
+		"--once", "--source=fake", "--fqdn-template=external-dns." + strings.TrimSuffix(zone, "."),
+		"--registry=noop", "--provider=rfc2136",
+		"--rfc2136-host=127.0.0.1", fmt.Sprintf("--rfc2136-port=%d", b.port),
+		"--rfc2136-zone=" + zone, "--rfc2136-tsig-axfr",
+		"--rfc2136-tsig-keyname=" + keyName, "--rfc2136-tsig-secret=" + b.secret,
+		"--rfc2136-tsig-secret-alg=" + b.alg,
+	}
+	output, _ := exec.Command("docker", args...).CombinedOutput()
+	if bytes.Contains(output, []byte("bad signature")) || bytes.Contains(output, []byte("BADSIG")) {
+		t.Fatalf("ExternalDNS rejected the TSIG of the bridge:\n%s", output)
+	}
+	if !bytes.Contains(output, []byte("bad xfr rcode")) {
+		t.Fatalf("Expected ExternalDNS to fail on the refused transfer:\n%s", output)
+	}
+}
+
+// buildBridge builds the server binary
+func buildBridge(t *testing.T) string {
+	binary := filepath.Join(t.TempDir(), "ddnsbridge4extdns")
+	cmd := exec.Command("go", "build", "-o", binary, "../../cmd/server")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build the bridge: %v\n%s", err, output)
+	}
+	return binary
+}
+
+// startBridge starts the bridge with a fresh key of an algorithm, and waits until it answers
+func startBridge(t *testing.T, binary, alg string) *bridge {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatalf("Failed to generate TSIG secret: %v", err)
+	}
+	b := &bridge{port: freePort(t), secret: base64.StdEncoding.EncodeToString(secret), alg: alg, log: &bytes.Buffer{}}
+
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(),
+		"LISTEN_ADDR=127.0.0.1",
+		fmt.Sprintf("PORT=%d", b.port),
+		"TSIG_KEY="+keyName,
+		"TSIG_SECRET="+b.secret,
+		"TSIG_ALGORITHM="+alg,
+		"ALLOWED_ZONES="+zone,
+		"NAMESPACE="+namespace(),
+		"LOG_LEVEL=debug",
+	)
+	cmd.Stdout, cmd.Stderr = b.log, b.log
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the bridge: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	client := &dns.Client{Net: "tcp", Timeout: time.Second}
+	query := new(dns.Msg).SetQuestion(zone, dns.TypeSOA)
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		if _, _, err := client.Exchange(query, fmt.Sprintf("127.0.0.1:%d", b.port)); err == nil {
+			return b
+		}
+	}
+	t.Fatalf("Bridge did not start:\n%s", b.log.String())
+	return nil
+}
+
+// runClient runs a containerized client on the host network, feeding it a script
+func runClient(t *testing.T, image, script string, args ...string) {
+	dockerArgs := []string{"run", "--rm", "-i", "--network", "host"}
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		dockerArgs = append(dockerArgs, args[0], args[1])
+		args = args[2:]
+	}
+	dockerArgs = append(dockerArgs, image)
+	dockerArgs = append(dockerArgs, args...)
+
+	cmd := exec.Command("docker", dockerArgs...)
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Client failed: %v\n%s", err, output)
+	}
+}
+
+// waitEndpoint waits until a DNSEndpoint publishes a name with a target, or no
+// longer publishes it when target is empty
+func waitEndpoint(t *testing.T, endpoints dynamic.ResourceInterface, name, target string) {
+	var targets []string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		targets = publishedTargets(t, endpoints, name)
+		if target == "" && len(targets) == 0 {
+			return
+		}
+		for _, published := range targets {
+			if published == target {
+				return
+			}
+		}
+	}
+	t.Fatalf("Expected %s to publish %q, got %v", name, target, targets)
+}
+
+// publishedTargets returns the targets of a name in the DNSEndpoints of the bridge
+func publishedTargets(t *testing.T, endpoints dynamic.ResourceInterface, name string) []string {
+	list, err := endpoints.List(context.Background(), metav1.ListOptions{
+		LabelSelector: "app.kubernetes.io/managed-by=ddnsbridge4extdns",
+	})
+	if err != nil {
+		t.Fatalf("Failed to list DNSEndpoints: %v", err)
+	}
+	var targets []string
+	for _, item := range list.Items {
+		entries, _, _ := unstructured.NestedSlice(item.Object, "spec", "endpoints")
+		for _, entry := range entries {
+			fields, _ := entry.(map[string]interface{})
+			dnsName, _ := fields["dnsName"].(string)
+			if strings.TrimSuffix(dnsName, ".") != strings.TrimSuffix(name, ".") {
+				continue
+			}
+			values, _, _ := unstructured.NestedStringSlice(fields, "targets")
+			targets = append(targets, values...)
+		}
+	}
+	return targets
+}
+
+// endpointClient returns the DNSEndpoints of the test namespace
+func endpointClient(t *testing.T) dynamic.ResourceInterface {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		t.Skipf("A cluster is required: %v", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+	return client.Resource(endpointGVR).Namespace(namespace())
+}
+
+// freePort returns a free TCP port, also used for UDP
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// namespace returns the namespace the bridge writes to
+func namespace() string {
+	return getEnv("INTEROP_NAMESPACE", "default")
+}
+
+// getEnv returns the value of an environment variable, or a default
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}